@@ -0,0 +1,336 @@
+package applib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// APIManifestPath is the route on which every application exports the
+// manifest of the endpoints registered with HandleAPI. The genclient tool in
+// clients/go/cmd/genclient reads it to generate typed API clients.
+const APIManifestPath = "/internal/api-manifest"
+
+// APIManifest describes an application's API endpoints and the types they
+// exchange. Endpoints and types are sorted so the manifest is stable.
+type APIManifest struct {
+	Endpoints []APIEndpoint `json:"endpoints"`
+	Types     []APIType     `json:"types"`
+}
+
+// APIEndpoint describes one registered endpoint.
+type APIEndpoint struct {
+	Name     string      `json:"name"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Request  *APITypeRef `json:"request,omitempty"`
+	Response *APITypeRef `json:"response,omitempty"`
+	// DataView marks endpoints that serve data views refreshed on events,
+	// for which clients generate DataProvider constructors.
+	DataView bool `json:"dataView,omitempty"`
+}
+
+// APIType describes a named JSON object type.
+type APIType struct {
+	Name   string     `json:"name"`
+	Fields []APIField `json:"fields"`
+}
+
+// APIField describes one member of an APIType.
+type APIField struct {
+	Name     string     `json:"name"`
+	JSON     string     `json:"json"`
+	Type     APITypeRef `json:"type"`
+	Optional bool       `json:"optional,omitempty"`
+}
+
+// APITypeRef refers to a type in the manifest. Kind is one of string, int,
+// float, bool, time, bytes, any, array, map or object; objects are named by
+// Name and described in APIManifest.Types, arrays and maps by Elem.
+type APITypeRef struct {
+	Kind string      `json:"kind"`
+	Name string      `json:"name,omitempty"`
+	Elem *APITypeRef `json:"elem,omitempty"`
+}
+
+type endpointConfig struct {
+	name     string
+	method   string
+	request  reflect.Type
+	response reflect.Type
+	dataView bool
+}
+
+// EndpointOption describes an endpoint registered with HandleAPI.
+type EndpointOption func(*endpointConfig)
+
+// WithName sets the name generated clients use for the endpoint, e.g.
+// "ListUsers". By default it is derived from the path.
+func WithName(name string) EndpointOption {
+	return func(c *endpointConfig) {
+		c.name = name
+	}
+}
+
+// WithMethod sets the HTTP method of the endpoint. The default is GET, or
+// POST if a request type is given.
+func WithMethod(method string) EndpointOption {
+	return func(c *endpointConfig) {
+		c.method = method
+	}
+}
+
+// WithRequest records the type of the endpoint's JSON request body, given as
+// an example value such as MyRequest{}.
+func WithRequest(example any) EndpointOption {
+	return func(c *endpointConfig) {
+		c.request = reflect.TypeOf(example)
+	}
+}
+
+// WithResponse records the type of the endpoint's JSON response, given as an
+// example value such as UsersData{}.
+func WithResponse(example any) EndpointOption {
+	return func(c *endpointConfig) {
+		c.response = reflect.TypeOf(example)
+	}
+}
+
+// AsDataView marks the endpoint as a data view, refetched by clients when
+// the application processes new events.
+func AsDataView() EndpointOption {
+	return func(c *endpointConfig) {
+		c.dataView = true
+	}
+}
+
+// HandleAPI registers handler for pattern on the default serve mux, like
+// http.HandleFunc, and records the endpoint in the application's API
+// manifest. Like http.HandleFunc it panics on invalid registrations, here
+// including two different Go types with the same name.
+func HandleAPI(pattern string, handler http.HandlerFunc, opts ...EndpointOption) {
+	defaultAPIRegistry.add(pattern, opts)
+	http.HandleFunc(pattern, handler)
+}
+
+var (
+	defaultAPIRegistry     = newAPIRegistry()
+	registerAPIManifestRun sync.Once
+)
+
+// registerAPIManifest serves the manifest of the default registry. It is
+// called by NewApplication.
+func registerAPIManifest() {
+	registerAPIManifestRun.Do(func() {
+		http.Handle(APIManifestPath, defaultAPIRegistry)
+	})
+}
+
+type apiRegistry struct {
+	mu        sync.Mutex
+	endpoints []APIEndpoint
+	types     map[string]APIType
+	goTypes   map[string]reflect.Type
+}
+
+func newAPIRegistry() *apiRegistry {
+	return &apiRegistry{
+		types:   make(map[string]APIType),
+		goTypes: make(map[string]reflect.Type),
+	}
+}
+
+func (r *apiRegistry) add(pattern string, opts []EndpointOption) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := pattern
+	config := endpointConfig{}
+	if method, rest, ok := strings.Cut(pattern, " "); ok {
+		config.method = method
+		path = strings.TrimSpace(rest)
+	}
+	config.name = nameFromPath(path)
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	endpoint := APIEndpoint{
+		Name:     config.name,
+		Method:   config.method,
+		Path:     path,
+		Request:  r.typeRef(config.request, config.name+"Request"),
+		Response: r.typeRef(config.response, config.name+"Response"),
+		DataView: config.dataView,
+	}
+	if endpoint.Method == "" {
+		endpoint.Method = http.MethodGet
+		if endpoint.Request != nil {
+			endpoint.Method = http.MethodPost
+		}
+	}
+	r.endpoints = append(r.endpoints, endpoint)
+}
+
+// Manifest returns the registered endpoints and types, sorted.
+func (r *apiRegistry) Manifest() APIManifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	manifest := APIManifest{
+		Endpoints: append([]APIEndpoint{}, r.endpoints...),
+		Types:     make([]APIType, 0, len(r.types)),
+	}
+	sort.Slice(manifest.Endpoints, func(i, j int) bool {
+		a, b := manifest.Endpoints[i], manifest.Endpoints[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	for _, t := range r.types {
+		manifest.Types = append(manifest.Types, t)
+	}
+	sort.Slice(manifest.Types, func(i, j int) bool {
+		return manifest.Types[i].Name < manifest.Types[j].Name
+	})
+	return manifest
+}
+
+func (r *apiRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := json.MarshalIndent(r.Manifest(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	jsonRawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// typeRef describes t, recording any object types it uses. Unnamed structs
+// are given the suggested name.
+func (r *apiRegistry) typeRef(t reflect.Type, name string) *APITypeRef {
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &APITypeRef{Kind: "time"}
+	case t == jsonRawMessageType:
+		return &APITypeRef{Kind: "any"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &APITypeRef{Kind: "string"}
+	case reflect.Bool:
+		return &APITypeRef{Kind: "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &APITypeRef{Kind: "int"}
+	case reflect.Float32, reflect.Float64:
+		return &APITypeRef{Kind: "float"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &APITypeRef{Kind: "bytes"}
+		}
+		return &APITypeRef{Kind: "array", Elem: r.typeRef(t.Elem(), name+"Item")}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			panic(fmt.Sprintf("applib: API type %s has a non-string map key", t))
+		}
+		return &APITypeRef{Kind: "map", Elem: r.typeRef(t.Elem(), name+"Value")}
+	case reflect.Struct:
+		if t.Name() != "" {
+			name = t.Name()
+		}
+		r.addObject(t, name)
+		return &APITypeRef{Kind: "object", Name: name}
+	default:
+		return &APITypeRef{Kind: "any"}
+	}
+}
+
+func (r *apiRegistry) addObject(t reflect.Type, name string) {
+	if existing, ok := r.goTypes[name]; ok {
+		if existing != t {
+			panic(fmt.Sprintf("applib: API types %s and %s are both named %s", existing, t, name))
+		}
+		return
+	}
+	// Register before describing the fields so recursive types terminate
+	r.goTypes[name] = t
+	r.types[name] = APIType{Name: name, Fields: r.objectFields(t, name)}
+}
+
+// objectFields lists the JSON members of a struct, following encoding/json:
+// unexported and "-" fields are skipped and embedded structs are flattened.
+func (r *apiRegistry) objectFields(t reflect.Type, name string) []APIField {
+	fields := []APIField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		jsonName, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && jsonName == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, r.objectFields(embedded, name)...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		fields = append(fields, APIField{
+			Name:     field.Name,
+			JSON:     jsonName,
+			Type:     *r.typeRef(field.Type, name+field.Name),
+			Optional: field.Type.Kind() == reflect.Pointer || strings.Contains(options, "omitempty"),
+		})
+	}
+	return fields
+}
+
+// nameFromPath derives an endpoint name from its path, e.g. "/api/users"
+// becomes "Users" and "/api/hash_password" becomes "HashPassword".
+func nameFromPath(path string) string {
+	path = strings.TrimPrefix(path, "/api/")
+	var name strings.Builder
+	upper := true
+	for _, c := range path {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		name.WriteRune(c)
+	}
+	return name.String()
+}
@@ -0,0 +1,102 @@
+package applib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type manifestTestUser struct {
+	ID       int       `json:"id"`
+	Username string    `json:"username"`
+	Password string    `json:"-"`
+	Created  time.Time `json:"created"`
+	Nickname *string   `json:"nickname"`
+	internal int
+}
+
+type manifestTestUsers struct {
+	Users []manifestTestUser `json:"users"`
+	Total int                `json:"total,omitempty"`
+}
+
+func TestAPIRegistryManifest(t *testing.T) {
+	registry := newAPIRegistry()
+	registry.add("/api/users", []EndpointOption{WithName("ListUsers"), WithResponse(manifestTestUsers{}), AsDataView()})
+	registry.add("/api/hash_password", []EndpointOption{WithRequest(""), WithResponse(struct {
+		Salt string `json:"salt"`
+	}{})})
+	registry.add("DELETE /api/users/{id}", nil)
+
+	manifest := registry.Manifest()
+
+	expectedEndpoints := []APIEndpoint{
+		{Name: "HashPassword", Method: "POST", Path: "/api/hash_password",
+			Request:  &APITypeRef{Kind: "string"},
+			Response: &APITypeRef{Kind: "object", Name: "HashPasswordResponse"}},
+		{Name: "ListUsers", Method: "GET", Path: "/api/users",
+			Response: &APITypeRef{Kind: "object", Name: "manifestTestUsers"}, DataView: true},
+		{Name: "UsersId", Method: "DELETE", Path: "/api/users/{id}"},
+	}
+	if !reflect.DeepEqual(manifest.Endpoints, expectedEndpoints) {
+		t.Errorf("Unexpected endpoints:\n%+v", manifest.Endpoints)
+	}
+
+	expectedTypes := []APIType{
+		{Name: "HashPasswordResponse", Fields: []APIField{
+			{Name: "Salt", JSON: "salt", Type: APITypeRef{Kind: "string"}},
+		}},
+		{Name: "manifestTestUser", Fields: []APIField{
+			{Name: "ID", JSON: "id", Type: APITypeRef{Kind: "int"}},
+			{Name: "Username", JSON: "username", Type: APITypeRef{Kind: "string"}},
+			{Name: "Created", JSON: "created", Type: APITypeRef{Kind: "time"}},
+			{Name: "Nickname", JSON: "nickname", Type: APITypeRef{Kind: "string"}, Optional: true},
+		}},
+		{Name: "manifestTestUsers", Fields: []APIField{
+			{Name: "Users", JSON: "users", Type: APITypeRef{Kind: "array", Elem: &APITypeRef{Kind: "object", Name: "manifestTestUser"}}},
+			{Name: "Total", JSON: "total", Type: APITypeRef{Kind: "int"}, Optional: true},
+		}},
+	}
+	if !reflect.DeepEqual(manifest.Types, expectedTypes) {
+		t.Errorf("Unexpected types:\n%+v", manifest.Types)
+	}
+}
+
+func TestAPIRegistryRejectsConflictingNames(t *testing.T) {
+	registry := newAPIRegistry()
+	registry.add("/api/a", []EndpointOption{WithResponse(struct{ A int }{}), WithName("Thing")})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for two different types with the same name")
+		}
+	}()
+	registry.add("/api/b", []EndpointOption{WithName("Thing"), WithResponse(struct{ B int }{})})
+}
+
+func TestAPIManifestEndpoint(t *testing.T) {
+	HandleAPI("/api/manifest-test", func(w http.ResponseWriter, r *http.Request) {}, WithResponse(manifestTestUsers{}))
+	handler := NewApplication(nil).Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, APIManifestPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from %s, got %d", APIManifestPath, rec.Code)
+	}
+	var manifest APIManifest
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("Failed to decode manifest: %v", err)
+	}
+	found := false
+	for _, endpoint := range manifest.Endpoints {
+		if endpoint.Path == "/api/manifest-test" && endpoint.Name == "ManifestTest" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected /api/manifest-test in manifest, got %+v", manifest.Endpoints)
+	}
+}
@@ -2,9 +2,13 @@ package applib
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/tomyedwab/yesterday/applib/database"
@@ -13,6 +17,12 @@ import (
 type Application struct {
 	db          *database.Database
 	contextVars map[string]any
+	middleware  []func(http.Handler) http.Handler
+	crashes     *crashReporter
+	selfTests   *selfTests
+	probes      *probes
+	shutdown    shutdownHooks
+	serveOnce   sync.Once
 }
 
 var (
@@ -22,18 +32,73 @@ var (
 )
 
 func NewApplication(db *database.Database) *Application {
-	return &Application{
+	app := &Application{
 		db:          db,
 		contextVars: make(map[string]any),
+		crashes:     newCrashReporter(sendToHub),
+		selfTests:   newSelfTests(),
+		probes:      newProbes(),
 	}
+	captureLogBreadcrumbs()
+	app.Use(traceMiddleware)
+	app.Use(app.recoveryMiddleware)
+	app.Use(deadlineMiddleware)
+	app.Use(userMiddleware)
+	registerAPIManifest()
+	return app
 }
 
 func (app *Application) AddContextVar(key string, value any) {
 	app.contextVars[key] = value
 }
 
+// Use adds a middleware that wraps every handler served by the application.
+// Middleware is applied in the order it was added, so the first middleware
+// added is the outermost.
+func (app *Application) Use(mw func(http.Handler) http.Handler) {
+	app.middleware = append(app.middleware, mw)
+}
+
+// Handler returns the root handler for the application: the default serve mux
+// wrapped in all registered middleware.
+func (app *Application) Handler() http.Handler {
+	var handler http.Handler = http.DefaultServeMux
+	for i := len(app.middleware) - 1; i >= 0; i-- {
+		handler = app.middleware[i](handler)
+	}
+	return handler
+}
+
 func (app *Application) Serve() {
-	log.Printf("Starting server on port 80")
+	log.Printf("Starting server")
+	listener, err := listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := app.newServer()
+
+	ctx, stop := notifyShutdown(context.Background())
+	defer stop()
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	app.stop(server)
+}
+
+// newServer returns a server for the application's handlers. The first time,
+// it also registers the endpoints the hub calls and starts the self-tests,
+// which run while serving so the hub can poll their progress.
+func (app *Application) newServer() *http.Server {
+	app.serveOnce.Do(func() {
+		http.Handle(SelfTestPath, app.selfTests)
+		http.HandleFunc(LogLevelPath, serveLogLevel)
+		http.Handle(ProbesPath, app.probes)
+		go app.selfTests.run()
+	})
 	contextFn := func(net.Listener) context.Context {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, ContextApplicationKey, app)
@@ -44,8 +109,61 @@ func (app *Application) Serve() {
 		}
 		return ctx
 	}
-	server := &http.Server{Addr: "127.0.0.1:80", Handler: nil, BaseContext: contextFn}
-	log.Fatal(server.ListenAndServe())
+	return &http.Server{Handler: app.Handler(), BaseContext: contextFn}
+}
+
+// stop shuts the application down when the hub asks it to: it stops
+// accepting requests and waits briefly for in-flight ones, runs the shutdown
+// hooks and finally closes the database.
+func (app *Application) stop(server *http.Server) {
+	log.Printf("Shutting down")
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Printf("Failed to finish in-flight requests: %v", err)
+	}
+	app.shutdown.run()
+	if app.db != nil {
+		if err := app.db.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+		}
+	}
+	log.Printf("Shutdown complete")
+}
+
+// listen opens the listener the hub expects: the unix socket named by
+// LISTEN_SOCKET for packages using the unix transport, otherwise port 80 on
+// the loopback interface, falling back to IPv6 on hosts without IPv4. Over
+// TCP, connections must authenticate with the hub's client certificate, see
+// serverTLSConfig.
+func listen() (net.Listener, error) {
+	if path := os.Getenv("LISTEN_SOCKET"); path != "" {
+		log.Printf("Listening on socket %s", path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		// A socket left behind by a previous run would fail the listen
+		os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:80")
+	if err != nil {
+		var ipv6Err error
+		listener, ipv6Err = net.Listen("tcp", "[::1]:80")
+		if ipv6Err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if tlsConfig != nil {
+		log.Printf("Requiring the hub's client certificate")
+		return tls.NewListener(listener, tlsConfig), nil
+	}
+	return listener, nil
 }
 
 func (app *Application) GetDatabase() *database.Database {
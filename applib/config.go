@@ -0,0 +1,111 @@
+package applib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadConfig populates the fields of the struct pointed to by cfg from
+// environment variables, using struct tags:
+//
+//	type Config struct {
+//		Host    string        `env:"HOST,required"`
+//		Workers int           `env:"WORKERS" default:"4"`
+//		Debug   bool          `env:"DEBUG"`
+//		Timeout time.Duration `env:"TIMEOUT" default:"30s"`
+//	}
+//
+// Supported field types are string, bool, the int and uint types, float64 and
+// time.Duration. Fields without an env tag are left untouched. All missing and
+// invalid variables are reported together in the returned error so that a
+// misconfigured app fails fast at startup with the full list of problems.
+func LoadConfig(cfg any) error {
+	value := reflect.ValueOf(cfg)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("LoadConfig requires a pointer to a struct, got %T", cfg)
+	}
+	value = value.Elem()
+	structType := value.Type()
+
+	var errs []error
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, ok := field.Tag.Lookup("env")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		required := options == "required"
+
+		raw, present := os.LookupEnv(name)
+		if !present || raw == "" {
+			if required {
+				errs = append(errs, fmt.Errorf("%s: required environment variable is not set", name))
+				continue
+			}
+			raw, present = field.Tag.Lookup("default")
+			if !present {
+				continue
+			}
+		}
+
+		if err := setConfigField(value.Field(i), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setConfigField(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
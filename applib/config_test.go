@@ -0,0 +1,70 @@
+package applib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Host    string        `env:"TEST_HOST,required"`
+	Workers int           `env:"TEST_WORKERS" default:"4"`
+	Debug   bool          `env:"TEST_DEBUG"`
+	Timeout time.Duration `env:"TEST_TIMEOUT" default:"30s"`
+	Ratio   float64       `env:"TEST_RATIO"`
+	Ignored string
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TEST_HOST", "app.yesterday.localhost")
+	t.Setenv("TEST_DEBUG", "true")
+	t.Setenv("TEST_RATIO", "0.5")
+
+	cfg := testConfig{Ignored: "unchanged"}
+	if err := LoadConfig(&cfg); err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+
+	if cfg.Host != "app.yesterday.localhost" {
+		t.Errorf("Expected Host from env, got %q", cfg.Host)
+	}
+	if cfg.Workers != 4 {
+		t.Errorf("Expected default Workers 4, got %d", cfg.Workers)
+	}
+	if !cfg.Debug {
+		t.Error("Expected Debug to be true")
+	}
+	if cfg.Timeout != 30*time.Second {
+		t.Errorf("Expected default Timeout 30s, got %v", cfg.Timeout)
+	}
+	if cfg.Ratio != 0.5 {
+		t.Errorf("Expected Ratio 0.5, got %v", cfg.Ratio)
+	}
+	if cfg.Ignored != "unchanged" {
+		t.Errorf("Expected untagged field to be left alone, got %q", cfg.Ignored)
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	t.Setenv("TEST_HOST", "")
+	t.Setenv("TEST_WORKERS", "many")
+	t.Setenv("TEST_TIMEOUT", "soon")
+
+	var cfg testConfig
+	err := LoadConfig(&cfg)
+	if err == nil {
+		t.Fatal("Expected an error for invalid configuration")
+	}
+	for _, expected := range []string{"TEST_HOST", "TEST_WORKERS", "TEST_TIMEOUT"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to mention %s, got: %v", expected, err)
+		}
+	}
+}
+
+func TestLoadConfigRequiresStructPointer(t *testing.T) {
+	var cfg testConfig
+	if err := LoadConfig(cfg); err == nil {
+		t.Error("Expected an error when passing a struct by value")
+	}
+}
@@ -0,0 +1,227 @@
+package applib
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+// CrashReportPath is the hub endpoint that receives crash reports.
+const CrashReportPath = "/internal/crash-reports"
+
+const (
+	// crashQueueSize bounds the reports waiting to be sent. When the hub is
+	// unreachable the oldest reports are dropped to make room.
+	crashQueueSize = 16
+	// breadcrumbCount is the number of recent log lines sent with a report.
+	breadcrumbCount = 20
+
+	crashRetryDelay    = time.Second
+	crashMaxRetryDelay = time.Minute
+)
+
+// crashReport is the payload posted to CrashReportPath.
+type crashReport struct {
+	Message     string        `json:"message"`
+	Stack       string        `json:"stack"`
+	Breadcrumbs []string      `json:"breadcrumbs,omitempty"`
+	Request     *crashRequest `json:"request,omitempty"`
+	Task        string        `json:"task,omitempty"`
+	Time        time.Time     `json:"time"`
+}
+
+type crashRequest struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// breadcrumbs keeps the most recent lines written to the standard logger.
+type breadcrumbs struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+var (
+	logBreadcrumbs        = &breadcrumbs{}
+	installBreadcrumbOnce sync.Once
+)
+
+// captureLogBreadcrumbs tees the standard logger into logBreadcrumbs.
+func captureLogBreadcrumbs() {
+	installBreadcrumbOnce.Do(func() {
+		log.SetOutput(&teeWriter{out: log.Writer(), crumbs: logBreadcrumbs})
+	})
+}
+
+type teeWriter struct {
+	out    io.Writer
+	crumbs *breadcrumbs
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	t.crumbs.add(strings.TrimRight(string(p), "\n"))
+	return t.out.Write(p)
+}
+
+func (b *breadcrumbs) add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines = append(b.lines, line)
+	if len(b.lines) > breadcrumbCount {
+		b.lines = b.lines[len(b.lines)-breadcrumbCount:]
+	}
+}
+
+func (b *breadcrumbs) recent() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
+
+// crashReporter sends crash reports to the hub in the background. Reporting
+// is best-effort: report never blocks, and reports that cannot be delivered
+// are retried until newer ones push them out of the queue.
+type crashReporter struct {
+	send func(crashReport) error
+
+	mu      sync.Mutex
+	queue   []queuedCrash
+	nextSeq int
+	dropped int
+	wake    chan struct{}
+	start   sync.Once
+}
+
+type queuedCrash struct {
+	seq    int
+	report crashReport
+}
+
+func newCrashReporter(send func(crashReport) error) *crashReporter {
+	return &crashReporter{send: send, wake: make(chan struct{}, 1)}
+}
+
+// sendToHub posts a report to the hub on behalf of this instance.
+func sendToHub(report crashReport) error {
+	_, _, err := httputils.CallService[crashReport, map[string]any](os.Getenv("INSTANCE_ID"), CrashReportPath, report)
+	return err
+}
+
+// report queues a crash for delivery, dropping the oldest queued report if
+// the queue is full.
+func (c *crashReporter) report(report crashReport) {
+	c.start.Do(func() { go c.run() })
+
+	c.mu.Lock()
+	if len(c.queue) == crashQueueSize {
+		c.queue = c.queue[1:]
+		c.dropped++
+	}
+	c.nextSeq++
+	c.queue = append(c.queue, queuedCrash{seq: c.nextSeq, report: report})
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *crashReporter) run() {
+	delay := crashRetryDelay
+	for range c.wake {
+		for {
+			c.mu.Lock()
+			if len(c.queue) == 0 {
+				c.mu.Unlock()
+				break
+			}
+			next := c.queue[0]
+			c.mu.Unlock()
+
+			if err := c.send(next.report); err != nil {
+				log.Printf("Failed to send crash report, retrying in %v: %v", delay, err)
+				time.Sleep(delay)
+				delay = min(delay*2, crashMaxRetryDelay)
+				continue
+			}
+			delay = crashRetryDelay
+
+			// The report may have been pushed out while it was being sent
+			c.mu.Lock()
+			if len(c.queue) > 0 && c.queue[0].seq == next.seq {
+				c.queue = c.queue[1:]
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// newCrash describes a recovered panic.
+func newCrash(value any, stack []byte) crashReport {
+	return crashReport{
+		Message:     fmt.Sprint(value),
+		Stack:       string(stack),
+		Breadcrumbs: logBreadcrumbs.recent(),
+		Time:        time.Now().UTC(),
+	}
+}
+
+// recoveryMiddleware turns a panicking handler into a 500 response and
+// reports the panic to the hub. The response is written before the report is
+// sent, so an unreachable hub never delays or changes it.
+func (app *Application) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httputils.NewResponseRecorder(w, 0)
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+			if recorder.Status() == 0 {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+
+			crash := newCrash(value, debug.Stack())
+			crash.Request = &crashRequest{
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				TraceID: r.Header.Get(httputils.TraceIDHeader),
+			}
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, value, crash.Stack)
+			app.crashes.report(crash)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// Go runs fn in a new goroutine. If fn panics the panic is logged and
+// reported to the hub under the given task name, and the application keeps
+// running.
+func (app *Application) Go(name string, fn func()) {
+	go func() {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			crash := newCrash(value, debug.Stack())
+			crash.Task = name
+			log.Printf("panic in background task %s: %v\n%s", name, value, crash.Stack)
+			app.crashes.report(crash)
+		}()
+		fn()
+	}()
+}
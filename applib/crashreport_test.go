@@ -0,0 +1,101 @@
+package applib
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoveryMiddlewareReportsPanics(t *testing.T) {
+	http.HandleFunc("/api/crash-test", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("about to crash")
+		var m map[string]int
+		m["boom"] = 1
+	})
+	app := NewApplication(nil)
+	reports := make(chan crashReport, 1)
+	app.crashes = newCrashReporter(func(report crashReport) error {
+		reports <- report
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/crash-test", nil)
+	req.Header.Set("X-Trace-ID", "trace-1")
+	recorder := httptest.NewRecorder()
+	app.Handler().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", recorder.Code)
+	}
+
+	select {
+	case report := <-reports:
+		if !strings.Contains(report.Message, "nil map") || !strings.Contains(report.Stack, "crashreport_test.go") {
+			t.Errorf("Unexpected report message %q or stack:\n%s", report.Message, report.Stack)
+		}
+		if report.Request == nil || report.Request.Path != "/api/crash-test" || report.Request.TraceID != "trace-1" {
+			t.Errorf("Unexpected request context %+v", report.Request)
+		}
+		if len(report.Breadcrumbs) == 0 || !strings.HasSuffix(report.Breadcrumbs[len(report.Breadcrumbs)-1], "about to crash") {
+			t.Errorf("Expected the last breadcrumb to be the handler's log line, got %q", report.Breadcrumbs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Crash was not reported")
+	}
+}
+
+func TestCrashReportingWithUnreachableHub(t *testing.T) {
+	http.HandleFunc("/api/crash-unreachable-test", func(w http.ResponseWriter, r *http.Request) {
+		panic(r.URL.Query().Get("n"))
+	})
+	app := NewApplication(nil)
+	app.crashes = newCrashReporter(func(crashReport) error {
+		return errors.New("hub unreachable")
+	})
+
+	total := crashQueueSize + 5
+	for i := 0; i < total; i++ {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/crash-unreachable-test?n="+string(rune('a'+i)), nil)
+		app.Handler().ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500 with the hub unreachable, got %d", recorder.Code)
+		}
+	}
+
+	app.crashes.mu.Lock()
+	defer app.crashes.mu.Unlock()
+	if len(app.crashes.queue) != crashQueueSize || app.crashes.dropped != total-crashQueueSize {
+		t.Fatalf("Expected %d queued and %d dropped, got %d and %d", crashQueueSize, total-crashQueueSize, len(app.crashes.queue), app.crashes.dropped)
+	}
+	var messages []string
+	for _, queued := range app.crashes.queue {
+		messages = append(messages, queued.report.Message)
+	}
+	if messages[0] != string(rune('a'+total-crashQueueSize)) || !slices.Contains(messages, string(rune('a'+total-1))) {
+		t.Errorf("Expected the oldest reports to be dropped, queue holds %q", messages)
+	}
+}
+
+func TestGoReportsPanics(t *testing.T) {
+	app := NewApplication(nil)
+	reports := make(chan crashReport, 1)
+	app.crashes = newCrashReporter(func(report crashReport) error {
+		reports <- report
+		return nil
+	})
+
+	app.Go("cleanup", func() { panic("task failed") })
+	select {
+	case report := <-reports:
+		if report.Task != "cleanup" || report.Message != "task failed" || report.Request != nil {
+			t.Errorf("Unexpected report %+v", report)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Crash was not reported")
+	}
+}
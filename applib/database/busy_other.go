@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package database
+
+import "strings"
+
+// isSQLiteBusy reports whether err is an SQLITE_BUSY or SQLITE_LOCKED error.
+// The driver's error type only exists with cgo, so this falls back to
+// SQLite's messages for those codes.
+func isSQLiteBusy(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
+}
@@ -4,8 +4,13 @@ package database
 // to the appropriate handlers.
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
@@ -14,41 +19,161 @@ import (
 type EventHandler[T interface{}] func(tx *sqlx.Tx, event T) (bool, error)
 type GenericEventHandler func(tx *sqlx.Tx, eventJson []byte) (bool, error)
 
+// ApplyMode selects when an event handler runs: while events arrive from the
+// hub, while an exported event log is replayed with ImportEvents, or both.
+type ApplyMode int
+
+const (
+	// ApplyBoth runs the handler for live and replayed events. It is the
+	// mode of handlers added with AddEventHandler and AddGenericEventHandler.
+	ApplyBoth ApplyMode = iota
+	// ApplyLive runs the handler only for events delivered by the hub.
+	ApplyLive
+	// ApplyReplay runs the handler only while replaying an event log, e.g. to
+	// rebuild a projection that is too expensive to maintain per event.
+	ApplyReplay
+)
+
+// runs reports whether a handler with this mode runs for an event applied
+// live or, if replaying is set, from a replayed log.
+func (m ApplyMode) runs(replaying bool) bool {
+	switch m {
+	case ApplyLive:
+		return !replaying
+	case ApplyReplay:
+		return replaying
+	}
+	return true
+}
+
+// registeredHandler is an event handler and its name for error reports.
+type registeredHandler struct {
+	name    string
+	mode    ApplyMode
+	handler GenericEventHandler
+}
+
 type Database struct {
-	db         *sqlx.DB
-	handlers   map[string][]GenericEventHandler
-	eventState *EventState
+	db            *sqlx.DB
+	handlers      map[string][]registeredHandler
+	eventErrors   *eventErrorStore
+	eventState    *EventState
+	schemaVersion int
+	migrations    []Migration
+	tableHooks    *tableHooks // nil unless the driver is sqlite3
+	lockPath      string      // Instance lock file; empty if not applicable
+	instanceID    string
+	appVersion    string // Recorded in event log exports, see SetAppVersion
+	instanceLock  *InstanceLock
+	// deadLetterAfter is the number of failed attempts after which an event
+	// is dead-lettered, or zero to never dead-letter
+	deadLetterAfter int
+	// latestEventId is the highest event ID the application has been sent
+	latestEventId   atomic.Int64
+	handlerObserver HandlerObserver
+	// personalData marks the event fields sealed in the event log, see
+	// SetPersonalData
+	personalData  map[string]PersonalData
+	resetHandlers []func(tx *sqlx.Tx) error
+	// softDeleteTables maps the tables registered with
+	// RegisterSoftDeleteTable to their deleted-at columns
+	softDeleteTables    map[string]string
+	softDeleteRetention time.Duration
+	softDeletePurger    *softDeletePurger
 }
 
 func Connect(driverName string, dataSourceName string) (*Database, error) {
-	db, err := sqlx.Connect(driverName, dataSourceName)
-	if err != nil {
+	if driverName != "sqlite3" {
+		db, err := sqlx.Connect(driverName, dataSourceName)
+		if err != nil {
+			return nil, err
+		}
+		return &Database{
+			db:              db,
+			handlers:        make(map[string][]registeredHandler),
+			eventErrors:     newEventErrorStore(),
+			deadLetterAfter: DefaultDeadLetterAfter,
+			personalData:    make(map[string]PersonalData),
+		}, nil
+	}
+
+	// SQLite connections are opened with update hooks installed so that
+	// OnTableChange can observe writes from any code path
+	hooks := newTableHooks()
+	db := sqlx.NewDb(openWithHooks(dataSourceName, hooks), driverName)
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return &Database{
-		db:       db,
-		handlers: make(map[string][]GenericEventHandler),
+		db:              db,
+		handlers:        make(map[string][]registeredHandler),
+		eventErrors:     newEventErrorStore(),
+		deadLetterAfter: DefaultDeadLetterAfter,
+		tableHooks:      hooks,
+		lockPath:        instanceLockPath(dataSourceName),
+		personalData:    make(map[string]PersonalData),
 	}, nil
 }
 
+// SetInstanceID records the application instance ID in the instance lock,
+// so that a second instance refused access can say who holds the database.
+func (db *Database) SetInstanceID(instanceID string) {
+	db.instanceID = instanceID
+}
+
+// InstanceID returns the application instance ID set with SetInstanceID.
+func (db *Database) InstanceID() string {
+	return db.instanceID
+}
+
 func AddEventHandler[T interface{}](db *Database, eventType string, handler EventHandler[T]) {
-	db.handlers[eventType] = append(db.handlers[eventType], func(tx *sqlx.Tx, eventJson []byte) (bool, error) {
-		var event T
-		if err := json.Unmarshal(eventJson, &event); err != nil {
-			return false, fmt.Errorf("failed to unmarshal event of type %s: %w", eventType, err)
-		}
-		return handler(tx, event)
+	AddEventHandlerWithMode(db, eventType, ApplyBoth, handler)
+}
+
+// AddEventHandlerWithMode is like AddEventHandler but only runs the handler
+// for events applied in the given mode.
+func AddEventHandlerWithMode[T interface{}](db *Database, eventType string, mode ApplyMode, handler EventHandler[T]) {
+	db.handlers[eventType] = append(db.handlers[eventType], registeredHandler{
+		name: handlerName(handler),
+		mode: mode,
+		handler: func(tx *sqlx.Tx, eventJson []byte) (bool, error) {
+			var event T
+			if err := json.Unmarshal(eventJson, &event); err != nil {
+				return false, fmt.Errorf("failed to unmarshal event of type %s: %w", eventType, err)
+			}
+			return handler(tx, event)
+		},
 	})
 }
 
 func AddGenericEventHandler(db *Database, eventType string, handler GenericEventHandler) {
-	db.handlers[eventType] = append(db.handlers[eventType], handler)
+	AddGenericEventHandlerWithMode(db, eventType, ApplyBoth, handler)
+}
+
+// AddGenericEventHandlerWithMode is like AddGenericEventHandler but only runs
+// the handler for events applied in the given mode.
+func AddGenericEventHandlerWithMode(db *Database, eventType string, mode ApplyMode, handler GenericEventHandler) {
+	db.handlers[eventType] = append(db.handlers[eventType], registeredHandler{name: handlerName(handler), mode: mode, handler: handler})
 }
 
-// Connect creates a new database connection and initializes the database
-// schema.
+// Initialize acquires the instance lock and initializes the database schema.
+// It fails with an InstanceLockedError if another live instance is already
+// serving this database.
 func (db *Database) Initialize() error {
-	var err error
+	if db.lockPath != "" && db.instanceLock == nil {
+		lock, err := AcquireInstanceLock(db.db, db.lockPath, db.instanceID)
+		if err != nil {
+			return err
+		}
+		db.instanceLock = lock
+	}
+
+	err := db.checkSchemaVersion()
+	if err != nil {
+		return err
+	}
+
 	db.eventState, err = NewEventState(db.GetDB())
 	if err != nil {
 		return err
@@ -59,39 +184,169 @@ func (db *Database) Initialize() error {
 		return err
 	}
 
+	// Rows soft-deleted for longer than the retention period are purged in
+	// the background
+	db.startSoftDeletePurge()
+
 	fmt.Println("Initialized application.")
 
 	return nil
 }
 
 // HandleEvent updates the state of all handlers that are interested in the
-// event type and updates the current event ID.
+// event type and updates the current event ID. If the database is busy the
+// transaction is rolled back and the event is applied again, up to
+// MaxEventAttempts times, so a retry never double-applies an event.
+//
+// Failures are recorded, deduplicated, in EventErrors until the event is
+// applied successfully.
 func (db *Database) HandleEvent(eventId int, eventType string, eventData []byte) error {
+	return db.handleEvent(eventId, eventType, eventData, false)
+}
+
+// handleEvent applies an event live or, if replaying is set, as part of a
+// replayed event log. Only the handlers whose ApplyMode matches run.
+func (db *Database) handleEvent(eventId int, eventType string, eventData []byte, replaying bool) error {
+	db.noteEventId(eventId)
+	err := db.handleEventWithRetry(eventId, eventType, eventData, replaying)
+	if err != nil {
+		attempts := db.eventErrors.record(eventId, eventType, err)
+		// A busy database is not the event's fault, so only handler failures
+		// count towards dead-lettering. A rejected event never applies, so it
+		// is set aside straight away.
+		rejected := !replaying && IsRejected(err)
+		if !rejected && (replaying || IsRetryable(err) || db.deadLetterAfter <= 0 || attempts < db.deadLetterAfter) {
+			return err
+		}
+		if dlErr := db.deadLetter(eventId, eventType, eventData, err, attempts); dlErr != nil {
+			logf(slog.LevelError, "Failed to dead-letter event %d: %v", eventId, dlErr)
+			return err
+		}
+	}
+	db.eventErrors.clear(eventId)
+	return nil
+}
+
+func (db *Database) handleEventWithRetry(eventId int, eventType string, eventData []byte, replaying bool) error {
+	return retryWhileBusy(context.Background(), fmt.Sprintf("event %d", eventId), func() error {
+		return db.applyEvent(eventId, eventType, eventData, replaying)
+	})
+}
+
+// retryWhileBusy calls apply, which applies what, again with backoff while it
+// fails because the database is busy, up to MaxEventAttempts times or until
+// ctx is done.
+func retryWhileBusy(ctx context.Context, what string, apply func() error) error {
+	backoff := eventRetryBackoffInitial
+	var err error
+	for attempt := 1; attempt <= MaxEventAttempts; attempt++ {
+		err = apply()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		logf(slog.LevelWarn, "Database busy applying %s (attempt %d/%d): %v", what, attempt, MaxEventAttempts, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("giving up on %s: %w (last error: %v)", what, ctx.Err(), err)
+		}
+		backoff = min(backoff*2, eventRetryBackoffMax)
+	}
+	return fmt.Errorf("giving up on %s after %d attempts: %w", what, MaxEventAttempts, err)
+}
+
+// applyEvent runs all handlers for an event in a single transaction.
+func (db *Database) applyEvent(eventId int, eventType string, eventData []byte, replaying bool) error {
 	// Start a transaction before writing anything to the DB
 	tx, err := db.db.Beginx()
 	if err != nil {
-		return err
+		return wrapBusyError(err)
 	}
 	defer tx.Rollback()
 
-	// Update all handlers with the new event
-	for _, handlerFunc := range db.handlers[eventType] {
-		_, err := handlerFunc(tx, eventData)
-		if err != nil {
-			return err
-		}
+	if err := db.applyEventTx(tx, eventId, eventType, eventData, replaying); err != nil {
+		return wrapBusyError(err)
 	}
 
-	err = db.eventState.SetCurrentEventId(eventId, tx)
+	if err := saveCurrentEventId(tx, eventId); err != nil {
+		return wrapBusyError(err)
+	}
+
+	// Commit the transaction, and only then advance the in-memory event ID:
+	// an event rolled back while the database is busy must be delivered again
+	if err := tx.Commit(); err != nil {
+		return wrapBusyError(err)
+	}
+	db.eventState.CurrentEventId = eventId
+	return nil
+}
+
+// applyEventTx runs the handlers for an event and adds it to the event log
+// within tx.
+func (db *Database) applyEventTx(tx *sqlx.Tx, eventId int, eventType string, eventData []byte, replaying bool) error {
+	// Handlers see personal data in the clear, but it is logged sealed
+	handlerData, personal, err := db.openEvent(tx, eventType, eventData)
 	if err != nil {
 		return err
 	}
 
-	// Commit the transaction
-	err = tx.Commit()
+	// Update all handlers with the new event
+	if err := db.runHandlers(tx, eventType, handlerData, replaying); err != nil {
+		return err
+	}
+
+	logData := eventData
+	if personal != nil {
+		if logData, err = personal.seal(tx, eventId, handlerData); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(insertEventLogSql, eventId, eventType, string(logData))
+	return err
+}
+
+// runHandlers runs the handlers for the event type whose mode matches.
+func (db *Database) runHandlers(tx *sqlx.Tx, eventType string, eventData []byte, replaying bool) error {
+	_, err := db.runHandlersChanged(tx, eventType, eventData, replaying)
 	return err
 }
 
+// runHandlersChanged is runHandlers, also reporting whether any handler
+// changed state.
+func (db *Database) runHandlersChanged(tx *sqlx.Tx, eventType string, eventData []byte, replaying bool) (bool, error) {
+	changed := false
+	for _, registered := range db.handlers[eventType] {
+		if !registered.mode.runs(replaying) {
+			continue
+		}
+		start := time.Now()
+		handlerChanged, err := registered.handler(tx, eventData)
+		if db.handlerObserver != nil {
+			db.handlerObserver(eventType, registered.name, time.Since(start))
+		}
+		if err != nil {
+			return false, &EventHandlerError{EventType: eventType, Handler: registered.name, Err: err}
+		}
+		changed = changed || handlerChanged
+		logf(slog.LevelDebug, "Handler %s applied %s in %s", registered.name, eventType, time.Since(start))
+	}
+	return changed, nil
+}
+
 func (db *Database) GetDB() *sqlx.DB {
 	return db.db
 }
+
+// Close stops purging soft-deleted rows, releases the instance lock, if
+// held, and closes the database.
+func (db *Database) Close() error {
+	db.stopSoftDeletePurge()
+	var lockErr error
+	if db.instanceLock != nil {
+		lockErr = db.instanceLock.Release()
+		db.instanceLock = nil
+	}
+	return errors.Join(lockErr, db.db.Close())
+}
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultDeadLetterAfter is the number of failed attempts to apply an event
+// after which it is moved to the dead-letter table, see SetDeadLetterAfter.
+const DefaultDeadLetterAfter = 5
+
+// ErrDeadLetterNotFound is returned by RedriveDeadLetter for an event that is
+// not in the dead-letter table.
+var ErrDeadLetterNotFound = errors.New("event is not dead-lettered")
+
+const deadLetterSchema = `
+	CREATE TABLE IF NOT EXISTS dead_letter_events (
+		event_id INTEGER PRIMARY KEY,
+		event_type TEXT NOT NULL,
+		event_data TEXT NOT NULL,
+		error TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		dead_lettered_at TIMESTAMP NOT NULL,
+		group_id INTEGER
+	)`
+
+// Dead-letter tables created before event groups are migrated in
+// NewEventState.
+const deadLetterGroupColumnSql = `
+	SELECT COUNT(*) FROM pragma_table_info('dead_letter_events') WHERE name = 'group_id'`
+
+const addDeadLetterGroupColumnSql = `
+	ALTER TABLE dead_letter_events ADD COLUMN group_id INTEGER`
+
+const selectDeadLetterSql = `
+	SELECT event_id, event_type, event_data, error, attempts, dead_lettered_at, COALESCE(group_id, 0) AS group_id
+	FROM dead_letter_events`
+
+// DeadLetter is an event that failed to apply too many times and was set
+// aside so that the events after it could be applied.
+type DeadLetter struct {
+	EventID        int       `db:"event_id" json:"eventId"`
+	EventType      string    `db:"event_type" json:"eventType"`
+	EventData      string    `db:"event_data" json:"eventData"`
+	Error          string    `db:"error" json:"error"`
+	Attempts       int       `db:"attempts" json:"attempts"`
+	DeadLetteredAt time.Time `db:"dead_lettered_at" json:"deadLetteredAt"`
+	// GroupID is the group the event was published atomically with, see
+	// HandleEventGroup. The group's events are dead-lettered and redriven
+	// together.
+	GroupID int `db:"group_id" json:"groupId,omitempty"`
+}
+
+// RejectedEventError marks an event that a handler refused as invalid, for
+// example one that fails validation an old client skipped. Retrying cannot
+// make it apply, so a live event is dead-lettered on the first attempt
+// instead of after DefaultDeadLetterAfter attempts.
+type RejectedEventError struct {
+	Err error
+}
+
+func (e *RejectedEventError) Error() string {
+	return fmt.Sprintf("event rejected: %v", e.Err)
+}
+
+func (e *RejectedEventError) Unwrap() error {
+	return e.Err
+}
+
+// RejectEvent wraps a handler error to mark the event as invalid, see
+// RejectedEventError. Rejections only make sense in ApplyLive handlers: a
+// replayed event is already in the event log, so rejecting it fails the
+// replay.
+func RejectEvent(err error) error {
+	return &RejectedEventError{Err: err}
+}
+
+// IsRejected reports whether err marks an event rejected by RejectEvent.
+func IsRejected(err error) bool {
+	var rejected *RejectedEventError
+	return errors.As(err, &rejected)
+}
+
+// SetDeadLetterAfter sets the number of failed attempts to apply an event
+// after which it is dead-lettered: recorded in the dead-letter table and
+// skipped, so one poison event cannot halt the application. Zero disables
+// dead-lettering, leaving a failing event to block the events after it. The
+// default is DefaultDeadLetterAfter. Replayed events are never
+// dead-lettered.
+func (db *Database) SetDeadLetterAfter(attempts int) {
+	db.deadLetterAfter = attempts
+}
+
+// migrateDeadLetterSchema adds the group_id column to dead-letter tables
+// created before event groups.
+func migrateDeadLetterSchema(db *sqlx.DB) error {
+	var hasGroupId int
+	if err := db.Get(&hasGroupId, deadLetterGroupColumnSql); err != nil {
+		return err
+	}
+	if hasGroupId == 0 {
+		_, err := db.Exec(addDeadLetterGroupColumnSql)
+		return err
+	}
+	return nil
+}
+
+// deadLetter records a failing event in the dead-letter table and advances
+// the current event ID past it.
+func (db *Database) deadLetter(eventId int, eventType string, eventData []byte, cause error, attempts int) error {
+	return db.deadLetterEvents(0, []GroupEvent{{ID: eventId, Type: eventType, Data: eventData}}, cause, attempts)
+}
+
+// deadLetterEvents records failing events, a group unless groupId is zero, in
+// the dead-letter table and advances the current event ID past them.
+func (db *Database) deadLetterEvents(groupId int, events []GroupEvent, cause error, attempts int) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		_, err = tx.Exec(`
+			INSERT OR REPLACE INTO dead_letter_events (event_id, event_type, event_data, error, attempts, dead_lettered_at, group_id)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))`,
+			event.ID, event.Type, string(event.Data), cause.Error(), attempts, time.Now().UTC(), groupId)
+		if err != nil {
+			return err
+		}
+	}
+	lastId := events[len(events)-1].ID
+	advance := lastId > db.eventState.CurrentEventId
+	if advance {
+		if err := saveCurrentEventId(tx, lastId); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if advance {
+		db.eventState.CurrentEventId = lastId
+	}
+	if groupId != 0 {
+		logf(slog.LevelError, "Dead-lettered event group %d (%d events) after %d failed attempts: %v", groupId, len(events), attempts, cause)
+	} else {
+		logf(slog.LevelError, "Dead-lettered event %d (%s) after %d failed attempts: %v", events[0].ID, events[0].Type, attempts, cause)
+	}
+	return nil
+}
+
+// DeadLetters returns the dead-lettered events, ordered by event ID.
+func (db *Database) DeadLetters() ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	err := db.db.Select(&deadLetters, selectDeadLetterSql+` ORDER BY event_id`)
+	return deadLetters, err
+}
+
+// DeadLetterCount returns the number of dead-lettered events.
+func (db *Database) DeadLetterCount() (int, error) {
+	var count int
+	err := db.db.Get(&count, `SELECT COUNT(*) FROM dead_letter_events`)
+	return count, err
+}
+
+// RedriveDeadLetter applies a dead-lettered event again, e.g. after a fixed
+// handler has been deployed. On success the event is added to the event log
+// and removed from the dead-letter table. Note that it is applied after the
+// events that followed it. On failure it stays dead-lettered with the new
+// error. An event of a group is redriven together with the rest of its
+// group, in a single transaction.
+func (db *Database) RedriveDeadLetter(eventId int) error {
+	var deadLetter DeadLetter
+	err := db.db.Get(&deadLetter, selectDeadLetterSql+` WHERE event_id = $1`, eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return err
+	}
+	deadLetters := []DeadLetter{deadLetter}
+	if deadLetter.GroupID != 0 {
+		deadLetters = nil
+		err := db.db.Select(&deadLetters, selectDeadLetterSql+` WHERE group_id = $1 ORDER BY event_id`, deadLetter.GroupID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = db.redrive(deadLetters)
+	if err != nil {
+		for _, deadLetter := range deadLetters {
+			_, updateErr := db.db.Exec(`UPDATE dead_letter_events SET error = $1, attempts = attempts + 1 WHERE event_id = $2`,
+				err.Error(), deadLetter.EventID)
+			if updateErr != nil {
+				logf(slog.LevelError, "Failed to update dead-lettered event %d: %v", deadLetter.EventID, updateErr)
+			}
+		}
+		return fmt.Errorf("failed to redrive event %d: %w", eventId, err)
+	}
+	if deadLetter.GroupID != 0 {
+		logf(slog.LevelInfo, "Redrove dead-lettered event group %d (%d events)", deadLetter.GroupID, len(deadLetters))
+	} else {
+		logf(slog.LevelInfo, "Redrove dead-lettered event %d (%s)", eventId, deadLetter.EventType)
+	}
+	return nil
+}
+
+func (db *Database) redrive(deadLetters []DeadLetter) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, deadLetter := range deadLetters {
+		eventData := []byte(deadLetter.EventData)
+		if err := db.runHandlers(tx, deadLetter.EventType, eventData, false); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(insertEventLogSql, deadLetter.EventID, deadLetter.EventType, deadLetter.EventData); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM dead_letter_events WHERE event_id = $1`, deadLetter.EventID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
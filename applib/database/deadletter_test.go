@@ -0,0 +1,164 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func addPoisonHandler(db *Database, fail *bool) {
+	AddGenericEventHandler(db, "Counter:Poison", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		if *fail {
+			return false, errors.New("cannot apply")
+		}
+		_, err := tx.Exec(`UPDATE counter SET value = value + 100 WHERE id = 0`)
+		return true, err
+	})
+	AddGenericEventHandler(db, "Counter:Increment", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return true, err
+	})
+}
+
+func TestDeadLetterAfterRepeatedFailures(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	db.SetDeadLetterAfter(3)
+	fail := true
+	addPoisonHandler(db, &fail)
+
+	for attempt := 1; attempt < 3; attempt++ {
+		if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err == nil {
+			t.Fatalf("Expected attempt %d to fail", attempt)
+		}
+	}
+	if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the event to be dead-lettered, got %v", err)
+	}
+
+	deadLetters, err := db.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].EventID != 1 || deadLetters[0].Attempts != 3 || deadLetters[0].Error == "" {
+		t.Fatalf("Expected event 1 to be dead-lettered after 3 attempts, got %+v", deadLetters)
+	}
+	if db.eventState.CurrentEventId != 1 {
+		t.Errorf("Expected the current event ID to advance past the dead letter, got %d", db.eventState.CurrentEventId)
+	}
+	if db.EventErrorCount() != 0 {
+		t.Errorf("Expected the event errors to be cleared, got %+v", db.EventErrors())
+	}
+
+	// The events after it are applied
+	if err := db.HandleEvent(2, "Counter:Increment", []byte(`{}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+
+	// Once the handler is fixed the event can be redriven
+	fail = false
+	if err := db.RedriveDeadLetter(1); err != nil {
+		t.Fatalf("RedriveDeadLetter returned error: %v", err)
+	}
+	var value int
+	db.GetDB().Get(&value, `SELECT value FROM counter WHERE id = 0`)
+	if value != 101 {
+		t.Errorf("Expected counter to be 101, got %d", value)
+	}
+	if count, _ := db.DeadLetterCount(); count != 0 {
+		t.Errorf("Expected no dead letters after redriving, got %d", count)
+	}
+	var logged int
+	db.GetDB().Get(&logged, `SELECT COUNT(*) FROM event_log`)
+	if logged != 2 {
+		t.Errorf("Expected both events in the event log, got %d", logged)
+	}
+	if err := db.RedriveDeadLetter(1); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("Expected ErrDeadLetterNotFound, got %v", err)
+	}
+}
+
+func TestRedriveFailureKeepsDeadLetter(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	db.SetDeadLetterAfter(1)
+	fail := true
+	addPoisonHandler(db, &fail)
+
+	if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the event to be dead-lettered, got %v", err)
+	}
+	if err := db.RedriveDeadLetter(1); err == nil {
+		t.Fatal("Expected redriving to fail")
+	}
+	deadLetters, _ := db.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 2 {
+		t.Errorf("Expected the dead letter to remain with 2 attempts, got %+v", deadLetters)
+	}
+}
+
+func TestDeadLetterDisabled(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	db.SetDeadLetterAfter(0)
+	fail := true
+	addPoisonHandler(db, &fail)
+
+	for attempt := 1; attempt <= DefaultDeadLetterAfter+1; attempt++ {
+		if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err == nil {
+			t.Fatalf("Expected attempt %d to fail", attempt)
+		}
+	}
+	if count, _ := db.DeadLetterCount(); count != 0 {
+		t.Errorf("Expected no dead letters when disabled, got %d", count)
+	}
+	if db.eventState.CurrentEventId != 0 {
+		t.Errorf("Expected the failing event to block, got current event ID %d", db.eventState.CurrentEventId)
+	}
+}
+
+func TestRejectedEventDeadLetteredImmediately(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	db.SetDeadLetterAfter(0)
+	AddGenericEventHandlerWithMode(db, "Counter:Poison", ApplyLive, func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		return false, RejectEvent(errors.New("invalid"))
+	})
+
+	if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the rejected event to be dead-lettered, got %v", err)
+	}
+	deadLetters, _ := db.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 1 {
+		t.Errorf("Expected one dead letter after 1 attempt, got %+v", deadLetters)
+	}
+	if db.eventState.CurrentEventId != 1 {
+		t.Errorf("Expected the current event ID to advance past the rejected event, got %d", db.eventState.CurrentEventId)
+	}
+}
+
+func TestBusyDeadLetterDoesNotAdvanceEventId(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+	AddGenericEventHandlerWithMode(db, "Counter:Poison", ApplyLive, func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		return false, RejectEvent(errors.New("invalid"))
+	})
+
+	// A reader keeps the dead letter from committing
+	reader := other.MustBegin()
+	var value int
+	if err := reader.Get(&value, `SELECT value FROM counter WHERE id = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err == nil {
+		t.Fatal("Expected an error when the dead letter cannot be saved")
+	}
+	if db.eventState.CurrentEventId != 0 {
+		t.Fatalf("Expected the event ID to stay at 0, got %d", db.eventState.CurrentEventId)
+	}
+	reader.Rollback()
+
+	// The redelivered event is dead-lettered rather than skipped
+	if err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the redelivered event to be dead-lettered, got %v", err)
+	}
+	if count, _ := db.DeadLetterCount(); count != 1 || db.eventState.CurrentEventId != 1 {
+		t.Errorf("Expected one dead letter and event ID 1, got %d and event ID %d", count, db.eventState.CurrentEventId)
+	}
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// DryRunEvent is an event to apply with DryRunEvents.
+type DryRunEvent struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// DryRunEvents applies events in order as they would be applied live, then
+// rolls everything back, returning the outcome of each event. Each event sees
+// the changes of the events accepted before it; a rejected event's changes
+// are undone before the next one. Nothing is written to the event log and
+// the current event ID does not change.
+//
+// Handlers must only change the database, deferring any other side effects
+// to an outbox table processed after commit, so that they vanish with the
+// rollback. Handlers with direct side effects are not supported in dry runs.
+func (db *Database) DryRunEvents(events []DryRunEvent) ([]types.DryRunOutcome, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, wrapBusyError(err)
+	}
+	defer tx.Rollback()
+
+	outcomes := make([]types.DryRunOutcome, len(events))
+	for i, event := range events {
+		if _, err := tx.Exec(`SAVEPOINT dry_run_event`); err != nil {
+			return nil, wrapBusyError(err)
+		}
+		changed, err := db.dryRunEvent(tx, event)
+		if err := wrapBusyError(err); IsRetryable(err) {
+			// A busy database says nothing about the event
+			return nil, err
+		}
+		switch {
+		case err != nil:
+			outcomes[i] = types.DryRunOutcome{Status: types.DryRunRejected, Error: err.Error()}
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT dry_run_event`); err != nil {
+				return nil, wrapBusyError(err)
+			}
+		case changed:
+			outcomes[i] = types.DryRunOutcome{Status: types.DryRunAccepted}
+		default:
+			outcomes[i] = types.DryRunOutcome{Status: types.DryRunNoop}
+		}
+		if _, err := tx.Exec(`RELEASE SAVEPOINT dry_run_event`); err != nil {
+			return nil, wrapBusyError(err)
+		}
+	}
+	return outcomes, nil
+}
+
+// dryRunEvent runs the live handlers for an event in the transaction,
+// reporting whether any changed state.
+func (db *Database) dryRunEvent(tx *sqlx.Tx, event DryRunEvent) (bool, error) {
+	handlerData, _, err := db.openEvent(tx, event.Type, []byte(event.Data))
+	if err != nil {
+		return false, err
+	}
+	return db.runHandlersChanged(tx, event.Type, handlerData, false)
+}
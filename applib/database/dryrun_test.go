@@ -0,0 +1,82 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+type itemEvent struct {
+	Name string `json:"name"`
+}
+
+func TestDryRunEventsLeavesStateUnchanged(t *testing.T) {
+	db, _ := setupTestDatabase(t)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("initializeSchema returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE items (name TEXT PRIMARY KEY)`)
+	AddEventHandler(db, "Item:Add", func(tx *sqlx.Tx, event itemEvent) (bool, error) {
+		var count int
+		if err := tx.Get(&count, `SELECT COUNT(*) FROM items WHERE name = $1`, event.Name); err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return false, RejectEvent(errors.New("duplicate item " + event.Name))
+		}
+		_, err := tx.Exec(`INSERT INTO items (name) VALUES ($1)`, event.Name)
+		return err == nil, err
+	})
+	AddEventHandler(db, "Item:Touch", func(tx *sqlx.Tx, event itemEvent) (bool, error) {
+		return false, nil
+	})
+	if err := db.HandleEvent(1, "Item:Add", []byte(`{"name":"a"}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+
+	outcomes, err := db.DryRunEvents([]DryRunEvent{
+		{Type: "Item:Add", Data: `{"name":"b"}`},
+		{Type: "Item:Add", Data: `{"name":"a"}`},
+		// Later events see the ones accepted before them
+		{Type: "Item:Add", Data: `{"name":"b"}`},
+		{Type: "Item:Touch", Data: `{"name":"b"}`},
+		{Type: "Item:Unknown", Data: `{}`},
+		{Type: "Item:Add", Data: `not json`},
+		{Type: "Item:Add", Data: `{"name":"c"}`},
+	})
+	if err != nil {
+		t.Fatalf("DryRunEvents returned error: %v", err)
+	}
+	expected := []types.DryRunStatus{
+		types.DryRunAccepted, types.DryRunRejected, types.DryRunRejected,
+		types.DryRunNoop, types.DryRunNoop, types.DryRunRejected, types.DryRunAccepted,
+	}
+	if len(outcomes) != len(expected) {
+		t.Fatalf("Expected %d outcomes, got %+v", len(expected), outcomes)
+	}
+	for i, outcome := range outcomes {
+		if outcome.Status != expected[i] {
+			t.Errorf("Event %d: expected %s, got %+v", i, expected[i], outcome)
+		}
+		if (outcome.Status == types.DryRunRejected) != (outcome.Error != "") {
+			t.Errorf("Event %d: expected an error only for rejections, got %+v", i, outcome)
+		}
+	}
+	if !strings.Contains(outcomes[1].Error, "duplicate item a") {
+		t.Errorf("Expected the handler error, got %q", outcomes[1].Error)
+	}
+
+	var names []string
+	db.GetDB().Select(&names, `SELECT name FROM items`)
+	if len(names) != 1 || names[0] != "a" {
+		t.Errorf("Expected no items to be added, got %v", names)
+	}
+	var logged int
+	db.GetDB().Get(&logged, `SELECT COUNT(*) FROM event_log`)
+	if logged != 1 || db.eventState.CurrentEventId != 1 {
+		t.Errorf("Expected the event log to be unchanged, got %d events and current event %d", logged, db.eventState.CurrentEventId)
+	}
+}
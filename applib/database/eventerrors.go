@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// eventErrorLogInterval is the minimum time between log lines for the same
+// event error. The hub resends a failing event on every request to the
+// instance, so without this a poison event floods the log.
+const eventErrorLogInterval = time.Minute
+
+// EventHandlerError is returned by HandleEvent when an event handler fails.
+type EventHandlerError struct {
+	EventType string
+	// Handler is the name of the function registered with AddEventHandler or
+	// AddGenericEventHandler.
+	Handler string
+	Err     error
+}
+
+func (e *EventHandlerError) Error() string {
+	return fmt.Sprintf("handler %s failed on %s event: %v", e.Handler, e.EventType, e.Err)
+}
+
+func (e *EventHandlerError) Unwrap() error {
+	return e.Err
+}
+
+// EventError is a failure to apply an event, deduplicated across attempts.
+type EventError struct {
+	EventID   int    `json:"eventId"`
+	EventType string `json:"eventType"`
+	// Handler is empty if the event failed outside its handlers, e.g. when
+	// the transaction could not be committed.
+	Handler   string    `json:"handler,omitempty"`
+	Error     string    `json:"error"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+type eventErrorKey struct {
+	eventID int
+	handler string
+}
+
+// eventErrorStore records events that failed to apply until they succeed.
+type eventErrorStore struct {
+	mu      sync.Mutex
+	entries map[eventErrorKey]*eventErrorEntry
+	now     func() time.Time
+}
+
+type eventErrorEntry struct {
+	EventError
+	lastLogged time.Time
+	// suppressed counts the failures not logged since lastLogged
+	suppressed int
+}
+
+func newEventErrorStore() *eventErrorStore {
+	return &eventErrorStore{
+		entries: make(map[eventErrorKey]*eventErrorEntry),
+		now:     time.Now,
+	}
+}
+
+// record adds a failed attempt to apply an event, logging it unless the same
+// error was logged within eventErrorLogInterval. It returns the number of
+// failed attempts recorded for the event.
+func (s *eventErrorStore) record(eventID int, eventType string, err error) int {
+	var handler string
+	var handlerErr *EventHandlerError
+	if errors.As(err, &handlerErr) {
+		handler = handlerErr.Handler
+	}
+	key := eventErrorKey{eventID: eventID, handler: handler}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &eventErrorEntry{EventError: EventError{EventID: eventID, EventType: eventType, Handler: handler, FirstSeen: now}}
+		s.entries[key] = entry
+	}
+	entry.Count++
+	entry.Error = err.Error()
+	entry.LastSeen = now
+
+	attempts := 0
+	for key, entry := range s.entries {
+		if key.eventID == eventID {
+			attempts += entry.Count
+		}
+	}
+
+	if !entry.lastLogged.IsZero() && now.Sub(entry.lastLogged) < eventErrorLogInterval {
+		entry.suppressed++
+		return attempts
+	}
+	if entry.suppressed > 0 {
+		logf(slog.LevelError, "Failed to apply event %d (%d times, %d not logged): %v", eventID, entry.Count, entry.suppressed, err)
+	} else {
+		logf(slog.LevelError, "Failed to apply event %d: %v", eventID, err)
+	}
+	entry.lastLogged = now
+	entry.suppressed = 0
+	return attempts
+}
+
+// clear forgets every error recorded for the event.
+func (s *eventErrorStore) clear(eventID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if key.eventID == eventID {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *eventErrorStore) list() []EventError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]EventError, 0, len(s.entries))
+	for _, entry := range s.entries {
+		list = append(list, entry.EventError)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].EventID != list[j].EventID {
+			return list[i].EventID < list[j].EventID
+		}
+		return list[i].Handler < list[j].Handler
+	})
+	return list
+}
+
+func (s *eventErrorStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// EventErrors returns the events that failed to apply and have not succeeded
+// since, ordered by event ID.
+func (db *Database) EventErrors() []EventError {
+	return db.eventErrors.list()
+}
+
+// EventErrorCount returns the number of distinct event errors, as listed by
+// EventErrors.
+func (db *Database) EventErrorCount() int {
+	return db.eventErrors.count()
+}
+
+// handlerName returns the name of a handler function for error reports.
+func handlerName(handler any) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
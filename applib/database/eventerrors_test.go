@@ -0,0 +1,97 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func failingHandler(tx *sqlx.Tx, _ []byte) (bool, error) {
+	return false, errors.New("poison event")
+}
+
+func TestEventErrorsDeduplicate(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	AddGenericEventHandler(db, "Counter:Poison", failingHandler)
+
+	var logs bytes.Buffer
+	original := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(original)
+
+	for i := 0; i < 3; i++ {
+		err := db.HandleEvent(1, "Counter:Poison", []byte(`{}`))
+		var handlerErr *EventHandlerError
+		if !errors.As(err, &handlerErr) || !strings.HasSuffix(handlerErr.Handler, ".failingHandler") {
+			t.Fatalf("Expected an EventHandlerError naming the handler, got %v", err)
+		}
+	}
+
+	eventErrors := db.EventErrors()
+	if len(eventErrors) != 1 {
+		t.Fatalf("Expected 1 deduplicated error, got %+v", eventErrors)
+	}
+	if eventErrors[0].EventID != 1 || eventErrors[0].Count != 3 || eventErrors[0].EventType != "Counter:Poison" {
+		t.Errorf("Unexpected event error %+v", eventErrors[0])
+	}
+	if lines := strings.Count(logs.String(), "Failed to apply event 1"); lines != 1 {
+		t.Errorf("Expected 1 log line for repeated failures, got %d:\n%s", lines, logs.String())
+	}
+}
+
+func TestEventErrorLogRateLimit(t *testing.T) {
+	store := newEventErrorStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	var logs bytes.Buffer
+	original := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(original)
+
+	err := errors.New("boom")
+	store.record(7, "Type", err)
+	store.record(7, "Type", err)
+	store.record(8, "Type", err)
+	now = now.Add(eventErrorLogInterval)
+	store.record(7, "Type", err)
+
+	if got := strings.Count(logs.String(), "Failed to apply event 7"); got != 2 {
+		t.Errorf("Expected 2 log lines for event 7, got %d:\n%s", got, logs.String())
+	}
+	if !strings.Contains(logs.String(), "(3 times, 1 not logged)") {
+		t.Errorf("Expected the suppressed count to be logged, got:\n%s", logs.String())
+	}
+	if store.count() != 2 {
+		t.Errorf("Expected 2 distinct errors, got %d", store.count())
+	}
+}
+
+func TestEventErrorsClearedOnSuccess(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	fail := true
+	AddGenericEventHandler(db, "Counter:Flaky", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		if fail {
+			return false, errors.New("not yet")
+		}
+		return true, nil
+	})
+
+	db.HandleEvent(1, "Counter:Flaky", []byte(`{}`))
+	if db.EventErrorCount() != 1 {
+		t.Fatalf("Expected 1 event error, got %d", db.EventErrorCount())
+	}
+
+	fail = false
+	if err := db.HandleEvent(1, "Counter:Flaky", []byte(`{}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+	if db.EventErrorCount() != 0 {
+		t.Errorf("Expected the error to be cleared, got %+v", db.EventErrors())
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// GroupEvent is one event of a group published atomically, see
+// HandleEventGroup.
+type GroupEvent struct {
+	ID   int
+	Type string
+	Data []byte
+}
+
+// EventGroupError reports which event of a group failed to apply. None of
+// the group's events were applied.
+type EventGroupError struct {
+	EventID   int
+	EventType string
+	Err       error
+}
+
+func (e *EventGroupError) Error() string {
+	return fmt.Sprintf("event %d (%s) of group: %v", e.EventID, e.EventType, e.Err)
+}
+
+func (e *EventGroupError) Unwrap() error {
+	return e.Err
+}
+
+// HandleEventGroup applies a group of events published atomically, in order.
+// Their handlers all run in a single transaction, so either every event of
+// the group is applied or, if any handler fails, none is and the current
+// event ID stays before the group. groupId identifies the group, and is the
+// ID of its first event as published, which may be one the application does
+// not subscribe to.
+//
+// Like HandleEvent, the group is applied again while the database is busy,
+// and failures are recorded in EventErrors against the event that failed. A
+// group that keeps failing, or that a handler rejects, is dead-lettered as a
+// whole, and redriving any of its events redrives them all.
+func (db *Database) HandleEventGroup(groupId int, events []GroupEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	for _, event := range events {
+		db.noteEventId(event.ID)
+	}
+	if events[len(events)-1].ID <= db.eventState.CurrentEventId {
+		return nil // Already applied
+	}
+
+	err := retryWhileBusy(context.Background(), fmt.Sprintf("event group %d", groupId), func() error {
+		return db.applyEventGroup(events)
+	})
+	if err != nil {
+		failed := EventGroupError{EventID: events[0].ID, EventType: events[0].Type}
+		var groupErr *EventGroupError
+		if errors.As(err, &groupErr) {
+			failed = *groupErr
+		}
+		attempts := db.eventErrors.record(failed.EventID, failed.EventType, err)
+		if !IsRejected(err) && (IsRetryable(err) || db.deadLetterAfter <= 0 || attempts < db.deadLetterAfter) {
+			return err
+		}
+		if dlErr := db.deadLetterEvents(groupId, events, err, attempts); dlErr != nil {
+			logf(slog.LevelError, "Failed to dead-letter event group %d: %v", groupId, dlErr)
+			return err
+		}
+	}
+	for _, event := range events {
+		db.eventErrors.clear(event.ID)
+	}
+	return nil
+}
+
+// applyEventGroup runs the handlers for all events of a group in a single
+// transaction.
+func (db *Database) applyEventGroup(events []GroupEvent) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return wrapBusyError(err)
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		if err := db.applyEventTx(tx, event.ID, event.Type, event.Data, false); err != nil {
+			return wrapBusyError(&EventGroupError{EventID: event.ID, EventType: event.Type, Err: err})
+		}
+	}
+	lastId := events[len(events)-1].ID
+	if err := saveCurrentEventId(tx, lastId); err != nil {
+		return wrapBusyError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return wrapBusyError(err)
+	}
+	db.eventState.CurrentEventId = lastId
+	return nil
+}
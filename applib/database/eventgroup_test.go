@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func counterValue(t *testing.T, db *Database) int {
+	t.Helper()
+	var value int
+	if err := db.GetDB().Get(&value, `SELECT value FROM counter WHERE id = 0`); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func eventLogCount(t *testing.T, db *Database) int {
+	t.Helper()
+	var count int
+	if err := db.GetDB().Get(&count, `SELECT COUNT(*) FROM event_log`); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestEventGroupFailureLeavesNoTrace(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	db.SetDeadLetterAfter(2)
+	fail := true
+	addPoisonHandler(db, &fail)
+
+	group := []GroupEvent{
+		{ID: 1, Type: "Counter:Increment", Data: []byte(`{}`)},
+		{ID: 2, Type: "Counter:Poison", Data: []byte(`{}`)},
+		{ID: 3, Type: "Counter:Increment", Data: []byte(`{}`)},
+	}
+	err := db.HandleEventGroup(1, group)
+	var groupErr *EventGroupError
+	if !errors.As(err, &groupErr) || groupErr.EventID != 2 {
+		t.Fatalf("Expected event 2 of the group to fail, got %v", err)
+	}
+	if value := counterValue(t, db); value != 0 {
+		t.Errorf("Expected the group's first event not to be applied, got counter %d", value)
+	}
+	if count := eventLogCount(t, db); count != 0 {
+		t.Errorf("Expected no events in the event log, got %d", count)
+	}
+	if db.eventState.CurrentEventId != 0 {
+		t.Errorf("Expected the current event ID to stay before the group, got %d", db.eventState.CurrentEventId)
+	}
+	if eventErrors := db.EventErrors(); len(eventErrors) != 1 || eventErrors[0].EventID != 2 {
+		t.Errorf("Expected the error to be recorded against event 2, got %+v", eventErrors)
+	}
+
+	// The second failure dead-letters the whole group
+	if err := db.HandleEventGroup(1, group); err != nil {
+		t.Fatalf("Expected the group to be dead-lettered, got %v", err)
+	}
+	deadLetters, err := db.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 3 {
+		t.Fatalf("Expected the group's 3 events to be dead-lettered, got %+v", deadLetters)
+	}
+	for _, deadLetter := range deadLetters {
+		if deadLetter.GroupID != 1 || deadLetter.Attempts != 2 {
+			t.Errorf("Expected a dead letter of group 1 after 2 attempts, got %+v", deadLetter)
+		}
+	}
+	if db.eventState.CurrentEventId != 3 {
+		t.Errorf("Expected the current event ID to advance past the group, got %d", db.eventState.CurrentEventId)
+	}
+	if value := counterValue(t, db); value != 0 {
+		t.Errorf("Expected nothing to be applied, got counter %d", value)
+	}
+
+	// Redriving any event of the group redrives all of it
+	fail = false
+	if err := db.RedriveDeadLetter(3); err != nil {
+		t.Fatalf("RedriveDeadLetter returned error: %v", err)
+	}
+	if value := counterValue(t, db); value != 102 {
+		t.Errorf("Expected counter to be 102, got %d", value)
+	}
+	if count, _ := db.DeadLetterCount(); count != 0 {
+		t.Errorf("Expected no dead letters after redriving, got %d", count)
+	}
+	if count := eventLogCount(t, db); count != 3 {
+		t.Errorf("Expected the group's events in the event log, got %d", count)
+	}
+}
+
+func TestEventGroupAppliedInOrder(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	AddGenericEventHandler(db, "Counter:Increment", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return true, err
+	})
+	AddGenericEventHandler(db, "Counter:Double", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		_, err := tx.Exec(`UPDATE counter SET value = value * 2 WHERE id = 0`)
+		return true, err
+	})
+
+	// Each event sees the state left by the ones before it
+	err := db.HandleEventGroup(1, []GroupEvent{
+		{ID: 1, Type: "Counter:Increment", Data: []byte(`{}`)},
+		{ID: 2, Type: "Counter:Double", Data: []byte(`{}`)},
+		{ID: 3, Type: "Counter:Increment", Data: []byte(`{}`)},
+		{ID: 4, Type: "Counter:Double", Data: []byte(`{}`)},
+	})
+	if err != nil {
+		t.Fatalf("HandleEventGroup returned error: %v", err)
+	}
+	if value := counterValue(t, db); value != 6 {
+		t.Errorf("Expected counter to be ((0+1)*2+1)*2 = 6, got %d", value)
+	}
+	var logged []int
+	if err := db.GetDB().Select(&logged, `SELECT id FROM event_log ORDER BY id`); err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 4 || logged[0] != 1 || logged[1] != 2 || logged[2] != 3 || logged[3] != 4 {
+		t.Errorf("Expected events 1-4 logged in order, got %v", logged)
+	}
+	if db.eventState.CurrentEventId != 4 {
+		t.Errorf("Expected the current event ID to be 4, got %d", db.eventState.CurrentEventId)
+	}
+
+	// A group that was already applied is skipped
+	if err := db.HandleEventGroup(1, []GroupEvent{{ID: 1, Type: "Counter:Increment", Data: []byte(`{}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if value := counterValue(t, db); value != 6 {
+		t.Errorf("Expected an applied group not to be applied again, got counter %d", value)
+	}
+}
+
+func TestBusyEventGroupDoesNotAdvanceEventId(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+	fail := false
+	addPoisonHandler(db, &fail)
+	group := []GroupEvent{
+		{ID: 1, Type: "Counter:Increment", Data: []byte(`{}`)},
+		{ID: 2, Type: "Counter:Increment", Data: []byte(`{}`)},
+	}
+
+	// A reader keeps the group's transaction from committing
+	reader := other.MustBegin()
+	var value int
+	if err := reader.Get(&value, `SELECT value FROM counter WHERE id = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HandleEventGroup(1, group); !IsRetryable(err) {
+		t.Fatalf("Expected a retryable error, got %v", err)
+	}
+	if db.eventState.CurrentEventId != 0 {
+		t.Fatalf("Expected the rolled back group to leave the event ID at 0, got %d", db.eventState.CurrentEventId)
+	}
+	reader.Rollback()
+
+	// The redelivered group is applied rather than skipped
+	if err := db.HandleEventGroup(1, group); err != nil {
+		t.Fatalf("Expected the redelivered group to be applied, got %v", err)
+	}
+	if value := counterValue(t, db); value != 2 || db.eventState.CurrentEventId != 2 {
+		t.Errorf("Expected counter 2 and event ID 2, got counter %d and event ID %d", value, db.eventState.CurrentEventId)
+	}
+}
@@ -0,0 +1,273 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime/debug"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// EventLogFormat is the version of the export format written by ExportEvents.
+const EventLogFormat = 1
+
+// EventLogHeader is the first record of an exported event log.
+type EventLogHeader struct {
+	Format int `json:"format"`
+	// SchemaEpoch is the schema version of the exporting application. Events
+	// can be imported into the same or a newer schema, whose handlers are
+	// expected to understand older events.
+	SchemaEpoch int    `json:"schemaEpoch"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	EventCount  int    `json:"eventCount"`
+	// ContentHash is the hex SHA-256 of the event records, each followed by a
+	// newline, exactly as they appear in the export.
+	ContentHash string `json:"contentHash"`
+}
+
+// LoggedEvent is an event applied to the application, as recorded in the
+// event log and exported after the header.
+type LoggedEvent struct {
+	ID   int             `db:"id" json:"id"`
+	Type string          `db:"event_type" json:"type"`
+	Data json.RawMessage `db:"event_data" json:"data"`
+}
+
+// EventLogNotEmptyError is returned by ImportEvents when the database has
+// already applied events and the import was not forced.
+type EventLogNotEmptyError struct {
+	CurrentEventId int
+}
+
+func (e *EventLogNotEmptyError) Error() string {
+	return fmt.Sprintf("database has already applied events up to ID %d; refusing to import without force", e.CurrentEventId)
+}
+
+// ErrEventLogCorrupt is returned by ImportEvents when the stream does not
+// match its header, for instance because it was truncated.
+var ErrEventLogCorrupt = errors.New("event log does not match its header")
+
+const eventLogSchema = `
+CREATE TABLE IF NOT EXISTS event_log (
+	id INTEGER PRIMARY KEY,
+	event_type TEXT NOT NULL,
+	event_data TEXT NOT NULL
+)`
+
+const insertEventLogSql = `INSERT OR REPLACE INTO event_log (id, event_type, event_data) VALUES ($1, $2, $3)`
+
+// SetAppVersion sets the application version recorded in exported event
+// logs. It defaults to the VCS revision the binary was built from, if known.
+func (db *Database) SetAppVersion(version string) {
+	db.appVersion = version
+}
+
+func (db *Database) getAppVersion() string {
+	if db.appVersion != "" {
+		return db.appVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+// forEachLoggedEvent calls fn with every logged event, in order. Personal
+// data is opened as the handlers see it: in the clear, or as a tombstone once
+// the user is forgotten.
+func (db *Database) forEachLoggedEvent(tx *sqlx.Tx, fn func(event LoggedEvent) error) error {
+	return db.forEachLoggedEventUpTo(tx, math.MaxInt, fn)
+}
+
+// forEachLoggedEventUpTo is like forEachLoggedEvent but stops after the
+// event with ID lastEventId.
+func (db *Database) forEachLoggedEventUpTo(tx *sqlx.Tx, lastEventId int, fn func(event LoggedEvent) error) error {
+	rows, err := tx.Queryx(`SELECT id, event_type, event_data FROM event_log WHERE id <= $1 ORDER BY id`, lastEventId)
+	if err != nil {
+		return err
+	}
+	var events []LoggedEvent
+	for rows.Next() {
+		var event LoggedEvent
+		var data string
+		if err := rows.Scan(&event.ID, &event.Type, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		event.Data = json.RawMessage(data)
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		opened, _, err := db.openEvent(tx, event.Type, event.Data)
+		if err != nil {
+			return fmt.Errorf("failed to open event %d: %w", event.ID, err)
+		}
+		event.Data = opened
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeLoggedEvent encodes an event as a record of an export, followed by a
+// newline.
+func encodeLoggedEvent(event LoggedEvent) ([]byte, error) {
+	record, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event %d: %w", event.ID, err)
+	}
+	return append(record, '\n'), nil
+}
+
+// ExportEvents writes the event log as newline-delimited JSON: an
+// EventLogHeader followed by one LoggedEvent per line. Events applied before
+// the event log was introduced are not included. Personal data is exported in
+// the clear, since the keys sealing it stay behind, and is sealed again on
+// import; the data of forgotten users is exported as tombstones.
+func (db *Database) ExportEvents(w io.Writer) error {
+	// Both passes read the same snapshot, so the header matches the events
+	// even if new ones arrive during the export
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	header := EventLogHeader{
+		Format:      EventLogFormat,
+		SchemaEpoch: db.schemaVersion,
+		AppVersion:  db.getAppVersion(),
+	}
+	contentHash := sha256.New()
+	err = db.forEachLoggedEvent(tx, func(event LoggedEvent) error {
+		record, err := encodeLoggedEvent(event)
+		if err != nil {
+			return err
+		}
+		header.EventCount++
+		contentHash.Write(record)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read event log: %w", err)
+	}
+	header.ContentHash = hex.EncodeToString(contentHash.Sum(nil))
+
+	out := bufio.NewWriter(w)
+	if err := json.NewEncoder(out).Encode(header); err != nil {
+		return err
+	}
+	err = db.forEachLoggedEvent(tx, func(event LoggedEvent) error {
+		record, err := encodeLoggedEvent(event)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(record)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// ImportEvents reads an export written by ExportEvents, verifies it against
+// its header and replays the events through the registered handlers to
+// rebuild the application's state. Handlers added with ApplyLive are skipped
+// and those added with ApplyReplay run. Nothing is applied unless the whole
+// stream verifies.
+//
+// Importing into a database that has already applied events fails with an
+// EventLogNotEmptyError unless force is set, in which case events at or below
+// the current event ID are skipped. It returns the number of events applied.
+func (db *Database) ImportEvents(r io.Reader, force bool) (int, error) {
+	if db.eventState.CurrentEventId > 0 && !force {
+		return 0, &EventLogNotEmptyError{CurrentEventId: db.eventState.CurrentEventId}
+	}
+
+	header, events, err := readEventLog(r)
+	if err != nil {
+		return 0, err
+	}
+	if header.SchemaEpoch > db.schemaVersion {
+		return 0, &SchemaVersionError{Stored: header.SchemaEpoch, Expected: db.schemaVersion}
+	}
+
+	applied := 0
+	for _, event := range events {
+		if event.ID <= db.eventState.CurrentEventId {
+			continue
+		}
+		if err := db.handleEvent(event.ID, event.Type, event.Data, true); err != nil {
+			return applied, fmt.Errorf("failed to replay event %d: %w", event.ID, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// readEventLog parses and verifies an exported event log.
+func readEventLog(r io.Reader) (*EventLogHeader, []LoggedEvent, error) {
+	reader := bufio.NewReader(r)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read event log header: %w", err)
+	}
+	var header EventLogHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid event log header: %w", err)
+	}
+	if header.Format != EventLogFormat {
+		return nil, nil, fmt.Errorf("unsupported event log format %d", header.Format)
+	}
+
+	var events []LoggedEvent
+	contentHash := sha256.New()
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			contentHash.Write(line)
+			var event LoggedEvent
+			if err := json.Unmarshal(bytes.TrimSpace(line), &event); err != nil {
+				return nil, nil, fmt.Errorf("%w: invalid event record: %v", ErrEventLogCorrupt, err)
+			}
+			if len(events) > 0 && event.ID <= events[len(events)-1].ID {
+				return nil, nil, fmt.Errorf("%w: event %d is out of order", ErrEventLogCorrupt, event.ID)
+			}
+			events = append(events, event)
+		} else if len(line) > 0 {
+			return nil, nil, fmt.Errorf("%w: incomplete final record", ErrEventLogCorrupt)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read event log: %w", err)
+		}
+	}
+
+	if len(events) != header.EventCount {
+		return nil, nil, fmt.Errorf("%w: expected %d events, found %d", ErrEventLogCorrupt, header.EventCount, len(events))
+	}
+	if hex.EncodeToString(contentHash.Sum(nil)) != header.ContentHash {
+		return nil, nil, fmt.Errorf("%w: content hash mismatch", ErrEventLogCorrupt)
+	}
+	return &header, events, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type itemAdded struct {
+	Name string `json:"name"`
+}
+
+// setupItemsDatabase returns an initialized database whose items table is
+// built from ItemAdded events.
+func setupItemsDatabase(t *testing.T) *Database {
+	db, _ := setupTestDatabase(t)
+	db.SetAppVersion("test-version")
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("initializeSchema returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE items (name TEXT)`)
+	AddEventHandler(db, "ItemAdded", func(tx *sqlx.Tx, event itemAdded) (bool, error) {
+		_, err := tx.Exec(`INSERT INTO items (name) VALUES ($1)`, event.Name)
+		return true, err
+	})
+	return db
+}
+
+func itemNames(t *testing.T, db *Database) []string {
+	var names []string
+	if err := db.GetDB().Select(&names, `SELECT name FROM items ORDER BY rowid`); err != nil {
+		t.Fatalf("Failed to read items: %v", err)
+	}
+	return names
+}
+
+// exportFixture applies a few events to a fresh database and exports them.
+func exportFixture(t *testing.T) []byte {
+	source := setupItemsDatabase(t)
+	for i, name := range []string{"apple", "banana", "cherry"} {
+		// Event IDs are global to the hub, so an instance sees gaps
+		if err := source.HandleEvent(10*(i+1), "ItemAdded", []byte(`{"name":"`+name+`"}`)); err != nil {
+			t.Fatalf("HandleEvent returned error: %v", err)
+		}
+	}
+	var export bytes.Buffer
+	if err := source.ExportEvents(&export); err != nil {
+		t.Fatalf("ExportEvents returned error: %v", err)
+	}
+	return export.Bytes()
+}
+
+func TestEventLogRoundTrip(t *testing.T) {
+	export := exportFixture(t)
+	header := strings.SplitN(string(export), "\n", 2)[0]
+	if !strings.Contains(header, `"eventCount":3`) || !strings.Contains(header, `"appVersion":"test-version"`) {
+		t.Errorf("Unexpected header %s", header)
+	}
+
+	dest := setupItemsDatabase(t)
+	applied, err := dest.ImportEvents(bytes.NewReader(export), false)
+	if err != nil {
+		t.Fatalf("ImportEvents returned error: %v", err)
+	}
+	if applied != 3 || dest.eventState.CurrentEventId != 30 {
+		t.Errorf("Expected 3 events applied up to ID 30, got %d up to %d", applied, dest.eventState.CurrentEventId)
+	}
+	if names := itemNames(t, dest); strings.Join(names, ",") != "apple,banana,cherry" {
+		t.Errorf("Expected state rebuilt by the handlers, got %v", names)
+	}
+
+	// The imported database exports the same log
+	var reexport bytes.Buffer
+	if err := dest.ExportEvents(&reexport); err != nil {
+		t.Fatalf("ExportEvents returned error: %v", err)
+	}
+	if !bytes.Equal(export, reexport.Bytes()) {
+		t.Errorf("Expected identical exports:\n%s\n%s", export, reexport.Bytes())
+	}
+}
+
+func TestImportRejectsCorruptStreams(t *testing.T) {
+	export := exportFixture(t)
+	lines := strings.SplitAfter(string(export), "\n")
+
+	corrupt := map[string]string{
+		"missing last event": strings.Join(lines[:len(lines)-2], ""),
+		"cut mid-record":     string(export[:len(export)-10]),
+		"tampered event":     strings.Replace(string(export), "banana", "bandana", 1),
+		"unsupported format": strings.Replace(string(export), `"format":1`, `"format":2`, 1),
+		"newer schema epoch": strings.Replace(string(export), `"schemaEpoch":0`, `"schemaEpoch":5`, 1),
+		"header only":        lines[0],
+	}
+	for name, stream := range corrupt {
+		dest := setupItemsDatabase(t)
+		if _, err := dest.ImportEvents(strings.NewReader(stream), false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if names := itemNames(t, dest); len(names) != 0 || dest.eventState.CurrentEventId != 0 {
+			t.Errorf("%s: expected nothing applied, got %v", name, names)
+		}
+	}
+
+	dest := setupItemsDatabase(t)
+	_, err := dest.ImportEvents(strings.NewReader(strings.Join(lines[:len(lines)-2], "")), false)
+	if !errors.Is(err, ErrEventLogCorrupt) {
+		t.Errorf("Expected ErrEventLogCorrupt for a truncated stream, got %v", err)
+	}
+}
+
+func TestImportIntoNonEmptyLog(t *testing.T) {
+	export := exportFixture(t)
+	dest := setupItemsDatabase(t)
+	if err := dest.HandleEvent(20, "ItemAdded", []byte(`{"name":"local"}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+
+	var notEmpty *EventLogNotEmptyError
+	if _, err := dest.ImportEvents(bytes.NewReader(export), false); !errors.As(err, &notEmpty) {
+		t.Fatalf("Expected EventLogNotEmptyError, got %v", err)
+	}
+
+	// Forcing skips the events the database has already seen
+	applied, err := dest.ImportEvents(bytes.NewReader(export), true)
+	if err != nil {
+		t.Fatalf("Forced ImportEvents returned error: %v", err)
+	}
+	if names := itemNames(t, dest); applied != 1 || strings.Join(names, ",") != "local,cherry" {
+		t.Errorf("Expected only event 30 applied, got %d applied and %v", applied, names)
+	}
+}
+
+func TestImportRunsReplayHandlers(t *testing.T) {
+	export := exportFixture(t)
+	dest := setupItemsDatabase(t)
+	var live, replayed []string
+	AddEventHandlerWithMode(dest, "ItemAdded", ApplyLive, func(tx *sqlx.Tx, event itemAdded) (bool, error) {
+		live = append(live, event.Name)
+		return false, nil
+	})
+	AddEventHandlerWithMode(dest, "ItemAdded", ApplyReplay, func(tx *sqlx.Tx, event itemAdded) (bool, error) {
+		replayed = append(replayed, event.Name)
+		return false, nil
+	})
+
+	if _, err := dest.ImportEvents(bytes.NewReader(export), false); err != nil {
+		t.Fatalf("ImportEvents returned error: %v", err)
+	}
+	if err := dest.HandleEvent(40, "ItemAdded", []byte(`{"name":"date"}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+
+	if strings.Join(replayed, ",") != "apple,banana,cherry" {
+		t.Errorf("Expected the replay handler to run for imported events only, got %v", replayed)
+	}
+	if strings.Join(live, ",") != "date" {
+		t.Errorf("Expected the live handler to run for live events only, got %v", live)
+	}
+	// Handlers added without a mode run in both
+	if names := itemNames(t, dest); strings.Join(names, ",") != "apple,banana,cherry,date" {
+		t.Errorf("Expected default handlers to run for all events, got %v", names)
+	}
+}
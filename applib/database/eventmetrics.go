@@ -0,0 +1,47 @@
+package database
+
+import "time"
+
+// HandlerObserver is called with the time taken by each event handler, for
+// exporting handler latency metrics.
+type HandlerObserver func(eventType, handler string, duration time.Duration)
+
+// SetHandlerObserver registers a function to be called after each event
+// handler runs, whether or not it succeeded. Must be called before events are
+// handled.
+func (db *Database) SetHandlerObserver(observer HandlerObserver) {
+	db.handlerObserver = observer
+}
+
+// noteEventId records that the application has been sent the event, so the
+// backlog covers events that were received but not yet applied.
+func (db *Database) noteEventId(eventId int) {
+	for {
+		latest := db.latestEventId.Load()
+		if int64(eventId) <= latest || db.latestEventId.CompareAndSwap(latest, int64(eventId)) {
+			return
+		}
+	}
+}
+
+// CurrentEventID returns the ID of the last event applied to the database.
+func (db *Database) CurrentEventID() int {
+	return db.eventState.CurrentEventId
+}
+
+// LatestEventID returns the highest event ID the application has been sent,
+// or the current event ID if it has not been sent any since starting.
+func (db *Database) LatestEventID() int {
+	latest := int(db.latestEventId.Load())
+	if current := db.CurrentEventID(); current > latest {
+		return current
+	}
+	return latest
+}
+
+// EventBacklog returns the number of events the application has been sent
+// but not yet applied. A backlog that keeps growing means a handler is stuck
+// or too slow to keep up.
+func (db *Database) EventBacklog() int {
+	return db.LatestEventID() - db.CurrentEventID()
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestEventBacklog(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+	AddGenericEventHandler(db, "Counter:Increment", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return true, err
+	})
+	AddGenericEventHandler(db, "Counter:Stuck", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		return false, errors.New("stuck")
+	})
+
+	observed := map[string]int{}
+	db.SetHandlerObserver(func(eventType, handler string, duration time.Duration) {
+		observed[eventType]++
+	})
+
+	if err := db.HandleEvent(1, "Counter:Increment", []byte(`{}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+	if db.LatestEventID() != 1 || db.EventBacklog() != 0 {
+		t.Errorf("Expected no backlog at event 1, got latest %d backlog %d", db.LatestEventID(), db.EventBacklog())
+	}
+
+	// A stuck handler leaves the event and everything sent after it unapplied
+	db.noteEventId(4)
+	if err := db.HandleEvent(2, "Counter:Stuck", []byte(`{}`)); err == nil {
+		t.Fatal("Expected the stuck handler to fail")
+	}
+	if db.CurrentEventID() != 1 || db.LatestEventID() != 4 || db.EventBacklog() != 3 {
+		t.Errorf("Expected a backlog of 3, got current %d latest %d backlog %d",
+			db.CurrentEventID(), db.LatestEventID(), db.EventBacklog())
+	}
+
+	if observed["Counter:Increment"] != 1 || observed["Counter:Stuck"] != 1 {
+		t.Errorf("Expected each handler run to be observed, got %v", observed)
+	}
+}
@@ -2,7 +2,7 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -23,6 +23,27 @@ func NewEventState(db *sqlx.DB) (*EventState, error) {
 		return nil, fmt.Errorf("failed to create event state table: %w", err)
 	}
 
+	// Every applied event is kept so the log can be exported and replayed
+	_, err = db.Exec(eventLogSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event log table: %w", err)
+	}
+
+	// Events that failed too often are set aside here, see SetDeadLetterAfter
+	_, err = db.Exec(deadLetterSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter table: %w", err)
+	}
+	if err := migrateDeadLetterSchema(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate dead-letter table: %w", err)
+	}
+
+	// Keys sealing personal data in the event log, see SetPersonalData
+	_, err = db.Exec(personalDataSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create personal data tables: %w", err)
+	}
+
 	// Create event state with event ID set to zero
 	_, err = db.Exec(`
 		INSERT INTO event_state (id, current_event_id)
@@ -39,15 +60,26 @@ func NewEventState(db *sqlx.DB) (*EventState, error) {
 		return nil, fmt.Errorf("failed to get event state: %w", err)
 	}
 
-	log.Printf("Initialized event state with ID %d\n", initialEventId)
+	logf(slog.LevelInfo, "Initialized event state with ID %d", initialEventId)
 
 	return &EventState{
 		CurrentEventId: initialEventId,
 	}, nil
 }
 
+// SetCurrentEventId records eventId as the current event ID in tx and in
+// memory. The in-memory ID is advanced even if tx is rolled back, so event
+// handling in this package saves the ID with saveCurrentEventId and advances
+// it only once the transaction has committed.
 func (state *EventState) SetCurrentEventId(eventId int, tx *sqlx.Tx) error {
-	_, err := tx.Exec(`UPDATE event_state SET current_event_id = $1 WHERE id = 0`, eventId)
+	err := saveCurrentEventId(tx, eventId)
 	state.CurrentEventId = eventId
 	return err
 }
+
+// saveCurrentEventId records eventId as the current event ID in tx, leaving
+// the in-memory ID unchanged.
+func saveCurrentEventId(tx *sqlx.Tx, eventId int) error {
+	_, err := tx.Exec(`UPDATE event_state SET current_event_id = $1 WHERE id = 0`, eventId)
+	return err
+}
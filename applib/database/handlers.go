@@ -2,8 +2,11 @@ package database
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/tomyedwab/yesterday/applib/httputils"
 	"github.com/tomyedwab/yesterday/nexushub/types"
@@ -18,12 +21,14 @@ func (db *Database) InitHandlers() error {
 	})
 
 	http.HandleFunc("/internal/publish_events", func(w http.ResponseWriter, r *http.Request) {
-		// Batch event processing
+		// Batch event processing. Events published atomically share a
+		// group ID and are delivered together.
 		var batchRequest struct {
 			Events []struct {
-				ID   int    `json:"id"`
-				Type string `json:"type"`
-				Data string `json:"data"`
+				ID      int    `json:"id"`
+				Type    string `json:"type"`
+				Data    string `json:"data"`
+				GroupID int    `json:"groupId"`
 			} `json:"events"`
 		}
 
@@ -37,18 +42,41 @@ func (db *Database) InitHandlers() error {
 			return
 		}
 
-		// Process events in order
-		lastProcessedId := 0
+		// The whole batch counts towards the backlog until it is applied
 		for _, event := range batchRequest.Events {
-			if db.eventState.CurrentEventId >= event.ID {
+			db.noteEventId(event.ID)
+		}
+
+		// Process events in order, each group in a single transaction
+		lastProcessedId := 0
+		for start := 0; start < len(batchRequest.Events); {
+			event := batchRequest.Events[start]
+			end := start + 1
+			for event.GroupID != 0 && end < len(batchRequest.Events) && batchRequest.Events[end].GroupID == event.GroupID {
+				end++
+			}
+			lastId := batchRequest.Events[end-1].ID
+			if db.eventState.CurrentEventId >= lastId {
+				start = end
 				continue // Skip already processed events
 			}
 
-			if err := db.HandleEvent(event.ID, event.Type, []byte(event.Data)); err != nil {
+			var err error
+			if event.GroupID != 0 {
+				group := make([]GroupEvent, 0, end-start)
+				for _, member := range batchRequest.Events[start:end] {
+					group = append(group, GroupEvent{ID: member.ID, Type: member.Type, Data: []byte(member.Data)})
+				}
+				err = db.HandleEventGroup(event.GroupID, group)
+			} else {
+				err = db.HandleEvent(event.ID, event.Type, []byte(event.Data))
+			}
+			if err != nil {
 				http.Error(w, fmt.Sprintf("Failed to publish event ID %d: %v", event.ID, err), http.StatusInternalServerError)
 				return
 			}
-			lastProcessedId = event.ID
+			lastProcessedId = lastId
+			start = end
 		}
 
 		if lastProcessedId > 0 {
@@ -58,5 +86,168 @@ func (db *Database) InitHandlers() error {
 		}
 	})
 
+	http.HandleFunc("/internal/events/dry-run", func(w http.ResponseWriter, r *http.Request) {
+		if !httputils.IsInternalRequest(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var request struct {
+			Events []DryRunEvent `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to decode dry run request: %w", err), http.StatusBadRequest)
+			return
+		}
+		outcomes, err := db.DryRunEvents(request.Events)
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+			return
+		}
+		httputils.HandleAPIResponse(w, r, map[string]any{"outcomes": outcomes}, nil, http.StatusOK)
+	})
+
+	http.HandleFunc("/internal/events/export", func(w http.ResponseWriter, r *http.Request) {
+		if !httputils.IsInternalRequest(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if err := db.ExportEvents(w); err != nil {
+			// The header may already be written; a truncated stream fails
+			// hash verification on import
+			logf(slog.LevelError, "Failed to export events: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to export events: %v", err), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/internal/events/import", func(w http.ResponseWriter, r *http.Request) {
+		if !httputils.IsInternalRequest(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		applied, err := db.ImportEvents(r.Body, r.URL.Query().Get("force") == "true")
+		var notEmpty *EventLogNotEmptyError
+		var schemaErr *SchemaVersionError
+		switch {
+		case errors.As(err, &notEmpty):
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusConflict)
+		case errors.Is(err, ErrEventLogCorrupt), errors.As(err, &schemaErr):
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+		case err != nil:
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		default:
+			httputils.HandleAPIResponse(w, r, map[string]int{
+				"applied":        applied,
+				"currentEventId": db.eventState.CurrentEventId,
+			}, nil, http.StatusOK)
+		}
+	})
+
+	http.HandleFunc("/internal/event-errors", func(w http.ResponseWriter, r *http.Request) {
+		if !httputils.IsInternalRequest(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		eventErrors := db.EventErrors()
+		httputils.HandleAPIResponse(w, r, map[string]any{
+			"errors": eventErrors,
+			"count":  len(eventErrors),
+		}, nil, http.StatusOK)
+	})
+
+	http.HandleFunc("/internal/dead-letters", func(w http.ResponseWriter, r *http.Request) {
+		if !httputils.IsInternalRequest(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deadLetters, err := db.DeadLetters()
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+			return
+		}
+		httputils.HandleAPIResponse(w, r, map[string]any{
+			"deadLetters": deadLetters,
+			"count":       len(deadLetters),
+		}, nil, http.StatusOK)
+	})
+
+	http.HandleFunc("/internal/dead-letters/redrive", func(w http.ResponseWriter, r *http.Request) {
+		if !httputils.IsInternalRequest(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Redrive the given event, or every dead-lettered event in order.
+		// A group is redriven by its first event.
+		var eventIds []int
+		if id := r.URL.Query().Get("id"); id != "" {
+			eventId, err := strconv.Atoi(id)
+			if err != nil {
+				httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid event ID %q", id), http.StatusBadRequest)
+				return
+			}
+			eventIds = append(eventIds, eventId)
+		} else {
+			deadLetters, err := db.DeadLetters()
+			if err != nil {
+				httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+				return
+			}
+			groups := map[int]bool{}
+			for _, deadLetter := range deadLetters {
+				if deadLetter.GroupID != 0 {
+					if groups[deadLetter.GroupID] {
+						continue
+					}
+					groups[deadLetter.GroupID] = true
+				}
+				eventIds = append(eventIds, deadLetter.EventID)
+			}
+		}
+
+		redriven := []int{}
+		failed := map[int]string{}
+		for _, eventId := range eventIds {
+			err := db.RedriveDeadLetter(eventId)
+			if errors.Is(err, ErrDeadLetterNotFound) && len(eventIds) == 1 {
+				httputils.HandleAPIResponse(w, r, nil, err, http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				failed[eventId] = err.Error()
+				continue
+			}
+			redriven = append(redriven, eventId)
+		}
+		httputils.HandleAPIResponse(w, r, map[string]any{
+			"redriven": redriven,
+			"failed":   failed,
+		}, nil, http.StatusOK)
+	})
+
 	return nil
 }
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The instance lock keeps two servers for the same instance from applying
+// events to one database at once. It is a lock file next to the database
+// recording the holder, refreshed by a heartbeat, plus a BEGIN IMMEDIATE
+// probe that catches a writer which somehow holds no lock file.
+//
+// A lock is stale, and is removed, if its holder is a dead process on this
+// host or its heartbeat is older than instanceLockStaleAfter (the holder may
+// run in another VM, where its PID cannot be checked).
+//
+// Handing off between two instances, e.g. for a blue/green deploy, works as
+// follows: the old instance stops applying events and calls Database.Close,
+// which deletes the lock file; the new instance then calls Initialize, which
+// acquires the lock. The new instance must not be started against the same
+// database until the old one has closed, or it will fail with an
+// InstanceLockedError and should be retried.
+const (
+	InstanceLockHeartbeat  = 10 * time.Second
+	instanceLockStaleAfter = 3 * InstanceLockHeartbeat
+)
+
+// InstanceLockInfo identifies the holder of an instance lock.
+type InstanceLockInfo struct {
+	PID        int       `json:"pid"`
+	InstanceID string    `json:"instanceId"`
+	Hostname   string    `json:"hostname"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+}
+
+// InstanceLockedError is returned when another live instance holds the lock.
+type InstanceLockedError struct {
+	Path   string
+	Holder InstanceLockInfo
+}
+
+func (e *InstanceLockedError) Error() string {
+	return fmt.Sprintf("database is in use by instance %q (pid %d on %s since %s); lock file %s",
+		e.Holder.InstanceID, e.Holder.PID, e.Holder.Hostname, e.Holder.AcquiredAt.Format(time.RFC3339), e.Path)
+}
+
+// InstanceLock is a held instance lock. Release it on shutdown.
+type InstanceLock struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// instanceLockPath returns the lock file path for a SQLite data source name,
+// or "" for in-memory databases.
+func instanceLockPath(dataSourceName string) string {
+	path := strings.TrimPrefix(dataSourceName, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" || path == ":memory:" {
+		return ""
+	}
+	return path + ".lock"
+}
+
+// AcquireInstanceLock takes the instance lock at lockPath for the database,
+// failing fast with an InstanceLockedError if another live instance holds it.
+func AcquireInstanceLock(db *sqlx.DB, lockPath, instanceID string) (*InstanceLock, error) {
+	hostname, _ := os.Hostname()
+	info := InstanceLockInfo{
+		PID:        os.Getpid(),
+		InstanceID: instanceID,
+		Hostname:   hostname,
+		AcquiredAt: time.Now().UTC(),
+	}
+
+	// Two attempts: the second follows removal of a stale lock
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		err = createLockFile(lockPath, info)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+		holder, stale := readLockHolder(lockPath, hostname)
+		if !stale {
+			return nil, &InstanceLockedError{Path: lockPath, Holder: holder}
+		}
+		logf(slog.LevelWarn, "Removing stale instance lock %s held by pid %d (%s)", lockPath, holder.PID, holder.InstanceID)
+		if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale instance lock: %w", err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance lock: %w", err)
+	}
+
+	if err := probeWriter(db); err != nil {
+		os.Remove(lockPath)
+		return nil, err
+	}
+
+	lock := &InstanceLock{
+		path: lockPath,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go lock.heartbeat()
+	return lock, nil
+}
+
+func createLockFile(path string, info InstanceLockInfo) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(info); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// readLockHolder reads an existing lock file and reports whether it is stale.
+func readLockHolder(path, hostname string) (InstanceLockInfo, bool) {
+	var holder InstanceLockInfo
+	stat, err := os.Stat(path)
+	if err != nil {
+		// Removed in the meantime; let the caller try again
+		return holder, true
+	}
+	heartbeatExpired := time.Since(stat.ModTime()) > instanceLockStaleAfter
+
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &holder) != nil {
+		// A holder may be midway through writing the file
+		return holder, heartbeatExpired
+	}
+	if holder.Hostname == hostname && !processAlive(holder.PID) {
+		return holder, true
+	}
+	return holder, heartbeatExpired
+}
+
+// probeWriter checks that no other connection holds a write transaction on
+// the database.
+func probeWriter(db *sqlx.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		if IsBusyError(err) {
+			return fmt.Errorf("database is being written by another process: %w", err)
+		}
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+func (l *InstanceLock) heartbeat() {
+	defer close(l.done)
+	ticker := time.NewTicker(InstanceLockHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(l.path, now, now); err != nil {
+				logf(slog.LevelWarn, "Failed to refresh instance lock %s: %v", l.path, err)
+			}
+		}
+	}
+}
+
+// Release stops the heartbeat and deletes the lock file.
+func (l *InstanceLock) Release() error {
+	close(l.stop)
+	<-l.done
+	return os.Remove(l.path)
+}
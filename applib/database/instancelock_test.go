@@ -0,0 +1,131 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+// connectInstance opens a second "instance" of the database, the way a
+// separate server process would.
+func connectInstance(t *testing.T, dsn string) *Database {
+	db, err := Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	t.Cleanup(func() { db.GetDB().Close() })
+	return db
+}
+
+func writeLockFile(t *testing.T, lockPath string, info InstanceLockInfo, modTime time.Time) {
+	data, _ := json.Marshal(info)
+	if err := os.WriteFile(lockPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(lockPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstanceLockRejectsSecondInstance(t *testing.T) {
+	dsn := path.Join(t.TempDir(), "app.sqlite")
+	first := connectInstance(t, dsn)
+	second := connectInstance(t, dsn)
+
+	lock, err := AcquireInstanceLock(first.GetDB(), first.lockPath, "blue")
+	if err != nil {
+		t.Fatalf("First instance failed to acquire the lock: %v", err)
+	}
+
+	start := time.Now()
+	_, err = AcquireInstanceLock(second.GetDB(), second.lockPath, "green")
+	var lockedErr *InstanceLockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("Expected InstanceLockedError, got %v", err)
+	}
+	if lockedErr.Holder.InstanceID != "blue" || lockedErr.Holder.PID != os.Getpid() {
+		t.Errorf("Expected the error to name the holder, got %+v", lockedErr.Holder)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the second instance to fail fast, took %v", elapsed)
+	}
+
+	// Once released, the lock can be handed to the other instance
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release returned error: %v", err)
+	}
+	lock, err = AcquireInstanceLock(second.GetDB(), second.lockPath, "green")
+	if err != nil {
+		t.Fatalf("Second instance failed to acquire the released lock: %v", err)
+	}
+	lock.Release()
+}
+
+func TestInstanceLockRemovesStaleLocks(t *testing.T) {
+	dsn := path.Join(t.TempDir(), "app.sqlite")
+	db := connectInstance(t, dsn)
+	hostname, _ := os.Hostname()
+
+	// A process that has exited on this host
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot run a child process: %v", err)
+	}
+	writeLockFile(t, db.lockPath, InstanceLockInfo{PID: cmd.Process.Pid, InstanceID: "dead", Hostname: hostname}, time.Now())
+	lock, err := AcquireInstanceLock(db.GetDB(), db.lockPath, "new")
+	if err != nil {
+		t.Fatalf("Expected lock held by a dead process to be taken over, got %v", err)
+	}
+	lock.Release()
+
+	// A holder elsewhere whose heartbeat has stopped
+	writeLockFile(t, db.lockPath, InstanceLockInfo{PID: 1, InstanceID: "remote", Hostname: "other-vm"}, time.Now().Add(-2*instanceLockStaleAfter))
+	lock, err = AcquireInstanceLock(db.GetDB(), db.lockPath, "new")
+	if err != nil {
+		t.Fatalf("Expected lock with an expired heartbeat to be taken over, got %v", err)
+	}
+	lock.Release()
+
+	// A holder elsewhere with a fresh heartbeat is respected
+	writeLockFile(t, db.lockPath, InstanceLockInfo{PID: 1, InstanceID: "remote", Hostname: "other-vm"}, time.Now())
+	var lockedErr *InstanceLockedError
+	if _, err := AcquireInstanceLock(db.GetDB(), db.lockPath, "new"); !errors.As(err, &lockedErr) {
+		t.Errorf("Expected InstanceLockedError for a live remote holder, got %v", err)
+	}
+}
+
+func TestInstanceLockProbesForWriters(t *testing.T) {
+	dsn := path.Join(t.TempDir(), "app.sqlite") + "?_busy_timeout=0"
+	db := connectInstance(t, dsn)
+	other := connectInstance(t, dsn)
+	other.GetDB().MustExec(`CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+
+	// A writer that never took the lock file
+	tx := other.GetDB().MustBegin()
+	tx.MustExec(`INSERT INTO items (id) VALUES (1)`)
+	defer tx.Rollback()
+
+	if _, err := AcquireInstanceLock(db.GetDB(), db.lockPath, "new"); err == nil {
+		t.Fatal("Expected the probe to detect the other writer")
+	}
+	if _, err := os.Stat(db.lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the lock file to be removed after a failed probe, got %v", err)
+	}
+}
+
+func TestInstanceLockPath(t *testing.T) {
+	cases := map[string]string{
+		"/db/app.sqlite":                   "/db/app.sqlite.lock",
+		"file:/db/app.sqlite?cache=shared": "/db/app.sqlite.lock",
+		":memory:":                         "",
+	}
+	for dsn, expected := range cases {
+		if got := instanceLockPath(dsn); got != expected {
+			t.Errorf("instanceLockPath(%q) = %q, expected %q", dsn, got, expected)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package database
+
+import "os"
+
+// processAlive reports whether a process with the PID exists. On Windows,
+// finding a process opens a handle to it, which fails once it has exited.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrJSONPathNotFound is returned by GetJSONField when the row's JSON column
+// has no value at the path.
+var ErrJSONPathNotFound = errors.New("JSON path not found")
+
+// jsonPathPattern matches the SQLite JSON paths the helpers accept: "$"
+// followed by object keys such as ".theme" and array indexes such as "[2]",
+// or "[#]" to append to an array. Paths are written into the query, so
+// anything else, including quoted keys, is refused.
+var jsonPathPattern = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[([0-9]+|#)\])*$`)
+
+func checkJSONColumn(table, column, path string) error {
+	if !identifierPattern.MatchString(table) || !identifierPattern.MatchString(column) {
+		return fmt.Errorf("invalid JSON column %s.%s", table, column)
+	}
+	if !jsonPathPattern.MatchString(path) {
+		return fmt.Errorf("invalid JSON path %q", path)
+	}
+	return nil
+}
+
+// GetJSONField decodes the value at path, e.g. "$.settings.theme", of the
+// JSON column of the first row of the table matching the condition into
+// dest. The condition's placeholders are numbered from $1, as for
+// RowQuery.Where, and soft-deleted rows are left out. It fails with
+// sql.ErrNoRows if no row matches and with ErrJSONPathNotFound if the column
+// is NULL or has no value at the path.
+func GetJSONField(db sqlx.Queryer, dest any, table, column, path, where string, args ...any) error {
+	if err := checkJSONColumn(table, column, path); err != nil {
+		return err
+	}
+	var value *string
+	err := Rows(table, fmt.Sprintf("%s -> '%s'", column, path)).Where(where, args...).Get(db, &value)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("%w: %s.%s %s", ErrJSONPathNotFound, table, column, path)
+	}
+	return json.Unmarshal([]byte(*value), dest)
+}
+
+// SetJSONField sets the value at path of the JSON column of every row of the
+// table matching the condition to value encoded as JSON, leaving the rest of
+// the document alone, and returns how many rows matched. A NULL column is
+// treated as an empty object, and objects missing along the path are
+// created. The condition is as for GetJSONField; an empty one matches every
+// row.
+func SetJSONField(tx sqlx.Execer, table, column, path string, value any, where string, args ...any) (int64, error) {
+	if err := checkJSONColumn(table, column, path); err != nil {
+		return 0, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode JSON value for %s.%s %s: %w", table, column, path, err)
+	}
+	// The matching rows are selected first, so that the condition's
+	// placeholders come before the value's and keep their numbers
+	matching, _ := Rows(table, "rowid AS matching_rowid").Where(where, args...).SQL()
+	query := fmt.Sprintf(`WITH matching AS (%s)
+		UPDATE %s SET %s = json_set(COALESCE(%s, '{}'), '%s', json($%d))
+		WHERE rowid IN (SELECT matching_rowid FROM matching)`,
+		matching, table, column, column, path, len(args)+1)
+	result, err := tx.Exec(query, append(append([]any{}, args...), string(encoded))...)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rowsAffected, nil
+}
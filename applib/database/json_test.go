@@ -0,0 +1,78 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+type profileSettings struct {
+	Theme string   `json:"theme"`
+	Tags  []string `json:"tags"`
+}
+
+func TestJSONFields(t *testing.T) {
+	db := setupWidgetsDatabase(t)
+	db.GetDB().MustExec(`ALTER TABLE widgets ADD COLUMN profile TEXT`)
+	db.GetDB().MustExec(`UPDATE widgets SET profile = '{"settings":{"theme":"dark","tags":["a"]},"visits":3}' WHERE id = 1`)
+
+	var settings profileSettings
+	if err := GetJSONField(db.GetDB(), &settings, "widgets", "profile", "$.settings", "id = $1", 1); err != nil ||
+		settings.Theme != "dark" || len(settings.Tags) != 1 {
+		t.Errorf("Unexpected settings %+v, %v", settings, err)
+	}
+	var visits int
+	if err := GetJSONField(db.GetDB(), &visits, "widgets", "profile", "$.visits", "id = $1", 1); err != nil || visits != 3 {
+		t.Errorf("Unexpected visits %d, %v", visits, err)
+	}
+
+	tx := db.GetDB().MustBegin()
+	if n, err := SetJSONField(tx, "widgets", "profile", "$.settings.theme", "light", "id = $1 AND name = $2", 1, "one"); err != nil || n != 1 {
+		t.Errorf("Expected one row to be updated, got %d, %v", n, err)
+	}
+	if _, err := SetJSONField(tx, "widgets", "profile", "$.settings.tags[#]", "b", "id = $1", 1); err != nil {
+		t.Fatal(err)
+	}
+	// A NULL column is treated as an empty object
+	if n, err := SetJSONField(tx, "widgets", "profile", "$.visits", 1, "id = $1", 3); err != nil || n != 1 {
+		t.Errorf("Expected the NULL profile to be updated, got %d, %v", n, err)
+	}
+	if _, err := SetJSONField(tx, "widgets", "profile", "$.settings.theme", "dark", "id = $1", 3); err != nil {
+		t.Fatal(err)
+	}
+	// Soft-deleted rows are left alone
+	if n, err := SetJSONField(tx, "widgets", "profile", "$.visits", 1, "id = $1", 2); err != nil || n != 0 {
+		t.Errorf("Expected the deleted row not to be updated, got %d, %v", n, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	settings = profileSettings{}
+	if err := GetJSONField(db.GetDB(), &settings, "widgets", "profile", "$.settings", "id = $1", 1); err != nil ||
+		settings.Theme != "light" || len(settings.Tags) != 2 || settings.Tags[1] != "b" {
+		t.Errorf("Unexpected settings after update %+v, %v", settings, err)
+	}
+	if err := GetJSONField(db.GetDB(), &visits, "widgets", "profile", "$.visits", "id = $1", 3); err != nil || visits != 1 {
+		t.Errorf("Unexpected visits %d, %v", visits, err)
+	}
+
+	var theme string
+	if err := GetJSONField(db.GetDB(), &theme, "widgets", "profile", "$.settings.theme", "id = $1", 3); err != nil || theme != "dark" {
+		t.Errorf("Expected the missing settings object to be created, got %q, %v", theme, err)
+	}
+	if err := GetJSONField(db.GetDB(), &visits, "widgets", "profile", "$.missing", "id = $1", 1); !errors.Is(err, ErrJSONPathNotFound) {
+		t.Errorf("Expected ErrJSONPathNotFound, got %v", err)
+	}
+	if err := GetJSONField(db.GetDB(), &visits, "widgets", "profile", "$.visits", "id = $1", 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a deleted row, got %v", err)
+	}
+	for _, path := range []string{"visits", "$.a'; DROP TABLE widgets; --", `$."quoted"`} {
+		if err := GetJSONField(db.GetDB(), &visits, "widgets", "profile", path, "id = $1", 1); err == nil {
+			t.Errorf("Expected an error for path %q", path)
+		}
+	}
+	if _, err := SetJSONField(db.GetDB(), "widgets; --", "profile", "$.visits", 1, ""); err == nil {
+		t.Error("Expected an error for an invalid table")
+	}
+}
@@ -0,0 +1,17 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// logf logs a formatted message at level as the "database" module, so its
+// level can be set separately from the rest of the application (see
+// applib.Logger).
+func logf(level slog.Level, format string, args ...any) {
+	logger := slog.Default().With("module", "database")
+	if logger.Enabled(context.Background(), level) {
+		logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
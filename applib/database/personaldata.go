@@ -0,0 +1,397 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Personal data in events is crypto-shredded: fields marked with
+// SetPersonalData are sealed in the event log with a key per user, and
+// forgetting a user destroys the key. Handlers always see the fields in the
+// clear, or as a tombstone once the key is gone.
+
+const personalDataSchema = `
+	CREATE TABLE IF NOT EXISTS personal_data_keys (
+		user_id INTEGER PRIMARY KEY,
+		key BLOB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS personal_data_events (
+		event_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		PRIMARY KEY (event_id, user_id)
+	)`
+
+const (
+	// sealedPrefix starts a sealed field value:
+	// "pii:v1:<user ID>:<base64 of the nonce and ciphertext>".
+	sealedPrefix = "pii:v1:"
+	// redactedPrefix starts the tombstone replacing a sealed field whose key
+	// was destroyed, see Redacted.
+	redactedPrefix = "redacted-"
+)
+
+// ErrNoResetHandlers is returned by ForgetUser when no projection can be
+// rebuilt because no reset handler was added with AddResetHandler.
+var ErrNoResetHandlers = errors.New("no reset handlers to rebuild projections with")
+
+// PersonalData marks the fields of an event type that hold personal data
+// about a user. Only top-level string fields are sealed; other values are
+// logged as they are.
+type PersonalData struct {
+	// Fields are the names of the top-level JSON fields holding personal data.
+	Fields []string
+	// UserIDField is the top-level JSON field holding the ID of the user the
+	// data is about.
+	UserIDField string
+	// Subject returns the ID of the user the data is about, for events that
+	// do not carry it, e.g. because the ID is assigned by a handler. It runs
+	// in the event's transaction after the handlers. It is only consulted if
+	// UserIDField is empty.
+	Subject func(tx *sqlx.Tx, eventData []byte) (int, error)
+}
+
+// SetPersonalData marks fields of an event type as personal data. Handlers
+// receive them in the clear, but the event log keeps them encrypted with a
+// key for the user they are about, so that ForgetUser can make them
+// unrecoverable by destroying the key. Call it with the event type's
+// handlers, before initializing the database.
+func (db *Database) SetPersonalData(eventType string, personalData PersonalData) {
+	db.personalData[eventType] = personalData
+}
+
+// AddResetHandler adds a function that returns a projection built from
+// events to its state before the first event, typically by deleting its rows.
+// ForgetUser runs every reset handler before replaying the event log, so
+// every projection updated by an event handler needs one.
+func (db *Database) AddResetHandler(reset func(tx *sqlx.Tx) error) {
+	db.resetHandlers = append(db.resetHandlers, reset)
+}
+
+// Redacted returns the tombstone that replaces a user's personal data once
+// the user is forgotten.
+func Redacted(userID int) string {
+	return redactedPrefix + strconv.Itoa(userID)
+}
+
+// IsRedacted reports whether a value is a tombstone left by ForgetUser.
+// Handlers that derive secrets from personal data, such as password hashes,
+// must not derive them from a tombstone.
+func IsRedacted(value string) bool {
+	userID, ok := strings.CutPrefix(value, redactedPrefix)
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(userID)
+	return err == nil
+}
+
+// personalEvent is an event with personal data, decoded to its top-level
+// fields as they were received or logged.
+type personalEvent struct {
+	spec   PersonalData
+	data   []byte
+	fields map[string]json.RawMessage
+}
+
+func decodePersonalEvent(spec PersonalData, data []byte) (*personalEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode event with personal data: %w", err)
+	}
+	return &personalEvent{spec: spec, data: data, fields: fields}, nil
+}
+
+// stringField returns the value of a personal data field that is a JSON
+// string.
+func (e *personalEvent) stringField(name string) (string, bool) {
+	raw, ok := e.fields[name]
+	if !ok {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// open returns the event data handed to the handlers: sealed fields are
+// decrypted, or replaced with a tombstone if their key was destroyed.
+func (e *personalEvent) open(tx *sqlx.Tx) ([]byte, error) {
+	opened := make(map[string]json.RawMessage, len(e.fields))
+	changed := false
+	for name, raw := range e.fields {
+		opened[name] = raw
+	}
+	for _, name := range e.spec.Fields {
+		value, ok := e.stringField(name)
+		if !ok || !strings.HasPrefix(value, sealedPrefix) {
+			continue
+		}
+		plaintext, err := openField(tx, name, value)
+		if err != nil {
+			return nil, err
+		}
+		if opened[name], err = json.Marshal(plaintext); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return e.data, nil
+	}
+	return json.Marshal(opened)
+}
+
+// seal returns the event data to log: personal data fields received in the
+// clear are sealed with the key of the user they are about, created if
+// needed. It also records which users' data the event holds.
+func (e *personalEvent) seal(tx *sqlx.Tx, eventId int, handlerData []byte) ([]byte, error) {
+	sealed := make(map[string]json.RawMessage, len(e.fields))
+	for name, raw := range e.fields {
+		sealed[name] = raw
+	}
+	userID, changed := 0, false
+	for _, name := range e.spec.Fields {
+		value, ok := e.stringField(name)
+		if !ok || IsRedacted(value) {
+			continue
+		}
+		if strings.HasPrefix(value, sealedPrefix) {
+			// Already sealed, e.g. when rebuilding from the event log
+			owner, _, err := parseSealed(value)
+			if err != nil {
+				return nil, err
+			}
+			if err := recordPersonalDataEvent(tx, eventId, owner); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if userID == 0 {
+			var err error
+			if userID, err = e.subject(tx, handlerData); err != nil {
+				return nil, fmt.Errorf("cannot tell whose personal data event %d holds: %w", eventId, err)
+			}
+		}
+		ciphertext, err := sealField(tx, userID, name, value)
+		if err != nil {
+			return nil, err
+		}
+		if sealed[name], err = json.Marshal(ciphertext); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return e.data, nil
+	}
+	if err := recordPersonalDataEvent(tx, eventId, userID); err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// subject returns the ID of the user the event's personal data is about.
+func (e *personalEvent) subject(tx *sqlx.Tx, handlerData []byte) (int, error) {
+	if e.spec.UserIDField != "" {
+		var userID int
+		if err := json.Unmarshal(e.fields[e.spec.UserIDField], &userID); err != nil {
+			return 0, fmt.Errorf("invalid %s field: %w", e.spec.UserIDField, err)
+		}
+		return userID, nil
+	}
+	if e.spec.Subject == nil {
+		return 0, errors.New("neither UserIDField nor Subject is set")
+	}
+	return e.spec.Subject(tx, handlerData)
+}
+
+func recordPersonalDataEvent(tx *sqlx.Tx, eventId, userID int) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO personal_data_events (event_id, user_id) VALUES ($1, $2)`, eventId, userID)
+	return err
+}
+
+// personalDataKey returns the key sealing a user's personal data, creating
+// it if create is set. Without create it returns nil if there is no key.
+func personalDataKey(tx *sqlx.Tx, userID int, create bool) ([]byte, error) {
+	var key []byte
+	err := tx.Get(&key, `SELECT key FROM personal_data_keys WHERE user_id = $1`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !create {
+			return nil, nil
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO personal_data_keys (user_id, key) VALUES ($1, $2)`, userID, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load personal data key for user %d: %w", userID, err)
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealField encrypts a field value with the user's key. The field name is
+// authenticated so that sealed values cannot be swapped between fields.
+func sealField(tx *sqlx.Tx, userID int, name, value string) (string, error) {
+	key, err := personalDataKey(tx, userID, true)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, []byte(value), []byte(name))
+	return sealedPrefix + strconv.Itoa(userID) + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func parseSealed(value string) (int, []byte, error) {
+	owner, encoded, ok := strings.Cut(strings.TrimPrefix(value, sealedPrefix), ":")
+	userID, err := strconv.Atoi(owner)
+	if !ok || err != nil {
+		return 0, nil, fmt.Errorf("malformed sealed value")
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed sealed value: %w", err)
+	}
+	return userID, ciphertext, nil
+}
+
+// openField decrypts a sealed field value, or returns the user's tombstone if
+// the key was destroyed.
+func openField(tx *sqlx.Tx, name, value string) (string, error) {
+	userID, ciphertext, err := parseSealed(value)
+	if err != nil {
+		return "", err
+	}
+	key, err := personalDataKey(tx, userID, false)
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return Redacted(userID), nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed sealed value")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to open personal data of user %d: %w", userID, err)
+	}
+	return string(plaintext), nil
+}
+
+// openEvent returns the event data handed to the handlers for a received or
+// logged event, and the event decoded for sealing if it has personal data.
+func (db *Database) openEvent(tx *sqlx.Tx, eventType string, eventData []byte) ([]byte, *personalEvent, error) {
+	spec, ok := db.personalData[eventType]
+	if !ok {
+		return eventData, nil, nil
+	}
+	event, err := decodePersonalEvent(spec, eventData)
+	if err != nil {
+		return nil, nil, err
+	}
+	opened, err := event.open(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return opened, event, nil
+}
+
+// ForgetUser makes a user's personal data in events unrecoverable by
+// destroying the key it is sealed with, then rebuilds the projections by
+// running the reset handlers and replaying the event log in a single
+// transaction. Handlers see the user's personal data as the tombstone
+// returned by Redacted. The sealed events stay in the log.
+//
+// It returns the events that held the user's personal data as the handlers
+// now see them, so that other copies of the events can be redacted the same
+// way. Forgetting a user again rebuilds the projections again and returns
+// the same events.
+func (db *Database) ForgetUser(userID int) ([]LoggedEvent, error) {
+	if len(db.resetHandlers) == 0 {
+		return nil, ErrNoResetHandlers
+	}
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, wrapBusyError(err)
+	}
+	defer tx.Rollback()
+
+	// Overwrite the freed key in the database file rather than only
+	// unlinking it
+	if _, err := tx.Exec(`PRAGMA secure_delete = ON`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM personal_data_keys WHERE user_id = $1`, userID); err != nil {
+		return nil, wrapBusyError(err)
+	}
+	affected := map[int]bool{}
+	var eventIds []int
+	if err := tx.Select(&eventIds, `SELECT event_id FROM personal_data_events WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, eventId := range eventIds {
+		affected[eventId] = true
+	}
+
+	for _, reset := range db.resetHandlers {
+		if err := reset(tx); err != nil {
+			return nil, fmt.Errorf("failed to reset projections: %w", err)
+		}
+	}
+
+	var logged []LoggedEvent
+	err = db.forEachLoggedEvent(tx, func(event LoggedEvent) error {
+		logged = append(logged, event)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event log: %w", err)
+	}
+	redacted := []LoggedEvent{}
+	for _, event := range logged {
+		if err := db.runHandlers(tx, event.Type, event.Data, true); err != nil {
+			return nil, fmt.Errorf("failed to replay event %d: %w", event.ID, wrapBusyError(err))
+		}
+		if affected[event.ID] {
+			redacted = append(redacted, event)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, wrapBusyError(err)
+	}
+	return redacted, nil
+}
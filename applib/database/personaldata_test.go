@@ -0,0 +1,200 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type profileSet struct {
+	UserID int    `json:"userId"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+type accountOpened struct {
+	Name string `json:"name"`
+}
+
+// setupProfilesDatabase returns an initialized database whose profiles table
+// is built from ProfileSet events, which carry the user ID, and
+// AccountOpened events, whose user ID is assigned by the handler.
+func setupProfilesDatabase(t *testing.T) *Database {
+	db, _ := setupTestDatabase(t)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("initializeSchema returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE profiles (user_id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE, email TEXT)`)
+	AddEventHandler(db, "AccountOpened", func(tx *sqlx.Tx, event accountOpened) (bool, error) {
+		_, err := tx.Exec(`INSERT INTO profiles (name) VALUES ($1)`, event.Name)
+		return true, err
+	})
+	AddEventHandler(db, "ProfileSet", func(tx *sqlx.Tx, event profileSet) (bool, error) {
+		_, err := tx.Exec(`UPDATE profiles SET name = $1, email = $2 WHERE user_id = $3`, event.Name, event.Email, event.UserID)
+		return true, err
+	})
+	db.SetPersonalData("AccountOpened", PersonalData{
+		Fields: []string{"name"},
+		Subject: func(tx *sqlx.Tx, eventData []byte) (int, error) {
+			var userID int
+			err := tx.Get(&userID, `SELECT user_id FROM profiles WHERE name = json_extract($1, '$.name')`, string(eventData))
+			return userID, err
+		},
+	})
+	db.SetPersonalData("ProfileSet", PersonalData{Fields: []string{"name", "email"}, UserIDField: "userId"})
+	db.AddResetHandler(func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM profiles`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = 'profiles'`)
+		return err
+	})
+	return db
+}
+
+// applyProfiles opens accounts for Alice (user 1) and Bob (user 2) and sets
+// their profiles.
+func applyProfiles(t *testing.T, db *Database) {
+	events := []struct {
+		eventType, data string
+	}{
+		{"AccountOpened", `{"name":"alice"}`},
+		{"AccountOpened", `{"name":"bob"}`},
+		{"ProfileSet", `{"userId":1,"name":"Alice","email":"alice@example.com"}`},
+		{"ProfileSet", `{"userId":2,"name":"Bob","email":"bob@example.com"}`},
+	}
+	for i, event := range events {
+		if err := db.HandleEvent(i+1, event.eventType, []byte(event.data)); err != nil {
+			t.Fatalf("HandleEvent returned error: %v", err)
+		}
+	}
+}
+
+func profiles(t *testing.T, db *Database) []string {
+	var rows []string
+	err := db.GetDB().Select(&rows, `SELECT user_id || ':' || name || ':' || COALESCE(email, '') FROM profiles ORDER BY user_id`)
+	if err != nil {
+		t.Fatalf("Failed to read profiles: %v", err)
+	}
+	return rows
+}
+
+func eventLogData(t *testing.T, db *Database) string {
+	var data []string
+	if err := db.GetDB().Select(&data, `SELECT event_data FROM event_log ORDER BY id`); err != nil {
+		t.Fatalf("Failed to read event log: %v", err)
+	}
+	return strings.Join(data, "\n")
+}
+
+func TestPersonalDataSealedInEventLog(t *testing.T) {
+	db := setupProfilesDatabase(t)
+	applyProfiles(t, db)
+
+	if rows := strings.Join(profiles(t, db), ","); rows != "1:Alice:alice@example.com,2:Bob:bob@example.com" {
+		t.Errorf("Expected handlers to see personal data in the clear, got %s", rows)
+	}
+	logged := eventLogData(t, db)
+	for _, plaintext := range []string{"alice", "Alice", "bob@example.com"} {
+		if strings.Contains(logged, plaintext) {
+			t.Errorf("Expected %q to be sealed in the event log:\n%s", plaintext, logged)
+		}
+	}
+	if !strings.Contains(logged, `"userId":1`) || strings.Count(logged, sealedPrefix+"1:") != 3 {
+		t.Errorf("Expected user 1's fields sealed with their key:\n%s", logged)
+	}
+}
+
+func TestForgetUserRedactsProjections(t *testing.T) {
+	db := setupProfilesDatabase(t)
+	applyProfiles(t, db)
+	loggedBefore := eventLogData(t, db)
+
+	redacted, err := db.ForgetUser(1)
+	if err != nil {
+		t.Fatalf("ForgetUser returned error: %v", err)
+	}
+	if rows := strings.Join(profiles(t, db), ","); rows != "1:redacted-1:redacted-1,2:Bob:bob@example.com" {
+		t.Errorf("Expected only user 1 redacted after replay, got %s", rows)
+	}
+	if len(redacted) != 2 || redacted[0].ID != 1 || redacted[1].ID != 3 {
+		t.Fatalf("Expected events 1 and 3 to be returned, got %+v", redacted)
+	}
+	if data := string(redacted[1].Data); !strings.Contains(data, `"email":"redacted-1"`) || !strings.Contains(data, `"userId":1`) {
+		t.Errorf("Expected the redacted event as the handlers see it, got %s", data)
+	}
+	if eventLogData(t, db) != loggedBefore {
+		t.Error("Expected the sealed event log to be kept as it was")
+	}
+
+	// The tombstones are what an export holds from now on
+	var export bytes.Buffer
+	if err := db.ExportEvents(&export); err != nil {
+		t.Fatalf("ExportEvents returned error: %v", err)
+	}
+	if strings.Contains(export.String(), "alice") || !strings.Contains(export.String(), "bob@example.com") {
+		t.Errorf("Expected only user 1 redacted in the export:\n%s", export.String())
+	}
+
+	// Events keep applying, and forgetting again is harmless
+	if err := db.HandleEvent(5, "ProfileSet", []byte(`{"userId":2,"name":"Robert","email":"bob@example.com"}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+	if again, err := db.ForgetUser(1); err != nil || len(again) != 2 {
+		t.Errorf("Expected forgetting again to return the same events, got %d: %v", len(again), err)
+	}
+	if rows := strings.Join(profiles(t, db), ","); rows != "1:redacted-1:redacted-1,2:Robert:bob@example.com" {
+		t.Errorf("Unexpected profiles after forgetting again: %s", rows)
+	}
+}
+
+func TestPersonalDataExportImport(t *testing.T) {
+	source := setupProfilesDatabase(t)
+	applyProfiles(t, source)
+	var export bytes.Buffer
+	if err := source.ExportEvents(&export); err != nil {
+		t.Fatalf("ExportEvents returned error: %v", err)
+	}
+	if !strings.Contains(export.String(), "alice@example.com") {
+		t.Errorf("Expected the export to carry personal data in the clear:\n%s", export.String())
+	}
+
+	// The importing database seals the data with keys of its own
+	dest := setupProfilesDatabase(t)
+	if _, err := dest.ImportEvents(&export, false); err != nil {
+		t.Fatalf("ImportEvents returned error: %v", err)
+	}
+	if rows := strings.Join(profiles(t, dest), ","); rows != "1:Alice:alice@example.com,2:Bob:bob@example.com" {
+		t.Errorf("Expected the profiles to be rebuilt, got %s", rows)
+	}
+	if strings.Contains(eventLogData(t, dest), "alice@example.com") {
+		t.Error("Expected imported personal data to be sealed")
+	}
+	if _, err := dest.ForgetUser(2); err != nil {
+		t.Fatalf("ForgetUser returned error: %v", err)
+	}
+	if rows := strings.Join(profiles(t, dest), ","); rows != "1:Alice:alice@example.com,2:redacted-2:redacted-2" {
+		t.Errorf("Expected user 2 redacted in the imported database, got %s", rows)
+	}
+}
+
+func TestForgetUserRequiresResetHandlers(t *testing.T) {
+	db := setupItemsDatabase(t)
+	if _, err := db.ForgetUser(1); !errors.Is(err, ErrNoResetHandlers) {
+		t.Errorf("Expected ErrNoResetHandlers, got %v", err)
+	}
+}
+
+func TestIsRedacted(t *testing.T) {
+	if !IsRedacted(Redacted(42)) {
+		t.Error("Expected a tombstone to be recognized")
+	}
+	for _, value := range []string{"", "redacted-", "redacted-bob", "alice"} {
+		if IsRedacted(value) {
+			t.Errorf("Expected %q not to be a tombstone", value)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// MaxEventAttempts is the number of times HandleEvent tries to apply an
+	// event before giving up when the database is busy.
+	MaxEventAttempts = 5
+
+	eventRetryBackoffInitial = 25 * time.Millisecond
+	eventRetryBackoffMax     = 500 * time.Millisecond
+)
+
+// RetryableError wraps an error caused by write contention ("database is
+// locked" / SQLITE_BUSY). The operation that failed can be safely retried
+// once its transaction has been rolled back.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("retryable database error: %v", e.Err)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// IsBusyError reports whether err was caused by another connection holding a
+// lock on the database.
+func IsBusyError(err error) bool {
+	return err != nil && isSQLiteBusy(err)
+}
+
+// IsRetryable reports whether err is a RetryableError or a raw busy error.
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable) || IsBusyError(err)
+}
+
+// wrapBusyError wraps busy errors in a RetryableError and returns all other
+// errors unchanged.
+func wrapBusyError(err error) error {
+	if err != nil && IsBusyError(err) {
+		return &RetryableError{Err: err}
+	}
+	return err
+}
+
+// WithTx runs fn in a transaction and commits it, or rolls it back if fn
+// fails. While fn or the commit fails because the database is busy, the
+// whole transaction is rolled back and run again with backoff, up to
+// MaxEventAttempts times or until ctx is done, so fn must not have effects
+// outside the transaction. Events are applied by HandleEvent the same way.
+func (db *Database) WithTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	return retryWhileBusy(ctx, "transaction", func() error {
+		tx, err := db.db.BeginTxx(ctx, nil)
+		if err != nil {
+			return wrapBusyError(err)
+		}
+		defer tx.Rollback()
+		if err := fn(tx); err != nil {
+			return wrapBusyError(err)
+		}
+		return wrapBusyError(tx.Commit())
+	})
+}
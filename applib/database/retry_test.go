@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// setupContendedDatabase opens two independent connections to the same file
+// with SQLite's own busy wait disabled, so contention surfaces immediately.
+func setupContendedDatabase(t *testing.T) (*Database, *sqlx.DB) {
+	dsn := path.Join(t.TempDir(), "app.sqlite") + "?_busy_timeout=0"
+	db, err := Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	t.Cleanup(func() { db.GetDB().Close() })
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("Initialize returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE counter (id INTEGER PRIMARY KEY, value INTEGER)`)
+	db.GetDB().MustExec(`INSERT INTO counter (id, value) VALUES (0, 0)`)
+
+	other := sqlx.MustConnect("sqlite3", dsn)
+	t.Cleanup(func() { other.Close() })
+	return db, other
+}
+
+func TestHandleEventRetriesWhenBusy(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+
+	var calls atomic.Int32
+	AddGenericEventHandler(db, "Counter:Increment", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		calls.Add(1)
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return true, err
+	})
+
+	// The second writer holds the write lock for a while, then commits
+	lock := other.MustBegin()
+	lock.MustExec(`UPDATE counter SET value = value + 10 WHERE id = 0`)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Commit()
+	}()
+
+	if err := db.HandleEvent(1, "Counter:Increment", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected event to be applied after retrying, got %v", err)
+	}
+	if calls.Load() < 2 {
+		t.Errorf("Expected the handler to be retried, got %d calls", calls.Load())
+	}
+
+	// Retries roll back, so the event is applied exactly once
+	var value int
+	db.GetDB().Get(&value, `SELECT value FROM counter WHERE id = 0`)
+	if value != 11 {
+		t.Errorf("Expected counter to be 11, got %d", value)
+	}
+	var eventId int
+	db.GetDB().Get(&eventId, `SELECT current_event_id FROM event_state WHERE id = 0`)
+	if eventId != 1 {
+		t.Errorf("Expected current event ID 1, got %d", eventId)
+	}
+}
+
+func TestHandleEventGivesUpWhenLockHeld(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+
+	AddGenericEventHandler(db, "Counter:Increment", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return true, err
+	})
+
+	lock := other.MustBegin()
+	lock.MustExec(`UPDATE counter SET value = value + 10 WHERE id = 0`)
+	defer lock.Rollback()
+
+	err := db.HandleEvent(1, "Counter:Increment", []byte(`{}`))
+	if !IsRetryable(err) {
+		t.Fatalf("Expected a retryable error, got %v", err)
+	}
+	var retryable *RetryableError
+	if !errors.As(err, &retryable) {
+		t.Errorf("Expected error to wrap RetryableError, got %T", err)
+	}
+}
+
+func TestHandleEventDoesNotRetryOtherErrors(t *testing.T) {
+	db, _ := setupContendedDatabase(t)
+
+	var calls atomic.Int32
+	AddGenericEventHandler(db, "Counter:Fail", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		calls.Add(1)
+		return false, errors.New("handler failed")
+	})
+
+	if err := db.HandleEvent(1, "Counter:Fail", []byte(`{}`)); err == nil || IsRetryable(err) {
+		t.Fatalf("Expected a non-retryable error, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected a single attempt, got %d", calls.Load())
+	}
+}
+
+func TestWithTxRetriesWhenBusy(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+
+	lock := other.MustBegin()
+	lock.MustExec(`UPDATE counter SET value = value + 10 WHERE id = 0`)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Commit()
+	}()
+
+	var calls int
+	err := db.WithTx(context.Background(), func(tx *sqlx.Tx) error {
+		calls++
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected the transaction to commit after retrying, got %v", err)
+	}
+	if calls < 2 {
+		t.Errorf("Expected the transaction to be retried, got %d calls", calls)
+	}
+	var value int
+	db.GetDB().Get(&value, `SELECT value FROM counter WHERE id = 0`)
+	if value != 11 {
+		t.Errorf("Expected counter to be 11, got %d", value)
+	}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+
+	failed := errors.New("failed")
+	calls := 0
+	err := db.WithTx(context.Background(), func(tx *sqlx.Tx) error {
+		calls++
+		tx.MustExec(`UPDATE counter SET value = 5 WHERE id = 0`)
+		return failed
+	})
+	if !errors.Is(err, failed) || calls != 1 {
+		t.Errorf("Expected one attempt failing with the closure's error, got %d, %v", calls, err)
+	}
+	var value int
+	db.GetDB().Get(&value, `SELECT value FROM counter WHERE id = 0`)
+	if value != 0 {
+		t.Errorf("Expected the update to be rolled back, got %d", value)
+	}
+
+	// A cancelled context stops the retries while the lock is held
+	lock := other.MustBegin()
+	lock.MustExec(`UPDATE counter SET value = value + 10 WHERE id = 0`)
+	defer lock.Rollback()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = db.WithTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to stop the retries, got %v", err)
+	}
+}
+
+func TestBusyEventDoesNotAdvanceEventId(t *testing.T) {
+	db, other := setupContendedDatabase(t)
+
+	var calls atomic.Int32
+	AddGenericEventHandler(db, "Counter:Increment", func(tx *sqlx.Tx, _ []byte) (bool, error) {
+		calls.Add(1)
+		_, err := tx.Exec(`UPDATE counter SET value = value + 1 WHERE id = 0`)
+		return true, err
+	})
+
+	// A reader keeps the transaction from committing
+	reader := other.MustBegin()
+	var value int
+	if err := reader.Get(&value, `SELECT value FROM counter WHERE id = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HandleEvent(1, "Counter:Increment", []byte(`{}`)); !IsRetryable(err) {
+		t.Fatalf("Expected a retryable error, got %v", err)
+	}
+	if got := db.CurrentEventID(); got != 0 {
+		t.Fatalf("Expected the rolled back event to leave the event ID at 0, got %d", got)
+	}
+	reader.Rollback()
+
+	// The redelivered event is applied rather than skipped
+	calls.Store(0)
+	if err := db.HandleEvent(1, "Counter:Increment", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected the redelivered event to be applied, got %v", err)
+	}
+	if calls.Load() != 1 || db.CurrentEventID() != 1 {
+		t.Errorf("Expected one handler call and event ID 1, got %d calls and event ID %d", calls.Load(), db.CurrentEventID())
+	}
+}
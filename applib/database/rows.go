@@ -0,0 +1,121 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// deletedFilter selects which rows of a soft-deleting table a RowQuery
+// returns.
+type deletedFilter int
+
+const (
+	excludeDeleted deletedFilter = iota
+	includeDeleted
+	onlyDeleted
+)
+
+// RowQuery selects rows of one table. For tables registered with
+// RegisterSoftDeleteTable it leaves out the soft-deleted rows, unless
+// WithDeleted or OnlyDeleted is called.
+type RowQuery struct {
+	table   string
+	columns string
+	where   string
+	args    []any
+	orderBy string
+	limit   int
+	offset  int
+	deleted deletedFilter
+}
+
+// Rows starts a query for the given columns, e.g. "id, username", of the
+// table.
+func Rows(table, columns string) *RowQuery {
+	return &RowQuery{table: table, columns: columns}
+}
+
+// Where restricts the query to rows matching the condition, whose
+// placeholders are numbered from $1.
+func (q *RowQuery) Where(condition string, args ...any) *RowQuery {
+	q.where = condition
+	q.args = args
+	return q
+}
+
+// OrderBy sets the ORDER BY clause, e.g. "id DESC".
+func (q *RowQuery) OrderBy(order string) *RowQuery {
+	q.orderBy = order
+	return q
+}
+
+// Limit returns at most limit rows, skipping the first offset, for paging
+// through a list.
+func (q *RowQuery) Limit(limit, offset int) *RowQuery {
+	q.limit = limit
+	q.offset = offset
+	return q
+}
+
+// WithDeleted includes soft-deleted rows.
+func (q *RowQuery) WithDeleted() *RowQuery {
+	q.deleted = includeDeleted
+	return q
+}
+
+// OnlyDeleted returns only soft-deleted rows, e.g. to list what can be
+// restored.
+func (q *RowQuery) OnlyDeleted() *RowQuery {
+	q.deleted = onlyDeleted
+	return q
+}
+
+// SQL returns the query and its arguments.
+func (q *RowQuery) SQL() (string, []any) {
+	var conditions []string
+	if column, ok := softDeleteColumn(q.table); ok {
+		switch q.deleted {
+		case excludeDeleted:
+			conditions = append(conditions, column+" IS NULL")
+		case onlyDeleted:
+			conditions = append(conditions, column+" IS NOT NULL")
+		}
+	}
+	if q.where != "" {
+		conditions = append(conditions, "("+q.where+")")
+	}
+
+	var query strings.Builder
+	fmt.Fprintf(&query, "SELECT %s FROM %s", q.columns, q.table)
+	if len(conditions) > 0 {
+		query.WriteString(" WHERE " + strings.Join(conditions, " AND "))
+	}
+	if q.orderBy != "" {
+		query.WriteString(" ORDER BY " + q.orderBy)
+	}
+	if q.limit > 0 {
+		fmt.Fprintf(&query, " LIMIT %d OFFSET %d", q.limit, q.offset)
+	}
+	return query.String(), q.args
+}
+
+// Select scans all matching rows into dest, a pointer to a slice.
+func (q *RowQuery) Select(db sqlx.Queryer, dest any) error {
+	query, args := q.SQL()
+	return sqlx.Select(db, dest, query, args...)
+}
+
+// Get scans the first matching row into dest, failing with sql.ErrNoRows if
+// there is none.
+func (q *RowQuery) Get(db sqlx.Queryer, dest any) error {
+	query, args := q.SQL()
+	return sqlx.Get(db, dest, query, args...)
+}
+
+// Query returns the matching rows, to be read one at a time.
+func (q *RowQuery) Query(db sqlx.Queryer) (*sqlx.Rows, error) {
+	query, args := q.SQL()
+	return db.Queryx(query, args...)
+}
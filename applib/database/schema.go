@@ -0,0 +1,133 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration upgrades the application schema from Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(tx *sqlx.Tx) error
+}
+
+// SchemaVersionError is returned by Initialize when the database on disk was
+// written by a newer version of the application than the running binary.
+type SchemaVersionError struct {
+	Stored   int
+	Expected int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the version %d expected by this binary; refusing to start", e.Stored, e.Expected)
+}
+
+// SetSchemaVersion declares the schema version this binary expects, along with
+// the migrations needed to reach it from older versions. Must be called before
+// Initialize.
+func (db *Database) SetSchemaVersion(version int, migrations ...Migration) {
+	db.schemaVersion = version
+	db.migrations = migrations
+}
+
+const upsertSchemaVersionSql = `
+	INSERT INTO schema_version (id, version) VALUES (0, $1)
+	ON CONFLICT (id) DO UPDATE SET version = excluded.version`
+
+func tableExists(db *sqlx.DB, name string) (bool, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`, name)
+	return count > 0, err
+}
+
+// checkSchemaVersion compares the stored schema version against the expected
+// one, refusing to continue if the database is newer and running forward
+// migrations if it is older. A database that has never been initialized is
+// stamped with the expected version since its tables were just created by
+// this binary.
+func (db *Database) checkSchemaVersion() error {
+	initialized, err := tableExists(db.db, "event_state")
+	if err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+
+	_, err = db.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+		    id INTEGER PRIMARY KEY,
+			version INTEGER NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+
+	var stored int
+	if initialized {
+		err = db.db.Get(&stored, `SELECT COALESCE(MAX(version), 0) FROM schema_version WHERE id = 0`)
+		if err != nil {
+			return fmt.Errorf("failed to get schema version: %w", err)
+		}
+	} else {
+		stored = db.schemaVersion
+	}
+
+	if stored > db.schemaVersion {
+		return &SchemaVersionError{Stored: stored, Expected: db.schemaVersion}
+	}
+
+	migrations := make(map[int]Migration, len(db.migrations))
+	for _, migration := range db.migrations {
+		migrations[migration.Version] = migration
+	}
+
+	for version := stored + 1; version <= db.schemaVersion; version++ {
+		migration, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("no migration registered to upgrade schema to version %d", version)
+		}
+		logf(slog.LevelInfo, "Migrating schema to version %d: %s", version, migration.Description)
+		if err := db.applyMigration(migration); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.db.Exec(upsertSchemaVersionSql, db.schemaVersion)
+	if err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	return nil
+}
+
+// applyMigration runs a single migration and records the new schema version
+// in the same transaction.
+func (db *Database) applyMigration(migration Migration) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migration.Apply(tx); err != nil {
+		return fmt.Errorf("migration to schema version %d failed: %w", migration.Version, err)
+	}
+	if _, err := tx.Exec(upsertSchemaVersionSql, migration.Version); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the schema version this binary expects, see
+// SetSchemaVersion.
+func (db *Database) SchemaVersion() int {
+	return db.schemaVersion
+}
+
+// StoredSchemaVersion returns the schema version recorded in the database,
+// which matches SchemaVersion once Initialize has run the migrations.
+func (db *Database) StoredSchemaVersion() (int, error) {
+	var stored int
+	err := db.db.Get(&stored, `SELECT COALESCE(MAX(version), 0) FROM schema_version WHERE id = 0`)
+	return stored, err
+}
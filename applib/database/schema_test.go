@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func setupTestDatabase(t *testing.T) (*Database, string) {
+	dbPath := path.Join(t.TempDir(), "app.sqlite")
+	db, err := Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	t.Cleanup(func() { db.GetDB().Close() })
+	return db, dbPath
+}
+
+// initializeSchema runs the same startup steps as Initialize without
+// registering HTTP handlers, which can only happen once per process.
+func initializeSchema(t *testing.T, db *Database) error {
+	if err := db.checkSchemaVersion(); err != nil {
+		return err
+	}
+	eventState, err := NewEventState(db.GetDB())
+	if err != nil {
+		t.Fatalf("NewEventState returned error: %v", err)
+	}
+	db.eventState = eventState
+	return nil
+}
+
+func storedSchemaVersion(t *testing.T, db *Database) int {
+	var version int
+	if err := db.GetDB().Get(&version, `SELECT version FROM schema_version WHERE id = 0`); err != nil {
+		t.Fatalf("Failed to read schema version: %v", err)
+	}
+	return version
+}
+
+func TestFreshDatabaseStampedWithExpectedVersion(t *testing.T) {
+	db, _ := setupTestDatabase(t)
+	applied := false
+	db.SetSchemaVersion(2, Migration{Version: 2, Apply: func(tx *sqlx.Tx) error {
+		applied = true
+		return nil
+	}})
+
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("Expected fresh database to initialize, got %v", err)
+	}
+	if applied {
+		t.Error("Expected migrations not to run against a fresh database")
+	}
+	if version := storedSchemaVersion(t, db); version != 2 {
+		t.Errorf("Expected schema version 2, got %d", version)
+	}
+}
+
+func TestNewerDatabaseRefused(t *testing.T) {
+	db, dbPath := setupTestDatabase(t)
+	db.SetSchemaVersion(3)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("Initialize returned error: %v", err)
+	}
+
+	oldBinary, err := Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer oldBinary.GetDB().Close()
+	oldBinary.SetSchemaVersion(2)
+
+	err = oldBinary.checkSchemaVersion()
+	var versionErr *SchemaVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("Expected SchemaVersionError, got %v", err)
+	}
+	if versionErr.Stored != 3 || versionErr.Expected != 2 {
+		t.Errorf("Expected stored=3 expected=2, got stored=%d expected=%d", versionErr.Stored, versionErr.Expected)
+	}
+}
+
+func TestOlderDatabaseMigrated(t *testing.T) {
+	db, dbPath := setupTestDatabase(t)
+	db.SetSchemaVersion(1)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("Initialize returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE items_v1 (id INTEGER PRIMARY KEY)`)
+
+	newBinary, err := Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer newBinary.GetDB().Close()
+
+	var applied []int
+	newBinary.SetSchemaVersion(3,
+		Migration{Version: 3, Description: "add items name", Apply: func(tx *sqlx.Tx) error {
+			applied = append(applied, 3)
+			_, err := tx.Exec(`ALTER TABLE items_v1 ADD COLUMN name TEXT`)
+			return err
+		}},
+		Migration{Version: 2, Description: "add items owner", Apply: func(tx *sqlx.Tx) error {
+			applied = append(applied, 2)
+			_, err := tx.Exec(`ALTER TABLE items_v1 ADD COLUMN owner TEXT`)
+			return err
+		}},
+	)
+
+	if err := newBinary.checkSchemaVersion(); err != nil {
+		t.Fatalf("Expected migrations to succeed, got %v", err)
+	}
+	if len(applied) != 2 || applied[0] != 2 || applied[1] != 3 {
+		t.Errorf("Expected migrations [2 3] in order, got %v", applied)
+	}
+	if version := storedSchemaVersion(t, newBinary); version != 3 {
+		t.Errorf("Expected schema version 3, got %d", version)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db, dbPath := setupTestDatabase(t)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("Initialize returned error: %v", err)
+	}
+
+	newBinary, err := Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer newBinary.GetDB().Close()
+	newBinary.SetSchemaVersion(2,
+		Migration{Version: 1, Apply: func(tx *sqlx.Tx) error { return nil }},
+		Migration{Version: 2, Apply: func(tx *sqlx.Tx) error { return errors.New("boom") }},
+	)
+
+	if err := newBinary.checkSchemaVersion(); err == nil {
+		t.Fatal("Expected failed migration to return an error")
+	}
+	if version := storedSchemaVersion(t, newBinary); version != 1 {
+		t.Errorf("Expected schema version to stop at 1, got %d", version)
+	}
+}
+
+func TestMissingMigration(t *testing.T) {
+	db, dbPath := setupTestDatabase(t)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("Initialize returned error: %v", err)
+	}
+
+	newBinary, err := Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer newBinary.GetDB().Close()
+	newBinary.SetSchemaVersion(1)
+
+	if err := newBinary.checkSchemaVersion(); err == nil {
+		t.Fatal("Expected missing migration to return an error")
+	}
+}
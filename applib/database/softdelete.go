@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DefaultSoftDeleteRetention is how long soft-deleted rows are kept,
+	// and can be restored, before they are purged.
+	DefaultSoftDeleteRetention = 30 * 24 * time.Hour
+	// SoftDeletePurgeInterval is how often rows past the retention period
+	// are purged.
+	SoftDeletePurgeInterval = time.Hour
+)
+
+// ErrRowNotFound is returned by SoftDeleteRow when the table has no live row
+// with the ID, and by RestoreRow when it has no soft-deleted one.
+var ErrRowNotFound = errors.New("row not found")
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// softDeleteColumns maps each table registered for soft deletion to its
+// deleted-at column. It is global, like the HTTP handlers registered by
+// InitHandlers, so that helpers called from event handlers only need the
+// transaction.
+var softDeleteColumns = struct {
+	sync.RWMutex
+	columns map[string]string
+}{columns: make(map[string]string)}
+
+// RegisterSoftDeleteTable marks a table as soft-deleting: its rows are
+// deleted with SoftDeleteRow, which sets deletedAtColumn to the current time,
+// and can be brought back with RestoreRow. Queries built with Rows leave the
+// deleted rows out. Once a row has been deleted for longer than the
+// retention period, see SetSoftDeleteRetention, it is purged for good.
+//
+// The column must be a nullable TIMESTAMP. It panics if either name is not a
+// plain SQL identifier or the table is already registered with another
+// column.
+func (db *Database) RegisterSoftDeleteTable(table, deletedAtColumn string) {
+	if !identifierPattern.MatchString(table) || !identifierPattern.MatchString(deletedAtColumn) {
+		panic(fmt.Sprintf("invalid soft delete table %q or column %q", table, deletedAtColumn))
+	}
+	softDeleteColumns.Lock()
+	defer softDeleteColumns.Unlock()
+	if column, ok := softDeleteColumns.columns[table]; ok && column != deletedAtColumn {
+		panic(fmt.Sprintf("soft delete table %s is already registered with column %s", table, column))
+	}
+	softDeleteColumns.columns[table] = deletedAtColumn
+	if db.softDeleteTables == nil {
+		db.softDeleteTables = make(map[string]string)
+	}
+	db.softDeleteTables[table] = deletedAtColumn
+}
+
+// SetSoftDeleteRetention sets how long soft-deleted rows are kept before
+// they are purged. Must be called before Initialize.
+func (db *Database) SetSoftDeleteRetention(retention time.Duration) {
+	db.softDeleteRetention = retention
+}
+
+func softDeleteColumn(table string) (string, bool) {
+	softDeleteColumns.RLock()
+	defer softDeleteColumns.RUnlock()
+	column, ok := softDeleteColumns.columns[table]
+	return column, ok
+}
+
+// NotDeleted returns an SQL condition matching the rows of the table that
+// are not soft-deleted, for queries not built with Rows. It is always true
+// for tables not registered with RegisterSoftDeleteTable.
+func NotDeleted(table string) string {
+	if column, ok := softDeleteColumn(table); ok {
+		return column + " IS NULL"
+	}
+	return "1"
+}
+
+// SoftDeleteRow marks the row of a soft-deleting table with the given rowid
+// deleted. Replaying the event log sets the time again, restarting the
+// retention period.
+func SoftDeleteRow(tx *sqlx.Tx, table string, rowid int64) error {
+	return setDeletedAt(tx, table, rowid, true)
+}
+
+// RestoreRow undoes SoftDeleteRow for the row with the given rowid, for
+// handlers of undo events. It fails with ErrRowNotFound if the row is not
+// deleted or has already been purged.
+func RestoreRow(tx *sqlx.Tx, table string, rowid int64) error {
+	return setDeletedAt(tx, table, rowid, false)
+}
+
+func setDeletedAt(tx *sqlx.Tx, table string, rowid int64, deleted bool) error {
+	column, ok := softDeleteColumn(table)
+	if !ok {
+		return fmt.Errorf("table %s is not registered for soft deletion", table)
+	}
+	var result sql.Result
+	var err error
+	if deleted {
+		result, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE rowid = $2 AND %s IS NULL`, table, column, column),
+			time.Now().UTC(), rowid)
+	} else {
+		result, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = NULL WHERE rowid = $1 AND %s IS NOT NULL`, table, column, column),
+			rowid)
+	}
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s row %d", ErrRowNotFound, table, rowid)
+	}
+	return nil
+}
+
+// PurgeSoftDeleted permanently removes the rows of every soft-deleting table
+// that were deleted before the given time, returning how many were removed.
+// It runs every SoftDeletePurgeInterval while the database is initialized.
+func (db *Database) PurgeSoftDeleted(before time.Time) (int64, error) {
+	tables := make([]string, 0, len(db.softDeleteTables))
+	for table := range db.softDeleteTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return 0, wrapBusyError(err)
+	}
+	defer tx.Rollback()
+	var purged int64
+	for _, table := range tables {
+		column := db.softDeleteTables[table]
+		result, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s IS NOT NULL AND %s < $1`, table, column, column), before.UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge %s: %w", table, wrapBusyError(err))
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		purged += rowsAffected
+	}
+	return purged, wrapBusyError(tx.Commit())
+}
+
+// softDeletePurger runs PurgeSoftDeleted in the background until stopped.
+type softDeletePurger struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (db *Database) startSoftDeletePurge() {
+	if len(db.softDeleteTables) == 0 || db.softDeletePurger != nil {
+		return
+	}
+	retention := db.softDeleteRetention
+	if retention <= 0 {
+		retention = DefaultSoftDeleteRetention
+	}
+	purger := &softDeletePurger{stop: make(chan struct{}), done: make(chan struct{})}
+	db.softDeletePurger = purger
+	go func() {
+		defer close(purger.done)
+		ticker := time.NewTicker(SoftDeletePurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-purger.stop:
+				return
+			case <-ticker.C:
+				purged, err := db.PurgeSoftDeleted(time.Now().Add(-retention))
+				if err != nil {
+					logf(slog.LevelWarn, "Failed to purge soft-deleted rows: %v", err)
+				} else if purged > 0 {
+					logf(slog.LevelInfo, "Purged %d soft-deleted rows", purged)
+				}
+			}
+		}
+	}()
+}
+
+func (db *Database) stopSoftDeletePurge() {
+	if db.softDeletePurger == nil {
+		return
+	}
+	close(db.softDeletePurger.stop)
+	<-db.softDeletePurger.done
+	db.softDeletePurger = nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type widgetEvent struct {
+	ID int64 `json:"id"`
+}
+
+// setupWidgetsDatabase returns an initialized database with a soft-deleting
+// widgets table holding widgets 1 to 3, of which 2 is deleted.
+func setupWidgetsDatabase(t *testing.T) *Database {
+	db, _ := setupTestDatabase(t)
+	if err := initializeSchema(t, db); err != nil {
+		t.Fatalf("initializeSchema returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE widgets (id INTEGER PRIMARY KEY, name TEXT NOT NULL, deleted_at TIMESTAMP)`)
+	db.GetDB().MustExec(`INSERT INTO widgets (id, name) VALUES (1, 'one'), (2, 'two'), (3, 'three')`)
+	db.RegisterSoftDeleteTable("widgets", "deleted_at")
+	AddEventHandler(db, "Widget:Delete", func(tx *sqlx.Tx, event widgetEvent) (bool, error) {
+		return true, SoftDeleteRow(tx, "widgets", event.ID)
+	})
+	AddEventHandler(db, "Widget:Restore", func(tx *sqlx.Tx, event widgetEvent) (bool, error) {
+		return true, RestoreRow(tx, "widgets", event.ID)
+	})
+	if err := db.HandleEvent(1, "Widget:Delete", []byte(`{"id":2}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+	return db
+}
+
+func widgetNames(t *testing.T, db *Database, query *RowQuery) string {
+	t.Helper()
+	var names []string
+	if err := query.Select(db.GetDB(), &names); err != nil {
+		t.Fatalf("Select returned error: %v", err)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSoftDeleteFiltersQueries(t *testing.T) {
+	db := setupWidgetsDatabase(t)
+
+	for _, tc := range []struct {
+		query *RowQuery
+		names string
+	}{
+		{Rows("widgets", "name").OrderBy("id"), "one,three"},
+		{Rows("widgets", "name").Where("id > $1 OR name = $2", 1, "one").OrderBy("id"), "one,three"},
+		{Rows("widgets", "name").OrderBy("id").Limit(1, 1), "three"},
+		{Rows("widgets", "name").OrderBy("id").WithDeleted(), "one,two,three"},
+		{Rows("widgets", "name").OnlyDeleted(), "two"},
+	} {
+		query, _ := tc.query.SQL()
+		if names := widgetNames(t, db, tc.query); names != tc.names {
+			t.Errorf("%s: expected %q, got %q", query, tc.names, names)
+		}
+	}
+
+	var name string
+	if err := Rows("widgets", "name").Where("id = $1", 2).Get(db.GetDB(), &name); err == nil {
+		t.Errorf("Expected the deleted widget not to be found, got %q", name)
+	}
+	var count int
+	db.GetDB().Get(&count, `SELECT COUNT(*) FROM widgets WHERE `+NotDeleted("widgets"))
+	if count != 2 {
+		t.Errorf("Expected 2 live widgets, got %d", count)
+	}
+
+	// Deleting a deleted row fails like deleting a missing one
+	if err := db.HandleEvent(2, "Widget:Delete", []byte(`{"id":2}`)); !errors.Is(err, ErrRowNotFound) {
+		t.Errorf("Expected ErrRowNotFound, got %v", err)
+	}
+}
+
+func TestRestoreRow(t *testing.T) {
+	db := setupWidgetsDatabase(t)
+	if err := db.HandleEvent(2, "Widget:Restore", []byte(`{"id":2}`)); err != nil {
+		t.Fatalf("HandleEvent returned error: %v", err)
+	}
+	if names := widgetNames(t, db, Rows("widgets", "name").OrderBy("id")); names != "one,two,three" {
+		t.Errorf("Expected the widget to be restored, got %q", names)
+	}
+	if err := db.HandleEvent(3, "Widget:Restore", []byte(`{"id":2}`)); !errors.Is(err, ErrRowNotFound) {
+		t.Errorf("Expected restoring a live row to fail with ErrRowNotFound, got %v", err)
+	}
+}
+
+func TestPurgeSoftDeleted(t *testing.T) {
+	db := setupWidgetsDatabase(t)
+
+	// Rows deleted within the retention period are kept
+	purged, err := db.PurgeSoftDeleted(time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Fatalf("Expected nothing to be purged, got %d: %v", purged, err)
+	}
+	purged, err = db.PurgeSoftDeleted(time.Now().Add(time.Second))
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected one row to be purged, got %d: %v", purged, err)
+	}
+	if names := widgetNames(t, db, Rows("widgets", "name").OrderBy("id").WithDeleted()); names != "one,three" {
+		t.Errorf("Expected the deleted widget to be gone, got %q", names)
+	}
+	if err := db.HandleEvent(2, "Widget:Restore", []byte(`{"id":2}`)); !errors.Is(err, ErrRowNotFound) {
+		t.Errorf("Expected restoring a purged row to fail with ErrRowNotFound, got %v", err)
+	}
+}
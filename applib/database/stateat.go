@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrEventNotApplied is returned by StateAtEvent for an event ID the
+// database has not reached yet.
+var ErrEventNotApplied = errors.New("event has not been applied")
+
+// StateAtEvent reconstructs the application's state as it was right after
+// the given event was applied, for debugging and audits. The live schema is
+// copied into a throwaway in-memory database and the logged events up to
+// eventID are replayed into it through the registered handlers, as
+// ImportEvents would, so the live tables are never touched. An eventID of
+// zero gives the empty state.
+//
+// The returned database is read-only and must be closed by the caller. It
+// has a single connection, so rows must be closed before the next query.
+// Since there are no state snapshots to start from, every call replays the
+// log from the start; events applied before the event log was introduced are
+// missing from the reconstructed state.
+func (db *Database) StateAtEvent(ctx context.Context, eventID int64) (*sqlx.DB, error) {
+	if eventID < 0 || eventID > int64(db.eventState.CurrentEventId) {
+		return nil, fmt.Errorf("%w: %d (current event ID is %d)", ErrEventNotApplied, eventID, db.eventState.CurrentEventId)
+	}
+
+	// The schema and the event log are read from the same snapshot
+	live, err := db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, wrapBusyError(err)
+	}
+	defer live.Rollback()
+
+	// Every connection to :memory: opens a database of its own
+	state, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	state.SetMaxOpenConns(1)
+	state.SetConnMaxLifetime(0)
+	if err := db.replayStateAt(ctx, live, state, int(eventID)); err != nil {
+		state.Close()
+		return nil, err
+	}
+	if _, err := state.ExecContext(ctx, `PRAGMA query_only = ON`); err != nil {
+		state.Close()
+		return nil, err
+	}
+	return state, nil
+}
+
+// replayStateAt creates the live schema in state and replays the logged
+// events up to eventID into it.
+func (db *Database) replayStateAt(ctx context.Context, live *sqlx.Tx, state *sqlx.DB, eventID int) error {
+	// Tables first, so that indexes, views and triggers can refer to them
+	var schema []string
+	err := live.SelectContext(ctx, &schema, `
+		SELECT sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, rowid`)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	tx, err := state.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range schema {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to copy schema: %w", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO event_state (id, current_event_id) VALUES (0, $1)`, eventID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, upsertSchemaVersionSql, db.schemaVersion); err != nil {
+		return err
+	}
+
+	err = db.forEachLoggedEventUpTo(live, eventID, func(event LoggedEvent) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := db.runHandlers(tx, event.Type, event.Data, true); err != nil {
+			return fmt.Errorf("failed to replay event %d: %w", event.ID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
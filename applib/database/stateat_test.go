@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStateAtEvent(t *testing.T) {
+	db := setupItemsDatabase(t)
+	db.GetDB().MustExec(`CREATE INDEX items_name ON items (name)`)
+	for i, name := range []string{"apple", "banana", "cherry"} {
+		if err := db.HandleEvent(10*(i+1), "ItemAdded", []byte(`{"name":"`+name+`"}`)); err != nil {
+			t.Fatalf("HandleEvent returned error: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		eventID int64
+		names   string
+	}{
+		{0, ""},
+		{10, "apple"},
+		{25, "apple,banana"},
+		{30, "apple,banana,cherry"},
+	} {
+		state, err := db.StateAtEvent(context.Background(), tc.eventID)
+		if err != nil {
+			t.Fatalf("StateAtEvent(%d) returned error: %v", tc.eventID, err)
+		}
+		var names []string
+		if err := state.Select(&names, `SELECT name FROM items ORDER BY rowid`); err != nil {
+			t.Fatalf("Failed to read items: %v", err)
+		}
+		if strings.Join(names, ",") != tc.names {
+			t.Errorf("StateAtEvent(%d): expected items %q, got %v", tc.eventID, tc.names, names)
+		}
+		var currentEventId int64
+		if err := state.Get(&currentEventId, `SELECT current_event_id FROM event_state WHERE id = 0`); err != nil || currentEventId != tc.eventID {
+			t.Errorf("StateAtEvent(%d): expected the event state to match, got %d: %v", tc.eventID, currentEventId, err)
+		}
+		if _, err := state.Exec(`INSERT INTO items (name) VALUES ('durian')`); err == nil {
+			t.Errorf("StateAtEvent(%d): expected the state to be read-only", tc.eventID)
+		}
+		state.Close()
+	}
+
+	if names := itemNames(t, db); strings.Join(names, ",") != "apple,banana,cherry" {
+		t.Errorf("Expected the live tables to be untouched, got %v", names)
+	}
+	if _, err := db.StateAtEvent(context.Background(), 31); !errors.Is(err, ErrEventNotApplied) {
+		t.Errorf("Expected ErrEventNotApplied, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.StateAtEvent(ctx, 30); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the replay to stop with its context, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RowResult is a row sent by SelectChan, or the error that ended the query.
+type RowResult[T any] struct {
+	Row T
+	Err error
+}
+
+// ForEach runs the query and calls fn with each row scanned into a T, one at
+// a time, so that large results are never held in memory at once. Rows are
+// scanned as by sqlx.Select: into the fields of a struct by their db tags,
+// or into T itself for a single column. It stops at the first error fn
+// returns, and with ctx.Err() when ctx is done. A RowQuery's SQL method
+// gives the query and arguments for a table's rows.
+func ForEach[T any](ctx context.Context, db sqlx.QueryerContext, fn func(row T) error, query string, args ...any) error {
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	scan := rowScanner[T]()
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var row T
+		if err := scan(rows, &row); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// SelectChan runs the query and sends its rows, scanned as by ForEach, on
+// the returned channel, which is closed after the last row. An error while
+// reading the rows is sent as a final RowResult. The query holds a
+// connection until the channel is closed, so callers that stop reading early
+// must cancel ctx.
+func SelectChan[T any](ctx context.Context, db sqlx.QueryerContext, query string, args ...any) (<-chan RowResult[T], error) {
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan RowResult[T])
+	go func() {
+		defer close(results)
+		defer rows.Close()
+		send := func(result RowResult[T]) bool {
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		scan := rowScanner[T]()
+		for rows.Next() {
+			var row T
+			if err := scan(rows, &row); err != nil {
+				send(RowResult[T]{Err: err})
+				return
+			}
+			if !send(RowResult[T]{Row: row}) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			send(RowResult[T]{Err: err})
+		}
+	}()
+	return results, nil
+}
+
+// rowScanner returns how rows are scanned into a T: by column name for
+// structs, directly for anything else, as sqlx.Select does.
+func rowScanner[T any]() func(rows *sqlx.Rows, dest *T) error {
+	destType := reflect.TypeFor[T]()
+	if destType.Kind() == reflect.Struct && destType != reflect.TypeFor[time.Time]() &&
+		!reflect.PointerTo(destType).Implements(reflect.TypeFor[sql.Scanner]()) {
+		return func(rows *sqlx.Rows, dest *T) error {
+			return rows.StructScan(dest)
+		}
+	}
+	return func(rows *sqlx.Rows, dest *T) error {
+		return rows.Scan(dest)
+	}
+}
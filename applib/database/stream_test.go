@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type widgetRow struct {
+	ID   int    `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestForEach(t *testing.T) {
+	db := setupWidgetsDatabase(t)
+	ctx := context.Background()
+
+	var names []string
+	query, args := Rows("widgets", "id, name").OrderBy("id").SQL()
+	err := ForEach(ctx, db.GetDB(), func(row widgetRow) error {
+		names = append(names, row.Name)
+		return nil
+	}, query, args...)
+	if err != nil || strings.Join(names, ",") != "one,three" {
+		t.Errorf("Unexpected rows %v, %v", names, err)
+	}
+
+	// Single columns are scanned directly, and fn's error stops the query
+	stop := errors.New("stop")
+	var ids []int
+	err = ForEach(ctx, db.GetDB(), func(id int) error {
+		ids = append(ids, id)
+		return stop
+	}, `SELECT id FROM widgets ORDER BY id`)
+	if !errors.Is(err, stop) || len(ids) != 1 {
+		t.Errorf("Expected to stop after one row, got %v, %v", ids, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := ForEach(cancelled, db.GetDB(), func(id int) error { return nil }, `SELECT id FROM widgets`); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled context to stop the query, got %v", err)
+	}
+}
+
+func TestSelectChan(t *testing.T) {
+	db := setupWidgetsDatabase(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results, err := SelectChan[widgetRow](ctx, db.GetDB(), `SELECT id, name FROM widgets WHERE id > $1 ORDER BY id`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for result := range results {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		names = append(names, result.Row.Name)
+	}
+	if strings.Join(names, ",") != "two,three" {
+		t.Errorf("Unexpected rows %v", names)
+	}
+
+	// Scan errors end the stream
+	results, err = SelectChan[widgetRow](ctx, db.GetDB(), `SELECT id, name, deleted_at FROM widgets`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last RowResult[widgetRow]
+	for result := range results {
+		last = result
+	}
+	if last.Err == nil {
+		t.Error("Expected an error for a column with no field")
+	}
+
+	// Cancelling closes the channel without reading the rest
+	ids, err := SelectChan[int](ctx, db.GetDB(), `SELECT id FROM widgets ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := <-ids; first.Err != nil || first.Row != 1 {
+		t.Errorf("Unexpected first row %+v", first)
+	}
+	cancel()
+	for range ids {
+	}
+
+	if _, err := SelectChan[int](context.Background(), db.GetDB(), `SELECT nope FROM widgets`); err == nil {
+		t.Error("Expected an error for an invalid query")
+	}
+}
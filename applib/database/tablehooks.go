@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Operations reported to table change handlers.
+const (
+	TableInsert = "insert"
+	TableUpdate = "update"
+	TableDelete = "delete"
+	// TableBulkChange is reported, with a rowid of 0, in place of individual
+	// changes when more than MaxCoalescedTableChanges rows of a table change
+	// within one delivery interval. Handlers should re-read the table.
+	TableBulkChange = "bulk"
+)
+
+const (
+	// DefaultTableHookInterval is how long changes to a table are collected
+	// and coalesced before handlers are called.
+	DefaultTableHookInterval = 100 * time.Millisecond
+	// MaxCoalescedTableChanges is the most individual row changes delivered
+	// for one table per interval before they collapse into TableBulkChange.
+	MaxCoalescedTableChanges = 100
+)
+
+// TableChangeHandler is called with the operation and rowid of a changed row.
+type TableChangeHandler func(op string, rowid int64)
+
+// SQLite's codes for the operation passed to update hooks. go-sqlite3 only
+// defines them when built with cgo.
+const (
+	sqliteDelete = 9
+	sqliteInsert = 18
+	sqliteUpdate = 23
+)
+
+type tableChange struct {
+	table string
+	op    string
+	rowid int64
+}
+
+// tableHooks collects row changes reported by SQLite's update hook and
+// delivers them to handlers once the transaction that made them commits.
+type tableHooks struct {
+	mu       sync.Mutex
+	handlers map[string][]TableChangeHandler
+	queued   []tableChange
+	running  bool
+	interval time.Duration
+	wake     chan struct{}
+}
+
+func newTableHooks() *tableHooks {
+	return &tableHooks{
+		handlers: make(map[string][]TableChangeHandler),
+		interval: DefaultTableHookInterval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (h *tableHooks) watching(table string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.handlers[table]) > 0
+}
+
+// install registers the hooks on a new connection. SQLite calls them on the
+// goroutine using the connection, inside the statement or commit, so they
+// only record changes and never block.
+func (h *tableHooks) install(conn *sqlite3.SQLiteConn) error {
+	var mu sync.Mutex
+	var pending []tableChange
+
+	conn.RegisterUpdateHook(func(op int, _ string, table string, rowid int64) {
+		if !h.watching(table) {
+			return
+		}
+		change := tableChange{table: table, rowid: rowid}
+		switch op {
+		case sqliteInsert:
+			change.op = TableInsert
+		case sqliteUpdate:
+			change.op = TableUpdate
+		case sqliteDelete:
+			change.op = TableDelete
+		default:
+			return
+		}
+		mu.Lock()
+		pending = append(pending, change)
+		mu.Unlock()
+	})
+	conn.RegisterCommitHook(func() int {
+		mu.Lock()
+		committed := pending
+		pending = nil
+		mu.Unlock()
+		h.enqueue(committed)
+		return 0 // Allow the commit
+	})
+	conn.RegisterRollbackHook(func() {
+		mu.Lock()
+		pending = nil
+		mu.Unlock()
+	})
+	return nil
+}
+
+func (h *tableHooks) enqueue(changes []tableChange) {
+	if len(changes) == 0 {
+		return
+	}
+	h.mu.Lock()
+	h.queued = append(h.queued, changes...)
+	h.mu.Unlock()
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers committed changes. After the first change arrives it waits one
+// interval, then calls each table's handlers with the coalesced changes, so
+// each table's handlers run at most once per interval.
+func (h *tableHooks) run() {
+	for range h.wake {
+		time.Sleep(h.interval)
+
+		h.mu.Lock()
+		queued := h.queued
+		h.queued = nil
+		h.mu.Unlock()
+
+		var tables []string
+		byTable := make(map[string][]tableChange)
+		for _, change := range queued {
+			if _, ok := byTable[change.table]; !ok {
+				tables = append(tables, change.table)
+			}
+			byTable[change.table] = append(byTable[change.table], change)
+		}
+		for _, table := range tables {
+			h.deliver(table, coalesceChanges(byTable[table]))
+		}
+	}
+}
+
+func (h *tableHooks) deliver(table string, changes []tableChange) {
+	h.mu.Lock()
+	handlers := h.handlers[table]
+	h.mu.Unlock()
+
+	if len(changes) > MaxCoalescedTableChanges {
+		changes = []tableChange{{table: table, op: TableBulkChange}}
+	}
+	for _, change := range changes {
+		for _, handler := range handlers {
+			handler(change.op, change.rowid)
+		}
+	}
+}
+
+// coalesceChanges merges repeated changes to the same row, in order of each
+// row's first change. An insert followed by updates is still an insert, and a
+// row inserted and deleted within the window is dropped.
+func coalesceChanges(changes []tableChange) []tableChange {
+	var order []int64
+	ops := make(map[int64]string)
+	for _, change := range changes {
+		previous, seen := ops[change.rowid]
+		if !seen {
+			order = append(order, change.rowid)
+			ops[change.rowid] = change.op
+			continue
+		}
+		switch {
+		case previous == TableInsert && change.op == TableUpdate:
+			// Still a new row
+		case previous == TableInsert && change.op == TableDelete:
+			ops[change.rowid] = ""
+		case previous == "" && change.op != TableDelete:
+			ops[change.rowid] = TableInsert
+		case previous == TableDelete && change.op == TableInsert:
+			// The rowid was reused
+			ops[change.rowid] = TableUpdate
+		default:
+			ops[change.rowid] = change.op
+		}
+	}
+
+	result := make([]tableChange, 0, len(order))
+	for _, rowid := range order {
+		if op := ops[rowid]; op != "" {
+			result = append(result, tableChange{table: changes[0].table, op: op, rowid: rowid})
+		}
+	}
+	return result
+}
+
+// hookConnector opens SQLite connections with the table hooks installed.
+type hookConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *hookConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *hookConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openWithHooks opens a SQLite database whose connections report row changes
+// to the given hooks.
+func openWithHooks(dsn string, hooks *tableHooks) *sql.DB {
+	return sql.OpenDB(&hookConnector{
+		driver: &sqlite3.SQLiteDriver{ConnectHook: hooks.install},
+		dsn:    dsn,
+	})
+}
+
+// OnTableChange calls fn after each committed transaction that inserts,
+// updates or deletes rows in the given table, whichever code path made the
+// change. Changes from rolled-back transactions are never reported. Changes
+// are delivered on a background goroutine, coalesced per table over
+// DefaultTableHookInterval; see TableBulkChange for large batches.
+//
+// Table hooks are only available for databases opened with the "sqlite3"
+// driver.
+func (db *Database) OnTableChange(table string, fn TableChangeHandler) error {
+	if db.tableHooks == nil {
+		return fmt.Errorf("table change hooks require the sqlite3 driver")
+	}
+	hooks := db.tableHooks
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.handlers[table] = append(hooks.handlers[table], fn)
+	if !hooks.running {
+		hooks.running = true
+		go hooks.run()
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedChange struct {
+	op    string
+	rowid int64
+}
+
+// watchTable registers a handler on the table and returns a function that
+// waits for the handler's calls to settle and returns them.
+func watchTable(t *testing.T, db *Database, table string) func() []recordedChange {
+	db.tableHooks.interval = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	var changes []recordedChange
+	err := db.OnTableChange(table, func(op string, rowid int64) {
+		mu.Lock()
+		changes = append(changes, recordedChange{op, rowid})
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("OnTableChange returned error: %v", err)
+	}
+	return func() []recordedChange {
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		result := changes
+		changes = nil
+		return result
+	}
+}
+
+func TestOnTableChangeIgnoresRollback(t *testing.T) {
+	db, _ := setupTestDatabase(t)
+	db.GetDB().MustExec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+	changes := watchTable(t, db, "items")
+
+	tx := db.GetDB().MustBegin()
+	tx.MustExec(`INSERT INTO items (name) VALUES ('discarded')`)
+	tx.Rollback()
+	if got := changes(); len(got) != 0 {
+		t.Errorf("Expected no callbacks for a rolled-back transaction, got %v", got)
+	}
+
+	tx = db.GetDB().MustBegin()
+	tx.MustExec(`INSERT INTO items (id, name) VALUES (7, 'kept')`)
+	tx.Commit()
+	got := changes()
+	if len(got) != 1 || got[0] != (recordedChange{TableInsert, 7}) {
+		t.Errorf("Expected one insert of row 7, got %v", got)
+	}
+}
+
+func TestOnTableChangeCoalesces(t *testing.T) {
+	db, _ := setupTestDatabase(t)
+	db.GetDB().MustExec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+	db.GetDB().MustExec(`CREATE TABLE other (id INTEGER PRIMARY KEY)`)
+	db.GetDB().MustExec(`INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b')`)
+	changes := watchTable(t, db, "items")
+
+	// Autocommit statements in quick succession
+	for i := 0; i < 5; i++ {
+		db.GetDB().MustExec(`UPDATE items SET name = $1 WHERE id = 1`, fmt.Sprintf("a%d", i))
+	}
+	db.GetDB().MustExec(`INSERT INTO items (id, name) VALUES (3, 'c')`)
+	db.GetDB().MustExec(`UPDATE items SET name = 'c2' WHERE id = 3`)
+	db.GetDB().MustExec(`INSERT INTO items (id, name) VALUES (4, 'd')`)
+	db.GetDB().MustExec(`DELETE FROM items WHERE id = 4`)
+	db.GetDB().MustExec(`DELETE FROM items WHERE id = 2`)
+	db.GetDB().MustExec(`INSERT INTO other (id) VALUES (1)`)
+
+	got := changes()
+	expected := []recordedChange{{TableUpdate, 1}, {TableInsert, 3}, {TableDelete, 2}}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected coalesced changes %v, got %v", expected, got)
+	}
+
+	// A bulk import collapses into a single notification
+	tx := db.GetDB().MustBegin()
+	for i := 0; i < MaxCoalescedTableChanges+1; i++ {
+		tx.MustExec(`INSERT INTO items (name) VALUES ('bulk')`)
+	}
+	tx.Commit()
+	got = changes()
+	if len(got) != 1 || got[0] != (recordedChange{TableBulkChange, 0}) {
+		t.Errorf("Expected a single bulk change, got %v", got)
+	}
+}
@@ -0,0 +1,25 @@
+package applib
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+// deadlineMiddleware applies the deadline sent by the proxy in the
+// X-Request-Deadline header to the request context, so handlers, database
+// queries and cross-service calls made with r.Context() stop once the proxy
+// has given up on the response. Every Application installs it.
+func deadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := httputils.RequestDeadline(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,34 @@
+package applib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+func TestRequestDeadlineHeader(t *testing.T) {
+	observed := make(chan time.Time, 2)
+	http.HandleFunc("/api/deadline-test", func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		observed <- deadline
+	})
+	handler := NewApplication(nil).Handler()
+
+	deadline := time.Now().Add(time.Minute).Round(0)
+	req := httptest.NewRequest(http.MethodGet, "/api/deadline-test", nil)
+	httputils.SetRequestDeadline(req.Header, deadline)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got := <-observed; !got.Equal(deadline) {
+		t.Errorf("Expected context deadline %v, got %v", deadline, got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/deadline-test", nil)
+	req.Header.Set(httputils.RequestDeadlineHeader, "not a time")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got := <-observed; !got.IsZero() {
+		t.Errorf("Expected no deadline for an invalid header, got %v", got)
+	}
+}
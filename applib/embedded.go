@@ -0,0 +1,117 @@
+package applib
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tomyedwab/yesterday/applib/database"
+)
+
+// EmbeddedSetup prepares an application to run embedded in the hub, see
+// RegisterEmbedded. It does what main does for an application run as its own
+// process before calling Serve, e.g. registering handlers and initializing
+// the database.
+type EmbeddedSetup func(app *Application) error
+
+// EmbeddedConfig is what the hub passes an embedded application in place of
+// the environment its own processes get.
+type EmbeddedConfig struct {
+	InstanceID string
+	// DatabasePath is the application's SQLite database, /db/app.sqlite for
+	// an application run as its own process.
+	DatabasePath string
+	// SecretFile is the file the hub keeps the internal secret in, see
+	// httputils.InternalSecret.
+	SecretFile string
+}
+
+var embedded = struct {
+	mu         sync.Mutex
+	setups     map[string]EmbeddedSetup
+	name       string // Application that was set up, if any
+	instanceID string
+	app        *Application
+	err        error // Why setting it up failed
+}{setups: make(map[string]EmbeddedSetup)}
+
+// RegisterEmbedded makes an application available to run embedded in the hub
+// under name, the name in its package manifest, for packages with the
+// embedded transport. Application packages call it from an init function and
+// are linked into the hub by importing them for their side effects. It
+// panics if name is registered twice.
+func RegisterEmbedded(name string, setup EmbeddedSetup) {
+	embedded.mu.Lock()
+	defer embedded.mu.Unlock()
+	if _, exists := embedded.setups[name]; exists {
+		panic(fmt.Sprintf("applib: embedded application %q registered twice", name))
+	}
+	embedded.setups[name] = setup
+}
+
+// IsEmbedded reports whether an application is registered under name.
+func IsEmbedded(name string) bool {
+	embedded.mu.Lock()
+	defer embedded.mu.Unlock()
+	_, exists := embedded.setups[name]
+	return exists
+}
+
+// StartEmbedded returns a server for an embedded instance of the application
+// registered under name, setting the application up the first time. The
+// caller serves it on a listener of its choice and shuts it down to stop the
+// instance; starting it again reuses the application, whose database stays
+// open.
+//
+// Applications register their handlers with http.DefaultServeMux and read
+// their configuration from the environment, in which StartEmbedded sets
+// INSTANCE_ID and INTERNAL_SECRET_FILE, so only one embedded instance can run
+// per process. An application whose setup failed cannot be started again
+// until the process restarts, since it may have registered some handlers.
+func StartEmbedded(name string, config EmbeddedConfig) (*http.Server, error) {
+	embedded.mu.Lock()
+	defer embedded.mu.Unlock()
+	if embedded.name != "" {
+		if embedded.name != name || embedded.instanceID != config.InstanceID {
+			return nil, fmt.Errorf("cannot run %s embedded: instance %s of %s already runs embedded in this process", config.InstanceID, embedded.instanceID, embedded.name)
+		}
+		if embedded.err != nil {
+			return nil, fmt.Errorf("embedded application %s failed to set up: %w", name, embedded.err)
+		}
+		return embedded.app.newServer(), nil
+	}
+	setup, exists := embedded.setups[name]
+	if !exists {
+		return nil, fmt.Errorf("no embedded application registered as %q", name)
+	}
+
+	embedded.name, embedded.instanceID = name, config.InstanceID
+	embedded.app, embedded.err = setUpEmbedded(setup, config)
+	if embedded.err != nil {
+		return nil, fmt.Errorf("embedded application %s failed to set up: %w", name, embedded.err)
+	}
+	return embedded.app.newServer(), nil
+}
+
+// setUpEmbedded does what Init and main do for an application run as its own
+// process.
+func setUpEmbedded(setup EmbeddedSetup, config EmbeddedConfig) (*Application, error) {
+	os.Setenv("INSTANCE_ID", config.InstanceID)
+	os.Setenv("INTERNAL_SECRET_FILE", config.SecretFile)
+	if err := os.MkdirAll(filepath.Dir(config.DatabasePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	db, err := database.Connect("sqlite3", config.DatabasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetInstanceID(config.InstanceID)
+	app := NewApplication(db)
+	if err := setup(app); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return app, nil
+}
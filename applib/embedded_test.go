@@ -0,0 +1,71 @@
+package applib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/applib/database"
+)
+
+func TestStartEmbedded(t *testing.T) {
+	t.Setenv("INSTANCE_ID", "")
+	t.Setenv("INTERNAL_SECRET_FILE", "")
+	setups := 0
+	RegisterEmbedded("embedded-test", func(app *Application) error {
+		setups++
+		http.HandleFunc("/api/embedded-test", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("instance " + r.Context().Value(ContextDatabaseKey).(*database.Database).InstanceID()))
+		})
+		return nil
+	})
+	RegisterEmbedded("embedded-other", func(app *Application) error {
+		return errors.New("not expected to be set up")
+	})
+	if !IsEmbedded("embedded-test") || IsEmbedded("missing") {
+		t.Error("Expected only registered applications to be embedded")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected registering a name twice to panic")
+			}
+		}()
+		RegisterEmbedded("embedded-test", nil)
+	}()
+
+	if _, err := StartEmbedded("missing", EmbeddedConfig{InstanceID: "app"}); err == nil {
+		t.Error("Expected an unregistered application not to start")
+	}
+	config := EmbeddedConfig{
+		InstanceID:   "app",
+		DatabasePath: filepath.Join(t.TempDir(), "db", "app.sqlite"),
+		SecretFile:   filepath.Join(t.TempDir(), "secret"),
+	}
+	server, err := StartEmbedded("embedded-test", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/embedded-test", nil).WithContext(server.BaseContext(nil)))
+	if body := recorder.Body.String(); body != "instance app" {
+		t.Errorf("Expected the handler to see the instance's database, got %q", body)
+	}
+
+	// Starting the instance again reuses the application
+	if again, err := StartEmbedded("embedded-test", config); err != nil || again == server || setups != 1 {
+		t.Errorf("Expected a new server for the same application, got %v after %d setups", err, setups)
+	}
+
+	// Only one instance runs embedded per process
+	_, err = StartEmbedded("embedded-test", EmbeddedConfig{InstanceID: "other"})
+	if err == nil || !strings.Contains(err.Error(), "already runs embedded") {
+		t.Errorf("Expected a second instance to be refused, got %v", err)
+	}
+	if _, err := StartEmbedded("embedded-other", config); err == nil {
+		t.Error("Expected a second application to be refused")
+	}
+}
@@ -0,0 +1,222 @@
+package applib
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+// Feature flag events are published to the hub like any other event, usually
+// with the admin CLI. Applications that use flags must list both event types
+// in the subscriptions of their manifest.
+const (
+	FeatureFlagSetEventType    = "FeatureFlag:Set"
+	FeatureFlagDeleteEventType = "FeatureFlag:Delete"
+)
+
+// FeatureFlagsPath is the data view serving the flags evaluated for the
+// requesting user.
+const FeatureFlagsPath = "/api/flags"
+
+// FeatureFlag is a flag scoped to one application instance. For a given user
+// the deny list takes precedence over the allow list, which takes precedence
+// over the percentage rollout. Users outside all three, and requests without
+// a user, get Default.
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	// Percentage of users, from 0 to 100, for whom the flag is enabled.
+	Percentage int   `json:"percentage"`
+	Allow      []int `json:"allow,omitempty"`
+	Deny       []int `json:"deny,omitempty"`
+}
+
+// FeatureFlagSetEvent creates or replaces a flag of an application instance.
+type FeatureFlagSetEvent struct {
+	InstanceID string `json:"instanceId"`
+	FeatureFlag
+}
+
+// FeatureFlagDeleteEvent removes a flag from an application instance.
+type FeatureFlagDeleteEvent struct {
+	InstanceID string `json:"instanceId"`
+	Name       string `json:"name"`
+}
+
+// FeatureFlagsData is the response of the /api/flags data view.
+type FeatureFlagsData struct {
+	Flags map[string]bool `json:"flags"`
+}
+
+// Enabled evaluates the flag for the user. A userID of zero means there is
+// no authenticated user.
+func (f *FeatureFlag) Enabled(userID int) bool {
+	if userID > 0 {
+		if slices.Contains(f.Deny, userID) {
+			return false
+		}
+		if slices.Contains(f.Allow, userID) {
+			return true
+		}
+		if FlagBucket(f.Name, userID) < f.Percentage {
+			return true
+		}
+	}
+	return f.Default
+}
+
+// FlagBucket places the user in one of 100 buckets for the flag's
+// percentage rollout. The bucket depends only on the flag name and user ID,
+// so a user keeps their bucket across processes and restarts, and raising
+// the percentage only ever adds users.
+func FlagBucket(name string, userID int) int {
+	hash := sha256.Sum256([]byte(name + ":" + strconv.Itoa(userID)))
+	return int(binary.BigEndian.Uint64(hash[:8]) % 100)
+}
+
+// featureFlagStore applies flag events for one application instance.
+type featureFlagStore struct {
+	instanceID string
+}
+
+// EnableFeatureFlags creates the flags table, registers the handlers for the
+// flag events addressed to this instance and serves the /api/flags data
+// view. Call it once, before initializing the database.
+func (app *Application) EnableFeatureFlags() error {
+	_, err := app.db.GetDB().Exec(`
+		CREATE TABLE IF NOT EXISTS feature_flags_v1 (
+			name TEXT PRIMARY KEY,
+			flag TEXT NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create feature flags table: %w", err)
+	}
+
+	store := &featureFlagStore{instanceID: app.db.InstanceID()}
+	database.AddEventHandler(app.db, FeatureFlagSetEventType, store.handleSet)
+	database.AddEventHandler(app.db, FeatureFlagDeleteEventType, store.handleDelete)
+	app.db.AddResetHandler(func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`DELETE FROM feature_flags_v1`)
+		return err
+	})
+
+	HandleAPI(FeatureFlagsPath, handleFeatureFlags, WithName("FeatureFlags"), WithResponse(FeatureFlagsData{}), AsDataView())
+	return nil
+}
+
+func (s *featureFlagStore) handleSet(tx *sqlx.Tx, event *FeatureFlagSetEvent) (bool, error) {
+	if event.InstanceID != s.instanceID {
+		return false, nil
+	}
+	if event.Name == "" {
+		return false, fmt.Errorf("feature flag has no name")
+	}
+	flag, err := json.Marshal(event.FeatureFlag)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal feature flag %s: %w", event.Name, err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO feature_flags_v1 (name, flag) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET flag = excluded.flag`,
+		event.Name, string(flag))
+	if err != nil {
+		return false, fmt.Errorf("failed to store feature flag %s: %w", event.Name, err)
+	}
+	return true, nil
+}
+
+func (s *featureFlagStore) handleDelete(tx *sqlx.Tx, event *FeatureFlagDeleteEvent) (bool, error) {
+	if event.InstanceID != s.instanceID {
+		return false, nil
+	}
+	result, err := tx.Exec(`DELETE FROM feature_flags_v1 WHERE name = $1`, event.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete feature flag %s: %w", event.Name, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete feature flag %s: %w", event.Name, err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// GetFeatureFlag returns the named flag, or nil if it is not set.
+func GetFeatureFlag(db *sqlx.DB, name string) (*FeatureFlag, error) {
+	var data string
+	err := db.Get(&data, `SELECT flag FROM feature_flags_v1 WHERE name = $1`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feature flag %s: %w", name, err)
+	}
+	var flag FeatureFlag
+	if err := json.Unmarshal([]byte(data), &flag); err != nil {
+		return nil, fmt.Errorf("failed to decode feature flag %s: %w", name, err)
+	}
+	return &flag, nil
+}
+
+// GetFeatureFlags returns every flag of the application, ordered by name.
+func GetFeatureFlags(db *sqlx.DB) ([]FeatureFlag, error) {
+	var rows []string
+	if err := db.Select(&rows, `SELECT flag FROM feature_flags_v1 ORDER BY name`); err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	flags := make([]FeatureFlag, len(rows))
+	for i, data := range rows {
+		if err := json.Unmarshal([]byte(data), &flags[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode feature flag: %w", err)
+		}
+	}
+	return flags, nil
+}
+
+// FlagEnabled evaluates the named flag for the user the request was made
+// for. Unknown flags are disabled. ctx must be a request context of an
+// application that called EnableFeatureFlags.
+func FlagEnabled(ctx context.Context, name string) bool {
+	db, ok := ctx.Value(ContextSqliteDatabaseKey).(*sqlx.DB)
+	if !ok {
+		return false
+	}
+	flag, err := GetFeatureFlag(db, name)
+	if err != nil {
+		log.Printf("Failed to evaluate feature flag: %v", err)
+		return false
+	}
+	if flag == nil {
+		return false
+	}
+	userID, _ := UserID(ctx)
+	return flag.Enabled(userID)
+}
+
+// handleFeatureFlags serves the flags evaluated for the requesting user. The
+// allow and deny lists are not exposed to clients.
+func handleFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value(ContextSqliteDatabaseKey).(*sqlx.DB)
+	flags, err := GetFeatureFlags(db)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		return
+	}
+	userID, _ := UserID(r.Context())
+	data := FeatureFlagsData{Flags: make(map[string]bool, len(flags))}
+	for _, flag := range flags {
+		data.Flags[flag.Name] = flag.Enabled(userID)
+	}
+	httputils.HandleAPIResponse(w, r, data, nil, http.StatusOK)
+}
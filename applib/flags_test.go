@@ -0,0 +1,114 @@
+package applib
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+func TestFlagBucketIsStable(t *testing.T) {
+	// The buckets must never change, or users would flip in and out of
+	// rollouts when the hashing code changes.
+	for userID, bucket := range map[int]int{1: 89, 2: 30, 3: 42, 42: 67, 1000: 35} {
+		if got := FlagBucket("new-editor", userID); got != bucket {
+			t.Errorf("Expected user %d in bucket %d, got %d", userID, bucket, got)
+		}
+	}
+
+	enabled := 0
+	for userID := 1; userID <= 10000; userID++ {
+		if FlagBucket("new-editor", userID) < 25 {
+			enabled++
+		}
+	}
+	if enabled < 2300 || enabled > 2700 {
+		t.Errorf("Expected about 25%% of users in a 25%% rollout, got %d of 10000", enabled)
+	}
+}
+
+func TestFeatureFlagPrecedence(t *testing.T) {
+	// User 1 is in bucket 89 and user 2 in bucket 30, see above
+	flag := FeatureFlag{Name: "new-editor", Percentage: 50, Allow: []int{1, 3}, Deny: []int{3, 2}}
+	tests := []struct {
+		userID int
+		want   bool
+	}{
+		{1, true},  // Allowed, though outside the rollout
+		{2, false}, // Denied, though inside the rollout
+		{3, false}, // Deny wins over allow
+		{42, false},
+		{1000, true},
+		{0, false}, // No user gets the default
+	}
+	for _, test := range tests {
+		if got := flag.Enabled(test.userID); got != test.want {
+			t.Errorf("Expected Enabled(%d) = %v, got %v", test.userID, test.want, got)
+		}
+	}
+
+	flag.Default = true
+	if !flag.Enabled(0) || !flag.Enabled(42) {
+		t.Error("Expected users outside the lists and rollout to get the default")
+	}
+	if flag.Enabled(2) {
+		t.Error("Expected the deny list to override the default")
+	}
+}
+
+func TestFeatureFlagEvents(t *testing.T) {
+	db, err := database.Connect("sqlite3", path.Join(t.TempDir(), "app.sqlite"))
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer db.Close()
+	db.SetInstanceID("app1")
+	app := NewApplication(db)
+	if err := app.EnableFeatureFlags(); err != nil {
+		t.Fatalf("EnableFeatureFlags returned error: %v", err)
+	}
+	if err := db.Initialize(); err != nil {
+		t.Fatalf("Initialize returned error: %v", err)
+	}
+
+	publish := func(eventID int, eventType string, event any) {
+		data, _ := json.Marshal(event)
+		if err := db.HandleEvent(eventID, eventType, data); err != nil {
+			t.Fatalf("HandleEvent returned error: %v", err)
+		}
+	}
+	publish(1, FeatureFlagSetEventType, FeatureFlagSetEvent{InstanceID: "app1", FeatureFlag: FeatureFlag{Name: "beta", Allow: []int{7}}})
+	publish(2, FeatureFlagSetEventType, FeatureFlagSetEvent{InstanceID: "app1", FeatureFlag: FeatureFlag{Name: "dark-mode", Default: true}})
+	publish(3, FeatureFlagSetEventType, FeatureFlagSetEvent{InstanceID: "other", FeatureFlag: FeatureFlag{Name: "other-app", Default: true}})
+
+	ctx := context.WithValue(context.Background(), ContextSqliteDatabaseKey, db.GetDB())
+	userCtx := context.WithValue(ctx, ContextUserIDKey, 7)
+	if FlagEnabled(ctx, "beta") || !FlagEnabled(userCtx, "beta") {
+		t.Error("Expected beta to be enabled only for the allowed user")
+	}
+	if FlagEnabled(userCtx, "other-app") || FlagEnabled(userCtx, "missing") {
+		t.Error("Expected flags of other instances and unknown flags to be disabled")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, FeatureFlagsPath, nil)
+	httputils.SetUserID(req.Header, 7)
+	rec := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rec, req.WithContext(ctx))
+	var data FeatureFlagsData
+	if err := json.NewDecoder(rec.Body).Decode(&data); err != nil {
+		t.Fatalf("Failed to decode %s response: %v", FeatureFlagsPath, err)
+	}
+	if len(data.Flags) != 2 || !data.Flags["beta"] || !data.Flags["dark-mode"] {
+		t.Errorf("Unexpected flags for user 7: %v", data.Flags)
+	}
+
+	publish(4, FeatureFlagDeleteEventType, FeatureFlagDeleteEvent{InstanceID: "app1", Name: "dark-mode"})
+	if FlagEnabled(userCtx, "dark-mode") {
+		t.Error("Expected a deleted flag to be disabled")
+	}
+}
@@ -0,0 +1,485 @@
+package httputils
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// BindQuery and BindJSON fill a struct from a request's query parameters or
+// JSON body and validate it, driven by struct tags:
+//
+//	type listParams struct {
+//		UserID int       `query:"userId,required" validate:"min=1"`
+//		Order  string    `query:"order" default:"asc" validate:"oneof=asc desc"`
+//		Since  time.Time `query:"since"`
+//	}
+//
+//   - query names the parameter a field is read from; fields without it are
+//     left alone by BindQuery. BindJSON reads the fields encoding/json would,
+//     matching names the same way.
+//   - required, as a query option or validate rule, rejects requests that
+//     leave the field out. Empty query parameters and JSON nulls count as
+//     left out, but an empty JSON string does not; use min=1 for that.
+//   - default is the value of a field that is left out, written like a
+//     query parameter.
+//   - validate lists rules: min=N and max=N bound numbers, and the length of
+//     strings (in characters), slices and maps; oneof=a b c limits the value
+//     to the listed ones; any other name is a validator registered with
+//     RegisterValidator. Fields that are left out without a default are not
+//     validated.
+//
+// Times are parsed like ParseTime in query parameters and like DecodeJSON in
+// bodies, and durations with time.ParseDuration. Every invalid field is
+// reported at once in a *ValidationError, which HandleAPIResponse writes as
+// an ErrorResponse. Invalid tags are programming errors and panic.
+
+// DefaultMaxBodyBytes is the size of the largest body BindJSON reads.
+const DefaultMaxBodyBytes = 1 << 20
+
+// ErrBodyTooLarge is returned by BindJSON for bodies over the limit.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// FieldError describes what is wrong with one field of a request. Field is
+// the query parameter or JSON name, empty for problems with the request as a
+// whole.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = strings.TrimSpace(field.Field + " " + field.Message)
+	}
+	return "invalid request: " + strings.Join(problems, "; ")
+}
+
+// ErrorResponse is the JSON body of responses to requests rejected with a
+// *ValidationError.
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// Validator checks the value of a field, returning an error that completes
+// the sentence "<field> ...", e.g. "must be a valid username".
+type Validator func(value any) error
+
+var (
+	validatorsMu sync.RWMutex
+	validators   = map[string]Validator{}
+)
+
+// builtinRules are the validate rules that can't be registered.
+var builtinRules = []string{"required", "min", "max", "oneof"}
+
+// RegisterValidator makes validator available to validate tags as name.
+// Applications register theirs before serving requests. It panics if the name
+// is taken.
+func RegisterValidator(name string, validator Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	if slices.Contains(builtinRules, name) || validators[name] != nil {
+		panic(fmt.Sprintf("httputils: validator %q is already registered", name))
+	}
+	validators[name] = validator
+}
+
+// HandleBindError rejects a request that BindQuery or BindJSON failed to
+// bind, with 413 for bodies over the limit and 400 otherwise.
+func HandleBindError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrBodyTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	HandleAPIResponse(w, r, nil, err, status)
+}
+
+// BindQuery fills the struct v points to from the request's query
+// parameters.
+func BindQuery(r *http.Request, v any) error {
+	target := bindTarget(v, "BindQuery")
+	query := r.URL.Query()
+	var problems []FieldError
+	for _, field := range queryFields(target.Type()) {
+		var values []string
+		for _, value := range query[field.name] {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		problems = append(problems, field.bind(target, len(values) > 0, func(dest reflect.Value) error {
+			return setStrings(dest, values)
+		})...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Fields: problems}
+	}
+	return nil
+}
+
+// BindJSON fills the struct v points to from the request's JSON body, of at
+// most DefaultMaxBodyBytes.
+func BindJSON(r *http.Request, v any) error {
+	return BindJSONLimit(r, v, DefaultMaxBodyBytes)
+}
+
+// BindJSONLimit is BindJSON for bodies of at most limit bytes.
+func BindJSONLimit(r *http.Request, v any, limit int64) error {
+	target := bindTarget(v, "BindJSON")
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return fmt.Errorf("%w: the limit is %d bytes", ErrBodyTooLarge, limit)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil || object == nil {
+		return &ValidationError{Fields: []FieldError{{Message: "body must be a JSON object"}}}
+	}
+
+	var problems []FieldError
+	for _, field := range bodyFields(target.Type()) {
+		member := jsonMember(object, field.name)
+		present := member != nil && !bytes.Equal(bytes.TrimSpace(member), []byte("null"))
+		problems = append(problems, field.bind(target, present, func(dest reflect.Value) error {
+			if err := DecodeJSON(member, dest.Addr().Interface()); err != nil {
+				return errors.New("must be " + describeType(dest.Type()))
+			}
+			return nil
+		})...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Fields: problems}
+	}
+	return nil
+}
+
+// jsonMember returns the member of object for a field name, matched the way
+// encoding/json does: exactly, or else case-insensitively.
+func jsonMember(object map[string]json.RawMessage, name string) json.RawMessage {
+	if member, ok := object[name]; ok {
+		return member
+	}
+	for key, member := range object {
+		if strings.EqualFold(key, name) {
+			return member
+		}
+	}
+	return nil
+}
+
+func bindTarget(v any, caller string) reflect.Value {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("httputils: %s needs a pointer to a struct, not %T", caller, v))
+	}
+	return target.Elem()
+}
+
+// boundField is a struct field bound to a query parameter or JSON member.
+type boundField struct {
+	name       string
+	index      []int
+	required   bool
+	hasDefault bool
+	def        string
+	rules      []string
+}
+
+func newBoundField(name string, index []int, tag reflect.StructTag) boundField {
+	field := boundField{name: name, index: index}
+	field.def, field.hasDefault = tag.Lookup("default")
+	if rules := tag.Get("validate"); rules != "" {
+		for _, rule := range strings.Split(rules, ",") {
+			if rule == "required" {
+				field.required = true
+			} else {
+				field.rules = append(field.rules, rule)
+			}
+		}
+	}
+	return field
+}
+
+func queryFields(t reflect.Type) []boundField {
+	var fields []boundField
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		tag, ok := structField.Tag.Lookup("query")
+		if !ok || tag == "-" || !structField.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		field := newBoundField(name, []int{i}, structField.Tag)
+		field.required = field.required || hasOption(options, "required")
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func bodyFields(t reflect.Type) []boundField {
+	fields := structFields(t)
+	bound := make([]boundField, len(fields))
+	for i, field := range fields {
+		bound[i] = newBoundField(field.name, field.index, t.FieldByIndex(field.index).Tag)
+	}
+	return bound
+}
+
+// bind sets the field from the request with set, or its default, and
+// validates it.
+func (field boundField) bind(target reflect.Value, present bool, set func(dest reflect.Value) error) []FieldError {
+	dest := settableField(target, field.index)
+	switch {
+	case present:
+		if err := set(dest); err != nil {
+			return []FieldError{{Field: field.name, Message: err.Error()}}
+		}
+	case field.required:
+		return []FieldError{{Field: field.name, Message: "is required"}}
+	case field.hasDefault:
+		if err := setStrings(dest, []string{field.def}); err != nil {
+			panic(fmt.Sprintf("httputils: invalid default %q for %s: %v", field.def, field.name, err))
+		}
+	default:
+		return nil
+	}
+	var problems []FieldError
+	for _, rule := range field.rules {
+		if err := checkRule(rule, dest); err != nil {
+			problems = append(problems, FieldError{Field: field.name, Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+// settableField returns the field of target at index, allocating embedded
+// struct pointers on the way.
+func settableField(target reflect.Value, index []int) reflect.Value {
+	v := target
+	for i, step := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(step)
+	}
+	return v
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setStrings sets dest from query parameter values: every value for slices,
+// otherwise the only one.
+func setStrings(dest reflect.Value, values []string) error {
+	switch {
+	case dest.Kind() == reflect.Pointer:
+		value := reflect.New(dest.Type().Elem())
+		if err := setStrings(value.Elem(), values); err != nil {
+			return err
+		}
+		dest.Set(value)
+		return nil
+	case dest.Kind() == reflect.Slice && dest.Type().Elem().Kind() != reflect.Uint8:
+		slice := reflect.MakeSlice(dest.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setString(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		dest.Set(slice)
+		return nil
+	case len(values) > 1:
+		return errors.New("must only be given once")
+	}
+	return setString(dest, values[0])
+}
+
+func setString(dest reflect.Value, value string) error {
+	invalid := errors.New("must be " + describeType(dest.Type()))
+	switch dest.Type() {
+	case timeType:
+		t, err := ParseTime(value)
+		if err != nil {
+			return invalid
+		}
+		dest.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return invalid
+		}
+		dest.SetInt(int64(d))
+		return nil
+	}
+	if unmarshaler, ok := dest.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := unmarshaler.UnmarshalText([]byte(value)); err != nil {
+			return invalid
+		}
+		return nil
+	}
+
+	switch dest.Kind() {
+	case reflect.String:
+		dest.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid
+		}
+		dest.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, dest.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		dest.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, dest.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		dest.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, dest.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		dest.SetFloat(f)
+	default:
+		panic(fmt.Sprintf("httputils: can't bind a query parameter to %s", dest.Type()))
+	}
+	return nil
+}
+
+// describeType describes the values of t that are accepted, after "must be".
+func describeType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return "a time"
+	case durationType:
+		return "a duration"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem()), "a "), "an ")
+	default:
+		return "an object"
+	}
+}
+
+// checkRule checks a validate rule other than required against the value of
+// dest.
+func checkRule(rule string, dest reflect.Value) error {
+	for dest.Kind() == reflect.Pointer {
+		if dest.IsNil() {
+			return nil
+		}
+		dest = dest.Elem()
+	}
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "min", "max":
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("httputils: invalid validate rule %q", rule))
+		}
+		value, unit := measure(dest)
+		if (name == "min" && value < bound) || (name == "max" && value > bound) {
+			limit := "at least"
+			if name == "max" {
+				limit = "at most"
+			}
+			if unit == "" {
+				return fmt.Errorf("must be %s %s", limit, arg)
+			}
+			return fmt.Errorf("must have %s %s %s", limit, arg, unit)
+		}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		values := []reflect.Value{dest}
+		if dest.Kind() == reflect.Slice {
+			values = values[:0]
+			for i := 0; i < dest.Len(); i++ {
+				values = append(values, dest.Index(i))
+			}
+		}
+		for _, value := range values {
+			if !slices.Contains(allowed, fmt.Sprint(value.Interface())) {
+				return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+			}
+		}
+	default:
+		validatorsMu.RLock()
+		validator := validators[name]
+		validatorsMu.RUnlock()
+		if validator == nil {
+			panic(fmt.Sprintf("httputils: unknown validate rule %q", rule))
+		}
+		return validator(dest.Interface())
+	}
+	return nil
+}
+
+// measure returns the value min and max compare: numbers themselves, or the
+// length of strings, slices and maps in the returned unit.
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "items"
+	}
+	panic(fmt.Sprintf("httputils: min and max don't apply to %s", v.Type()))
+}
+
+// writeValidationError writes the ErrorResponse for err with status.
+func writeValidationError(w http.ResponseWriter, err *ValidationError, status int) {
+	body, _ := json.Marshal(ErrorResponse{Error: err.Error(), Fields: err.Fields})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,227 @@
+package httputils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testQueryParams struct {
+	UserID int           `query:"userId,required" validate:"min=1"`
+	Order  string        `query:"order" default:"asc" validate:"oneof=asc desc"`
+	Limit  int           `query:"limit" default:"20" validate:"min=1,max=100"`
+	Since  time.Time     `query:"since"`
+	Wait   time.Duration `query:"wait"`
+	Tags   []string      `query:"tag" validate:"max=2"`
+	Exact  *bool         `query:"exact"`
+	Name   string        `query:"name" validate:"min=2,nospaces"`
+	Other  string
+}
+
+func init() {
+	RegisterValidator("nospaces", func(value any) error {
+		if strings.Contains(value.(string), " ") {
+			return errors.New("must not contain spaces")
+		}
+		return nil
+	})
+}
+
+func TestBindQuery(t *testing.T) {
+	exact := true
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected testQueryParams
+		problems []FieldError
+	}{{
+		name:     "defaults",
+		query:    "userId=7",
+		expected: testQueryParams{UserID: 7, Order: "asc", Limit: 20},
+	}, {
+		name:     "all set",
+		query:    "userId=7&order=desc&limit=100&since=2026-10-17T02:00:00Z&wait=5s&tag=a&tag=b&exact=true&name=tom&Other=x",
+		expected: testQueryParams{UserID: 7, Order: "desc", Limit: 100, Since: time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC), Wait: 5 * time.Second, Tags: []string{"a", "b"}, Exact: &exact, Name: "tom"},
+	}, {
+		name:     "unix milliseconds",
+		query:    "userId=7&since=1792202400000",
+		expected: testQueryParams{UserID: 7, Order: "asc", Limit: 20, Since: time.UnixMilli(1792202400000).UTC()},
+	}, {
+		name:     "empty counts as missing",
+		query:    "userId=&order=",
+		problems: []FieldError{{"userId", "is required"}},
+	}, {
+		name:     "missing required",
+		query:    "order=asc",
+		problems: []FieldError{{"userId", "is required"}},
+	}, {
+		name:     "types",
+		query:    "userId=x&limit=1.5&since=soon&wait=forever&exact=maybe",
+		problems: []FieldError{{"userId", "must be an integer"}, {"limit", "must be an integer"}, {"since", "must be a time"}, {"wait", "must be a duration"}, {"exact", "must be true or false"}},
+	}, {
+		name:     "rules",
+		query:    "userId=0&order=random&limit=101&tag=a&tag=b&tag=c&name=a+b",
+		problems: []FieldError{{"userId", "must be at least 1"}, {"order", "must be one of asc, desc"}, {"limit", "must be at most 100"}, {"tag", "must have at most 2 items"}, {"name", "must not contain spaces"}},
+	}, {
+		name:     "string length",
+		query:    "userId=1&name=a",
+		problems: []FieldError{{"name", "must have at least 2 characters"}},
+	}, {
+		name:     "repeated",
+		query:    "userId=1&userId=2",
+		problems: []FieldError{{"userId", "must only be given once"}},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var params testQueryParams
+			err := BindQuery(httptest.NewRequest(http.MethodGet, "/api/test?"+test.query, nil), &params)
+			if test.problems == nil {
+				if err != nil {
+					t.Fatalf("BindQuery returned error: %v", err)
+				}
+				if !reflect.DeepEqual(params, test.expected) {
+					t.Errorf("Expected %+v, got %+v", test.expected, params)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected a ValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Fields, test.problems) {
+				t.Errorf("Expected %+v, got %+v", test.problems, validationErr.Fields)
+			}
+		})
+	}
+}
+
+type testBodyBase struct {
+	Note string `json:"note" validate:"max=5"`
+}
+
+type testBody struct {
+	testBodyBase
+	Username string            `json:"username" validate:"required,min=3"`
+	Role     string            `json:"role" default:"user" validate:"oneof=user admin"`
+	Age      int               `json:"age" validate:"min=0"`
+	At       time.Time         `json:"at"`
+	IDs      []int             `json:"ids" validate:"min=1"`
+	Labels   map[string]string `json:"labels"`
+	Secret   string            `json:"-"`
+	Legacy   string
+}
+
+func TestBindJSON(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		body     string
+		expected testBody
+		problems []FieldError
+	}{{
+		name:     "defaults",
+		body:     `{"username": "tom"}`,
+		expected: testBody{Username: "tom", Role: "user"},
+	}, {
+		name: "all set",
+		body: `{"username": "tom", "role": "admin", "age": 40, "at": 1792202400000, "ids": [1, 2], "labels": {"a": "b"}, "note": "hi", "LEGACY": "x", "Secret": "y", "unknown": 1}`,
+		expected: testBody{
+			testBodyBase: testBodyBase{Note: "hi"},
+			Username:     "tom", Role: "admin", Age: 40, At: time.UnixMilli(1792202400000).UTC(),
+			IDs: []int{1, 2}, Labels: map[string]string{"a": "b"}, Legacy: "x",
+		},
+	}, {
+		name:     "case-insensitive names and null",
+		body:     `{"Username": "tom", "role": null}`,
+		expected: testBody{Username: "tom", Role: "user"},
+	}, {
+		name:     "missing required",
+		body:     `{"username": null}`,
+		problems: []FieldError{{"username", "is required"}},
+	}, {
+		name:     "types and rules",
+		body:     `{"username": "to", "role": "root", "age": "old", "at": "today", "ids": [], "labels": [], "note": "too long"}`,
+		problems: []FieldError{{"username", "must have at least 3 characters"}, {"role", "must be one of user, admin"}, {"age", "must be an integer"}, {"at", "must be a time"}, {"ids", "must have at least 1 items"}, {"labels", "must be an object"}, {"note", "must have at most 5 characters"}},
+	}, {
+		name:     "not an object",
+		body:     `["tom"]`,
+		problems: []FieldError{{"", "body must be a JSON object"}},
+	}, {
+		name:     "empty",
+		body:     ``,
+		problems: []FieldError{{"", "body must be a JSON object"}},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var body testBody
+			err := BindJSON(httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(test.body)), &body)
+			if test.problems == nil {
+				if err != nil {
+					t.Fatalf("BindJSON returned error: %v", err)
+				}
+				if !reflect.DeepEqual(body, test.expected) {
+					t.Errorf("Expected %+v, got %+v", test.expected, body)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected a ValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Fields, test.problems) {
+				t.Errorf("Expected %+v, got %+v", test.problems, validationErr.Fields)
+			}
+		})
+	}
+}
+
+func TestBindJSONLimit(t *testing.T) {
+	var body testBody
+	r := httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(`{"username": "tom"}`))
+	if err := BindJSONLimit(r, &body, 10); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("Expected ErrBodyTooLarge, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	HandleBindError(rec, r, fmt.Errorf("%w: the limit is 10 bytes", ErrBodyTooLarge))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", rec.Code)
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/test?userId=0&limit=x", nil)
+	var params testQueryParams
+	err := BindQuery(r, &params)
+	rec := httptest.NewRecorder()
+	HandleBindError(rec, r, err)
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a 400 JSON response, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	expected := ErrorResponse{
+		Error:  "invalid request: userId must be at least 1; limit must be an integer",
+		Fields: []FieldError{{"userId", "must be at least 1"}, {"limit", "must be an integer"}},
+	}
+	if !reflect.DeepEqual(response, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, response)
+	}
+}
+
+func TestRegisterValidatorRejectsDuplicates(t *testing.T) {
+	for _, name := range []string{"nospaces", "min"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected registering %q to panic", name)
+				}
+			}()
+			RegisterValidator(name, func(any) error { return nil })
+		}()
+	}
+}
@@ -0,0 +1,100 @@
+package httputils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type lookupRequest struct {
+	ID int `json:"id"`
+}
+
+type lookupResponse struct {
+	Username string `json:"username"`
+}
+
+// serveCrossService points cross-service requests at handler for the test
+func serveCrossService(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	host := crossServiceHost
+	crossServiceHost = strings.TrimPrefix(server.URL, "https://")
+	t.Cleanup(func() { crossServiceHost = host })
+}
+
+func TestCallService(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	serveCrossService(t, func(w http.ResponseWriter, r *http.Request) {
+		var req lookupRequest
+		if r.URL.Path != "/internal/user" || r.Header.Get("X-Application-Id") != "users" || !IsInternalRequest(r) {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID != 2 {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		HandleAPIResponse(w, r, lookupResponse{Username: "tom"}, nil, http.StatusOK)
+	})
+
+	resp, status, err := CallService[lookupRequest, lookupResponse]("users", "/internal/user", lookupRequest{ID: 2})
+	if err != nil || status != http.StatusOK || resp.Username != "tom" {
+		t.Errorf("Expected the decoded response, got %+v %d: %v", resp, status, err)
+	}
+}
+
+func TestCallServiceErrorStatus(t *testing.T) {
+	serveCrossService(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "user not found", http.StatusNotFound)
+	})
+
+	_, status, err := CallService[lookupRequest, lookupResponse]("users", "/internal/user", lookupRequest{ID: 3})
+	if status != http.StatusNotFound || err == nil || !strings.Contains(err.Error(), "user not found") {
+		t.Errorf("Expected a 404 error with the message, got %d: %v", status, err)
+	}
+}
+
+func TestCallServiceEmptyResponse(t *testing.T) {
+	serveCrossService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	resp, status, err := CallService[lookupRequest, lookupResponse]("users", "/internal/user", lookupRequest{ID: 2})
+	if err != nil || status != http.StatusNoContent || resp.Username != "" {
+		t.Errorf("Expected an empty response, got %+v %d: %v", resp, status, err)
+	}
+}
+
+func TestCallServiceSandboxed(t *testing.T) {
+	t.Setenv("SANDBOX", "1")
+	t.Setenv("INSTANCE_ID", "clone")
+	requests := 0
+	serveCrossService(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		HandleAPIResponse(w, r, lookupResponse{Username: "tom"}, nil, http.StatusOK)
+	})
+
+	if !Sandboxed() {
+		t.Fatal("Expected SANDBOX=1 to sandbox the instance")
+	}
+	if _, _, err := CallService[lookupRequest, lookupResponse]("users", "/internal/user", lookupRequest{ID: 2}); !errors.Is(err, ErrSandboxed) {
+		t.Errorf("Expected ErrSandboxed for another application, got %v", err)
+	}
+	var response lookupResponse
+	if _, err := CrossServiceRequest("/internal/user", "users", []byte(`{"id":2}`), &response); !errors.Is(err, ErrSandboxed) {
+		t.Errorf("Expected ErrSandboxed from CrossServiceRequest, got %v", err)
+	}
+	if requests != 0 {
+		t.Fatalf("Expected no requests to be sent, got %d", requests)
+	}
+
+	// Requests to the hub under the instance's own ID still go through
+	if _, _, err := CallService[lookupRequest, lookupResponse]("clone", "/events/stats", lookupRequest{}); err != nil || requests != 1 {
+		t.Errorf("Expected the request to the hub to be sent, got %d requests: %v", requests, err)
+	}
+}
@@ -0,0 +1,30 @@
+package httputils
+
+import (
+	"net/http"
+	"time"
+)
+
+// RequestDeadlineHeader carries the absolute time, in RFC 3339 format with
+// nanoseconds, after which the proxy will no longer wait for a response. The
+// proxy sets it on every request it forwards to an application.
+const RequestDeadlineHeader = "X-Request-Deadline"
+
+// SetRequestDeadline sets the deadline header on an outgoing request.
+func SetRequestDeadline(header http.Header, deadline time.Time) {
+	header.Set(RequestDeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+}
+
+// RequestDeadline returns the deadline carried by the request's deadline
+// header, if it is present and valid.
+func RequestDeadline(r *http.Request) (time.Time, bool) {
+	value := r.Header.Get(RequestDeadlineHeader)
+	if value == "" {
+		return time.Time{}, false
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return deadline, true
+}
@@ -0,0 +1,499 @@
+package httputils
+
+import (
+	"bytes"
+	"cmp"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON encoding policy for responses and events:
+//
+//   - time.Time values are written in UTC as RFC 3339 strings with nanosecond
+//     precision, or as int64 milliseconds since the epoch for clients that
+//     send TimeFormatHeader: unix-ms. Zero times are written as null.
+//   - Object keys are sorted, so equal values always encode to the same bytes
+//     and ETags derived from bodies are stable.
+//   - omitempty also omits zero times.
+//
+// MarshalJSON applies the policy, DecodeJSON reads either time format back,
+// and CheckEncodingPolicy lets applications test that their response types
+// follow it.
+
+// TimeFormatHeader is the request header clients use to choose how times are
+// encoded in JSON responses.
+const TimeFormatHeader = "X-Time-Format"
+
+// TimeFormat is a way of encoding times in JSON.
+type TimeFormat string
+
+const (
+	// TimeFormatRFC3339 encodes times as UTC RFC 3339 strings with nanosecond
+	// precision. It is the default.
+	TimeFormatRFC3339 TimeFormat = "rfc3339"
+	// TimeFormatUnixMillis encodes times as milliseconds since the epoch.
+	TimeFormatUnixMillis TimeFormat = "unix-ms"
+)
+
+// RequestTimeFormat returns the time format the request asks for with
+// TimeFormatHeader, TimeFormatRFC3339 if it asks for none or an unknown one.
+func RequestTimeFormat(r *http.Request) TimeFormat {
+	if TimeFormat(strings.TrimSpace(r.Header.Get(TimeFormatHeader))) == TimeFormatUnixMillis {
+		return TimeFormatUnixMillis
+	}
+	return TimeFormatRFC3339
+}
+
+// FormatTime formats t in the standard form: UTC RFC 3339 with nanosecond
+// precision.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// sqliteTimeFormats are the layouts the SQLite driver writes and reads
+// times in, without a trailing "Z".
+var sqliteTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses a stored time in the standard form or any of the older
+// ones: RFC 3339 with an offset, the SQLite driver's layouts, or Unix seconds
+// or milliseconds. The result is in UTC.
+func ParseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// Like the SQLite driver, treat values too large to be seconds as
+		// milliseconds
+		if n > 1e12 || n < -1e12 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	for _, layout := range sqliteTimeFormats {
+		if t, err := time.ParseInLocation(layout, strings.TrimSuffix(value, "Z"), time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// MarshalJSON encodes v following the encoding policy, with times in format.
+func MarshalJSON(v any, format TimeFormat) ([]byte, error) {
+	normalized, err := normalizeJSON(reflect.ValueOf(v), format, false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalized)
+}
+
+// DecodeJSON decodes data into v like json.Unmarshal, also accepting times
+// encoded as Unix milliseconds.
+func DecodeJSON(data []byte, v any) error {
+	data, err := millisToRFC3339(data, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	unmarshalerType   = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// jsonField is a struct field as encoding/json sees it.
+type jsonField struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	quoted    bool
+	tagged    bool
+}
+
+// structFields lists the fields encoding/json encodes for the struct type t.
+// The fields of untagged embedded structs are promoted unless an outer field
+// has the same name.
+func structFields(t reflect.Type) []jsonField {
+	var fields, promoted []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for _, inner := range structFields(embedded) {
+					inner.index = append([]int{i}, inner.index...)
+					promoted = append(promoted, inner)
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		fields = append(fields, jsonField{
+			name:      cmp.Or(name, field.Name),
+			index:     []int{i},
+			typ:       field.Type,
+			omitEmpty: hasOption(options, "omitempty"),
+			quoted:    hasOption(options, "string"),
+			tagged:    name != "",
+		})
+	}
+	for _, field := range promoted {
+		if !hasField(fields, field.name) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func hasField(fields []jsonField, name string) bool {
+	for _, field := range fields {
+		if field.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMarshaler reports whether encoding/json would encode v with its own
+// MarshalJSON or MarshalText method.
+func hasMarshaler(v reflect.Value) bool {
+	t := v.Type()
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) {
+		return true
+	}
+	pointer := reflect.PointerTo(t)
+	return v.CanAddr() && (pointer.Implements(marshalerType) || pointer.Implements(textMarshalerType))
+}
+
+// normalizeJSON converts v into maps, slices and values json.Marshal encodes
+// following the policy. Values with their own marshaler keep their encoding.
+func normalizeJSON(v reflect.Value, format TimeFormat, quoted bool) (any, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		// Pointers are followed first, so *time.Time follows the policy too
+		return normalizeJSON(v.Elem(), format, quoted)
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		switch {
+		case t.IsZero():
+			return nil, nil
+		case format == TimeFormatUnixMillis:
+			return t.UnixMilli(), nil
+		default:
+			return FormatTime(t), nil
+		}
+	}
+	if hasMarshaler(v) {
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+		data, err := json.Marshal(v.Interface())
+		return json.RawMessage(data), err
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		object := make(map[string]any)
+		for _, field := range structFields(v.Type()) {
+			value, ok := fieldByIndex(v, field.index)
+			if !ok || (field.omitEmpty && isEmptyValue(value)) {
+				continue
+			}
+			normalized, err := normalizeJSON(value, format, field.quoted)
+			if err != nil {
+				return nil, err
+			}
+			object[field.name] = normalized
+		}
+		return object, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		object := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := mapKey(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			if object[key], err = normalizeJSON(iter.Value(), format, false); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings
+			return v.Interface(), nil
+		}
+		fallthrough
+	case reflect.Array:
+		array := make([]any, v.Len())
+		for i := range array {
+			var err error
+			if array[i], err = normalizeJSON(v.Index(i), format, false); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+
+	if quoted {
+		switch v.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+			data, err := json.Marshal(v.Interface())
+			return string(data), err
+		}
+	}
+	return v.Interface(), nil
+}
+
+// fieldByIndex returns the field of v at index, or false if it is inside a
+// nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether omitempty omits v.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	case reflect.Struct:
+		return v.Type() == timeType && v.Interface().(time.Time).IsZero()
+	}
+	return false
+}
+
+// mapKey encodes a map key the way encoding/json does.
+func mapKey(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if marshaler, ok := key.Interface().(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		return string(text), err
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported map key type %s", key.Type())
+}
+
+// millisToRFC3339 rewrites the numbers in data that t holds as times into
+// RFC 3339 strings, which time.Time can decode. Values decoded by their own
+// UnmarshalJSON method are left as they are.
+func millisToRFC3339(data json.RawMessage, t reflect.Type) (json.RawMessage, error) {
+	if t == nil {
+		return data, nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	trimmed := bytes.TrimSpace(data)
+	if t == timeType {
+		if ms, err := strconv.ParseInt(string(trimmed), 10, 64); err == nil {
+			return json.Marshal(FormatTime(time.UnixMilli(ms)))
+		}
+		return data, nil
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) || len(trimmed) == 0 {
+		return data, nil
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && trimmed[0] == '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &object); err != nil {
+			return nil, err
+		}
+		fields := structFields(t)
+		for key, member := range object {
+			for _, field := range fields {
+				// encoding/json matches keys to field names case-insensitively
+				if strings.EqualFold(field.name, key) {
+					converted, err := millisToRFC3339(member, field.typ)
+					if err != nil {
+						return nil, err
+					}
+					object[key] = converted
+					break
+				}
+			}
+		}
+		return json.Marshal(object)
+	case t.Kind() == reflect.Map && trimmed[0] == '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &object); err != nil {
+			return nil, err
+		}
+		for key, member := range object {
+			converted, err := millisToRFC3339(member, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			object[key] = converted
+		}
+		return json.Marshal(object)
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && trimmed[0] == '[':
+		var array []json.RawMessage
+		if err := json.Unmarshal(trimmed, &array); err != nil {
+			return nil, err
+		}
+		for i, member := range array {
+			converted, err := millisToRFC3339(member, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			array[i] = converted
+		}
+		return json.Marshal(array)
+	}
+	return data, nil
+}
+
+// timestampSuffixes mark JSON names that should hold times.
+var timestampSuffixes = []string{"At", "Time", "Timestamp"}
+
+// CheckEncodingPolicy checks that sample, a populated value of a response
+// type, follows the encoding policy, for use in application tests:
+//
+//	if err := httputils.CheckEncodingPolicy(ListResponse{...}); err != nil {
+//		t.Error(err)
+//	}
+//
+// Exported fields must name their JSON key with a tag, fields named like
+// timestamps (createdAt, time) must be time.Time, and the value must decode
+// back to the same encoding in every time format.
+func CheckEncodingPolicy(sample any) error {
+	var problems []string
+	checkType(reflect.TypeOf(sample), reflect.TypeOf(sample).String(), map[reflect.Type]bool{}, &problems)
+
+	for _, format := range []TimeFormat{TimeFormatRFC3339, TimeFormatUnixMillis} {
+		encoded, err := MarshalJSON(sample, format)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", format, err))
+			continue
+		}
+		decoded := reflect.New(reflect.TypeOf(sample))
+		if err := DecodeJSON(encoded, decoded.Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: cannot decode %s: %v", format, encoded, err))
+			continue
+		}
+		reencoded, err := MarshalJSON(decoded.Elem().Interface(), format)
+		if err != nil || !bytes.Equal(encoded, reencoded) {
+			problems = append(problems, fmt.Sprintf("%s: %s does not round-trip, got %s", format, encoded, reencoded))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("encoding policy violations:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func checkType(t reflect.Type, path string, seen map[reflect.Type]bool, problems *[]string) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || seen[t] || reflect.PointerTo(t).Implements(marshalerType) {
+		return
+	}
+	seen[t] = true
+	for _, field := range structFields(t) {
+		fieldPath := path + "." + field.name
+		if !field.tagged {
+			*problems = append(*problems, fmt.Sprintf("%s: exported field has no json tag", fieldPath))
+		}
+		fieldType := field.typ
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType != timeType && looksLikeTimestamp(field.name) {
+			*problems = append(*problems, fmt.Sprintf("%s: looks like a timestamp but is %s, use time.Time", fieldPath, field.typ))
+		}
+		checkType(field.typ, fieldPath, seen, problems)
+	}
+}
+
+func looksLikeTimestamp(name string) bool {
+	if name == "time" || name == "timestamp" {
+		return true
+	}
+	for _, suffix := range timestampSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
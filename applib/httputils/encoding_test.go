@@ -0,0 +1,148 @@
+package httputils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAudit struct {
+	CreatedBy string `json:"createdBy"`
+}
+
+type testItem struct {
+	testAudit
+	Name      string          `json:"name"`
+	CreatedAt time.Time       `json:"createdAt"`
+	DeletedAt time.Time       `json:"deletedAt,omitempty"`
+	SeenAt    *time.Time      `json:"seenAt,omitempty"`
+	Count     int64           `json:"count,string"`
+	Extra     json.RawMessage `json:"extra,omitempty"`
+	Tags      map[string]int  `json:"tags"`
+	internal  string
+}
+
+// testTime is in a non-UTC zone to check that times are converted
+var testTime = time.Date(2026, 10, 16, 14, 30, 0, 123456789, time.FixedZone("CEST", 2*60*60))
+
+func newTestItem() testItem {
+	seen := testTime.Add(time.Hour)
+	return testItem{
+		testAudit: testAudit{CreatedBy: "tom"},
+		Name:      "item",
+		CreatedAt: testTime,
+		SeenAt:    &seen,
+		Count:     3,
+		Extra:     json.RawMessage(`{"b": 1, "a": 2}`),
+		Tags:      map[string]int{"z": 1, "a": 2},
+		internal:  "hidden",
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	data, err := MarshalJSON(newTestItem(), TimeFormatRFC3339)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keys are sorted, times are UTC with nanoseconds, zero times with
+	// omitempty are left out and values with their own marshaler are kept
+	expected := `{"count":"3","createdAt":"2026-10-16T12:30:00.123456789Z","createdBy":"tom","extra":{"b":1,"a":2},"name":"item","seenAt":"2026-10-16T13:30:00.123456789Z","tags":{"a":2,"z":1}}`
+	if string(data) != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, data)
+	}
+
+	data, err = MarshalJSON(newTestItem(), TimeFormatUnixMillis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"createdAt":1792153800123,`) || !strings.Contains(string(data), `"seenAt":1792157400123,`) {
+		t.Errorf("Expected times in milliseconds, got %s", data)
+	}
+
+	// Zero times without omitempty are null
+	data, _ = MarshalJSON(testItem{}, TimeFormatRFC3339)
+	if !strings.Contains(string(data), `"createdAt":null`) || strings.Contains(string(data), "deletedAt") {
+		t.Errorf("Expected a null createdAt and no deletedAt, got %s", data)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	for _, format := range []TimeFormat{TimeFormatRFC3339, TimeFormatUnixMillis} {
+		data, err := MarshalJSON([]testItem{newTestItem()}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var items []testItem
+		if err := DecodeJSON(data, &items); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		want := testTime
+		if format == TimeFormatUnixMillis {
+			want = want.Truncate(time.Millisecond)
+		}
+		if len(items) != 1 || !items[0].CreatedAt.Equal(want) || items[0].SeenAt == nil || !items[0].SeenAt.Equal(want.Add(time.Hour)) ||
+			items[0].CreatedBy != "tom" || items[0].Count != 3 {
+			t.Errorf("%s: unexpected round trip %+v", format, items)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)
+	for _, value := range []string{
+		"2026-10-16T12:30:00Z",
+		"2026-10-16T14:30:00+02:00",
+		"2026-10-16 12:30:00+00:00",
+		"2026-10-16 14:30:00.000+02:00",
+		"2026-10-16 12:30:00",
+		"2026-10-16T12:30:00",
+		"1792153800",
+		"1792153800000",
+	} {
+		got, err := ParseTime(value)
+		if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("ParseTime(%q) = %v, %v", value, got, err)
+		}
+	}
+	if _, err := ParseTime("yesterday"); err == nil {
+		t.Error("Expected an invalid time to be rejected")
+	}
+}
+
+func TestRequestTimeFormat(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	r.Header.Set(TimeFormatHeader, "unix-ms")
+	recorder := httptest.NewRecorder()
+	HandleAPIResponse(recorder, r, map[string]time.Time{"at": testTime}, nil, http.StatusOK)
+	if body := recorder.Body.String(); body != `{"at":1792153800123}` {
+		t.Errorf("Expected milliseconds when asked for, got %s", body)
+	}
+
+	r.Header.Set(TimeFormatHeader, "julian")
+	recorder = httptest.NewRecorder()
+	HandleAPIResponse(recorder, r, map[string]time.Time{"at": testTime}, nil, http.StatusOK)
+	if body := recorder.Body.String(); body != `{"at":"2026-10-16T12:30:00.123456789Z"}` {
+		t.Errorf("Expected the default format for unknown formats, got %s", body)
+	}
+}
+
+func TestCheckEncodingPolicy(t *testing.T) {
+	if err := CheckEncodingPolicy(newTestItem()); err != nil {
+		t.Errorf("Expected the item to follow the policy: %v", err)
+	}
+
+	type legacy struct {
+		ID        int   `json:"id"`
+		UpdatedAt int64 `json:"updatedAt"`
+		Name      string
+	}
+	err := CheckEncodingPolicy(struct {
+		Items []legacy `json:"items"`
+	}{Items: []legacy{{ID: 1, UpdatedAt: 1792153800}}})
+	if err == nil || !strings.Contains(err.Error(), ".items.updatedAt: looks like a timestamp") || !strings.Contains(err.Error(), ".items.Name: exported field has no json tag") {
+		t.Errorf("Expected the legacy fields to be reported, got %v", err)
+	}
+}
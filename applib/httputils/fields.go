@@ -0,0 +1,175 @@
+package httputils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FieldsParam is the query parameter clients use to request a subset of the
+// fields in each record of a response, e.g. ?fields=id,username
+const FieldsParam = "fields"
+
+// InvalidFieldsError is returned when a client requests fields that the
+// handler does not allow.
+type InvalidFieldsError struct {
+	Invalid []string
+}
+
+func (e *InvalidFieldsError) Error() string {
+	return fmt.Sprintf("invalid fields requested: %s", strings.Join(e.Invalid, ", "))
+}
+
+// ParseFields returns the sorted, de-duplicated list of fields requested in
+// the request's query string, or nil if no selection was requested. Every
+// requested field must appear in allowed.
+func ParseFields(r *http.Request, allowed []string) ([]string, error) {
+	param := r.URL.Query().Get(FieldsParam)
+	if param == "" {
+		return nil, nil
+	}
+
+	var fields, invalid []string
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || slices.Contains(fields, field) {
+			continue
+		}
+		if !slices.Contains(allowed, field) {
+			invalid = append(invalid, field)
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(invalid) > 0 {
+		return nil, &InvalidFieldsError{Invalid: invalid}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// SelectFields prunes the records in a response down to the given fields. A
+// record is each element of a top-level array, each element of an array
+// member of a top-level object (the {"users": [...]} shape used by data
+// views), or, if the top-level object has no such members, the object itself.
+func SelectFields(resp any, fields []string) (any, error) {
+	return selectFields(resp, fields, TimeFormatRFC3339)
+}
+
+// selectFields works like SelectFields, encoding the times in resp in format.
+func selectFields(resp any, fields []string, format TimeFormat) (any, error) {
+	if fields == nil {
+		return resp, nil
+	}
+
+	data, err := MarshalJSON(resp, format)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	switch value := generic.(type) {
+	case []any:
+		pruneRecords(value, fields)
+	case map[string]any:
+		hasRecords := false
+		for _, member := range value {
+			if records, ok := member.([]any); ok {
+				pruneRecords(records, fields)
+				hasRecords = true
+			}
+		}
+		if !hasRecords {
+			pruneRecord(value, fields)
+		}
+	}
+	return generic, nil
+}
+
+func pruneRecords(records []any, fields []string) {
+	for _, record := range records {
+		if object, ok := record.(map[string]any); ok {
+			pruneRecord(object, fields)
+		}
+	}
+}
+
+func pruneRecord(record map[string]any, fields []string) {
+	for key := range record {
+		if !slices.Contains(fields, key) {
+			delete(record, key)
+		}
+	}
+}
+
+// ComputeETag returns a strong ETag for a response body. The field selection
+// is part of the hash so that responses for different field sets never share
+// a cache entry, even if their bodies happen to match.
+func ComputeETag(body []byte, fields []string) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(strings.Join(fields, ",")))
+	hasher.Write([]byte{0})
+	hasher.Write(body)
+	return `"` + hex.EncodeToString(hasher.Sum(nil))[:32] + `"`
+}
+
+// ETagMatches reports whether an If-None-Match header value matches the
+// ETag, using the weak comparison RFC 9110 specifies for it.
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleFieldsAPIResponse works like HandleAPIResponse but honors the fields
+// query parameter, restricted to the allowed field names, and sets an ETag
+// header derived from the body and the selected fields. Requests whose
+// If-None-Match header matches the ETag get 304 Not Modified with no body.
+// Requests for fields outside the allowlist are rejected with 400 Bad
+// Request.
+func HandleFieldsAPIResponse(w http.ResponseWriter, r *http.Request, resp interface{}, allowed []string, err error, status int) {
+	if err != nil {
+		HandleAPIResponse(w, r, nil, err, status)
+		return
+	}
+	fields, err := ParseFields(r, allowed)
+	if err != nil {
+		HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+		return
+	}
+	format := RequestTimeFormat(r)
+	selected, err := selectFields(resp, fields, format)
+	if err != nil {
+		HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		return
+	}
+	body, err := MarshalJSON(selected, format)
+	if err != nil {
+		HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		return
+	}
+	etag := ComputeETag(body, fields)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", TimeFormatHeader)
+	if match := r.Header.Get("If-None-Match"); match != "" && ETagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,139 @@
+package httputils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+var testUsers = map[string]any{
+	"users": []testUser{
+		{ID: 1, Username: "admin", Email: "admin@example.com"},
+		{ID: 2, Username: "tom", Email: "tom@example.com"},
+	},
+}
+
+var testAllowedFields = []string{"id", "username", "email"}
+
+func serveFields(t *testing.T, target string, resp any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	HandleFieldsAPIResponse(rec, httptest.NewRequest(http.MethodGet, target, nil), resp, testAllowedFields, nil, http.StatusInternalServerError)
+	return rec
+}
+
+func TestParseFields(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/users?fields=username,id,,username", nil)
+	fields, err := ParseFields(r, testAllowedFields)
+	if err != nil {
+		t.Fatalf("ParseFields returned error: %v", err)
+	}
+	if len(fields) != 2 || fields[0] != "id" || fields[1] != "username" {
+		t.Errorf("Expected [id username], got %v", fields)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	fields, err = ParseFields(r, testAllowedFields)
+	if err != nil || fields != nil {
+		t.Errorf("Expected no selection, got %v (err %v)", fields, err)
+	}
+}
+
+func TestInvalidFieldsRejected(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/users?fields=id,password_hash,salt", nil)
+	_, err := ParseFields(r, testAllowedFields)
+	var fieldsErr *InvalidFieldsError
+	if !errors.As(err, &fieldsErr) {
+		t.Fatalf("Expected InvalidFieldsError, got %v", err)
+	}
+	if len(fieldsErr.Invalid) != 2 || fieldsErr.Invalid[0] != "password_hash" || fieldsErr.Invalid[1] != "salt" {
+		t.Errorf("Expected [password_hash salt] to be reported, got %v", fieldsErr.Invalid)
+	}
+
+	rec := serveFields(t, "/api/users?fields=id,password_hash", testUsers)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
+
+func TestSelectFieldsPrunesRecords(t *testing.T) {
+	rec := serveFields(t, "/api/users?fields=id,username", testUsers)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Users []map[string]any `json:"users"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Users) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(body.Users))
+	}
+	for _, user := range body.Users {
+		if _, ok := user["email"]; ok {
+			t.Errorf("Expected email to be pruned, got %v", user)
+		}
+		if _, ok := user["username"]; !ok {
+			t.Errorf("Expected username to be kept, got %v", user)
+		}
+	}
+
+	// A single record is pruned directly
+	rec = serveFields(t, "/api/user?fields=username", testUser{ID: 1, Username: "admin", Email: "a@b"})
+	if rec.Body.String() != `{"username":"admin"}` {
+		t.Errorf("Expected single record to be pruned, got %s", rec.Body.String())
+	}
+}
+
+func TestETagIncludesFieldSet(t *testing.T) {
+	full := serveFields(t, "/api/users", testUsers)
+	narrow := serveFields(t, "/api/users?fields=id,username", testUsers)
+	reordered := serveFields(t, "/api/users?fields=username,id", testUsers)
+
+	if full.Header().Get("ETag") == "" {
+		t.Fatal("Expected ETag header to be set")
+	}
+	if full.Header().Get("ETag") == narrow.Header().Get("ETag") {
+		t.Error("Expected different field sets to produce different ETags")
+	}
+	if narrow.Header().Get("ETag") != reordered.Header().Get("ETag") {
+		t.Error("Expected field order not to affect the ETag")
+	}
+
+	// Identical bodies for different field sets must still differ
+	if ComputeETag([]byte("{}"), []string{"id"}) == ComputeETag([]byte("{}"), []string{"username"}) {
+		t.Error("Expected ETag to incorporate the field set")
+	}
+}
+
+func TestIfNoneMatchReturnsNotModified(t *testing.T) {
+	etag := serveFields(t, "/api/users", testUsers).Header().Get("ETag")
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		r.Header.Set("If-None-Match", header)
+		HandleFieldsAPIResponse(rec, r, testUsers, testAllowedFields, nil, http.StatusInternalServerError)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: expected an empty 304 with the ETag, got %d %q", header, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/users?fields=id", nil)
+	r.Header.Set("If-None-Match", etag)
+	HandleFieldsAPIResponse(rec, r, testUsers, testAllowedFields, nil, http.StatusInternalServerError)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected a different field set to be sent in full, got %d", rec.Code)
+	}
+}
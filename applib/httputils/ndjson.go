@@ -0,0 +1,79 @@
+package httputils
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// NDJSONContentType is the media type of newline-delimited JSON streams,
+// which hold one JSON record per line.
+const NDJSONContentType = "application/x-ndjson"
+
+// WantsNDJSON reports whether the request's Accept header asks for a
+// newline-delimited JSON stream.
+func WantsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == NDJSONContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleNDJSONResponse streams records as newline-delimited JSON instead of
+// building the whole response in memory. stream calls emit with each record
+// in order. The fields query parameter is honored like in
+// HandleFieldsAPIResponse, restricted to the allowed field names.
+//
+// If stream fails before emitting a record, the error is reported with
+// status. Once records have been sent the status can no longer change, so the
+// response is aborted instead, and clients see a truncated stream rather than
+// a complete one.
+func HandleNDJSONResponse(w http.ResponseWriter, r *http.Request, allowed []string, stream func(emit func(record any) error) error, status int) {
+	fields, err := ParseFields(r, allowed)
+	if err != nil {
+		HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	started := false
+	format := RequestTimeFormat(r)
+	emit := func(record any) error {
+		selected, err := selectFields(record, fields, format)
+		if err != nil {
+			return err
+		}
+		line, err := MarshalJSON(selected, format)
+		if err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", NDJSONContentType)
+			started = true
+		}
+		_, err = w.Write(append(line, '\n'))
+		return err
+	}
+
+	err = stream(emit)
+	if err != nil && !started {
+		HandleAPIResponse(w, r, nil, err, status)
+		return
+	}
+	if err != nil {
+		fmt.Printf("%s - %s %s ERROR: %v\n",
+			r.RemoteAddr,
+			r.Method,
+			r.URL.Path,
+			err,
+		)
+		panic(http.ErrAbortHandler)
+	}
+	if !started {
+		w.Header().Set("Content-Type", NDJSONContentType)
+		w.WriteHeader(http.StatusOK)
+	}
+}
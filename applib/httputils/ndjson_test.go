@@ -0,0 +1,87 @@
+package httputils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func streamTestUsers(emit func(any) error) error {
+	for _, user := range testUsers["users"].([]testUser) {
+		if err := emit(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWantsNDJSON(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                     false,
+		"application/json":     false,
+		"application/x-ndjson": true,
+		"application/json, application/x-ndjson; q=0.9": true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		r.Header.Set("Accept", accept)
+		if got := WantsNDJSON(r); got != want {
+			t.Errorf("WantsNDJSON(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestHandleNDJSONResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/users?fields=username", nil)
+	HandleNDJSONResponse(rec, r, testAllowedFields, streamTestUsers, http.StatusInternalServerError)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != NDJSONContentType {
+		t.Fatalf("Expected an NDJSON response, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	want := "{\"username\":\"admin\"}\n{\"username\":\"tom\"}\n"
+	if rec.Body.String() != want {
+		t.Errorf("Expected %q, got %q", want, rec.Body.String())
+	}
+}
+
+func TestHandleNDJSONResponseEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	HandleNDJSONResponse(rec, r, testAllowedFields, func(emit func(any) error) error { return nil }, http.StatusInternalServerError)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != NDJSONContentType {
+		t.Errorf("Expected an empty NDJSON response, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleNDJSONResponseErrors(t *testing.T) {
+	// Invalid fields are rejected before streaming
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/users?fields=salt", nil)
+	HandleNDJSONResponse(rec, r, testAllowedFields, streamTestUsers, http.StatusInternalServerError)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid fields, got %d", rec.Code)
+	}
+
+	// Errors before the first record keep their status
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	HandleNDJSONResponse(rec, r, testAllowedFields, func(emit func(any) error) error {
+		return errors.New("database is locked")
+	}, http.StatusServiceUnavailable)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", rec.Code)
+	}
+
+	// Errors mid-stream abort the response
+	defer func() {
+		if value := recover(); value != http.ErrAbortHandler {
+			t.Errorf("Expected the response to be aborted, got %v", value)
+		}
+	}()
+	rec = httptest.NewRecorder()
+	HandleNDJSONResponse(rec, r, testAllowedFields, func(emit func(any) error) error {
+		emit(testUser{ID: 1})
+		return errors.New("database is locked")
+	}, http.StatusInternalServerError)
+}
@@ -0,0 +1,74 @@
+package httputils
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// ResponseRecorder passes a response through to the wrapped writer while
+// keeping its status and the first bytes of its body, for middleware that
+// needs to know how a handler answered.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	maxBody   int
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+// NewResponseRecorder wraps w, keeping up to maxBody bytes of the body. With
+// a maxBody of zero only the status is kept.
+func NewResponseRecorder(w http.ResponseWriter, maxBody int) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, maxBody: maxBody}
+}
+
+// Status returns the status sent to the client, or zero if nothing has been
+// written yet.
+func (r *ResponseRecorder) Status() int {
+	return r.status
+}
+
+// Body returns the start of the body written so far, see Truncated.
+func (r *ResponseRecorder) Body() []byte {
+	return r.body.Bytes()
+}
+
+// Truncated reports whether the body was longer than the recorder keeps.
+func (r *ResponseRecorder) Truncated() bool {
+	return r.truncated
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if keep := r.maxBody - r.body.Len(); len(b) > keep {
+		r.truncated = true
+		r.body.Write(b[:keep])
+	} else {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered response, with a 200 status if none was set.
+func (r *ResponseRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,36 @@
+package httputils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := NewResponseRecorder(w, 8)
+	if recorder.Status() != 0 {
+		t.Errorf("Expected no status before anything is written, got %d", recorder.Status())
+	}
+
+	recorder.WriteHeader(http.StatusNotFound)
+	recorder.WriteHeader(http.StatusOK)
+	recorder.Write([]byte("not "))
+	recorder.Write([]byte("found"))
+	if recorder.Status() != http.StatusNotFound {
+		t.Errorf("Expected the first status to be kept, got %d", recorder.Status())
+	}
+	if string(recorder.Body()) != "not foun" || !recorder.Truncated() {
+		t.Errorf("Expected the body to be truncated to 8 bytes, got %q (truncated %v)", recorder.Body(), recorder.Truncated())
+	}
+	if w.Body.String() != "not found" {
+		t.Errorf("Expected the whole body to be passed through, got %q", w.Body.String())
+	}
+
+	// Writing without a status sends 200
+	recorder = NewResponseRecorder(httptest.NewRecorder(), 0)
+	recorder.Write([]byte("ok"))
+	if recorder.Status() != http.StatusOK || len(recorder.Body()) != 0 {
+		t.Errorf("Expected status 200 and no kept body, got %d and %q", recorder.Status(), recorder.Body())
+	}
+}
@@ -1,11 +1,15 @@
 package httputils
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 )
 
+// HandleAPIResponse writes resp as JSON following the encoding policy, with
+// times in the format the request asks for, or reports err with status.
+// A *ValidationError is reported as a JSON ErrorResponse listing the invalid
+// fields, other errors as plain text.
 func HandleAPIResponse(w http.ResponseWriter, r *http.Request, resp interface{}, err error, status int) {
 	if err != nil {
 		fmt.Printf("%s - %s %s ERROR: %v\n",
@@ -14,10 +18,15 @@ func HandleAPIResponse(w http.ResponseWriter, r *http.Request, resp interface{},
 			r.URL.Path,
 			err,
 		)
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			writeValidationError(w, validationErr, status)
+			return
+		}
 		http.Error(w, err.Error(), status)
 		return
 	}
-	json, err := json.Marshal(resp)
+	body, err := MarshalJSON(resp, RequestTimeFormat(r))
 	if err != nil {
 		fmt.Printf("%s - %s %s ERROR: %v\n",
 			r.RemoteAddr,
@@ -29,5 +38,5 @@ func HandleAPIResponse(w http.ResponseWriter, r *http.Request, resp interface{},
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(json)
+	w.Write(body)
 }
@@ -0,0 +1,21 @@
+package httputils
+
+import "net/http"
+
+// TraceIDHeader carries the ID the proxy gives each request, which ties its
+// access record to the log lines and crash reports of the application that
+// served it. The proxy sets it on every request it forwards to an
+// application.
+const TraceIDHeader = "X-Trace-ID"
+
+// TraceIDLogKey is the attribute applications add to the log lines they
+// write while serving a request, naming its trace ID. The hub finds a
+// request's log lines by it.
+const TraceIDLogKey = "trace_id"
+
+// RequestTraceID returns the trace ID carried by the request's trace ID
+// header, if it is present.
+func RequestTraceID(r *http.Request) (string, bool) {
+	traceID := r.Header.Get(TraceIDHeader)
+	return traceID, traceID != ""
+}
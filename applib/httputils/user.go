@@ -0,0 +1,30 @@
+package httputils
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// UserIDHeader carries the ID of the user whose access token authorized the
+// request. The proxy removes any client-supplied value and sets it on every
+// request it forwards to an application on behalf of a logged-in user.
+const UserIDHeader = "X-Yesterday-User-Id"
+
+// SetUserID sets the user ID header on an outgoing request.
+func SetUserID(header http.Header, userID int) {
+	header.Set(UserIDHeader, strconv.Itoa(userID))
+}
+
+// RequestUserID returns the user ID carried by the request's user ID header,
+// if it is present and valid.
+func RequestUserID(r *http.Request) (int, bool) {
+	value := r.Header.Get(UserIDHeader)
+	if value == "" {
+		return 0, false
+	}
+	userID, err := strconv.Atoi(value)
+	if err != nil || userID <= 0 {
+		return 0, false
+	}
+	return userID, true
+}
@@ -2,15 +2,28 @@ package applib
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 
 	"github.com/tomyedwab/yesterday/applib/database"
 )
 
 func Init() (*Application, error) {
+	if err := setupLogging(os.Getenv("LOG_LEVEL")); err != nil {
+		return nil, fmt.Errorf("Invalid LOG_LEVEL: %v", err)
+	}
 	db, err := database.Connect("sqlite3", "/db/app.sqlite")
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to database: %v", err)
 	}
+	db.SetInstanceID(os.Getenv("INSTANCE_ID"))
+	if value := os.Getenv("EVENT_DEAD_LETTER_AFTER"); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 0 {
+			return nil, fmt.Errorf("Invalid EVENT_DEAD_LETTER_AFTER %q: must be a non-negative integer", value)
+		}
+		db.SetDeadLetterAfter(attempts)
+	}
 
 	return NewApplication(db), nil
 }
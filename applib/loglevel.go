@@ -0,0 +1,238 @@
+package applib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// LogLevelPath is the internal endpoint reporting (GET) and changing (PUT)
+// the application's log levels while it runs. The hub proxies it as
+// /apps/{instanceID}/loglevel.
+const LogLevelPath = "/internal/loglevel"
+
+// ModuleKey is the attribute naming the module a logger belongs to, see
+// Logger.
+const ModuleKey = "module"
+
+// LogLevels is the minimum level logged by each module. Modules are
+// dot-separated names; a module without its own level uses that of the
+// closest enclosing module, so "database.events" falls back to "database"
+// and then to Default.
+type LogLevels struct {
+	Default slog.Level
+	Modules map[string]slog.Level
+}
+
+// ParseLogLevels parses a comma-separated log level spec: a default level
+// and module=level overrides in any order, e.g. "info,database=debug,http=warn".
+// Levels are debug, info, warn and error. The default is info if the spec
+// does not set one.
+func ParseLogLevels(spec string) (LogLevels, error) {
+	levels := LogLevels{Default: slog.LevelInfo, Modules: make(map[string]slog.Level)}
+	seenDefault := false
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		module, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			if seenDefault {
+				return LogLevels{}, fmt.Errorf("more than one default level in %q", spec)
+			}
+			level, err := parseLevel(entry)
+			if err != nil {
+				return LogLevels{}, err
+			}
+			levels.Default = level
+			seenDefault = true
+			continue
+		}
+		module = strings.ToLower(strings.TrimSpace(module))
+		if module == "" || strings.HasPrefix(module, ".") || strings.HasSuffix(module, ".") || strings.Contains(module, "..") {
+			return LogLevels{}, fmt.Errorf("invalid module name in %q", entry)
+		}
+		if _, ok := levels.Modules[module]; ok {
+			return LogLevels{}, fmt.Errorf("more than one level for module %s", module)
+		}
+		level, err := parseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return LogLevels{}, err
+		}
+		levels.Modules[module] = level
+	}
+	return levels, nil
+}
+
+func parseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q: expected debug, info, warn or error", name)
+}
+
+// Level returns the minimum level logged by module.
+func (l LogLevels) Level(module string) slog.Level {
+	for module != "" {
+		if level, ok := l.Modules[module]; ok {
+			return level
+		}
+		i := strings.LastIndex(module, ".")
+		if i < 0 {
+			break
+		}
+		module = module[:i]
+	}
+	return l.Default
+}
+
+// String formats the levels as a spec ParseLogLevels accepts, with the
+// modules sorted.
+func (l LogLevels) String() string {
+	parts := []string{strings.ToLower(l.Default.String())}
+	modules := make([]string, 0, len(l.Modules))
+	for module := range l.Modules {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		parts = append(parts, module+"="+strings.ToLower(l.Modules[module].String()))
+	}
+	return strings.Join(parts, ",")
+}
+
+var (
+	// logLevels holds the current levels; nil means the defaults.
+	logLevels          atomic.Pointer[LogLevels]
+	installHandlerOnce sync.Once
+)
+
+// GetLogLevels returns the application's current log levels.
+func GetLogLevels() LogLevels {
+	if levels := logLevels.Load(); levels != nil {
+		return *levels
+	}
+	return LogLevels{Default: slog.LevelInfo}
+}
+
+// SetLogLevels changes the application's log levels. It applies to every
+// logger, including ones created before the change.
+func SetLogLevels(levels LogLevels) {
+	logLevels.Store(&levels)
+}
+
+// Logger returns a logger for the named module, whose records are filtered
+// by the module's level. Create it when logging rather than at package
+// initialization, so it uses the handler installed by Init.
+func Logger(module string) *slog.Logger {
+	return slog.Default().With(ModuleKey, module)
+}
+
+// levelHandler drops records below the current level of the module its
+// logger belongs to, then passes them on to next.
+type levelHandler struct {
+	next   slog.Handler
+	module string
+}
+
+// newLevelHandler returns a handler writing records enabled by the current
+// log levels to out in slog's text format, which the hub reads each line's
+// level and trace ID from.
+func newLevelHandler(out io.Writer) *levelHandler {
+	// Filtering is done here, so the text handler accepts every level
+	return &levelHandler{next: slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.Level(math.MinInt)})}
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= GetLogLevels().Level(h.module)
+}
+
+// Handle tags records logged while serving a request with its trace ID.
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	if traceID, ok := TraceID(ctx); ok {
+		record = record.Clone()
+		record.AddAttrs(slog.String(httputils.TraceIDLogKey, traceID))
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	module := h.module
+	for _, attr := range attrs {
+		if attr.Key == ModuleKey {
+			module = strings.ToLower(attr.Value.String())
+		}
+	}
+	return &levelHandler{next: h.next.WithAttrs(attrs), module: module}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), module: h.module}
+}
+
+// setupLogging sets the initial log levels from spec and installs the
+// leveled handler as the default logger. Output of the standard log package
+// is logged at info level without a module.
+func setupLogging(spec string) error {
+	levels, err := ParseLogLevels(spec)
+	if err != nil {
+		return err
+	}
+	SetLogLevels(levels)
+	installHandlerOnce.Do(func() {
+		// Write through the breadcrumb tee, which the default logger
+		// replaces as the log package's output
+		captureLogBreadcrumbs()
+		slog.SetDefault(slog.New(newLevelHandler(log.Writer())))
+	})
+	return nil
+}
+
+// serveLogLevel serves LogLevelPath to internal requests.
+func serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !httputils.IsInternalRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var request types.LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid log level request: %v", err), http.StatusBadRequest)
+			return
+		}
+		levels, err := ParseLogLevels(request.Level)
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+			return
+		}
+		SetLogLevels(levels)
+		log.Printf("Log level set to %s", levels)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, types.LogLevel{Level: GetLogLevels().String()}, nil, http.StatusOK)
+}
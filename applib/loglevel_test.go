@@ -0,0 +1,133 @@
+package applib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := ParseLogLevels(" database=debug, WARN ,http=error,database.events=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels.Default != slog.LevelWarn {
+		t.Errorf("Expected the default level warn, got %s", levels.Default)
+	}
+	for module, want := range map[string]slog.Level{
+		"":                      slog.LevelWarn,
+		"database":              slog.LevelDebug,
+		"database.schema":       slog.LevelDebug,
+		"database.events":       slog.LevelInfo,
+		"database.events.retry": slog.LevelInfo,
+		"databases":             slog.LevelWarn,
+		"http":                  slog.LevelError,
+		"flags":                 slog.LevelWarn,
+	} {
+		if got := levels.Level(module); got != want {
+			t.Errorf("Expected %s for module %q, got %s", want, module, got)
+		}
+	}
+	if spec := levels.String(); spec != "warn,database=debug,database.events=info,http=error" {
+		t.Errorf("Unexpected spec %q", spec)
+	}
+
+	if levels, err := ParseLogLevels(""); err != nil || levels.Default != slog.LevelInfo || len(levels.Modules) != 0 {
+		t.Errorf("Expected an empty spec to mean info, got %+v: %v", levels, err)
+	}
+	if levels, err := ParseLogLevels("http=debug"); err != nil || levels.Default != slog.LevelInfo || levels.Level("http") != slog.LevelDebug {
+		t.Errorf("Expected the default to stay info, got %+v: %v", levels, err)
+	}
+
+	for _, spec := range []string{"verbose", "info,debug", "http=loud", "=debug", "http=", ".http=info", "http..x=info", "http=info,HTTP=debug"} {
+		if _, err := ParseLogLevels(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func setLogLevelRequest(t *testing.T, method, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, LogLevelPath, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-secret")
+	recorder := httptest.NewRecorder()
+	serveLogLevel(recorder, req)
+	return recorder
+}
+
+func TestLogLevelChangesLive(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	original := GetLogLevels()
+	defer SetLogLevels(original)
+	levels, _ := ParseLogLevels("info")
+	SetLogLevels(levels)
+
+	var output bytes.Buffer
+	root := slog.New(newLevelHandler(&output))
+	database := root.With(ModuleKey, "database")
+	events := database.With(ModuleKey, "database.events")
+	logAll := func() {
+		root.Debug("root debug")
+		database.Debug("database debug")
+		events.Debug("events debug")
+		database.Info("database info")
+	}
+
+	logAll()
+	if got := output.String(); strings.Contains(got, "debug") || !strings.Contains(got, `level=INFO msg="database info" module=database`) {
+		t.Errorf("Expected only info records, got:\n%s", got)
+	}
+
+	recorder := setLogLevelRequest(t, http.MethodPut, `{"level":"info,database=debug"}`)
+	var response types.LogLevel
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil || recorder.Code != http.StatusOK || response.Level != "info,database=debug" {
+		t.Fatalf("Expected the level to be changed, got %d %+v: %v", recorder.Code, response, err)
+	}
+
+	// Loggers created before the change follow it, including those of
+	// submodules
+	output.Reset()
+	logAll()
+	got := output.String()
+	if strings.Contains(got, "root debug") || !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, "database debug") || !strings.Contains(got, "events debug") {
+		t.Errorf("Expected debug records for the database module only, got:\n%s", got)
+	}
+	if !root.Enabled(context.Background(), slog.LevelInfo) || root.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("Expected the default level to stay info")
+	}
+
+	recorder = setLogLevelRequest(t, http.MethodGet, "")
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil || response.Level != "info,database=debug" {
+		t.Errorf("Expected the current level, got %+v: %v", response, err)
+	}
+}
+
+func TestLogLevelRequestErrors(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	original := GetLogLevels()
+	defer SetLogLevels(original)
+
+	if recorder := setLogLevelRequest(t, http.MethodPut, `{"level":"loud"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid spec to be rejected, got %d", recorder.Code)
+	}
+	if GetLogLevels().String() != original.String() {
+		t.Errorf("Expected a rejected spec not to change the level, got %s", GetLogLevels())
+	}
+	if recorder := setLogLevelRequest(t, http.MethodPost, `{}`); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be rejected, got %d", recorder.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, LogLevelPath, nil)
+	recorder := httptest.NewRecorder()
+	serveLogLevel(recorder, req)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected requests without the internal secret to be refused, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,140 @@
+// Package metrics exports Prometheus metrics from an applib application. It
+// is a separate package so that applications that don't enable metrics don't
+// link the Prometheus client.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tomyedwab/yesterday/applib"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+// Path is the route on which Prometheus metrics are exposed once Enable has
+// been called.
+const Path = "/metrics"
+
+// enabled is set by the first call to Enable.
+var enabled atomic.Bool
+
+type config struct {
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+}
+
+type Option func(*config)
+
+// WithRegistry records metrics into the given registry instead of a fresh
+// one created by Enable. Useful in tests to inspect the recorded values.
+func WithRegistry(registry *prometheus.Registry) Option {
+	return func(c *config) {
+		c.registerer = registry
+		c.gatherer = registry
+	}
+}
+// Enable instruments every route served by the application with request
+// counters and latency histograms labeled by route pattern and status code,
+// and exposes them in the Prometheus text format at Path. Metrics are off
+// unless this is called before Serve. Path is registered on the default serve
+// mux, so metrics can only be enabled once per process; later calls return
+// an error.
+func Enable(app *applib.Application, opts ...Option) error {
+	if !enabled.CompareAndSwap(false, true) {
+		return errors.New("metrics are already enabled")
+	}
+	registry := prometheus.NewRegistry()
+	config := &config{registerer: registry, gatherer: registry}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests handled, by route and status code.",
+	}, []string{"route", "status"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds, by route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "status"})
+	config.registerer.MustRegister(requests, duration)
+	if db := app.GetDatabase(); db != nil {
+		config.registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "event_errors",
+			Help: "Number of events that failed to apply and have not succeeded since, by event and handler.",
+		}, func() float64 {
+			return float64(db.EventErrorCount())
+		}))
+		config.registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dead_letter_events",
+			Help: "Number of events that were dead-lettered after failing to apply and have not been redriven.",
+		}, func() float64 {
+			count, err := db.DeadLetterCount()
+			if err != nil {
+				return 0
+			}
+			return float64(count)
+		}))
+		config.registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "event_latest_id",
+			Help: "Highest event ID the application has been sent.",
+		}, func() float64 {
+			return float64(db.LatestEventID())
+		}))
+		config.registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "event_current_id",
+			Help: "ID of the last event applied to the database.",
+		}, func() float64 {
+			return float64(db.CurrentEventID())
+		}))
+		config.registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "event_backlog",
+			Help: "Number of events sent to the application but not yet applied. A growing backlog indicates a stuck or slow handler.",
+		}, func() float64 {
+			return float64(db.EventBacklog())
+		}))
+		handlerDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "event_handler_duration_seconds",
+			Help:    "Event handler latency in seconds, by event type and handler.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"event_type", "handler"})
+		config.registerer.MustRegister(handlerDuration)
+		db.SetHandlerObserver(func(eventType, handler string, duration time.Duration) {
+			handlerDuration.WithLabelValues(eventType, handler).Observe(duration.Seconds())
+		})
+	}
+
+	http.Handle(Path, promhttp.HandlerFor(config.gatherer, promhttp.HandlerOpts{}))
+
+	app.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Label by the registered pattern rather than the raw path so that
+			// path parameters don't explode the label cardinality.
+			_, route := http.DefaultServeMux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			if route == Path {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := httputils.NewResponseRecorder(w, 0)
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+			status := http.StatusOK
+			if recorder.Status() != 0 {
+				status = recorder.Status()
+			}
+			requests.WithLabelValues(route, strconv.Itoa(status)).Inc()
+			duration.WithLabelValues(route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+		})
+	})
+	return nil
+}
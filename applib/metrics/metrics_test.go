@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tomyedwab/yesterday/applib"
+)
+
+func TestEnable(t *testing.T) {
+	http.HandleFunc("/api/metrics-test", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	registry := prometheus.NewRegistry()
+	app := applib.NewApplication(nil)
+	if err := Enable(app, WithRegistry(registry)); err != nil {
+		t.Fatal(err)
+	}
+	handler := app.Handler()
+
+	for _, target := range []string{"/api/metrics-test", "/api/metrics-test", "/api/metrics-test?fail=1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	expected := `
+# HELP http_requests_total Total number of HTTP requests handled, by route and status code.
+# TYPE http_requests_total counter
+http_requests_total{route="/api/metrics-test",status="200"} 2
+http_requests_total{route="/api/metrics-test",status="500"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_requests_total"); err != nil {
+		t.Fatal(err)
+	}
+	if count := testutil.CollectAndCount(registry, "http_request_duration_seconds"); count != 2 {
+		t.Errorf("Expected 2 latency series, got %d", count)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from %s, got %d", Path, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `http_requests_total{route="/api/metrics-test",status="500"} 1`) {
+		t.Errorf("Expected metrics output to contain request counter, got:\n%s", rec.Body.String())
+	}
+
+	// The metrics route can only be registered once
+	if err := Enable(applib.NewApplication(nil)); err == nil {
+		t.Error("Expected enabling metrics twice to fail")
+	}
+}
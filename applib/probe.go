@@ -0,0 +1,148 @@
+package applib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// ProbesPath is the internal endpoint running the application's probes:
+// GET ProbesPath + name runs one and reports its result. The hub proxies it
+// as /apps/{instanceID}/probe/{name} for external monitors.
+const ProbesPath = "/internal/probes/"
+
+// DefaultProbeTimeout bounds probes registered without ProbeTimeout.
+const DefaultProbeTimeout = 5 * time.Second
+
+// Probe checks something the application depends on, e.g. that its
+// database answers, returning an error if it does not. detail, if not nil,
+// is reported with the result as JSON, healthy or not.
+type Probe func(ctx context.Context) (detail any, err error)
+
+type probeConfig struct {
+	timeout  time.Duration
+	critical bool
+}
+
+// ProbeOption configures a probe registered with AddProbe.
+type ProbeOption func(*probeConfig)
+
+// ProbeTimeout sets how long the probe may run before it is reported
+// unhealthy, instead of DefaultProbeTimeout.
+func ProbeTimeout(timeout time.Duration) ProbeOption {
+	return func(c *probeConfig) {
+		c.timeout = timeout
+	}
+}
+
+// ProbeCritical marks the probe critical: the hub marks the instance
+// degraded while the probe keeps failing.
+func ProbeCritical() ProbeOption {
+	return func(c *probeConfig) {
+		c.critical = true
+	}
+}
+
+type namedProbe struct {
+	probe  Probe
+	config probeConfig
+}
+
+// probes holds the probes registered with AddProbe and serves ProbesPath.
+type probes struct {
+	mu     sync.RWMutex
+	probes map[string]namedProbe
+}
+
+func newProbes() *probes {
+	return &probes{probes: make(map[string]namedProbe)}
+}
+
+// AddProbe registers a named probe that the hub runs on behalf of external
+// monitors. Unlike self-tests, which run once at startup, probes run each
+// time they are requested, though the hub caches results briefly. Names
+// must be a single path segment; registering a name again replaces the
+// probe.
+func (app *Application) AddProbe(name string, probe Probe, opts ...ProbeOption) {
+	if name == "" || strings.Contains(name, "/") {
+		panic(fmt.Sprintf("applib: invalid probe name %q", name))
+	}
+	config := probeConfig{timeout: DefaultProbeTimeout}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	app.probes.mu.Lock()
+	defer app.probes.mu.Unlock()
+	app.probes.probes[name] = namedProbe{probe: probe, config: config}
+}
+
+func (p *probes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !httputils.IsInternalRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, ProbesPath)
+	p.mu.RLock()
+	probe, ok := p.probes[name]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("No probe named %q", name), http.StatusNotFound)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, runProbe(r.Context(), name, probe), nil, http.StatusOK)
+}
+
+// runProbe runs a probe with its timeout, turning a panic into a failure. A
+// probe that ignores its context is abandoned once the timeout passes.
+func runProbe(ctx context.Context, name string, probe namedProbe) types.ProbeResult {
+	result := types.ProbeResult{Name: name, Critical: probe.config.critical, CheckedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(ctx, probe.config.timeout)
+	defer cancel()
+
+	type outcome struct {
+		detail any
+		err    error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		detail, err := probe.probe(ctx)
+		done <- outcome{detail: detail, err: err}
+	}()
+
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = fmt.Errorf("timed out after %v", probe.config.timeout)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.Healthy = out.err == nil
+	if out.err != nil {
+		result.Error = out.err.Error()
+	}
+	if out.detail != nil {
+		detail, err := json.Marshal(out.detail)
+		if err != nil {
+			detail, _ = json.Marshal(fmt.Sprintf("unencodable detail: %v", err))
+		}
+		result.Detail = detail
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package applib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func runTestProbe(t *testing.T, app *Application, name string) (int, types.ProbeResult) {
+	req := httptest.NewRequest(http.MethodGet, ProbesPath+name, nil)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	rec := httptest.NewRecorder()
+	app.probes.ServeHTTP(rec, req)
+	var result types.ProbeResult
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+	}
+	return rec.Code, result
+}
+
+func TestProbes(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	app := &Application{probes: newProbes()}
+	app.AddProbe("db", func(ctx context.Context) (any, error) {
+		return map[string]int{"connections": 3}, nil
+	}, ProbeCritical())
+	app.AddProbe("cache", func(ctx context.Context) (any, error) {
+		return nil, errors.New("cache unreachable")
+	})
+	app.AddProbe("slow", func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, ProbeTimeout(20*time.Millisecond))
+	app.AddProbe("stuck", func(ctx context.Context) (any, error) {
+		select {}
+	}, ProbeTimeout(20*time.Millisecond))
+	app.AddProbe("panics", func(ctx context.Context) (any, error) { panic("boom") })
+
+	status, result := runTestProbe(t, app, "db")
+	if status != http.StatusOK || !result.Healthy || !result.Critical || string(result.Detail) != `{"connections":3}` || result.CheckedAt.IsZero() {
+		t.Errorf("Expected a healthy critical result with detail, got %d %+v", status, result)
+	}
+	if _, result := runTestProbe(t, app, "cache"); result.Healthy || result.Critical || result.Error != "cache unreachable" {
+		t.Errorf("Expected an unhealthy result, got %+v", result)
+	}
+	for _, name := range []string{"slow", "stuck"} {
+		start := time.Now()
+		_, result := runTestProbe(t, app, name)
+		if result.Healthy || result.Error != "timed out after 20ms" || time.Since(start) > time.Second {
+			t.Errorf("%s: expected a timeout, got %+v", name, result)
+		}
+	}
+	if _, result := runTestProbe(t, app, "panics"); result.Healthy || result.Error != "panic: boom" {
+		t.Errorf("Expected a panic to be reported, got %+v", result)
+	}
+	if status, _ := runTestProbe(t, app, "missing"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown probe, got %d", status)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, ProbesPath+"db", nil)
+	rec := httptest.NewRecorder()
+	app.probes.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected requests without the internal secret to be refused, got %d", rec.Code)
+	}
+}
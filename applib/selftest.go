@@ -0,0 +1,156 @@
+package applib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// SelfTestPath is the internal endpoint reporting the results of the
+// application's self-tests. The hub polls it before marking an instance whose
+// manifest sets runSelfTest as running.
+const SelfTestPath = "/internal/selftest"
+
+// selfTestTimeout bounds each self-test.
+const selfTestTimeout = 30 * time.Second
+
+// SelfTest checks that the application is able to serve requests, returning
+// an error describing the problem if it is not.
+type SelfTest func(ctx context.Context) error
+
+type namedSelfTest struct {
+	name string
+	test SelfTest
+}
+
+// selfTests runs the registered self-tests and serves their report.
+type selfTests struct {
+	tests []namedSelfTest
+
+	mu     sync.Mutex
+	report types.SelfTestReport
+}
+
+func newSelfTests() *selfTests {
+	return &selfTests{report: types.SelfTestReport{Status: types.SelfTestRunning, Tests: []types.SelfTestResult{}}}
+}
+
+// AddSelfTest registers a check that runs once when the application starts
+// serving, after the database has been initialized. Instances of packages
+// whose manifest sets runSelfTest are only marked running once every check
+// has passed; otherwise they are marked failed with the names of the failing
+// checks. Each check is given 30 seconds. Call it before Serve.
+func (app *Application) AddSelfTest(name string, test SelfTest) {
+	app.selfTests.tests = append(app.selfTests.tests, namedSelfTest{name: name, test: test})
+}
+
+// run runs every self-test in turn, recording the results as they finish.
+func (s *selfTests) run() {
+	failed := false
+	for _, test := range s.tests {
+		start := time.Now()
+		err := runSelfTest(test.test)
+		result := types.SelfTestResult{Name: test.name, Passed: err == nil, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			failed = true
+			result.Error = err.Error()
+			log.Printf("Self-test %s failed: %v", test.name, err)
+		}
+		s.mu.Lock()
+		s.report.Tests = append(s.report.Tests, result)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if failed {
+		s.report.Status = types.SelfTestFailed
+	} else {
+		s.report.Status = types.SelfTestPassed
+	}
+	log.Printf("Self-tests %s", s.report.Status)
+}
+
+// runSelfTest runs a single test with a timeout, turning a panic into a
+// failure.
+func runSelfTest(test SelfTest) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return test(ctx)
+}
+
+func (s *selfTests) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !httputils.IsInternalRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	report := types.SelfTestReport{Status: s.report.Status, Tests: append([]types.SelfTestResult{}, s.report.Tests...)}
+	s.mu.Unlock()
+	httputils.HandleAPIResponse(w, r, report, nil, http.StatusOK)
+}
+
+// SchemaSelfTest checks that the database schema is at the version the
+// binary expects, see database.SetSchemaVersion.
+func SchemaSelfTest(db *database.Database) SelfTest {
+	return func(ctx context.Context) error {
+		stored, err := db.StoredSchemaVersion()
+		if err != nil {
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
+		if stored != db.SchemaVersion() {
+			return fmt.Errorf("database schema is at version %d, expected %d", stored, db.SchemaVersion())
+		}
+		return nil
+	}
+}
+
+// ConfigSelfTest checks that the environment variables described by the
+// struct tags of cfg, a pointer to a configuration struct as passed to
+// LoadConfig, are set and valid. cfg itself is not modified.
+func ConfigSelfTest(cfg any) SelfTest {
+	return func(ctx context.Context) error {
+		value := reflect.ValueOf(cfg)
+		if value.Kind() != reflect.Pointer {
+			return fmt.Errorf("ConfigSelfTest requires a pointer to a struct, got %T", cfg)
+		}
+		return LoadConfig(reflect.New(value.Elem().Type()).Interface())
+	}
+}
+
+// ReachableSelfTest checks that a GET request to url is answered without a
+// server error, for services the application depends on.
+func ReachableSelfTest(url string) SelfTest {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s is unreachable: %w", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s answered %s", url, resp.Status)
+		}
+		return nil
+	}
+}
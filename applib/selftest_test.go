@@ -0,0 +1,99 @@
+package applib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func getSelfTestReport(t *testing.T, tests *selfTests) types.SelfTestReport {
+	req := httptest.NewRequest(http.MethodGet, SelfTestPath, nil)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	rec := httptest.NewRecorder()
+	tests.ServeHTTP(rec, req)
+	var report types.SelfTestReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report %d: %v", rec.Code, err)
+	}
+	return report
+}
+
+func TestSelfTests(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	app := &Application{selfTests: newSelfTests()}
+	app.AddSelfTest("ok", func(ctx context.Context) error { return nil })
+	app.AddSelfTest("broken", func(ctx context.Context) error { return errors.New("no database") })
+	app.AddSelfTest("panics", func(ctx context.Context) error { panic("boom") })
+
+	if report := getSelfTestReport(t, app.selfTests); report.Status != types.SelfTestRunning {
+		t.Errorf("Expected the tests to be reported running before they finish, got %+v", report)
+	}
+	app.selfTests.run()
+
+	report := getSelfTestReport(t, app.selfTests)
+	if report.Status != types.SelfTestFailed || len(report.Tests) != 3 {
+		t.Fatalf("Expected a failed report of 3 tests, got %+v", report)
+	}
+	failed := report.FailedTests()
+	if len(failed) != 2 || failed[0] != "broken" || failed[1] != "panics" {
+		t.Errorf("Unexpected failed tests %v", failed)
+	}
+	if report.Tests[1].Error != "no database" || report.Tests[2].Error != "panic: boom" {
+		t.Errorf("Unexpected errors %+v", report.Tests)
+	}
+}
+
+func TestSelfTestsPassWithoutTests(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	tests := newSelfTests()
+	tests.run()
+	if report := getSelfTestReport(t, tests); report.Status != types.SelfTestPassed {
+		t.Errorf("Expected an application without self-tests to pass, got %+v", report)
+	}
+
+	rec := httptest.NewRecorder()
+	tests.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, SelfTestPath, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the internal secret, got %d", rec.Code)
+	}
+}
+
+func TestSchemaSelfTest(t *testing.T) {
+	db, err := database.Connect("sqlite3", path.Join(t.TempDir(), "app.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.GetDB().MustExec(`CREATE TABLE schema_version (id INTEGER PRIMARY KEY, version INTEGER NOT NULL)`)
+	db.GetDB().MustExec(`INSERT INTO schema_version (id, version) VALUES (0, 1)`)
+
+	db.SetSchemaVersion(1)
+	if err := SchemaSelfTest(db)(context.Background()); err != nil {
+		t.Errorf("Expected a matching schema to pass, got %v", err)
+	}
+	db.SetSchemaVersion(2)
+	if err := SchemaSelfTest(db)(context.Background()); err == nil {
+		t.Error("Expected a schema behind the binary to fail")
+	}
+}
+
+func TestConfigSelfTest(t *testing.T) {
+	type config struct {
+		Host string `env:"SELFTEST_HOST,required"`
+	}
+	cfg := &config{Host: "unchanged"}
+	if err := ConfigSelfTest(cfg)(context.Background()); err == nil {
+		t.Error("Expected missing configuration to fail")
+	}
+	t.Setenv("SELFTEST_HOST", "example.com")
+	if err := ConfigSelfTest(cfg)(context.Background()); err != nil || cfg.Host != "unchanged" {
+		t.Errorf("Expected the configuration to pass without being modified, got %q: %v", cfg.Host, err)
+	}
+}
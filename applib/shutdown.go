@@ -0,0 +1,132 @@
+package applib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownHookTimeout bounds each shutdown hook. The hub kills
+// processes that have not exited 10 seconds after asking them to stop.
+const DefaultShutdownHookTimeout = 5 * time.Second
+
+// shutdownDrainTimeout bounds waiting for in-flight requests to finish.
+const shutdownDrainTimeout = 3 * time.Second
+
+// shutdownFilePollInterval is how often the file named by SHUTDOWN_FILE is
+// checked for.
+const shutdownFilePollInterval = 100 * time.Millisecond
+
+// ShutdownHook releases a resource when the application stops. It should
+// return promptly once ctx is done.
+type ShutdownHook func(ctx context.Context) error
+
+// shutdownHooks holds the hooks registered with OnShutdown.
+type shutdownHooks struct {
+	mu      sync.Mutex
+	hooks   []ShutdownHook
+	timeout time.Duration
+}
+
+// OnShutdown registers a hook that runs when the application is asked to
+// stop (SIGTERM, SIGINT or its shutdown file), after the server has stopped accepting requests
+// and before the database is closed. Hooks run one at a time in the reverse
+// order they were registered, so resources are released before the ones
+// they depend on. Each hook is given DefaultShutdownHookTimeout; hooks that
+// fail or time out are logged and the remaining hooks still run.
+func (app *Application) OnShutdown(hook ShutdownHook) {
+	app.shutdown.mu.Lock()
+	defer app.shutdown.mu.Unlock()
+	app.shutdown.hooks = append(app.shutdown.hooks, hook)
+}
+
+// SetShutdownHookTimeout changes how long each shutdown hook is given.
+func (app *Application) SetShutdownHookTimeout(timeout time.Duration) {
+	app.shutdown.mu.Lock()
+	defer app.shutdown.mu.Unlock()
+	app.shutdown.timeout = timeout
+}
+
+// run runs the hooks last to first, returning once each has finished or
+// timed out.
+func (s *shutdownHooks) run() {
+	s.mu.Lock()
+	hooks := append([]ShutdownHook{}, s.hooks...)
+	timeout := s.timeout
+	s.mu.Unlock()
+	if timeout <= 0 {
+		timeout = DefaultShutdownHookTimeout
+	}
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		start := time.Now()
+		if err := runShutdownHook(hooks[i], timeout); err != nil {
+			log.Printf("Shutdown hook %d failed after %v: %v", i, time.Since(start).Round(time.Millisecond), err)
+		}
+	}
+}
+
+// runShutdownHook runs a single hook with a timeout, turning a panic into a
+// failure. A hook that ignores its context is abandoned once the timeout
+// passes.
+func runShutdownHook(hook ShutdownHook, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- hook(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// notifyShutdown returns a context that is done when the hub asks the
+// application to stop: with SIGTERM or SIGINT, or, on Windows hosts where it
+// can't signal processes reliably, by creating the file named by
+// SHUTDOWN_FILE.
+func notifyShutdown(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stopSignals := signal.NotifyContext(parent, syscall.SIGTERM, os.Interrupt)
+	path := os.Getenv("SHUTDOWN_FILE")
+	if path == "" {
+		return ctx, stopSignals
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go watchShutdownFile(ctx, path, shutdownFilePollInterval, cancel)
+	return ctx, func() {
+		cancel()
+		stopSignals()
+	}
+}
+
+// watchShutdownFile calls stop once a file exists at path, checking every
+// interval until ctx is done.
+func watchShutdownFile(ctx context.Context, path string, interval time.Duration, stop func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			log.Printf("Found shutdown file %s", path)
+			stop()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package applib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownHooks(t *testing.T) {
+	app := &Application{}
+	app.SetShutdownHookTimeout(50 * time.Millisecond)
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+	app.OnShutdown(func(ctx context.Context) error {
+		record("first")
+		return nil
+	})
+	app.OnShutdown(func(ctx context.Context) error {
+		record("failing")
+		return errors.New("flush failed")
+	})
+	app.OnShutdown(func(ctx context.Context) error {
+		record("panics")
+		panic("boom")
+	})
+	app.OnShutdown(func(ctx context.Context) error {
+		record("slow")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	stuck := make(chan struct{})
+	defer close(stuck)
+	app.OnShutdown(func(ctx context.Context) error {
+		record("stuck")
+		<-stuck
+		return nil
+	})
+
+	start := time.Now()
+	app.shutdown.run()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected hooks that overrun to be abandoned, took %v", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"stuck", "slow", "panics", "failing", "first"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected hooks to run last to first %v, got %v", expected, order)
+	}
+}
+
+func TestShutdownFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "shutdown")
+	t.Setenv("SHUTDOWN_FILE", path)
+	ctx, stop := notifyShutdown(context.Background())
+	defer stop()
+
+	time.Sleep(3 * shutdownFilePollInterval / 2)
+	if ctx.Err() != nil {
+		t.Fatal("Expected no shutdown before the file exists")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the shutdown file to stop the application")
+	}
+}
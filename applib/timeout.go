@@ -0,0 +1,29 @@
+package applib
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// SetHandlerTimeout bounds how long any handler may run. Requests that exceed
+// the timeout receive a 503 Service Unavailable, and the request context
+// carries the deadline so database queries and cross-service calls made with
+// r.Context() are cancelled as well.
+//
+// Routes listed in exemptRoutes (matched against the registered pattern, e.g.
+// "/api/stream") are not wrapped; use this for long-lived responses like SSE
+// streams, which http.TimeoutHandler would otherwise buffer and cut off.
+func (app *Application) SetHandlerTimeout(d time.Duration, exemptRoutes ...string) {
+	app.Use(func(next http.Handler) http.Handler {
+		timeoutHandler := http.TimeoutHandler(next, d, "Request timed out")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := http.DefaultServeMux.Handler(r)
+			if slices.Contains(exemptRoutes, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timeoutHandler.ServeHTTP(w, r)
+		})
+	})
+}
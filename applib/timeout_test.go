@@ -0,0 +1,44 @@
+package applib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetHandlerTimeout(t *testing.T) {
+	observedDeadline := make(chan bool, 1)
+	http.HandleFunc("/api/timeout-test/slow", func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline := r.Context().Deadline()
+		observedDeadline <- hasDeadline
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			w.Write([]byte("finished"))
+		}
+	})
+	http.HandleFunc("/api/timeout-test/stream", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("streamed"))
+	})
+
+	app := NewApplication(nil)
+	app.SetHandlerTimeout(20*time.Millisecond, "/api/timeout-test/stream")
+	handler := app.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/timeout-test/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for slow handler, got %d", rec.Code)
+	}
+	if !<-observedDeadline {
+		t.Error("Expected request context to carry a deadline")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/timeout-test/stream", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "streamed" {
+		t.Errorf("Expected exempt route to complete, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,58 @@
+package applib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// serverTLSConfig returns the TLS configuration for serving over TCP, or nil
+// to serve plain HTTP. The hub issues the application a server certificate
+// and passes it in TLS_CERT_FILE and TLS_KEY_FILE, along with the CA of the
+// client certificate it dials with in TLS_CLIENT_CA_FILE. Connections
+// without a client certificate from that CA are refused, so only the hub can
+// call the application. When INSTANCE_ID is set, the certificate must also
+// have been issued for this instance.
+//
+// Without TLS_CERT_FILE, e.g. when running an application locally or with
+// the hub's BACKEND_MTLS set to "off", the application serves plain HTTP.
+func serverTLSConfig() (*tls.Config, error) {
+	certFile := os.Getenv("TLS_CERT_FILE")
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		return nil, errors.New("TLS_CLIENT_CA_FILE is required with TLS_CERT_FILE")
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if instanceID := os.Getenv("INSTANCE_ID"); instanceID != "" {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if peer := state.PeerCertificates[0].Subject.CommonName; peer != instanceID {
+				return fmt.Errorf("client certificate is for %q, not this instance", peer)
+			}
+			return nil
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,128 @@
+package applib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates like the hub's internal CA.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTLSConfigRequiresHubCertificate(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "")
+	if config, err := serverTLSConfig(); config != nil || err != nil {
+		t.Fatalf("Expected plain HTTP without TLS_CERT_FILE, got %v: %v", config, err)
+	}
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	server := ca.issue(t, "inst", x509.ExtKeyUsageServerAuth)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(server.PrivateKey)
+	writePEM(t, filepath.Join(dir, "server.crt"), "CERTIFICATE", server.Certificate[0])
+	writePEM(t, filepath.Join(dir, "server.key"), "PRIVATE KEY", keyDER)
+	os.WriteFile(filepath.Join(dir, "ca.crt"), ca.pem, 0600)
+	t.Setenv("TLS_CERT_FILE", filepath.Join(dir, "server.crt"))
+	t.Setenv("TLS_KEY_FILE", filepath.Join(dir, "server.key"))
+	t.Setenv("TLS_CLIENT_CA_FILE", filepath.Join(dir, "ca.crt"))
+	t.Setenv("INSTANCE_ID", "inst")
+
+	config, err := serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Config.ErrorLog = log.New(io.Discard, "", 0)
+	backend.TLS = config
+	backend.StartTLS()
+	defer backend.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCerts ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{Certificates: clientCerts, RootCAs: roots, ServerName: "inst"},
+		}}
+		resp, err := client.Get(backend.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(ca.issue(t, "inst", x509.ExtKeyUsageClientAuth)); err != nil {
+		t.Errorf("Expected the hub's certificate to be accepted: %v", err)
+	}
+	if err := get(); err == nil {
+		t.Error("Expected a connection without a client certificate to be rejected")
+	}
+	if err := get(ca.issue(t, "other", x509.ExtKeyUsageClientAuth)); err == nil {
+		t.Error("Expected a certificate for another instance to be rejected")
+	}
+	if err := get(newTestCA(t).issue(t, "inst", x509.ExtKeyUsageClientAuth)); err == nil {
+		t.Error("Expected a certificate from another CA to be rejected")
+	}
+}
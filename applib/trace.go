@@ -0,0 +1,31 @@
+package applib
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+var ContextTraceIDKey = "trace_id"
+
+// traceMiddleware adds the trace ID sent by the proxy in the X-Trace-ID
+// header to the request context. Records logged with that context, e.g.
+// slog.InfoContext(r.Context(), ...), are tagged with it, so the hub can show
+// them in the request's trace. Every Application installs it.
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID, ok := httputils.RequestTraceID(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextTraceIDKey, traceID)))
+	})
+}
+
+// TraceID returns the trace ID of the request being served.
+func TraceID(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(ContextTraceIDKey).(string)
+	return traceID, ok && traceID != ""
+}
@@ -0,0 +1,37 @@
+package applib
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogLinesTaggedWithTraceID(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(newLevelHandler(&output)).With(ModuleKey, "http")
+	handler := traceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "serving", "path", r.URL.Path)
+		logger.Info("without context")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.Header.Set("X-Trace-ID", "trace-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/untraced", nil))
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got:\n%s", output.String())
+	}
+	if !strings.HasSuffix(lines[0], `msg=serving module=http path=/api/items trace_id=trace-1`) {
+		t.Errorf("Expected the line to end with the trace ID, got %s", lines[0])
+	}
+	for _, line := range lines[1:] {
+		if strings.Contains(line, "trace_id") {
+			t.Errorf("Expected no trace ID, got %s", line)
+		}
+	}
+}
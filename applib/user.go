@@ -0,0 +1,32 @@
+package applib
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+var ContextUserIDKey = "user_id"
+
+// userMiddleware adds the authenticated user sent by the proxy in the
+// X-Yesterday-User-Id header to the request context. Every Application
+// installs it.
+func userMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := httputils.RequestUserID(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextUserIDKey, userID)))
+	})
+}
+
+// UserID returns the ID of the user the request was made for. It returns
+// false for requests made with the internal secret rather than a user's
+// access token.
+func UserID(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(ContextUserIDKey).(int)
+	return userID, ok
+}
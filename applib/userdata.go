@@ -0,0 +1,101 @@
+package applib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+const (
+	// UserDataExportPath is the internal endpoint the hub calls to gather an
+	// application's records about a user.
+	UserDataExportPath = "/internal/user-data/export"
+	// UserDataForgetPath is the internal endpoint the hub calls to forget a
+	// user.
+	UserDataForgetPath = "/internal/user-data/forget"
+)
+
+// UserDataExtractor returns the application's records about a user, for the
+// archive assembled by the hub's user data export. The records are encoded as
+// JSON.
+type UserDataExtractor func(db *sqlx.DB, userID int) (any, error)
+
+// userDataHandlers serves the internal user data endpoints.
+type userDataHandlers struct {
+	db      *database.Database
+	extract UserDataExtractor
+}
+
+// HandleUserData takes part in the hub's user data exports and deletions. The
+// hub calls UserDataExportPath to gather the records returned by extract,
+// and UserDataForgetPath to forget a user: the user's personal data, marked
+// with database.SetPersonalData, is crypto-shredded and the projections are
+// rebuilt, starting from reset, see database.ForgetUser. reset must return
+// every projection the application builds from events to its state before
+// the first event. Call it before initializing the database.
+func (app *Application) HandleUserData(extract UserDataExtractor, reset func(tx *sqlx.Tx) error) {
+	app.db.AddResetHandler(reset)
+	handlers := &userDataHandlers{db: app.db, extract: extract}
+	http.HandleFunc(UserDataExportPath, handlers.handleExport)
+	http.HandleFunc(UserDataForgetPath, handlers.handleForget)
+}
+
+// userIDParam checks that a request to a user data endpoint was made by the
+// hub and returns the user ID it is about.
+func userIDParam(w http.ResponseWriter, r *http.Request, method string) (int, bool) {
+	if !httputils.IsInternalRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+	userID, err := strconv.Atoi(r.URL.Query().Get("userId"))
+	if err != nil || userID <= 0 {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid userId %q", r.URL.Query().Get("userId")), http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+func (h *userDataHandlers) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+	records, err := h.extract(h.db.GetDB(), userID)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to extract user data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	encoded, err := json.Marshal(records)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to encode user data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, types.UserDataExport{UserID: userID, Records: encoded}, nil, http.StatusOK)
+}
+
+func (h *userDataHandlers) handleForget(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+	logged, err := h.db.ForgetUser(userID)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to forget user %d: %v", userID, err), http.StatusInternalServerError)
+		return
+	}
+	response := types.UserDataForget{UserID: userID, Events: make([]types.RedactedEvent, len(logged))}
+	for i, event := range logged {
+		response.Events[i] = types.RedactedEvent{ID: event.ID, Type: event.Type, Data: event.Data}
+	}
+	httputils.HandleAPIResponse(w, r, response, nil, http.StatusOK)
+}
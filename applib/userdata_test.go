@@ -0,0 +1,83 @@
+package applib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func newUserDataHandlers(t *testing.T) *userDataHandlers {
+	db, err := database.Connect("sqlite3", path.Join(t.TempDir(), "app.sqlite"))
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Initialize registers HTTP handlers, which can only happen once per
+	// process, so only the event tables are created. Redaction by replay is
+	// tested in the database package.
+	if _, err := database.NewEventState(db.GetDB()); err != nil {
+		t.Fatalf("NewEventState returned error: %v", err)
+	}
+	db.GetDB().MustExec(`CREATE TABLE notes (user_id INTEGER, text TEXT)`)
+	db.GetDB().MustExec(`INSERT INTO notes (user_id, text) VALUES (7, 'call mum'), (8, 'buy milk')`)
+	db.AddResetHandler(func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`DELETE FROM notes WHERE user_id = 7`)
+		return err
+	})
+	return &userDataHandlers{db: db, extract: func(db *sqlx.DB, userID int) (any, error) {
+		notes := []string{}
+		err := db.Select(&notes, `SELECT text FROM notes WHERE user_id = $1`, userID)
+		return map[string]any{"notes": notes}, err
+	}}
+}
+
+func userDataRequest(t *testing.T, handler http.HandlerFunc, method, target string, internal bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if internal {
+		req.Header.Set("Authorization", "Bearer test-secret")
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestUserDataEndpoints(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	handlers := newUserDataHandlers(t)
+
+	rec := userDataRequest(t, handlers.handleExport, http.MethodGet, UserDataExportPath+"?userId=7", true)
+	var export types.UserDataExport
+	if err := json.NewDecoder(rec.Body).Decode(&export); err != nil || export.UserID != 7 || string(export.Records) != `{"notes":["call mum"]}` {
+		t.Errorf("Unexpected export %d %+v: %v", rec.Code, export, err)
+	}
+
+	rec = userDataRequest(t, handlers.handleForget, http.MethodPost, UserDataForgetPath+"?userId=7", true)
+	var forget types.UserDataForget
+	if err := json.NewDecoder(rec.Body).Decode(&forget); err != nil || forget.UserID != 7 || forget.Events == nil {
+		t.Fatalf("Unexpected forget response %d %+v: %v", rec.Code, forget, err)
+	}
+	rec = userDataRequest(t, handlers.handleExport, http.MethodGet, UserDataExportPath+"?userId=7", true)
+	if err := json.NewDecoder(rec.Body).Decode(&export); err != nil || string(export.Records) != `{"notes":[]}` {
+		t.Errorf("Expected the projections to be rebuilt from the reset, got %s: %v", export.Records, err)
+	}
+}
+
+func TestUserDataEndpointsAreInternal(t *testing.T) {
+	t.Setenv("INTERNAL_SECRET", "test-secret")
+	handlers := newUserDataHandlers(t)
+	if rec := userDataRequest(t, handlers.handleForget, http.MethodPost, UserDataForgetPath+"?userId=7", false); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the internal secret, got %d", rec.Code)
+	}
+	if rec := userDataRequest(t, handlers.handleForget, http.MethodGet, UserDataForgetPath+"?userId=7", true); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+	if rec := userDataRequest(t, handlers.handleExport, http.MethodGet, UserDataExportPath+"?userId=abc", true); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid user ID, got %d", rec.Code)
+	}
+}
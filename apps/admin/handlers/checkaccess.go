@@ -1,24 +1,37 @@
 package handlers
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib"
 	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/apps/admin/state"
 	admin_types "github.com/tomyedwab/yesterday/apps/admin/types"
 )
 
-func HandleCheckAccess(w http.ResponseWriter, r *http.Request) {
+// HandleCheckAccess decides whether the hub may issue an access token to a
+// user, and whether the token is restricted to changing an expired password.
+func HandleCheckAccess(w http.ResponseWriter, r *http.Request, policy *state.PasswordPolicy) {
+	db := r.Context().Value(applib.ContextSqliteDatabaseKey).(*sqlx.DB)
+
 	var request admin_types.AccessRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
+	if err := httputils.BindJSON(r, &request); err != nil {
+		httputils.HandleBindError(w, r, err)
+		return
+	}
+
+	user, err := state.GetUserByID(db, request.UserID)
 	if err != nil {
-		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("error parsing request: %v", err), http.StatusBadRequest)
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to get user %d: %w", request.UserID, err), http.StatusBadRequest)
 		return
 	}
 
 	// TODO(tom): user-application permissions check
 	httputils.HandleAPIResponse(w, r, admin_types.AccessResponse{
-		AccessGranted: true,
+		AccessGranted:      true,
+		MustChangePassword: policy.Expired(user.PasswordChangedAt, time.Now()),
 	}, nil, http.StatusOK)
 }
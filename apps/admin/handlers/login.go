@@ -3,9 +3,9 @@ package handlers
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/tomyedwab/yesterday/applib"
@@ -14,13 +14,14 @@ import (
 	admin_types "github.com/tomyedwab/yesterday/apps/admin/types"
 )
 
-func HandleDoLogin(w http.ResponseWriter, r *http.Request) {
+// HandleDoLogin checks a user's credentials for the hub. A user whose
+// password has expired under the policy is logged in with MustChange set.
+func HandleDoLogin(w http.ResponseWriter, r *http.Request, policy *state.PasswordPolicy) {
 	db := r.Context().Value(applib.ContextSqliteDatabaseKey).(*sqlx.DB)
 
 	var request admin_types.AdminLoginRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("error parsing request: %v", err), http.StatusBadRequest)
+	if err := httputils.BindJSON(r, &request); err != nil {
+		httputils.HandleBindError(w, r, err)
 		return
 	}
 
@@ -40,10 +41,12 @@ func HandleDoLogin(w http.ResponseWriter, r *http.Request) {
 		httputils.HandleAPIResponse(w, r, admin_types.AdminLoginResponse{
 			Success: false,
 		}, nil, http.StatusOK)
+		return
 	}
 
 	httputils.HandleAPIResponse(w, r, admin_types.AdminLoginResponse{
-		Success: true,
-		UserID:  user.ID,
+		Success:    true,
+		UserID:     user.ID,
+		MustChange: policy.Expired(user.PasswordChangedAt, time.Now()),
 	}, nil, http.StatusOK)
 }
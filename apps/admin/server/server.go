@@ -0,0 +1,202 @@
+// Package server is the Admin application, which manages user accounts and
+// checks logins for the hub. It runs as its own process from main, or
+// embedded in the hub by importing this package.
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib"
+	"github.com/tomyedwab/yesterday/applib/database"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/apps/admin/handlers"
+	"github.com/tomyedwab/yesterday/apps/admin/state"
+	"github.com/tomyedwab/yesterday/apps/admin/types"
+)
+
+// Name is the application's name in its package manifest, under which it is
+// registered to run embedded.
+const Name = "User admin"
+
+// HashPasswordRequest is the request of /api/hash_password. Older clients
+// send the password as a JSON string instead, which skips the username
+// check.
+type HashPasswordRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HashedPassword is the response of /api/hash_password.
+type HashedPassword struct {
+	Salt         string `json:"salt"`
+	PasswordHash string `json:"passwordHash"`
+}
+
+// UserProfileParams are the query parameters of /api/user_profile, which
+// looks a user up by exactly one of ID or username.
+type UserProfileParams struct {
+	ID       int    `query:"id" validate:"min=1"`
+	Username string `query:"username"`
+}
+
+func init() {
+	applib.RegisterEmbedded(Name, Setup)
+}
+
+// Setup registers the Admin application's handlers and initializes its
+// database, ready for the application to be served.
+func Setup(application *applib.Application) error {
+	passwordPolicy := &state.PasswordPolicy{}
+	if err := applib.LoadConfig(passwordPolicy); err != nil {
+		return err
+	}
+
+	// Internal login functionality, called by nexushub directly
+	http.HandleFunc("/internal/dologin", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleDoLogin(w, r, passwordPolicy)
+	})
+	http.HandleFunc("/internal/checkAccess", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleCheckAccess(w, r, passwordPolicy)
+	})
+
+	// Register data views. The user list is streamed as NDJSON, one user per
+	// line, to clients that accept it.
+	applib.HandleAPI("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		db := r.Context().Value(applib.ContextSqliteDatabaseKey).(*sqlx.DB)
+		if httputils.WantsNDJSON(r) {
+			httputils.HandleNDJSONResponse(w, r, []string{"id", "username"}, func(emit func(any) error) error {
+				return state.StreamUsers(db, func(user state.User) error {
+					return emit(user)
+				})
+			}, http.StatusInternalServerError)
+			return
+		}
+		ret, err := state.GetUsers(db)
+		httputils.HandleFieldsAPIResponse(w, r, state.UsersData{
+			Users: ret,
+		}, []string{"id", "username"}, err, http.StatusInternalServerError)
+	}, applib.WithName("ListUsers"), applib.WithResponse(state.UsersData{}), applib.AsDataView())
+
+	applib.HandleAPI("/api/feature_flags", func(w http.ResponseWriter, r *http.Request) {
+		db := r.Context().Value(applib.ContextSqliteDatabaseKey).(*sqlx.DB)
+		ret, err := state.GetFeatureFlags(db)
+		httputils.HandleAPIResponse(w, r, state.FeatureFlagsData{
+			Flags: ret,
+		}, err, http.StatusInternalServerError)
+	}, applib.WithName("ListFeatureFlags"), applib.WithResponse(state.FeatureFlagsData{}), applib.AsDataView())
+
+	applib.HandleAPI("/api/user_profile", func(w http.ResponseWriter, r *http.Request) {
+		var params UserProfileParams
+		if err := httputils.BindQuery(r, &params); err != nil {
+			httputils.HandleBindError(w, r, err)
+			return
+		}
+		if (params.ID == 0) == (params.Username == "") {
+			httputils.HandleBindError(w, r, &httputils.ValidationError{Fields: []httputils.FieldError{{
+				Message: "exactly one of id or username is required",
+			}}})
+			return
+		}
+		db := r.Context().Value(applib.ContextSqliteDatabaseKey).(*sqlx.DB)
+		var user *state.User
+		var err error
+		if params.ID != 0 {
+			user, err = state.GetUserByID(db, params.ID)
+		} else {
+			user, err = state.GetUser(db, params.Username)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("user not found"), http.StatusNotFound)
+			return
+		}
+		httputils.HandleAPIResponse(w, r, user, err, http.StatusInternalServerError)
+	}, applib.WithName("GetUserProfile"), applib.WithResponse(state.User{}))
+
+	// Event log statistics, fetched from the hub
+	applib.HandleAPI("/api/event_stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, status, err := httputils.CallService[any, types.EventStats](os.Getenv("INSTANCE_ID"), "/events/stats", nil)
+		httputils.HandleAPIResponse(w, r, stats, err, status)
+	}, applib.WithName("GetEventStats"), applib.WithResponse(types.EventStats{}), applib.AsDataView())
+
+	// Special method to hash a password for older clients that publish
+	// User:Add events with a hash. Newer clients send the password in the
+	// event and it is hashed when the event is applied.
+	applib.HandleAPI("/api/hash_password", func(w http.ResponseWriter, r *http.Request) {
+		requestBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var request HashPasswordRequest
+		if err := json.Unmarshal(requestBytes, &request.Password); err != nil {
+			if err := json.Unmarshal(requestBytes, &request); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to unmarshal password: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := passwordPolicy.Validate(request.Username, request.Password); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+			return
+		}
+
+		salt, passwordHash := state.HashPassword(request.Password)
+		passwordPolicy.Issue(salt, passwordHash)
+		httputils.HandleAPIResponse(w, r, HashedPassword{
+			Salt:         salt,
+			PasswordHash: passwordHash,
+		}, nil, http.StatusOK)
+	}, applib.WithRequest(HashPasswordRequest{}), applib.WithResponse(HashedPassword{}))
+
+	db := application.GetDatabase()
+
+	tx, err := db.GetDB().Beginx()
+	if err != nil {
+		return err
+	}
+	if err := state.InitUsers(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := state.InitFeatureFlags(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	db.SetSchemaVersion(len(state.UsersMigrations), state.UsersMigrations...)
+	// Deleted users can be restored until they are purged
+	db.RegisterSoftDeleteTable(state.UsersTable, "deleted_at")
+
+	// User management event handlers. New passwords are checked against the
+	// policy before they are applied.
+	database.AddEventHandlerWithMode(db, state.UserAddedEventType, database.ApplyLive, passwordPolicy.HandleAddedEvent)
+	database.AddEventHandlerWithMode(db, state.UpdateUserPasswordEventType, database.ApplyLive, passwordPolicy.HandleUpdatePasswordEvent)
+	database.AddEventHandler(db, state.UserAddedEventType, state.UsersHandleAddedEvent)
+	database.AddEventHandler(db, state.UpdateUserPasswordEventType, state.UsersHandleUpdatePasswordEvent)
+	database.AddEventHandler(db, state.DeleteUserEventType, state.UsersHandleDeleteEvent)
+	database.AddEventHandler(db, state.RestoreUserEventType, state.UsersHandleRestoreEvent)
+	database.AddEventHandler(db, state.UpdateUserEventType, state.UsersHandleUpdateEvent)
+	for eventType, personalData := range state.UsersPersonalData {
+		db.SetPersonalData(eventType, personalData)
+	}
+
+	// Feature flags of all applications
+	database.AddEventHandler(db, applib.FeatureFlagSetEventType, state.FeatureFlagsHandleSetEvent)
+	database.AddEventHandler(db, applib.FeatureFlagDeleteEventType, state.FeatureFlagsHandleDeleteEvent)
+
+	// Profiles and access grants for user data exports, and the tables to
+	// rebuild when a user is forgotten
+	application.HandleUserData(state.ExtractUserData, state.ResetProjections)
+
+	return db.Initialize()
+}
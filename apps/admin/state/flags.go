@@ -0,0 +1,88 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib"
+)
+
+// FeatureFlag is a flag of an application instance. Each application keeps
+// its own flags through applib.EnableFeatureFlags; the Admin app keeps every
+// instance's flags so they can be listed in one place.
+type FeatureFlag struct {
+	InstanceID string `json:"instanceId"`
+	applib.FeatureFlag
+}
+
+type FeatureFlagsData struct {
+	Flags []FeatureFlag `json:"flags"`
+}
+
+// -- DB Helpers --
+
+func GetFeatureFlags(db *sqlx.DB) ([]FeatureFlag, error) {
+	var rows []struct {
+		InstanceID string `db:"instance_id"`
+		Flag       string `db:"flag"`
+	}
+	err := db.Select(&rows, `SELECT instance_id, flag FROM admin_feature_flags_v1 ORDER BY instance_id, name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	flags := make([]FeatureFlag, len(rows))
+	for i, row := range rows {
+		flags[i].InstanceID = row.InstanceID
+		if err := json.Unmarshal([]byte(row.Flag), &flags[i].FeatureFlag); err != nil {
+			return nil, fmt.Errorf("failed to decode feature flag: %w", err)
+		}
+	}
+	return flags, nil
+}
+
+// -- Event handlers --
+
+func InitFeatureFlags(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS admin_feature_flags_v1 (
+			instance_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			flag TEXT NOT NULL,
+			PRIMARY KEY (instance_id, name)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create feature flags table: %w", err)
+	}
+	return nil
+}
+
+func FeatureFlagsHandleSetEvent(tx *sqlx.Tx, event *applib.FeatureFlagSetEvent) (bool, error) {
+	fmt.Printf("Setting feature flag %s for instance %s\n", event.Name, event.InstanceID)
+	flag, err := json.Marshal(event.FeatureFlag)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal feature flag %s: %w", event.Name, err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO admin_feature_flags_v1 (instance_id, name, flag) VALUES ($1, $2, $3)
+		ON CONFLICT (instance_id, name) DO UPDATE SET flag = excluded.flag`,
+		event.InstanceID, event.Name, string(flag))
+	if err != nil {
+		return false, fmt.Errorf("failed to store feature flag %s: %w", event.Name, err)
+	}
+	return true, nil
+}
+
+func FeatureFlagsHandleDeleteEvent(tx *sqlx.Tx, event *applib.FeatureFlagDeleteEvent) (bool, error) {
+	fmt.Printf("Deleting feature flag %s for instance %s\n", event.Name, event.InstanceID)
+	result, err := tx.Exec(`DELETE FROM admin_feature_flags_v1 WHERE instance_id = $1 AND name = $2`,
+		event.InstanceID, event.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete feature flag %s: %w", event.Name, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,190 @@
+package state
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
+)
+
+// commonPasswords is a short deny-list of about 400 of the most frequently
+// used passwords, one per line in lowercase. It is not a full top-10k list;
+// replace the file with a longer list to deny more.
+//
+//go:embed common_passwords_short.txt
+var commonPasswordsList string
+
+var commonPasswords = parseCommonPasswords(commonPasswordsList)
+
+func parseCommonPasswords(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			passwords[strings.ToLower(password)] = true
+		}
+	}
+	return passwords
+}
+
+// issuedHashTTL is how long a hash returned by /api/hash_password is accepted
+// in a User:Add event.
+const issuedHashTTL = time.Hour
+
+// PasswordPolicy is the set of rules new passwords must satisfy, loaded from
+// the environment with applib.LoadConfig.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int `env:"PASSWORD_MIN_LENGTH" default:"10"`
+	// MinCharacterClasses is how many of lowercase letters, uppercase
+	// letters, digits and symbols the password must contain
+	MinCharacterClasses int `env:"PASSWORD_MIN_CHARACTER_CLASSES" default:"2"`
+	// DenyCommon rejects passwords on the embedded common password list
+	DenyCommon bool `env:"PASSWORD_DENY_COMMON" default:"true"`
+	// DenyUsername rejects passwords that contain the username
+	DenyUsername bool `env:"PASSWORD_DENY_USERNAME" default:"true"`
+	// MaxAge is how long a password may be used before the user has to
+	// change it. Zero disables expiry.
+	MaxAge time.Duration `env:"PASSWORD_MAX_AGE"`
+
+	mu sync.Mutex
+	// issued holds the hashes returned by /api/hash_password, which were
+	// checked against the policy, and when they expire
+	issued map[string]time.Time
+}
+
+// PasswordPolicyError is returned when a password does not satisfy the
+// policy.
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy: " + e.Reason
+}
+
+// IsPasswordPolicyError reports whether err is a PasswordPolicyError.
+func IsPasswordPolicyError(err error) bool {
+	var policyErr *PasswordPolicyError
+	return errors.As(err, &policyErr)
+}
+
+// Validate checks a new password for the user against the policy. username
+// may be empty if it is not known yet.
+func (p *PasswordPolicy) Validate(username, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("must be at least %d characters long", p.MinLength)}
+	}
+	if classes := characterClasses(password); classes < p.MinCharacterClasses {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharacterClasses)}
+	}
+	if p.DenyCommon && commonPasswords[strings.ToLower(password)] {
+		return &PasswordPolicyError{Reason: "is too common"}
+	}
+	if p.DenyUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return &PasswordPolicyError{Reason: "must not contain the username"}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// Expired reports whether a password last changed at changedAt has to be
+// changed. A password whose age is unknown, such as the initial admin
+// password, is expired as soon as a maximum age is set.
+func (p *PasswordPolicy) Expired(changedAt *time.Time, now time.Time) bool {
+	if p.MaxAge <= 0 {
+		return false
+	}
+	return changedAt == nil || now.Sub(*changedAt) > p.MaxAge
+}
+
+// Issue remembers a hash returned by /api/hash_password for a password that
+// passed the policy, so that the User:Add event carrying it is accepted.
+func (p *PasswordPolicy) Issue(salt, passwordHash string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.issued == nil {
+		p.issued = make(map[string]time.Time)
+	}
+	for key, expiry := range p.issued {
+		if now.After(expiry) {
+			delete(p.issued, key)
+		}
+	}
+	p.issued[salt+":"+passwordHash] = now.Add(issuedHashTTL)
+}
+
+func (p *PasswordPolicy) wasIssued(salt, passwordHash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expiry, ok := p.issued[salt+":"+passwordHash]
+	return ok && time.Now().Before(expiry)
+}
+
+// -- Event handlers --
+
+// These run only for live events, before the handlers that apply them, so
+// that events published by clients that skip the checks are rejected. Events
+// already in the event log are replayed as they are.
+
+// HandleAddedEvent checks the password of a new user. Events from older
+// clients carry a hash instead of the password, which is only accepted if
+// it was computed by /api/hash_password.
+func (p *PasswordPolicy) HandleAddedEvent(tx *sqlx.Tx, event *UserAddedEvent) (bool, error) {
+	if event.Password == "" {
+		if !p.wasIssued(event.Salt, event.PasswordHash) {
+			return false, database.RejectEvent(fmt.Errorf("password hash for user %s was not issued by /api/hash_password", event.Username))
+		}
+		return false, nil
+	}
+	if err := p.Validate(event.Username, event.Password); err != nil {
+		return false, database.RejectEvent(err)
+	}
+	return false, nil
+}
+
+// HandleUpdatePasswordEvent checks a user's new password.
+func (p *PasswordPolicy) HandleUpdatePasswordEvent(tx *sqlx.Tx, event *UpdateUserPasswordEvent) (bool, error) {
+	// Forgetting a user replaces the password with a tombstone
+	if database.IsRedacted(event.NewPassword) {
+		return false, nil
+	}
+	var username string
+	if err := tx.Get(&username, `SELECT username FROM users_v1 WHERE id = $1`, event.UserID); err != nil {
+		return false, fmt.Errorf("failed to get user %d: %w", event.UserID, err)
+	}
+	if err := p.Validate(username, event.NewPassword); err != nil {
+		return false, database.RejectEvent(err)
+	}
+	return false, nil
+}
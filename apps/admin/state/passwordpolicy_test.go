@@ -0,0 +1,138 @@
+package state
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
+)
+
+func testPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:           10,
+		MinCharacterClasses: 2,
+		DenyCommon:          true,
+		DenyUsername:        true,
+	}
+}
+
+func TestPasswordPolicyRules(t *testing.T) {
+	policy := testPolicy()
+	for _, tc := range []struct {
+		name     string
+		username string
+		password string
+		valid    bool
+	}{
+		{"valid", "alice", "Correct-horse-7", true},
+		{"too short", "alice", "Sh0rt!", false},
+		{"one character class", "alice", "alllowercaseletters", false},
+		{"digits count as a class", "alice", "lowercase2024", true},
+		{"common", "alice", "Password123", false},
+		{"common in any case", "alice", "QWERTYUIOP123", false},
+		{"contains username", "alice", "Alice-rocks-99", false},
+		{"username unknown", "", "Alice-rocks-99", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Validate(tc.username, tc.password)
+			if tc.valid && err != nil {
+				t.Errorf("Expected %q to be accepted, got %v", tc.password, err)
+			}
+			if !tc.valid && !IsPasswordPolicyError(err) {
+				t.Errorf("Expected %q to be rejected, got %v", tc.password, err)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyRulesCanBeDisabled(t *testing.T) {
+	policy := &PasswordPolicy{}
+	if err := policy.Validate("alice", "alice"); err != nil {
+		t.Errorf("Expected an empty policy to accept any password, got %v", err)
+	}
+}
+
+func TestPasswordExpired(t *testing.T) {
+	policy := testPolicy()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	if policy.Expired(nil, now) || policy.Expired(&old, now) {
+		t.Error("Expected passwords never to expire without a maximum age")
+	}
+
+	policy.MaxAge = 24 * time.Hour
+	recent := now.Add(-time.Hour)
+	if policy.Expired(&recent, now) {
+		t.Error("Expected a recent password to be accepted")
+	}
+	if !policy.Expired(&old, now) {
+		t.Error("Expected a password older than the maximum age to expire")
+	}
+	if !policy.Expired(nil, now) {
+		t.Error("Expected a password of unknown age to expire")
+	}
+}
+
+func setupUsersDatabase(t *testing.T) *sqlx.Tx {
+	db, err := database.Connect("sqlite3", path.Join(t.TempDir(), "admin.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.GetDB().Close() })
+	tx := db.GetDB().MustBegin()
+	t.Cleanup(func() { tx.Rollback() })
+	if err := InitUsers(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestPasswordPolicyRejectsEvents(t *testing.T) {
+	policy := testPolicy()
+	tx := setupUsersDatabase(t)
+
+	// New clients send the password
+	if _, err := policy.HandleAddedEvent(tx, &UserAddedEvent{Username: "bob", Password: "a"}); !database.IsRejected(err) {
+		t.Errorf("Expected a weak password to be rejected, got %v", err)
+	}
+	if _, err := policy.HandleAddedEvent(tx, &UserAddedEvent{Username: "bob", Password: "Correct-horse-7"}); err != nil {
+		t.Errorf("Expected a strong password to be accepted, got %v", err)
+	}
+
+	// Older clients send a hash, which must come from /api/hash_password
+	salt, passwordHash := HashPassword("a")
+	if _, err := policy.HandleAddedEvent(tx, &UserAddedEvent{Username: "bob", Salt: salt, PasswordHash: passwordHash}); !database.IsRejected(err) {
+		t.Errorf("Expected a hash computed by the client to be rejected, got %v", err)
+	}
+	salt, passwordHash = HashPassword("Correct-horse-7")
+	policy.Issue(salt, passwordHash)
+	if _, err := policy.HandleAddedEvent(tx, &UserAddedEvent{Username: "bob", Salt: salt, PasswordHash: passwordHash}); err != nil {
+		t.Errorf("Expected an issued hash to be accepted, got %v", err)
+	}
+
+	// The username is looked up for password changes
+	if _, err := policy.HandleUpdatePasswordEvent(tx, &UpdateUserPasswordEvent{UserID: 1, NewPassword: "admin-2024-pass"}); !database.IsRejected(err) {
+		t.Errorf("Expected a password containing the username to be rejected, got %v", err)
+	}
+	if _, err := policy.HandleUpdatePasswordEvent(tx, &UpdateUserPasswordEvent{UserID: 1, NewPassword: "Correct-horse-7"}); err != nil {
+		t.Errorf("Expected a strong password to be accepted, got %v", err)
+	}
+}
+
+func TestPasswordChangeRecordsTime(t *testing.T) {
+	tx := setupUsersDatabase(t)
+	var changedAt *time.Time
+	tx.Get(&changedAt, `SELECT password_changed_at FROM users_v1 WHERE id = 1`)
+	if changedAt != nil {
+		t.Fatalf("Expected the initial admin password to have no change time, got %v", changedAt)
+	}
+
+	if _, err := UsersHandleUpdatePasswordEvent(tx, &UpdateUserPasswordEvent{UserID: 1, NewPassword: "Correct-horse-7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Get(&changedAt, `SELECT password_changed_at FROM users_v1 WHERE id = 1`); err != nil || changedAt == nil || time.Since(*changedAt) > time.Minute {
+		t.Errorf("Expected the change time to be recorded, got %v: %v", changedAt, err)
+	}
+}
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
+)
+
+// FlagGrant is a feature flag whose allow or deny list names the user.
+type FlagGrant struct {
+	InstanceID string `json:"instanceId"`
+	Name       string `json:"name"`
+	Allowed    bool   `json:"allowed"`
+	Denied     bool   `json:"denied"`
+}
+
+// UserData is the Admin app's part of a user data export: the user's
+// profile and access grants. Every user may access every application, so the
+// only grants are feature flag allow and deny lists. Credentials are never
+// exported.
+type UserData struct {
+	Profile    *User       `json:"profile"`
+	FlagGrants []FlagGrant `json:"flagGrants"`
+}
+
+// UsersPersonalData marks the personal data in user events, so that it can
+// be crypto-shredded when a user is forgotten.
+var UsersPersonalData = map[string]database.PersonalData{
+	UserAddedEventType: {
+		Fields:  []string{"username", "password", "salt", "passwordHash"},
+		Subject: userAddedSubject,
+	},
+	UpdateUserPasswordEventType: {Fields: []string{"newPassword"}, UserIDField: "userId"},
+	UpdateUserEventType:         {Fields: []string{"username"}, UserIDField: "userId"},
+}
+
+// userAddedSubject returns the ID assigned to the user added by a User:Add
+// event.
+func userAddedSubject(tx *sqlx.Tx, eventData []byte) (int, error) {
+	var event UserAddedEvent
+	if err := json.Unmarshal(eventData, &event); err != nil {
+		return 0, err
+	}
+	var userID int
+	err := database.Rows(UsersTable, "id").Where("username = $1", event.Username).Get(tx, &userID)
+	return userID, err
+}
+
+// ExtractUserData returns the user's profile and access grants. Deleted
+// users that have not been purged yet are exported too.
+func ExtractUserData(db *sqlx.DB, userID int) (any, error) {
+	data := UserData{FlagGrants: []FlagGrant{}}
+	var user User
+	err := database.Rows(UsersTable, "id, username, deleted_at").Where("id = $1", userID).WithDeleted().Get(db, &user)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to load user %d: %w", userID, err)
+	}
+	if err == nil {
+		data.Profile = &user
+	}
+
+	flags, err := GetFeatureFlags(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, flag := range flags {
+		allowed, denied := slices.Contains(flag.Allow, userID), slices.Contains(flag.Deny, userID)
+		if allowed || denied {
+			data.FlagGrants = append(data.FlagGrants, FlagGrant{
+				InstanceID: flag.InstanceID,
+				Name:       flag.Name,
+				Allowed:    allowed,
+				Denied:     denied,
+			})
+		}
+	}
+	return data, nil
+}
+
+// ResetProjections empties the tables built from events before they are
+// rebuilt from the event log. The admin user is created at startup rather
+// than by an event, so it is kept; its password is set again by replaying
+// its User:UpdatePassword events. IDs are assigned again from 2 in the same
+// order as the first time.
+func ResetProjections(tx *sqlx.Tx) error {
+	for _, statement := range []string{
+		`DELETE FROM users_v1 WHERE id != 1`,
+		`DELETE FROM sqlite_sequence WHERE name = 'users_v1'`,
+		`DELETE FROM admin_feature_flags_v1`,
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to reset projections: %w", err)
+		}
+	}
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/database"
 )
 
 type User struct {
@@ -14,16 +16,30 @@ type User struct {
 	Username     string `db:"username" json:"username"`
 	Salt         string `db:"salt" json:"-"`
 	PasswordHash string `db:"password_hash" json:"-"`
+	// PasswordChangedAt is when the password was last set, or nil if unknown
+	PasswordChangedAt *time.Time `db:"password_changed_at" json:"-"`
+	// DeletedAt is when the user was deleted, for users that can still be
+	// restored
+	DeletedAt *time.Time `db:"deleted_at" json:"deletedAt,omitempty"`
 }
 
 const UserAddedEventType string = "User:Add"
 const UpdateUserPasswordEventType string = "User:UpdatePassword"
 const DeleteUserEventType string = "User:Delete"
 const UpdateUserEventType string = "User:Update"
+const RestoreUserEventType string = "User:Restore"
 
+// UsersTable is the users table. Deleted users are soft-deleted, so that a
+// User:Restore event can bring them back until they are purged.
+const UsersTable = "users_v1"
+
+// UserAddedEvent adds a user. Clients send the password, which is hashed
+// when the event is applied; older clients send a salt and hash computed by
+// /api/hash_password instead.
 type UserAddedEvent struct {
 	Username     string `json:"username"`
-	Salt         string `json:"salt"`
+	Password     string `json:"password,omitempty"`
+	Salt         string `json:"salt,omitempty"`
 	PasswordHash string `json:"passwordHash,omitempty"`
 }
 
@@ -41,46 +57,90 @@ type UpdateUserEvent struct {
 	Username string `json:"username"`
 }
 
+// RestoreUserEvent undoes a User:Delete event.
+type RestoreUserEvent struct {
+	UserID int `json:"userId"`
+}
+
+// UsersMigrations upgrade the users table created by older versions.
+var UsersMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "Record when each user's password was last changed",
+		Apply: func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE users_v1 ADD COLUMN password_changed_at TIMESTAMP`)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "Soft-delete users, so that usernames are only unique among live users",
+		Apply: func(tx *sqlx.Tx) error {
+			// SQLite cannot drop the UNIQUE constraint, so the table is
+			// rebuilt, keeping its AUTOINCREMENT sequence
+			if err := createUsersTable(tx, "users_v1_new"); err != nil {
+				return err
+			}
+			for _, statement := range []string{
+				`INSERT INTO users_v1_new (id, username, salt, password_hash, password_changed_at)
+				 SELECT id, username, salt, password_hash, password_changed_at FROM users_v1`,
+				`UPDATE sqlite_sequence SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'users_v1')
+				 WHERE name = 'users_v1_new'`,
+				`DROP TABLE users_v1`,
+				`ALTER TABLE users_v1_new RENAME TO users_v1`,
+			} {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			return createUsersIndexes(tx)
+		},
+	},
+}
+
 // -- DB Helpers --
 
+const userColumns = "id, username, salt, password_hash, password_changed_at"
+
 func GetUser(db *sqlx.DB, username string) (*User, error) {
 	var user User
-	err := db.Get(&user, "SELECT id, username, salt, password_hash FROM users_v1 WHERE username = $1", username)
+	err := database.Rows(UsersTable, userColumns).Where("username = $1", username).Get(db, &user)
 	return &user, err
 }
 
+// GetUserByID returns the user with the given ID, unless it is deleted.
+func GetUserByID(db *sqlx.DB, userID int) (*User, error) {
+	var user User
+	err := database.Rows(UsersTable, userColumns).Where("id = $1", userID).Get(db, &user)
+	return &user, err
+}
+
+// HashPassword hashes a password with a new random salt.
+func HashPassword(password string) (salt, passwordHash string) {
+	salt = uuid.New().String()
+	hasher := sha256.New()
+	hasher.Write([]byte(salt + password))
+	return salt, hex.EncodeToString(hasher.Sum(nil))
+}
+
 // -- Event handlers --
 
 func InitUsers(tx *sqlx.Tx) error {
 	// Generate a random salt for the admin user
-	salt := uuid.New().String()
-	hasher := sha256.New()
-	hasher.Write([]byte(salt + "admin"))
-	passwordHash := hex.EncodeToString(hasher.Sum(nil))
+	salt, passwordHash := HashPassword("admin")
 
-	// Create users table
-	_, err := tx.Exec(`
-		CREATE TABLE IF NOT EXISTS users_v1 (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			salt TEXT NOT NULL,
-			password_hash TEXT NOT NULL
-		)`)
-	if err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
+	if err := createUsersTable(tx, "users_v1"); err != nil {
+		return err
 	}
-
-	// Create indexes for better performance
-	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS idx_users_username ON users_v1(username)`)
-	if err != nil {
-		return fmt.Errorf("failed to create users username index: %w", err)
+	if err := createUsersIndexes(tx); err != nil {
+		return err
 	}
 
 	// Create admin user
-	_, err = tx.Exec(`
+	_, err := tx.Exec(`
 		INSERT INTO users_v1 (username, salt, password_hash)
 		SELECT 'admin', $1, $2
-		ON CONFLICT (username) DO NOTHING
+		WHERE NOT EXISTS (SELECT 1 FROM users_v1 WHERE username = 'admin')
 		`, salt, passwordHash)
 	if err != nil {
 		return fmt.Errorf("failed to create admin user: %w", err)
@@ -90,10 +150,44 @@ func InitUsers(tx *sqlx.Tx) error {
 	return nil
 }
 
+// createUsersTable creates the users table under the given name.
+func createUsersTable(tx *sqlx.Tx, name string) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + name + ` (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			salt TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			password_changed_at TIMESTAMP,
+			deleted_at TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create users table: %w", err)
+	}
+	return nil
+}
+
+// createUsersIndexes creates the users table's indexes. A deleted user's
+// name can be taken by a new user, after which the deleted user can no
+// longer be restored.
+func createUsersIndexes(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users_v1(username) WHERE deleted_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to create users username index: %w", err)
+	}
+	return nil
+}
+
 func UsersHandleAddedEvent(tx *sqlx.Tx, event *UserAddedEvent) (bool, error) {
 	fmt.Printf("Adding user: %s\n", event.Username)
-	_, err := tx.Exec(`INSERT INTO users_v1 (username, salt, password_hash) VALUES ($1, $2, $3)`,
-		event.Username, event.Salt, event.PasswordHash)
+	salt, passwordHash := event.Salt, event.PasswordHash
+	if event.Password != "" {
+		salt, passwordHash = HashPassword(event.Password)
+	}
+	// Replaying the event log sets the time again, restarting the password's
+	// age
+	_, err := tx.Exec(`INSERT INTO users_v1 (username, salt, password_hash, password_changed_at) VALUES ($1, $2, $3, $4)`,
+		event.Username, salt, passwordHash, time.Now().UTC())
 	if err != nil {
 		// Consider UNIQUE constraint violation etc.
 		return false, fmt.Errorf("failed to insert user %s: %w", event.Username, err)
@@ -105,13 +199,16 @@ func UsersHandleUpdatePasswordEvent(tx *sqlx.Tx, event *UpdateUserPasswordEvent)
 	fmt.Printf("Updating password for user ID: %d\n", event.UserID)
 
 	// Generate new salt and hash password
-	salt := uuid.New().String()
-	hasher := sha256.New()
-	hasher.Write([]byte(salt + event.NewPassword))
-	passwordHash := hex.EncodeToString(hasher.Sum(nil))
+	salt, passwordHash := HashPassword(event.NewPassword)
 
-	result, err := tx.Exec(`UPDATE users_v1 SET salt = $1, password_hash = $2 WHERE id = $3`,
-		salt, passwordHash, event.UserID)
+	// A forgotten user's password is a well-known tombstone, so leave an
+	// empty hash that no password matches
+	if database.IsRedacted(event.NewPassword) {
+		passwordHash = ""
+	}
+
+	result, err := tx.Exec(`UPDATE users_v1 SET salt = $1, password_hash = $2, password_changed_at = $3 WHERE id = $4 AND `+database.NotDeleted(UsersTable),
+		salt, passwordHash, time.Now().UTC(), event.UserID)
 	if err != nil {
 		return false, fmt.Errorf("failed to update password for user %d: %w", event.UserID, err)
 	}
@@ -136,28 +233,21 @@ func UsersHandleDeleteEvent(tx *sqlx.Tx, event *DeleteUserEvent) (bool, error) {
 		return false, fmt.Errorf("cannot delete admin user")
 	}
 
-	// Delete user access rules first (cascade delete)
-	_, err := tx.Exec(`DELETE FROM user_access_rules_v1 WHERE subject_type = 'USER' AND subject_id = $1`,
-		fmt.Sprintf("%d", event.UserID))
-	if err != nil {
-		return false, fmt.Errorf("failed to delete user access rules for user %d: %w", event.UserID, err)
-	}
-
-	// Delete the user
-	result, err := tx.Exec(`DELETE FROM users_v1 WHERE id = $1`, event.UserID)
-	if err != nil {
+	// The user is only marked deleted until it is purged, so it can be
+	// restored
+	if err := database.SoftDeleteRow(tx, UsersTable, int64(event.UserID)); err != nil {
 		return false, fmt.Errorf("failed to delete user %d: %w", event.UserID, err)
 	}
+	return true, nil
+}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("failed to get affected rows: %w", err)
-	}
+func UsersHandleRestoreEvent(tx *sqlx.Tx, event *RestoreUserEvent) (bool, error) {
+	fmt.Printf("Restoring user ID: %d\n", event.UserID)
 
-	if rowsAffected == 0 {
-		return false, fmt.Errorf("no user found with ID %d", event.UserID)
+	// Fails on the unique index if the username was taken in the meantime
+	if err := database.RestoreRow(tx, UsersTable, int64(event.UserID)); err != nil {
+		return false, fmt.Errorf("failed to restore user %d: %w", event.UserID, err)
 	}
-
 	return true, nil
 }
 
@@ -169,7 +259,7 @@ func UsersHandleUpdateEvent(tx *sqlx.Tx, event *UpdateUserEvent) (bool, error) {
 		return false, fmt.Errorf("cannot change username of admin user")
 	}
 
-	result, err := tx.Exec(`UPDATE users_v1 SET username = $1 WHERE id = $2`,
+	result, err := tx.Exec(`UPDATE users_v1 SET username = $1 WHERE id = $2 AND `+database.NotDeleted(UsersTable),
 		event.Username, event.UserID)
 	if err != nil {
 		return false, fmt.Errorf("failed to update user %d: %w", event.UserID, err)
@@ -189,12 +279,40 @@ func UsersHandleUpdateEvent(tx *sqlx.Tx, event *UpdateUserEvent) (bool, error) {
 
 // -- Getters --
 
+// UsersData is the response of the /api/users data view.
+type UsersData struct {
+	Users []User `json:"users"`
+}
+
 func GetUsers(db *sqlx.DB) ([]User, error) {
 	ret := []User{}
-	err := db.Select(&ret, "SELECT id, username FROM users_v1")
+	err := database.Rows(UsersTable, "id, username").Select(db, &ret)
 	if err != nil {
 		return ret, fmt.Errorf("failed to select all users: %v", err)
 	}
 
 	return ret, nil
 }
+
+// StreamUsers calls fn with each user in ID order, reading them from the
+// database one at a time instead of loading the whole list.
+func StreamUsers(db *sqlx.DB, fn func(User) error) error {
+	rows, err := database.Rows(UsersTable, "id, username").OrderBy("id").Query(db)
+	if err != nil {
+		return fmt.Errorf("failed to select all users: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user User
+		if err := rows.StructScan(&user); err != nil {
+			return fmt.Errorf("failed to read user: %v", err)
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to select all users: %v", err)
+	}
+	return nil
+}
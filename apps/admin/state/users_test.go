@@ -0,0 +1,120 @@
+package state
+
+import (
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/applib/database"
+)
+
+func TestDeleteAndRestoreUser(t *testing.T) {
+	db, err := database.Connect("sqlite3", path.Join(t.TempDir(), "admin.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.GetDB().Close() })
+	db.RegisterSoftDeleteTable(UsersTable, "deleted_at")
+	tx := db.GetDB().MustBegin()
+	defer tx.Rollback()
+	if err := InitUsers(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitFeatureFlags(tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UsersHandleAddedEvent(tx, &UserAddedEvent{Username: "bob", Password: "Correct-horse-7"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UsersHandleDeleteEvent(tx, &DeleteUserEvent{UserID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetUser(db.GetDB(), "bob"); err == nil {
+		t.Error("Expected a deleted user not to be able to log in")
+	}
+	if users, _ := GetUsers(db.GetDB()); len(users) != 1 {
+		t.Errorf("Expected the deleted user not to be listed, got %+v", users)
+	}
+	if data, err := ExtractUserData(db.GetDB(), 2); err != nil || data.(UserData).Profile.DeletedAt == nil {
+		t.Errorf("Expected the deleted user to be exported, got %+v: %v", data, err)
+	}
+
+	tx = db.GetDB().MustBegin()
+	defer tx.Rollback()
+	if _, err := UsersHandleUpdateEvent(tx, &UpdateUserEvent{UserID: 2, Username: "robert"}); err == nil {
+		t.Error("Expected a deleted user not to be updated")
+	}
+	if _, err := UsersHandleRestoreEvent(tx, &RestoreUserEvent{UserID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	var username string
+	if err := database.Rows(UsersTable, "username").Where("id = $1", 2).Get(tx, &username); err != nil || username != "bob" {
+		t.Errorf("Expected the user to be restored, got %q: %v", username, err)
+	}
+	if _, err := UsersHandleRestoreEvent(tx, &RestoreUserEvent{UserID: 2}); !errors.Is(err, database.ErrRowNotFound) {
+		t.Errorf("Expected restoring a live user to fail, got %v", err)
+	}
+
+	// Once deleted, the username can be reused, after which the old user
+	// cannot be restored
+	if _, err := UsersHandleDeleteEvent(tx, &DeleteUserEvent{UserID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UsersHandleAddedEvent(tx, &UserAddedEvent{Username: "bob", Password: "Correct-horse-7"}); err != nil {
+		t.Fatalf("Expected the username of a deleted user to be reusable, got %v", err)
+	}
+	if _, err := UsersHandleRestoreEvent(tx, &RestoreUserEvent{UserID: 2}); err == nil {
+		t.Error("Expected restoring a user whose name was taken to fail")
+	}
+}
+
+func TestUsersSoftDeleteMigration(t *testing.T) {
+	db, err := database.Connect("sqlite3", path.Join(t.TempDir(), "admin.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.GetDB().Close() })
+	tx := db.GetDB().MustBegin()
+	defer tx.Rollback()
+
+	// The table as created by schema version 1
+	tx.MustExec(`
+		CREATE TABLE users_v1 (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			salt TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			password_changed_at TIMESTAMP
+		)`)
+	tx.MustExec(`CREATE INDEX idx_users_username ON users_v1(username)`)
+	tx.MustExec(`INSERT INTO users_v1 (username, salt, password_hash) VALUES ('admin', 's', 'h'), ('alice', 's', 'h'), ('bob', 's', 'h')`)
+	tx.MustExec(`DELETE FROM users_v1 WHERE username = 'bob'`)
+
+	// Startup creates the tables before the migrations run
+	if err := InitUsers(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := UsersMigrations[1].Apply(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tx.Select(&names, `SELECT username FROM users_v1 ORDER BY id`)
+	if len(names) != 2 || names[1] != "alice" {
+		t.Errorf("Expected the users to be kept, got %v", names)
+	}
+	if _, err := UsersHandleAddedEvent(tx, &UserAddedEvent{Username: "carol", Password: "Correct-horse-7"}); err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	if err := tx.Get(&id, `SELECT id FROM users_v1 WHERE username = 'carol'`); err != nil || id != 4 {
+		t.Errorf("Expected IDs not to be reused, got %d: %v", id, err)
+	}
+	if _, err := UsersHandleAddedEvent(tx, &UserAddedEvent{Username: "alice", Password: "Correct-horse-7"}); err == nil {
+		t.Error("Expected usernames of live users to stay unique")
+	}
+}
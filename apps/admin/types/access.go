@@ -1,9 +1,12 @@
 package types
 
 type AccessRequest struct {
-	UserID int
+	UserID int `validate:"required,min=1"`
 }
 
 type AccessResponse struct {
 	AccessGranted bool
+	// MustChangePassword restricts the access token to changing the user's
+	// password, see AdminLoginResponse.MustChange.
+	MustChangePassword bool
 }
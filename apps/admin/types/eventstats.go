@@ -0,0 +1,28 @@
+package types
+
+// EventStats mirrors the hub's /events/stats response, see
+// nexushub/events.EventStats.
+type EventStats struct {
+	TotalEvents int64                `json:"totalEvents"`
+	Types       []EventTypeStats     `json:"types"`
+	Days        []EventDayStats      `json:"days"`
+	Instances   []InstanceEventStats `json:"instances"`
+}
+
+type EventTypeStats struct {
+	Type                string  `json:"type"`
+	Count               int64   `json:"count"`
+	PayloadBytes        int64   `json:"payloadBytes"`
+	AveragePayloadBytes float64 `json:"averagePayloadBytes"`
+}
+
+type EventDayStats struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+type InstanceEventStats struct {
+	InstanceID string `json:"instanceId"`
+	Name       string `json:"name"`
+	Count      int64  `json:"count"`
+}
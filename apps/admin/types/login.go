@@ -1,11 +1,14 @@
 package types
 
 type AdminLoginRequest struct {
-	Username string
+	Username string `validate:"required,min=1"`
 	Password string
 }
 
 type AdminLoginResponse struct {
 	Success bool
 	UserID  int
+	// MustChange is set when the password is past its maximum age. The hub
+	// then only lets the session change the password.
+	MustChange bool
 }
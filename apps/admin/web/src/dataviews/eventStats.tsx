@@ -0,0 +1,34 @@
+import { useDataView } from "@tomyedwab/yesterday";
+
+export type EventTypeStats = {
+  type: string;
+  count: number;
+  payloadBytes: number;
+  averagePayloadBytes: number;
+};
+
+export type EventDayStats = {
+  day: string;
+  count: number;
+};
+
+export type InstanceEventStats = {
+  instanceId: string;
+  name: string;
+  count: number;
+};
+
+export type EventStats = {
+  totalEvents: number;
+  types: EventTypeStats[];
+  days: EventDayStats[];
+  instances: InstanceEventStats[];
+};
+
+export function useEventStatsView(): [boolean, EventStats | null] {
+  const [loading, response] = useDataView("api/event_stats");
+  if (loading || response === null) {
+    return [true, null];
+  }
+  return [false, response];
+}
@@ -4,12 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"slices"
 )
 
 // LoginRequest represents the login request payload
@@ -22,9 +19,20 @@ type LoginRequest struct {
 type AccessTokenResponse struct {
 	AccessToken string `json:"access_token"`
 	Error       string `json:"error"`
+	// MustChange is set when the access token may only be used to change
+	// the user's expired password
+	MustChange bool `json:"must_change"`
 }
 
-// Login authenticates the user with username and password
+// loginResponse is the body of a login response asking the user to change
+// their password. Other successful logins respond with "ok".
+type loginResponse struct {
+	MustChange bool `json:"must_change"`
+}
+
+// Login authenticates the user with username and password. If the password
+// has expired it returns a *PasswordExpiredError, leaving the client logged
+// in with an access token that may only change the password.
 func (c *Client) Login(ctx context.Context, username, password string) error {
 	if username == "" || password == "" {
 		return NewValidationError("username and password are required")
@@ -40,10 +48,13 @@ func (c *Client) Login(ctx context.Context, username, password string) error {
 		return NewErrorWithCause(ErrorTypeValidation, "failed to marshal login request", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/public/login", bytes.NewBuffer(jsonData))
+	reqCtx, release := c.requestContext(ctx)
+	defer release()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", c.GetBaseURL()+"/public/login", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return NewErrorWithCause(ErrorTypeNetwork, "failed to create login request", err)
 	}
+	c.applyDefaultHeaders(req)
 
 	req.Header.Set("Content-Type", "application/json")
 
@@ -75,21 +86,39 @@ func (c *Client) Login(ctx context.Context, username, password string) error {
 		return NewErrorWithCause(ErrorTypeNetwork, "failed to store refresh token", err)
 	}
 
+	var loginResp loginResponse
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		// Not JSON for an ordinary login
+		json.Unmarshal(body, &loginResp)
+	}
+
 	// Try to get access token immediately
 	if err := c.RefreshAccessToken(ctx); err != nil {
 		// Don't fail login if access token refresh fails - we have the refresh token stored
 		// The next API call will trigger another refresh attempt
 	}
 
+	if loginResp.MustChange {
+		return &PasswordExpiredError{}
+	}
 	return nil
 }
 
-// Logout terminates the current session
+// Logout terminates the current session. Sessions on other devices are
+// unaffected, see LogoutAll.
 func (c *Client) Logout(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/public/logout", nil)
+	reqCtx, release := c.requestContext(ctx)
+	defer release()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", c.GetBaseURL()+"/public/logout", nil)
 	if err != nil {
 		return NewErrorWithCause(ErrorTypeNetwork, "failed to create logout request", err)
 	}
+	c.applyDefaultHeaders(req)
+
+	// Send the access token so the hub revokes it along with the session
+	if token := c.getAccessToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	// Add refresh token as cookie if we have it
 	if refreshToken, err := c.loadRefreshToken(); err == nil && refreshToken != "" {
@@ -116,8 +145,99 @@ func (c *Client) Logout(ctx context.Context) error {
 	return nil
 }
 
-// RefreshAccessToken refreshes the access token using the stored refresh token
+// LogoutAllResponse is the response to a LogoutAll request
+type LogoutAllResponse struct {
+	// SessionsRevoked is the number of sessions that were ended
+	SessionsRevoked int `json:"sessions_revoked"`
+}
+
+// LogoutAll ends every session of the user on all devices, revoking all of
+// their refresh and access tokens, e.g. after a suspected credential
+// compromise. It returns the number of sessions that were ended.
+//
+// The stored tokens are cleared once the hub has ended the sessions or
+// rejected the refresh token. If the request fails otherwise they are kept
+// so that LogoutAll can be retried.
+func (c *Client) LogoutAll(ctx context.Context) (int, error) {
+	refreshToken, err := c.loadRefreshToken()
+	if err != nil {
+		return 0, NewErrorWithCause(ErrorTypeAuthentication, "failed to load refresh token", err)
+	}
+	if refreshToken == "" {
+		return 0, NewAuthenticationError("no refresh token available")
+	}
+
+	reqCtx, release := c.requestContext(ctx)
+	defer release()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", c.GetBaseURL()+"/public/logout_all", nil)
+	if err != nil {
+		return 0, NewErrorWithCause(ErrorTypeNetwork, "failed to create logout request", err)
+	}
+	c.applyDefaultHeaders(req)
+	req.AddCookie(&http.Cookie{
+		Name:  "YRT",
+		Value: refreshToken,
+	})
+
+	resp, err := c.GetHTTPClient().Do(req)
+	if err != nil {
+		return 0, NewNetworkError("logout request failed", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return 0, WrapHTTPError(resp, "logout failed")
+	}
+
+	// The sessions are gone, or the refresh token was no good anyway
+	c.clearAccessToken()
+	c.clearRefreshToken()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, WrapHTTPError(resp, "logout failed")
+	}
+
+	var logoutResp LogoutAllResponse
+	if err := json.NewDecoder(resp.Body).Decode(&logoutResp); err != nil {
+		return 0, NewErrorWithCause(ErrorTypeAPI, "failed to decode logout response", err)
+	}
+	return logoutResp.SessionsRevoked, nil
+}
+
+// RefreshAccessToken refreshes the access token using the stored refresh token.
+// It returns a *PasswordExpiredError along with a restricted access token if
+// the user's password has expired. If the hub rejects the refresh token the
+// access token is cleared and the OnRefreshFailure handlers are called.
 func (c *Client) RefreshAccessToken(ctx context.Context) error {
+	err := c.refreshAccessToken(ctx)
+	if IsAuthenticationError(err) {
+		c.clearAccessToken()
+		c.notifyRefreshFailure(err)
+	}
+	return err
+}
+
+// OnRefreshFailure registers a handler that is called whenever a token refresh
+// fails because the session is no longer valid, e.g. the refresh token has
+// expired or was revoked, so the user has to log in again. Network and server
+// errors do not call it. Handlers run on the goroutine that attempted the
+// refresh and must not block.
+func (c *Client) OnRefreshFailure(handler func(err error)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.refreshHandlers = append(c.refreshHandlers, handler)
+}
+
+func (c *Client) notifyRefreshFailure(err error) {
+	c.handlersMu.Lock()
+	handlers := slices.Clone(c.refreshHandlers)
+	c.handlersMu.Unlock()
+	for _, handler := range handlers {
+		handler(err)
+	}
+}
+
+func (c *Client) refreshAccessToken(ctx context.Context) error {
 	refreshToken, err := c.loadRefreshToken()
 	if err != nil {
 		return NewErrorWithCause(ErrorTypeAuthentication, "failed to load refresh token", err)
@@ -127,10 +247,13 @@ func (c *Client) RefreshAccessToken(ctx context.Context) error {
 		return NewAuthenticationError("no refresh token available")
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/public/access_token", nil)
+	reqCtx, release := c.requestContext(ctx)
+	defer release()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", c.GetBaseURL()+"/public/access_token", nil)
 	if err != nil {
 		return NewErrorWithCause(ErrorTypeNetwork, "failed to create access token request", err)
 	}
+	c.applyDefaultHeaders(req)
 
 	// Add refresh token as YRT cookie
 	req.AddCookie(&http.Cookie{
@@ -148,6 +271,21 @@ func (c *Client) RefreshAccessToken(ctx context.Context) error {
 		return WrapHTTPError(resp, "failed to refresh access token")
 	}
 
+	// The server has rotated the refresh token and will not accept the old
+	// one again, so keep the new one before anything else can fail
+	var newRefreshToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "YRT" {
+			newRefreshToken = cookie.Value
+			break
+		}
+	}
+	if newRefreshToken != "" {
+		if err := c.storeRefreshToken(newRefreshToken); err != nil {
+			c.log.Printf("failed to save rotated refresh token, keeping it in memory: %v", err)
+		}
+	}
+
 	// Parse response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -167,22 +305,16 @@ func (c *Client) RefreshAccessToken(ctx context.Context) error {
 		return NewAuthenticationError("empty access token received")
 	}
 
-	// Extract refresh token from YRT cookie
-	var newRefreshToken string
-	for _, cookie := range resp.Cookies() {
-		if cookie.Name == "YRT" {
-			newRefreshToken = cookie.Value
-			break
-		}
-	}
-
 	if newRefreshToken == "" {
 		return NewAuthenticationError("no refresh token received")
 	}
 
 	// Store access token in memory
 	c.setAccessToken(tokenResp.AccessToken)
-	c.storeRefreshToken(newRefreshToken)
+
+	if tokenResp.MustChange {
+		return &PasswordExpiredError{}
+	}
 
 	return nil
 }
@@ -192,36 +324,39 @@ func (c *Client) IsAuthenticated() bool {
 	return c.getAccessToken() != ""
 }
 
-// storeRefreshToken stores the refresh token to the configured path
+// storeRefreshToken saves the refresh token to the token store. If saving
+// fails the token is kept in memory, where loadRefreshToken finds it, so a
+// token the server has rotated is not lost while the process runs.
 func (c *Client) storeRefreshToken(token string) error {
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(c.refreshTokenPath)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return fmt.Errorf("failed to create token directory: %w", err)
-	}
-
-	// Write token to file with restricted permissions
-	if err := os.WriteFile(c.refreshTokenPath, []byte(token), 0600); err != nil {
-		return fmt.Errorf("failed to write refresh token: %w", err)
+	err := c.tokenStore.Save(token)
+	c.mu.Lock()
+	if err != nil {
+		c.unsavedToken = token
+	} else {
+		c.unsavedToken = ""
 	}
-
-	return nil
+	c.mu.Unlock()
+	return err
 }
 
-// loadRefreshToken loads the refresh token from the configured path
+// loadRefreshToken loads the refresh token, preferring one the token store
+// failed to save
 func (c *Client) loadRefreshToken() (string, error) {
-	data, err := os.ReadFile(c.refreshTokenPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "", nil // No token file is not an error
-		}
-		return "", fmt.Errorf("failed to read refresh token: %w", err)
+	c.mu.RLock()
+	unsaved := c.unsavedToken
+	c.mu.RUnlock()
+	if unsaved != "" {
+		return unsaved, nil
 	}
-
-	return strings.TrimSpace(string(data)), nil
+	return c.tokenStore.Load()
 }
 
 // clearRefreshToken removes the stored refresh token
 func (c *Client) clearRefreshToken() {
-	os.Remove(c.refreshTokenPath)
+	c.mu.Lock()
+	c.unsavedToken = ""
+	c.mu.Unlock()
+	if err := c.tokenStore.Clear(); err != nil {
+		c.log.Printf("Failed to clear refresh token: %v", err)
+	}
 }
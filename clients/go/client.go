@@ -8,8 +8,10 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,12 +20,25 @@ import (
 type Client struct {
 	baseURL          string
 	httpClient       *http.Client
-	refreshTokenPath string
+	httpClientOnce   sync.Once // Creates httpClient if WithHTTPClient was not used
+	tokenStore       TokenStore
 	accessToken      string
-	mu               sync.RWMutex    // Protects accessToken
+	unsavedToken     string          // Rotated refresh token the token store failed to save
+	mu               sync.RWMutex    // Protects accessToken and unsavedToken
 	eventPoller      *EventPoller    // Event polling system
 	eventPublisher   *EventPublisher // Event publishing system
 	log              *log.Logger
+	baseCtx          context.Context // Inherited by every request, see WithBaseContext
+	defaultHeaders   map[string]string
+	introspections   introspectionCache // Results of IntrospectToken
+	fallbackURLs     []string           // See WithFallbackURLs
+	endpoints        endpointSet
+	failoverMu       sync.Mutex // Serializes switching endpoints
+	probeInterval    time.Duration
+	eventRegistry    *EventRegistry // See WithEventRegistry
+	defaultInstance  string         // See WithDefaultInstance
+	refreshHandlers  []func(error)  // See OnRefreshFailure
+	handlersMu       sync.Mutex     // Protects refreshHandlers
 }
 
 // ClientOption represents a functional option for configuring the Client
@@ -36,10 +51,28 @@ func WithHTTPClient(client *http.Client) ClientOption {
 	}
 }
 
-// WithRefreshTokenPath sets a custom path for storing the refresh token
+// WithRefreshTokenPath sets a custom path for storing the refresh token. It is
+// shorthand for WithTokenStore(NewFileTokenStore(path)).
 func WithRefreshTokenPath(path string) ClientOption {
+	return WithTokenStore(NewFileTokenStore(path))
+}
+
+// WithTokenStore sets where the refresh token is persisted
+func WithTokenStore(store TokenStore) ClientOption {
 	return func(c *Client) {
-		c.refreshTokenPath = path
+		c.tokenStore = store
+	}
+}
+
+// WithDefaultHeaders sets headers sent with every request, including those
+// made by the event poller and publisher. Headers passed to an individual
+// call override defaults with the same name.
+func WithDefaultHeaders(headers map[string]string) ClientOption {
+	return func(c *Client) {
+		c.defaultHeaders = make(map[string]string, len(headers))
+		for key, value := range headers {
+			c.defaultHeaders[key] = value
+		}
 	}
 }
 
@@ -49,6 +82,15 @@ func WithLogger(logger *log.Logger) ClientOption {
 	}
 }
 
+// WithDefaultInstance sets the application instance used by AppPath and
+// NewDataProvider when they are given an empty instance ID, so the ID only
+// has to be configured in one place.
+func WithDefaultInstance(instanceID string) ClientOption {
+	return func(c *Client) {
+		c.defaultInstance = instanceID
+	}
+}
+
 // NewClient creates a new Yesterday API client with the given base URL and options
 func NewClient(baseURL string, options ...ClientOption) *Client {
 	// Set default refresh token path
@@ -61,25 +103,59 @@ func NewClient(baseURL string, options ...ClientOption) *Client {
 	client := &Client{
 		baseURL:          baseURL,
 		httpClient:       nil,
-		refreshTokenPath: defaultRefreshTokenPath,
+		tokenStore:       NewFileTokenStore(defaultRefreshTokenPath),
 		log:              log.New(os.Stderr, "yesterday: ", log.LstdFlags),
+		introspections:   introspectionCache{ttl: DefaultIntrospectionCacheTTL},
+		endpoints:        endpointSet{sticky: true},
+		probeInterval:    DefaultHealthProbeInterval,
 	}
 
-	// Initialize event poller and publisher
-	client.eventPoller = NewEventPoller(client)
-	client.eventPublisher = NewEventPublisher(client)
-
 	// Apply options
 	for _, option := range options {
 		option(client)
 	}
 
+	for _, url := range append([]string{baseURL}, client.fallbackURLs...) {
+		client.endpoints.endpoints = append(client.endpoints.endpoints, &endpoint{url: url, healthy: true})
+	}
+	if len(client.endpoints.endpoints) > 1 && client.probeInterval > 0 {
+		go client.probeEndpoints()
+	}
+
+	// Initialize event poller and publisher once options such as the base
+	// context are in place, since both start background goroutines
+	client.eventPoller = NewEventPoller(client)
+	client.eventPublisher = NewEventPublisher(client)
+
 	return client
 }
 
-// GetBaseURL returns the client's base URL
+// GetBaseURL returns the base URL of the endpoint the client is using,
+// which differs from the URL passed to NewClient after a failover
 func (c *Client) GetBaseURL() string {
-	return c.baseURL
+	return c.endpoints.activeURL()
+}
+
+// DefaultInstance returns the instance ID set with WithDefaultInstance.
+func (c *Client) DefaultInstance() string {
+	return c.defaultInstance
+}
+
+// AppPath returns the path of apiPath, e.g. "api/users", on an application
+// instance, for use with Get, Post and the other request methods. An empty
+// instanceID selects the default instance; without either the path is
+// returned relative to the hub itself.
+//
+//	client.Get(ctx, client.AppPath(adminID, "api/users"), nil)
+func (c *Client) AppPath(instanceID, apiPath string) string {
+	if instanceID == "" {
+		instanceID = c.defaultInstance
+	}
+	apiPath = strings.TrimPrefix(apiPath, "/")
+	if instanceID == "" {
+		return "/" + apiPath
+	}
+	return "/" + url.PathEscape(instanceID) + "/" + apiPath
 }
 
 func (c *Client) Log() *log.Logger {
@@ -88,7 +164,10 @@ func (c *Client) Log() *log.Logger {
 
 // GetHTTPClient returns the underlying HTTP client
 func (c *Client) GetHTTPClient() *http.Client {
-	if c.httpClient == nil {
+	c.httpClientOnce.Do(func() {
+		if c.httpClient != nil {
+			return
+		}
 		// Configure TLS for localhost domains
 		tlsConfig, err := configureTLSForLocalhost(c.baseURL, c.log)
 		if err != nil {
@@ -96,17 +175,27 @@ func (c *Client) GetHTTPClient() *http.Client {
 		}
 
 		// Create HTTP client with optional TLS configuration
-		c.httpClient = &http.Client{Timeout: 60 * time.Second}
+		httpClient := &http.Client{Timeout: 60 * time.Second}
 		if tlsConfig != nil {
-			applyTLSConfigToClient(c.httpClient, tlsConfig)
+			applyTLSConfigToClient(httpClient, tlsConfig)
 		}
-	}
+		c.httpClient = httpClient
+	})
 	return c.httpClient
 }
 
-// GetRefreshTokenPath returns the path where refresh tokens are stored
+// GetRefreshTokenPath returns the path where refresh tokens are stored, or an
+// empty string if the token store is not file-based
 func (c *Client) GetRefreshTokenPath() string {
-	return c.refreshTokenPath
+	if store, ok := c.tokenStore.(*FileTokenStore); ok {
+		return store.Path
+	}
+	return ""
+}
+
+// GetTokenStore returns the store the refresh token is persisted in
+func (c *Client) GetTokenStore() TokenStore {
+	return c.tokenStore
 }
 
 // setAccessToken sets the access token in a thread-safe manner
@@ -130,48 +219,85 @@ func (c *Client) clearAccessToken() {
 	c.accessToken = ""
 }
 
+// applyDefaultHeaders sets the client's default headers on a request. Call it
+// before setting any per-call headers so that those take precedence.
+func (c *Client) applyDefaultHeaders(req *http.Request) {
+	for key, value := range c.defaultHeaders {
+		req.Header.Set(key, value)
+	}
+}
+
 // makeRequest performs an HTTP request with authentication headers
 func (c *Client) makeRequest(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
-	url := c.baseURL + path
-
-	var bodyReader io.Reader
+	var bodyBytes []byte
 	if body != nil {
-		bodyBytes, err := json.Marshal(body)
+		var err error
+		bodyBytes, err = json.Marshal(body)
 		if err != nil {
-			c.log.Printf("failed to marshal request body: %w", err)
+			c.log.Printf("failed to marshal request body: %v", err)
 			return nil, err
 		}
-		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
-	if err != nil {
-		c.log.Printf("failed to create request: %w", err)
-		return nil, err
-	}
+	return c.send(ctx, method, path, bodyBytes, func(req *http.Request) {
+		// Add custom headers
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
 
-	// Add authentication header if we have an access token
-	if token := c.getAccessToken(); token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
-	}
+		// Set default content type for JSON requests
+		if req.Header.Get("Content-Type") == "" && (method == "POST" || method == "PUT" || method == "PATCH") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	})
+}
 
-	// Add custom headers
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
+// send sends a request to the endpoint in use with the default headers and
+// the access token, then lets setHeaders add its own. If the endpoint turns
+// out to be down the request is retried on the next healthy endpoint when
+// that is safe, see WithFallbackURLs.
+func (c *Client) send(ctx context.Context, method, path string, body []byte, setHeaders func(req *http.Request)) (*http.Response, error) {
+	attempts := len(c.endpoints.urls())
+	for attempt := 1; ; attempt++ {
+		baseURL := c.selectEndpoint(ctx)
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
 
-	// Set default content type for JSON requests
-	if req.Header.Get("Content-Type") == "" && (method == "POST" || method == "PUT" || method == "PATCH") {
-		req.Header.Set("Content-Type", "application/json")
-	}
+		reqCtx, release := c.requestContext(ctx)
+		req, err := http.NewRequestWithContext(reqCtx, method, baseURL+path, bodyReader)
+		if err != nil {
+			release()
+			c.log.Printf("failed to create request: %v", err)
+			return nil, err
+		}
 
-	resp, err := c.GetHTTPClient().Do(req)
-	if err != nil {
-		c.log.Printf("request failed: %w", err)
-		return nil, err
-	}
+		c.applyDefaultHeaders(req)
 
-	return resp, nil
+		// Add authentication header if we have an access token
+		if token := c.getAccessToken(); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		setHeaders(req)
+
+		resp, err := c.doWithRelease(req, release)
+		failed := isEndpointFailure(reqCtx, resp, err)
+		c.endpoints.report(baseURL, !failed)
+		if failed && attempt < attempts && isRetryable(req) && c.endpoints.hasHealthyAlternative(baseURL) {
+			if err == nil {
+				resp.Body.Close()
+			}
+			c.log.Printf("%s is unavailable, retrying %s %s on another endpoint", baseURL, method, path)
+			continue
+		}
+		if err != nil {
+			c.log.Printf("request failed: %v", err)
+			return nil, err
+		}
+		return resp, nil
+	}
 }
 
 // Get performs a GET request to the specified path
@@ -202,7 +328,7 @@ func (c *Client) PostMultipart(ctx context.Context, path string, fields map[stri
 	// Add form fields
 	for key, value := range fields {
 		if err := writer.WriteField(key, value); err != nil {
-			c.log.Printf("failed to write field %s: %w", key, err)
+			c.log.Printf("failed to write field %s: %v", key, err)
 			return nil, err
 		}
 	}
@@ -211,47 +337,29 @@ func (c *Client) PostMultipart(ctx context.Context, path string, fields map[stri
 	for key, data := range files {
 		part, err := writer.CreateFormFile(key, key)
 		if err != nil {
-			c.log.Printf("failed to create form file %s: %w", key, err)
+			c.log.Printf("failed to create form file %s: %v", key, err)
 			return nil, err
 		}
 		if _, err := part.Write(data); err != nil {
-			c.log.Printf("failed to write file data for %s: %w", key, err)
+			c.log.Printf("failed to write file data for %s: %v", key, err)
 			return nil, err
 		}
 	}
 
 	writer.Close()
 
-	// Create request
-	url := c.baseURL + path
-	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
-	if err != nil {
-		c.log.Printf("failed to create request: %w", err)
-		return nil, err
-	}
+	contentType := writer.FormDataContentType()
+	return c.send(ctx, "POST", path, body.Bytes(), func(req *http.Request) {
+		// Set multipart content type
+		req.Header.Set("Content-Type", contentType)
 
-	// Add authentication header if we have an access token
-	if token := c.getAccessToken(); token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
-	}
-
-	// Set multipart content type
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	// Add custom headers (but don't override Content-Type)
-	for key, value := range headers {
-		if key != "Content-Type" {
-			req.Header.Set(key, value)
+		// Add custom headers (but don't override Content-Type)
+		for key, value := range headers {
+			if key != "Content-Type" {
+				req.Header.Set(key, value)
+			}
 		}
-	}
-
-	resp, err := c.GetHTTPClient().Do(req)
-	if err != nil {
-		c.log.Printf("request failed: %w", err)
-		return nil, err
-	}
-
-	return resp, nil
+	})
 }
 
 // Initialize performs initial setup including token refresh
@@ -260,7 +368,7 @@ func (c *Client) Initialize(ctx context.Context) error {
 	if err := c.RefreshAccessToken(ctx); err != nil {
 		// Log the error but don't fail initialization - user can still login
 		// In a real implementation, you might want to use a proper logger here
-		c.log.Printf("failed to refresh access token during initialization: %w", err)
+		c.log.Printf("failed to refresh access token during initialization: %v", err)
 		return err
 	}
 	return nil
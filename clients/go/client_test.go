@@ -0,0 +1,236 @@
+package yesterdaygo
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithDefaultHeaders(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	defaults := map[string]string{"X-Client-Version": "1.2.3", "X-Tenant": "default"}
+	client := NewClient(server.URL,
+		WithDefaultHeaders(defaults),
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	// Mutating the caller's map after construction has no effect
+	defaults["X-Tenant"] = "mutated"
+
+	ctx := context.Background()
+	resp, err := client.Get(ctx, "/get", map[string]string{"X-Tenant": "override"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = client.PostMultipart(ctx, "/multipart", map[string]string{"field": "value"}, nil, nil)
+	if err != nil {
+		t.Fatalf("PostMultipart failed: %v", err)
+	}
+	resp.Body.Close()
+	client.GetEventPublisher().PublishEvent("event-1", map[string]string{"type": "Test"})
+	if err := client.GetEventPublisher().FlushEvents(5 * time.Second); err != nil {
+		t.Fatalf("FlushEvents failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[string]string{
+		"/get":            "override",
+		"/multipart":      "default",
+		"/events/publish": "default",
+	}
+	for path, tenant := range expected {
+		header, ok := received[path]
+		if !ok {
+			t.Errorf("No request received for %s", path)
+			continue
+		}
+		if got := header.Get("X-Client-Version"); got != "1.2.3" {
+			t.Errorf("%s: expected X-Client-Version 1.2.3, got %q", path, got)
+		}
+		if got := header.Get("X-Tenant"); got != tenant {
+			t.Errorf("%s: expected X-Tenant %q, got %q", path, tenant, got)
+		}
+	}
+}
+
+func TestLoginWithExpiredPassword(t *testing.T) {
+	var mustChange atomic.Bool
+	mustChange.Store(true)
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh"})
+		switch r.URL.Path {
+		case "/public/login":
+			w.Write([]byte(`{"must_change":true}`))
+		case "/public/access_token":
+			if mustChange.Load() {
+				w.Write([]byte(`{"access_token":"restricted","must_change":true}`))
+			} else {
+				w.Write([]byte(`{"access_token":"full","must_change":false}`))
+			}
+		}
+	})
+
+	err := client.Login(context.Background(), "alice", "old-password")
+	if !IsPasswordExpiredError(err) {
+		t.Fatalf("Expected a PasswordExpiredError, got %v", err)
+	}
+	// The restricted token is kept so that the password can be changed
+	if !client.IsAuthenticated() {
+		t.Error("Expected the client to hold the restricted access token")
+	}
+
+	mustChange.Store(false)
+	if err := client.RefreshAccessToken(context.Background()); err != nil {
+		t.Errorf("Expected a full access token once the password is changed, got %v", err)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/public/login":
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh"})
+		case "/public/access_token":
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh"})
+			w.Write([]byte(`{"access_token":"access"}`))
+		case "/public/logout_all":
+			if failing.Load() {
+				http.Error(w, "database is locked", http.StatusInternalServerError)
+				return
+			}
+			if cookie, err := r.Cookie("YRT"); err != nil || cookie.Value != "refresh" {
+				http.Error(w, "invalid refresh token", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"sessions_revoked":3}`))
+		}
+	})
+
+	ctx := context.Background()
+	if err := client.Login(ctx, "alice", "password"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// A failed request keeps the tokens so that it can be retried
+	if _, err := client.LogoutAll(ctx); err == nil {
+		t.Fatal("Expected an error when the hub fails")
+	}
+	if !client.IsAuthenticated() {
+		t.Fatal("Expected the tokens to be kept after a failed request")
+	}
+
+	failing.Store(false)
+	revoked, err := client.LogoutAll(ctx)
+	if err != nil {
+		t.Fatalf("LogoutAll failed: %v", err)
+	}
+	if revoked != 3 {
+		t.Errorf("Expected 3 sessions revoked, got %d", revoked)
+	}
+	if client.IsAuthenticated() {
+		t.Error("Expected the access token to be cleared")
+	}
+	if token, _ := client.loadRefreshToken(); token != "" {
+		t.Errorf("Expected the refresh token to be cleared, got %q", token)
+	}
+}
+
+func TestOnRefreshFailure(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh"})
+		switch r.URL.Path {
+		case "/public/access_token":
+			if code := int(status.Load()); code != http.StatusOK {
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+			w.Write([]byte(`{"access_token":"access"}`))
+		}
+	})
+
+	var failures []error
+	client.OnRefreshFailure(func(err error) {
+		failures = append(failures, err)
+	})
+
+	ctx := context.Background()
+	if err := client.Login(ctx, "alice", "password"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// A server error does not mean the session has ended
+	status.Store(http.StatusServiceUnavailable)
+	if err := client.RefreshAccessToken(ctx); err == nil {
+		t.Fatal("Expected an error when the hub is unavailable")
+	}
+	if len(failures) != 0 {
+		t.Fatalf("Expected no refresh failures, got %v", failures)
+	}
+	if !client.IsAuthenticated() {
+		t.Fatal("Expected the access token to be kept after a server error")
+	}
+
+	status.Store(http.StatusUnauthorized)
+	err := client.RefreshAccessToken(ctx)
+	if !IsAuthenticationError(err) {
+		t.Fatalf("Expected an authentication error, got %v", err)
+	}
+	if len(failures) != 1 || failures[0] != err {
+		t.Errorf("Expected the handler to be called with %v, got %v", err, failures)
+	}
+	if client.IsAuthenticated() {
+		t.Error("Expected the access token to be cleared")
+	}
+}
+
+func TestAppPath(t *testing.T) {
+	client := NewClient("https://hub.example", WithDefaultInstance("MBtskI6D"))
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	for _, tc := range []struct {
+		instanceID, apiPath, expected string
+	}{
+		{"", "api/users", "/MBtskI6D/api/users"},
+		{"", "/api/users", "/MBtskI6D/api/users"},
+		{"tasks", "api/lists", "/tasks/api/lists"},
+		{"a b", "api/lists", "/a%20b/api/lists"},
+	} {
+		if path := client.AppPath(tc.instanceID, tc.apiPath); path != tc.expected {
+			t.Errorf("AppPath(%q, %q): expected %q, got %q", tc.instanceID, tc.apiPath, tc.expected, path)
+		}
+	}
+
+	// Without a default instance paths are relative to the hub
+	hubClient := NewClient("https://hub.example")
+	defer hubClient.GetEventPoller().StopEventPolling()
+	defer hubClient.GetEventPublisher().Stop()
+	if path := hubClient.AppPath("", "apps/list"); path != "/apps/list" {
+		t.Errorf("Expected a hub path, got %q", path)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// application is an installed application instance as listed by the hub's
+// /apps/list endpoint.
+type application struct {
+	InstanceID  string    `json:"instanceId"`
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	PackageHash string    `json:"packageHash"`
+	ActiveUntil time.Time `json:"activeUntil"`
+}
+
+func runListApplications(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("listapplications")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var data struct {
+		Applications []application `json:"applications"`
+	}
+	if err := getJSON(ctx, client, "/apps/list", &data); err != nil {
+		return err
+	}
+	if data.Applications == nil {
+		data.Applications = []application{}
+	}
+	return printResult(data.Applications, func(w io.Writer) {
+		for _, app := range data.Applications {
+			fmt.Fprintf(w, "- %s %s [%s]\n", app.Name, app.Version, app.InstanceID)
+		}
+	})
+}
+
+func runDeleteApplication(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("deleteapplication")
+	id := flags.String("id", "", "Instance ID of the application to delete")
+	var force bool
+	addForceFlags(flags, &force)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return fmt.Errorf("--id is required")
+	}
+	if *id == adminInstanceID {
+		return fmt.Errorf("refusing to delete the Admin application (%s)", adminInstanceID)
+	}
+
+	// Look the instance up first so a mistyped ID fails here rather than at
+	// the hub, and so the prompt can show what is being deleted.
+	var data struct {
+		Applications []application `json:"applications"`
+	}
+	if err := getJSON(ctx, client, "/apps/list", &data); err != nil {
+		return err
+	}
+	var target *application
+	for i := range data.Applications {
+		if data.Applications[i].InstanceID == *id {
+			target = &data.Applications[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("no application with instance ID %q", *id)
+	}
+
+	if !force {
+		if err := confirm(fmt.Sprintf("About to delete application %s %s [%s].", target.Name, target.Version, target.InstanceID)); err != nil {
+			return err
+		}
+	}
+
+	if err := postJSON(ctx, client, "/apps/uninstall", map[string]string{"instanceId": target.InstanceID}); err != nil {
+		return err
+	}
+	return printResult(target, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted %s %s [%s]\n", target.Name, target.Version, target.InstanceID)
+	})
+}
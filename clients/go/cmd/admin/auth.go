@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// retryPolicy says how often authentication is retried.
+type retryPolicy struct {
+	// retries is how many more times a request that failed with a transient
+	// error is attempted, and how many more times to prompt after the hub
+	// rejects the credentials
+	retries int
+	// backoff is the wait before the first retry, doubling after each one
+	backoff time.Duration
+}
+
+// isTransient reports whether a failed authentication request may succeed if
+// retried: network errors and server errors, but not rejected credentials.
+func isTransient(err error) bool {
+	if yesterdaygo.IsNetworkError(err) {
+		return true
+	}
+	var yErr *yesterdaygo.Error
+	return errors.As(err, &yErr) && yErr.IsType(yesterdaygo.ErrorTypeAPI) &&
+		(yErr.StatusCode >= 500 || yErr.StatusCode == 429)
+}
+
+// do calls attempt until it succeeds, fails with an error that is not
+// transient or has been retried policy.retries times.
+func (p retryPolicy) do(ctx context.Context, attempt func() error) error {
+	backoff := p.backoff
+	for retry := 0; ; retry++ {
+		err := attempt()
+		if err == nil || retry >= p.retries || !isTransient(err) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%v; retrying in %s\n", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// authenticate obtains an access token from the saved refresh token, falling
+// back to prompting for credentials. The client keeps the refresh token the
+// hub rotates on every exchange, including across retries.
+func authenticate(ctx context.Context, client *yesterdaygo.Client, input io.Reader, policy retryPolicy) error {
+	err := policy.do(ctx, func() error { return client.Initialize(ctx) })
+	if err == nil {
+		return nil
+	}
+	if isTransient(err) || yesterdaygo.IsPasswordExpiredError(err) || ctx.Err() != nil {
+		// Prompting would not help
+		return err
+	}
+
+	reader := bufio.NewReader(input)
+	for prompt := 0; ; prompt++ {
+		fmt.Fprint(os.Stderr, "Username: ")
+		username, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read username: %w", err)
+		}
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		err = policy.do(ctx, func() error {
+			return client.Login(ctx, strings.TrimSpace(username), strings.TrimSpace(password))
+		})
+		if err == nil && !client.IsAuthenticated() {
+			// Logged in, but exchanging the new refresh token failed
+			err = policy.do(ctx, func() error { return client.RefreshAccessToken(ctx) })
+		}
+		if err == nil || prompt >= policy.retries || isTransient(err) || yesterdaygo.IsPasswordExpiredError(err) || ctx.Err() != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Login failed: %v\n", err)
+	}
+}
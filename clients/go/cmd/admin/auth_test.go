@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// authTestHub rotates the refresh token on every exchange, failing the
+// next `failures` access token requests with 503.
+type authTestHub struct {
+	mu       sync.Mutex
+	current  string
+	failures int
+	logins   int
+}
+
+func (h *authTestHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch r.URL.Path {
+	case "/public/login":
+		var request yesterdaygo.LoginRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		h.logins++
+		if request.Password != "secret" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		h.current = "refresh-login"
+		http.SetCookie(w, &http.Cookie{Name: "YRT", Value: h.current})
+	case "/public/access_token":
+		if h.failures > 0 {
+			h.failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		cookie, err := r.Cookie("YRT")
+		if err != nil || cookie.Value != h.current {
+			http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		h.current += "+"
+		http.SetCookie(w, &http.Cookie{Name: "YRT", Value: h.current})
+		w.Write([]byte(`{"access_token":"access"}`))
+	}
+}
+
+func newAuthTestClient(t *testing.T, hub *authTestHub) (*yesterdaygo.Client, *yesterdaygo.FileTokenStore) {
+	server := httptest.NewServer(hub)
+	t.Cleanup(server.Close)
+	store := yesterdaygo.NewFileTokenStore(filepath.Join(t.TempDir(), "admin-token"))
+	return yesterdaygo.NewClient(server.URL, yesterdaygo.WithTokenStore(store)), store
+}
+
+var testPolicy = retryPolicy{retries: 2, backoff: time.Millisecond}
+
+func TestAuthenticateRetriesRefresh(t *testing.T) {
+	hub := &authTestHub{current: "refresh-1", failures: 2}
+	client, store := newAuthTestClient(t, hub)
+	store.Save("refresh-1")
+
+	if err := authenticate(context.Background(), client, strings.NewReader(""), testPolicy); err != nil {
+		t.Fatalf("Expected the refresh to succeed on the third attempt, got %v", err)
+	}
+	if token, _ := store.Load(); token != "refresh-1+" {
+		t.Errorf("Expected the rotated token to be saved, got %q", token)
+	}
+
+	// A later run uses the rotated token without prompting
+	if err := authenticate(context.Background(), client, strings.NewReader(""), testPolicy); err != nil || hub.logins != 0 {
+		t.Errorf("Expected the saved token to be reused, got %d logins: %v", hub.logins, err)
+	}
+}
+
+func TestAuthenticateGivesUpOnServerErrors(t *testing.T) {
+	hub := &authTestHub{current: "refresh-1", failures: 5}
+	client, store := newAuthTestClient(t, hub)
+	store.Save("refresh-1")
+
+	err := authenticate(context.Background(), client, strings.NewReader("admin\nsecret\n"), testPolicy)
+	if err == nil || hub.logins != 0 || hub.failures != 2 {
+		t.Errorf("Expected 3 attempts and no prompt, got %d logins, %d failures left: %v", hub.logins, hub.failures, err)
+	}
+}
+
+func TestAuthenticatePromptsAgainAfterRejectedPassword(t *testing.T) {
+	hub := &authTestHub{current: "refresh-1"}
+	client, store := newAuthTestClient(t, hub)
+
+	if err := authenticate(context.Background(), client, strings.NewReader("admin\nwrong\nadmin\nsecret\n"), testPolicy); err != nil {
+		t.Fatalf("Expected the second login to succeed, got %v", err)
+	}
+	if hub.logins != 2 || !client.IsAuthenticated() {
+		t.Errorf("Expected 2 logins and an access token, got %d", hub.logins)
+	}
+	if token, _ := store.Load(); token != "refresh-login+" {
+		t.Errorf("Expected the token rotated after login to be saved, got %q", token)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdin is where confirmation prompts read their answer.
+var stdin io.Reader = os.Stdin
+
+// addForceFlags registers --force and its alias --yes on a destructive
+// command's flag set.
+func addForceFlags(flags *flag.FlagSet, force *bool) {
+	flags.BoolVar(force, "force", false, "Do not prompt for confirmation")
+	flags.BoolVar(force, "yes", false, "Alias for --force")
+}
+
+// confirm describes what is about to happen on stderr and asks the user to
+// type "yes". It returns an error unless they do, so commands can simply
+// return it.
+func confirm(description string) error {
+	fmt.Fprintf(os.Stderr, "%s\nThis cannot be undone. Type \"yes\" to continue: ", description)
+	answer, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("aborted: no confirmation given (use --force to skip the prompt)")
+	}
+	if strings.TrimSpace(strings.ToLower(answer)) != "yes" {
+		return fmt.Errorf("aborted")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// newDeleteTestServer serves a single application and records the instance
+// IDs passed to /apps/uninstall.
+func newDeleteTestServer(t *testing.T, uninstalled *[]string) *yesterdaygo.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/list", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"applications":[{"instanceId":"abc123","name":"tasks","version":"0.2.0"}]}`))
+	})
+	mux.HandleFunc("/apps/uninstall", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			InstanceID string `json:"instanceId"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		*uninstalled = append(*uninstalled, request.InstanceID)
+		w.Write([]byte(`{"instanceId":"` + request.InstanceID + `"}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return yesterdaygo.NewClient(server.URL)
+}
+
+func runDelete(t *testing.T, input string, args ...string) ([]string, string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	stdout = &out
+	stdin = strings.NewReader(input)
+	t.Cleanup(func() {
+		stdout = os.Stdout
+		stdin = os.Stdin
+	})
+	var uninstalled []string
+	err := runDeleteApplication(context.Background(), newDeleteTestServer(t, &uninstalled), args)
+	return uninstalled, out.String(), err
+}
+
+func TestDeleteApplicationConfirmed(t *testing.T) {
+	uninstalled, out, err := runDelete(t, "yes\n", "--id", "abc123")
+	if err != nil {
+		t.Fatalf("deleteapplication returned error: %v", err)
+	}
+	if len(uninstalled) != 1 || uninstalled[0] != "abc123" {
+		t.Errorf("Expected abc123 to be uninstalled, got %v", uninstalled)
+	}
+	if out != "Deleted tasks 0.2.0 [abc123]\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestDeleteApplicationDeclined(t *testing.T) {
+	for _, input := range []string{"n\n", "y\n", ""} {
+		uninstalled, _, err := runDelete(t, input, "--id", "abc123")
+		if err == nil {
+			t.Errorf("Expected an error for answer %q", input)
+		}
+		if len(uninstalled) != 0 {
+			t.Errorf("Expected nothing to be uninstalled for answer %q, got %v", input, uninstalled)
+		}
+	}
+}
+
+func TestDeleteApplicationForce(t *testing.T) {
+	for _, flag := range []string{"--force", "--yes"} {
+		uninstalled, _, err := runDelete(t, "", "--id", "abc123", flag)
+		if err != nil {
+			t.Fatalf("deleteapplication %s returned error: %v", flag, err)
+		}
+		if len(uninstalled) != 1 {
+			t.Errorf("Expected %s to skip the prompt, got %v", flag, uninstalled)
+		}
+	}
+}
+
+func TestDeleteApplicationRefusals(t *testing.T) {
+	for _, id := range []string{adminInstanceID, "missing"} {
+		uninstalled, _, err := runDelete(t, "yes\n", "--id", id, "--force")
+		if err == nil {
+			t.Errorf("Expected deleting %q to fail", id)
+		}
+		if len(uninstalled) != 0 {
+			t.Errorf("Expected nothing to be uninstalled for %q, got %v", id, uninstalled)
+		}
+	}
+}
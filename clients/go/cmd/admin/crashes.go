@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// crash is a group of identical crash reports as returned by the hub's
+// /apps/crashes endpoint.
+type crash struct {
+	ID          int64         `json:"id"`
+	InstanceID  string        `json:"instanceId"`
+	StackHash   string        `json:"stackHash"`
+	Count       int64         `json:"count"`
+	FirstSeen   int64         `json:"firstSeen"`
+	LastSeen    int64         `json:"lastSeen"`
+	Message     string        `json:"message"`
+	Stack       string        `json:"stack"`
+	Breadcrumbs []string      `json:"breadcrumbs,omitempty"`
+	Request     *crashRequest `json:"request,omitempty"`
+	Task        string        `json:"task,omitempty"`
+}
+
+type crashRequest struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	TraceID string `json:"traceId,omitempty"`
+}
+
+func runCrashes(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("crashes")
+	instance := flags.String("instance", "", "Instance ID of the application")
+	limit := flags.Int("limit", 20, "Number of crashes to show")
+	offset := flags.Int("offset", 0, "Number of crashes to skip")
+	stacks := flags.Bool("stacks", false, "Print stack traces and breadcrumbs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *instance == "" {
+		return fmt.Errorf("--instance is required")
+	}
+
+	query := url.Values{}
+	query.Set("instance", *instance)
+	query.Set("limit", strconv.Itoa(*limit))
+	query.Set("offset", strconv.Itoa(*offset))
+	var data struct {
+		Crashes []crash `json:"crashes"`
+		Total   int     `json:"total"`
+	}
+	if err := getJSON(ctx, client, "/apps/crashes?"+query.Encode(), &data); err != nil {
+		return err
+	}
+	if data.Crashes == nil {
+		data.Crashes = []crash{}
+	}
+	return printResult(data.Crashes, func(w io.Writer) {
+		fmt.Fprintf(w, "%d distinct crashes, showing %d from offset %d\n", data.Total, len(data.Crashes), *offset)
+		for _, c := range data.Crashes {
+			formatCrash(w, c, *stacks)
+		}
+	})
+}
+
+func formatCrash(w io.Writer, c crash, stacks bool) {
+	where := c.Task
+	if c.Request != nil {
+		where = c.Request.Method + " " + c.Request.Path
+	}
+	fmt.Fprintf(w, "- %s (%dx, last %s) %s [%.12s]\n",
+		c.Message, c.Count, time.Unix(c.LastSeen, 0).Local().Format(time.DateTime), where, c.StackHash)
+	if !stacks {
+		return
+	}
+	for _, line := range c.Breadcrumbs {
+		fmt.Fprintf(w, "    | %s\n", line)
+	}
+	fmt.Fprintf(w, "%s\n", c.Stack)
+}
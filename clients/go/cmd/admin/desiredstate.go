@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// desiredSnapshot is a snapshot of the instances the hub should be running,
+// as returned by its /apps/desired-state endpoint. Instances are kept as JSON
+// objects so that every field the hub records is compared.
+type desiredSnapshot struct {
+	Version   int64            `json:"version"`
+	TakenAt   time.Time        `json:"takenAt"`
+	Instances []map[string]any `json:"instances"`
+}
+
+// desiredDiff is the difference between two snapshots.
+type desiredDiff struct {
+	FromVersion int64             `json:"fromVersion"`
+	ToVersion   int64             `json:"toVersion"`
+	Added       []string          `json:"added"`
+	Removed     []string          `json:"removed"`
+	Changed     []changedInstance `json:"changed"`
+}
+
+// changedInstance names the fields of an instance that changed, with the
+// variables of changed maps such as env as "env.NAME".
+type changedInstance struct {
+	InstanceID string   `json:"instanceId"`
+	Fields     []string `json:"fields"`
+}
+
+func runDiffDesired(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("diff-desired")
+	from := flags.String("from", "", "Time of the earlier snapshot, RFC 3339 or local \"YYYY-MM-DD HH:MM[:SS]\"")
+	to := flags.String("to", "now", "Time of the later snapshot, in the same form")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("--from is required")
+	}
+	fromTime, err := parseTimeFlag(*from)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	toTime, err := parseTimeFlag(*to)
+	if err != nil {
+		return fmt.Errorf("invalid --to: %w", err)
+	}
+
+	var before, after desiredSnapshot
+	for _, fetch := range []struct {
+		at       time.Time
+		snapshot *desiredSnapshot
+	}{{fromTime, &before}, {toTime, &after}} {
+		query := url.Values{}
+		query.Set("at", fetch.at.Format(time.RFC3339))
+		if err := getJSON(ctx, client, "/apps/desired-state?"+query.Encode(), fetch.snapshot); err != nil {
+			return err
+		}
+	}
+
+	diff := diffDesired(before, after)
+	return printResult(diff, func(w io.Writer) {
+		fmt.Fprintf(w, "Version %d (%s) to version %d (%s)\n",
+			before.Version, before.TakenAt.Local().Format(time.DateTime),
+			after.Version, after.TakenAt.Local().Format(time.DateTime))
+		if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+			fmt.Fprintln(w, "No changes")
+			return
+		}
+		for _, id := range diff.Added {
+			fmt.Fprintf(w, "+ %s\n", id)
+		}
+		for _, id := range diff.Removed {
+			fmt.Fprintf(w, "- %s\n", id)
+		}
+		for _, changed := range diff.Changed {
+			fmt.Fprintf(w, "~ %s: %s\n", changed.InstanceID, strings.Join(changed.Fields, ", "))
+		}
+	})
+}
+
+// parseTimeFlag parses "now", an RFC 3339 timestamp or a local date and time.
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "now" {
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not \"now\", an RFC 3339 timestamp or \"YYYY-MM-DD HH:MM[:SS]\"", value)
+}
+
+// diffDesired lists the instances added, removed and changed between two
+// snapshots, each sorted by instance ID.
+func diffDesired(before, after desiredSnapshot) desiredDiff {
+	diff := desiredDiff{
+		FromVersion: before.Version,
+		ToVersion:   after.Version,
+		Added:       []string{},
+		Removed:     []string{},
+		Changed:     []changedInstance{},
+	}
+	beforeByID := instancesByID(before.Instances)
+	afterByID := instancesByID(after.Instances)
+	for id, instance := range afterByID {
+		previous, ok := beforeByID[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+		} else if fields := changedFields(previous, instance, ""); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, changedInstance{InstanceID: id, Fields: fields})
+		}
+	}
+	for id := range beforeByID {
+		if _, ok := afterByID[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].InstanceID < diff.Changed[j].InstanceID
+	})
+	return diff
+}
+
+func instancesByID(instances []map[string]any) map[string]map[string]any {
+	byID := make(map[string]map[string]any, len(instances))
+	for _, instance := range instances {
+		id, _ := instance["instanceId"].(string)
+		byID[id] = instance
+	}
+	return byID
+}
+
+// changedFields returns the sorted names of the fields that differ between
+// two objects, descending into fields that are objects in both.
+func changedFields(before, after map[string]any, prefix string) []string {
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	var fields []string
+	for name := range names {
+		if reflect.DeepEqual(before[name], after[name]) {
+			continue
+		}
+		beforeObject, beforeIsObject := before[name].(map[string]any)
+		afterObject, afterIsObject := after[name].(map[string]any)
+		if beforeIsObject && afterIsObject {
+			fields = append(fields, changedFields(beforeObject, afterObject, prefix+name+".")...)
+		} else {
+			fields = append(fields, prefix+name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
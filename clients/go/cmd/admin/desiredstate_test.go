@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffDesired(t *testing.T) {
+	var before, after desiredSnapshot
+	if err := json.Unmarshal([]byte(`{"version": 3, "instances": [
+		{"instanceId": "kept", "packageHash": "abc", "env": {"HOST": "h1", "INSTANCE_ID": "i1"}},
+		{"instanceId": "same", "sandbox": true},
+		{"instanceId": "removed"}
+	]}`), &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"version": 5, "instances": [
+		{"instanceId": "added"},
+		{"instanceId": "same", "sandbox": true},
+		{"instanceId": "kept", "packageHash": "def", "runSelfTest": true, "env": {"HOST": "h2", "INSTANCE_ID": "i1"}}
+	]}`), &after); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := diffDesired(before, after)
+	expected := desiredDiff{
+		FromVersion: 3,
+		ToVersion:   5,
+		Added:       []string{"added"},
+		Removed:     []string{"removed"},
+		Changed: []changedInstance{
+			{InstanceID: "kept", Fields: []string{"env.HOST", "packageHash", "runSelfTest"}},
+		},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diff)
+	}
+}
+
+func TestParseTimeFlag(t *testing.T) {
+	if parsed, err := parseTimeFlag("2026-10-17T02:13:00Z"); err != nil || !parsed.Equal(time.Date(2026, 10, 17, 2, 13, 0, 0, time.UTC)) {
+		t.Errorf("Expected an RFC 3339 time to be parsed, got %v: %v", parsed, err)
+	}
+	if parsed, err := parseTimeFlag("2026-10-17 02:13"); err != nil || !parsed.Equal(time.Date(2026, 10, 17, 2, 13, 0, 0, time.Local)) {
+		t.Errorf("Expected a local time to be parsed, got %v: %v", parsed, err)
+	}
+	if _, err := parseTimeFlag("yesterday"); err == nil {
+		t.Error("Expected an unknown time to be rejected")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// eventStats is the event log summary returned by the hub's /events/stats
+// endpoint.
+type eventStats struct {
+	TotalEvents int64 `json:"totalEvents"`
+	Types       []struct {
+		Type                string  `json:"type"`
+		Count               int64   `json:"count"`
+		PayloadBytes        int64   `json:"payloadBytes"`
+		AveragePayloadBytes float64 `json:"averagePayloadBytes"`
+	} `json:"types"`
+	Days []struct {
+		Day   string `json:"day"`
+		Count int64  `json:"count"`
+	} `json:"days"`
+	Instances []struct {
+		InstanceID string `json:"instanceId"`
+		Name       string `json:"name"`
+		Count      int64  `json:"count"`
+	} `json:"instances"`
+}
+
+func runEventStats(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("eventstats")
+	days := flags.Int("days", 30, "Number of days of per-day counts to show")
+	top := flags.Int("top", 10, "Number of event types to show, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *days < 0 {
+		return fmt.Errorf("--days must not be negative")
+	}
+
+	var stats eventStats
+	if err := getJSON(ctx, client, fmt.Sprintf("/events/stats?days=%d", *days), &stats); err != nil {
+		return err
+	}
+	return printResult(stats, func(w io.Writer) {
+		fmt.Fprintf(w, "Total events: %d\n", stats.TotalEvents)
+
+		types := stats.Types
+		if *top > 0 && len(types) > *top {
+			types = types[:*top]
+		}
+		fmt.Fprintf(w, "\nTop event types:\n")
+		for _, t := range types {
+			fmt.Fprintf(w, "  %-32s %10d  avg %.0f bytes\n", t.Type, t.Count, t.AveragePayloadBytes)
+		}
+		if len(stats.Days) > 0 {
+			fmt.Fprintf(w, "\nEvents per day:\n")
+			for _, d := range stats.Days {
+				fmt.Fprintf(w, "  %s %10d\n", d.Day, d.Count)
+			}
+		}
+		if len(stats.Instances) > 0 {
+			fmt.Fprintf(w, "\nEvents delivered per instance:\n")
+			for _, i := range stats.Instances {
+				fmt.Fprintf(w, "  %-32s %10d  [%s]\n", i.Name, i.Count, i.InstanceID)
+			}
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// fetchResult describes a completed download.
+type fetchResult struct {
+	InstanceID string `json:"instanceId"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+}
+
+func runFetchPackage(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	return runFetch(ctx, client, "fetch-package", "package", ".zip", args)
+}
+
+func runFetchDatabase(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	return runFetch(ctx, client, "fetch-db", "database", ".sqlite", args)
+}
+
+// runFetch downloads an instance's package or database snapshot. Running the
+// same command again after an interruption resumes the download.
+func runFetch(ctx context.Context, client *yesterdaygo.Client, name, artifact, extension string, args []string) error {
+	flags := newFlagSet(name)
+	instance := flags.String("instance", "", "Instance ID of the application")
+	output := flags.String("output", "", "File to write (default <instanceID>"+extension+")")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *instance == "" {
+		return fmt.Errorf("--instance is required")
+	}
+	if *output == "" {
+		*output = *instance + extension
+	}
+
+	size, err := client.DownloadToFile(ctx, "/apps/"+*instance+"/"+artifact, *output, nil)
+	if err != nil {
+		return err
+	}
+	result := fetchResult{InstanceID: *instance, Path: *output, Size: size}
+	return printResult(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Downloaded %s of %s to %s (%d bytes)\n", artifact, result.InstanceID, result.Path, result.Size)
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// featureFlag is a flag from the Admin app's /api/feature_flags data view,
+// and the payload of a FeatureFlag:Set event.
+type featureFlag struct {
+	InstanceID string `json:"instanceId"`
+	Name       string `json:"name"`
+	Default    bool   `json:"default"`
+	Percentage int    `json:"percentage"`
+	Allow      []int  `json:"allow,omitempty"`
+	Deny       []int  `json:"deny,omitempty"`
+}
+
+func (f featureFlag) describe() string {
+	var parts []string
+	parts = append(parts, fmt.Sprintf("default %v", f.Default))
+	if f.Percentage > 0 {
+		parts = append(parts, fmt.Sprintf("%d%% rollout", f.Percentage))
+	}
+	if len(f.Allow) > 0 {
+		parts = append(parts, "allow "+joinIDs(f.Allow))
+	}
+	if len(f.Deny) > 0 {
+		parts = append(parts, "deny "+joinIDs(f.Deny))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func joinIDs(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
+
+// userIDList is a flag.Value for a comma-separated list of user IDs.
+type userIDList []int
+
+func (l *userIDList) String() string {
+	return joinIDs(*l)
+}
+
+func (l *userIDList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid user ID %q", part)
+		}
+		*l = append(*l, id)
+	}
+	return nil
+}
+
+func fetchFeatureFlags(ctx context.Context, client *yesterdaygo.Client) ([]featureFlag, error) {
+	var data struct {
+		Flags []featureFlag `json:"flags"`
+	}
+	if err := getJSON(ctx, client, client.AppPath(adminInstanceID, "api/feature_flags"), &data); err != nil {
+		return nil, err
+	}
+	if data.Flags == nil {
+		data.Flags = []featureFlag{}
+	}
+	return data.Flags, nil
+}
+
+// publishEvent publishes an event to the hub and waits for it to be accepted.
+func publishEvent(ctx context.Context, client *yesterdaygo.Client, eventType string, data any) error {
+	return postJSON(ctx, client, "/events/publish", map[string]any{
+		"clientId":  yesterdaygo.GenerateClientID(),
+		"type":      eventType,
+		"timestamp": time.Now(),
+		"data":      data,
+	})
+}
+
+func runListFlags(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("listflags")
+	instance := flags.String("instance", "", "Only list the flags of this application instance")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	all, err := fetchFeatureFlags(ctx, client)
+	if err != nil {
+		return err
+	}
+	list := []featureFlag{}
+	for _, f := range all {
+		if *instance == "" || f.InstanceID == *instance {
+			list = append(list, f)
+		}
+	}
+	return printResult(list, func(w io.Writer) {
+		for _, f := range list {
+			fmt.Fprintf(w, "- %s [%s]: %s\n", f.Name, f.InstanceID, f.describe())
+		}
+	})
+}
+
+func runSetFlag(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	var f featureFlag
+	var allow, deny userIDList
+	flags := newFlagSet("setflag")
+	flags.StringVar(&f.InstanceID, "instance", "", "Instance ID of the application the flag belongs to")
+	flags.StringVar(&f.Name, "name", "", "Name of the flag")
+	flags.BoolVar(&f.Default, "default", false, "Value for users outside the allow and deny lists and the rollout")
+	flags.IntVar(&f.Percentage, "percentage", 0, "Percentage of users, from 0 to 100, to enable the flag for")
+	flags.Var(&allow, "allow", "Comma-separated IDs of users to always enable the flag for")
+	flags.Var(&deny, "deny", "Comma-separated IDs of users to never enable the flag for")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if f.InstanceID == "" || f.Name == "" {
+		return fmt.Errorf("--instance and --name are required")
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("--percentage must be between 0 and 100")
+	}
+	f.Allow, f.Deny = allow, deny
+
+	if err := publishEvent(ctx, client, "FeatureFlag:Set", f); err != nil {
+		return err
+	}
+	return printResult(f, func(w io.Writer) {
+		fmt.Fprintf(w, "Set %s [%s]: %s\n", f.Name, f.InstanceID, f.describe())
+	})
+}
+
+func runDeleteFlag(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("deleteflag")
+	instance := flags.String("instance", "", "Instance ID of the application the flag belongs to")
+	name := flags.String("name", "", "Name of the flag")
+	var force bool
+	addForceFlags(flags, &force)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *instance == "" || *name == "" {
+		return fmt.Errorf("--instance and --name are required")
+	}
+
+	if !force {
+		if err := confirm(fmt.Sprintf("About to delete feature flag %s [%s].", *name, *instance)); err != nil {
+			return err
+		}
+	}
+	event := map[string]string{"instanceId": *instance, "name": *name}
+	if err := publishEvent(ctx, client, "FeatureFlag:Delete", event); err != nil {
+		return err
+	}
+	return printResult(event, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted %s [%s]\n", *name, *instance)
+	})
+}
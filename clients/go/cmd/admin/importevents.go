@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// importedEvent is a line of an import file.
+type importedEvent struct {
+	ClientID  string          `json:"clientId"`
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// readImportFile reads events, one JSON object per line, skipping blank
+// lines. Events without a client ID or timestamp get new ones.
+func readImportFile(path string) ([]importedEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []importedEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event importedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if event.Type == "" {
+			return nil, fmt.Errorf("%s:%d: event has no type", path, line)
+		}
+		if event.ClientID == "" {
+			event.ClientID = yesterdaygo.GenerateClientID()
+		}
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+func runImportEvents(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("importevents")
+	path := flags.String("file", "", "File of events to publish, one JSON object per line")
+	dryRun := flags.Bool("dry-run", false, "Only check whether the events would apply, without publishing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("--file is required")
+	}
+	events, err := readImportFile(*path)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		return dryRunImport(ctx, client, events)
+	}
+	for i, event := range events {
+		if err := postJSON(ctx, client, "/events/publish", event); err != nil {
+			return fmt.Errorf("published %d of %d events, then: %w", i, len(events), err)
+		}
+	}
+	return printResult(map[string]int{"published": len(events)}, func(w io.Writer) {
+		fmt.Fprintf(w, "Published %d events\n", len(events))
+	})
+}
+
+// dryRunImport reports how the events would apply, failing if any would be
+// rejected.
+func dryRunImport(ctx context.Context, client *yesterdaygo.Client, events []importedEvent) error {
+	payloads := make([]interface{}, len(events))
+	for i, event := range events {
+		payloads[i] = event
+	}
+	results, err := client.GetEventPublisher().PublishDryRun(ctx, payloads...)
+	if err != nil {
+		return err
+	}
+
+	counts := map[yesterdaygo.DryRunStatus]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	err = printResult(results, func(w io.Writer) {
+		for i, result := range results {
+			if result.Status == yesterdaygo.DryRunRejected {
+				fmt.Fprintf(w, "- event %d (%s, %s): %s\n", i+1, result.Type, result.ClientID, result.Error)
+			}
+		}
+		fmt.Fprintf(w, "%d accepted, %d rejected, %d no-op; nothing was published\n",
+			counts[yesterdaygo.DryRunAccepted], counts[yesterdaygo.DryRunRejected], counts[yesterdaygo.DryRunNoop])
+	})
+	if err != nil {
+		return err
+	}
+	if rejected := counts[yesterdaygo.DryRunRejected]; rejected > 0 {
+		return fmt.Errorf("%d of %d events would be rejected", rejected, len(events))
+	}
+	return nil
+}
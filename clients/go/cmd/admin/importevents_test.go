@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+func TestImportEventsDryRun(t *testing.T) {
+	published := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dryRun") != "true" {
+			published++
+			w.Write([]byte(`{"status":"success","id":1}`))
+			return
+		}
+		var request struct {
+			Events []importedEvent `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		results := []yesterdaygo.DryRunResult{}
+		for _, event := range request.Events {
+			result := yesterdaygo.DryRunResult{ClientID: event.ClientID, Type: event.Type, Status: yesterdaygo.DryRunAccepted}
+			if event.Type == "User:Delete" {
+				result.Status, result.Error = yesterdaygo.DryRunRejected, "MBtskI6D: user not found"
+			}
+			results = append(results, result)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"dryRun": true, "results": results})
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	os.WriteFile(path, []byte(`{"type":"User:Add","data":{"username":"bob"}}
+
+{"clientId":"c2","type":"User:Delete","data":{"userId":9}}
+`), 0600)
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	client := yesterdaygo.NewClient(server.URL)
+	err := runImportEvents(context.Background(), client, []string{"--file", path, "--dry-run"})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 events would be rejected") {
+		t.Errorf("Expected the rejection to fail the command, got %v", err)
+	}
+	expected := "- event 2 (User:Delete, c2): MBtskI6D: user not found\n1 accepted, 1 rejected, 0 no-op; nothing was published\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+	if published != 0 {
+		t.Errorf("Expected nothing to be published, got %d events", published)
+	}
+
+	if err := runImportEvents(context.Background(), client, []string{"--file", path}); err != nil || published != 2 {
+		t.Errorf("Expected both events to be published, got %d: %v", published, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// logLevel is the payload of the hub's /apps/{id}/loglevel endpoint.
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func runLogLevel(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("loglevel")
+	instance := flags.String("instance", "", "Instance ID of the application")
+	level := flags.String("level", "", "Log level spec to set until the application restarts, e.g. info,database=debug")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *instance == "" {
+		return fmt.Errorf("--instance is required")
+	}
+
+	path := "/apps/" + url.PathEscape(*instance) + "/loglevel"
+	var result logLevel
+	if *level == "" {
+		if err := getJSON(ctx, client, path, &result); err != nil {
+			return err
+		}
+	} else if err := putLogLevel(ctx, client, path, *level, &result); err != nil {
+		return err
+	}
+	return printResult(result, func(w io.Writer) {
+		fmt.Fprintf(w, "%s: %s\n", *instance, result.Level)
+	})
+}
+
+func putLogLevel(ctx context.Context, client *yesterdaygo.Client, path, level string, result *logLevel) error {
+	resp, err := client.Put(ctx, path, logLevel{Level: level}, nil)
+	if err != nil {
+		return yesterdaygo.NewNetworkError(fmt.Sprintf("PUT %s failed", path), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return yesterdaygo.WrapHTTPError(resp, fmt.Sprintf("PUT %s failed", path))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return yesterdaygo.NewErrorWithCause(yesterdaygo.ErrorTypeAPI, "failed to decode response", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// logEntry is a log line as sent by the proxy's debug log stream.
+type logEntry struct {
+	ID        int64  `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Source    string `json:"source"`
+	Message   string `json:"message"`
+	PID       int    `json:"pid,omitempty"`
+}
+
+// logsOptions configures tailLogs.
+type logsOptions struct {
+	AppID  string
+	Follow bool
+	// QuietPeriod ends a one-shot tail once the stream has been idle this
+	// long, which happens after the recent entries have been replayed.
+	QuietPeriod time.Duration
+	// RetryDelay and MaxRetryDelay bound the reconnection backoff in follow
+	// mode.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// logsConnectTimeout bounds how long a one-shot tail waits to connect.
+const logsConnectTimeout = 30 * time.Second
+
+var defaultLogsOptions = logsOptions{
+	QuietPeriod:   time.Second,
+	RetryDelay:    2 * time.Second,
+	MaxRetryDelay: 30 * time.Second,
+}
+
+func runLogs(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	opts := defaultLogsOptions
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	flags.StringVar(&opts.AppID, "app", "", "Instance ID of the application (required)")
+	flags.BoolVar(&opts.Follow, "follow", false, "Keep streaming new entries, reconnecting if the stream drops")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if opts.AppID == "" {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	return tailLogs(ctx, client, opts, os.Stdout)
+}
+
+// tailLogs prints the application's recent log entries to out. In follow mode
+// it keeps printing new entries until ctx is done, reconnecting with
+// exponential backoff like nexusdebug's Monitor. Entries already printed are
+// skipped when a reconnected stream replays them.
+func tailLogs(ctx context.Context, client *yesterdaygo.Client, opts logsOptions, out io.Writer) error {
+	if !opts.Follow {
+		_, err := tailOnce(ctx, client, opts, out)
+		return err
+	}
+
+	var lastID int64
+	retryDelay := opts.RetryDelay
+	for {
+		connected, id, err := streamLogs(ctx, client, opts.AppID, lastID, out, nil)
+		lastID = id
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if connected {
+			// Reset the backoff after a successful connection
+			retryDelay = opts.RetryDelay
+			fmt.Fprintf(os.Stderr, "Log stream closed, reconnecting in %v...\n", retryDelay)
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to connect to log stream: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Retrying in %v...\n", retryDelay)
+			if yesterdaygo.IsAuthenticationError(err) {
+				// The access token may have expired during a long follow
+				if err := client.RefreshAccessToken(ctx); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return nil
+		}
+		if !connected {
+			retryDelay *= 2
+			if retryDelay > opts.MaxRetryDelay {
+				retryDelay = opts.MaxRetryDelay
+			}
+		}
+	}
+}
+
+// tailOnce prints the entries the stream replays on connecting and returns
+// once it has been quiet for opts.QuietPeriod.
+func tailOnce(ctx context.Context, client *yesterdaygo.Client, opts logsOptions, out io.Writer) (int64, error) {
+	tailCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The stream sends a connection notice straight away, so the quiet
+	// period only starts once connected
+	quiet := time.AfterFunc(logsConnectTimeout, cancel)
+	defer quiet.Stop()
+
+	_, lastID, err := streamLogs(tailCtx, client, opts.AppID, 0, out, func() {
+		quiet.Reset(opts.QuietPeriod)
+	})
+	if tailCtx.Err() != nil && ctx.Err() == nil {
+		// Ended by the quiet period
+		return lastID, nil
+	}
+	return lastID, err
+}
+
+// streamLogs connects to the application's log stream and prints entries with
+// IDs above afterID until the stream ends. It reports whether it connected and
+// the ID of the last entry printed. onEvent, if set, is called for every
+// event received, including keepalives.
+func streamLogs(ctx context.Context, client *yesterdaygo.Client, appID string, afterID int64, out io.Writer, onEvent func()) (bool, int64, error) {
+	lastID := afterID
+	resp, err := client.Get(ctx, fmt.Sprintf("/debug/application/%s/logs", appID), map[string]string{
+		"Accept": "text/event-stream",
+	})
+	if err != nil {
+		return false, lastID, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, lastID, yesterdaygo.WrapHTTPError(resp, "failed to open log stream")
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// End of an event
+			if onEvent != nil {
+				onEvent()
+			}
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			fmt.Fprintln(out, data)
+			continue
+		}
+		// ID 0 is a notice from the stream itself: the connection notice,
+		// or a warning that entries were dropped because we read too
+		// slowly. Lower IDs were printed before a reconnect.
+		if entry.ID == 0 {
+			if entry.Level == "warn" {
+				fmt.Fprintln(out, formatLogEntry(entry))
+			}
+			continue
+		}
+		if entry.ID <= lastID {
+			continue
+		}
+		lastID = entry.ID
+		fmt.Fprintln(out, formatLogEntry(entry))
+	}
+	return true, lastID, scanner.Err()
+}
+
+func formatLogEntry(entry logEntry) string {
+	timestamp := entry.Timestamp
+	if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
+		timestamp = t.Local().Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("%s %-5s %s", timestamp, strings.ToUpper(entry.Level), entry.Message)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// fakeLogStream serves the debug log stream for app "app1". Each connection
+// replays the entries logged so far, like the hub does, then sends the
+// entries queued for that connection and either closes or stays open.
+type fakeLogStream struct {
+	mu          sync.Mutex
+	connections int
+	logged      []int64
+	// batches[i] holds the new entries sent on connection i
+	batches [][]int64
+	// holdOpen keeps the last connection open until the client leaves
+	holdOpen bool
+}
+
+func (s *fakeLogStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/debug/application/app1/logs" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	connection := s.connections
+	s.connections++
+	entries := append([]int64{0}, s.logged...)
+	if connection < len(s.batches) {
+		entries = append(entries, s.batches[connection]...)
+		s.logged = append(s.logged, s.batches[connection]...)
+	}
+	last := connection >= len(s.batches)-1
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, id := range entries {
+		data, _ := json.Marshal(logEntry{
+			ID:        id,
+			Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC).Format(time.RFC3339),
+			Level:     "info",
+			Message:   fmt.Sprintf("entry %d", id),
+		})
+		fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+	}
+	w.(http.Flusher).Flush()
+	if last && s.holdOpen {
+		<-r.Context().Done()
+	}
+}
+
+func countLines(output, substr string) int {
+	count := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasSuffix(line, substr) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestTailLogsOnce(t *testing.T) {
+	stream := &fakeLogStream{batches: [][]int64{{1, 2, 3}}, holdOpen: true}
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	var out bytes.Buffer
+	opts := defaultLogsOptions
+	opts.AppID = "app1"
+	opts.QuietPeriod = 50 * time.Millisecond
+	if err := tailLogs(context.Background(), yesterdaygo.NewClient(server.URL), opts, &out); err != nil {
+		t.Fatalf("tailLogs returned error: %v", err)
+	}
+
+	for id := 1; id <= 3; id++ {
+		if n := countLines(out.String(), fmt.Sprintf("entry %d", id)); n != 1 {
+			t.Errorf("Expected entry %d once, got %d times in:\n%s", id, n, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "entry 0") {
+		t.Errorf("Expected the connection notice to be skipped")
+	}
+}
+
+func TestTailLogsFollowReconnects(t *testing.T) {
+	stream := &fakeLogStream{batches: [][]int64{{1, 2}, {3}}, holdOpen: true}
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var out bytes.Buffer
+	writer := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return out.Write(p)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	opts := defaultLogsOptions
+	opts.AppID = "app1"
+	opts.Follow = true
+	opts.RetryDelay = 10 * time.Millisecond
+	go func() {
+		done <- tailLogs(ctx, yesterdaygo.NewClient(server.URL), opts, writer)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		output := out.String()
+		mu.Unlock()
+		if strings.Contains(output, "entry 3") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for entry after reconnect, got:\n%s", output)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("tailLogs returned error: %v", err)
+	}
+
+	// The second connection replays entries 1 and 2, which must not repeat
+	for id := 1; id <= 3; id++ {
+		if n := countLines(out.String(), fmt.Sprintf("entry %d", id)); n != 1 {
+			t.Errorf("Expected entry %d once, got %d times in:\n%s", id, n, out.String())
+		}
+	}
+}
+
+func TestTailLogsUnknownApp(t *testing.T) {
+	server := httptest.NewServer(&fakeLogStream{})
+	defer server.Close()
+
+	opts := defaultLogsOptions
+	opts.AppID = "missing"
+	err := tailLogs(context.Background(), yesterdaygo.NewClient(server.URL), opts, &bytes.Buffer{})
+	if !yesterdaygo.IsAPIError(err) {
+		t.Errorf("Expected API error for unknown app, got %v", err)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,189 @@
+// Package main implements the admin CLI for managing a NexusHub installation
+// through the Admin app and the proxy's debug API.
+//
+// Usage:
+//
+//	admin [-url URL] [-token-path PATH] [-auth-retries N] [-auth-backoff D] [-json] <command> [options]
+//
+// The CLI reuses the refresh token saved by earlier runs, in a file only the
+// user can read, and prompts for a username and password only when it has
+// none or the hub rejects it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+const defaultHubURL = "https://www.yesterday.localhost:8443"
+
+// command is a single admin CLI subcommand.
+type command struct {
+	summary string
+	run     func(ctx context.Context, client *yesterdaygo.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"listusers": {
+		summary: "List the users of the Admin app",
+		run:     runListUsers,
+	},
+	"getuserprofile": {
+		summary: "Show a user (getuserprofile --id <id> | --username <name>)",
+		run:     runGetUserProfile,
+	},
+	"restoreuser": {
+		summary: "Restore a deleted user before it is purged (restoreuser --id <id>)",
+		run:     runRestoreUser,
+	},
+	"exportuser": {
+		summary: "Export everything held about a user (exportuser --id <id> [--output FILE] [--force])",
+		run:     runExportUser,
+	},
+	"forgetuser": {
+		summary: "Erase a user's personal data from every application (forgetuser --id <id> [--force])",
+		run:     runForgetUser,
+	},
+	"crashes": {
+		summary: "List an application's crashes (crashes --instance <instanceID> [--stacks])",
+		run:     runCrashes,
+	},
+	"deleteapplication": {
+		summary: "Delete an application instance (deleteapplication --id <instanceID> [--force])",
+		run:     runDeleteApplication,
+	},
+	"fetch-package": {
+		summary: "Download the package an application was installed from (fetch-package --instance <instanceID> [--output FILE])",
+		run:     runFetchPackage,
+	},
+	"fetch-db": {
+		summary: "Download a snapshot of an application's database (fetch-db --instance <instanceID> [--output FILE])",
+		run:     runFetchDatabase,
+	},
+	"listflags": {
+		summary: "List feature flags (listflags [--instance <instanceID>])",
+		run:     runListFlags,
+	},
+	"setflag": {
+		summary: "Set a feature flag (setflag --instance <instanceID> --name <name> [--default] [--percentage N] [--allow IDs] [--deny IDs])",
+		run:     runSetFlag,
+	},
+	"deleteflag": {
+		summary: "Delete a feature flag (deleteflag --instance <instanceID> --name <name> [--force])",
+		run:     runDeleteFlag,
+	},
+	"listapplications": {
+		summary: "List the installed application instances",
+		run:     runListApplications,
+	},
+	"importevents": {
+		summary: "Publish events from a file, one JSON object per line (importevents --file FILE [--dry-run])",
+		run:     runImportEvents,
+	},
+	"eventstats": {
+		summary: "Show event log statistics (eventstats [--days N] [--top N])",
+		run:     runEventStats,
+	},
+	"logs": {
+		summary: "Print an application's logs (logs --app <instanceID> [--follow])",
+		run:     runLogs,
+	},
+	"loglevel": {
+		summary: "Show or change an application's log level (loglevel --instance <instanceID> [--level SPEC])",
+		run:     runLogLevel,
+	},
+	"usage": {
+		summary: "Show every application's database size and event count against its quota",
+		run:     runUsage,
+	},
+	"quota": {
+		summary: "Show or override an application's quota (quota --instance <instanceID> [--database-soft SIZE] [--database-hard SIZE] [--events-soft N] [--events-hard N] [--package SIZE] [--clear])",
+		run:     runQuota,
+	},
+	"restart": {
+		summary: "Restart an application's process, letting it drain first (restart --instance <instanceID>)",
+		run:     runRestart,
+	},
+	"trace": {
+		summary: "Show a request's access record, log lines and crashes (trace [--stacks] <traceID>)",
+		run:     runTrace,
+	},
+	"diff-desired": {
+		summary: "Show how the desired application instances changed between two times (diff-desired --from T1 [--to T2])",
+		run:     runDiffDesired,
+	},
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `NexusHub admin CLI
+
+Usage:
+  %s [options] <command> [command options]
+
+Options:
+`, os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", name, commands[name].summary)
+	}
+}
+
+func main() {
+	home, _ := os.UserHomeDir()
+	hubURL := flag.String("url", defaultHubURL, "NexusHub URL")
+	tokenPath := flag.String("token-path", filepath.Join(home, ".yesterday", "admin-token"), "File where the refresh token is kept")
+	authRetries := flag.Int("auth-retries", 3, "How many times to retry authenticating after a network or server error, and to prompt again after rejected credentials")
+	authBackoff := flag.Duration("auth-backoff", time.Second, "Wait before the first authentication retry, doubling after each one")
+	flag.BoolVar(&jsonOutput, "json", false, "Print command results as JSON")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		printUsage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		printUsage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := yesterdaygo.NewClient(*hubURL,
+		yesterdaygo.WithRefreshTokenPath(*tokenPath),
+		yesterdaygo.WithLogger(log.New(io.Discard, "", 0)),
+	)
+	if err := authenticate(ctx, client, os.Stdin, retryPolicy{retries: *authRetries, backoff: *authBackoff}); err != nil {
+		fmt.Fprintf(os.Stderr, "Authentication failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(ctx, client, flag.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
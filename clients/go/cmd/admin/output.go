@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// adminInstanceID is the instance ID of the Admin app.
+const adminInstanceID = "MBtskI6D"
+
+// jsonOutput is set by the global --json flag.
+var jsonOutput bool
+
+// stdout is where commands write their results.
+var stdout io.Writer = os.Stdout
+
+// newFlagSet returns the flag set for a command that supports --json, so the
+// flag may also be given after the command name.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.BoolVar(&jsonOutput, "json", jsonOutput, "Print the result as JSON")
+	return flags
+}
+
+// printResult writes v to stdout as indented JSON in --json mode, or calls
+// text to write the human-readable form otherwise.
+func printResult(v any, text func(w io.Writer)) error {
+	if !jsonOutput {
+		text(stdout)
+		return nil
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// getJSON fetches path and decodes its JSON response into result.
+func getJSON(ctx context.Context, client *yesterdaygo.Client, path string, result any) error {
+	resp, err := client.Get(ctx, path, nil)
+	if err != nil {
+		return yesterdaygo.NewNetworkError(fmt.Sprintf("GET %s failed", path), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return yesterdaygo.WrapHTTPError(resp, fmt.Sprintf("GET %s failed", path))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return yesterdaygo.NewErrorWithCause(yesterdaygo.ErrorTypeAPI, "failed to decode response", err)
+	}
+	return nil
+}
+
+// postJSON posts body as JSON to path and checks that the request succeeded.
+// The response body is discarded.
+func postJSON(ctx context.Context, client *yesterdaygo.Client, path string, body any) error {
+	resp, err := client.Post(ctx, path, body, nil)
+	if err != nil {
+		return yesterdaygo.NewNetworkError(fmt.Sprintf("POST %s failed", path), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return yesterdaygo.WrapHTTPError(resp, fmt.Sprintf("POST %s failed", path))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+func newAdminTestServer(t *testing.T) *yesterdaygo.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+adminInstanceID+"/api/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"users":[{"id":1,"username":"admin"},{"id":2,"username":"tom"}]}`))
+	})
+	mux.HandleFunc("/apps/list", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"applications":[{"instanceId":"MBtskI6D","name":"admin","version":"1.0.0","packageHash":"abc","activeUntil":"2025-01-02T03:04:05Z"}]}`))
+	})
+	mux.HandleFunc("/apps/crashes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("instance") != "MBtskI6D" || r.URL.Query().Get("limit") != "20" {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"crashes":[{"id":1,"instanceId":"MBtskI6D","stackHash":"0123456789abcdef","count":3,"lastSeen":1700000000,"message":"nil map","request":{"method":"GET","path":"/api/users"}}],"total":1}`))
+	})
+	mux.HandleFunc("/"+adminInstanceID+"/api/feature_flags", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"flags":[{"instanceId":"app1","name":"beta","default":false,"percentage":25,"allow":[1,2]},{"instanceId":"app2","name":"dark-mode","default":true,"percentage":0}]}`))
+	})
+	mux.HandleFunc("/events/publish", func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.Type != "FeatureFlag:Set" ||
+			string(event.Data) != `{"instanceId":"app1","name":"beta","default":false,"percentage":10,"deny":[3,4]}` {
+			http.Error(w, "unexpected event", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"status":"success","id":1}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return yesterdaygo.NewClient(server.URL)
+}
+
+// runCommand runs a command with output captured, restoring the global
+// output settings afterwards.
+func runCommand(t *testing.T, name string, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	stdout = &out
+	t.Cleanup(func() {
+		stdout = os.Stdout
+		jsonOutput = false
+	})
+	if err := commands[name].run(context.Background(), newAdminTestServer(t), args); err != nil {
+		t.Fatalf("%s returned error: %v", name, err)
+	}
+	return out.String()
+}
+
+func TestTextOutput(t *testing.T) {
+	tests := []struct {
+		command  string
+		args     []string
+		expected string
+	}{
+		{"listusers", nil, "- admin [1]\n- tom [2]\n"},
+		{"getuserprofile", []string{"--username", "tom"}, "ID:       2\nUsername: tom\n"},
+		{"listapplications", nil, "- admin 1.0.0 [MBtskI6D]\n"},
+		{"listflags", nil, "- beta [app1]: default false, 25% rollout, allow 1,2\n- dark-mode [app2]: default true\n"},
+		{"listflags", []string{"--instance", "app2"}, "- dark-mode [app2]: default true\n"},
+		{"setflag", []string{"--instance", "app1", "--name", "beta", "--percentage", "10", "--deny", "3,4"}, "Set beta [app1]: default false, 10% rollout, deny 3,4\n"},
+	}
+	for _, test := range tests {
+		if got := runCommand(t, test.command, test.args...); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.command, test.expected, got)
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	var users []user
+	if err := json.Unmarshal([]byte(runCommand(t, "listusers", "--json")), &users); err != nil {
+		t.Fatalf("listusers --json produced invalid JSON: %v", err)
+	}
+	if len(users) != 2 || users[1].Username != "tom" {
+		t.Errorf("Unexpected users: %+v", users)
+	}
+
+	// The global flag works too
+	jsonOutput = true
+	var profile user
+	if err := json.Unmarshal([]byte(runCommand(t, "getuserprofile", "--id", "1")), &profile); err != nil {
+		t.Fatalf("getuserprofile produced invalid JSON: %v", err)
+	}
+	if profile.ID != 1 || profile.Username != "admin" {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+
+	var apps []application
+	if err := json.Unmarshal([]byte(runCommand(t, "listapplications", "--json")), &apps); err != nil {
+		t.Fatalf("listapplications --json produced invalid JSON: %v", err)
+	}
+	if len(apps) != 1 || apps[0].InstanceID != "MBtskI6D" || apps[0].ActiveUntil.IsZero() {
+		t.Errorf("Unexpected applications: %+v", apps)
+	}
+
+	var crashes []crash
+	if err := json.Unmarshal([]byte(runCommand(t, "crashes", "--instance", "MBtskI6D", "--json")), &crashes); err != nil {
+		t.Fatalf("crashes --json produced invalid JSON: %v", err)
+	}
+	if len(crashes) != 1 || crashes[0].Count != 3 || crashes[0].Request.Path != "/api/users" {
+		t.Errorf("Unexpected crashes: %+v", crashes)
+	}
+}
+
+func TestCrashesTextOutput(t *testing.T) {
+	out := runCommand(t, "crashes", "--instance", "MBtskI6D")
+	if !strings.HasPrefix(out, "1 distinct crashes") || !strings.Contains(out, "- nil map (3x, last ") || !strings.HasSuffix(out, "GET /api/users [0123456789ab]\n") {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
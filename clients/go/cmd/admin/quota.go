@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// quotaLimit is a soft and a hard limit, zero meaning none.
+type quotaLimit struct {
+	Soft int64 `json:"soft,omitempty"`
+	Hard int64 `json:"hard,omitempty"`
+}
+
+// quotaLimits are the limits on an instance's resources, as accepted by the
+// hub's /apps/{id}/quota endpoint.
+type quotaLimits struct {
+	DatabaseBytes quotaLimit `json:"databaseBytes"`
+	Events        quotaLimit `json:"events"`
+	PackageBytes  int64      `json:"packageBytes,omitempty"`
+}
+
+// quotaUsage is an instance's usage as of the hub's last measurement.
+type quotaUsage struct {
+	InstanceID    string      `json:"instanceId"`
+	Name          string      `json:"name"`
+	DatabaseBytes int64       `json:"databaseBytes"`
+	Events        int64       `json:"events"`
+	Limits        quotaLimits `json:"limits"`
+	Overridden    bool        `json:"overridden,omitempty"`
+	DatabaseLevel string      `json:"databaseLevel"`
+	EventsLevel   string      `json:"eventsLevel"`
+	MeasuredAt    time.Time   `json:"measuredAt"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// quotaView is the payload of the hub's /apps/{id}/quota endpoint.
+type quotaView struct {
+	InstanceID string       `json:"instanceId"`
+	Usage      *quotaUsage  `json:"usage,omitempty"`
+	Override   *quotaLimits `json:"override,omitempty"`
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// byteSize is a flag holding a byte count such as "512MiB", "2GB" or
+// "1048576", the sizes the hub accepts in its QUOTA_* variables.
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(value string) error {
+	number, multiplier := strings.TrimSpace(value), int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*s = byteSize(n * multiplier)
+	return nil
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, unit := float64(n)/(1<<10), "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < 1<<10 {
+			break
+		}
+		value, unit = value/(1<<10), next
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
+
+// formatLimit describes a limit, formatting values with format.
+func formatLimit(limit quotaLimit, format func(int64) string) string {
+	var parts []string
+	if limit.Soft > 0 {
+		parts = append(parts, "soft "+format(limit.Soft))
+	}
+	if limit.Hard > 0 {
+		parts = append(parts, "hard "+format(limit.Hard))
+	}
+	if len(parts) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatCount(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func runUsage(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("usage")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var result struct {
+		Instances []quotaUsage `json:"instances"`
+	}
+	if err := getJSON(ctx, client, "/apps/usage", &result); err != nil {
+		return err
+	}
+	return printResult(result, func(w io.Writer) {
+		formatUsage(w, result.Instances)
+	})
+}
+
+// formatUsage prints one line per instance, flagging those at a limit.
+func formatUsage(w io.Writer, instances []quotaUsage) {
+	if len(instances) == 0 {
+		fmt.Fprintf(w, "No instances have been measured yet\n")
+		return
+	}
+	for _, usage := range instances {
+		fmt.Fprintf(w, "%-20s %-10s  database %10s  events %10d", usage.Name, usage.InstanceID, formatBytes(usage.DatabaseBytes), usage.Events)
+		if usage.DatabaseLevel != "ok" {
+			fmt.Fprintf(w, "  database at %s limit", usage.DatabaseLevel)
+		}
+		if usage.EventsLevel != "ok" {
+			fmt.Fprintf(w, "  events at %s limit", usage.EventsLevel)
+		}
+		if usage.Overridden {
+			fmt.Fprintf(w, "  (overridden)")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func runQuota(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("quota")
+	instance := flags.String("instance", "", "Instance ID of the application")
+	var limits quotaLimits
+	flags.Var((*byteSize)(&limits.DatabaseBytes.Soft), "database-soft", "Database size that warns, e.g. 1GiB")
+	flags.Var((*byteSize)(&limits.DatabaseBytes.Hard), "database-hard", "Database size that rejects event publishes, e.g. 4GiB")
+	flags.Int64Var(&limits.Events.Soft, "events-soft", 0, "Event count that warns")
+	flags.Int64Var(&limits.Events.Hard, "events-hard", 0, "Event count that rejects event publishes")
+	flags.Var((*byteSize)(&limits.PackageBytes), "package", "Largest debug package accepted, e.g. 256MiB")
+	clearOverride := flags.Bool("clear", false, "Remove the override, restoring the package's and the hub's limits")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *instance == "" {
+		return fmt.Errorf("--instance is required")
+	}
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || (f.Name != "instance" && f.Name != "clear")
+	})
+	if set && *clearOverride {
+		return fmt.Errorf("--clear cannot be combined with limits")
+	}
+
+	path := "/apps/" + url.PathEscape(*instance) + "/quota"
+	var result quotaView
+	var err error
+	switch {
+	case *clearOverride:
+		err = sendQuota(ctx, client, http.MethodDelete, path, nil, &result)
+	case set:
+		err = sendQuota(ctx, client, http.MethodPut, path, limits, &result)
+	default:
+		err = getJSON(ctx, client, path, &result)
+	}
+	if err != nil {
+		return err
+	}
+	return printResult(result, func(w io.Writer) {
+		formatQuota(w, result)
+	})
+}
+
+// formatQuota prints an instance's usage against each of its limits.
+func formatQuota(w io.Writer, view quotaView) {
+	if view.Usage == nil {
+		fmt.Fprintf(w, "%s has not been measured yet\n", view.InstanceID)
+	} else {
+		usage := view.Usage
+		fmt.Fprintf(w, "%s [%s], measured %s\n", usage.Name, usage.InstanceID, usage.MeasuredAt.Local().Format(time.DateTime))
+		fmt.Fprintf(w, "  database: %s (%s), %s\n", formatBytes(usage.DatabaseBytes), usage.DatabaseLevel, formatLimit(usage.Limits.DatabaseBytes, formatBytes))
+		fmt.Fprintf(w, "  events:   %d (%s), %s\n", usage.Events, usage.EventsLevel, formatLimit(usage.Limits.Events, formatCount))
+		if usage.Limits.PackageBytes > 0 {
+			fmt.Fprintf(w, "  packages: up to %s\n", formatBytes(usage.Limits.PackageBytes))
+		} else {
+			fmt.Fprintf(w, "  packages: unlimited\n")
+		}
+		if usage.Error != "" {
+			fmt.Fprintf(w, "  error:    %s\n", usage.Error)
+		}
+	}
+	if view.Override == nil {
+		fmt.Fprintf(w, "No override\n")
+		return
+	}
+	fmt.Fprintf(w, "Overridden: database %s; events %s", formatLimit(view.Override.DatabaseBytes, formatBytes), formatLimit(view.Override.Events, formatCount))
+	if view.Override.PackageBytes > 0 {
+		fmt.Fprintf(w, "; packages up to %s", formatBytes(view.Override.PackageBytes))
+	}
+	fmt.Fprintln(w)
+}
+
+func sendQuota(ctx context.Context, client *yesterdaygo.Client, method, path string, limits any, result *quotaView) error {
+	var resp *http.Response
+	var err error
+	if method == http.MethodDelete {
+		resp, err = client.Delete(ctx, path, nil)
+	} else {
+		resp, err = client.Put(ctx, path, limits, nil)
+	}
+	if err != nil {
+		return yesterdaygo.NewNetworkError(fmt.Sprintf("%s %s failed", method, path), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return yesterdaygo.WrapHTTPError(resp, fmt.Sprintf("%s %s failed", method, path))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return yesterdaygo.NewErrorWithCause(yesterdaygo.ErrorTypeAPI, "failed to decode response", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestByteSize(t *testing.T) {
+	for value, expected := range map[string]int64{"1048576": 1 << 20, "2GiB": 2 << 30, "5 MB": 5e6, "10B": 10} {
+		var size byteSize
+		if err := size.Set(value); err != nil || int64(size) != expected {
+			t.Errorf("Expected %s to be %d bytes, got %d, %v", value, expected, size, err)
+		}
+	}
+	for _, value := range []string{"", "lots", "-1KiB"} {
+		var size byteSize
+		if err := size.Set(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestFormatQuota(t *testing.T) {
+	var view quotaView
+	if err := json.Unmarshal([]byte(`{
+		"instanceId": "abc",
+		"usage": {"instanceId": "abc", "name": "todo", "databaseBytes": 1572864, "events": 1200,
+			"limits": {"databaseBytes": {"soft": 1048576, "hard": 2097152}, "events": {"hard": 1000}},
+			"overridden": true, "databaseLevel": "soft", "eventsLevel": "hard", "measuredAt": "2026-10-17T02:00:00Z"},
+		"override": {"databaseBytes": {}, "events": {"hard": 1000}, "packageBytes": 268435456}
+	}`), &view); err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	formatQuota(&output, view)
+	for _, expected := range []string{
+		"todo [abc], measured ",
+		"  database: 1.5 MiB (soft), soft 1.0 MiB, hard 2.0 MiB\n",
+		"  events:   1200 (hard), hard 1000\n",
+		"  packages: unlimited\n",
+		"Overridden: database unlimited; events hard 1000; packages up to 256.0 MiB\n",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, output.String())
+		}
+	}
+
+	output.Reset()
+	formatUsage(&output, []quotaUsage{*view.Usage})
+	if !strings.Contains(output.String(), "database at soft limit  events at hard limit  (overridden)") {
+		t.Errorf("Unexpected output:\n%s", output.String())
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+func runRestart(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("restart")
+	instance := flags.String("instance", "", "Instance ID of the application")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *instance == "" {
+		return fmt.Errorf("--instance is required")
+	}
+
+	if err := postJSON(ctx, client, "/apps/"+url.PathEscape(*instance)+"/restart", nil); err != nil {
+		return err
+	}
+	return printResult(map[string]string{"restarted": *instance}, func(w io.Writer) {
+		fmt.Fprintf(w, "Restarted %s\n", *instance)
+	})
+}
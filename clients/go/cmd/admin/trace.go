@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// traceView is everything the hub knows about a request, as returned by its
+// /debug/trace/{traceID} endpoint.
+type traceView struct {
+	TraceID  string        `json:"traceId"`
+	Request  *traceRecord  `json:"request,omitempty"`
+	Logs     []traceLogRow `json:"logs"`
+	LogError string        `json:"logError,omitempty"`
+	Crashes  []crash       `json:"crashes"`
+}
+
+// traceRecord is the proxy's record of a request.
+type traceRecord struct {
+	InstanceID string    `json:"instanceId,omitempty"`
+	UserID     int       `json:"userId,omitempty"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// traceLogRow is a line the instance logged while serving the request.
+type traceLogRow struct {
+	Timestamp time.Time `json:"timestamp"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	PID       int       `json:"pid,omitempty"`
+}
+
+func runTrace(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("trace")
+	stacks := flags.Bool("stacks", false, "Print the stack traces and breadcrumbs of crashes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: trace [--stacks] <traceID>")
+	}
+
+	var view traceView
+	if err := getJSON(ctx, client, "/debug/trace/"+url.PathEscape(flags.Arg(0)), &view); err != nil {
+		return err
+	}
+	return printResult(view, func(w io.Writer) {
+		formatTrace(w, view, *stacks)
+	})
+}
+
+func formatTrace(w io.Writer, view traceView, stacks bool) {
+	fmt.Fprintf(w, "Trace %s\n", view.TraceID)
+	if request := view.Request; request != nil {
+		fmt.Fprintf(w, "%s %s%s => %d in %s at %s\n", request.Method, request.Host, request.Path, request.Status,
+			request.FinishedAt.Sub(request.StartedAt), request.StartedAt.Local().Format(time.DateTime))
+		if request.InstanceID != "" {
+			fmt.Fprintf(w, "  instance: %s\n", request.InstanceID)
+		}
+		if request.UserID != 0 {
+			fmt.Fprintf(w, "  user:     %d\n", request.UserID)
+		}
+		if request.Error != "" {
+			fmt.Fprintf(w, "  error:    %s\n", request.Error)
+		}
+	} else {
+		fmt.Fprintf(w, "The request was not indexed\n")
+	}
+
+	switch {
+	case view.LogError != "":
+		fmt.Fprintf(w, "\nLogs could not be read: %s\n", view.LogError)
+	case len(view.Logs) > 0:
+		fmt.Fprintf(w, "\nLogs (%d):\n", len(view.Logs))
+		for _, line := range view.Logs {
+			fmt.Fprintf(w, "%s %-5s %s\n", line.Timestamp.Local().Format("15:04:05.000"), line.Level, line.Message)
+		}
+	}
+	if len(view.Crashes) > 0 {
+		fmt.Fprintf(w, "\nCrashes (%d):\n", len(view.Crashes))
+		for _, c := range view.Crashes {
+			formatCrash(w, c, stacks)
+		}
+	}
+}
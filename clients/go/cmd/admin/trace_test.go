@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormatTrace(t *testing.T) {
+	var view traceView
+	if err := json.Unmarshal([]byte(`{
+		"traceId": "trace-1",
+		"request": {"instanceId": "abc", "userId": 5, "method": "GET", "host": "hub.example.com", "path": "/abc/api/fail",
+			"status": 500, "startedAt": "2026-10-17T02:00:00Z", "finishedAt": "2026-10-17T02:00:00.120Z", "error": "database is locked"},
+		"logs": [{"timestamp": "2026-10-17T02:00:00.050Z", "level": "error", "message": "level=ERROR msg=\"query failed\" trace_id=trace-1"}],
+		"crashes": [{"message": "nil map", "count": 1, "lastSeen": 1792202400, "stackHash": "0123456789abcdef", "request": {"method": "GET", "path": "/api/fail"}}]
+	}`), &view); err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	formatTrace(&output, view, false)
+	for _, expected := range []string{
+		"GET hub.example.com/abc/api/fail => 500 in 120ms",
+		"  instance: abc\n",
+		"  user:     5\n",
+		"  error:    database is locked\n",
+		"Logs (1):\n",
+		`error level=ERROR msg="query failed" trace_id=trace-1`,
+		"Crashes (1):\n- nil map (1x",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, output.String())
+		}
+	}
+
+	output.Reset()
+	formatTrace(&output, traceView{TraceID: "trace-2", LogError: "permission denied"}, false)
+	if !strings.Contains(output.String(), "The request was not indexed") || !strings.Contains(output.String(), "Logs could not be read: permission denied") {
+		t.Errorf("Unexpected output:\n%s", output.String())
+	}
+}
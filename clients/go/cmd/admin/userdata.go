@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// exportResult describes a user data archive written by exportuser.
+type exportResult struct {
+	UserID int    `json:"userId"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+}
+
+// forgetResult is the hub's response to /users/forget.
+type forgetResult struct {
+	UserID         int               `json:"userId"`
+	Applications   []string          `json:"applications"`
+	RedactedEvents int               `json:"redactedEvents"`
+	Failed         map[string]string `json:"failed,omitempty"`
+}
+
+// findUser looks a user up by ID in the Admin app.
+func findUser(ctx context.Context, client *yesterdaygo.Client, id int) (*user, error) {
+	users, err := fetchUsers(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if users[i].ID == id {
+			return &users[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no user with ID %d", id)
+}
+
+func runExportUser(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("exportuser")
+	id := flags.Int("id", 0, "ID of the user to export")
+	output := flags.String("output", "", "File to write (default user-<id>.json)")
+	var force bool
+	flags.BoolVar(&force, "force", false, "Overwrite the output file if it exists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return fmt.Errorf("--id is required")
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("user-%d.json", *id)
+	}
+
+	path := "/users/export?userId=" + strconv.Itoa(*id)
+	resp, err := client.Get(ctx, path, nil)
+	if err != nil {
+		return yesterdaygo.NewNetworkError(fmt.Sprintf("GET %s failed", path), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return yesterdaygo.WrapHTTPError(resp, fmt.Sprintf("GET %s failed", path))
+	}
+
+	// The archive holds personal data, so it is only readable by its owner
+	// and an existing file is not replaced by accident.
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(*output, mode, 0o600)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+
+	result := exportResult{UserID: *id, Path: *output, Size: size}
+	return printResult(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Exported the data of user %d to %s (%d bytes)\n", result.UserID, result.Path, result.Size)
+	})
+}
+
+func runForgetUser(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("forgetuser")
+	id := flags.Int("id", 0, "ID of the user to forget")
+	var force bool
+	addForceFlags(flags, &force)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return fmt.Errorf("--id is required")
+	}
+	if *id == 1 {
+		return fmt.Errorf("refusing to forget the admin user")
+	}
+
+	target, err := findUser(ctx, client, *id)
+	if err != nil {
+		return err
+	}
+	if !force {
+		if err := confirm(fmt.Sprintf("About to forget user %s [%d]: their sessions are deleted and their personal data is erased from every application.", target.Username, target.ID)); err != nil {
+			return err
+		}
+	}
+
+	path := "/users/forget?userId=" + strconv.Itoa(*id)
+	resp, err := client.Post(ctx, path, nil, nil)
+	if err != nil {
+		return yesterdaygo.NewNetworkError(fmt.Sprintf("POST %s failed", path), err)
+	}
+	defer resp.Body.Close()
+	// A 502 carries the result of a partial deletion, naming the
+	// applications that failed
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadGateway {
+		return yesterdaygo.WrapHTTPError(resp, fmt.Sprintf("POST %s failed", path))
+	}
+	var result forgetResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return yesterdaygo.NewErrorWithCause(yesterdaygo.ErrorTypeAPI, "failed to decode response", err)
+	}
+
+	if err := printResult(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Forgot user %d in %d applications, redacted %d events\n", result.UserID, len(result.Applications), result.RedactedEvents)
+	}); err != nil {
+		return err
+	}
+	if len(result.Failed) > 0 {
+		failed := make([]string, 0, len(result.Failed))
+		for instanceID := range result.Failed {
+			failed = append(failed, instanceID)
+		}
+		sort.Strings(failed)
+		for _, instanceID := range failed {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", instanceID, result.Failed[instanceID])
+		}
+		return fmt.Errorf("%d applications failed to forget the user; run forgetuser again to retry them", len(failed))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// user is a user record from the Admin app's /api/users data view.
+type user struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+func fetchUsers(ctx context.Context, client *yesterdaygo.Client) ([]user, error) {
+	var data struct {
+		Users []user `json:"users"`
+	}
+	if err := getJSON(ctx, client, client.AppPath(adminInstanceID, "api/users"), &data); err != nil {
+		return nil, err
+	}
+	if data.Users == nil {
+		data.Users = []user{}
+	}
+	return data.Users, nil
+}
+
+func runListUsers(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	flags := newFlagSet("listusers")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	users, err := fetchUsers(ctx, client)
+	if err != nil {
+		return err
+	}
+	return printResult(users, func(w io.Writer) {
+		for _, u := range users {
+			fmt.Fprintf(w, "- %s [%d]\n", u.Username, u.ID)
+		}
+	})
+}
+
+func runGetUserProfile(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	var id int
+	var username string
+	flags := newFlagSet("getuserprofile")
+	flags.IntVar(&id, "id", 0, "ID of the user")
+	flags.StringVar(&username, "username", "", "Username of the user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (id == 0) == (username == "") {
+		fmt.Fprintln(flags.Output(), "Exactly one of --id or --username is required")
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	users, err := fetchUsers(ctx, client)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if (id != 0 && u.ID == id) || (username != "" && u.Username == username) {
+			return printResult(u, func(w io.Writer) {
+				fmt.Fprintf(w, "ID:       %d\n", u.ID)
+				fmt.Fprintf(w, "Username: %s\n", u.Username)
+			})
+		}
+	}
+	return fmt.Errorf("user not found")
+}
+
+func runRestoreUser(ctx context.Context, client *yesterdaygo.Client, args []string) error {
+	var id int
+	flags := newFlagSet("restoreuser")
+	flags.IntVar(&id, "id", 0, "ID of the deleted user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if id <= 0 {
+		fmt.Fprintln(flags.Output(), "--id is required")
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	// Deleted users can be restored until they are purged, or until their
+	// username is taken by a new user
+	event := map[string]int{"userId": id}
+	if err := publishEvent(ctx, client, "User:Restore", event); err != nil {
+		return err
+	}
+	return printResult(event, func(w io.Writer) {
+		fmt.Fprintf(w, "Restored user %d\n", id)
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// options configures the generated file.
+type options struct {
+	Package    string
+	ClientType string
+	Source     string
+}
+
+// generate renders the typed client for a manifest. Endpoints and types are
+// emitted in sorted order and the result is gofmt'ed, so the same manifest
+// always produces the same file.
+func generate(m *manifest, opts options) ([]byte, error) {
+	endpoints := append([]endpoint{}, m.Endpoints...)
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Name < endpoints[j].Name
+	})
+	objects := append([]object{}, m.Types...)
+	sort.Slice(objects, func(i, j int) bool {
+		return exported(objects[i].Name) < exported(objects[j].Name)
+	})
+
+	g := &generator{}
+	for _, obj := range objects {
+		g.object(obj)
+	}
+	g.client(opts.ClientType)
+	seen := make(map[string]bool)
+	for _, ep := range endpoints {
+		name := exported(ep.Name)
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate endpoint name %s", name)
+		}
+		seen[name] = true
+		if methodConst(ep.Method) == "" {
+			return nil, fmt.Errorf("endpoint %s uses unsupported method %s", name, ep.Method)
+		}
+		g.endpoint(opts.ClientType, ep)
+	}
+	g.helpers(opts.ClientType)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by genclient from %s. DO NOT EDIT.\n\n", opts.Source)
+	fmt.Fprintf(&out, "package %s\n\n", opts.Package)
+	out.WriteString("import (\n\t\"context\"\n\t\"encoding/json\"\n\t\"net/http\"\n")
+	if g.usesURL {
+		out.WriteString("\t\"net/url\"\n")
+	}
+	if g.usesTime {
+		out.WriteString("\t\"time\"\n")
+	}
+	out.WriteString("\n\tyesterdaygo \"github.com/tomyedwab/yesterday/clients/go\"\n)\n")
+	out.Write(g.body.Bytes())
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %w", err)
+	}
+	return formatted, nil
+}
+
+type generator struct {
+	body     bytes.Buffer
+	usesURL  bool
+	usesTime bool
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+func (g *generator) goType(t *typeRef) string {
+	switch t.Kind {
+	case "string":
+		return "string"
+	case "int":
+		return "int"
+	case "float":
+		return "float64"
+	case "bool":
+		return "bool"
+	case "time":
+		g.usesTime = true
+		return "time.Time"
+	case "bytes":
+		return "[]byte"
+	case "array":
+		return "[]" + g.goType(t.Elem)
+	case "map":
+		return "map[string]" + g.goType(t.Elem)
+	case "object":
+		return exported(t.Name)
+	default:
+		return "json.RawMessage"
+	}
+}
+
+func (g *generator) object(obj object) {
+	name := exported(obj.Name)
+	g.printf("\n// %s is a type exchanged with the API.\n", name)
+	g.printf("type %s struct {\n", name)
+	for _, f := range obj.Fields {
+		tag := f.JSON
+		if f.Optional {
+			tag += ",omitempty"
+		}
+		g.printf("\t%s %s `json:%q`\n", f.Name, g.goType(&f.Type), tag)
+	}
+	g.printf("}\n")
+}
+
+func (g *generator) client(clientType string) {
+	g.printf(`
+// %[1]s calls the application's API endpoints through a yesterdaygo.Client.
+type %[1]s struct {
+	client     *yesterdaygo.Client
+	instanceID string
+}
+
+// New%[1]s returns a client for the application instance with the given ID.
+func New%[1]s(client *yesterdaygo.Client, instanceID string) *%[1]s {
+	return &%[1]s{client: client, instanceID: instanceID}
+}
+`, clientType)
+}
+
+// pathParam is a {name} segment of an endpoint path.
+type pathParam struct {
+	name  string
+	index int
+}
+
+func pathParams(path string) []pathParam {
+	var params []pathParam
+	for i, segment := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := strings.TrimSuffix(strings.Trim(segment, "{}"), "...")
+			params = append(params, pathParam{name: identifier(name), index: i})
+		}
+	}
+	return params
+}
+
+// pathExpr returns a Go expression building the request path relative to the
+// instance.
+func (g *generator) pathExpr(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	params := pathParams(path)
+	if len(params) == 0 {
+		return fmt.Sprintf("%q", strings.Join(segments, "/"))
+	}
+	g.usesURL = true
+	var parts []string
+	literal := ""
+	next := 0
+	for i, segment := range segments {
+		if i > 0 {
+			literal += "/"
+		}
+		if next < len(params) && params[next].index == i {
+			if literal != "" {
+				parts = append(parts, fmt.Sprintf("%q", literal))
+			}
+			parts = append(parts, "url.PathEscape("+params[next].name+")")
+			literal = ""
+			next++
+			continue
+		}
+		literal += segment
+	}
+	if literal != "" {
+		parts = append(parts, fmt.Sprintf("%q", literal))
+	}
+	return strings.Join(parts, " + ")
+}
+
+func (g *generator) endpoint(clientType string, ep endpoint) {
+	name := exported(ep.Name)
+	method := methodConst(ep.Method)
+	path := g.pathExpr(ep.Path)
+
+	args := []string{"ctx context.Context"}
+	for _, param := range pathParams(ep.Path) {
+		args = append(args, param.name+" string")
+	}
+	body := "nil"
+	if ep.Request != nil {
+		args = append(args, "request "+g.goType(ep.Request))
+		body = "request"
+	}
+
+	g.printf("\n// %s calls %s %s.\n", name, ep.Method, ep.Path)
+	if ep.Response == nil {
+		g.printf("func (c *%s) %s(%s) error {\n", clientType, name, strings.Join(args, ", "))
+		g.printf("\treturn c.do(ctx, %s, %s, %s, nil)\n}\n", method, path, body)
+	} else {
+		result := g.goType(ep.Response)
+		g.printf("func (c *%s) %s(%s) (%s, error) {\n", clientType, name, strings.Join(args, ", "), result)
+		g.printf("\tvar result %s\n", result)
+		g.printf("\terr := c.do(ctx, %s, %s, %s, &result)\n", method, path, body)
+		g.printf("\treturn result, err\n}\n")
+	}
+
+	if ep.DataView && ep.Response != nil && ep.Method == "GET" && len(pathParams(ep.Path)) == 0 {
+		result := g.goType(ep.Response)
+		g.printf("\n// %sProvider returns a DataProvider for %s %s that refreshes when the\n// application processes new events.\n", name, ep.Method, ep.Path)
+		g.printf("func (c *%s) %sProvider(params map[string]interface{}, opts ...yesterdaygo.DataProviderOption) *yesterdaygo.DataProvider[%s] {\n", clientType, name, result)
+		g.printf("\treturn yesterdaygo.NewDataProvider[%s](c.client, c.instanceID, %s, params, opts...)\n}\n", result, path)
+	}
+}
+
+func (g *generator) helpers(clientType string) {
+	g.printf(`
+// do sends a request to the instance and decodes the JSON response into
+// result, if it is not nil.
+func (c *%s) do(ctx context.Context, method, path string, body any, result any) error {
+	path = c.client.AppPath(c.instanceID, path)
+	var resp *http.Response
+	var err error
+	switch method {
+	case http.MethodGet:
+		resp, err = c.client.Get(ctx, path, nil)
+	case http.MethodPut:
+		resp, err = c.client.Put(ctx, path, body, nil)
+	case http.MethodDelete:
+		resp, err = c.client.Delete(ctx, path, nil)
+	case http.MethodPost:
+		resp, err = c.client.Post(ctx, path, body, nil)
+	}
+	if err != nil {
+		return yesterdaygo.NewNetworkError(method+" "+path+" failed", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return yesterdaygo.WrapHTTPError(resp, method+" "+path+" failed")
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return yesterdaygo.NewErrorWithCause(yesterdaygo.ErrorTypeAPI, "failed to decode response", err)
+	}
+	return nil
+}
+`, clientType)
+}
+
+func methodConst(method string) string {
+	switch strings.ToUpper(method) {
+	case "PUT":
+		return "http.MethodPut"
+	case "DELETE":
+		return "http.MethodDelete"
+	case "POST":
+		return "http.MethodPost"
+	case "GET":
+		return "http.MethodGet"
+	default:
+		return ""
+	}
+}
+
+// exported turns a manifest name into an exported Go identifier.
+func exported(name string) string {
+	name = identifier(name)
+	if name == "" {
+		return "X"
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// identifier drops characters that are not valid in a Go identifier.
+func identifier(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	result := b.String()
+	if result != "" && unicode.IsDigit([]rune(result)[0]) {
+		result = "_" + result
+	}
+	return result
+}
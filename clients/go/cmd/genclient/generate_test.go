@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestGenerateGolden compares the client generated for each manifest in
+// testdata with its .golden file. Run with -update to regenerate them.
+func TestGenerateGolden(t *testing.T) {
+	manifests, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) == 0 {
+		t.Fatal("No manifests found in testdata")
+	}
+
+	for _, path := range manifests {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			m, err := readManifest(path)
+			if err != nil {
+				t.Fatalf("readManifest returned error: %v", err)
+			}
+			code, err := generate(m, options{Package: name + "api", ClientType: "APIClient", Source: filepath.Base(path)})
+			if err != nil {
+				t.Fatalf("generate returned error: %v", err)
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, code, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(code, expected) {
+				t.Errorf("Generated code differs from %s; run go test -update and review the diff.\nGot:\n%s", golden, code)
+			}
+		})
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	m, err := readManifest(filepath.Join("testdata", "tasks.json"))
+	if err != nil {
+		t.Fatalf("readManifest returned error: %v", err)
+	}
+	opts := options{Package: "tasksapi", ClientType: "APIClient", Source: "tasks.json"}
+	first, err := generate(m, opts)
+	if err != nil {
+		t.Fatalf("generate returned error: %v", err)
+	}
+
+	// Reversing the manifest order must not change the output
+	for i, j := 0, len(m.Endpoints)-1; i < j; i, j = i+1, j-1 {
+		m.Endpoints[i], m.Endpoints[j] = m.Endpoints[j], m.Endpoints[i]
+	}
+	for i, j := 0, len(m.Types)-1; i < j; i, j = i+1, j-1 {
+		m.Types[i], m.Types[j] = m.Types[j], m.Types[i]
+	}
+	second, err := generate(m, opts)
+	if err != nil {
+		t.Fatalf("generate returned error: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("Expected output to be independent of manifest order")
+	}
+}
+
+func TestGenerateRejectsUnsupportedMethod(t *testing.T) {
+	m := &manifest{Endpoints: []endpoint{{Name: "Patch", Method: "PATCH", Path: "/api/thing"}}}
+	if _, err := generate(m, options{Package: "x", ClientType: "APIClient"}); err == nil {
+		t.Error("Expected an error for an unsupported method")
+	}
+}
@@ -0,0 +1,56 @@
+// Command genclient generates a typed API client for a Yesterday application
+// from the endpoint manifest it serves at /internal/api-manifest (see
+// applib.HandleAPI).
+//
+// It is meant to be run from go:generate against a checked-in copy of the
+// manifest, so regenerating is reproducible:
+//
+//	//go:generate go run github.com/tomyedwab/yesterday/clients/go/cmd/genclient -manifest api-manifest.json -package adminapi -o client.go
+//
+// The generated file declares the manifest's types, a client struct with one
+// method per endpoint, and DataProvider constructors for data view endpoints.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	manifestSource := flag.String("manifest", "", "Manifest file or URL (required)")
+	packageName := flag.String("package", "", "Package name of the generated file (required)")
+	clientType := flag.String("type", "APIClient", "Name of the generated client type")
+	output := flag.String("o", "", "Output file (default stdout)")
+	flag.Parse()
+
+	if *manifestSource == "" || *packageName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := readManifest(*manifestSource)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "genclient: %v\n", err)
+		os.Exit(1)
+	}
+	code, err := generate(m, options{
+		Package:    *packageName,
+		ClientType: *clientType,
+		Source:     filepath.Base(*manifestSource),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "genclient: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := os.WriteFile(*output, code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "genclient: %v\n", err)
+		os.Exit(1)
+	}
+}
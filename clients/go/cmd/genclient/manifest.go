@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The manifest types mirror applib's APIManifest, which applications serve at
+// /internal/api-manifest. They are duplicated here so the client module does
+// not depend on the server library.
+
+type manifest struct {
+	Endpoints []endpoint `json:"endpoints"`
+	Types     []object   `json:"types"`
+}
+
+type endpoint struct {
+	Name     string   `json:"name"`
+	Method   string   `json:"method"`
+	Path     string   `json:"path"`
+	Request  *typeRef `json:"request,omitempty"`
+	Response *typeRef `json:"response,omitempty"`
+	DataView bool     `json:"dataView,omitempty"`
+}
+
+type object struct {
+	Name   string  `json:"name"`
+	Fields []field `json:"fields"`
+}
+
+type field struct {
+	Name     string  `json:"name"`
+	JSON     string  `json:"json"`
+	Type     typeRef `json:"type"`
+	Optional bool    `json:"optional,omitempty"`
+}
+
+type typeRef struct {
+	Kind string   `json:"kind"`
+	Name string   `json:"name,omitempty"`
+	Elem *typeRef `json:"elem,omitempty"`
+}
+
+// readManifest reads a manifest from a file, or fetches it if source is an
+// http(s) URL.
+func readManifest(source string) (*manifest, error) {
+	var reader io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch manifest: %s", resp.Status)
+		}
+		reader = resp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var m manifest
+	if err := json.NewDecoder(reader).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &m, nil
+}
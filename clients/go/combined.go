@@ -0,0 +1,411 @@
+package yesterdaygo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CombinedSource is a provider a CombinedDataProvider reads from.
+// *DataProvider[T] implements it for any T.
+type CombinedSource interface {
+	sourceInstanceID() string
+	sourceName() string
+	sourceEvents() EventSource
+	refreshIfStale() error
+}
+
+func (dp *DataProvider[T]) sourceInstanceID() string {
+	return dp.instanceID
+}
+
+func (dp *DataProvider[T]) sourceName() string {
+	return dp.instanceID + "/" + dp.uri
+}
+
+func (dp *DataProvider[T]) sourceEvents() EventSource {
+	return dp.events
+}
+
+func (dp *DataProvider[T]) refreshIfStale() error {
+	_, err := dp.Get()
+	return err
+}
+
+// Cached returns the data from the last successful fetch without refreshing
+// it, or the zero value if nothing has been fetched yet.
+func (dp *DataProvider[T]) Cached() T {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+	return dp.data
+}
+
+// WithPartialResults makes a CombinedDataProvider combine the results even
+// when some of its sources fail to refresh. Failed sources contribute the
+// data from their last successful fetch, and the failures are reported with
+// a *CombinedFetchError alongside the combined value. Without it, any
+// failure fails the whole refresh. Other providers ignore it.
+func WithPartialResults() DataProviderOption {
+	return func(c *dataProviderConfig) {
+		c.partialResults = true
+	}
+}
+
+// CombinedFetchError reports the sources of a CombinedDataProvider that
+// failed to refresh, keyed by "instanceID/uri".
+type CombinedFetchError struct {
+	Errors map[string]error
+}
+
+func (e *CombinedFetchError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = fmt.Sprintf("%s: %v", name, e.Errors[name])
+	}
+	return fmt.Sprintf("failed to refresh %d of the combined sources: %s", len(names), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the failed sources, for errors.Is and
+// errors.As.
+func (e *CombinedFetchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// CombinedDataProvider combines the data of several DataProviders into one
+// typed value, refreshing it whenever an event is processed by any of the
+// sources' application instances.
+type CombinedDataProvider[T any] struct {
+	sources    []CombinedSource
+	instances  []string
+	combine    func() (T, error)
+	events     EventSource
+	minRefetch time.Duration
+	partial    bool
+
+	data            T
+	lastEventIds    map[string]int // nil until a refresh succeeds
+	refreshCallback func(T)
+	mu              sync.RWMutex // Protects data, lastEventIds, and refreshCallback
+
+	ctx            context.Context
+	cancel         context.CancelFunc
+	isSubscribed   bool
+	subscriptionMu sync.Mutex // Protects subscription state
+}
+
+// NewCombinedDataProvider creates a provider whose value is computed by
+// combine from the given sources. Sources are refreshed as needed before
+// combine is called, so it should read them with Cached:
+//
+//	users := NewDataProvider[UserList](client, adminID, "api/users", nil)
+//	apps := NewDataProvider[AppList](client, adminID, "api/applications", nil)
+//	dashboard := NewCombinedDataProvider([]CombinedSource{users, apps}, func() (Dashboard, error) {
+//		return Dashboard{Users: users.Cached(), Apps: apps.Cached()}, nil
+//	})
+//
+// WithEventSource, WithMinRefetchInterval and WithPartialResults apply.
+func NewCombinedDataProvider[T any](sources []CombinedSource, combine func() (T, error), opts ...DataProviderOption) *CombinedDataProvider[T] {
+	config := &dataProviderConfig{minRefetchInterval: DefaultMinRefetchInterval}
+	for _, opt := range opts {
+		opt(config)
+	}
+	events := config.events
+	var instances []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		if events == nil {
+			events = source.sourceEvents()
+		}
+		if instanceID := source.sourceInstanceID(); !seen[instanceID] {
+			seen[instanceID] = true
+			instances = append(instances, instanceID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CombinedDataProvider[T]{
+		sources:    sources,
+		instances:  instances,
+		combine:    combine,
+		events:     events,
+		minRefetch: config.minRefetchInterval,
+		partial:    config.partialResults,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// currentEventIds returns the latest event ID of each source instance.
+func (cp *CombinedDataProvider[T]) currentEventIds() map[string]int {
+	eventIds := make(map[string]int, len(cp.instances))
+	for _, instanceID := range cp.instances {
+		eventIds[instanceID] = cp.events.GetCurrentEventId(instanceID)
+	}
+	return eventIds
+}
+
+// Get returns the combined data, refreshing it if an event has been
+// processed by any source instance since it was last combined. With
+// WithPartialResults, a partial value is returned together with a
+// *CombinedFetchError.
+func (cp *CombinedDataProvider[T]) Get() (T, error) {
+	cp.mu.RLock()
+	needsRefresh := cp.lastEventIds == nil
+	for instanceID, eventId := range cp.lastEventIds {
+		if eventId < cp.events.GetCurrentEventId(instanceID) {
+			needsRefresh = true
+		}
+	}
+	cachedData := cp.data
+	cp.mu.RUnlock()
+
+	if !needsRefresh {
+		return cachedData, nil
+	}
+	if err := cp.Refresh(); err != nil {
+		if _, partial := err.(*CombinedFetchError); partial && cp.partial {
+			return cp.Cached(), err
+		}
+		var zero T
+		return zero, fmt.Errorf("failed to refresh data: %w", err)
+	}
+	return cp.Cached(), nil
+}
+
+// Cached returns the last combined value without refreshing it.
+func (cp *CombinedDataProvider[T]) Cached() T {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	return cp.data
+}
+
+// Refresh refreshes the stale sources concurrently and combines their data.
+func (cp *CombinedDataProvider[T]) Refresh() error {
+	data, eventIds, err := cp.fetch(cp.currentEventIds())
+	if data != nil {
+		cp.store(*data, eventIds, true)
+	}
+	return err
+}
+
+// fetch refreshes the sources and combines them. eventIds must be recorded
+// before fetching, like in DataProvider.Refresh. The returned data is nil if
+// nothing could be combined, and the event IDs are nil if any source failed,
+// so that the next Get tries again.
+func (cp *CombinedDataProvider[T]) fetch(eventIds map[string]int) (*T, map[string]int, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[string]error)
+	for _, source := range cp.sources {
+		wg.Add(1)
+		go func(source CombinedSource) {
+			defer wg.Done()
+			if err := source.refreshIfStale(); err != nil {
+				mu.Lock()
+				failed[source.sourceName()] = err
+				mu.Unlock()
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	var fetchErr error
+	if len(failed) > 0 {
+		fetchErr = &CombinedFetchError{Errors: failed}
+		if !cp.partial {
+			return nil, nil, fetchErr
+		}
+		eventIds = nil
+	}
+
+	combined, err := cp.combine()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to combine data: %w", err)
+	}
+	return &combined, eventIds, fetchErr
+}
+
+// store updates the cached data and, if notify is set, calls the refresh
+// callback. A nil eventIds leaves the recorded event IDs unchanged.
+func (cp *CombinedDataProvider[T]) store(data T, eventIds map[string]int, notify bool) {
+	cp.mu.Lock()
+	cp.data = data
+	if eventIds != nil {
+		cp.lastEventIds = eventIds
+	}
+	callback := cp.refreshCallback
+	cp.mu.Unlock()
+
+	if notify && callback != nil {
+		callback(data)
+	}
+}
+
+// Subscribe registers a callback called with the combined data whenever it
+// is refreshed after an event. Refreshes that fail are retried on the next
+// event; with WithPartialResults the callback receives partial values.
+func (cp *CombinedDataProvider[T]) Subscribe(callback func(T)) error {
+	cp.subscriptionMu.Lock()
+	defer cp.subscriptionMu.Unlock()
+
+	if cp.isSubscribed {
+		return fmt.Errorf("data provider is already subscribed")
+	}
+
+	cp.mu.Lock()
+	cp.refreshCallback = callback
+	cp.mu.Unlock()
+
+	notifications := make(chan eventNotification)
+	for _, instanceID := range cp.instances {
+		go cp.forwardEvents(instanceID, cp.events.SubscribeToEvents(instanceID), notifications)
+	}
+	cp.isSubscribed = true
+
+	go cp.eventLoop(notifications)
+
+	return nil
+}
+
+type eventNotification struct {
+	instanceID string
+	eventId    int
+}
+
+// forwardEvents sends the notifications for one instance to the event loop.
+func (cp *CombinedDataProvider[T]) forwardEvents(instanceID string, subscription <-chan int, notifications chan<- eventNotification) {
+	for {
+		select {
+		case eventId, ok := <-subscription:
+			if !ok {
+				return
+			}
+			select {
+			case notifications <- eventNotification{instanceID: instanceID, eventId: eventId}:
+			case <-cp.ctx.Done():
+				return
+			}
+		case <-cp.ctx.Done():
+			return
+		}
+	}
+}
+
+type combinedFetchResult[T any] struct {
+	data     *T
+	eventIds map[string]int
+}
+
+// eventLoop refreshes the combined data when any source instance processes
+// an event, coalescing bursts like DataProvider.eventLoop: at most one
+// refresh is in flight, refreshes start at least minRefetch apart, and a
+// refresh that is already stale when it completes does not call the
+// callback.
+func (cp *CombinedDataProvider[T]) eventLoop(notifications <-chan eventNotification) {
+	var (
+		dirty     bool
+		fetching  bool
+		requested map[string]int // Event IDs observed by the last refresh started
+		lastFetch time.Time
+		timer     *time.Timer
+		timerC    <-chan time.Time
+		fetchDone = make(chan combinedFetchResult[T], 1)
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	cp.mu.RLock()
+	requested = cp.lastEventIds
+	cp.mu.RUnlock()
+
+	maybeFetch := func() {
+		if !dirty || fetching || timerC != nil {
+			return
+		}
+		if wait := cp.minRefetch - time.Since(lastFetch); wait > 0 {
+			timer = time.NewTimer(wait)
+			timerC = timer.C
+			return
+		}
+		dirty = false
+		fetching = true
+		lastFetch = time.Now()
+		requested = cp.currentEventIds()
+		go func(eventIds map[string]int) {
+			data, eventIds, _ := cp.fetch(eventIds)
+			fetchDone <- combinedFetchResult[T]{data: data, eventIds: eventIds}
+		}(requested)
+	}
+
+	for {
+		select {
+		case n := <-notifications:
+			if requested == nil || n.eventId > requested[n.instanceID] {
+				dirty = true
+				maybeFetch()
+			}
+		case <-timerC:
+			timerC = nil
+			maybeFetch()
+		case result := <-fetchDone:
+			fetching = false
+			if result.data != nil {
+				cp.store(*result.data, result.eventIds, !dirty)
+			}
+			if result.eventIds == nil {
+				// Retry on the next notification
+				cp.mu.RLock()
+				requested = cp.lastEventIds
+				cp.mu.RUnlock()
+			}
+			maybeFetch()
+		case <-cp.ctx.Done():
+			return // Subscription cancelled
+		}
+	}
+}
+
+// Unsubscribe stops automatic data refresh notifications
+func (cp *CombinedDataProvider[T]) Unsubscribe() {
+	cp.subscriptionMu.Lock()
+	defer cp.subscriptionMu.Unlock()
+
+	if !cp.isSubscribed {
+		return
+	}
+
+	cp.isSubscribed = false
+	cp.cancel()
+
+	cp.mu.Lock()
+	cp.refreshCallback = nil
+	cp.mu.Unlock()
+}
+
+// IsSubscribed returns whether the data provider is subscribed to events
+func (cp *CombinedDataProvider[T]) IsSubscribed() bool {
+	cp.subscriptionMu.Lock()
+	defer cp.subscriptionMu.Unlock()
+	return cp.isSubscribed
+}
+
+// Close cleans up the data provider resources. The sources are not closed.
+func (cp *CombinedDataProvider[T]) Close() {
+	cp.Unsubscribe()
+	cp.cancel()
+}
@@ -0,0 +1,126 @@
+package yesterdaygo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type dashboard struct {
+	Users  []userSummary
+	Apps   []string
+	Events int64
+}
+
+// newDashboardServer serves users and applications for the "test" instance.
+// The applications endpoint fails while failApps is set, and both report
+// the mock poller's event number.
+func newDashboardServer(t *testing.T, poller *MockEventPoller, failApps *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/api/users":
+			json.NewEncoder(w).Encode(userSummaries{Users: []userSummary{{ID: int(poller.GetCurrentEventNumber()), Username: "admin"}}})
+		case "/test/api/apps":
+			if failApps.Load() {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode([]string{"admin", "login"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestDashboard(t *testing.T, server *httptest.Server, poller *MockEventPoller, opts ...DataProviderOption) *CombinedDataProvider[dashboard] {
+	client := NewClient(server.URL)
+	users := NewDataProvider[userSummaries](client, "test", "api/users", nil, WithEventSource(poller.EventSource()))
+	apps := NewDataProvider[[]string](client, "test", "api/apps", nil, WithEventSource(poller.EventSource()))
+	t.Cleanup(users.Close)
+	t.Cleanup(apps.Close)
+	combined := NewCombinedDataProvider([]CombinedSource{users, apps}, func() (dashboard, error) {
+		return dashboard{Users: users.Cached().Users, Apps: apps.Cached()}, nil
+	}, opts...)
+	t.Cleanup(combined.Close)
+	return combined
+}
+
+func TestCombinedDataProviderRefreshesOnEvents(t *testing.T) {
+	poller := NewMockEventPoller(nil)
+	var failApps atomic.Bool
+	server := newDashboardServer(t, poller, &failApps)
+	combined := newTestDashboard(t, server, poller, WithMinRefetchInterval(10*time.Millisecond))
+
+	data, err := combined.Get()
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if len(data.Users) != 1 || len(data.Apps) != 2 {
+		t.Fatalf("Expected users and applications to be combined, got %+v", data)
+	}
+
+	delivered := make(chan dashboard, 10)
+	if err := combined.Subscribe(func(data dashboard) { delivered <- data }); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+	poller.TriggerEvent(3)
+	select {
+	case data := <-delivered:
+		if len(data.Users) != 1 || data.Users[0].ID != 3 || len(data.Apps) != 2 {
+			t.Errorf("Expected the data refreshed at event 3, got %+v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the refreshed data")
+	}
+}
+
+func TestCombinedDataProviderFailsWhole(t *testing.T) {
+	poller := NewMockEventPoller(nil)
+	var failApps atomic.Bool
+	failApps.Store(true)
+	server := newDashboardServer(t, poller, &failApps)
+	combined := newTestDashboard(t, server, poller)
+
+	data, err := combined.Get()
+	var fetchErr *CombinedFetchError
+	if !errors.As(err, &fetchErr) || len(fetchErr.Errors) != 1 || fetchErr.Errors["test/api/apps"] == nil {
+		t.Fatalf("Expected the applications fetch to be reported, got %v", err)
+	}
+	if data.Users != nil {
+		t.Errorf("Expected no data when a source fails, got %+v", data)
+	}
+
+	// The next Get tries again
+	failApps.Store(false)
+	if data, err := combined.Get(); err != nil || len(data.Apps) != 2 {
+		t.Errorf("Expected the retry to succeed, got %+v: %v", data, err)
+	}
+}
+
+func TestCombinedDataProviderPartialResults(t *testing.T) {
+	poller := NewMockEventPoller(nil)
+	var failApps atomic.Bool
+	failApps.Store(true)
+	server := newDashboardServer(t, poller, &failApps)
+	combined := newTestDashboard(t, server, poller, WithPartialResults())
+
+	data, err := combined.Get()
+	var fetchErr *CombinedFetchError
+	if !errors.As(err, &fetchErr) {
+		t.Fatalf("Expected a CombinedFetchError, got %v", err)
+	}
+	if len(data.Users) != 1 || data.Apps != nil {
+		t.Errorf("Expected the users without applications, got %+v", data)
+	}
+
+	failApps.Store(false)
+	if data, err := combined.Get(); err != nil || len(data.Apps) != 2 {
+		t.Errorf("Expected the retry to fill in the applications, got %+v: %v", data, err)
+	}
+}
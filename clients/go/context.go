@@ -0,0 +1,103 @@
+package yesterdaygo
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// WithBaseContext sets a context that every request made by the client
+// inherits, e.g. one carrying a tracing span or an overall deadline. Each
+// request runs under a context derived from both the base context and the
+// per-call context passed to the method:
+//
+//   - Values are looked up in the per-call context first, so per-call values
+//     shadow base values with the same key.
+//   - The request is cancelled as soon as either context is done.
+//   - The effective deadline is the earlier of the two.
+//
+// The background EventPoller and EventPublisher also run under the base
+// context and shut down when it is cancelled.
+func WithBaseContext(ctx context.Context) ClientOption {
+	return func(c *Client) {
+		c.baseCtx = ctx
+	}
+}
+
+// BaseContext returns the context set with WithBaseContext, or
+// context.Background() if none was set.
+func (c *Client) BaseContext() context.Context {
+	if c.baseCtx == nil {
+		return context.Background()
+	}
+	return c.baseCtx
+}
+
+// mergedValues is a context whose values come from the per-call context and
+// fall back to the base context.
+type mergedValues struct {
+	context.Context
+	base context.Context
+}
+
+func (m mergedValues) Value(key any) any {
+	if value := m.Context.Value(key); value != nil {
+		return value
+	}
+	return m.base.Value(key)
+}
+
+// requestContext combines the per-call context with the client's base
+// context. The returned release function must be called once the request,
+// including reading its response body, is finished.
+func (c *Client) requestContext(ctx context.Context) (context.Context, func()) {
+	base := c.baseCtx
+	if base == nil || base == ctx {
+		return ctx, func() {}
+	}
+
+	merged, cancel := context.WithCancelCause(mergedValues{Context: ctx, base: base})
+	stop := context.AfterFunc(base, func() {
+		cancel(context.Cause(base))
+	})
+	release := func() {
+		stop()
+		cancel(context.Canceled)
+	}
+
+	if deadline, ok := base.Deadline(); ok {
+		withDeadline, cancelDeadline := context.WithDeadline(merged, deadline)
+		releaseMerged := release
+		merged = withDeadline
+		release = func() {
+			cancelDeadline()
+			releaseMerged()
+		}
+	}
+	return merged, release
+}
+
+// releaseOnClose releases a request context when the response body is closed,
+// so that callers can keep reading the body after the request method returns.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
+// doWithRelease sends the request and ties the release of its context to the
+// response body.
+func (c *Client) doWithRelease(req *http.Request, release func()) (*http.Response, error) {
+	resp, err := c.GetHTTPClient().Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
@@ -0,0 +1,133 @@
+package yesterdaygo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type contextKey string
+
+func newBaseContextClient(t *testing.T, baseURL string, base context.Context) *Client {
+	client := NewClient(baseURL,
+		WithBaseContext(base),
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	t.Cleanup(func() {
+		client.GetEventPoller().StopEventPolling()
+		client.GetEventPublisher().Stop()
+	})
+	return client
+}
+
+func TestRequestContextMergesValues(t *testing.T) {
+	base := context.WithValue(context.Background(), contextKey("trace"), "base-trace")
+	base = context.WithValue(base, contextKey("tenant"), "base-tenant")
+	client := newBaseContextClient(t, "http://unused", base)
+
+	call := context.WithValue(context.Background(), contextKey("tenant"), "call-tenant")
+	ctx, release := client.requestContext(call)
+	defer release()
+
+	if got := ctx.Value(contextKey("trace")); got != "base-trace" {
+		t.Errorf("expected base value to be inherited, got %v", got)
+	}
+	if got := ctx.Value(contextKey("tenant")); got != "call-tenant" {
+		t.Errorf("expected per-call value to take precedence, got %v", got)
+	}
+}
+
+func TestRequestContextUsesEarlierDeadline(t *testing.T) {
+	baseDeadline := time.Now().Add(time.Hour)
+	base, cancelBase := context.WithDeadline(context.Background(), baseDeadline)
+	defer cancelBase()
+	client := newBaseContextClient(t, "http://unused", base)
+
+	callDeadline := time.Now().Add(time.Minute)
+	call, cancelCall := context.WithDeadline(context.Background(), callDeadline)
+	defer cancelCall()
+	ctx, release := client.requestContext(call)
+	if deadline, _ := ctx.Deadline(); !deadline.Equal(callDeadline) {
+		t.Errorf("expected per-call deadline %v, got %v", callDeadline, deadline)
+	}
+	release()
+
+	ctx, release = client.requestContext(context.Background())
+	defer release()
+	if deadline, _ := ctx.Deadline(); !deadline.Equal(baseDeadline) {
+		t.Errorf("expected base deadline %v, got %v", baseDeadline, deadline)
+	}
+}
+
+func TestBaseContextCancelsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	base, cancelBase := context.WithCancel(context.Background())
+	client := newBaseContextClient(t, server.URL, base)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Get(context.Background(), "/slow", nil)
+		errCh <- err
+	}()
+
+	<-started
+	cancelBase()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled with the base context")
+	}
+}
+
+func TestBaseContextKeepsBodyReadable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	t.Cleanup(server.Close)
+
+	client := newBaseContextClient(t, server.URL, context.Background())
+	resp, err := client.Get(context.Background(), "/", nil)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("expected body %q, got %q (err %v)", "hello", body, err)
+	}
+}
+
+func TestBaseContextStopsBackgroundWorkers(t *testing.T) {
+	base, cancelBase := context.WithCancel(context.Background())
+	client := newBaseContextClient(t, "http://unused", base)
+
+	if !client.GetEventPoller().IsRunning() || !client.GetEventPublisher().IsRunning() {
+		t.Fatal("expected poller and publisher to be running")
+	}
+	cancelBase()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.GetEventPoller().IsRunning() || client.GetEventPublisher().IsRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("poller and publisher did not stop after the base context was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
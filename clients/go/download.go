@@ -0,0 +1,206 @@
+package yesterdaygo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// downloadBufferSize is the chunk size used when streaming downloads, and so
+// roughly how often the progress callback is called.
+const downloadBufferSize = 32 * 1024
+
+// DigestHeader carries the hex SHA-256 of the complete file served by the
+// hub's download endpoints.
+const DigestHeader = "X-Content-Sha256"
+
+// ErrDigestMismatch is returned by DownloadToFile when the downloaded file
+// does not match the digest sent by the server.
+var ErrDigestMismatch = errors.New("downloaded file does not match its digest")
+
+// ProgressFunc reports how many bytes have been transferred so far. total is
+// -1 if the size is unknown, e.g. when the server sends no Content-Length.
+type ProgressFunc func(bytesWritten, total int64)
+
+// GetToWriter performs a GET request and streams the response body to dst
+// instead of reading it into memory, which suits large artifacts such as
+// package downloads or exports. If progress is non-nil it is called after
+// every chunk is written. Cancelling the context aborts the download
+// mid-stream. It returns the number of bytes written.
+func (c *Client) GetToWriter(ctx context.Context, path string, dst io.Writer, progress ProgressFunc) (int64, error) {
+	resp, err := c.Get(ctx, path, nil)
+	if err != nil {
+		return 0, NewNetworkError("download request failed", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, WrapHTTPError(resp, "download failed")
+	}
+
+	written, err := streamBody(ctx, resp.Body, dst, 0, resp.ContentLength, progress)
+	return written, err
+}
+
+// DownloadToFile downloads path to filename, resuming a previous attempt if
+// one was interrupted. Data is written to filename+".part" and the file is
+// only moved into place once complete. Resuming uses a Range request guarded
+// by If-Range, so if the file changed on the server the download starts
+// over. If the server sends a DigestHeader the complete file is verified
+// against it; on a mismatch the partial file is discarded and the error
+// wraps ErrDigestMismatch. progress, if non-nil, counts resumed bytes too.
+// It returns the size of the file.
+func (c *Client) DownloadToFile(ctx context.Context, path, filename string, progress ProgressFunc) (int64, error) {
+	partPath := filename + ".part"
+	etagPath := partPath + ".etag"
+
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, NewErrorWithCause(ErrorTypeUnknown, "failed to open partial download", err)
+	}
+	defer part.Close()
+
+	resp, offset, err := c.resumeDownload(ctx, path, part, etagPath)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		os.WriteFile(etagPath, []byte(etag), 0644)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	written, err := streamBody(ctx, resp.Body, part, offset, total, progress)
+	if err != nil {
+		return offset + written, err
+	}
+
+	if expected := resp.Header.Get(DigestHeader); expected != "" {
+		actual, err := fileDigest(part)
+		if err != nil {
+			return 0, NewErrorWithCause(ErrorTypeUnknown, "failed to verify download", err)
+		}
+		if !strings.EqualFold(actual, expected) {
+			part.Close()
+			os.Remove(partPath)
+			os.Remove(etagPath)
+			return 0, NewErrorWithCause(ErrorTypeValidation, fmt.Sprintf("expected SHA-256 %s, got %s", expected, actual), ErrDigestMismatch)
+		}
+	}
+
+	if err := part.Close(); err != nil {
+		return 0, NewErrorWithCause(ErrorTypeUnknown, "failed to write download", err)
+	}
+	if err := os.Rename(partPath, filename); err != nil {
+		return 0, NewErrorWithCause(ErrorTypeUnknown, "failed to move download into place", err)
+	}
+	os.Remove(etagPath)
+	return offset + written, nil
+}
+
+// resumeDownload requests the part of the file not yet in part, positions
+// part for writing the response body and returns the offset it starts at.
+func (c *Client) resumeDownload(ctx context.Context, path string, part *os.File, etagPath string) (*http.Response, int64, error) {
+	info, err := part.Stat()
+	if err != nil {
+		return nil, 0, NewErrorWithCause(ErrorTypeUnknown, "failed to open partial download", err)
+	}
+	offset := info.Size()
+	etag, _ := os.ReadFile(etagPath)
+	if len(etag) == 0 {
+		// Without an ETag there is no way to tell whether the partial data
+		// still matches the file on the server
+		offset = 0
+	}
+
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+		headers["If-Range"] = string(etag)
+	}
+	resp, err := c.Get(ctx, path, headers)
+	if err != nil {
+		return nil, 0, NewNetworkError("download request failed", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is at least as long as the file on the server,
+		// so it cannot be trusted. Start over.
+		resp.Body.Close()
+		os.Remove(etagPath)
+		if err := part.Truncate(0); err != nil {
+			return nil, 0, NewErrorWithCause(ErrorTypeUnknown, "failed to restart download", err)
+		}
+		return c.resumeDownload(ctx, path, part, etagPath)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// The server sent the whole file
+		offset = 0
+	default:
+		defer resp.Body.Close()
+		return nil, 0, WrapHTTPError(resp, "download failed")
+	}
+
+	if err := part.Truncate(offset); err != nil {
+		resp.Body.Close()
+		return nil, 0, NewErrorWithCause(ErrorTypeUnknown, "failed to resume download", err)
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		resp.Body.Close()
+		return nil, 0, NewErrorWithCause(ErrorTypeUnknown, "failed to resume download", err)
+	}
+	return resp, offset, nil
+}
+
+// streamBody copies body to dst in chunks, reporting progress as offset plus
+// the bytes copied so far. It returns the number of bytes copied.
+func streamBody(ctx context.Context, body io.Reader, dst io.Writer, offset, total int64, progress ProgressFunc) (int64, error) {
+	if progress != nil {
+		progress(offset, total)
+	}
+
+	var written int64
+	buf := make([]byte, downloadBufferSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, NewNetworkError("download cancelled", err)
+		}
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return written, NewErrorWithCause(ErrorTypeUnknown, "failed to write download", err)
+			}
+			written += int64(n)
+			if progress != nil {
+				progress(offset+written, total)
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, NewNetworkError("failed to read download", readErr)
+		}
+	}
+}
+
+func fileDigest(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
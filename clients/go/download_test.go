@@ -0,0 +1,191 @@
+package yesterdaygo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newDownloadTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	t.Cleanup(func() {
+		client.GetEventPoller().StopEventPolling()
+		client.GetEventPublisher().Stop()
+	})
+	return client
+}
+
+func TestGetToWriter(t *testing.T) {
+	payload := bytes.Repeat([]byte("yesterday"), 20000)
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		}
+		w.Write(payload)
+	})
+
+	for _, tc := range []struct {
+		path          string
+		expectedTotal int64
+	}{
+		{"/artifact", int64(len(payload))},
+		{"/artifact?chunked=1", -1},
+	} {
+		var dst bytes.Buffer
+		var calls int
+		var last int64
+		written, err := client.GetToWriter(context.Background(), tc.path, &dst, func(bytesWritten, total int64) {
+			calls++
+			if bytesWritten < last {
+				t.Errorf("%s: progress went backwards from %d to %d", tc.path, last, bytesWritten)
+			}
+			if total != tc.expectedTotal {
+				t.Errorf("%s: expected total %d, got %d", tc.path, tc.expectedTotal, total)
+			}
+			last = bytesWritten
+		})
+		if err != nil {
+			t.Fatalf("%s: GetToWriter failed: %v", tc.path, err)
+		}
+		if written != int64(len(payload)) || !bytes.Equal(dst.Bytes(), payload) {
+			t.Errorf("%s: expected %d bytes written, got %d", tc.path, len(payload), written)
+		}
+		if last != written || calls < 2 {
+			t.Errorf("%s: expected final progress %d over several calls, got %d after %d calls", tc.path, written, last, calls)
+		}
+	}
+}
+
+func TestGetToWriterCancelledMidStream(t *testing.T) {
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		w.Write(make([]byte, 1000))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	written, err := client.GetToWriter(ctx, "/artifact", io.Discard, func(bytesWritten, total int64) {
+		if bytesWritten > 0 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if written == 0 || written >= 1000000 {
+		t.Errorf("Expected a partial download, got %d bytes", written)
+	}
+}
+
+func TestGetToWriterHTTPError(t *testing.T) {
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "missing", http.StatusNotFound)
+	})
+
+	var dst bytes.Buffer
+	_, err := client.GetToWriter(context.Background(), "/artifact", &dst, nil)
+	if !IsAPIError(err) {
+		t.Errorf("Expected an API error, got %v", err)
+	}
+	if dst.Len() != 0 {
+		t.Errorf("Expected nothing written for an error response, got %q", dst.String())
+	}
+}
+
+// newRangeServer serves payload with Range support and the given digest,
+// recording the Range headers it receives.
+func newRangeServer(t *testing.T, payload []byte, digest string) (*Client, *[]string) {
+	var ranges []string
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set(DigestHeader, digest)
+		http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(payload))
+	})
+	return client, &ranges
+}
+
+func payloadDigest(payload []byte) string {
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
+
+func TestDownloadToFileResumes(t *testing.T) {
+	payload := bytes.Repeat([]byte("yesterday"), 20000)
+	client, ranges := newRangeServer(t, payload, payloadDigest(payload))
+	filename := filepath.Join(t.TempDir(), "artifact")
+	os.WriteFile(filename+".part", payload[:1000], 0644)
+	os.WriteFile(filename+".part.etag", []byte(`"v1"`), 0644)
+
+	var first int64 = -1
+	size, err := client.DownloadToFile(context.Background(), "/artifact", filename, func(bytesWritten, total int64) {
+		if first < 0 {
+			first = bytesWritten
+		}
+	})
+	if err != nil {
+		t.Fatalf("DownloadToFile returned error: %v", err)
+	}
+	if len(*ranges) != 1 || (*ranges)[0] != "bytes=1000-" {
+		t.Errorf("Expected one request for the rest of the file, got %q", *ranges)
+	}
+	if first != 1000 {
+		t.Errorf("Expected progress to start at the resumed offset, got %d", first)
+	}
+	if got, _ := os.ReadFile(filename); size != int64(len(payload)) || !bytes.Equal(got, payload) {
+		t.Errorf("Expected the complete file, got %d bytes", len(got))
+	}
+	if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be moved into place")
+	}
+}
+
+func TestDownloadToFileRestartsWhenFileChanged(t *testing.T) {
+	payload := bytes.Repeat([]byte("yesterday"), 20000)
+	client, _ := newRangeServer(t, payload, payloadDigest(payload))
+	filename := filepath.Join(t.TempDir(), "artifact")
+	os.WriteFile(filename+".part", bytes.Repeat([]byte("x"), 1000), 0644)
+	os.WriteFile(filename+".part.etag", []byte(`"v0"`), 0644)
+
+	if _, err := client.DownloadToFile(context.Background(), "/artifact", filename, nil); err != nil {
+		t.Fatalf("DownloadToFile returned error: %v", err)
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, payload) {
+		t.Error("Expected stale partial data to be replaced")
+	}
+}
+
+func TestDownloadToFileDigestMismatch(t *testing.T) {
+	payload := bytes.Repeat([]byte("yesterday"), 20000)
+	client, _ := newRangeServer(t, payload, payloadDigest([]byte("something else")))
+	filename := filepath.Join(t.TempDir(), "artifact")
+
+	_, err := client.DownloadToFile(context.Background(), "/artifact", filename, nil)
+	if !errors.Is(err, ErrDigestMismatch) || !IsValidationError(err) {
+		t.Errorf("Expected ErrDigestMismatch, got %v", err)
+	}
+	for _, path := range []string{filename, filename + ".part", filename + ".part.etag"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed after a digest mismatch", filepath.Base(path))
+		}
+	}
+}
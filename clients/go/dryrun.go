@@ -0,0 +1,76 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// DryRunStatus is the outcome of applying an event in a dry run.
+type DryRunStatus string
+
+const (
+	// DryRunAccepted means the event would apply and change state
+	DryRunAccepted DryRunStatus = "accepted"
+	// DryRunRejected means an application's handler would fail the event
+	DryRunRejected DryRunStatus = "rejected"
+	// DryRunNoop means the event would apply without changing state
+	DryRunNoop DryRunStatus = "noop"
+)
+
+// InstanceDryRunResult is the outcome of an event on one application
+// instance.
+type InstanceDryRunResult struct {
+	InstanceID string       `json:"instanceId"`
+	Status     DryRunStatus `json:"status"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// DryRunResult is the outcome of an event in a dry run: rejected if any
+// instance rejected it, accepted if any accepted it and otherwise a no-op.
+type DryRunResult struct {
+	ClientID  string                 `json:"clientId"`
+	Type      string                 `json:"type"`
+	Status    DryRunStatus           `json:"status"`
+	Error     string                 `json:"error,omitempty"`
+	Instances []InstanceDryRunResult `json:"instances"`
+}
+
+// PublishDryRun checks whether events would apply cleanly, e.g. before a
+// bulk import, without publishing them. The hub applies the events in order
+// to every running instance subscribed to them, each event seeing the
+// changes of the ones before it, then rolls everything back: no event
+// numbers are assigned and nothing changes. It returns a result for each
+// payload, in order. Payloads are the same as those given to PublishEvent
+// and are validated by the client's EventRegistry first; the queue is not
+// involved. Event types the user may not publish are refused with an error
+// for which IsForbiddenEventError is true.
+func (p *EventPublisher) PublishDryRun(ctx context.Context, payloads ...interface{}) ([]DryRunResult, error) {
+	if len(payloads) == 0 {
+		return nil, nil
+	}
+	for i, payload := range payloads {
+		if err := p.client.validateEvent(payload); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+	resp, err := p.client.Post(ctx, "/events/publish?dryRun=true", map[string]any{"events": payloads}, nil)
+	if err != nil {
+		return nil, NewNetworkError("dry run request failed", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, wrapPublishError(resp, "dry run failed")
+	}
+
+	var response struct {
+		Results []DryRunResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, NewErrorWithCause(ErrorTypeAPI, "invalid dry run response", err)
+	}
+	if len(response.Results) != len(payloads) {
+		return nil, NewError(ErrorTypeAPI, fmt.Sprintf("dry run returned %d results for %d events", len(response.Results), len(payloads)))
+	}
+	return response.Results, nil
+}
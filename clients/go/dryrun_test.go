@@ -0,0 +1,56 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublishDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events/publish" || r.URL.Query().Get("dryRun") != "true" || r.Header.Get(IdempotencyKeyHeader) != "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var request struct {
+			Events []EventPublishData `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		results := []DryRunResult{}
+		for _, event := range request.Events {
+			result := DryRunResult{ClientID: event.ClientID, Type: event.Type, Status: DryRunAccepted}
+			if event.Type == "Bad" {
+				result.Status, result.Error = DryRunRejected, "admin: bad event"
+			}
+			results = append(results, result)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"dryRun": true, "results": results})
+	}))
+	defer server.Close()
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	results, err := client.GetEventPublisher().PublishDryRun(context.Background(),
+		EventPublishData{ClientID: "1", Type: "Good"},
+		EventPublishData{ClientID: "2", Type: "Bad"},
+	)
+	if err != nil {
+		t.Fatalf("PublishDryRun returned error: %v", err)
+	}
+	if len(results) != 2 || results[0].Status != DryRunAccepted || results[1].Status != DryRunRejected || results[1].Error == "" {
+		t.Errorf("Unexpected results %+v", results)
+	}
+	if length := client.GetEventPublisher().GetQueueLength(); length != 0 {
+		t.Errorf("Expected nothing to be queued, got %d events", length)
+	}
+}
@@ -0,0 +1,164 @@
+package yesterdaygo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormatHeader is the request header that chooses how the hub and
+// applications encode times in JSON responses. Without it times are UTC
+// RFC 3339 strings with nanosecond precision; send it through
+// WithDefaultHeaders to get milliseconds since the epoch instead:
+//
+//	client := yesterdaygo.NewClient(baseURL, yesterdaygo.WithDefaultHeaders(map[string]string{
+//		yesterdaygo.TimeFormatHeader: yesterdaygo.TimeFormatUnixMillis,
+//	}))
+const TimeFormatHeader = "X-Time-Format"
+
+// Values of TimeFormatHeader.
+const (
+	TimeFormatRFC3339    = "rfc3339"
+	TimeFormatUnixMillis = "unix-ms"
+)
+
+// DecodeJSON decodes a JSON response body into v like json.Decoder, accepting
+// times in either time format, so the same types work whichever format the
+// client asks for. Zero times are sent as null and decode to the zero time.
+func DecodeJSON(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data, err = millisToRFC3339(data, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// millisToRFC3339 rewrites the numbers in data that t holds as times into
+// RFC 3339 strings, which time.Time can decode. Values decoded by their own
+// UnmarshalJSON method are left as they are.
+func millisToRFC3339(data json.RawMessage, t reflect.Type) (json.RawMessage, error) {
+	if t == nil {
+		return data, nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	trimmed := bytes.TrimSpace(data)
+	if t == timeType {
+		if ms, err := strconv.ParseInt(string(trimmed), 10, 64); err == nil {
+			return json.Marshal(time.UnixMilli(ms).UTC().Format(time.RFC3339Nano))
+		}
+		return data, nil
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) || len(trimmed) == 0 {
+		return data, nil
+	}
+
+	switch {
+	case (t.Kind() == reflect.Struct || t.Kind() == reflect.Map) && trimmed[0] == '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &object); err != nil {
+			return nil, err
+		}
+		var fields map[string]reflect.Type
+		if t.Kind() == reflect.Struct {
+			fields = jsonFieldTypes(t)
+		}
+		for key, member := range object {
+			var memberType reflect.Type
+			if fields != nil {
+				memberType = fieldType(fields, key)
+			} else {
+				memberType = t.Elem()
+			}
+			converted, err := millisToRFC3339(member, memberType)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = converted
+		}
+		return json.Marshal(object)
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && trimmed[0] == '[':
+		var array []json.RawMessage
+		if err := json.Unmarshal(trimmed, &array); err != nil {
+			return nil, err
+		}
+		for i, member := range array {
+			converted, err := millisToRFC3339(member, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			array[i] = converted
+		}
+		return json.Marshal(array)
+	}
+	return data, nil
+}
+
+// jsonFieldTypes maps the JSON names of the fields of struct type t to their
+// types, promoting the fields of untagged embedded structs like
+// encoding/json.
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	promoted := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for innerName, innerType := range jsonFieldTypes(embedded) {
+					promoted[innerName] = innerType
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	for name, fieldType := range promoted {
+		if _, ok := fields[name]; !ok {
+			fields[name] = fieldType
+		}
+	}
+	return fields
+}
+
+// fieldType looks up the field a JSON key decodes into, matching names
+// case-insensitively like encoding/json. It returns nil for unknown keys.
+func fieldType(fields map[string]reflect.Type, key string) reflect.Type {
+	if fieldType, ok := fields[key]; ok {
+		return fieldType
+	}
+	for name, fieldType := range fields {
+		if strings.EqualFold(name, key) {
+			return fieldType
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package yesterdaygo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type encodingTestItem struct {
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	SeenAt    *time.Time `json:"seenAt,omitempty"`
+	History   []struct {
+		At time.Time `json:"at"`
+	} `json:"history"`
+	ByDay map[string]time.Time `json:"byDay"`
+}
+
+func TestDecodeJSONAcceptsBothTimeFormats(t *testing.T) {
+	want := time.Date(2026, 10, 16, 12, 30, 0, 123000000, time.UTC)
+	bodies := map[string]string{
+		TimeFormatRFC3339:    `{"name":"x","createdAt":"2026-10-16T12:30:00.123Z","seenAt":"2026-10-16T12:30:00.123Z","history":[{"at":"2026-10-16T12:30:00.123Z"}],"byDay":{"d":"2026-10-16T12:30:00.123Z"}}`,
+		TimeFormatUnixMillis: `{"name":"x","createdAt":1792153800123,"seenAt":1792153800123,"history":[{"at":1792153800123}],"byDay":{"d":1792153800123}}`,
+	}
+	for format, body := range bodies {
+		var item encodingTestItem
+		if err := DecodeJSON(strings.NewReader(body), &item); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if item.Name != "x" || !item.CreatedAt.Equal(want) || item.SeenAt == nil || !item.SeenAt.Equal(want) ||
+			len(item.History) != 1 || !item.History[0].At.Equal(want) || !item.ByDay["d"].Equal(want) {
+			t.Errorf("%s: unexpected decoded item %+v", format, item)
+		}
+	}
+
+	// Zero times are sent as null
+	var item encodingTestItem
+	if err := DecodeJSON(strings.NewReader(`{"createdAt":null}`), &item); err != nil || !item.CreatedAt.IsZero() {
+		t.Errorf("Expected a null time to decode to the zero time, got %v: %v", item.CreatedAt, err)
+	}
+}
@@ -1,8 +1,11 @@
 package yesterdaygo
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // ErrorType represents different categories of errors
@@ -19,6 +22,18 @@ const (
 	ErrorTypeAPI
 	// ErrorTypeValidation represents validation errors
 	ErrorTypeValidation
+	// ErrorTypeInsufficientStorage represents a hub that is rejecting writes
+	// because it is low on disk space. Retry once space has been freed.
+	ErrorTypeInsufficientStorage
+	// ErrorTypeQuotaExceeded represents a publish the hub rejected because
+	// an instance the events are delivered to is at a hard quota. The
+	// error's cause is a *QuotaExceededError. Retry once an admin has raised
+	// the quota or the instance's usage has dropped.
+	ErrorTypeQuotaExceeded
+	// ErrorTypeEventForbidden represents a publish the hub refused because
+	// the user may not publish one of the event types. The error's cause is
+	// a *ForbiddenEventError. Retrying does not help.
+	ErrorTypeEventForbidden
 )
 
 // Error represents a structured error with type information
@@ -47,6 +62,58 @@ func (e *Error) IsType(errorType ErrorType) bool {
 	return e.Type == errorType
 }
 
+// PasswordExpiredError is returned by Login and RefreshAccessToken when the
+// hub accepted the credentials but the password is past its maximum age. The
+// client still gets an access token, but the hub rejects every request made
+// with it except publishing a User:UpdatePassword event for the user. Call
+// RefreshAccessToken once the password has been changed to get an
+// unrestricted token.
+type PasswordExpiredError struct{}
+
+// Error implements the error interface
+func (e *PasswordExpiredError) Error() string {
+	return "password has expired and must be changed"
+}
+
+// IsPasswordExpiredError checks if an error reports an expired password
+func IsPasswordExpiredError(err error) bool {
+	var expired *PasswordExpiredError
+	return errors.As(err, &expired)
+}
+
+// QuotaExceededError describes the quota that made the hub reject a publish.
+type QuotaExceededError struct {
+	InstanceID string `json:"instanceId"`
+	// Resource is "databaseBytes" or "events"
+	Resource string `json:"resource"`
+	Usage    int64  `json:"usage"`
+	Limit    int64  `json:"limit"`
+}
+
+// Error implements the error interface
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("instance %s is at its %s quota (%d of %d)", e.InstanceID, e.Resource, e.Usage, e.Limit)
+}
+
+// ForbiddenEventError describes the event type the hub refused to let the
+// user publish.
+type ForbiddenEventError struct {
+	EventType string `json:"eventType"`
+	// InstanceID is the instance restricting the event type
+	InstanceID string `json:"instanceId"`
+	// Roles are the roles allowed to publish it, or just "internal" if only
+	// the hub and applications may
+	Roles []string `json:"roles"`
+}
+
+// Error implements the error interface
+func (e *ForbiddenEventError) Error() string {
+	if len(e.Roles) == 1 && e.Roles[0] == "internal" {
+		return fmt.Sprintf("%s events can only be published internally", e.EventType)
+	}
+	return fmt.Sprintf("%s events can only be published by %s", e.EventType, strings.Join(e.Roles, ", "))
+}
+
 // NewError creates a new Error with the specified type and message
 func NewError(errorType ErrorType, message string) *Error {
 	return &Error{
@@ -88,6 +155,38 @@ func NewValidationError(message string) *Error {
 	return NewError(ErrorTypeValidation, message)
 }
 
+// NewInsufficientStorageError creates an error for a write the hub rejected
+// with 507 Insufficient Storage
+func NewInsufficientStorageError(message string) *Error {
+	return &Error{
+		Type:       ErrorTypeInsufficientStorage,
+		Message:    message,
+		StatusCode: http.StatusInsufficientStorage,
+	}
+}
+
+// NewQuotaExceededError creates an error for a publish the hub rejected
+// because of the quota
+func NewQuotaExceededError(message string, statusCode int, quota *QuotaExceededError) *Error {
+	return &Error{
+		Type:       ErrorTypeQuotaExceeded,
+		Message:    message,
+		StatusCode: statusCode,
+		Cause:      quota,
+	}
+}
+
+// NewForbiddenEventError creates an error for a publish the hub refused
+// because of its event type
+func NewForbiddenEventError(message string, forbidden *ForbiddenEventError) *Error {
+	return &Error{
+		Type:       ErrorTypeEventForbidden,
+		Message:    message,
+		StatusCode: http.StatusForbidden,
+		Cause:      forbidden,
+	}
+}
+
 // IsNetworkError checks if an error is network-related
 func IsNetworkError(err error) bool {
 	if yErr, ok := err.(*Error); ok {
@@ -120,6 +219,60 @@ func IsValidationError(err error) bool {
 	return false
 }
 
+// IsInsufficientStorageError checks if an error is a write rejected because
+// the hub is low on disk space
+func IsInsufficientStorageError(err error) bool {
+	if yErr, ok := err.(*Error); ok {
+		return yErr.IsType(ErrorTypeInsufficientStorage)
+	}
+	return false
+}
+
+// IsQuotaExceededError checks if an error is a publish rejected because of a
+// quota. Use errors.As with a *QuotaExceededError for the quota's details.
+func IsQuotaExceededError(err error) bool {
+	if yErr, ok := err.(*Error); ok {
+		return yErr.IsType(ErrorTypeQuotaExceeded)
+	}
+	return false
+}
+
+// IsForbiddenEventError checks if an error is a publish refused because the
+// user may not publish the event type. Use errors.As with a
+// *ForbiddenEventError for the event type and the roles allowed to publish
+// it.
+func IsForbiddenEventError(err error) bool {
+	if yErr, ok := err.(*Error); ok {
+		return yErr.IsType(ErrorTypeEventForbidden)
+	}
+	return false
+}
+
+// wrapPublishError wraps the response to a rejected publish like
+// WrapHTTPError, except that rejections because of a quota, 507 Insufficient
+// Storage or 429 Too Many Requests with the quota in the body, become quota
+// errors, and 403 Forbidden with the refused event type in the body becomes
+// a forbidden event error.
+func wrapPublishError(resp *http.Response, message string) *Error {
+	switch resp.StatusCode {
+	case http.StatusInsufficientStorage, http.StatusTooManyRequests:
+		var body struct {
+			Quota *QuotaExceededError `json:"quota"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Quota != nil {
+			return NewQuotaExceededError(fmt.Sprintf("%s: %s", message, resp.Status), resp.StatusCode, body.Quota)
+		}
+	case http.StatusForbidden:
+		var body struct {
+			Forbidden *ForbiddenEventError `json:"forbidden"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Forbidden != nil {
+			return NewForbiddenEventError(fmt.Sprintf("%s: %s", message, resp.Status), body.Forbidden)
+		}
+	}
+	return WrapHTTPError(resp, message)
+}
+
 // WrapHTTPError wraps an HTTP response into an appropriate Error type
 func WrapHTTPError(resp *http.Response, message string) *Error {
 	switch resp.StatusCode {
@@ -127,6 +280,8 @@ func WrapHTTPError(resp *http.Response, message string) *Error {
 		return NewAuthenticationError(fmt.Sprintf("%s: %s", message, resp.Status))
 	case http.StatusBadRequest:
 		return NewValidationError(fmt.Sprintf("%s: %s", message, resp.Status))
+	case http.StatusInsufficientStorage:
+		return NewInsufficientStorageError(fmt.Sprintf("%s: %s", message, resp.Status))
 	default:
 		return NewAPIError(fmt.Sprintf("%s: %s", message, resp.Status), resp.StatusCode)
 	}
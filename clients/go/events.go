@@ -3,6 +3,7 @@ package yesterdaygo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -18,6 +19,10 @@ type EventPublishData struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// ErrPollerStopped is returned by WaitForEventNumber when event polling is
+// stopped before the event is seen.
+var ErrPollerStopped = errors.New("event polling stopped")
+
 // EventPoller manages event number polling for detecting data changes
 type EventPoller struct {
 	client          *Client
@@ -25,7 +30,9 @@ type EventPoller struct {
 	pollInterval    time.Duration
 	subscribers     map[string][]chan int
 	stopCh          chan struct{}
-	mu              sync.RWMutex // Protects currentEventNumber and subscribers
+	wakeCh          chan struct{} // Asks the poll loop to poll now
+	mu              sync.RWMutex  // Protects currentEventNumber, subscribers and generation
+	generation      int           // Incremented when the client fails over
 	running         bool
 	runningMu       sync.Mutex // Protects running state
 }
@@ -38,6 +45,7 @@ func NewEventPoller(client *Client) *EventPoller {
 		pollInterval:    5 * time.Second, // Default 5 second interval
 		subscribers:     make(map[string][]chan int),
 		stopCh:          make(chan struct{}),
+		wakeCh:          make(chan struct{}, 1),
 	}
 	poller.StartEventPolling()
 	return poller
@@ -50,10 +58,14 @@ func (ep *EventPoller) GetCurrentEventId(instanceID string) int {
 	return ep.currentEventIds[instanceID]
 }
 
-// setCurrentEventNumber sets the current event number and notifies subscribers
-func (ep *EventPoller) setCurrentEventIds(eventIds map[string]int) {
+// setCurrentEventNumber sets the current event number and notifies
+// subscribers. Numbers polled before the last failover are ignored.
+func (ep *EventPoller) setCurrentEventIds(generation int, eventIds map[string]int) {
 	ep.mu.Lock()
 	defer ep.mu.Unlock()
+	if generation != ep.generation {
+		return
+	}
 
 	for instanceID, eventId := range eventIds {
 		if eventId <= ep.currentEventIds[instanceID] {
@@ -143,23 +155,37 @@ func (ep *EventPoller) pollLoop() {
 		select {
 		case <-ticker.C:
 			ep.performPoll()
+		case <-ep.wakeCh:
+			ep.performPoll()
 		case <-ep.stopCh:
 			return
+		case <-ep.client.BaseContext().Done():
+			// The client's base context was cancelled; shut down as if
+			// StopEventPolling had been called
+			ep.StopEventPolling()
+			return
 		}
 	}
 }
 
 // performPoll performs a single poll request to the API
 func (ep *EventPoller) performPoll() {
-	if len(ep.currentEventIds) == 0 {
+	ep.mu.RLock()
+	query := make(map[string]int, len(ep.currentEventIds))
+	for instanceID, eventId := range ep.currentEventIds {
+		query[instanceID] = eventId
+	}
+	generation := ep.generation
+	ep.mu.RUnlock()
+	if len(query) == 0 {
 		ep.client.Log().Printf("No event IDs to poll")
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ep.client.BaseContext(), 60*time.Second)
 	defer cancel()
 
 	ep.client.Log().Printf("POLL: Polling for events...")
-	resp, err := ep.client.Post(ctx, "/events/poll", ep.currentEventIds, nil)
+	resp, err := ep.client.Post(ctx, "/events/poll", query, nil)
 	if err != nil {
 		// Log error but continue polling
 		ep.client.Log().Printf("POLL: Error: %v", err)
@@ -183,7 +209,7 @@ func (ep *EventPoller) performPoll() {
 		}
 
 		// Update event IDs if they have changed
-		ep.setCurrentEventIds(pollResponse)
+		ep.setCurrentEventIds(generation, pollResponse)
 		return
 	} else {
 		// Handle other status codes as errors
@@ -223,3 +249,105 @@ func (ep *EventPoller) WaitForEvent(ctx context.Context, instanceID string) (int
 		return 0, ctx.Err()
 	}
 }
+
+// WaitForEventNumber blocks until the poller has seen instanceID reach at
+// least eventNumber, returning the instance's event ID at that point. It
+// returns immediately if the instance is already there and otherwise polls
+// right away rather than waiting for the next interval. It fails with
+// ErrPollerStopped if polling stops first.
+func (ep *EventPoller) WaitForEventNumber(ctx context.Context, instanceID string, eventNumber int) (int, error) {
+	// Subscribe before checking the current ID so no update is missed
+	eventCh := ep.SubscribeToEvents(instanceID)
+	defer ep.unsubscribe(instanceID, eventCh)
+
+	if current := ep.GetCurrentEventId(instanceID); current >= eventNumber {
+		return current, nil
+	}
+	ep.wake()
+
+	for {
+		select {
+		case eventId, ok := <-eventCh:
+			if !ok {
+				return 0, ErrPollerStopped
+			}
+			if eventId >= eventNumber {
+				return eventId, nil
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// resetEventIds starts polling again from the beginning after the client
+// fails over to another hub, whose event numbers are unrelated to those seen
+// so far. Subscribers are notified of the new hub's numbers as they arrive.
+func (ep *EventPoller) resetEventIds() {
+	ep.mu.Lock()
+	ep.generation++
+	for instanceID := range ep.currentEventIds {
+		ep.currentEventIds[instanceID] = 0
+	}
+	ep.mu.Unlock()
+	ep.wake()
+}
+
+// wake asks the poll loop to poll now instead of at the next interval
+func (ep *EventPoller) wake() {
+	select {
+	case ep.wakeCh <- struct{}{}:
+	default:
+		// A poll is already pending
+	}
+}
+
+// unsubscribe removes a channel returned by SubscribeToEvents
+func (ep *EventPoller) unsubscribe(instanceID string, ch <-chan int) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	subscribers := ep.subscribers[instanceID]
+	for i, subscriber := range subscribers {
+		if subscriber == ch {
+			ep.subscribers[instanceID] = append(subscribers[:i], subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// PublishAndWait publishes an event and waits until the application
+// instance instanceID has applied it, so that data fetched afterwards
+// reflects the event. event is sent as-is, like the payloads given to
+// EventPublisher.PublishEvent, and usually embeds EventPublishData. The
+// instance must subscribe to the event's type, or it never reaches the
+// event's number and PublishAndWait waits until ctx is done. Unlike the
+// EventPublisher the event is not queued or retried. It returns the event
+// number assigned by the server, or a validation error without publishing
+// if the client's EventRegistry rejects the event. A publish the hub rejects
+// because an instance is at a hard quota returns an error for which
+// IsQuotaExceededError is true.
+func (c *Client) PublishAndWait(ctx context.Context, instanceID string, event interface{}) (int64, error) {
+	if err := c.validateEvent(event); err != nil {
+		return 0, err
+	}
+	resp, err := c.Post(ctx, "/events/publish", event, nil)
+	if err != nil {
+		return 0, NewNetworkError("publish request failed", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, wrapPublishError(resp, "publish failed")
+	}
+
+	var published struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&published); err != nil {
+		return 0, NewErrorWithCause(ErrorTypeAPI, "invalid publish response", err)
+	}
+
+	if _, err := c.eventPoller.WaitForEventNumber(ctx, instanceID, int(published.ID)); err != nil {
+		return published.ID, err
+	}
+	return published.ID, nil
+}
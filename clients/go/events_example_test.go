@@ -25,10 +25,11 @@ func ExampleEventPoller() {
 	poller := client.GetEventPoller()
 	
 	// Subscribe to event notifications
-	eventCh := poller.SubscribeToEvents()
+	eventCh := poller.SubscribeToEvents("MBtskI6D")
 	
 	// Start polling with 3-second interval
-	if err := poller.StartEventPolling(3 * time.Second); err != nil {
+	poller.SetPollInterval(3 * time.Second)
+	if err := poller.StartEventPolling(); err != nil {
 		log.Printf("Failed to start event polling: %v", err)
 		return
 	}
@@ -46,7 +47,7 @@ func ExampleEventPoller() {
 	// Stop polling
 	poller.StopEventPolling()
 	
-	fmt.Printf("Final event number: %d\n", poller.GetCurrentEventNumber())
+	fmt.Printf("Final event number: %d\n", poller.GetCurrentEventId("MBtskI6D"))
 }
 
 // ExampleEventPoller_waitForEvent demonstrates waiting for a specific event
@@ -55,7 +56,8 @@ func ExampleEventPoller_waitForEvent() {
 	poller := client.GetEventPoller()
 	
 	// Start polling
-	if err := poller.StartEventPolling(2 * time.Second); err != nil {
+	poller.SetPollInterval(2 * time.Second)
+	if err := poller.StartEventPolling(); err != nil {
 		log.Printf("Failed to start event polling: %v", err)
 		return
 	}
@@ -65,7 +67,7 @@ func ExampleEventPoller_waitForEvent() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	
-	eventNumber, err := poller.WaitForEvent(ctx)
+	eventNumber, err := poller.WaitForEvent(ctx, "MBtskI6D")
 	if err != nil {
 		if err == context.DeadlineExceeded {
 			fmt.Println("No new events within timeout period")
@@ -84,11 +86,12 @@ func ExampleEventPoller_multipleSubscribers() {
 	poller := client.GetEventPoller()
 	
 	// Create multiple subscribers
-	subscriber1 := poller.SubscribeToEvents()
-	subscriber2 := poller.SubscribeToEvents()
+	subscriber1 := poller.SubscribeToEvents("MBtskI6D")
+	subscriber2 := poller.SubscribeToEvents("MBtskI6D")
 	
 	// Start polling
-	if err := poller.StartEventPolling(1 * time.Second); err != nil {
+	poller.SetPollInterval(1 * time.Second)
+	if err := poller.StartEventPolling(); err != nil {
 		log.Printf("Failed to start event polling: %v", err)
 		return
 	}
@@ -109,8 +112,6 @@ func ExampleEventPoller_multipleSubscribers() {
 	
 	// Wait for some events
 	time.Sleep(5 * time.Second)
-	
-	fmt.Printf("Total subscribers: %d\n", poller.GetSubscriberCount())
 }
 
 // ExampleEventPoller_pollingStatus demonstrates checking polling status
@@ -121,7 +122,8 @@ func ExampleEventPoller_pollingStatus() {
 	fmt.Printf("Is running initially: %t\n", poller.IsRunning())
 	
 	// Start polling
-	if err := poller.StartEventPolling(5 * time.Second); err != nil {
+	poller.SetPollInterval(5 * time.Second)
+	if err := poller.StartEventPolling(); err != nil {
 		log.Printf("Failed to start event polling: %v", err)
 		return
 	}
@@ -0,0 +1,175 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishAndWait(t *testing.T) {
+	// The hub assigns event 7, which instance "app" has applied by the next
+	// poll. Instance "other" never gets past event 3.
+	var mu sync.Mutex
+	published := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/events/publish":
+			published = true
+			json.NewEncoder(w).Encode(map[string]any{"status": "success", "id": 7, "clientId": "c1"})
+		case "/events/poll":
+			var query map[string]int
+			json.NewDecoder(r.Body).Decode(&query)
+			response := map[string]int{}
+			for instanceID := range query {
+				response[instanceID] = 3
+				if instanceID == "app" && published {
+					response[instanceID] = 7
+				}
+			}
+			json.NewEncoder(w).Encode(response)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	// Well within the poller's 5 second interval, so the wait must poll
+	// right away
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	event := EventPublishData{ClientID: "c1", Type: "Item:Add", Timestamp: time.Now().UTC()}
+	id, err := client.PublishAndWait(ctx, "app", event)
+	if err != nil || id != 7 {
+		t.Fatalf("Expected event 7 to be applied, got %d: %v", id, err)
+	}
+	if got := client.GetEventPoller().GetCurrentEventId("app"); got != 7 {
+		t.Errorf("Expected the poller to have seen event 7, got %d", got)
+	}
+
+	// Waiting on an instance that never applies the event times out
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.PublishAndWait(ctx, "other", event); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
+
+func TestWaitForEventNumberStopped(t *testing.T) {
+	client := NewClient("http://127.0.0.1:1",
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPublisher().Stop()
+	poller := client.GetEventPoller()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		poller.StopEventPolling()
+	}()
+	if _, err := poller.WaitForEventNumber(context.Background(), "app", 1); !errors.Is(err, ErrPollerStopped) {
+		t.Errorf("Expected ErrPollerStopped, got %v", err)
+	}
+}
+
+func TestPublishAndWaitInsufficientStorage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(map[string]string{"error": "insufficient storage", "level": "critical"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	event := EventPublishData{ClientID: "c1", Type: "Item:Add", Timestamp: time.Now().UTC()}
+	_, err := client.PublishAndWait(context.Background(), "app", event)
+	if !IsInsufficientStorageError(err) || IsAPIError(err) {
+		t.Errorf("Expected an insufficient storage error, got %v", err)
+	}
+}
+
+func TestPublishAndWaitQuotaExceeded(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		resource string
+	}{
+		{http.StatusInsufficientStorage, "databaseBytes"},
+		{http.StatusTooManyRequests, "events"},
+	} {
+		t.Run(tc.resource, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				json.NewEncoder(w).Encode(map[string]any{
+					"error": "instance app is at its quota",
+					"quota": map[string]any{"instanceId": "app", "resource": tc.resource, "usage": 1000, "limit": 1000},
+				})
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL,
+				WithHTTPClient(http.DefaultClient),
+				WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+				WithLogger(log.New(io.Discard, "", 0)),
+			)
+			defer client.GetEventPoller().StopEventPolling()
+			defer client.GetEventPublisher().Stop()
+
+			event := EventPublishData{ClientID: "c1", Type: "Item:Add", Timestamp: time.Now().UTC()}
+			_, err := client.PublishAndWait(context.Background(), "app", event)
+			if !IsQuotaExceededError(err) || IsInsufficientStorageError(err) {
+				t.Fatalf("Expected a quota error, got %v", err)
+			}
+			var quota *QuotaExceededError
+			if !errors.As(err, &quota) || quota.InstanceID != "app" || quota.Resource != tc.resource || quota.Limit != 1000 {
+				t.Errorf("Expected the quota in the error, got %+v", quota)
+			}
+			if err.(*Error).StatusCode != tc.status {
+				t.Errorf("Expected status %d, got %d", tc.status, err.(*Error).StatusCode)
+			}
+		})
+	}
+}
+
+func TestPublishAndWaitTooManyRequestsWithoutQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	event := EventPublishData{ClientID: "c1", Type: "Item:Add", Timestamp: time.Now().UTC()}
+	_, err := client.PublishAndWait(context.Background(), "app", event)
+	if IsQuotaExceededError(err) || !IsAPIError(err) {
+		t.Errorf("Expected a plain API error, got %v", err)
+	}
+}
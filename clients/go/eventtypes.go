@@ -0,0 +1,170 @@
+package yesterdaygo
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EventRegistry maps event types to the structs their payloads are published
+// as, so events can be constructed with the right type string and checked
+// before they are published. A typo in an event type otherwise goes
+// unnoticed: the hub accepts the event and no application handles it.
+//
+//	type AddUserEvent struct {
+//		yesterdaygo.EventPublishData
+//		Username string `json:"username"`
+//	}
+//
+//	registry := yesterdaygo.NewEventRegistry()
+//	yesterdaygo.RegisterEventType[AddUserEvent](registry, "User:Add")
+//	client := yesterdaygo.NewClient(baseURL, yesterdaygo.WithEventRegistry(registry))
+//
+//	event, _ := yesterdaygo.NewEventOf[AddUserEvent](registry, "User:Add")
+//	event.Username = "alice"
+//	client.GetEventPublisher().PublishEvent(yesterdaygo.GenerateClientID(), event)
+type EventRegistry struct {
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// NewEventRegistry returns an empty registry.
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{types: make(map[string]reflect.Type)}
+}
+
+// RegisterEventType registers eventType with the struct T its payloads are
+// published as. T usually embeds EventPublishData. Registering a type again
+// replaces its struct.
+func RegisterEventType[T any](r *EventRegistry, eventType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[eventType] = reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// New returns a pointer to a new payload for eventType, with the Type field
+// of an embedded EventPublishData set. It fails with a validation error if
+// the type is not registered.
+func (r *EventRegistry) New(eventType string) (any, error) {
+	t, err := r.lookup(eventType)
+	if err != nil {
+		return nil, err
+	}
+	event := reflect.New(t)
+	if t.Kind() == reflect.Struct {
+		if field := event.Elem().FieldByName("EventPublishData"); field.IsValid() && field.Type() == reflect.TypeOf(EventPublishData{}) {
+			field.FieldByName("Type").SetString(eventType)
+		}
+	}
+	return event.Interface(), nil
+}
+
+// NewEventOf is like New for callers that know the payload struct, returning
+// it typed. It fails if eventType is registered with a different struct.
+func NewEventOf[T any](r *EventRegistry, eventType string) (*T, error) {
+	event, err := r.New(eventType)
+	if err != nil {
+		return nil, err
+	}
+	typed, ok := event.(*T)
+	if !ok {
+		return nil, NewValidationError(fmt.Sprintf("event type %s is registered with %T, not %T", eventType, event, (*T)(nil)))
+	}
+	return typed, nil
+}
+
+// Types returns the registered event types in order.
+func (r *EventRegistry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.types))
+	for eventType := range r.types {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Validate checks that payload has a registered event type and, unless it
+// is a map or raw JSON, is the struct registered for it. It returns a
+// validation error describing the mismatch otherwise.
+func (r *EventRegistry) Validate(payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return NewErrorWithCause(ErrorTypeValidation, "failed to marshal event", err)
+	}
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return NewErrorWithCause(ErrorTypeValidation, "event is not a JSON object", err)
+	}
+	if envelope.Type == "" {
+		return NewValidationError("event has no type")
+	}
+	registered, err := r.lookup(envelope.Type)
+	if err != nil {
+		return err
+	}
+
+	t := reflect.TypeOf(payload)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct && t != registered {
+		return NewValidationError(fmt.Sprintf("event type %s is registered with %s, not %s", envelope.Type, registered, t))
+	}
+	return nil
+}
+
+// lookup returns the struct registered for eventType.
+func (r *EventRegistry) lookup(eventType string) (reflect.Type, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.types[eventType]
+	if !ok {
+		return nil, NewValidationError(fmt.Sprintf("unknown event type %q%s", eventType, r.suggestLocked(eventType)))
+	}
+	return t, nil
+}
+
+// suggestLocked names a registered type differing from eventType only in
+// case or punctuation, the usual typos.
+func (r *EventRegistry) suggestLocked(eventType string) string {
+	normalize := func(s string) string {
+		return strings.Map(func(c rune) rune {
+			if c == ':' || c == '_' || c == '-' || c == '.' {
+				return -1
+			}
+			return c
+		}, strings.ToLower(s))
+	}
+	for registered := range r.types {
+		if normalize(registered) == normalize(eventType) {
+			return fmt.Sprintf(" (did you mean %q?)", registered)
+		}
+	}
+	return ""
+}
+
+// WithEventRegistry makes the client check events against the registry
+// before publishing them: EventPublisher.PublishEvent,
+// PublishEventBlocking and Client.PublishAndWait return a validation error
+// for events whose type is not registered or whose payload is not the
+// registered struct, instead of sending them.
+func WithEventRegistry(registry *EventRegistry) ClientOption {
+	return func(c *Client) {
+		c.eventRegistry = registry
+	}
+}
+
+// validateEvent checks payload against the client's event registry, if any.
+func (c *Client) validateEvent(payload any) error {
+	if c.eventRegistry == nil {
+		return nil
+	}
+	return c.eventRegistry.Validate(payload)
+}
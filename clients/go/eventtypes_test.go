@@ -0,0 +1,94 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type testAddUserEvent struct {
+	EventPublishData
+	Username string `json:"username"`
+}
+
+type testDeleteUserEvent struct {
+	EventPublishData
+	UserID int `json:"userId"`
+}
+
+func newTestEventRegistry() *EventRegistry {
+	registry := NewEventRegistry()
+	RegisterEventType[testAddUserEvent](registry, "User:Add")
+	RegisterEventType[testDeleteUserEvent](registry, "User:Delete")
+	return registry
+}
+
+func TestEventRegistryNew(t *testing.T) {
+	registry := newTestEventRegistry()
+
+	event, err := registry.New("User:Add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, ok := event.(*testAddUserEvent)
+	if !ok || added.Type != "User:Add" {
+		t.Errorf("Expected a *testAddUserEvent of type User:Add, got %#v", event)
+	}
+
+	deleted, err := NewEventOf[testDeleteUserEvent](registry, "User:Delete")
+	if err != nil || deleted.Type != "User:Delete" {
+		t.Errorf("Expected a typed User:Delete event, got %#v: %v", deleted, err)
+	}
+	if _, err := NewEventOf[testDeleteUserEvent](registry, "User:Add"); err == nil {
+		t.Error("Expected a mismatched struct to be rejected")
+	}
+
+	_, err = registry.New("user:add")
+	if err == nil || !strings.Contains(err.Error(), `did you mean "User:Add"?`) {
+		t.Errorf("Expected an unknown type with a suggestion, got %v", err)
+	}
+	if types := registry.Types(); strings.Join(types, ",") != "User:Add,User:Delete" {
+		t.Errorf("Unexpected types %v", types)
+	}
+}
+
+func TestEventRegistryValidate(t *testing.T) {
+	registry := newTestEventRegistry()
+	for name, tc := range map[string]struct {
+		payload any
+		valid   bool
+	}{
+		"registered":      {&testAddUserEvent{EventPublishData: EventPublishData{Type: "User:Add"}}, true},
+		"map":             {map[string]any{"type": "User:Delete", "userId": 1}, true},
+		"raw":             {json.RawMessage(`{"type": "User:Add"}`), true},
+		"unknown type":    {map[string]any{"type": "User:Ad"}, false},
+		"no type":         {map[string]any{"userId": 1}, false},
+		"wrong struct":    {testDeleteUserEvent{EventPublishData: EventPublishData{Type: "User:Add"}}, false},
+		"not json object": {[]int{1}, false},
+	} {
+		err := registry.Validate(tc.payload)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid=%v, got %v", name, tc.valid, err)
+		}
+		if err != nil && !IsValidationError(err) {
+			t.Errorf("%s: expected a validation error, got %v", name, err)
+		}
+	}
+}
+
+func TestPublishRejectsUnregisteredEvents(t *testing.T) {
+	client := newBaseContextClient(t, "http://unused", context.Background())
+	client.eventRegistry = newTestEventRegistry()
+
+	err := client.GetEventPublisher().PublishEvent("event-1", map[string]any{"type": "User:Remove"})
+	if err == nil || !IsValidationError(err) {
+		t.Errorf("Expected the event to be rejected, got %v", err)
+	}
+	if _, err := client.PublishAndWait(context.Background(), "app", map[string]any{"type": "User:Remove"}); err == nil {
+		t.Error("Expected PublishAndWait to reject the event")
+	}
+	if length := client.GetEventPublisher().GetQueueLength(); length != 0 {
+		t.Errorf("Expected nothing to be queued, got %d", length)
+	}
+}
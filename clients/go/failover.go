@@ -0,0 +1,237 @@
+package yesterdaygo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultHealthProbeInterval is how often a client with fallback URLs checks
+// the health of its endpoints, see WithHealthProbeInterval.
+const DefaultHealthProbeInterval = 10 * time.Second
+
+// healthProbeTimeout bounds each health probe.
+const healthProbeTimeout = 5 * time.Second
+
+// WithFallbackURLs adds hubs to fail over to when the base URL, or the
+// endpoint the client is currently using, stops responding. The client
+// tracks the health of each endpoint from the outcome of its requests and a
+// background probe of /healthz, and sends each request to a healthy
+// endpoint, preferring earlier URLs.
+//
+// When the client moves to another endpoint it replays the refresh token
+// there to mint a new access token, and restarts event polling from that
+// hub's event numbers. Requests that fail because the endpoint is down are
+// retried on the next healthy endpoint if they are idempotent (GET, HEAD,
+// PUT, DELETE) or carry an Idempotency-Key header; other requests return the
+// error and the next request uses another endpoint.
+func WithFallbackURLs(urls ...string) ClientOption {
+	return func(c *Client) {
+		c.fallbackURLs = append(c.fallbackURLs, urls...)
+	}
+}
+
+// WithStickyEndpoint sets whether the client stays on the endpoint it failed
+// over to while that endpoint remains healthy (the default), or returns to
+// the earliest healthy URL as soon as it recovers.
+func WithStickyEndpoint(sticky bool) ClientOption {
+	return func(c *Client) {
+		c.endpoints.sticky = sticky
+	}
+}
+
+// WithHealthProbeInterval sets how often a client with fallback URLs probes
+// its endpoints. Zero disables the probe, leaving health to be judged from
+// requests alone.
+func WithHealthProbeInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.probeInterval = interval
+	}
+}
+
+// endpoint is a hub the client can send requests to.
+type endpoint struct {
+	url      string
+	healthy  bool
+	failures int // Consecutive failed requests and probes
+}
+
+// endpointSet tracks the health of the client's endpoints and which one is
+// in use.
+type endpointSet struct {
+	mu        sync.Mutex
+	endpoints []*endpoint
+	active    int
+	sticky    bool
+}
+
+// activeURL returns the URL of the endpoint in use.
+func (s *endpointSet) activeURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endpoints[s.active].url
+}
+
+// choose returns the endpoint requests should go to: the active one if
+// sticky and healthy, otherwise the earliest healthy one. If none is healthy
+// the active endpoint is kept.
+func (s *endpointSet) choose() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sticky && s.endpoints[s.active].healthy {
+		return s.active
+	}
+	for i, endpoint := range s.endpoints {
+		if endpoint.healthy {
+			return i
+		}
+	}
+	return s.active
+}
+
+// report records the outcome of a request or probe sent to the endpoint
+// with the given URL.
+func (s *endpointSet) report(url string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, endpoint := range s.endpoints {
+		if endpoint.url != url {
+			continue
+		}
+		if ok {
+			endpoint.healthy = true
+			endpoint.failures = 0
+		} else {
+			endpoint.healthy = false
+			endpoint.failures++
+		}
+	}
+}
+
+// hasHealthyAlternative reports whether an endpoint other than url is
+// healthy.
+func (s *endpointSet) hasHealthyAlternative(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, endpoint := range s.endpoints {
+		if endpoint.url != url && endpoint.healthy {
+			return true
+		}
+	}
+	return false
+}
+
+// urls returns the URLs of all endpoints.
+func (s *endpointSet) urls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls := make([]string, len(s.endpoints))
+	for i, endpoint := range s.endpoints {
+		urls[i] = endpoint.url
+	}
+	return urls
+}
+
+// EndpointHealth returns whether each of the client's endpoints is currently
+// considered healthy, keyed by URL.
+func (c *Client) EndpointHealth() map[string]bool {
+	c.endpoints.mu.Lock()
+	defer c.endpoints.mu.Unlock()
+	health := make(map[string]bool, len(c.endpoints.endpoints))
+	for _, endpoint := range c.endpoints.endpoints {
+		health[endpoint.url] = endpoint.healthy
+	}
+	return health
+}
+
+// selectEndpoint returns the base URL the next request should be sent to,
+// failing over first if the endpoint in use is no longer the one to use.
+func (c *Client) selectEndpoint(ctx context.Context) string {
+	c.failoverMu.Lock()
+	defer c.failoverMu.Unlock()
+
+	next := c.endpoints.choose()
+	c.endpoints.mu.Lock()
+	previous := c.endpoints.endpoints[c.endpoints.active].url
+	c.endpoints.active = next
+	url := c.endpoints.endpoints[next].url
+	c.endpoints.mu.Unlock()
+
+	if url != previous {
+		c.failover(ctx, previous, url)
+	}
+	return url
+}
+
+// failover moves the session to the endpoint now in use: access tokens are
+// only valid on the hub that minted them and event numbers differ between
+// hubs.
+func (c *Client) failover(ctx context.Context, from, to string) {
+	c.log.Printf("switching from %s to %s", from, to)
+	if c.getAccessToken() != "" {
+		c.clearAccessToken()
+		if err := c.RefreshAccessToken(ctx); err != nil {
+			c.log.Printf("failed to refresh access token on %s: %v", to, err)
+		}
+	}
+	if c.eventPoller != nil {
+		c.eventPoller.resetEventIds()
+	}
+}
+
+// isEndpointFailure reports whether a request outcome means the endpoint is
+// down rather than that the request itself failed.
+func isEndpointFailure(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRetryable reports whether a request can safely be sent again to another
+// endpoint.
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// probeEndpoints checks the health of every endpoint at the probe interval
+// until the client's base context is done.
+func (c *Client) probeEndpoints() {
+	ticker := time.NewTicker(c.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, url := range c.endpoints.urls() {
+				c.endpoints.report(url, c.probe(url))
+			}
+		case <-c.BaseContext().Done():
+			return
+		}
+	}
+}
+
+// probe reports whether the hub at url answers its health check.
+func (c *Client) probe(url string) bool {
+	ctx, cancel := context.WithTimeout(c.BaseContext(), healthProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/healthz", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.GetHTTPClient().Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,205 @@
+package yesterdaygo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sessions is the session store shared by the mock hubs: refresh tokens
+// minted by one hub are accepted by the other and rotate on use.
+type sessions struct {
+	mu           sync.Mutex
+	refreshToken string
+	rotations    int
+}
+
+func (s *sessions) rotate(presented string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if presented != s.refreshToken {
+		return "", false
+	}
+	s.rotations++
+	s.refreshToken = fmt.Sprintf("refresh-%d", s.rotations)
+	return s.refreshToken, true
+}
+
+// mockHub serves access tokens valid only on itself, an API needing one and
+// event polls reporting its own event number.
+type mockHub struct {
+	*httptest.Server
+	name     string
+	eventID  int
+	failing  atomic.Bool
+	requests atomic.Int32
+}
+
+func newMockHub(t *testing.T, name string, eventID int, sessions *sessions) *mockHub {
+	hub := &mockHub{name: name, eventID: eventID}
+	hub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hub.failing.Load() {
+			http.Error(w, "hub is down", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/healthz":
+		case "/public/access_token":
+			cookie, err := r.Cookie("YRT")
+			if err != nil {
+				http.Error(w, "no refresh token", http.StatusUnauthorized)
+				return
+			}
+			rotated, ok := sessions.rotate(cookie.Value)
+			if !ok {
+				http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: rotated})
+			fmt.Fprintf(w, `{"access_token": "token-%s"}`, name)
+		case "/events/poll":
+			fmt.Fprintf(w, `{"app": %d}`, hub.eventID)
+		default:
+			if r.Header.Get("Authorization") != "Bearer token-"+name {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			hub.requests.Add(1)
+			fmt.Fprint(w, name)
+		}
+	}))
+	t.Cleanup(hub.Close)
+	return hub
+}
+
+func newFailoverTestClient(t *testing.T, primary, fallback *mockHub, sessions *sessions, options ...ClientOption) *Client {
+	store := NewMemoryTokenStore()
+	store.Save(sessions.refreshToken)
+	client := NewClient(primary.URL, append([]ClientOption{
+		WithFallbackURLs(fallback.URL),
+		WithHealthProbeInterval(0),
+		WithHTTPClient(http.DefaultClient),
+		WithTokenStore(store),
+		WithLogger(log.New(io.Discard, "", 0)),
+	}, options...)...)
+	t.Cleanup(func() {
+		client.GetEventPoller().StopEventPolling()
+		client.GetEventPublisher().Stop()
+	})
+	if err := client.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	return client
+}
+
+func getBody(t *testing.T, client *Client, path string) string {
+	t.Helper()
+	resp, err := client.Get(context.Background(), path, nil)
+	if err != nil {
+		t.Fatalf("Get %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Get %s: status %d: %s", path, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestFailover(t *testing.T) {
+	sessions := &sessions{refreshToken: "refresh-0"}
+	primary := newMockHub(t, "a", 100, sessions)
+	fallback := newMockHub(t, "b", 5, sessions)
+	client := newFailoverTestClient(t, primary, fallback, sessions)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if body := getBody(t, client, "/api/data"); body != "a" {
+		t.Fatalf("Expected the primary to serve requests, got %q", body)
+	}
+	if id, err := client.GetEventPoller().WaitForEventNumber(ctx, "app", 100); err != nil || id != 100 {
+		t.Fatalf("Expected event 100 from the primary, got %d: %v", id, err)
+	}
+
+	// The primary goes down mid-session: the request is retried on the
+	// fallback with an access token minted there
+	primary.failing.Store(true)
+	if body := getBody(t, client, "/api/data"); body != "b" {
+		t.Fatalf("Expected the fallback to serve requests, got %q", body)
+	}
+	if client.GetBaseURL() != fallback.URL {
+		t.Errorf("Expected the client to use %s, got %s", fallback.URL, client.GetBaseURL())
+	}
+	if health := client.EndpointHealth(); health[primary.URL] || !health[fallback.URL] {
+		t.Errorf("Unexpected endpoint health %v", health)
+	}
+
+	// Event numbers start again from the fallback's
+	if id, err := client.GetEventPoller().WaitForEventNumber(ctx, "app", 5); err != nil || id != 5 {
+		t.Fatalf("Expected event 5 from the fallback, got %d: %v", id, err)
+	}
+	if id := client.GetEventPoller().GetCurrentEventId("app"); id != 5 {
+		t.Errorf("Expected the primary's event number to be dropped, got %d", id)
+	}
+
+	// The client stays on the fallback once the primary recovers
+	primary.failing.Store(false)
+	client.endpoints.report(primary.URL, client.probe(primary.URL))
+	if body := getBody(t, client, "/api/data"); body != "b" {
+		t.Errorf("Expected the client to stick to the fallback, got %q", body)
+	}
+}
+
+func TestFailoverReturnsToPrimaryWhenNotSticky(t *testing.T) {
+	sessions := &sessions{refreshToken: "refresh-0"}
+	primary := newMockHub(t, "a", 1, sessions)
+	fallback := newMockHub(t, "b", 1, sessions)
+	client := newFailoverTestClient(t, primary, fallback, sessions, WithStickyEndpoint(false), WithHealthProbeInterval(10*time.Millisecond))
+
+	primary.failing.Store(true)
+	if body := getBody(t, client, "/api/data"); body != "b" {
+		t.Fatalf("Expected the fallback to serve requests, got %q", body)
+	}
+
+	// The probe notices the primary is back
+	primary.failing.Store(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.EndpointHealth()[primary.URL] {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the probe to find the primary healthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if body := getBody(t, client, "/api/data"); body != "a" {
+		t.Errorf("Expected the client to return to the primary, got %q", body)
+	}
+}
+
+func TestFailoverDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	sessions := &sessions{refreshToken: "refresh-0"}
+	primary := newMockHub(t, "a", 1, sessions)
+	fallback := newMockHub(t, "b", 1, sessions)
+	client := newFailoverTestClient(t, primary, fallback, sessions)
+
+	primary.failing.Store(true)
+	resp, err := client.Post(context.Background(), "/api/items", map[string]string{"name": "x"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || fallback.requests.Load() != 0 {
+		t.Errorf("Expected the POST to fail without a retry, got %d with %d fallback requests", resp.StatusCode, fallback.requests.Load())
+	}
+
+	// The next request goes to the fallback
+	if body := getBody(t, client, "/api/data"); body != "b" {
+		t.Errorf("Expected the fallback to serve requests, got %q", body)
+	}
+}
@@ -0,0 +1,62 @@
+package yesterdaygo
+
+// featureFlagsURI is the data view applications serve once they enable
+// feature flags.
+const featureFlagsURI = "api/flags"
+
+type featureFlagsData struct {
+	Flags map[string]bool `json:"flags"`
+}
+
+// FlagProvider reports the feature flags of an application instance for the
+// logged-in user. Flags are evaluated by the application, so allow lists and
+// rollout percentages never reach the client. Evaluations are cached and
+// refetched when the instance processes new events, such as a flag change.
+type FlagProvider struct {
+	provider *DataProvider[featureFlagsData]
+}
+
+// NewFlagProvider creates a flag provider for the application instance.
+func NewFlagProvider(client *Client, instanceID string, opts ...DataProviderOption) *FlagProvider {
+	return &FlagProvider{
+		provider: NewDataProvider[featureFlagsData](client, instanceID, featureFlagsURI, nil, opts...),
+	}
+}
+
+// Flags returns every flag of the instance, evaluated for the current user.
+func (fp *FlagProvider) Flags() (map[string]bool, error) {
+	data, err := fp.provider.Get()
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]bool, len(data.Flags))
+	for name, enabled := range data.Flags {
+		flags[name] = enabled
+	}
+	return flags, nil
+}
+
+// Enabled reports whether the named flag is enabled for the current user.
+// Unknown flags, and all flags while the application cannot be reached, are
+// disabled.
+func (fp *FlagProvider) Enabled(name string) bool {
+	data, err := fp.provider.Get()
+	if err != nil {
+		fp.provider.client.Log().Printf("Failed to fetch feature flags: %v", err)
+		return false
+	}
+	return data.Flags[name]
+}
+
+// Subscribe registers a callback called with the evaluated flags whenever
+// they are refetched.
+func (fp *FlagProvider) Subscribe(callback func(map[string]bool)) error {
+	return fp.provider.Subscribe(func(data featureFlagsData) {
+		callback(data.Flags)
+	})
+}
+
+// Close stops refreshing the flags.
+func (fp *FlagProvider) Close() {
+	fp.provider.Close()
+}
@@ -0,0 +1,47 @@
+package yesterdaygo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFlagProviderRefetchesOnEvent(t *testing.T) {
+	var beta atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/api/flags" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"flags": map[string]bool{"beta": beta.Load(), "dark-mode": true},
+		})
+	}))
+	defer server.Close()
+	poller := NewMockEventPoller(nil)
+	poller.TriggerEvent(1)
+
+	flags := NewFlagProvider(NewClient(server.URL), "app", WithEventSource(poller.EventSource()))
+	defer flags.Close()
+
+	if flags.Enabled("beta") || !flags.Enabled("dark-mode") || flags.Enabled("missing") {
+		t.Error("Unexpected initial flag evaluations")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected evaluations to be cached, got %d requests", got)
+	}
+
+	beta.Store(true)
+	poller.TriggerEvent(2)
+	all, err := flags.Flags()
+	if err != nil {
+		t.Fatalf("Flags returned error: %v", err)
+	}
+	if !all["beta"] || !all["dark-mode"] {
+		t.Errorf("Expected flags to be refetched after an event, got %v", all)
+	}
+}
@@ -0,0 +1,135 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newIntrospectionServer returns a client of a hub knowing the "active"
+// token, expiring at expiry, and counting introspection requests.
+func newIntrospectionServer(t *testing.T, expiry time.Time, options ...ClientOption) (*Client, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/public/introspect" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer internal-secret" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		switch request.Token {
+		case "active":
+			json.NewEncoder(w).Encode(IntrospectionResult{Active: true, UserID: 7, Expiry: expiry.Unix(), TokenType: "Bearer"})
+		case "busy":
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "too many introspection requests", http.StatusTooManyRequests)
+		default:
+			json.NewEncoder(w).Encode(IntrospectionResult{})
+		}
+	}))
+	t.Cleanup(server.Close)
+	options = append([]ClientOption{
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithDefaultHeaders(map[string]string{"Authorization": "Bearer internal-secret"}),
+	}, options...)
+	client := NewClient(server.URL, options...)
+	t.Cleanup(func() {
+		client.GetEventPoller().StopEventPolling()
+		client.GetEventPublisher().Stop()
+	})
+	return client, &requests
+}
+
+func TestIntrospectToken(t *testing.T) {
+	now := time.Now()
+	client, requests := newIntrospectionServer(t, now.Add(time.Hour))
+	client.introspections.now = func() time.Time { return now }
+
+	result, err := client.IntrospectToken(context.Background(), "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Active || result.UserID != 7 || result.TokenType != "Bearer" {
+		t.Errorf("Expected an active token of user 7, got %+v", result)
+	}
+	result, err = client.IntrospectToken(context.Background(), "revoked")
+	if err != nil || result.Active {
+		t.Errorf("Expected an inactive token, got %+v: %v", result, err)
+	}
+
+	// Both results are reused until the cache TTL passes
+	client.IntrospectToken(context.Background(), "active")
+	client.IntrospectToken(context.Background(), "revoked")
+	if *requests != 2 {
+		t.Errorf("Expected cached results to be reused, got %d requests", *requests)
+	}
+	now = now.Add(DefaultIntrospectionCacheTTL)
+	client.IntrospectToken(context.Background(), "active")
+	if *requests != 3 {
+		t.Errorf("Expected the result to be refreshed after the TTL, got %d requests", *requests)
+	}
+}
+
+func TestIntrospectTokenCacheHonorsExpiry(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	client, requests := newIntrospectionServer(t, now.Add(5*time.Second))
+	client.introspections.now = func() time.Time { return now }
+
+	client.IntrospectToken(context.Background(), "active")
+	now = now.Add(4 * time.Second)
+	client.IntrospectToken(context.Background(), "active")
+	if *requests != 1 {
+		t.Errorf("Expected the result to be reused before expiry, got %d requests", *requests)
+	}
+	// The token expires well before the cache TTL
+	now = now.Add(time.Second)
+	client.IntrospectToken(context.Background(), "active")
+	if *requests != 2 {
+		t.Errorf("Expected the result not to be reused past expiry, got %d requests", *requests)
+	}
+}
+
+func TestIntrospectTokenWithoutCache(t *testing.T) {
+	client, requests := newIntrospectionServer(t, time.Now().Add(time.Hour), WithIntrospectionCacheTTL(0))
+	client.IntrospectToken(context.Background(), "active")
+	client.IntrospectToken(context.Background(), "active")
+	if *requests != 2 {
+		t.Errorf("Expected every call to ask the hub, got %d requests", *requests)
+	}
+}
+
+func TestIntrospectTokenErrors(t *testing.T) {
+	client, requests := newIntrospectionServer(t, time.Now().Add(time.Hour))
+
+	if _, err := client.IntrospectToken(context.Background(), ""); !IsValidationError(err) {
+		t.Errorf("Expected a validation error for an empty token, got %v", err)
+	}
+	if _, err := client.IntrospectToken(context.Background(), "busy"); !IsAPIError(err) {
+		t.Errorf("Expected an API error when rate limited, got %v", err)
+	}
+	// Errors are not cached
+	client.IntrospectToken(context.Background(), "busy")
+	if *requests != 2 {
+		t.Errorf("Expected errors to be retried, got %d requests", *requests)
+	}
+
+	client.defaultHeaders = nil
+	if _, err := client.IntrospectToken(context.Background(), "other"); !IsAuthenticationError(err) {
+		t.Errorf("Expected an authentication error without internal credentials, got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package yesterdaygo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Query parameters understood by paginated list endpoints.
+const (
+	// CursorParam selects the page to return, using the nextCursor of the
+	// previous page. It is omitted for the first page.
+	CursorParam = "cursor"
+	// LimitParam asks for at most this many items per page.
+	LimitParam = "limit"
+)
+
+// ErrTooManyItems is returned by FetchAll when the collection holds more
+// items than the cap.
+var ErrTooManyItems = errors.New("collection has more items than the limit")
+
+// Page is the list envelope returned by paginated endpoints. An empty
+// NextCursor marks the last page. Endpoints should use a stable cursor, such
+// as the sort key of the last item returned, so that items inserted or
+// deleted between requests don't cause others to be skipped or repeated.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// IterateOption configures Iterate and FetchAll.
+type IterateOption func(*iterateConfig)
+
+type iterateConfig struct {
+	pageSize     int
+	startCursor  string
+	prefetch     bool
+	pageTimeout  time.Duration
+	retries      int
+	retryBackoff time.Duration
+}
+
+// WithPageSize sets the limit parameter sent with every page request. By
+// default the server chooses.
+func WithPageSize(size int) IterateOption {
+	return func(c *iterateConfig) {
+		c.pageSize = size
+	}
+}
+
+// WithStartCursor starts iterating from a cursor returned by
+// Iterator.Cursor, e.g. to resume in a new process.
+func WithStartCursor(cursor string) IterateOption {
+	return func(c *iterateConfig) {
+		c.startCursor = cursor
+	}
+}
+
+// WithPrefetch fetches the next page in the background while the caller is
+// processing the current one.
+func WithPrefetch() IterateOption {
+	return func(c *iterateConfig) {
+		c.prefetch = true
+	}
+}
+
+// WithPageTimeout bounds each page request, including its retries' individual
+// attempts. The context passed to Iterate still bounds the whole iteration.
+func WithPageTimeout(timeout time.Duration) IterateOption {
+	return func(c *iterateConfig) {
+		c.pageTimeout = timeout
+	}
+}
+
+// WithPageRetries retries a page request that failed with a network error, a
+// 5xx or a 429 up to retries times, waiting backoff before the first retry and
+// doubling it each time. By default failed pages are not retried.
+func WithPageRetries(retries int, backoff time.Duration) IterateOption {
+	return func(c *iterateConfig) {
+		c.retries = retries
+		c.retryBackoff = backoff
+	}
+}
+
+type pageResult[T any] struct {
+	page *Page[T]
+	err  error
+}
+
+// Iterator steps through the items of a paginated collection, fetching pages
+// lazily as they are needed. It is not safe for concurrent use.
+//
+//	it := yesterdaygo.Iterate[User](ctx, client, "/admin/api/users", nil)
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		// it.Cursor() is where to resume
+//	}
+type Iterator[T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	client *Client
+	path   string
+	params map[string]interface{}
+	config iterateConfig
+
+	items   []T
+	index   int
+	value   T
+	cursor  string // Cursor of the next page to fetch
+	done    bool   // Whether the last page has been fetched
+	err     error
+	pending chan pageResult[T] // Prefetch of the page at cursor, if any
+}
+
+// Iterate returns an iterator over the items of the paginated collection at
+// path, an endpoint returning Page[T]. params are sent as query parameters
+// with every page request. Call Close once done with the iterator.
+func Iterate[T any](ctx context.Context, client *Client, path string, params map[string]interface{}, opts ...IterateOption) *Iterator[T] {
+	var config iterateConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Iterator[T]{
+		ctx:    ctx,
+		cancel: cancel,
+		client: client,
+		path:   path,
+		params: params,
+		config: config,
+		cursor: config.startCursor,
+	}
+}
+
+// Next advances to the next item, fetching the next page if the current one
+// is exhausted. It returns false at the end of the collection or when a page
+// request fails; check Err to tell which. Calling Next again after an error
+// retries the failed page, so iteration resumes where it stopped.
+func (it *Iterator[T]) Next() bool {
+	for it.index >= len(it.items) {
+		if it.done {
+			return false
+		}
+		page, err := it.nextPage()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.err = nil
+		if page.NextCursor != "" && page.NextCursor == it.cursor {
+			it.err = NewAPIError(fmt.Sprintf("page at cursor %q points back to itself", it.cursor), http.StatusOK)
+			return false
+		}
+		it.items, it.index = page.Items, 0
+		if page.NextCursor == "" {
+			it.done = true
+		} else {
+			it.cursor = page.NextCursor
+			if it.config.prefetch {
+				it.prefetch()
+			}
+		}
+	}
+	it.value = it.items[it.index]
+	it.index++
+	return true
+}
+
+// Value returns the current item.
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Err returns the error that stopped the last call to Next, or nil.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Cursor returns the cursor of the next page to fetch, or "" once the last
+// page has been fetched. When Next stops with an error every item before the
+// failed page has been returned, so passing Cursor to WithStartCursor resumes
+// without skipping or repeating items.
+func (it *Iterator[T]) Cursor() string {
+	if it.done {
+		return ""
+	}
+	return it.cursor
+}
+
+// Close stops any prefetch in progress. The iterator returns no more items
+// afterwards.
+func (it *Iterator[T]) Close() {
+	it.cancel()
+	it.items, it.index = nil, 0
+	it.done = true
+}
+
+// nextPage returns the page at the current cursor, from the prefetch if one
+// is in flight.
+func (it *Iterator[T]) nextPage() (*Page[T], error) {
+	if it.pending != nil {
+		result := <-it.pending
+		it.pending = nil
+		return result.page, result.err
+	}
+	return it.fetchPage(it.cursor)
+}
+
+// prefetch starts fetching the page at the current cursor in the background.
+func (it *Iterator[T]) prefetch() {
+	pending := make(chan pageResult[T], 1)
+	cursor := it.cursor
+	go func() {
+		page, err := it.fetchPage(cursor)
+		pending <- pageResult[T]{page: page, err: err}
+	}()
+	it.pending = pending
+}
+
+// fetchPage requests the page at cursor, retrying transient failures
+// according to the retry policy.
+func (it *Iterator[T]) fetchPage(cursor string) (*Page[T], error) {
+	backoff := it.config.retryBackoff
+	for attempt := 0; ; attempt++ {
+		page, err := it.fetchPageOnce(cursor)
+		if err == nil || attempt >= it.config.retries || !isRetryablePageError(err) || it.ctx.Err() != nil {
+			return page, err
+		}
+		select {
+		case <-it.ctx.Done():
+			return nil, NewNetworkError("page request cancelled", it.ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (it *Iterator[T]) fetchPageOnce(cursor string) (*Page[T], error) {
+	values := url.Values{}
+	for key, value := range it.params {
+		values.Add(key, fmt.Sprintf("%v", value))
+	}
+	if cursor != "" {
+		values.Set(CursorParam, cursor)
+	}
+	if it.config.pageSize > 0 {
+		values.Set(LimitParam, fmt.Sprintf("%d", it.config.pageSize))
+	}
+	requestURL := it.path
+	if len(values) > 0 {
+		separator := "?"
+		if strings.Contains(requestURL, "?") {
+			separator = "&"
+		}
+		requestURL += separator + values.Encode()
+	}
+
+	ctx := it.ctx
+	if it.config.pageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, it.config.pageTimeout)
+		defer cancel()
+	}
+	resp, err := it.client.Get(ctx, requestURL, nil)
+	if err != nil {
+		return nil, NewNetworkError("page request failed", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, WrapHTTPError(resp, "page request failed")
+	}
+	var page Page[T]
+	if err := DecodeJSON(resp.Body, &page); err != nil {
+		return nil, NewErrorWithCause(ErrorTypeAPI, "failed to decode page", err)
+	}
+	return &page, nil
+}
+
+// isRetryablePageError reports whether a failed page request may succeed if
+// retried.
+func isRetryablePageError(err error) bool {
+	if IsNetworkError(err) {
+		return true
+	}
+	var yErr *Error
+	if errors.As(err, &yErr) {
+		return yErr.StatusCode >= 500 || yErr.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// FetchAll returns every item of the paginated collection at path. If the
+// collection holds more than maxItems items it returns the first maxItems
+// along with ErrTooManyItems. If a page request fails it returns the items
+// fetched so far with the error.
+func FetchAll[T any](ctx context.Context, client *Client, path string, params map[string]interface{}, maxItems int, opts ...IterateOption) ([]T, error) {
+	it := Iterate[T](ctx, client, path, params, opts...)
+	defer it.Close()
+
+	items := []T{}
+	for it.Next() {
+		if len(items) == maxItems {
+			return items, NewErrorWithCause(ErrorTypeValidation, fmt.Sprintf("%s holds more than %d items", path, maxItems), ErrTooManyItems)
+		}
+		items = append(items, it.Value())
+	}
+	return items, it.Err()
+}
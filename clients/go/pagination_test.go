@@ -0,0 +1,222 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	ID int `json:"id"`
+}
+
+// unstableCollection serves items sorted by ID in pages, using the last ID
+// returned as a stable cursor. Every page request first runs mutate, which
+// can insert items anywhere in the collection.
+type unstableCollection struct {
+	mu       sync.Mutex
+	ids      []int
+	requests int
+	mutate   func(requests int, c *unstableCollection)
+	fail     func(requests int) int // Status to fail the request with, or 0
+}
+
+func (c *unstableCollection) insert(id int) {
+	c.ids = append(c.ids, id)
+	sort.Ints(c.ids)
+}
+
+func (c *unstableCollection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.fail != nil {
+		if status := c.fail(c.requests); status != 0 {
+			http.Error(w, "unavailable", status)
+			return
+		}
+	}
+	if c.mutate != nil {
+		c.mutate(c.requests, c)
+	}
+
+	after := -1
+	if cursor := r.URL.Query().Get(CursorParam); cursor != "" {
+		after, _ = strconv.Atoi(cursor)
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get(LimitParam))
+	page := Page[testItem]{Items: []testItem{}}
+	for _, id := range c.ids {
+		if id <= after {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = strconv.Itoa(page.Items[len(page.Items)-1].ID)
+			break
+		}
+		page.Items = append(page.Items, testItem{ID: id})
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func newUnstableCollection(count int) *unstableCollection {
+	c := &unstableCollection{}
+	for id := 1; id <= count; id++ {
+		c.ids = append(c.ids, id*10)
+	}
+	return c
+}
+
+func collectIDs(t *testing.T, it *Iterator[testItem]) []int {
+	t.Helper()
+	var ids []int
+	for it.Next() {
+		ids = append(ids, it.Value().ID)
+	}
+	return ids
+}
+
+func TestIterateWithInsertionsBetweenPages(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []IterateOption
+	}{
+		{"lazy", []IterateOption{WithPageSize(3)}},
+		{"prefetch", []IterateOption{WithPageSize(3), WithPrefetch()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			collection := newUnstableCollection(10)
+			// Before every page after the first, insert one item behind the
+			// cursor and one ahead of it
+			collection.mutate = func(requests int, c *unstableCollection) {
+				if requests > 1 {
+					c.insert(requests*10 - 5)
+					c.insert(1000 + requests)
+				}
+			}
+			client := newDownloadTestClient(t, collection.ServeHTTP)
+
+			it := Iterate[testItem](context.Background(), client, "/app/api/items", map[string]interface{}{"sort": "id"}, tc.opts...)
+			defer it.Close()
+			ids := collectIDs(t, it)
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			seen := map[int]bool{}
+			for i, id := range ids {
+				if seen[id] {
+					t.Errorf("Item %d returned twice", id)
+				}
+				seen[id] = true
+				if i > 0 && id <= ids[i-1] {
+					t.Errorf("Items out of order: %v", ids)
+				}
+			}
+			for id := 10; id <= 100; id += 10 {
+				if !seen[id] {
+					t.Errorf("Item %d present from the start was skipped", id)
+				}
+			}
+			// Items inserted ahead of the cursor are picked up
+			if !seen[1002] {
+				t.Errorf("Expected items inserted ahead of the cursor, got %v", ids)
+			}
+		})
+	}
+}
+
+func TestIterateResumesAfterError(t *testing.T) {
+	collection := newUnstableCollection(9)
+	collection.fail = func(requests int) int {
+		if requests == 2 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	client := newDownloadTestClient(t, collection.ServeHTTP)
+
+	it := Iterate[testItem](context.Background(), client, "/app/api/items", nil, WithPageSize(3), WithPrefetch())
+	defer it.Close()
+	ids := collectIDs(t, it)
+	var yErr *Error
+	if err := it.Err(); !errors.As(err, &yErr) || yErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the failed page's error, got %v", err)
+	}
+	if len(ids) != 3 || it.Cursor() != "30" {
+		t.Fatalf("Expected the first page and a cursor after it, got %v %q", ids, it.Cursor())
+	}
+
+	// Resume in place
+	ids = append(ids, collectIDs(t, it)...)
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 9 {
+		t.Errorf("Expected every item once, got %v", ids)
+	}
+
+	// Resume from the cursor in a new iterator
+	resumed := Iterate[testItem](context.Background(), client, "/app/api/items", nil, WithPageSize(3), WithStartCursor("60"))
+	defer resumed.Close()
+	if ids := collectIDs(t, resumed); len(ids) != 3 || ids[0] != 70 {
+		t.Errorf("Expected the items after the cursor, got %v", ids)
+	}
+}
+
+func TestIterateRetriesTransientErrors(t *testing.T) {
+	collection := newUnstableCollection(5)
+	collection.fail = func(requests int) int {
+		switch requests {
+		case 2:
+			return http.StatusTooManyRequests
+		case 4:
+			return http.StatusNotFound // Not retried
+		}
+		return 0
+	}
+	client := newDownloadTestClient(t, collection.ServeHTTP)
+
+	it := Iterate[testItem](context.Background(), client, "/app/api/items", nil, WithPageSize(2), WithPageRetries(2, time.Millisecond))
+	defer it.Close()
+	ids := collectIDs(t, it)
+	if len(ids) != 4 || !IsAPIError(it.Err()) {
+		t.Fatalf("Expected two pages, the second retried, and then the 404, got %v: %v", ids, it.Err())
+	}
+}
+
+func TestIteratePageTimeout(t *testing.T) {
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	it := Iterate[testItem](context.Background(), client, "/app/api/items", nil, WithPageTimeout(20*time.Millisecond))
+	defer it.Close()
+	if it.Next() || !IsNetworkError(it.Err()) || !errors.Is(it.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected the page request to time out, got %v", it.Err())
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	collection := newUnstableCollection(7)
+	client := newDownloadTestClient(t, collection.ServeHTTP)
+
+	items, err := FetchAll[testItem](context.Background(), client, "/app/api/items", nil, 10, WithPageSize(3), WithPrefetch())
+	if err != nil || len(items) != 7 {
+		t.Fatalf("Expected all 7 items, got %d: %v", len(items), err)
+	}
+
+	items, err = FetchAll[testItem](context.Background(), client, "/app/api/items", nil, 5, WithPageSize(3))
+	if !errors.Is(err, ErrTooManyItems) || len(items) != 5 {
+		t.Errorf("Expected the first 5 items and ErrTooManyItems, got %d: %v", len(items), err)
+	}
+}
@@ -2,24 +2,78 @@ package yesterdaygo
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
 
+// DataProviderOption configures optional DataProvider behavior
+type DataProviderOption func(*dataProviderConfig)
+
+type dataProviderConfig struct {
+	fields             []string
+	minRefetchInterval time.Duration
+	events             EventSource
+	partialResults     bool
+}
+
+// DefaultMinRefetchInterval is the default minimum time between the starts of
+// two refetches triggered by event notifications.
+const DefaultMinRefetchInterval = time.Second
+
+// EventSource reports the latest event ID processed by each application
+// instance. *EventPoller implements it; MockEventPoller.EventSource returns
+// one for tests.
+type EventSource interface {
+	GetCurrentEventId(instanceID string) int
+	SubscribeToEvents(instanceID string) <-chan int
+}
+
+// WithFields asks the server to return only the named fields of each record,
+// via the "fields" query parameter. The provider's type parameter may then be
+// a narrower struct containing just those fields.
+func WithFields(fields ...string) DataProviderOption {
+	return func(c *dataProviderConfig) {
+		c.fields = fields
+	}
+}
+
+// WithMinRefetchInterval sets the minimum time between the starts of two
+// refetches triggered by event notifications. Notifications arriving sooner
+// only mark the data dirty; it is refetched once the interval has passed.
+// The default is DefaultMinRefetchInterval.
+func WithMinRefetchInterval(interval time.Duration) DataProviderOption {
+	return func(c *dataProviderConfig) {
+		c.minRefetchInterval = interval
+	}
+}
+
+// WithEventSource makes the provider follow event IDs from the given source
+// instead of the client's event poller.
+func WithEventSource(events EventSource) DataProviderOption {
+	return func(c *dataProviderConfig) {
+		c.events = events
+	}
+}
+
 // DataProvider provides type-safe data access with automatic refresh on event changes
 type DataProvider[T any] struct {
 	client            *Client
 	instanceID        string
 	uri               string
 	params            map[string]interface{}
+	fields            []string
+	events            EventSource
+	minRefetch        time.Duration
 	data              T
+	etag              string // ETag of data, sent as If-None-Match
 	lastEventId       int
+	unnotified        bool // data has not been passed to refreshCallback
 	refreshCallback   func(T)
-	mu                sync.RWMutex // Protects data, lastEventId, and refreshCallback
+	mu                sync.RWMutex // Protects data, etag, lastEventId, unnotified, and refreshCallback
 	eventSubscription <-chan int
 	ctx               context.Context
 	cancel            context.CancelFunc
@@ -27,9 +81,22 @@ type DataProvider[T any] struct {
 	subscriptionMu    sync.Mutex // Protects subscription state
 }
 
-// NewDataProvider creates a new generic data provider
-func NewDataProvider[T any](client *Client, instanceID string, uri string, params map[string]interface{}) *DataProvider[T] {
+// NewDataProvider creates a new generic data provider for uri, e.g.
+// "api/users", on the application instance. An empty instanceID selects the
+// client's default instance, see WithDefaultInstance.
+func NewDataProvider[T any](client *Client, instanceID string, uri string, params map[string]interface{}, opts ...DataProviderOption) *DataProvider[T] {
 	ctx, cancel := context.WithCancel(context.Background())
+	if instanceID == "" {
+		instanceID = client.DefaultInstance()
+	}
+
+	config := &dataProviderConfig{minRefetchInterval: DefaultMinRefetchInterval}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.events == nil {
+		config.events = client.GetEventPoller()
+	}
 
 	return &DataProvider[T]{
 		client:      client,
@@ -37,6 +104,9 @@ func NewDataProvider[T any](client *Client, instanceID string, uri string, param
 		instanceID:  instanceID,
 		lastEventId: -1,
 		params:      params,
+		fields:      config.fields,
+		events:      config.events,
+		minRefetch:  config.minRefetchInterval,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
@@ -47,8 +117,7 @@ func (dp *DataProvider[T]) Get() (T, error) {
 	var zero T
 
 	// Check if we need to refresh based on current event number
-	poller := dp.client.GetEventPoller()
-	currentEventId := poller.GetCurrentEventId(dp.instanceID)
+	currentEventId := dp.events.GetCurrentEventId(dp.instanceID)
 
 	dp.mu.RLock()
 	needsRefresh := dp.lastEventId < currentEventId || dp.lastEventId == -1
@@ -71,13 +140,31 @@ func (dp *DataProvider[T]) Get() (T, error) {
 
 // Refresh manually refreshes the data from the API
 func (dp *DataProvider[T]) Refresh() error {
+	// Record the event ID before fetching, since the response is only
+	// guaranteed to reflect events processed before the request was sent
+	result := dp.fetch(dp.events.GetCurrentEventId(dp.instanceID))
+	if result.err != nil {
+		return result.err
+	}
+	dp.store(result, true)
+	return nil
+}
+
+// fetch requests the data from the API, recording eventId as the event it
+// reflects. The ETag of the cached data is sent as If-None-Match, and if
+// the server answers 304 Not Modified the result is marked notModified
+// instead of carrying data.
+func (dp *DataProvider[T]) fetch(eventId int) fetchResult[T] {
 	// Build the request URL with parameters
-	requestURL := fmt.Sprintf("/%s/%s", dp.instanceID, dp.uri)
-	if len(dp.params) > 0 {
+	requestURL := dp.client.AppPath(dp.instanceID, dp.uri)
+	if len(dp.params) > 0 || len(dp.fields) > 0 {
 		values := url.Values{}
 		for key, value := range dp.params {
 			values.Add(key, fmt.Sprintf("%v", value))
 		}
+		if len(dp.fields) > 0 {
+			values.Set("fields", strings.Join(dp.fields, ","))
+		}
 		requestURL += "?" + values.Encode()
 	}
 
@@ -85,38 +172,59 @@ func (dp *DataProvider[T]) Refresh() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	resp, err := dp.client.Get(ctx, requestURL, nil)
+	var headers map[string]string
+	dp.mu.RLock()
+	if dp.etag != "" {
+		headers = map[string]string{"If-None-Match": dp.etag}
+	}
+	dp.mu.RUnlock()
+
+	resp, err := dp.client.Get(ctx, requestURL, headers)
 	if err != nil {
-		return fmt.Errorf("API request failed: %w", err)
+		return fetchResult[T]{err: fmt.Errorf("API request failed: %w", err)}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && headers != nil {
+		return fetchResult[T]{eventId: eventId, notModified: true}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return fetchResult[T]{err: fmt.Errorf("API request failed with status %d", resp.StatusCode)}
 	}
 
 	// Parse the response
-	var newData T
-	if err := json.NewDecoder(resp.Body).Decode(&newData); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
+	result := fetchResult[T]{eventId: eventId, etag: resp.Header.Get("ETag")}
+	if err := DecodeJSON(resp.Body, &result.data); err != nil {
+		return fetchResult[T]{err: fmt.Errorf("failed to decode response: %w", err)}
 	}
+	return result
+}
 
-	// Update the cached data and event number
-	poller := dp.client.GetEventPoller()
-	currentEventId := poller.GetCurrentEventId(dp.instanceID)
-
+// store updates the cached data and, if notify is set, calls the refresh
+// callback. A not-modified result only records its event ID, keeping the
+// cached data; the callback is then called only if an earlier store left the
+// cached data unnotified, e.g. a coalesced fetch whose follow-up found no
+// further changes.
+func (dp *DataProvider[T]) store(result fetchResult[T], notify bool) {
 	dp.mu.Lock()
-	dp.data = newData
-	dp.lastEventId = currentEventId
+	dp.lastEventId = result.eventId
+	if result.notModified {
+		if !notify || !dp.unnotified {
+			dp.mu.Unlock()
+			return
+		}
+	} else {
+		dp.data = result.data
+		dp.etag = result.etag
+	}
+	data := dp.data
+	dp.unnotified = !notify
 	callback := dp.refreshCallback
 	dp.mu.Unlock()
 
-	// Call the refresh callback if one is set
-	if callback != nil {
-		callback(newData)
+	if notify && callback != nil {
+		callback(data)
 	}
-
-	return nil
 }
 
 // Subscribe registers a callback for automatic data refresh notifications
@@ -134,8 +242,7 @@ func (dp *DataProvider[T]) Subscribe(callback func(T)) error {
 	dp.mu.Unlock()
 
 	// Subscribe to event notifications
-	poller := dp.client.GetEventPoller()
-	dp.eventSubscription = poller.SubscribeToEvents(dp.instanceID)
+	dp.eventSubscription = dp.events.SubscribeToEvents(dp.instanceID)
 	dp.isSubscribed = true
 
 	// Start the event listening goroutine
@@ -162,23 +269,83 @@ func (dp *DataProvider[T]) Unsubscribe() {
 	dp.mu.Unlock()
 }
 
-// eventLoop handles automatic refresh when events are received
+type fetchResult[T any] struct {
+	data        T
+	etag        string
+	notModified bool
+	eventId     int
+	err         error
+}
+
+// eventLoop refetches the data when event notifications arrive. Bursts of
+// notifications are coalesced: at most one fetch is in flight, fetches start
+// at least minRefetch apart, and notifications arriving in between only mark
+// the data dirty so that exactly one follow-up fetch is made. A fetch whose
+// data is already stale when it completes updates the cache without calling
+// the callback, so subscribers only see the final value of a burst; the
+// follow-up fetch delivers it even if the server answers 304.
 func (dp *DataProvider[T]) eventLoop() {
+	var (
+		dirty     bool
+		fetching  bool
+		requested int // Event ID observed by the last fetch started
+		lastFetch time.Time
+		timer     *time.Timer
+		timerC    <-chan time.Time
+		fetchDone = make(chan fetchResult[T], 1)
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	dp.mu.RLock()
+	requested = dp.lastEventId
+	dp.mu.RUnlock()
+
+	maybeFetch := func() {
+		if !dirty || fetching || timerC != nil {
+			return
+		}
+		if wait := dp.minRefetch - time.Since(lastFetch); wait > 0 {
+			timer = time.NewTimer(wait)
+			timerC = timer.C
+			return
+		}
+		dirty = false
+		fetching = true
+		lastFetch = time.Now()
+		requested = dp.events.GetCurrentEventId(dp.instanceID)
+		go func(eventId int) {
+			fetchDone <- dp.fetch(eventId)
+		}(requested)
+	}
+
 	for {
 		select {
-		case eventId := <-dp.eventSubscription:
-			// Check if we need to refresh
-			dp.mu.RLock()
-			needsRefresh := dp.lastEventId < eventId
-			dp.mu.RUnlock()
-
-			if needsRefresh {
-				// Refresh data and call callback
-				if err := dp.Refresh(); err != nil {
-					// In a production system, you might want to log this error
-					continue
-				}
+		case eventId, ok := <-dp.eventSubscription:
+			if !ok {
+				return
+			}
+			if eventId > requested {
+				dirty = true
+				maybeFetch()
 			}
+		case <-timerC:
+			timerC = nil
+			maybeFetch()
+		case result := <-fetchDone:
+			fetching = false
+			if result.err != nil {
+				// Retry on the next notification
+				dp.mu.RLock()
+				requested = dp.lastEventId
+				dp.mu.RUnlock()
+			} else {
+				dp.store(result, !dirty)
+			}
+			maybeFetch()
 		case <-dp.ctx.Done():
 			return // Subscription cancelled
 		}
@@ -197,6 +364,12 @@ func (dp *DataProvider[T]) GetURI() string {
 	return dp.uri
 }
 
+// GetFields returns the fields selected with WithFields, or nil if the full
+// records are requested
+func (dp *DataProvider[T]) GetFields() []string {
+	return append([]string(nil), dp.fields...)
+}
+
 // GetParams returns a copy of the query parameters
 func (dp *DataProvider[T]) GetParams() map[string]interface{} {
 	result := make(map[string]interface{})
@@ -210,6 +383,11 @@ func (dp *DataProvider[T]) GetParams() map[string]interface{} {
 func (dp *DataProvider[T]) SetParams(params map[string]interface{}) error {
 	dp.params = params
 
+	// The cached data's ETag is for the old parameters
+	dp.mu.Lock()
+	dp.etag = ""
+	dp.mu.Unlock()
+
 	// If we're subscribed, trigger a refresh
 	dp.subscriptionMu.Lock()
 	isSubscribed := dp.isSubscribed
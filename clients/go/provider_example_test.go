@@ -32,7 +32,7 @@ func ExampleDataProvider() {
 	}
 	
 	// Create a data provider for user data
-	userProvider := yesterdaygo.NewDataProvider[User](client, "/api/users/123", nil)
+	userProvider := yesterdaygo.NewDataProvider[User](client, "MBtskI6D", "api/users/123", nil)
 	
 	// Get user data (will fetch from API on first call)
 	user, err := userProvider.Get()
@@ -67,7 +67,7 @@ func ExampleDataProvider_withParameters() {
 		"active":   true,
 	}
 	
-	usersProvider := yesterdaygo.NewDataProvider[UserList](client, "/api/users", params)
+	usersProvider := yesterdaygo.NewDataProvider[UserList](client, "MBtskI6D", "api/users", params)
 	
 	// Get users list
 	userList, err := usersProvider.Get()
@@ -108,14 +108,15 @@ func ExampleDataProvider_subscription() {
 	
 	// Start event polling
 	poller := client.GetEventPoller()
-	if err := poller.StartEventPolling(2 * time.Second); err != nil {
+	poller.SetPollInterval(2 * time.Second)
+	if err := poller.StartEventPolling(); err != nil {
 		log.Printf("Failed to start event polling: %v", err)
 		return
 	}
 	defer poller.StopEventPolling()
 	
 	// Create data provider
-	userProvider := yesterdaygo.NewDataProvider[User](client, "/api/users/123", nil)
+	userProvider := yesterdaygo.NewDataProvider[User](client, "MBtskI6D", "api/users/123", nil)
 	defer userProvider.Close()
 	
 	// Subscribe to automatic updates
@@ -139,13 +140,13 @@ func ExampleDataProvider_subscription() {
 	// Wait for automatic updates (in a real app, you'd do other work)
 	time.Sleep(10 * time.Second)
 	
-	fmt.Printf("Last event number: %d\n", userProvider.GetLastEventNumber())
+	fmt.Printf("Last event number: %d\n", userProvider.GetLastEventId())
 }
 
 // ExampleDataProvider_manualRefresh demonstrates manual data refresh
 func ExampleDataProvider_manualRefresh() {
 	client := yesterdaygo.NewClient("https://api.yesterday.localhost")
-	userProvider := yesterdaygo.NewDataProvider[User](client, "/api/users/123", nil)
+	userProvider := yesterdaygo.NewDataProvider[User](client, "MBtskI6D", "api/users/123", nil)
 	defer userProvider.Close()
 	
 	// Get initial data
@@ -155,7 +156,7 @@ func ExampleDataProvider_manualRefresh() {
 		return
 	}
 	
-	fmt.Printf("Initial: %s (event: %d)\n", user.Username, userProvider.GetLastEventNumber())
+	fmt.Printf("Initial: %s (event: %d)\n", user.Username, userProvider.GetLastEventId())
 	
 	// Wait a bit, then manually refresh
 	time.Sleep(2 * time.Second)
@@ -172,7 +173,7 @@ func ExampleDataProvider_manualRefresh() {
 		return
 	}
 	
-	fmt.Printf("Refreshed: %s (event: %d)\n", user2.Username, userProvider.GetLastEventNumber())
+	fmt.Printf("Refreshed: %s (event: %d)\n", user2.Username, userProvider.GetLastEventId())
 }
 
 // ExampleDataProvider_multipleProviders demonstrates multiple data providers
@@ -181,15 +182,16 @@ func ExampleDataProvider_multipleProviders() {
 	
 	// Start event polling for automatic updates
 	poller := client.GetEventPoller()
-	if err := poller.StartEventPolling(3 * time.Second); err != nil {
+	poller.SetPollInterval(3 * time.Second)
+	if err := poller.StartEventPolling(); err != nil {
 		log.Printf("Failed to start polling: %v", err)
 		return
 	}
 	defer poller.StopEventPolling()
 	
 	// Create multiple data providers
-	userProvider := yesterdaygo.NewDataProvider[User](client, "/api/users/123", nil)
-	usersProvider := yesterdaygo.NewDataProvider[UserList](client, "/api/users", map[string]interface{}{
+	userProvider := yesterdaygo.NewDataProvider[User](client, "MBtskI6D", "api/users/123", nil)
+	usersProvider := yesterdaygo.NewDataProvider[UserList](client, "MBtskI6D", "api/users", map[string]interface{}{
 		"active": true,
 	})
 	defer userProvider.Close()
@@ -0,0 +1,384 @@
+package yesterdaygo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type userSummary struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type userSummaries struct {
+	Users []userSummary `json:"users"`
+}
+
+// newTestProviderServer returns a server for the "test" instance that records
+// the query strings it receives.
+func newTestProviderServer(t *testing.T, body any) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), queries...)
+	}
+}
+
+func TestDataProviderWithFields(t *testing.T) {
+	server, queries := newTestProviderServer(t, map[string]any{
+		"users": []map[string]any{{"id": 1, "username": "admin"}},
+	})
+	client := NewClient(server.URL)
+
+	provider := NewDataProvider[userSummaries](client, "test", "api/users", map[string]interface{}{"active": true}, WithFields("id", "username"))
+	defer provider.Close()
+
+	if err := provider.Refresh(); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+	if got := queries(); len(got) != 1 || got[0] != "active=true&fields=id%2Cusername" {
+		t.Errorf("Expected fields to be sent as a query parameter, got %v", got)
+	}
+
+	data, err := provider.Get()
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if len(data.Users) != 1 || data.Users[0].Username != "admin" {
+		t.Errorf("Expected narrowed user data, got %+v", data)
+	}
+	if fields := provider.GetFields(); len(fields) != 2 || fields[0] != "id" {
+		t.Errorf("Expected GetFields to return [id username], got %v", fields)
+	}
+}
+
+func TestDataProviderWithoutFields(t *testing.T) {
+	server, queries := newTestProviderServer(t, userSummaries{})
+	client := NewClient(server.URL)
+
+	provider := NewDataProvider[userSummaries](client, "test", "api/users", nil)
+	defer provider.Close()
+
+	if err := provider.Refresh(); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+	if got := queries(); len(got) != 1 || got[0] != "" {
+		t.Errorf("Expected no query string, got %v", got)
+	}
+	if provider.GetFields() != nil {
+		t.Errorf("Expected no fields, got %v", provider.GetFields())
+	}
+}
+
+func TestDataProviderDefaultInstance(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewEncoder(w).Encode(userSummaries{})
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, WithDefaultInstance("admin"))
+
+	provider := NewDataProvider[userSummaries](client, "", "api/users", nil)
+	defer provider.Close()
+	if err := provider.Refresh(); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+	if path != "/admin/api/users" {
+		t.Errorf("Expected a request to the default instance, got %q", path)
+	}
+	if provider.sourceInstanceID() != "admin" {
+		t.Errorf("Expected events of the default instance to be followed, got %q", provider.sourceInstanceID())
+	}
+}
+
+type eventSnapshot struct {
+	Event int64 `json:"event"`
+}
+
+// newEventSnapshotServer serves the mock poller's current event number at
+// /test/api/snapshot and counts the requests. Each request first calls
+// before, if set, with the request number.
+func newEventSnapshotServer(t *testing.T, poller *MockEventPoller, before func(n int)) (*httptest.Server, func() []time.Time) {
+	var mu sync.Mutex
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test/api/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, time.Now())
+		n := len(requests)
+		mu.Unlock()
+		if before != nil {
+			before(n)
+		}
+		json.NewEncoder(w).Encode(eventSnapshot{Event: poller.GetCurrentEventNumber()})
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), requests...)
+	}
+}
+
+func TestDataProviderCoalescesEventBursts(t *testing.T) {
+	poller := NewMockEventPoller(nil)
+	firstFetch := make(chan struct{})
+	releaseFirst := make(chan struct{})
+	server, requests := newEventSnapshotServer(t, poller, func(n int) {
+		if n == 1 {
+			close(firstFetch)
+			<-releaseFirst
+		}
+	})
+
+	provider := NewDataProvider[eventSnapshot](NewClient(server.URL), "test", "api/snapshot", nil,
+		WithEventSource(poller.EventSource()), WithMinRefetchInterval(50*time.Millisecond))
+	defer provider.Close()
+
+	delivered := make(chan eventSnapshot, 10)
+	if err := provider.Subscribe(func(data eventSnapshot) { delivered <- data }); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+
+	// A burst of 500 events arrives while the first fetch is in flight
+	poller.TriggerEvent(1)
+	<-firstFetch
+	for i := int64(2); i <= 500; i++ {
+		poller.TriggerEvent(i)
+	}
+	close(releaseFirst)
+
+	select {
+	case data := <-delivered:
+		if data.Event != 500 {
+			t.Errorf("Expected subscribers to receive only the final value, got event %d", data.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the refetched data")
+	}
+
+	// Give any extra fetches a chance to happen before counting
+	time.Sleep(150 * time.Millisecond)
+	if got := len(requests()); got != 2 {
+		t.Errorf("Expected the burst to cause exactly one follow-up fetch, got %d fetches", got)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("Expected a single delivery, got %d more", len(delivered))
+	}
+	if got := provider.GetLastEventId(); got != 500 {
+		t.Errorf("Expected provider to be caught up to event 500, got %d", got)
+	}
+}
+
+func TestDataProviderMinRefetchInterval(t *testing.T) {
+	poller := NewMockEventPoller(nil)
+	server, requests := newEventSnapshotServer(t, poller, nil)
+
+	interval := 100 * time.Millisecond
+	provider := NewDataProvider[eventSnapshot](NewClient(server.URL), "test", "api/snapshot", nil,
+		WithEventSource(poller.EventSource()), WithMinRefetchInterval(interval))
+	defer provider.Close()
+
+	delivered := make(chan eventSnapshot, 10)
+	if err := provider.Subscribe(func(data eventSnapshot) { delivered <- data }); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+
+	poller.TriggerEvent(1)
+	<-delivered
+	poller.TriggerEvent(2)
+	poller.TriggerEvent(3)
+	if data := <-delivered; data.Event != 3 {
+		t.Errorf("Expected event 3 after the interval, got %d", data.Event)
+	}
+
+	times := requests()
+	if len(times) != 2 {
+		t.Fatalf("Expected 2 fetches, got %d", len(times))
+	}
+	// Timestamps are taken by the server, slightly after each fetch starts
+	if gap := times[1].Sub(times[0]); gap < interval*9/10 {
+		t.Errorf("Expected fetches at least %v apart, got %v", interval, gap)
+	}
+}
+
+func TestDataProviderRecordsEventBeforeFetch(t *testing.T) {
+	poller := NewMockEventPoller(nil)
+	server, _ := newEventSnapshotServer(t, poller, func(int) {
+		// An event is processed while the request is in flight
+		poller.TriggerEvent(2)
+	})
+	poller.TriggerEvent(1)
+
+	provider := NewDataProvider[eventSnapshot](NewClient(server.URL), "test", "api/snapshot", nil,
+		WithEventSource(poller.EventSource()))
+	defer provider.Close()
+
+	if err := provider.Refresh(); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+	if got := provider.GetLastEventId(); got != 1 {
+		t.Errorf("Expected provider to record event 1, observed before the fetch, got %d", got)
+	}
+}
+
+func TestDataProviderConditionalRefetch(t *testing.T) {
+	var mu sync.Mutex
+	var ifNoneMatch []string
+	body := `{"users":[{"id":1,"username":"admin"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		current := body
+		mu.Unlock()
+		etag := `"` + current + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(current))
+	}))
+	defer server.Close()
+	poller := NewMockEventPoller(nil)
+
+	provider := NewDataProvider[userSummaries](NewClient(server.URL), "test", "api/users", nil,
+		WithEventSource(poller.EventSource()), WithMinRefetchInterval(0))
+	defer provider.Close()
+	if err := provider.Refresh(); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+
+	delivered := make(chan userSummaries, 10)
+	if err := provider.Subscribe(func(data userSummaries) { delivered <- data }); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+
+	// An event that leaves the data unchanged keeps the cached value
+	poller.TriggerEvent(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for provider.GetLastEventId() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := provider.GetLastEventId(); got != 1 {
+		t.Fatalf("Expected the provider to catch up to event 1, got %d", got)
+	}
+	if len(delivered) != 0 {
+		t.Error("Expected no callback for unchanged data")
+	}
+	if data := provider.Cached(); len(data.Users) != 1 || data.Users[0].Username != "admin" {
+		t.Errorf("Expected the cached data to be kept, got %+v", data)
+	}
+
+	// Changed data is downloaded and delivered
+	mu.Lock()
+	body = `{"users":[{"id":1,"username":"tom"}]}`
+	mu.Unlock()
+	poller.TriggerEvent(2)
+	select {
+	case data := <-delivered:
+		if len(data.Users) != 1 || data.Users[0].Username != "tom" {
+			t.Errorf("Expected the changed data, got %+v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the changed data")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	first := `"{"users":[{"id":1,"username":"admin"}]}"`
+	if len(ifNoneMatch) != 3 || ifNoneMatch[0] != "" || ifNoneMatch[1] != first || ifNoneMatch[2] != first {
+		t.Errorf("Expected the cached ETag to be sent after the first fetch, got %q", ifNoneMatch)
+	}
+}
+
+func TestDataProviderDeliversCoalescedDataOnNotModified(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	firstEventFetch := make(chan struct{})
+	releaseFetch := make(chan struct{})
+	body := `{"users":[{"id":1,"username":"admin"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		current := body
+		mu.Unlock()
+		if n == 2 {
+			close(firstEventFetch)
+			<-releaseFetch
+		}
+		etag := `"` + current + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(current))
+	}))
+	defer server.Close()
+	poller := NewMockEventPoller(nil)
+
+	provider := NewDataProvider[userSummaries](NewClient(server.URL), "test", "api/users", nil,
+		WithEventSource(poller.EventSource()), WithMinRefetchInterval(0))
+	defer provider.Close()
+	if err := provider.Refresh(); err != nil {
+		t.Fatalf("Refresh returned error: %v", err)
+	}
+
+	delivered := make(chan userSummaries, 10)
+	if err := provider.Subscribe(func(data userSummaries) { delivered <- data }); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+
+	// The fetch for event 1 downloads the new data while event 2 arrives, so
+	// it is stored without a callback. The follow-up fetch is answered with
+	// 304, and the data must still reach the subscriber.
+	mu.Lock()
+	body = `{"users":[{"id":1,"username":"tom"}]}`
+	mu.Unlock()
+	poller.TriggerEvent(1)
+	<-firstEventFetch
+	poller.TriggerEvent(2)
+	close(releaseFetch)
+
+	select {
+	case data := <-delivered:
+		if len(data.Users) != 1 || data.Users[0].Username != "tom" {
+			t.Errorf("Expected the changed data, got %+v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the changed data")
+	}
+	if got := provider.GetLastEventId(); got != 2 {
+		t.Errorf("Expected the provider to be caught up to event 2, got %d", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(delivered) != 0 {
+		t.Errorf("Expected a single delivery, got %d more", len(delivered))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 {
+		t.Errorf("Expected one follow-up fetch, got %d requests", requests)
+	}
+}
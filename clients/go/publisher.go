@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -25,14 +26,50 @@ type EventPublisher struct {
 	stopCh       chan struct{}
 	flushCh      chan chan error
 	wg           sync.WaitGroup
+
+	// Bounded queue settings; protected by queueMu
+	maxQueueLength int
+	fullPolicy     QueueFullPolicy
+	highWaterMark  int
+	spaceFreed     chan struct{} // Closed and replaced whenever the queue shrinks
+
+	// onRejected is called for events the hub refuses, see
+	// WithRejectionHandler
+	onRejected func(event PendingEvent, err error)
 }
 
+// QueueFullPolicy selects what PublishEvent does when the queue is at its
+// maximum length.
+type QueueFullPolicy int
+
+const (
+	// QueueFullError rejects the new event with ErrQueueFull
+	QueueFullError QueueFullPolicy = iota
+	// QueueFullDropOldest discards the oldest queued event to make room
+	QueueFullDropOldest
+)
+
+// IdempotencyKeyHeader is the header NexusHub uses to recognize retries of a
+// mutating request: a request repeated with the same key and body gets the
+// stored response instead of being executed again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrQueueFull is returned by PublishEvent when the queue is full and the
+// publisher uses the QueueFullError policy.
+var ErrQueueFull = errors.New("event queue is full")
+
+// MaxAtomicEvents is the most events PublishAtomic can publish as a group.
+const MaxAtomicEvents = 100
+
 // PendingEvent represents an event awaiting publication
 type PendingEvent struct {
 	ClientID    string      `json:"clientID"`
 	Payload     interface{} `json:"payload"`
 	Attempts    int         `json:"attempts"`
 	LastAttempt time.Time   `json:"lastAttempt"`
+	// Atomic is set for a group of events queued by PublishAtomic, whose
+	// Payload holds all of them
+	Atomic bool `json:"atomic,omitempty"`
 }
 
 // PublisherOption represents a functional option for configuring the EventPublisher
@@ -59,6 +96,29 @@ func WithBatchSize(batchSize int) PublisherOption {
 	}
 }
 
+// WithMaxQueueLength bounds the number of events waiting to be published.
+// When the queue is full, PublishEvent applies the given policy and
+// PublishEventBlocking waits for space. A length of 0 (the default) leaves
+// the queue unbounded.
+func WithMaxQueueLength(maxLength int, policy QueueFullPolicy) PublisherOption {
+	return func(p *EventPublisher) {
+		p.maxQueueLength = maxLength
+		p.fullPolicy = policy
+	}
+}
+
+// WithRejectionHandler calls handler, from the publishing goroutine, with
+// each event the hub refuses with a client error and which is dropped from
+// the queue without retrying, and the error it was refused with. Events of a
+// type the user may not publish are refused with an error for which
+// IsForbiddenEventError is true, so that UIs can explain why the change was
+// not saved.
+func WithRejectionHandler(handler func(event PendingEvent, err error)) PublisherOption {
+	return func(p *EventPublisher) {
+		p.onRejected = handler
+	}
+}
+
 // NewEventPublisher creates a new EventPublisher with the given client and options
 func NewEventPublisher(client *Client, options ...PublisherOption) *EventPublisher {
 	publisher := &EventPublisher{
@@ -69,6 +129,7 @@ func NewEventPublisher(client *Client, options ...PublisherOption) *EventPublish
 		batchSize:    1,
 		stopCh:       make(chan struct{}),
 		flushCh:      make(chan chan error, 1),
+		spaceFreed:   make(chan struct{}),
 	}
 
 	// Apply options
@@ -92,32 +153,112 @@ func GenerateClientID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// PublishEvent adds an event to the publish queue and triggers immediate publish attempt
+// PublishEvent adds an event to the publish queue and triggers immediate publish attempt.
+// If the queue is bounded and full, the publisher's QueueFullPolicy decides
+// whether the event is rejected with ErrQueueFull or replaces the oldest one.
+// Events the client's EventRegistry rejects are not queued.
 func (p *EventPublisher) PublishEvent(clientId string, payload interface{}) error {
-	event := PendingEvent{
-		ClientID:    clientId,
-		Payload:     payload,
-		Attempts:    0,
-		LastAttempt: time.Time{},
+	select {
+	case <-p.stopCh:
+		return fmt.Errorf("publisher is stopped")
+	default:
+	}
+	if err := p.client.validateEvent(payload); err != nil {
+		return err
 	}
+	return p.enqueue(PendingEvent{ClientID: clientId, Payload: payload})
+}
 
+// PublishAtomic queues events to be published together as a group: the hub
+// gives them consecutive event numbers and applications apply them in a
+// single transaction, so either all of them take effect or, if any handler
+// fails, none do. Use it for changes that must not be seen half done, like
+// creating a user along with their profile. Each event is a payload as given
+// to PublishEvent, and clientId identifies the group, for example in
+// retries. The group takes one place in the queue and is retried as a whole.
+// At most MaxAtomicEvents events can be grouped, and the whole group is
+// refused if the client's EventRegistry rejects any of them.
+func (p *EventPublisher) PublishAtomic(clientId string, events []interface{}) error {
+	select {
+	case <-p.stopCh:
+		return fmt.Errorf("publisher is stopped")
+	default:
+	}
+	if len(events) == 0 || len(events) > MaxAtomicEvents {
+		return NewError(ErrorTypeValidation, fmt.Sprintf("an atomic group must have between 1 and %d events, got %d", MaxAtomicEvents, len(events)))
+	}
+	for _, event := range events {
+		if err := p.client.validateEvent(event); err != nil {
+			return err
+		}
+	}
+	return p.enqueue(PendingEvent{
+		ClientID: clientId,
+		Payload:  map[string]interface{}{"events": events},
+		Atomic:   true,
+	})
+}
+
+// enqueue queues an event, applying the queue full policy.
+func (p *EventPublisher) enqueue(event PendingEvent) error {
 	p.queueMu.Lock()
-	wasEmpty := len(p.queue) == 0
-	p.queue = append(p.queue, event)
-	p.queueMu.Unlock()
+	defer p.queueMu.Unlock()
+
+	if p.queueFullLocked() {
+		if p.fullPolicy != QueueFullDropOldest {
+			return ErrQueueFull
+		}
+		p.client.Log().Printf("Event queue full, dropping oldest event %s", p.queue[0].ClientID)
+		p.removeHeadLocked()
+	}
+	p.enqueueLocked(event)
+	return nil
+}
+
+// PublishEventBlocking adds an event to the publish queue, waiting for space
+// if the queue is full. It returns the context's error if the context is
+// done before space frees up.
+func (p *EventPublisher) PublishEventBlocking(ctx context.Context, clientId string, payload interface{}) error {
+	if err := p.client.validateEvent(payload); err != nil {
+		return err
+	}
+	for {
+		p.queueMu.Lock()
+		if !p.queueFullLocked() {
+			p.enqueueLocked(PendingEvent{ClientID: clientId, Payload: payload})
+			p.queueMu.Unlock()
+			return nil
+		}
+		spaceFreed := p.spaceFreed
+		p.queueMu.Unlock()
 
-	// If queue was empty, this will trigger immediate processing
-	// Otherwise, the background goroutine will pick it up
-	if wasEmpty {
 		select {
+		case <-spaceFreed:
 		case <-p.stopCh:
 			return fmt.Errorf("publisher is stopped")
-		default:
-			// Non-blocking - background goroutine will process
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+}
 
-	return nil
+func (p *EventPublisher) queueFullLocked() bool {
+	return p.maxQueueLength > 0 && len(p.queue) >= p.maxQueueLength
+}
+
+// enqueueLocked appends an event for the background goroutine to pick up.
+func (p *EventPublisher) enqueueLocked(event PendingEvent) {
+	p.queue = append(p.queue, event)
+	if len(p.queue) > p.highWaterMark {
+		p.highWaterMark = len(p.queue)
+	}
+}
+
+// removeHeadLocked removes the oldest event and wakes blocked publishers.
+func (p *EventPublisher) removeHeadLocked() {
+	p.queue = p.queue[1:]
+	close(p.spaceFreed)
+	p.spaceFreed = make(chan struct{})
 }
 
 // start begins the background publishing goroutine
@@ -136,16 +277,23 @@ func (p *EventPublisher) start() {
 
 // Stop gracefully stops the event publisher
 func (p *EventPublisher) Stop() {
+	if !p.markStopped() {
+		return
+	}
+	p.wg.Wait()
+}
+
+// markStopped flags the publisher as stopped and signals the background
+// goroutine. It returns false if the publisher was already stopped.
+func (p *EventPublisher) markStopped() bool {
 	p.runningMu.Lock()
+	defer p.runningMu.Unlock()
 	if !p.running {
-		p.runningMu.Unlock()
-		return
+		return false
 	}
 	p.running = false
-	p.runningMu.Unlock()
-
 	close(p.stopCh)
-	p.wg.Wait()
+	return true
 }
 
 // FlushEvents blocks until all queued events are published or timeout is reached
@@ -197,6 +345,14 @@ func (p *EventPublisher) GetQueueLength() int {
 	return len(p.queue)
 }
 
+// GetQueueHighWaterMark returns the largest queue length seen since the
+// publisher was created, useful for tuning WithMaxQueueLength.
+func (p *EventPublisher) GetQueueHighWaterMark() int {
+	p.queueMu.RLock()
+	defer p.queueMu.RUnlock()
+	return p.highWaterMark
+}
+
 // publishLoop runs the background publishing process
 func (p *EventPublisher) publishLoop() {
 	defer p.wg.Done()
@@ -208,6 +364,10 @@ func (p *EventPublisher) publishLoop() {
 		select {
 		case <-p.stopCh:
 			return
+		case <-p.client.BaseContext().Done():
+			// The client's base context was cancelled
+			p.markStopped()
+			return
 		case responseCh := <-p.flushCh:
 			// Handle flush request
 			err := p.processFlush()
@@ -250,7 +410,7 @@ func (p *EventPublisher) processQueue() {
 	if success {
 		// Remove the event from queue
 		if len(p.queue) > 0 && p.queue[0].ClientID == event.ClientID {
-			p.queue = p.queue[1:]
+			p.removeHeadLocked()
 		}
 	} else {
 		// Update the event with retry information
@@ -258,7 +418,7 @@ func (p *EventPublisher) processQueue() {
 			p.queue[0] = event
 			// If max retries exceeded, remove the event
 			if event.Attempts >= p.maxRetries {
-				p.queue = p.queue[1:]
+				p.removeHeadLocked()
 			}
 		}
 	}
@@ -311,14 +471,15 @@ func (p *EventPublisher) calculateBackoff(attempts int) time.Duration {
 
 // publishSingleEvent attempts to publish a single event to the API
 func (p *EventPublisher) publishSingleEvent(event *PendingEvent) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(p.client.BaseContext(), 30*time.Second)
 	defer cancel()
 
 	// Update attempt tracking
 	event.Attempts++
 	event.LastAttempt = time.Now()
 
-	p.client.Log().Printf("Sending event with ID %s to %s...\n", event.ClientID, p.client.baseURL)
+	baseURL := p.client.selectEndpoint(ctx)
+	p.client.Log().Printf("Sending event with ID %s to %s...\n", event.ClientID, baseURL)
 	payloadBytes, err := json.Marshal(event.Payload)
 	if err != nil {
 		// JSON marshaling error - this event is malformed, don't retry
@@ -326,11 +487,19 @@ func (p *EventPublisher) publishSingleEvent(event *PendingEvent) bool {
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", p.client.baseURL+"/events/publish", bytes.NewReader(payloadBytes))
+	url := baseURL + "/events/publish"
+	if event.Atomic {
+		url += "?atomic=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadBytes))
 	if err != nil {
 		return false
 	}
+	p.client.applyDefaultHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
+	// Retries of the event reuse its client ID as the idempotency key, so a
+	// retry of a request that did reach the hub gets the original response
+	req.Header.Set(IdempotencyKeyHeader, event.ClientID)
 
 	// Add authentication header if available
 	if token := p.client.getAccessToken(); token != "" {
@@ -338,7 +507,8 @@ func (p *EventPublisher) publishSingleEvent(event *PendingEvent) bool {
 	}
 
 	// Execute the request
-	resp, err := p.client.httpClient.Do(req)
+	resp, err := p.client.GetHTTPClient().Do(req)
+	p.client.endpoints.report(baseURL, !isEndpointFailure(ctx, resp, err))
 	if err != nil {
 		return false
 	}
@@ -349,8 +519,12 @@ func (p *EventPublisher) publishSingleEvent(event *PendingEvent) bool {
 		return true // Success
 	}
 
-	// For client errors (4xx), don't retry
-	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+	// For client errors (4xx), don't retry, except for publishes rejected
+	// because of a quota, which succeed once it is raised
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		if p.onRejected != nil {
+			p.onRejected(*event, wrapPublishError(resp, fmt.Sprintf("publish of event %s failed", event.ClientID)))
+		}
 		return true // Don't retry client errors
 	}
 
@@ -0,0 +1,290 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStalledPublisher returns a publisher whose requests hang until the
+// returned release function is called, so queued events stay queued.
+func newStalledPublisher(t *testing.T, options ...PublisherOption) (*EventPublisher, func()) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	client.GetEventPoller().StopEventPolling()
+	client.GetEventPublisher().Stop()
+
+	publisher := NewEventPublisher(client, options...)
+	released := false
+	releaseFn := func() {
+		if !released {
+			released = true
+			close(release)
+		}
+	}
+	t.Cleanup(func() {
+		releaseFn()
+		publisher.Stop()
+		server.Close()
+	})
+	return publisher, releaseFn
+}
+
+func TestPublishEventQueueFullError(t *testing.T) {
+	publisher, _ := newStalledPublisher(t, WithMaxQueueLength(2, QueueFullError))
+
+	for _, id := range []string{"a", "b"} {
+		if err := publisher.PublishEvent(id, nil); err != nil {
+			t.Fatalf("PublishEvent(%s) failed: %v", id, err)
+		}
+	}
+	if err := publisher.PublishEvent("c", nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if got := publisher.GetQueueLength(); got != 2 {
+		t.Errorf("Expected queue length 2, got %d", got)
+	}
+	if got := publisher.GetQueueHighWaterMark(); got != 2 {
+		t.Errorf("Expected high-water mark 2, got %d", got)
+	}
+}
+
+func TestPublishEventQueueFullDropOldest(t *testing.T) {
+	publisher, _ := newStalledPublisher(t, WithMaxQueueLength(2, QueueFullDropOldest))
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := publisher.PublishEvent(id, nil); err != nil {
+			t.Fatalf("PublishEvent(%s) failed: %v", id, err)
+		}
+	}
+
+	publisher.queueMu.RLock()
+	ids := []string{publisher.queue[0].ClientID, publisher.queue[1].ClientID}
+	publisher.queueMu.RUnlock()
+	if ids[0] != "b" || ids[1] != "c" {
+		t.Errorf("Expected oldest event to be dropped, queue is %v", ids)
+	}
+}
+
+func TestPublishEventBlocking(t *testing.T) {
+	publisher, release := newStalledPublisher(t, WithMaxQueueLength(1, QueueFullError))
+
+	if err := publisher.PublishEventBlocking(context.Background(), "a", nil); err != nil {
+		t.Fatalf("PublishEventBlocking failed: %v", err)
+	}
+
+	// The queue is full, so this times out
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := publisher.PublishEventBlocking(ctx, "b", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Once the stalled request completes, space frees up
+	done := make(chan error, 1)
+	go func() {
+		done <- publisher.PublishEventBlocking(context.Background(), "c", nil)
+	}()
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("PublishEventBlocking failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PublishEventBlocking did not unblock when space freed")
+	}
+}
+
+func TestPublishRetriesReuseIdempotencyKey(t *testing.T) {
+	keys := make(chan string, 10)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get(IdempotencyKeyHeader)
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	client.GetEventPoller().StopEventPolling()
+	client.GetEventPublisher().Stop()
+	publisher := NewEventPublisher(client, WithRetryBackoff(time.Millisecond))
+	defer publisher.Stop()
+
+	if err := publisher.PublishEvent("event-1", map[string]string{"type": "Item:Add"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.FlushEvents(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	close(keys)
+	var seen []string
+	for key := range keys {
+		seen = append(seen, key)
+	}
+	if len(seen) != 2 || seen[0] != "event-1" || seen[1] != "event-1" {
+		t.Errorf("Expected both attempts to send key event-1, got %v", seen)
+	}
+}
+
+func TestPublishAtomicSendsGroup(t *testing.T) {
+	type request struct {
+		query, key string
+		body       struct {
+			Events []struct {
+				ClientID string `json:"clientId"`
+				Type     string `json:"type"`
+			} `json:"events"`
+		}
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{query: r.URL.RawQuery, key: r.Header.Get(IdempotencyKeyHeader)}
+		json.NewDecoder(r.Body).Decode(&req.body)
+		requests <- req
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	client.GetEventPoller().StopEventPolling()
+	client.GetEventPublisher().Stop()
+	publisher := NewEventPublisher(client)
+	defer publisher.Stop()
+
+	err := publisher.PublishAtomic("new-user", []interface{}{
+		map[string]string{"clientId": "user", "type": "User:Create"},
+		map[string]string{"clientId": "profile", "type": "Profile:Create"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := publisher.GetQueueLength(); got != 1 {
+		t.Errorf("Expected the group to take one place in the queue, got %d", got)
+	}
+	if err := publisher.FlushEvents(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	close(requests)
+	var sent []request
+	for req := range requests {
+		sent = append(sent, req)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("Expected one request, got %d", len(sent))
+	}
+	events := sent[0].body.Events
+	if sent[0].query != "atomic=true" || sent[0].key != "new-user" {
+		t.Errorf("Expected an atomic request with key new-user, got query %q and key %q", sent[0].query, sent[0].key)
+	}
+	if len(events) != 2 || events[0].Type != "User:Create" || events[1].Type != "Profile:Create" {
+		t.Errorf("Expected the group's events in order, got %+v", events)
+	}
+
+	if err := publisher.PublishAtomic("empty", nil); err == nil {
+		t.Error("Expected an empty group to be refused")
+	}
+	if err := publisher.PublishAtomic("large", make([]interface{}, MaxAtomicEvents+1)); err == nil {
+		t.Error("Expected an oversized group to be refused")
+	}
+}
+
+func TestPublishRetriesQuotaRejections(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	client.GetEventPoller().StopEventPolling()
+	client.GetEventPublisher().Stop()
+	publisher := NewEventPublisher(client, WithRetryBackoff(time.Millisecond))
+	defer publisher.Stop()
+
+	if err := publisher.PublishEvent("event-1", map[string]string{"type": "Item:Add"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.FlushEvents(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected the event to be retried after 429, got %d attempts", attempts)
+	}
+}
+
+func TestPublishReportsForbiddenEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":     "User:Add events can only be published by admin",
+			"forbidden": map[string]any{"eventType": "User:Add", "instanceId": "admin", "roles": []string{"admin"}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	client.GetEventPoller().StopEventPolling()
+	client.GetEventPublisher().Stop()
+	var rejected []PendingEvent
+	var rejectErr error
+	publisher := NewEventPublisher(client, WithRetryBackoff(time.Millisecond), WithRejectionHandler(func(event PendingEvent, err error) {
+		rejected = append(rejected, event)
+		rejectErr = err
+	}))
+	defer publisher.Stop()
+
+	if err := publisher.PublishEvent("event-1", map[string]string{"type": "User:Add"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.FlushEvents(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || rejected[0].ClientID != "event-1" || !IsForbiddenEventError(rejectErr) {
+		t.Fatalf("Expected the event to be rejected as forbidden, got %v, %v", rejected, rejectErr)
+	}
+	var forbidden *ForbiddenEventError
+	if !errors.As(rejectErr, &forbidden) || forbidden.EventType != "User:Add" || forbidden.Error() != "User:Add events can only be published by admin" {
+		t.Errorf("Expected the forbidden event type in the error, got %+v", forbidden)
+	}
+}
@@ -0,0 +1,44 @@
+package yesterdaygo
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+)
+
+// NDJSONContentType is the media type of newline-delimited JSON streams, which
+// hold one JSON record per line. Endpoints that support it return records
+// this way when the request accepts it.
+const NDJSONContentType = "application/x-ndjson"
+
+// Stream performs a GET request for a newline-delimited JSON stream and
+// returns a decoder yielding its records one at a time, so that large lists
+// can be processed without holding them in memory. Call Decode until it
+// returns io.EOF, then call the returned close function, which releases the
+// connection; call it too when stopping early.
+//
+// If the server ends the stream abnormally, for example after a failure
+// partway through, Decode returns an error other than io.EOF. An endpoint that
+// does not support streaming is reported as an API error.
+func (c *Client) Stream(ctx context.Context, path string, headers map[string]string) (*json.Decoder, func() error, error) {
+	streamHeaders := map[string]string{"Accept": NDJSONContentType}
+	for key, value := range headers {
+		streamHeaders[key] = value
+	}
+	resp, err := c.Get(ctx, path, streamHeaders)
+	if err != nil {
+		return nil, nil, NewNetworkError("stream request failed", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, nil, WrapHTTPError(resp, "stream request failed")
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != NDJSONContentType {
+		resp.Body.Close()
+		return nil, nil, NewAPIError("endpoint did not return an NDJSON stream: "+resp.Header.Get("Content-Type"), http.StatusNotAcceptable)
+	}
+	return json.NewDecoder(resp.Body), resp.Body.Close, nil
+}
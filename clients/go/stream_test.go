@@ -0,0 +1,95 @@
+package yesterdaygo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func serveNDJSON(count int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != NDJSONContentType {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"users": []}`))
+			return
+		}
+		w.Header().Set("Content-Type", NDJSONContentType)
+		for id := 1; id <= count; id++ {
+			fmt.Fprintf(w, "{\"id\":%d}\n", id)
+		}
+	}
+}
+
+func TestStreamDecodesRecords(t *testing.T) {
+	client := newDownloadTestClient(t, serveNDJSON(1000))
+
+	decoder, closeStream, err := client.Stream(context.Background(), "/admin/api/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeStream()
+	count := 0
+	for {
+		var item testItem
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if item.ID != count {
+			t.Fatalf("Expected record %d, got %d", count, item.ID)
+		}
+	}
+	if count != 1000 {
+		t.Errorf("Expected 1000 records, got %d", count)
+	}
+}
+
+func TestStreamReportsTruncation(t *testing.T) {
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", NDJSONContentType)
+		w.Write([]byte("{\"id\":1}\n"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+
+	decoder, closeStream, err := client.Stream(context.Background(), "/admin/api/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeStream()
+	var item testItem
+	if err := decoder.Decode(&item); err != nil || item.ID != 1 {
+		t.Fatalf("Expected the first record, got %v: %v", item, err)
+	}
+	if err := decoder.Decode(&item); err == nil || err == io.EOF {
+		t.Errorf("Expected an aborted stream to fail, got %v", err)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	client := newDownloadTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		serveNDJSON(1)(w, r)
+	})
+
+	if _, _, err := client.Stream(context.Background(), "/forbidden", nil); !IsAuthenticationError(err) {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+
+	// A plain JSON response is not mistaken for a stream of one record
+	_, _, err := client.Stream(context.Background(), "/admin/api/users", map[string]string{"Accept": "application/json"})
+	var yErr *Error
+	if !errors.As(err, &yErr) || yErr.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("Expected an API error for a non-streaming response, got %v", err)
+	}
+}
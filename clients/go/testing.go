@@ -204,6 +204,12 @@ func (m *MockClient) Delete(ctx context.Context, path string, headers map[string
 	return m.makeRequest(ctx, "DELETE", path, nil, headers)
 }
 
+// PostMultipart performs a mock multipart POST request, recording the form
+// fields as the request body
+func (m *MockClient) PostMultipart(ctx context.Context, path string, fields map[string]string, files map[string][]byte, headers map[string]string) (*http.Response, error) {
+	return m.makeRequest(ctx, "POST", path, fields, headers)
+}
+
 // Login simulates user authentication
 func (m *MockClient) Login(ctx context.Context, username, password string) error {
 	m.recordRequest("POST", "/public/login", LoginRequest{Username: username, Password: password}, nil)
@@ -230,6 +236,26 @@ func (m *MockClient) Logout(ctx context.Context) error {
 	return nil
 }
 
+// LogoutAll simulates ending every session of the user. The mock response
+// body, if any, is read as a LogoutAllResponse.
+func (m *MockClient) LogoutAll(ctx context.Context) (int, error) {
+	m.recordRequest("POST", "/public/logout_all", nil, nil)
+
+	mockResp := m.getMockResponse("/public/logout_all")
+	if mockResp != nil && mockResp.Error != nil {
+		return 0, mockResp.Error
+	}
+
+	m.SetAuthenticated(false)
+	var response LogoutAllResponse
+	if mockResp != nil && mockResp.Body != nil {
+		if bodyBytes, err := json.Marshal(mockResp.Body); err == nil {
+			json.Unmarshal(bodyBytes, &response)
+		}
+	}
+	return response.SessionsRevoked, nil
+}
+
 // RefreshAccessToken simulates access token refresh
 func (m *MockClient) RefreshAccessToken(ctx context.Context) error {
 	m.recordRequest("POST", "/public/access_token", nil, nil)
@@ -281,6 +307,7 @@ type MockEventPoller struct {
 	client             interface{} // MockClient reference
 	currentEventNumber int64
 	subscribers        []chan int64
+	sourceSubscribers  []chan int
 	running            bool
 	mu                 sync.RWMutex
 }
@@ -314,6 +341,10 @@ func (m *MockEventPoller) StopEventPolling() {
 		close(ch)
 	}
 	m.subscribers = make([]chan int64, 0)
+	for _, ch := range m.sourceSubscribers {
+		close(ch)
+	}
+	m.sourceSubscribers = nil
 }
 
 // SubscribeToEvents returns a channel for event number notifications
@@ -341,6 +372,36 @@ func (m *MockEventPoller) TriggerEvent(eventNumber int64) {
 			// Skip if channel is full
 		}
 	}
+	for _, ch := range m.sourceSubscribers {
+		select {
+		case ch <- int(eventNumber):
+		default:
+			// Skip if channel is full, like EventPoller
+		}
+	}
+}
+
+// EventSource returns an EventSource reporting the mock's event number for
+// every instance, for driving a DataProvider with WithEventSource.
+func (m *MockEventPoller) EventSource() EventSource {
+	return mockEventSource{m}
+}
+
+type mockEventSource struct {
+	poller *MockEventPoller
+}
+
+func (s mockEventSource) GetCurrentEventId(instanceID string) int {
+	return int(s.poller.GetCurrentEventNumber())
+}
+
+func (s mockEventSource) SubscribeToEvents(instanceID string) <-chan int {
+	s.poller.mu.Lock()
+	defer s.poller.mu.Unlock()
+
+	ch := make(chan int, 10)
+	s.poller.sourceSubscribers = append(s.poller.sourceSubscribers, ch)
+	return ch
 }
 
 // GetCurrentEventNumber returns the current event number
@@ -411,6 +472,15 @@ func (m *MockEventPublisher) PublishEvent(eventType string, payload interface{})
 	return nil
 }
 
+// PublishEventBlocking simulates publishing an event; the mock queue never
+// fills, so it only fails if the context is already done
+func (m *MockEventPublisher) PublishEventBlocking(ctx context.Context, eventType string, payload interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.PublishEvent(eventType, payload)
+}
+
 // FlushEvents simulates flushing pending events
 func (m *MockEventPublisher) FlushEvents(timeout time.Duration) error {
 	// Immediate return for mock - all events are "published" immediately
@@ -437,6 +507,12 @@ func (m *MockEventPublisher) GetQueueLength() int {
 	return 0
 }
 
+// GetQueueHighWaterMark simulates returning the queue high-water mark
+// (always 0 for mock)
+func (m *MockEventPublisher) GetQueueHighWaterMark() int {
+	return 0
+}
+
 // GetPublishedEvents returns all events published during testing
 func (m *MockEventPublisher) GetPublishedEvents() []MockPublishedEvent {
 	m.mu.RLock()
@@ -70,7 +70,7 @@ func loadCertificatesFromDir(certsDir string, log *log.Logger) (*x509.CertPool,
 	// Read directory contents
 	files, err := ioutil.ReadDir(certsDir)
 	if err != nil {
-		log.Printf("failed to read certificates directory: %w", err)
+		log.Printf("failed to read certificates directory: %v", err)
 		return nil, err
 	}
 
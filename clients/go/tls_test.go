@@ -7,6 +7,8 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"io"
+	"log"
 	"math/big"
 	"net/http"
 	"os"
@@ -117,7 +119,7 @@ ZXJuZXQgV2lkZ2l0cyBQdHkgTHRkMIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKA
 			}
 
 			// Test the function
-			tlsConfig, err := configureTLSForLocalhost(tt.baseURL)
+			tlsConfig, err := configureTLSForLocalhost(tt.baseURL, log.New(io.Discard, "", 0))
 
 			// Check error expectation
 			if tt.expectError {
@@ -0,0 +1,116 @@
+package yesterdaygo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TokenStore persists the refresh token between runs. Load returns an empty
+// string, not an error, when no token has been saved. Implementations must be
+// safe for concurrent use.
+//
+// The default is a FileTokenStore under ~/.yesterday; use WithTokenStore to
+// keep the token in memory, an environment variable or an OS keychain
+// instead.
+type TokenStore interface {
+	Load() (string, error)
+	Save(token string) error
+	Clear() error
+}
+
+// FileTokenStore keeps the refresh token in a file readable only by the
+// current user.
+type FileTokenStore struct {
+	Path string
+}
+
+// NewFileTokenStore returns a store that keeps the token at path.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load reads the token from the file
+func (s *FileTokenStore) Load() (string, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil // No token file is not an error
+		}
+		return "", fmt.Errorf("failed to read refresh token: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Save writes the token to the file, creating its directory if needed. The
+// token is written to a temporary file that replaces the old one, so the file
+// is never left half-written and ends up with mode 0600 even if an earlier
+// version of it was readable by others.
+func (s *FileTokenStore) Save(token string) error {
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+
+	// CreateTemp creates the file with mode 0600
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write refresh token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(token); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write refresh token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write refresh token: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to write refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// Clear removes the token file
+func (s *FileTokenStore) Clear() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove refresh token: %w", err)
+	}
+	return nil
+}
+
+// MemoryTokenStore keeps the refresh token in memory only, so every new
+// process must log in again. Useful where there is no writable filesystem.
+type MemoryTokenStore struct {
+	mu    sync.Mutex
+	token string
+}
+
+// NewMemoryTokenStore returns an empty in-memory store.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{}
+}
+
+// Load returns the saved token
+func (s *MemoryTokenStore) Load() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, nil
+}
+
+// Save replaces the saved token
+func (s *MemoryTokenStore) Save(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	return nil
+}
+
+// Clear forgets the saved token
+func (s *MemoryTokenStore) Clear() error {
+	return s.Save("")
+}
@@ -0,0 +1,149 @@
+package yesterdaygo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	store := NewFileTokenStore(filepath.Join(t.TempDir(), "nested", "token"))
+
+	if token, err := store.Load(); err != nil || token != "" {
+		t.Fatalf("Expected no token before saving, got %q, %v", token, err)
+	}
+	if err := store.Save("refresh-1"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatalf("Token file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token file mode 0600, got %v", info.Mode().Perm())
+	}
+	if token, err := store.Load(); err != nil || token != "refresh-1" {
+		t.Errorf("Expected refresh-1, got %q, %v", token, err)
+	}
+
+	// A token file readable by others is replaced by a private one
+	if err := os.Chmod(store.Path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("refresh-2"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if info, err := os.Stat(store.Path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the rewritten token file to have mode 0600, got %v, %v", info.Mode().Perm(), err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(store.Path)); len(entries) != 1 {
+		t.Errorf("Expected no temporary files left behind, got %d entries", len(entries))
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if err := store.Clear(); err != nil {
+		t.Errorf("Clearing an empty store should not fail: %v", err)
+	}
+	if token, _ := store.Load(); token != "" {
+		t.Errorf("Expected no token after Clear, got %q", token)
+	}
+}
+
+func TestWithTokenStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/public/login":
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh-1"})
+		case "/public/access_token":
+			cookie, err := r.Cookie("YRT")
+			if err != nil || cookie.Value != "refresh-1" {
+				http.Error(w, "bad refresh token", http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh-2"})
+			w.Write([]byte(`{"access_token":"access-1"}`))
+		}
+	}))
+	defer server.Close()
+
+	store := NewMemoryTokenStore()
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithTokenStore(store),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	if client.GetRefreshTokenPath() != "" {
+		t.Errorf("Expected no refresh token path for a memory store, got %q", client.GetRefreshTokenPath())
+	}
+
+	ctx := context.Background()
+	if err := client.Login(ctx, "user", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if !client.IsAuthenticated() {
+		t.Error("Expected the client to be authenticated after login")
+	}
+	if token, _ := store.Load(); token != "refresh-2" {
+		t.Errorf("Expected the rotated refresh token in the store, got %q", token)
+	}
+
+	client.Logout(ctx)
+	if token, _ := store.Load(); token != "" {
+		t.Errorf("Expected Logout to clear the store, got %q", token)
+	}
+}
+
+// readOnlyTokenStore returns a saved token but fails to save new ones
+type readOnlyTokenStore struct {
+	token string
+}
+
+func (s *readOnlyTokenStore) Load() (string, error) { return s.token, nil }
+func (s *readOnlyTokenStore) Save(string) error     { return errors.New("read-only file system") }
+func (s *readOnlyTokenStore) Clear() error          { return nil }
+
+func TestRotatedTokenKeptWhenSaveFails(t *testing.T) {
+	current := "refresh-1"
+	var rotations int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("YRT")
+		if err != nil || cookie.Value != current {
+			http.Error(w, "bad refresh token", http.StatusUnauthorized)
+			return
+		}
+		rotations++
+		current = fmt.Sprintf("refresh-%d", rotations+1)
+		http.SetCookie(w, &http.Cookie{Name: "YRT", Value: current})
+		w.Write([]byte(`{"access_token":"access"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL,
+		WithHTTPClient(http.DefaultClient),
+		WithTokenStore(&readOnlyTokenStore{token: "refresh-1"}),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	defer client.GetEventPoller().StopEventPolling()
+	defer client.GetEventPublisher().Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := client.RefreshAccessToken(context.Background()); err != nil {
+			t.Fatalf("Refresh %d failed, the rotated token was lost: %v", i+1, err)
+		}
+	}
+	if rotations != 3 {
+		t.Errorf("Expected 3 rotations, got %d", rotations)
+	}
+}
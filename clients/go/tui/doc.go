@@ -0,0 +1,15 @@
+// Package tui provides reusable tview components for terminal clients of the
+// Yesterday platform:
+//
+//   - LoginFlow: a login form wired to Client.Login, with progress and error
+//     pages, that first tries to resume a session from the stored refresh
+//     token and shows the form again whenever a token refresh is rejected.
+//   - ProviderList: a tview List bound to a DataProvider subscription that
+//     re-renders whenever the provider's data changes.
+//   - StatusBar: a one-line view of the client's authentication and event
+//     polling state.
+//
+// Components take the *tview.Application they run in so that updates from
+// background goroutines are applied with QueueUpdateDraw. They can be tested
+// without a terminal by running the application on a tcell simulation screen.
+package tui
@@ -0,0 +1,166 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+const (
+	loginPageForm     = "login"
+	loginPageProgress = "loggingIn"
+
+	loginTimeout = 30 * time.Second
+)
+
+// LoginFlow is a primitive that signs the user in. It shows a username and
+// password form, a progress message while the login request is in flight,
+// and any error from the server below the form so the user can retry. If the
+// session ends later because a token refresh is rejected, it shows the form
+// again so the user can log back in.
+type LoginFlow struct {
+	*tview.Pages
+
+	app       *tview.Application
+	client    *yesterdaygo.Client
+	form      *tview.Form
+	errorText *tview.TextView
+	progress  *tview.TextView
+	onSuccess func()
+	onExpired func()
+	loggedIn  bool // Only accessed on the event loop
+}
+
+// NewLoginFlow creates a login flow for the client. onSuccess is called on
+// the application's event loop once the client holds a valid access token.
+func NewLoginFlow(app *tview.Application, client *yesterdaygo.Client, onSuccess func()) *LoginFlow {
+	lf := &LoginFlow{
+		Pages:     tview.NewPages(),
+		app:       app,
+		client:    client,
+		onSuccess: onSuccess,
+	}
+
+	lf.progress = tview.NewTextView().
+		SetTextColor(tcell.ColorGreen).
+		SetTextAlign(tview.AlignCenter)
+	lf.progress.SetBorder(true)
+
+	lf.errorText = tview.NewTextView().
+		SetTextColor(tcell.ColorRed).
+		SetTextAlign(tview.AlignCenter)
+
+	lf.form = tview.NewForm().SetButtonsAlign(tview.AlignCenter)
+	lf.form.AddInputField("Username", "", 20, nil, nil)
+	lf.form.AddPasswordField("Password", "", 20, '*', nil)
+	lf.form.AddButton("Log in", lf.submit)
+
+	formLayout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(lf.form, 7, 0, true).
+		AddItem(lf.errorText, 2, 0, false)
+	formLayout.SetBorder(true).SetTitle(" Log in ")
+
+	lf.AddPage(loginPageForm, CenterBox(formLayout, 40, 11), true, true)
+	lf.AddPage(loginPageProgress, CenterBox(lf.progress, 40, 3), true, false)
+
+	client.OnRefreshFailure(lf.refreshFailed)
+	return lf
+}
+
+// SetExpiredFunc sets a function that is called on the application's event
+// loop when the session ends after a successful login, once the login form is
+// showing again. Use it to bring the login flow back to the front.
+func (lf *LoginFlow) SetExpiredFunc(onExpired func()) *LoginFlow {
+	lf.onExpired = onExpired
+	return lf
+}
+
+// Start tries to resume the session from the stored refresh token and shows
+// the login form only if that fails, e.g. because the token has expired.
+func (lf *LoginFlow) Start() {
+	lf.showProgress("Restoring session...")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+		defer cancel()
+		err := lf.client.RefreshAccessToken(ctx)
+		lf.app.QueueUpdateDraw(func() {
+			if err == nil && lf.client.IsAuthenticated() {
+				lf.succeed()
+				return
+			}
+			lf.showForm("")
+		})
+	}()
+}
+
+// submit sends the form contents to the server.
+func (lf *LoginFlow) submit() {
+	username := lf.form.GetFormItemByLabel("Username").(*tview.InputField).GetText()
+	password := lf.form.GetFormItemByLabel("Password").(*tview.InputField).GetText()
+
+	lf.showProgress(fmt.Sprintf("Logging in as %s...", username))
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+		defer cancel()
+		err := lf.client.Login(ctx, username, password)
+		lf.app.QueueUpdateDraw(func() {
+			if err != nil {
+				lf.showForm(fmt.Sprintf("Error logging in: %s", err.Error()))
+				return
+			}
+			lf.succeed()
+		})
+	}()
+}
+
+func (lf *LoginFlow) showProgress(message string) {
+	lf.progress.SetText(message)
+	lf.SwitchToPage(loginPageProgress)
+}
+
+func (lf *LoginFlow) showForm(errorMessage string) {
+	lf.errorText.SetText(errorMessage)
+	lf.form.GetFormItemByLabel("Password").(*tview.InputField).SetText("")
+	lf.SwitchToPage(loginPageForm)
+	lf.app.SetFocus(lf.form)
+}
+
+// refreshFailed is called by the client when the hub rejects a token
+// refresh. The refresh may have been attempted on the event loop, where
+// QueueUpdateDraw would block, so the update is queued from a new goroutine.
+func (lf *LoginFlow) refreshFailed(error) {
+	go lf.app.QueueUpdateDraw(func() {
+		// Failures before the first login are handled by Start and submit
+		if !lf.loggedIn {
+			return
+		}
+		lf.loggedIn = false
+		lf.showForm("Session expired, please log in again")
+		if lf.onExpired != nil {
+			lf.onExpired()
+		}
+	})
+}
+
+func (lf *LoginFlow) succeed() {
+	lf.loggedIn = true
+	if lf.onSuccess != nil {
+		lf.onSuccess()
+	}
+}
+
+// CenterBox centers a primitive of the given size within the available space.
+func CenterBox(contents tview.Primitive, width, height int) *tview.Flex {
+	hflex := tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(tview.NewBox(), 0, 1, false).
+		AddItem(contents, width, 0, true).
+		AddItem(tview.NewBox(), 0, 1, false)
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tview.NewBox(), 0, 1, false).
+		AddItem(hflex, height, 0, true).
+		AddItem(tview.NewBox(), 0, 1, false)
+}
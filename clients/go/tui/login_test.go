@@ -0,0 +1,118 @@
+package tui
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rivo/tview"
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// newTestClient creates a client for a fake hub that accepts any login and
+// rejects token refreshes while revoked is set.
+func newTestClient(t *testing.T, revoked *atomic.Bool) *yesterdaygo.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/public/login":
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh"})
+		case "/public/access_token":
+			if revoked.Load() {
+				http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "YRT", Value: "refresh"})
+			w.Write([]byte(`{"access_token":"access"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return yesterdaygo.NewClient(server.URL,
+		yesterdaygo.WithHTTPClient(http.DefaultClient),
+		yesterdaygo.WithRefreshTokenPath(filepath.Join(t.TempDir(), "token")),
+		yesterdaygo.WithLogger(log.New(io.Discard, "", 0)),
+	)
+}
+
+// loginPage returns the front page and error text on the event loop.
+func loginPage(app *tview.Application, lf *LoginFlow) (string, string) {
+	type state struct{ page, errorText string }
+	result := make(chan state, 1)
+	app.QueueUpdate(func() {
+		page, _ := lf.GetFrontPage()
+		result <- state{page, lf.errorText.GetText(true)}
+	})
+	s := <-result
+	return s.page, s.errorText
+}
+
+func waitForPage(t *testing.T, app *tview.Application, lf *LoginFlow, expected string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	var page, errorText string
+	for time.Now().Before(deadline) {
+		page, errorText = loginPage(app, lf)
+		if page == expected {
+			return errorText
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected page %q, got %q", expected, page)
+	return ""
+}
+
+func waitForSignal(t *testing.T, signal <-chan struct{}, name string) {
+	t.Helper()
+	select {
+	case <-signal:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the %s callback to be called", name)
+	}
+}
+
+func TestLoginFlowShowsFormWhenRefreshFails(t *testing.T) {
+	var revoked atomic.Bool
+	client := newTestClient(t, &revoked)
+	app := newTestApp(t)
+
+	succeeded := make(chan struct{}, 1)
+	expired := make(chan struct{}, 1)
+	lf := NewLoginFlow(app, client, func() { succeeded <- struct{}{} }).
+		SetExpiredFunc(func() { expired <- struct{}{} })
+	runTestApp(t, app, lf)
+
+	// There is no stored refresh token, so the form is shown without an error
+	lf.Start()
+	if errorText := waitForPage(t, app, lf, loginPageForm); errorText != "" {
+		t.Errorf("Expected no error on the first login, got %q", errorText)
+	}
+
+	submit := func() {
+		app.QueueUpdate(func() {
+			lf.form.GetFormItemByLabel("Username").(*tview.InputField).SetText("alice")
+			lf.form.GetFormItemByLabel("Password").(*tview.InputField).SetText("password")
+			lf.submit()
+		})
+	}
+	submit()
+	waitForSignal(t, succeeded, "success")
+
+	// A refresh rejected later in the session brings the form back
+	revoked.Store(true)
+	if err := client.RefreshAccessToken(context.Background()); !yesterdaygo.IsAuthenticationError(err) {
+		t.Fatalf("Expected an authentication error, got %v", err)
+	}
+	waitForSignal(t, expired, "expired")
+	if errorText := waitForPage(t, app, lf, loginPageForm); errorText != "Session expired, please log in again" {
+		t.Errorf("Expected a session expired message, got %q", errorText)
+	}
+
+	revoked.Store(false)
+	submit()
+	waitForSignal(t, succeeded, "success")
+}
@@ -0,0 +1,103 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/rivo/tview"
+)
+
+// Source is the subset of DataProvider used by ProviderList. It is satisfied
+// by *yesterdaygo.DataProvider[T].
+type Source[T any] interface {
+	Get() (T, error)
+	Subscribe(callback func(T)) error
+	Unsubscribe()
+}
+
+// ListRow is a single rendered row of a ProviderList.
+type ListRow struct {
+	Main      string
+	Secondary string
+	Shortcut  rune
+	Selected  func()
+}
+
+// RowRenderer converts provider data into list rows.
+type RowRenderer[T any] func(data T) []ListRow
+
+// ProviderList is a tview List that displays the data from a provider and
+// re-renders automatically whenever the provider reports new data.
+type ProviderList[T any] struct {
+	*tview.List
+
+	app      *tview.Application
+	source   Source[T]
+	render   RowRenderer[T]
+	onError  func(error)
+	rowCount int
+}
+
+// NewProviderList creates a list bound to the given source. Call Bind to load
+// the initial data and subscribe to updates.
+func NewProviderList[T any](app *tview.Application, source Source[T], render RowRenderer[T]) *ProviderList[T] {
+	return &ProviderList[T]{
+		List:   tview.NewList().ShowSecondaryText(false),
+		app:    app,
+		source: source,
+		render: render,
+	}
+}
+
+// SetErrorHandler sets a callback for errors fetching data. By default the
+// error is shown as the only row of the list.
+func (pl *ProviderList[T]) SetErrorHandler(onError func(error)) *ProviderList[T] {
+	pl.onError = onError
+	return pl
+}
+
+// Bind fetches the current data, renders it, and subscribes to changes.
+// It may perform a network request and waits for the event loop to apply the
+// update, so call it from a goroutine rather than from the event loop itself.
+func (pl *ProviderList[T]) Bind() error {
+	data, err := pl.source.Get()
+	pl.app.QueueUpdateDraw(func() {
+		if err != nil {
+			pl.showError(err)
+			return
+		}
+		pl.update(data)
+	})
+	return pl.source.Subscribe(func(data T) {
+		pl.app.QueueUpdateDraw(func() {
+			pl.update(data)
+		})
+	})
+}
+
+// Unbind stops receiving updates from the source.
+func (pl *ProviderList[T]) Unbind() {
+	pl.source.Unsubscribe()
+}
+
+func (pl *ProviderList[T]) update(data T) {
+	current := pl.GetCurrentItem()
+	pl.Clear()
+	rows := pl.render(data)
+	for _, row := range rows {
+		pl.AddItem(row.Main, row.Secondary, row.Shortcut, row.Selected)
+	}
+	pl.rowCount = len(rows)
+	if current < pl.rowCount {
+		pl.SetCurrentItem(current)
+	}
+}
+
+func (pl *ProviderList[T]) showError(err error) {
+	if pl.onError != nil {
+		pl.onError(err)
+		return
+	}
+	pl.Clear()
+	pl.AddItem(fmt.Sprintf("[red]Error fetching data: %s", tview.Escape(err.Error())), "", 0, nil)
+	pl.rowCount = 0
+}
@@ -0,0 +1,171 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+type testUsers struct {
+	Names []string
+}
+
+// fakeSource is an in-memory Source whose subscribers are notified by Publish.
+type fakeSource struct {
+	mu       sync.Mutex
+	data     testUsers
+	err      error
+	callback func(testUsers)
+}
+
+func (s *fakeSource) Get() (testUsers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data, s.err
+}
+
+func (s *fakeSource) Subscribe(callback func(testUsers)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callback = callback
+	return nil
+}
+
+func (s *fakeSource) Unsubscribe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callback = nil
+}
+
+func (s *fakeSource) Publish(data testUsers) {
+	s.mu.Lock()
+	s.data = data
+	callback := s.callback
+	s.mu.Unlock()
+	if callback != nil {
+		callback(data)
+	}
+}
+
+// newTestApp creates a tview application on a simulation screen.
+func newTestApp(t *testing.T) *tview.Application {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("Failed to init simulation screen: %v", err)
+	}
+	screen.SetSize(80, 24)
+
+	return tview.NewApplication().SetScreen(screen)
+}
+
+// runTestApp runs the application with the given root until the test ends.
+func runTestApp(t *testing.T, app *tview.Application, root tview.Primitive) {
+	app.SetRoot(root, true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := app.Run(); err != nil {
+			t.Errorf("Application returned error: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		app.Stop()
+		<-done
+	})
+}
+
+// listItems reads the main text of every list row on the event loop.
+// QueueUpdate waits for the function to run, so the result channel must be
+// buffered.
+func listItems(app *tview.Application, list *tview.List) []string {
+	result := make(chan []string, 1)
+	app.QueueUpdate(func() {
+		var items []string
+		for i := 0; i < list.GetItemCount(); i++ {
+			main, _ := list.GetItemText(i)
+			items = append(items, main)
+		}
+		result <- items
+	})
+	return <-result
+}
+
+func waitForItems(t *testing.T, app *tview.Application, list *tview.List, expected []string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	var items []string
+	for time.Now().Before(deadline) {
+		items = listItems(app, list)
+		if fmt.Sprint(items) == fmt.Sprint(expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected list items %v, got %v", expected, items)
+}
+
+func renderUsers(data testUsers) []ListRow {
+	rows := make([]ListRow, len(data.Names))
+	for i, name := range data.Names {
+		rows[i] = ListRow{Main: name}
+	}
+	return rows
+}
+
+func TestProviderListUpdatesOnChange(t *testing.T) {
+	source := &fakeSource{data: testUsers{Names: []string{"admin"}}}
+	app := newTestApp(t)
+	list := NewProviderList[testUsers](app, source, renderUsers)
+	runTestApp(t, app, list)
+
+	if err := list.Bind(); err != nil {
+		t.Fatalf("Bind returned error: %v", err)
+	}
+	waitForItems(t, app, list.List, []string{"admin"})
+
+	source.Publish(testUsers{Names: []string{"admin", "tom"}})
+	waitForItems(t, app, list.List, []string{"admin", "tom"})
+
+	source.Publish(testUsers{Names: []string{"tom"}})
+	waitForItems(t, app, list.List, []string{"tom"})
+
+	list.Unbind()
+	source.Publish(testUsers{Names: []string{"ignored"}})
+	time.Sleep(50 * time.Millisecond)
+	waitForItems(t, app, list.List, []string{"tom"})
+}
+
+func TestProviderListShowsErrors(t *testing.T) {
+	source := &fakeSource{err: errors.New("connection refused")}
+	app := newTestApp(t)
+	list := NewProviderList[testUsers](app, source, renderUsers)
+	runTestApp(t, app, list)
+
+	var reported error
+	var mu sync.Mutex
+	list.SetErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = err
+	})
+
+	if err := list.Bind(); err != nil {
+		t.Fatalf("Bind returned error: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		got := reported
+		mu.Unlock()
+		if got != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the error handler to be called")
+}
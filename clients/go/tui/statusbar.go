@@ -0,0 +1,79 @@
+package tui
+
+import (
+	"context"
+	"time"
+
+	"github.com/rivo/tview"
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// ConnectionState describes what the status bar currently shows.
+type ConnectionState struct {
+	Authenticated bool
+	Polling       bool
+}
+
+// StatusBar is a one-line view of the client's connection state.
+type StatusBar struct {
+	*tview.TextView
+
+	app   *tview.Application
+	state ConnectionState
+}
+
+// NewStatusBar creates an empty status bar.
+func NewStatusBar(app *tview.Application) *StatusBar {
+	sb := &StatusBar{
+		TextView: tview.NewTextView().SetDynamicColors(true),
+		app:      app,
+	}
+	sb.render()
+	return sb
+}
+
+// SetState updates the displayed state. Must be called on the event loop.
+func (sb *StatusBar) SetState(state ConnectionState) {
+	sb.state = state
+	sb.render()
+}
+
+// State returns the currently displayed state.
+func (sb *StatusBar) State() ConnectionState {
+	return sb.state
+}
+
+// Watch samples the client's state every interval and updates the bar when
+// it changes, until the context is cancelled.
+func (sb *StatusBar) Watch(ctx context.Context, client *yesterdaygo.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		state := ConnectionState{
+			Authenticated: client.IsAuthenticated(),
+			Polling:       client.GetEventPoller().IsRunning(),
+		}
+		sb.app.QueueUpdateDraw(func() {
+			if state != sb.state {
+				sb.SetState(state)
+			}
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sb *StatusBar) render() {
+	auth := "[red]not signed in[-]"
+	if sb.state.Authenticated {
+		auth = "[green]signed in[-]"
+	}
+	polling := "[yellow]events paused[-]"
+	if sb.state.Polling {
+		polling = "[green]live[-]"
+	}
+	sb.SetText(" " + auth + " | " + polling)
+}
@@ -0,0 +1,5 @@
+// Package adminapi is a typed client for the Admin app's API, generated from
+// the manifest the app serves at /internal/api-manifest.
+package adminapi
+
+//go:generate go run github.com/tomyedwab/yesterday/clients/go/cmd/genclient -manifest api-manifest.json -package adminapi -o client.go
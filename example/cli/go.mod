@@ -4,11 +4,14 @@ go 1.24.4
 
 require github.com/rivo/tview v0.42.0
 
-require github.com/tomyedwab/yesterday/clients/go v0.0.0
+require (
+	github.com/gdamore/tcell/v2 v2.8.1
+	github.com/tomyedwab/yesterday/clients/go v0.0.0
+	github.com/tomyedwab/yesterday/clients/go/tui v0.0.0
+)
 
 require (
 	github.com/gdamore/encoding v1.0.1 // indirect
-	github.com/gdamore/tcell/v2 v2.8.1 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
@@ -18,3 +21,5 @@ require (
 )
 
 replace github.com/tomyedwab/yesterday/clients/go => ../../clients/go
+
+replace github.com/tomyedwab/yesterday/clients/go/tui => ../../clients/go/tui
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -12,70 +11,10 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+	"github.com/tomyedwab/yesterday/clients/go/tui"
+	"github.com/tomyedwab/yesterday/example/cli/adminapi"
 )
 
-func centerBox(contents tview.Primitive, width, height int) *tview.Flex {
-	hflex := tview.NewFlex().SetFullScreen(false).SetDirection(tview.FlexColumn).
-		AddItem(tview.NewBox(), 0, 1, false).
-		AddItem(contents, width, 0, true).
-		AddItem(tview.NewBox(), 0, 1, false)
-	return tview.NewFlex().SetFullScreen(false).SetDirection(tview.FlexRow).
-		AddItem(tview.NewBox(), 0, 1, false).
-		AddItem(hflex, height, 0, true).
-		AddItem(tview.NewBox(), 0, 1, false)
-}
-
-func createLoginPages(client *yesterdaygo.Client, pages *tview.Pages, mainPage *MainPage) {
-	// Create "logging in" page
-	var loggingInText = tview.NewTextView().
-		SetTextColor(tcell.ColorGreen).
-		SetTextAlign(tview.AlignCenter)
-	loggingInText.SetBorder(true)
-	pages.AddPage("LoggingIn", centerBox(loggingInText, 35, 3), true, true)
-
-	// Create "logging in error" page
-	var errorText = tview.NewTextView().
-		SetTextColor(tcell.ColorRed).
-		SetTextAlign(tview.AlignCenter)
-	errorText.SetBorder(true)
-	pages.AddPage("LoginError", errorText, true, true)
-
-	// Create login form
-	var loginUsername string
-	var loginPassword string
-	form := tview.NewForm().SetButtonsAlign(tview.AlignCenter)
-	form.SetBorder(true)
-	form.AddInputField("Username", "", 20, nil, func(username string) {
-		loginUsername = username
-	})
-	form.AddInputField("Password", "", 20, nil, func(password string) {
-		loginPassword = password
-	})
-	form.AddButton("Log in", func() {
-		loggingInText.SetText(fmt.Sprintf("Logging in as %s...", loginUsername))
-		_ = loginPassword
-		pages.SwitchToPage("LoggingIn")
-		if err := client.Login(context.Background(), loginUsername, loginPassword); err != nil {
-			errorText.SetText(fmt.Sprintf("Error logging in: %s", err.Error()))
-			pages.SwitchToPage("LoginError")
-		} else {
-			mainPage.update()
-			pages.SwitchToPage("Main")
-		}
-	})
-
-	pages.AddPage("Login", centerBox(form, 35, 9), true, true)
-}
-
-type UserData struct {
-	ID       int    `db:"id" json:"id"`
-	Username string `db:"username" json:"username"`
-}
-
-type UsersData struct {
-	Users []UserData `json:"users"`
-}
-
 type CreateUserPublishData struct {
 	yesterdaygo.EventPublishData
 	Username     string `json:"username"`
@@ -83,32 +22,16 @@ type CreateUserPublishData struct {
 	PasswordHash string `json:"passwordHash"`
 }
 
-type MainPage struct {
-	provider *yesterdaygo.DataProvider[UsersData]
-	pages    *tview.Pages
-	users    *tview.List
-}
-
-func (m *MainPage) update() {
-	users, err := m.provider.Get()
-	if err != nil {
-		var errorText = tview.NewTextView().
-			SetTextColor(tcell.ColorRed).
-			SetTextAlign(tview.AlignCenter).
-			SetText(fmt.Sprintf("Error fetching users: %s", err.Error()))
-		errorText.SetBorder(true)
-		m.pages.AddPage("Main", errorText, true, true)
-	}
-
-	if m.users == nil {
-		m.users = tview.NewList().ShowSecondaryText(false)
-		m.pages.AddPage("Main", m.users, true, true)
-	} else {
-		m.users.Clear()
-	}
-	for index, user := range users.Users {
-		m.users.AddItem(user.Username, fmt.Sprintf("ID %d", user.ID), rune(49+index), nil)
+func renderUsers(data adminapi.UsersData) []tui.ListRow {
+	rows := make([]tui.ListRow, 0, len(data.Users))
+	for index, user := range data.Users {
+		rows = append(rows, tui.ListRow{
+			Main:      user.Username,
+			Secondary: fmt.Sprintf("ID %d", user.ID),
+			Shortcut:  rune(49 + index),
+		})
 	}
+	return rows
 }
 
 func main() {
@@ -117,39 +40,43 @@ func main() {
 		log.Fatal(err)
 	}
 
+	logger := log.New(logOutput, "yesterday-cli: ", log.LstdFlags)
 	client := yesterdaygo.NewClient(
 		"https://www.yesterday.localhost:8443",
 		yesterdaygo.WithRefreshTokenPath(path.Join(os.Getenv("HOME"), ".yesterday", "token")),
-		yesterdaygo.WithLogger(log.New(logOutput, "yesterday-cli: ", log.LstdFlags)),
+		yesterdaygo.WithLogger(logger),
+		yesterdaygo.WithDefaultInstance("MBtskI6D"),
 	)
 
-	err = client.RefreshAccessToken(context.Background())
-	if err != nil {
-		fmt.Println("Error refreshing access token:", err)
-	}
-
 	var app = tview.NewApplication()
 	var pages = tview.NewPages()
-	var mainPage = &MainPage{
-		provider: yesterdaygo.NewDataProvider[UsersData](client, "MBtskI6D", "api/users", nil),
-		pages:    pages,
-	}
-	mainPage.provider.Subscribe(func(_ UsersData) {
-		app.QueueUpdateDraw(func() {
-			mainPage.update()
-			pages.SwitchToPage("Main")
-		})
+	var adminAPI = adminapi.NewAPIClient(client, "")
+	var provider = adminAPI.ListUsersProvider(nil)
+	var users = tui.NewProviderList[adminapi.UsersData](app, provider, renderUsers)
+	var statusBar = tui.NewStatusBar(app)
+	var login = tui.NewLoginFlow(app, client, func() {
+		pages.SwitchToPage("Main")
+		app.SetFocus(users)
+		go func() {
+			if err := users.Bind(); err != nil {
+				logger.Printf("Error subscribing to users: %v", err)
+			}
+		}()
+	}).SetExpiredFunc(func() {
+		users.Unbind()
+		pages.SwitchToPage("Login")
 	})
 
-	pages.AddPage("Main", centerBox(tview.NewTextView().SetText("Welcome to Yesterday!"), 35, 9), true, true)
-	createLoginPages(client, pages, mainPage)
+	mainLayout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(users, 0, 1, true).
+		AddItem(statusBar, 1, 0, false)
+	pages.AddPage("Main", mainLayout, true, false)
+	pages.AddPage("Login", login, true, true)
 
-	if client.IsAuthenticated() {
-		mainPage.update()
-		pages.SwitchToPage("Main")
-	} else {
-		pages.SwitchToPage("Login")
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go statusBar.Watch(ctx, client, time.Second)
+	login.Start()
 
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Rune() == 113 {
@@ -161,27 +88,29 @@ func main() {
 		if event.Rune() == 99 {
 			// "c" creates a new user
 			// TODO(tom) STOPSHIP make a proper UI affordance
-			resp, err := client.Post(context.Background(), "/MBtskI6D/api/hash_password", "testpassword", map[string]string{})
-			if err == nil {
-				var respData struct {
-					Salt         string
-					PasswordHash string
+			go func() {
+				hashed, err := adminAPI.HashPassword(context.Background(), "Test-password-1")
+				if err != nil {
+					logger.Printf("Error hashing password: %v", err)
+					return
 				}
-				err = json.NewDecoder(resp.Body).Decode(&respData)
-				if err == nil {
-					clientId := yesterdaygo.GenerateClientID()
-					client.GetEventPublisher().PublishEvent(clientId, CreateUserPublishData{
-						EventPublishData: yesterdaygo.EventPublishData{
-							ClientID:  clientId,
-							Type:      "User:Add",
-							Timestamp: time.Now().UTC(),
-						},
-						Username:     "tom",
-						Salt:         respData.Salt,
-						PasswordHash: respData.PasswordHash,
-					})
+				clientId := yesterdaygo.GenerateClientID()
+				publishCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+				_, err = client.PublishAndWait(publishCtx, client.DefaultInstance(), CreateUserPublishData{
+					EventPublishData: yesterdaygo.EventPublishData{
+						ClientID:  clientId,
+						Type:      "User:Add",
+						Timestamp: time.Now().UTC(),
+					},
+					Username:     "tom",
+					Salt:         hashed.Salt,
+					PasswordHash: hashed.PasswordHash,
+				})
+				if err != nil {
+					logger.Printf("Error creating user: %v", err)
 				}
-			}
+			}()
 		}
 		return event
 	})
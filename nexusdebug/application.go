@@ -13,8 +13,6 @@ import (
 	"log"
 	"strings"
 	"time"
-
-	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
 )
 
 // DebugApplication represents a debug application instance
@@ -36,6 +34,7 @@ type ApplicationStatus struct {
 	ProcessID     int                    `json:"processId,omitempty"`
 	Port          int                    `json:"port,omitempty"`
 	HealthCheck   string                 `json:"healthCheck,omitempty"`
+	EventErrors   int                    `json:"eventErrors,omitempty"`
 	Error         string                 `json:"error,omitempty"`
 	LastUpdated   string                 `json:"lastUpdated"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`
@@ -43,18 +42,24 @@ type ApplicationStatus struct {
 
 // ApplicationManager handles debug application lifecycle operations
 type ApplicationManager struct {
-	client           *yesterdaygo.Client
+	client           APIClient
 	currentApp       *DebugApplication
 	appName          string
 	staticServiceURL string
+	// How often to poll the application status while waiting for it to
+	// start or stop
+	readyPollInterval time.Duration
+	stopPollInterval  time.Duration
 }
 
 // NewApplicationManager creates a new application manager
-func NewApplicationManager(client *yesterdaygo.Client, appName, staticServiceURL string) *ApplicationManager {
+func NewApplicationManager(client APIClient, appName, staticServiceURL string) *ApplicationManager {
 	return &ApplicationManager{
-		client:           client,
-		appName:          appName,
-		staticServiceURL: staticServiceURL,
+		client:            client,
+		appName:           appName,
+		staticServiceURL:  staticServiceURL,
+		readyPollInterval: 2 * time.Second,
+		stopPollInterval:  1 * time.Second,
 	}
 }
 
@@ -172,7 +177,7 @@ func (am *ApplicationManager) waitForApplicationReady(ctx context.Context) error
 	timeout := time.NewTimer(60 * time.Second)
 	defer timeout.Stop()
 
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := time.NewTicker(am.readyPollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -205,8 +210,9 @@ func (am *ApplicationManager) waitForApplicationReady(ctx context.Context) error
 				return nil
 			}
 
-			// Check for failure states
-			if status.Status == "stopped" {
+			// Check for failure states. An application that failed its
+			// self-tests is reported failed, with the failing tests as the error
+			if status.Status == "stopped" || status.Status == "failed" {
 				return fmt.Errorf("application failed to start: %s", status.Error)
 			}
 		}
@@ -257,7 +263,7 @@ func (am *ApplicationManager) waitForApplicationStopped(ctx context.Context) err
 	timeout := time.NewTimer(30 * time.Second)
 	defer timeout.Stop()
 
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(am.stopPollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -0,0 +1,120 @@
+// Clones of installed instances as debug applications.
+//
+// A clone runs the source instance's package against a copy of its data, so
+// the debug workflow can deploy a build to it and reproduce data-dependent
+// bugs without touching production. The hub uninstalls clones when they
+// expire, in case the CLI does not get to clean up.
+//
+// Reference: spec/nexusdebug.md - Task nexusdebug-clone
+package nexusdebug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CloneInfo describes a clone created by the hub
+type CloneInfo struct {
+	InstanceID string    `json:"instanceId"`
+	CloneOf    string    `json:"cloneOf"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	HostName   string    `json:"hostName,omitempty"`
+	Sandbox    bool      `json:"sandbox"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// CloneManager creates a clone of an installed instance in place of a new
+// debug application; deploying to and monitoring it works as for any debug
+// application.
+type CloneManager struct {
+	*ApplicationManager
+	from string
+	ttl  string
+}
+
+var _ ApplicationLifecycle = (*CloneManager)(nil)
+
+// NewCloneManager creates a manager cloning the instance from. ttl is how
+// long the hub keeps the clone, e.g. "2h", or empty for the hub's default.
+func NewCloneManager(client APIClient, from, ttl string) *CloneManager {
+	return &CloneManager{
+		ApplicationManager: NewApplicationManager(client, from, ""),
+		from:               from,
+		ttl:                ttl,
+	}
+}
+
+// CreateApplication clones the source instance via POST /apps/{id}/clone
+func (cm *CloneManager) CreateApplication(ctx context.Context) (*DebugApplication, error) {
+	log.Printf("Cloning instance: %s", cm.from)
+
+	request := map[string]interface{}{}
+	if cm.ttl != "" {
+		request["ttl"] = cm.ttl
+	}
+	response, err := cm.client.Post(ctx, fmt.Sprintf("/apps/%s/clone", url.PathEscape(cm.from)), request, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone %s: %w", cm.from, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("failed to clone %s: status %d: %s", cm.from, response.StatusCode, strings.TrimSpace(string(bodyBytes)))
+	}
+
+	var clone CloneInfo
+	if err := json.NewDecoder(response.Body).Decode(&clone); err != nil {
+		return nil, fmt.Errorf("failed to parse clone response: %w", err)
+	}
+
+	log.Printf("Clone created with ID: %s", clone.InstanceID)
+	log.Printf("  Package: %s %s", clone.Name, clone.Version)
+	log.Printf("  Sandbox: %v", clone.Sandbox)
+	log.Printf("  Expires: %s", clone.ExpiresAt.Local().Format(time.RFC1123))
+
+	cm.currentApp = &DebugApplication{
+		ID:          clone.InstanceID,
+		AppID:       clone.InstanceID,
+		DisplayName: fmt.Sprintf("Clone of %s", clone.Name),
+		HostName:    clone.HostName,
+		Status:      "running",
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	return cm.currentApp, nil
+}
+
+// StopApplication does nothing: the hub runs a clone like any other
+// instance until it is removed
+func (cm *CloneManager) StopApplication(ctx context.Context) error {
+	return nil
+}
+
+// Cleanup removes the clone via DELETE /apps/{id}/clone
+func (cm *CloneManager) Cleanup(ctx context.Context) error {
+	if cm.currentApp == nil {
+		return nil
+	}
+
+	log.Printf("Removing clone: %s", cm.currentApp.ID)
+	response, err := cm.client.Delete(ctx, fmt.Sprintf("/apps/%s/clone", url.PathEscape(cm.currentApp.ID)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to remove clone: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("failed to remove clone: status %d: %s", response.StatusCode, strings.TrimSpace(string(bodyBytes)))
+	}
+
+	log.Printf("Clone removed successfully")
+	cm.currentApp = nil
+	return nil
+}
@@ -0,0 +1,76 @@
+package nexusdebug
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// newCloneTestWorkflow returns the test workflow cloning instance "prod-1"
+// into the debug application app-1.
+func newCloneTestWorkflow(t *testing.T) (*Workflow, *yesterdaygo.MockClient, *fakeMonitor) {
+	workflow, client, _, monitor := newTestWorkflow(t)
+	client.SetMockResponse("/apps/prod-1/clone", http.StatusOK, CloneInfo{
+		InstanceID: "app-1",
+		CloneOf:    "prod-1",
+		Name:       "tasks",
+		Version:    "1.2.0",
+		HostName:   "tasks-clone.example.com",
+		Sandbox:    true,
+		ExpiresAt:  time.Now().Add(2 * time.Hour),
+	})
+	clones := NewCloneManager(client, "prod-1", "2h")
+	clones.readyPollInterval = 10 * time.Millisecond
+	workflow.Apps = clones
+	return workflow, client, monitor
+}
+
+func TestCloneWorkflowRun(t *testing.T) {
+	workflow, client, monitor := newCloneTestWorkflow(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.onStart = cancel
+
+	if err := workflow.Run(ctx); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	app := workflow.Application()
+	if app.ID != "app-1" || app.HostName != "tasks-clone.example.com" || app.DisplayName != "Clone of tasks" {
+		t.Errorf("Expected the clone as the debug application, got %+v", app)
+	}
+
+	yesterdaygo.AssertRequestMade(t, client, "POST", "/apps/prod-1/clone")
+	yesterdaygo.AssertRequestMade(t, client, "POST", testAppPath+"/upload")
+	yesterdaygo.AssertRequestMade(t, client, "POST", testAppPath+"/install-dev")
+	yesterdaygo.AssertRequestMade(t, client, "DELETE", "/apps/app-1/clone")
+	for _, req := range client.GetRequestHistory() {
+		if req.Path == "/apps/prod-1/clone" {
+			if body, ok := req.Body.(map[string]interface{}); !ok || body["ttl"] != "2h" {
+				t.Errorf("Expected the TTL to be sent, got %+v", req.Body)
+			}
+		}
+		if req.Path == "/debug/application" || req.Path == testAppPath+"/stop" {
+			t.Errorf("Expected no debug application to be created or stopped, got %+v", req)
+		}
+	}
+}
+
+func TestCloneWorkflowCloneFailure(t *testing.T) {
+	workflow, client, _ := newCloneTestWorkflow(t)
+	client.SetMockResponse("/apps/prod-1/clone", http.StatusNotFound, map[string]string{"error": "application prod-1 not found"})
+
+	err := workflow.Run(context.Background())
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != StageCreateApp {
+		t.Fatalf("Expected a create-app StageError, got %v", err)
+	}
+	for _, req := range client.GetRequestHistory() {
+		if req.Method == "DELETE" {
+			t.Errorf("Expected no cleanup of a clone that was not created, got %+v", req)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tomyedwab/yesterday/nexusdebug"
+)
+
+// runCloneCommand clones an installed instance and runs the debug workflow
+// against the clone, removing it on exit
+func runCloneCommand(args []string) error {
+	flags := flag.NewFlagSet("clone", flag.ContinueOnError)
+	var config nexusdebug.Config
+	flags.StringVar(&config.AdminURL, "admin-url", "", "Target NexusHub admin service URL (required)")
+	from := flags.String("from", "", "Instance ID to clone (required)")
+	ttl := flags.String("ttl", "", "How long the hub keeps the clone if it is not removed, e.g. 2h (default the hub's)")
+	flags.StringVar(&config.BuildCommand, "build-cmd", "make build", "Build command to execute")
+	flags.StringVar(&config.PackageFilename, "package", "dist/package.zip", "Package filename path")
+	flags.StringVar(&config.LogLevel, "log-level", "", "Only display log entries at or above this level: debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if config.AdminURL == "" || *from == "" {
+		flags.Usage()
+		return fmt.Errorf("-admin-url and --from are required")
+	}
+	if !nexusdebug.ValidLogLevel(config.LogLevel) {
+		return fmt.Errorf("invalid -log-level %q: use debug, info, warn or error", config.LogLevel)
+	}
+	config.AppName = *from
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.Printf("NexusDebug CLI starting in clone mode...")
+	log.Printf("  Admin URL: %s", config.AdminURL)
+	log.Printf("  Cloning: %s", *from)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workflow := nexusdebug.NewCloneWorkflow(config, *from, *ttl)
+	workflow.Interactive = true
+	return workflow.Run(ctx)
+}
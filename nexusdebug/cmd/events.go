@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tomyedwab/yesterday/nexusdebug"
+)
+
+// runEventCommand implements the export-events and import-events commands,
+// which copy an application's event log to or from a running debug
+// application.
+func runEventCommand(name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	adminURL := flags.String("admin-url", "", "Target NexusHub admin service URL (required)")
+	appID := flags.String("id", "", "Debug application ID, as printed when it was created (required)")
+	file := flags.String("file", "", "Event log file (default stdout for export, stdin for import)")
+	force := flags.Bool("force", false, "Import even if the application has already applied events")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *adminURL == "" || *appID == "" {
+		flags.Usage()
+		return fmt.Errorf("-admin-url and -id are required")
+	}
+
+	ctx := context.Background()
+	authManager := nexusdebug.NewAuthManager(*adminURL)
+	if err := authManager.Login(ctx); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+
+	if name == "export-events" {
+		out := io.Writer(os.Stdout)
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		written, err := nexusdebug.ExportEvents(ctx, authManager.Client, *appID, out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d bytes of events\n", written)
+		return nil
+	}
+
+	var export []byte
+	var err error
+	if *file != "" {
+		export, err = os.ReadFile(*file)
+	} else {
+		export, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	result, err := nexusdebug.ImportEvents(ctx, authManager.Client, *appID, export, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d events; application is now at event %d\n", result.Applied, result.CurrentEventId)
+	return nil
+}
@@ -9,44 +9,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/tomyedwab/yesterday/nexusdebug"
 )
 
-// Config holds the CLI configuration parameters
-type Config struct {
-	AdminURL         string // Required: Target NexusHub admin service URL
-	AppName          string // Required: Used to generate AppID, DisplayName, and HostName
-	BuildCommand     string // Optional: Defaults to "make build"
-	PackageFilename  string // Optional: Defaults to "dist/package.zip"
-	StaticServiceURL string // Optional: For proxying frontend requests during development
-}
-
-// validateConfig validates the configuration and returns an error if invalid
-func validateConfig(config *Config) error {
-	if config.AdminURL == "" {
-		return fmt.Errorf("admin URL is required")
-	}
-
-	if config.AppName == "" {
-		return fmt.Errorf("application name is required")
-	}
-
-	// Validate that package filename directory exists or can be created
-	packageDir := filepath.Dir(config.PackageFilename)
-	if packageDir != "." {
-		if _, err := os.Stat(packageDir); os.IsNotExist(err) {
-			log.Printf("Warning: Package directory %s does not exist", packageDir)
-		}
-	}
-
-	return nil
+// exitCodes maps the workflow stage that failed to the CLI's exit code, so
+// scripts can tell a build failure from a deployment failure
+var exitCodes = map[nexusdebug.Stage]int{
+	nexusdebug.StageAuthenticate: 2,
+	nexusdebug.StageCreateApp:    3,
+	nexusdebug.StageBuild:        4,
+	nexusdebug.StageDeploy:       5,
+	nexusdebug.StageMonitor:      6,
 }
 
 // printUsage prints the CLI usage information
@@ -58,24 +39,56 @@ running within the NexusHub platform.
 
 Usage:
   %s [options]
+  %s export-events -admin-url=URL -id=ID [-file=events.ndjson]
+  %s import-events -admin-url=URL -id=ID [-file=events.ndjson] [-force]
+  %s clone --from=INSTANCE -admin-url=URL [-ttl=2h] [-build-cmd=CMD] [-package=PATH] [-log-level=LEVEL]
 
 Options:
-`, os.Args[0])
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, `
 Examples:
   %s -admin-url=https://admin.example.com -app-name=myapp
   %s -admin-url=https://admin.example.com -app-name=myapp -build-cmd="go build" -package="build/app.zip"
+  %s -admin-url=https://admin.example.com -app-name=myapp -log-level=warn
+
+  %s export-events -admin-url=https://admin.example.com -id=<debug app ID> -file=prod.ndjson
+  %s import-events -admin-url=https://admin.example.com -id=<debug app ID> -file=prod.ndjson
+
+  %s clone --from=<instance ID> -admin-url=https://admin.example.com -ttl=2h
 
 Interactive Commands (during execution):
   R - Rebuild and redeploy application
   Q - Quit and cleanup debug application
 
-`, os.Args[0], os.Args[0])
+Exit Codes:
+  1 - Invalid arguments or other error
+  2 - Authentication failed
+  3 - Debug application could not be created
+  4 - Build failed
+  5 - Upload, installation or startup failed
+  6 - Monitoring could not be started
+
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 }
 
 func main() {
-	var config Config
+	if len(os.Args) > 1 && (os.Args[1] == "export-events" || os.Args[1] == "import-events") {
+		if err := runEventCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "clone" {
+		if err := runCloneCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	var config nexusdebug.Config
 	var showHelp bool
 
 	// Define command-line flags
@@ -84,6 +97,7 @@ func main() {
 	flag.StringVar(&config.BuildCommand, "build-cmd", "make build", "Build command to execute")
 	flag.StringVar(&config.PackageFilename, "package", "dist/package.zip", "Package filename path")
 	flag.StringVar(&config.StaticServiceURL, "static-url", "", "Static service URL for proxying frontend requests during development")
+	flag.StringVar(&config.LogLevel, "log-level", "", "Only display log entries at or above this level: debug, info, warn or error")
 	flag.BoolVar(&showHelp, "help", false, "Show this help message")
 	flag.BoolVar(&showHelp, "h", false, "Show this help message")
 
@@ -100,7 +114,7 @@ func main() {
 	}
 
 	// Validate configuration
-	if err := validateConfig(&config); err != nil {
+	if err := config.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
 		printUsage()
 		os.Exit(1)
@@ -117,151 +131,30 @@ func main() {
 	if config.StaticServiceURL != "" {
 		log.Printf("  Static Service URL: %s", config.StaticServiceURL)
 	}
-
-	// Initialize authentication manager
-	authManager := nexusdebug.NewAuthManager(config.AdminURL)
-
-	// Create context with timeout for authentication
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Perform authentication
-	log.Printf("Authentication status: %s", authManager.GetAuthenticationStatus(ctx))
-	if err := authManager.Login(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "Authentication failed: %v\n", err)
-		os.Exit(1)
+	if config.LogLevel != "" {
+		log.Printf("  Log Level: %s", config.LogLevel)
 	}
 
-	log.Printf("Authentication status: %s", authManager.GetAuthenticationStatus(ctx))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Initialize application manager
-	appManager := nexusdebug.NewApplicationManager(authManager.Client, config.AppName, config.StaticServiceURL)
-
-	// Initialize build manager
-	buildManager := nexusdebug.NewBuildManager(config.BuildCommand, config.PackageFilename)
-
-	// Setup graceful shutdown handling
-	ctx, cancel = context.WithCancel(context.Background())
-	defer cancel()
-
-	// Setup cleanup on exit
-	defer func() {
-		log.Printf("Cleaning up debug application...")
-		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cleanupCancel()
-		if err := appManager.Cleanup(cleanupCtx); err != nil {
-			log.Printf("Cleanup error: %v", err)
-		}
-	}()
-
-	// Create debug application
-	log.Printf("Creating debug application...")
-	createCtx, createCancel := context.WithTimeout(ctx, 30*time.Second)
-	defer createCancel()
-	app, err := appManager.CreateApplication(createCtx)
+	workflow := nexusdebug.NewWorkflow(config)
+	workflow.Interactive = true
+	err := workflow.Run(ctx)
+	stop()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create debug application: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err))
 	}
+}
 
-	fmt.Printf("\nDebug application created successfully!\n")
-	fmt.Printf("Application ID: %s\n", app.ID)
-	fmt.Printf("Display Name: %s\n", app.DisplayName)
-	fmt.Printf("Host Name: %s\n", app.HostName)
-	if app.StaticServiceURL != "" {
-		fmt.Printf("Static Service URL: %s\n", app.StaticServiceURL)
-	}
-
-	// Build application package
-	log.Printf("Building application package...")
-	buildCtx, buildCancel := context.WithTimeout(ctx, 300*time.Second) // 5 minute timeout
-	defer buildCancel()
-	if err := buildManager.BuildApplication(buildCtx); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to build application: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Display package information
-	packageSize, err := buildManager.GetPackageSize()
-	if err != nil {
-		log.Printf("Warning: could not get package size: %v", err)
-	} else {
-		fmt.Printf("📦 Package built successfully: %s (%.2f MB)\n", buildManager.GetPackagePath(), float64(packageSize)/1024/1024)
-	}
-
-	// Initialize upload manager
-	uploadManager := nexusdebug.NewUploadManager(authManager.Client)
-	uploadManager.SetApplication(app)
-
-	// Upload and install the application package
-	log.Printf("Uploading and installing debug application...")
-	uploadCtx, uploadCancel := context.WithTimeout(ctx, 600*time.Second) // 10 minute timeout for upload+install
-	defer uploadCancel()
-	
-	// Upload with progress reporting
-	if err := uploadManager.UploadAndInstall(uploadCtx, buildManager.GetPackagePath(), nexusdebug.PrintUploadProgress); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to upload and install debug application: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Wait for application to be ready
-	log.Printf("Waiting for application to start...")
-	readyCtx, readyCancel := context.WithTimeout(ctx, 120*time.Second)
-	defer readyCancel()
-	if err := appManager.InstallApplication(readyCtx); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to verify application startup: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Printf("\n✅ Debug application is now running!\n")
-	fmt.Printf("🌐 Access your application at: https://%s\n", app.HostName)
-	fmt.Printf("\n📋 Next Steps:\n")
-	fmt.Printf("  1. Package uploaded and installed successfully\n")
-	fmt.Printf("  2. Use 'R' key for hot-reload after making changes\n")
-	fmt.Printf("  3. Use 'Q' key to exit gracefully\n")
-	fmt.Printf("\n🔄 Application build, upload, and deployment pipeline ready!")
-
-	// Initialize monitoring
-	monitor := nexusdebug.NewMonitor(authManager.Client, app)
-	
-	// Start monitoring in the background
-	monitorCtx, monitorCancel := context.WithCancel(ctx)
-	defer monitorCancel()
-	
-	if err := monitor.StartMonitoring(monitorCtx); err != nil {
-		log.Printf("Warning: failed to start monitoring: %v", err)
-	} else {
-		fmt.Printf("\n📊 Monitoring started - displaying logs and status updates:\n")
-		fmt.Printf("───────────────────────────────────────────────────────────────\n")
-		
-		// Start displaying logs in a separate goroutine
-		go monitor.DisplayLogs(monitorCtx)
-	}
-
-	// Initialize interactive control system
-	control := nexusdebug.NewControl(authManager.Client, app, monitor)
-	control.SetManagers(buildManager, uploadManager, appManager)
-
-	// Start interactive mode
-	fmt.Printf("\n🎮 Starting interactive control mode...\n")
-	if err := control.StartInteractiveMode(monitorCtx); err != nil {
-		log.Printf("Warning: failed to start interactive mode: %v", err)
-	}
-
-	// Keep the application running with interactive controls and monitoring
-	select {
-	case <-ctx.Done():
-		fmt.Println("\nShutting down...")
-	}
-
-	// Stop interactive control and monitoring
-	control.StopInteractiveMode()
-	monitor.StopMonitoring()
-
-	// Cleanup on exit
-	defer func() {
-		if err := authManager.Logout(context.Background()); err != nil {
-			log.Printf("Warning: logout failed: %v", err)
+// exitCode returns the exit code for the stage that failed, or 1
+func exitCode(err error) int {
+	var stageErr *nexusdebug.StageError
+	if errors.As(err, &stageErr) {
+		if code, ok := exitCodes[stageErr.Stage]; ok {
+			return code
 		}
-	}()
+	}
+	return 1
 }
@@ -10,24 +10,20 @@ package nexusdebug
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
+	"sync"
 	"time"
 
 	"golang.org/x/term"
-	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
 )
 
-// Control handles interactive user input and hot-reload workflows
+// Control handles interactive user input and hands rebuild and shutdown
+// requests to its callbacks
 type Control struct {
-	client         *yesterdaygo.Client
-	app            *DebugApplication
-	monitor        *Monitor
-	buildManager   *BuildManager
-	uploadManager  *UploadManager
-	appManager     *ApplicationManager
-	stopChan       chan struct{}
-	terminalState  *term.State
+	callbacks          ControlCallbacks
+	stopChan           chan struct{}
+	stopOnce           sync.Once
+	terminalState      *term.State
 	inputCheckInterval time.Duration
 }
 
@@ -38,23 +34,14 @@ type ControlCallbacks struct {
 }
 
 // NewControl creates a new interactive control instance
-func NewControl(client *yesterdaygo.Client, app *DebugApplication, monitor *Monitor) *Control {
+func NewControl(callbacks ControlCallbacks) *Control {
 	return &Control{
-		client:            client,
-		app:               app,
-		monitor:           monitor,
-		stopChan:          make(chan struct{}),
+		callbacks:          callbacks,
+		stopChan:           make(chan struct{}),
 		inputCheckInterval: 100 * time.Millisecond, // Check for input every 100ms
 	}
 }
 
-// SetManagers configures the necessary managers for rebuild operations
-func (c *Control) SetManagers(buildMgr *BuildManager, uploadMgr *UploadManager, appMgr *ApplicationManager) {
-	c.buildManager = buildMgr
-	c.uploadManager = uploadMgr
-	c.appManager = appMgr
-}
-
 // StartInteractiveMode begins the interactive control loop
 func (c *Control) StartInteractiveMode(ctx context.Context) error {
 	// Display initial instructions
@@ -66,9 +53,10 @@ func (c *Control) StartInteractiveMode(ctx context.Context) error {
 	return nil
 }
 
-// StopInteractiveMode stops the interactive control loop
+// StopInteractiveMode stops the interactive control loop. It is safe to call
+// more than once.
 func (c *Control) StopInteractiveMode() {
-	close(c.stopChan)
+	c.stopOnce.Do(func() { close(c.stopChan) })
 	c.restoreTerminal()
 }
 
@@ -113,17 +101,17 @@ func (c *Control) checkForInput() (byte, error) {
 	// Use a very simple approach: try to read one byte with immediate timeout
 	// This avoids the goroutine complexity
 	buf := make([]byte, 1)
-	
+
 	// Try to read without blocking by immediately restoring and checking
 	// if there was input queued
 	c.restoreTerminal()
 	c.setRawMode()
-	
+
 	// Set a very short deadline for non-blocking read
 	deadline := time.Now().Add(1 * time.Millisecond)
 	os.Stdin.SetReadDeadline(deadline)
 	defer os.Stdin.SetReadDeadline(time.Time{}) // Clear deadline
-	
+
 	n, err := os.Stdin.Read(buf)
 	if err != nil || n == 0 {
 		return 0, nil // No input available
@@ -134,7 +122,7 @@ func (c *Control) checkForInput() (byte, error) {
 // setRawMode switches the terminal to raw mode for input detection
 func (c *Control) setRawMode() error {
 	fd := int(os.Stdin.Fd())
-	
+
 	// Save current state if not already saved
 	if c.terminalState == nil {
 		state, err := term.GetState(fd)
@@ -186,76 +174,23 @@ func (c *Control) handleKeyPress(ctx context.Context, key byte) {
 
 // handleRebuild executes the rebuild and redeploy workflow
 func (c *Control) handleRebuild(ctx context.Context) error {
-	if c.buildManager == nil || c.uploadManager == nil || c.appManager == nil {
-		return fmt.Errorf("managers not configured for rebuild operations")
-	}
-
-	// Step 1: Stop current application instance
-	fmt.Println("🛑 Stopping current application...")
-	if err := c.appManager.StopApplication(ctx); err != nil {
-		log.Printf("Warning: Failed to stop application: %v", err)
-		// Continue with rebuild even if stop fails
-	}
-
-	// Step 2: Execute build command
-	fmt.Println("🔨 Building application...")
-	if err := c.buildManager.BuildApplication(ctx); err != nil {
-		return fmt.Errorf("build failed: %w", err)
+	if c.callbacks.OnRebuild == nil {
+		return fmt.Errorf("rebuild is not configured")
 	}
-	fmt.Println("✅ Build completed successfully")
-
-	// Step 3: Upload new package
-	fmt.Println("📦 Uploading new package...")
-	if err := c.uploadManager.UploadPackageFromBuildManager(ctx, c.buildManager, PrintUploadProgress); err != nil {
-		return fmt.Errorf("upload failed: %w", err)
+	if err := c.callbacks.OnRebuild(ctx); err != nil {
+		return err
 	}
-	fmt.Println("✅ Package uploaded successfully")
-
-	// Step 4: Install and start updated application
-	fmt.Println("🚀 Installing and starting application...")
-	if err := c.uploadManager.InstallUploadedPackage(ctx); err != nil {
-		return fmt.Errorf("installation failed: %w", err)
-	}
-	fmt.Println("✅ Application redeployed successfully")
-
-	// Step 5: Resume log monitoring (already running, just notify)
 	fmt.Println("📊 Resuming log monitoring...")
 	fmt.Println("Press 'R' to rebuild again, 'Q' to quit")
-
 	return nil
 }
 
-// handleShutdown executes the graceful shutdown workflow
+// handleShutdown stops interactive mode and asks the workflow to shut down,
+// which cleans up the debug application and closes the session
 func (c *Control) handleShutdown(ctx context.Context) error {
-	// Step 1: Stop monitoring
-	fmt.Println("📊 Stopping monitoring...")
-	if c.monitor != nil {
-		c.monitor.StopMonitoring()
-	}
-
-	// Step 2: Stop application instance and clean up
-	fmt.Println("🛑 Stopping application instance...")
-	if c.appManager != nil {
-		if err := c.appManager.Cleanup(ctx); err != nil {
-			log.Printf("Warning: Failed to cleanup application: %v", err)
-		}
-	}
-
-	// Step 3: Close authentication session
-	fmt.Println("🔐 Closing authentication session...")
-	if c.client != nil {
-		if err := c.client.Logout(ctx); err != nil {
-			log.Printf("Warning: Failed to logout: %v", err)
-		}
-	}
-
-	// Step 4: Stop interactive mode
 	c.StopInteractiveMode()
-
-	fmt.Println("✅ Graceful shutdown completed")
-	
-	// Exit the program
-	os.Exit(0)
-	
-	return nil
-}
\ No newline at end of file
+	if c.callbacks.OnShutdown == nil {
+		return nil
+	}
+	return c.callbacks.OnShutdown(ctx)
+}
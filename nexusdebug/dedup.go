@@ -0,0 +1,269 @@
+// Deduplicated package uploads.
+//
+// The package is split into content-defined chunks, so an insertion or
+// deletion only changes the chunks around it. The hub is sent the manifest
+// of chunk hashes first and answers with the chunks it does not have yet;
+// only those are uploaded before the hub assembles and verifies the package.
+// Successive deployments that mostly share their bytes upload only the
+// changed chunks.
+//
+// Reference: spec/nexusdebug.md - Task nexusdebug-file-upload
+package nexusdebug
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// Content-defined chunks are at least minDedupChunkSize and at most
+	// maxDedupChunkSize bytes, and about 1.5MB on average
+	minDedupChunkSize = 512 * 1024
+	maxDedupChunkSize = 8 * 1024 * 1024
+	dedupChunkMask    = 1<<20 - 1
+)
+
+// errDedupUnsupported is returned when the hub does not support
+// deduplicated uploads
+var errDedupUnsupported = errors.New("hub does not support deduplicated uploads")
+
+// gearTable maps each byte to a pseudo-random value for the rolling hash
+// that finds chunk boundaries. It is derived from SHA-256 so that every
+// client cuts the same package into the same chunks.
+var gearTable = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// packageChunk is one content-defined chunk of a package file
+type packageChunk struct {
+	Hash   string
+	Offset int64
+	Size   int64
+}
+
+// chunkManifest is the body of POST /debug/application/{id}/upload/manifest
+type chunkManifest struct {
+	Chunks   []string `json:"chunks"`
+	FileHash string   `json:"fileHash"`
+}
+
+// manifestResponse lists the chunks the hub is missing. Missing is nil if
+// the response did not include the field.
+type manifestResponse struct {
+	Missing *[]string `json:"missing"`
+}
+
+// splitPackage cuts the data into content-defined chunks using a gear
+// rolling hash, returning the chunks and the SHA-256 hash of the whole data.
+func splitPackage(r io.Reader) ([]packageChunk, string, error) {
+	var chunks []packageChunk
+	fileHasher := sha256.New()
+	chunkHasher := sha256.New()
+	reader := bufio.NewReaderSize(r, 1<<20)
+
+	var offset, size int64
+	var rolling uint64
+	buffer := make([]byte, 0, 64*1024)
+	flush := func() {
+		chunkHasher.Write(buffer)
+		fileHasher.Write(buffer)
+		buffer = buffer[:0]
+	}
+	cut := func() {
+		flush()
+		chunks = append(chunks, packageChunk{
+			Hash:   hex.EncodeToString(chunkHasher.Sum(nil)),
+			Offset: offset,
+			Size:   size,
+		})
+		chunkHasher.Reset()
+		offset += size
+		size = 0
+		rolling = 0
+	}
+
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		buffer = append(buffer, b)
+		if len(buffer) == cap(buffer) {
+			flush()
+		}
+		size++
+		rolling = rolling<<1 + gearTable[b]
+		if size >= maxDedupChunkSize || size >= minDedupChunkSize && rolling&dedupChunkMask == 0 {
+			cut()
+		}
+	}
+	if size > 0 {
+		cut()
+	}
+	flush()
+	return chunks, hex.EncodeToString(fileHasher.Sum(nil)), nil
+}
+
+// uploadDeduplicated uploads only the chunks of the package the hub does not
+// have. It returns errDedupUnsupported if the hub does not support it.
+func (um *UploadManager) uploadDeduplicated(ctx context.Context, appID, packagePath string, progress *UploadProgress, progressCallback UploadProgressCallback) error {
+	file, err := os.Open(packagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open package file: %w", err)
+	}
+	defer file.Close()
+
+	log.Printf("🔍 Splitting package into chunks...")
+	chunks, fileHash, err := splitPackage(file)
+	if err != nil {
+		return fmt.Errorf("failed to read package file: %w", err)
+	}
+	manifest := chunkManifest{FileHash: fileHash}
+	for _, chunk := range chunks {
+		manifest.Chunks = append(manifest.Chunks, chunk.Hash)
+	}
+
+	missing, err := um.sendManifest(ctx, appID, manifest)
+	if err != nil {
+		return err
+	}
+
+	// Upload each missing chunk once, in package order
+	needed := make(map[string]bool, len(missing))
+	for _, hash := range missing {
+		needed[hash] = true
+	}
+	var toUpload []packageChunk
+	var uploadBytes int64
+	for _, chunk := range chunks {
+		if needed[chunk.Hash] {
+			toUpload = append(toUpload, chunk)
+			uploadBytes += chunk.Size
+			delete(needed, chunk.Hash)
+		}
+	}
+	progress.ChunksTotal = len(toUpload)
+	progress.BytesSkipped = progress.TotalBytes - uploadBytes
+	log.Printf("Hub already has %d of %d chunks, uploading %.2f MB of %.2f MB",
+		len(chunks)-len(toUpload), len(chunks), float64(uploadBytes)/1024/1024, float64(progress.TotalBytes)/1024/1024)
+
+	for i, chunk := range toUpload {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("upload timeout exceeded")
+		default:
+		}
+
+		progress.CurrentChunk = i + 1
+		progress.ChunksUploaded = i
+		um.reportProgress(progress, progressCallback)
+
+		data := make([]byte, chunk.Size)
+		if _, err := file.ReadAt(data, chunk.Offset); err != nil {
+			return fmt.Errorf("failed to read chunk data: %w", err)
+		}
+		if err := um.retry(ctx, fmt.Sprintf("chunk %d", i+1), func(ctx context.Context) error {
+			return um.uploadDedupChunk(ctx, appID, chunk.Hash, data)
+		}); err != nil {
+			return fmt.Errorf("failed to upload chunk %d: %w", i+1, err)
+		}
+		progress.BytesUploaded += chunk.Size
+	}
+	progress.ChunksUploaded = len(toUpload)
+
+	return um.commitDeduplicated(ctx, appID)
+}
+
+// sendManifest sends the manifest and returns the hashes of the chunks the
+// hub is missing
+func (um *UploadManager) sendManifest(ctx context.Context, appID string, manifest chunkManifest) ([]string, error) {
+	response, err := um.client.Post(ctx, fmt.Sprintf("/debug/application/%s/upload/manifest", appID), manifest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("manifest request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, errDedupUnsupported
+	default:
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("manifest request failed with status %d: %s", response.StatusCode, string(bodyBytes))
+	}
+
+	// Hubs that predate deduplication may answer without a list of chunks
+	var result manifestResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil || result.Missing == nil {
+		return nil, errDedupUnsupported
+	}
+	return *result.Missing, nil
+}
+
+// uploadDedupChunk uploads one chunk listed in the manifest
+func (um *UploadManager) uploadDedupChunk(ctx context.Context, appID, hash string, data []byte) error {
+	endpoint := fmt.Sprintf("/debug/application/%s/upload/chunk", appID)
+	response, err := um.client.PostMultipart(ctx, endpoint, map[string]string{"hash": hash}, map[string][]byte{"chunk": data}, nil)
+	if err != nil {
+		return fmt.Errorf("chunk upload request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("chunk upload failed with status %d: %s", response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// commitDeduplicated asks the hub to assemble and verify the package
+func (um *UploadManager) commitDeduplicated(ctx context.Context, appID string) error {
+	response, err := um.client.Post(ctx, fmt.Sprintf("/debug/application/%s/upload/commit", appID), nil, nil)
+	if err != nil {
+		return fmt.Errorf("commit request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("commit failed with status %d: %s", response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// reportProgress updates the derived progress fields and calls the callback
+func (um *UploadManager) reportProgress(progress *UploadProgress, progressCallback UploadProgressCallback) {
+	progress.ElapsedTime = time.Since(progress.StartTime)
+	done := progress.BytesUploaded + progress.BytesSkipped
+	if progress.TotalBytes > 0 {
+		progress.Percentage = float64(done) / float64(progress.TotalBytes) * 100
+	}
+	if progress.ElapsedTime.Seconds() > 0 {
+		progress.UploadSpeed = float64(progress.BytesUploaded) / progress.ElapsedTime.Seconds()
+		if progress.UploadSpeed > 0 {
+			remaining := float64(progress.TotalBytes - done)
+			progress.EstimatedTime = time.Duration(remaining/progress.UploadSpeed) * time.Second
+		}
+	}
+	if progressCallback != nil {
+		progressCallback(progress)
+	}
+}
@@ -0,0 +1,185 @@
+package nexusdebug
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// fakeDedupHub implements the deduplicated upload endpoints in memory,
+// counting the chunk bytes it receives
+type fakeDedupHub struct {
+	mu            sync.Mutex
+	chunks        map[string][]byte
+	manifest      chunkManifest
+	packageData   []byte
+	uploadedBytes int
+	fullUploads   int
+	unsupported   bool
+}
+
+func (h *fakeDedupHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch r.URL.Path {
+	case "/debug/application/app-1/upload/manifest":
+		if h.unsupported {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&h.manifest)
+		missing := []string{}
+		for _, hash := range h.manifest.Chunks {
+			if _, ok := h.chunks[hash]; !ok {
+				missing = append(missing, hash)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"missing": missing})
+	case "/debug/application/app-1/upload/chunk":
+		file, _, err := r.FormFile("chunk")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		sum := sha256.Sum256(data)
+		if hash := r.FormValue("hash"); hex.EncodeToString(sum[:]) != hash {
+			http.Error(w, "hash mismatch", http.StatusBadRequest)
+			return
+		}
+		h.chunks[r.FormValue("hash")] = data
+		h.uploadedBytes += len(data)
+	case "/debug/application/app-1/upload/commit":
+		var assembled []byte
+		for _, hash := range h.manifest.Chunks {
+			assembled = append(assembled, h.chunks[hash]...)
+		}
+		sum := sha256.Sum256(assembled)
+		if hex.EncodeToString(sum[:]) != h.manifest.FileHash {
+			http.Error(w, "file hash mismatch", http.StatusBadRequest)
+			return
+		}
+		h.packageData = assembled
+	case "/debug/application/app-1/upload":
+		file, _, _ := r.FormFile("chunk")
+		data, _ := io.ReadAll(file)
+		h.uploadedBytes += len(data)
+		h.fullUploads++
+	}
+}
+
+func newDedupTestUploader(t *testing.T, hub *fakeDedupHub) *UploadManager {
+	hub.chunks = make(map[string][]byte)
+	server := httptest.NewServer(hub)
+	t.Cleanup(server.Close)
+	uploader := NewUploadManager(yesterdaygo.NewClient(server.URL))
+	uploader.SetApplication(&DebugApplication{ID: "app-1"})
+	uploader.SetMaxRetries(1)
+	return uploader
+}
+
+// syntheticPackage returns size bytes of incompressible data, like the
+// vendored assets of a real package
+func syntheticPackage(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func writePackage(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "package.zip")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDeduplicatedUploadSendsOnlyChangedChunks(t *testing.T) {
+	hub := &fakeDedupHub{}
+	uploader := newDedupTestUploader(t, hub)
+
+	first := syntheticPackage(1, 48<<20)
+	if err := uploader.UploadPackage(context.Background(), writePackage(t, first), nil); err != nil {
+		t.Fatalf("First upload failed: %v", err)
+	}
+	if hub.uploadedBytes != len(first) || !bytes.Equal(hub.packageData, first) {
+		t.Fatalf("Expected the first upload to send the whole package, sent %d of %d bytes", hub.uploadedBytes, len(first))
+	}
+
+	// A small edit in the middle and a few bytes inserted near the start,
+	// which shifts everything after it
+	second := append([]byte(nil), first[:10<<20]...)
+	second = append(second, []byte("inserted bytes")...)
+	second = append(second, first[10<<20:]...)
+	copy(second[30<<20:], []byte("edited"))
+
+	hub.uploadedBytes = 0
+	if err := uploader.UploadPackage(context.Background(), writePackage(t, second), nil); err != nil {
+		t.Fatalf("Second upload failed: %v", err)
+	}
+	if !bytes.Equal(hub.packageData, second) {
+		t.Fatal("Expected the hub to assemble the second package")
+	}
+	if hub.uploadedBytes > 8<<20 {
+		t.Errorf("Expected the second upload to send only a few MB, sent %.2f MB", float64(hub.uploadedBytes)/(1<<20))
+	}
+	if hub.fullUploads != 0 {
+		t.Errorf("Expected no full uploads, got %d chunks", hub.fullUploads)
+	}
+}
+
+func TestUploadFallsBackWithoutDeduplication(t *testing.T) {
+	hub := &fakeDedupHub{unsupported: true}
+	uploader := newDedupTestUploader(t, hub)
+	uploader.SetChunkSize(1 << 20)
+
+	data := syntheticPackage(2, 3<<20)
+	if err := uploader.UploadPackage(context.Background(), writePackage(t, data), nil); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if hub.fullUploads != 3 || hub.uploadedBytes != len(data) {
+		t.Errorf("Expected the whole package in 3 chunks, got %d chunks and %d bytes", hub.fullUploads, hub.uploadedBytes)
+	}
+}
+
+func TestSplitPackageResynchronizes(t *testing.T) {
+	data := syntheticPackage(3, 16<<20)
+	shifted := append([]byte("prefix"), data...)
+
+	original, _, err := splitPackage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, _, err := splitPackage(bytes.NewReader(shifted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, chunk := range original {
+		seen[chunk.Hash] = true
+		if chunk.Size > maxDedupChunkSize {
+			t.Errorf("Expected chunks of at most %d bytes, got %d", maxDedupChunkSize, chunk.Size)
+		}
+	}
+	shared := 0
+	for _, chunk := range chunks {
+		if seen[chunk.Hash] {
+			shared++
+		}
+	}
+	if shared < len(original)-1 {
+		t.Errorf("Expected all but the first chunk to survive a shift, %d of %d did", shared, len(original))
+	}
+}
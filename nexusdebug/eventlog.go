@@ -0,0 +1,60 @@
+// Event log export and import for debug applications.
+//
+// The hub forwards these requests to the application's internal event log
+// endpoints, so a production event history can be replayed into a local
+// debug instance to reproduce its state.
+package nexusdebug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+// ImportResult reports the outcome of an event log import
+type ImportResult struct {
+	Applied        int `json:"applied"`
+	CurrentEventId int `json:"currentEventId"`
+}
+
+// ExportEvents streams the event log of a debug application to w as
+// newline-delimited JSON and returns the number of bytes written
+func ExportEvents(ctx context.Context, client *yesterdaygo.Client, appID string, w io.Writer) (int64, error) {
+	endpoint := fmt.Sprintf("/debug/application/%s/events/export", appID)
+	written, err := client.GetToWriter(ctx, endpoint, w, nil)
+	if err != nil {
+		return written, fmt.Errorf("event export failed: %w", err)
+	}
+	return written, nil
+}
+
+// ImportEvents replays an exported event log into a debug application. The
+// application refuses to import into a database that already has events
+// unless force is set, in which case events it has already applied are
+// skipped.
+func ImportEvents(ctx context.Context, client *yesterdaygo.Client, appID string, export []byte, force bool) (*ImportResult, error) {
+	endpoint := fmt.Sprintf("/debug/application/%s/events/import", appID)
+	if force {
+		endpoint += "?force=true"
+	}
+	response, err := client.PostMultipart(ctx, endpoint, nil, map[string][]byte{"events": export}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("event import request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("event import failed with status %d: %s", response.StatusCode, string(bodyBytes))
+	}
+
+	var result ImportResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse import response: %w", err)
+	}
+	return &result, nil
+}
@@ -15,9 +15,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
-
-	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
 )
 
 // LogEntry represents a single log entry from the debug application
@@ -31,16 +30,20 @@ type LogEntry struct {
 
 // Monitor handles real-time log tailing and application status monitoring
 type Monitor struct {
-	client     *yesterdaygo.Client
+	client     APIClient
 	app        *DebugApplication
 	logStream  io.ReadCloser
 	stopChan   chan struct{}
+	stopOnce   sync.Once
 	statusChan chan *ApplicationStatus
 	logChan    chan *LogEntry
+	// LogLevel is the lowest level of log entries DisplayLogs shows ("" for
+	// all of them)
+	LogLevel string
 }
 
 // NewMonitor creates a new monitoring instance for the given debug application
-func NewMonitor(client *yesterdaygo.Client, app *DebugApplication) *Monitor {
+func NewMonitor(client APIClient, app *DebugApplication) *Monitor {
 	return &Monitor{
 		client:     client,
 		app:        app,
@@ -67,9 +70,10 @@ func (m *Monitor) StartMonitoring(ctx context.Context) error {
 	return nil
 }
 
-// StopMonitoring stops all monitoring activities
+// StopMonitoring stops all monitoring activities. It is safe to call more
+// than once.
 func (m *Monitor) StopMonitoring() {
-	close(m.stopChan)
+	m.stopOnce.Do(func() { close(m.stopChan) })
 
 	if m.logStream != nil {
 		m.logStream.Close()
@@ -298,6 +302,35 @@ func formatLogLevel(level string) string {
 	}
 }
 
+// logLevelRank orders log levels by severity, returning -1 for levels it
+// does not know
+func logLevelRank(level string) int {
+	switch strings.ToLower(level) {
+	case "debug":
+		return 0
+	case "info":
+		return 1
+	case "warn", "warning":
+		return 2
+	case "error":
+		return 3
+	default:
+		return -1
+	}
+}
+
+// ValidLogLevel reports whether level can be used as a minimum log level
+func ValidLogLevel(level string) bool {
+	return level == "" || logLevelRank(level) >= 0
+}
+
+// ShowLogEntry reports whether entry is at or above minLevel. Entries with an
+// unknown level are always shown.
+func ShowLogEntry(entry *LogEntry, minLevel string) bool {
+	rank := logLevelRank(entry.Level)
+	return minLevel == "" || rank < 0 || rank >= logLevelRank(minLevel)
+}
+
 // FormatStatusUpdate formats an application status update for display
 func FormatStatusUpdate(status *ApplicationStatus) string {
 	statusIcon := getStatusIcon(status.Status)
@@ -318,6 +351,10 @@ func FormatStatusUpdate(status *ApplicationStatus) string {
 		parts = append(parts, fmt.Sprintf("Health: %s %s", healthIcon, status.HealthCheck))
 	}
 
+	if status.EventErrors > 0 {
+		parts = append(parts, fmt.Sprintf("Event errors: %d", status.EventErrors))
+	}
+
 	if status.Error != "" {
 		parts = append(parts, fmt.Sprintf("Error: %s", status.Error))
 	}
@@ -369,7 +406,9 @@ func (m *Monitor) DisplayLogs(ctx context.Context) {
 		case <-m.stopChan:
 			return
 		case logEntry := <-m.logChan:
-			fmt.Printf("\r%s\n", FormatLogEntry(logEntry))
+			if ShowLogEntry(logEntry, m.LogLevel) {
+				fmt.Printf("\r%s\n", FormatLogEntry(logEntry))
+			}
 		case status := <-m.statusChan:
 			fmt.Printf("\r%s\n", FormatStatusUpdate(status))
 		}
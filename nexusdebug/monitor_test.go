@@ -184,6 +184,15 @@ func TestFormatStatusUpdate(t *testing.T) {
 			},
 			contains: []string{"💥 Status: failed", "Error: Connection refused"},
 		},
+		{
+			name: "Status with event errors",
+			status: &ApplicationStatus{
+				Status:      "running",
+				EventErrors: 3,
+				LastUpdated: "2024-01-01T12:00:00Z",
+			},
+			contains: []string{"Event errors: 3", "12:00:00"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -330,3 +339,34 @@ func BenchmarkFormatStatusUpdate(b *testing.B) {
 		FormatStatusUpdate(status)
 	}
 }
+
+// TestShowLogEntry tests filtering log entries by minimum level
+func TestShowLogEntry(t *testing.T) {
+	tests := []struct {
+		level    string
+		minLevel string
+		expected bool
+	}{
+		{"debug", "", true},
+		{"debug", "info", false},
+		{"info", "info", true},
+		{"WARN", "info", true},
+		{"warning", "warn", true},
+		{"info", "warn", false},
+		{"error", "warn", true},
+		{"warn", "error", false},
+		{"custom", "error", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level+"/"+tt.minLevel, func(t *testing.T) {
+			if result := ShowLogEntry(&LogEntry{Level: tt.level}, tt.minLevel); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	if ValidLogLevel("verbose") || !ValidLogLevel("") || !ValidLogLevel("Warn") {
+		t.Error("Expected only empty and known levels to be valid")
+	}
+}
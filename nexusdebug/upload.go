@@ -10,6 +10,7 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -18,8 +19,6 @@ import (
 	"strconv"
 	"sync"
 	"time"
-
-	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
 )
 
 const (
@@ -39,6 +38,7 @@ const (
 // UploadProgress represents the current upload progress
 type UploadProgress struct {
 	BytesUploaded  int64
+	BytesSkipped   int64 // Bytes of chunks the hub already had
 	TotalBytes     int64
 	ChunksTotal    int
 	ChunksUploaded int
@@ -52,7 +52,7 @@ type UploadProgress struct {
 
 // UploadManager handles chunked file upload operations
 type UploadManager struct {
-	client       *yesterdaygo.Client
+	client       APIClient
 	chunkSize    int64
 	maxRetries   int
 	progressChan chan *UploadProgress
@@ -64,7 +64,7 @@ type UploadManager struct {
 type UploadProgressCallback func(progress *UploadProgress)
 
 // NewUploadManager creates a new upload manager
-func NewUploadManager(client *yesterdaygo.Client) *UploadManager {
+func NewUploadManager(client APIClient) *UploadManager {
 	return &UploadManager{
 		client:       client,
 		chunkSize:    DefaultChunkSize,
@@ -138,6 +138,22 @@ func (um *UploadManager) UploadPackage(ctx context.Context, packagePath string,
 	uploadCtx, cancel := context.WithTimeout(ctx, TotalUploadTimeout)
 	defer cancel()
 
+	// Upload only the chunks the hub doesn't have yet, if it supports it
+	err = um.uploadDeduplicated(uploadCtx, app.ID, packagePath, progress, progressCallback)
+	if err == nil {
+		um.reportProgress(progress, progressCallback)
+		log.Printf("✅ Package upload completed successfully!")
+		log.Printf("Uploaded %.2f MB, skipped %.2f MB the hub already had",
+			float64(progress.BytesUploaded)/1024/1024, float64(progress.BytesSkipped)/1024/1024)
+		return nil
+	}
+	if !errors.Is(err, errDedupUnsupported) {
+		return err
+	}
+	log.Printf("Hub does not support deduplicated uploads, uploading the whole package")
+	progress.ChunksTotal = totalChunks
+	progress.StartTime = time.Now()
+
 	// Open the package file
 	file, err := os.Open(packagePath)
 	if err != nil {
@@ -231,11 +247,19 @@ func (um *UploadManager) UploadPackage(ctx context.Context, packagePath string,
 
 // uploadChunkWithRetry uploads a single chunk with retry logic
 func (um *UploadManager) uploadChunkWithRetry(ctx context.Context, appID string, chunkIndex, totalChunks int, chunkData []byte, fileHash string) error {
+	return um.retry(ctx, fmt.Sprintf("chunk %d", chunkIndex+1), func(ctx context.Context) error {
+		return um.uploadChunk(ctx, appID, chunkIndex, totalChunks, chunkData, fileHash)
+	})
+}
+
+// retry calls upload with a per-chunk timeout until it succeeds or has
+// failed maxRetries times
+func (um *UploadManager) retry(ctx context.Context, name string, upload func(ctx context.Context) error) error {
 	var lastErr error
 
 	for attempt := 0; attempt < um.maxRetries; attempt++ {
 		if attempt > 0 {
-			log.Printf("Retrying chunk %d (attempt %d/%d)...", chunkIndex+1, attempt+1, um.maxRetries)
+			log.Printf("Retrying %s (attempt %d/%d)...", name, attempt+1, um.maxRetries)
 			// Exponential backoff
 			backoff := time.Duration(attempt) * time.Second
 			select {
@@ -247,7 +271,7 @@ func (um *UploadManager) uploadChunkWithRetry(ctx context.Context, appID string,
 
 		// Create chunk upload context with timeout
 		chunkCtx, cancel := context.WithTimeout(ctx, ChunkUploadTimeout)
-		err := um.uploadChunk(chunkCtx, appID, chunkIndex, totalChunks, chunkData, fileHash)
+		err := upload(chunkCtx)
 		cancel()
 
 		if err == nil {
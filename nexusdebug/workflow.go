@@ -0,0 +1,364 @@
+// Package nexusdebug implements the debug workflow for the NexusDebug CLI tool.
+//
+// A Workflow authenticates, creates a debug application, builds and deploys
+// the package, then monitors the running application until it is cancelled.
+// Each stage is backed by an interface so tests can substitute the yesterdaygo
+// MockClient or fake managers, and failures are reported as a StageError
+// rather than exiting, leaving exit codes to the CLI.
+//
+// Reference: spec/nexusdebug.md - Task nexusdebug-workflow
+package nexusdebug
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stage timeouts, matching what the CLI has always used
+const (
+	AuthenticateTimeout = 30 * time.Second
+	CreateAppTimeout    = 30 * time.Second
+	BuildTimeout        = 5 * time.Minute
+	UploadTimeout       = 10 * time.Minute
+	ReadyTimeout        = 2 * time.Minute
+	CleanupTimeout      = 30 * time.Second
+)
+
+// APIClient is the part of the yesterdaygo client used to manage debug
+// applications. Both *yesterdaygo.Client and *yesterdaygo.MockClient
+// implement it.
+type APIClient interface {
+	Get(ctx context.Context, path string, headers map[string]string) (*http.Response, error)
+	Post(ctx context.Context, path string, body interface{}, headers map[string]string) (*http.Response, error)
+	Delete(ctx context.Context, path string, headers map[string]string) (*http.Response, error)
+	PostMultipart(ctx context.Context, path string, fields map[string]string, files map[string][]byte, headers map[string]string) (*http.Response, error)
+	Login(ctx context.Context, username, password string) error
+	Logout(ctx context.Context) error
+	RefreshAccessToken(ctx context.Context) error
+	IsAuthenticated() bool
+}
+
+// Authenticator logs in to and out of the admin service
+type Authenticator interface {
+	Login(ctx context.Context) error
+	Logout(ctx context.Context) error
+}
+
+// ApplicationLifecycle creates, starts, stops and removes the debug
+// application
+type ApplicationLifecycle interface {
+	CreateApplication(ctx context.Context) (*DebugApplication, error)
+	InstallApplication(ctx context.Context) error
+	StopApplication(ctx context.Context) error
+	Cleanup(ctx context.Context) error
+}
+
+// Builder builds the application package
+type Builder interface {
+	BuildApplication(ctx context.Context) error
+	GetPackagePath() string
+}
+
+// Uploader uploads a package to the debug application
+type Uploader interface {
+	SetApplication(app *DebugApplication)
+	UploadPackage(ctx context.Context, packagePath string, progressCallback UploadProgressCallback) error
+}
+
+// StatusMonitor streams the logs and status of the running application
+type StatusMonitor interface {
+	StartMonitoring(ctx context.Context) error
+	DisplayLogs(ctx context.Context)
+	StopMonitoring()
+}
+
+var (
+	_ Authenticator        = (*AuthManager)(nil)
+	_ ApplicationLifecycle = (*ApplicationManager)(nil)
+	_ Builder              = (*BuildManager)(nil)
+	_ Uploader             = (*UploadManager)(nil)
+	_ StatusMonitor        = (*Monitor)(nil)
+)
+
+// Stage identifies a step of the workflow
+type Stage string
+
+const (
+	StageAuthenticate Stage = "authenticate"
+	StageCreateApp    Stage = "create-app"
+	StageBuild        Stage = "build"
+	StageDeploy       Stage = "deploy"
+	StageMonitor      Stage = "monitor"
+)
+
+// StageError reports which stage of the workflow failed
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Config holds the workflow configuration parameters
+type Config struct {
+	AdminURL         string // Required: Target NexusHub admin service URL
+	AppName          string // Required: Used to generate AppID, DisplayName, and HostName
+	BuildCommand     string // Optional: Defaults to "make build"
+	PackageFilename  string // Optional: Defaults to "dist/package.zip"
+	StaticServiceURL string // Optional: For proxying frontend requests during development
+	LogLevel         string // Optional: Lowest level of log entries to display, e.g. "warn"
+}
+
+// Validate checks the configuration and returns an error if it is invalid
+func (config *Config) Validate() error {
+	if config.AdminURL == "" {
+		return fmt.Errorf("admin URL is required")
+	}
+
+	if config.AppName == "" {
+		return fmt.Errorf("application name is required")
+	}
+
+	if !ValidLogLevel(config.LogLevel) {
+		return fmt.Errorf("invalid log level %q: use debug, info, warn or error", config.LogLevel)
+	}
+
+	// Validate that package filename directory exists or can be created
+	packageDir := filepath.Dir(config.PackageFilename)
+	if packageDir != "." {
+		if _, err := os.Stat(packageDir); os.IsNotExist(err) {
+			log.Printf("Warning: Package directory %s does not exist", packageDir)
+		}
+	}
+
+	return nil
+}
+
+// Workflow runs the debug workflow. The stage implementations are exported
+// so they can be replaced before calling Run.
+type Workflow struct {
+	Auth     Authenticator
+	Apps     ApplicationLifecycle
+	Builder  Builder
+	Uploader Uploader
+	// StatusMonitor defaults to a Monitor for the created application
+	StatusMonitor StatusMonitor
+	// Interactive enables the R/Q keyboard controls while monitoring
+	Interactive bool
+	// LogLevel is the lowest level of log entries the default monitor shows
+	LogLevel string
+	// Output receives progress messages for the user
+	Output io.Writer
+
+	client APIClient
+	app    *DebugApplication
+}
+
+// NewWorkflow creates a workflow using the real managers for the given
+// configuration
+func NewWorkflow(config Config) *Workflow {
+	authManager := NewAuthManager(config.AdminURL)
+	return &Workflow{
+		Auth:     authManager,
+		Apps:     NewApplicationManager(authManager.Client, config.AppName, config.StaticServiceURL),
+		Builder:  NewBuildManager(config.BuildCommand, config.PackageFilename),
+		Uploader: NewUploadManager(authManager.Client),
+		Output:   os.Stdout,
+		LogLevel: config.LogLevel,
+		client:   authManager.Client,
+	}
+}
+
+// NewCloneWorkflow creates a workflow that deploys to and monitors a clone
+// of the installed instance from, kept by the hub for ttl ("" for the hub's
+// default) unless the workflow removes it first
+func NewCloneWorkflow(config Config, from, ttl string) *Workflow {
+	w := NewWorkflow(config)
+	w.Apps = NewCloneManager(w.client, from, ttl)
+	return w
+}
+
+// Application returns the debug application, once it has been created
+func (w *Workflow) Application() *DebugApplication {
+	return w.app
+}
+
+func (w *Workflow) printf(format string, args ...interface{}) {
+	if w.Output != nil {
+		fmt.Fprintf(w.Output, format, args...)
+	}
+}
+
+// Authenticate logs in to the admin service
+func (w *Workflow) Authenticate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, AuthenticateTimeout)
+	defer cancel()
+	if err := w.Auth.Login(ctx); err != nil {
+		return &StageError{Stage: StageAuthenticate, Err: err}
+	}
+	return nil
+}
+
+// CreateApp creates the debug application
+func (w *Workflow) CreateApp(ctx context.Context) (*DebugApplication, error) {
+	ctx, cancel := context.WithTimeout(ctx, CreateAppTimeout)
+	defer cancel()
+	app, err := w.Apps.CreateApplication(ctx)
+	if err != nil {
+		return nil, &StageError{Stage: StageCreateApp, Err: err}
+	}
+	w.app = app
+	w.Uploader.SetApplication(app)
+
+	w.printf("\nDebug application created successfully!\n")
+	w.printf("Application ID: %s\n", app.ID)
+	w.printf("Display Name: %s\n", app.DisplayName)
+	w.printf("Host Name: %s\n", app.HostName)
+	if app.StaticServiceURL != "" {
+		w.printf("Static Service URL: %s\n", app.StaticServiceURL)
+	}
+	return app, nil
+}
+
+// Build builds the application package
+func (w *Workflow) Build(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, BuildTimeout)
+	defer cancel()
+	if err := w.Builder.BuildApplication(ctx); err != nil {
+		return &StageError{Stage: StageBuild, Err: err}
+	}
+
+	packagePath := w.Builder.GetPackagePath()
+	if info, err := os.Stat(packagePath); err == nil {
+		w.printf("📦 Package built successfully: %s (%.2f MB)\n", packagePath, float64(info.Size())/1024/1024)
+	}
+	return nil
+}
+
+// Deploy uploads the built package, installs it and waits for the
+// application to become ready
+func (w *Workflow) Deploy(ctx context.Context) error {
+	if w.app == nil {
+		return &StageError{Stage: StageDeploy, Err: fmt.Errorf("no debug application to deploy to")}
+	}
+
+	uploadCtx, cancel := context.WithTimeout(ctx, UploadTimeout)
+	defer cancel()
+	if err := w.Uploader.UploadPackage(uploadCtx, w.Builder.GetPackagePath(), PrintUploadProgress); err != nil {
+		return &StageError{Stage: StageDeploy, Err: fmt.Errorf("package upload failed: %w", err)}
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, ReadyTimeout)
+	defer cancel()
+	if err := w.Apps.InstallApplication(readyCtx); err != nil {
+		return &StageError{Stage: StageDeploy, Err: err}
+	}
+
+	w.printf("\n✅ Debug application is now running!\n")
+	if w.app.HostName != "" {
+		w.printf("🌐 Access your application at: https://%s\n", w.app.HostName)
+	} else {
+		w.printf("🌐 Access your application at: /%s/\n", w.app.ID)
+	}
+	return nil
+}
+
+// Rebuild stops the application, then builds and deploys it again
+func (w *Workflow) Rebuild(ctx context.Context) error {
+	if err := w.Apps.StopApplication(ctx); err != nil {
+		log.Printf("Warning: Failed to stop application: %v", err)
+	}
+	if err := w.Build(ctx); err != nil {
+		return err
+	}
+	return w.Deploy(ctx)
+}
+
+// Monitor displays the application's logs and status until ctx is done or,
+// in interactive mode, the user quits
+func (w *Workflow) Monitor(ctx context.Context) error {
+	if w.app == nil {
+		return &StageError{Stage: StageMonitor, Err: fmt.Errorf("no debug application to monitor")}
+	}
+	monitor := w.StatusMonitor
+	if monitor == nil {
+		defaultMonitor := NewMonitor(w.client, w.app)
+		defaultMonitor.LogLevel = w.LogLevel
+		monitor = defaultMonitor
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := monitor.StartMonitoring(ctx); err != nil {
+		return &StageError{Stage: StageMonitor, Err: err}
+	}
+	defer monitor.StopMonitoring()
+
+	w.printf("\n📊 Monitoring started - displaying logs and status updates:\n")
+	w.printf("───────────────────────────────────────────────────────────────\n")
+	go monitor.DisplayLogs(ctx)
+
+	if w.Interactive {
+		control := NewControl(ControlCallbacks{
+			OnRebuild: w.Rebuild,
+			OnShutdown: func(context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+		if err := control.StartInteractiveMode(ctx); err != nil {
+			log.Printf("Warning: failed to start interactive mode: %v", err)
+		}
+		defer control.StopInteractiveMode()
+	}
+
+	<-ctx.Done()
+	w.printf("\nShutting down...\n")
+	return nil
+}
+
+// Run executes the whole workflow, returning when ctx is cancelled or a stage
+// fails. The debug application is removed and the session closed before it
+// returns.
+func (w *Workflow) Run(ctx context.Context) error {
+	if err := w.Authenticate(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err := w.Auth.Logout(context.Background()); err != nil {
+			log.Printf("Warning: logout failed: %v", err)
+		}
+	}()
+
+	if _, err := w.CreateApp(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		log.Printf("Cleaning up debug application...")
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), CleanupTimeout)
+		defer cancel()
+		if err := w.Apps.Cleanup(cleanupCtx); err != nil {
+			log.Printf("Cleanup error: %v", err)
+		}
+	}()
+
+	if err := w.Build(ctx); err != nil {
+		return err
+	}
+	if err := w.Deploy(ctx); err != nil {
+		return err
+	}
+	return w.Monitor(ctx)
+}
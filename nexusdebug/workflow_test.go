@@ -0,0 +1,238 @@
+package nexusdebug
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	yesterdaygo "github.com/tomyedwab/yesterday/clients/go"
+)
+
+const (
+	testAppPath    = "/debug/application/app-1"
+	testStatusPath = testAppPath + "/status"
+)
+
+// mockAuthenticator logs in through the MockClient instead of prompting
+type mockAuthenticator struct {
+	client *yesterdaygo.MockClient
+}
+
+func (a mockAuthenticator) Login(ctx context.Context) error {
+	return a.client.Login(ctx, "developer", "password")
+}
+
+func (a mockAuthenticator) Logout(ctx context.Context) error {
+	return a.client.Logout(ctx)
+}
+
+// fakeBuilder writes a small package instead of running a build command
+type fakeBuilder struct {
+	path   string
+	err    error
+	builds int
+}
+
+func (b *fakeBuilder) BuildApplication(ctx context.Context) error {
+	b.builds++
+	if b.err != nil {
+		return b.err
+	}
+	return os.WriteFile(b.path, []byte("package contents"), 0644)
+}
+
+func (b *fakeBuilder) GetPackagePath() string {
+	return b.path
+}
+
+// fakeMonitor calls onStart instead of streaming logs
+type fakeMonitor struct {
+	err     error
+	onStart func()
+	stopped bool
+}
+
+func (m *fakeMonitor) StartMonitoring(ctx context.Context) error {
+	if m.onStart != nil {
+		m.onStart()
+	}
+	return m.err
+}
+
+func (m *fakeMonitor) DisplayLogs(ctx context.Context) {}
+
+func (m *fakeMonitor) StopMonitoring() {
+	m.stopped = true
+}
+
+func setStatus(client *yesterdaygo.MockClient, status string) {
+	client.SetMockResponse(testStatusPath, http.StatusOK, ApplicationStatus{
+		ApplicationID: "app-1",
+		Status:        status,
+		HealthCheck:   "healthy",
+	})
+}
+
+// newTestWorkflow returns a workflow whose stages all succeed against the
+// MockClient. Once monitoring starts the application reports itself stopped
+// so that cleanup does not wait.
+func newTestWorkflow(t *testing.T) (*Workflow, *yesterdaygo.MockClient, *fakeBuilder, *fakeMonitor) {
+	client := yesterdaygo.NewMockClient()
+	client.SetMockResponse("/debug/application", http.StatusCreated, DebugApplication{
+		ID:       "app-1",
+		AppID:    "debug-test",
+		HostName: "test.debug",
+	})
+	setStatus(client, "running")
+
+	apps := NewApplicationManager(client, "test", "")
+	apps.readyPollInterval = 10 * time.Millisecond
+	apps.stopPollInterval = 10 * time.Millisecond
+	uploader := NewUploadManager(client)
+	uploader.SetMaxRetries(1)
+	builder := &fakeBuilder{path: filepath.Join(t.TempDir(), "package.zip")}
+	monitor := &fakeMonitor{onStart: func() { setStatus(client, "stopped") }}
+
+	return &Workflow{
+		Auth:          mockAuthenticator{client},
+		Apps:          apps,
+		Builder:       builder,
+		Uploader:      uploader,
+		StatusMonitor: monitor,
+		Output:        io.Discard,
+	}, client, builder, monitor
+}
+
+func TestWorkflowRun(t *testing.T) {
+	workflow, client, builder, monitor := newTestWorkflow(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.onStart = func() {
+		setStatus(client, "stopped")
+		cancel()
+	}
+
+	if err := workflow.Run(ctx); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if builder.builds != 1 {
+		t.Errorf("Expected one build, got %d", builder.builds)
+	}
+	if !monitor.stopped {
+		t.Error("Expected monitoring to be stopped")
+	}
+	yesterdaygo.AssertAuthenticationCalled(t, client)
+	yesterdaygo.AssertRequestMade(t, client, "POST", testAppPath+"/upload")
+	yesterdaygo.AssertRequestMade(t, client, "POST", testAppPath+"/install-dev")
+	yesterdaygo.AssertRequestMade(t, client, "DELETE", testAppPath)
+	yesterdaygo.AssertRequestMade(t, client, "POST", "/public/logout")
+}
+
+func TestWorkflowStageFailures(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	tests := []struct {
+		name  string
+		setup func(client *yesterdaygo.MockClient, builder *fakeBuilder, monitor *fakeMonitor)
+		stage Stage
+		// Whether the debug application was created and must be cleaned up
+		created bool
+	}{
+		{
+			name: "authentication",
+			setup: func(client *yesterdaygo.MockClient, _ *fakeBuilder, _ *fakeMonitor) {
+				client.SetMockError("/public/login", errInjected)
+			},
+			stage: StageAuthenticate,
+		},
+		{
+			name: "application creation",
+			setup: func(client *yesterdaygo.MockClient, _ *fakeBuilder, _ *fakeMonitor) {
+				client.SetMockError("/debug/application", errInjected)
+			},
+			stage: StageCreateApp,
+		},
+		{
+			name: "build",
+			setup: func(client *yesterdaygo.MockClient, builder *fakeBuilder, _ *fakeMonitor) {
+				setStatus(client, "stopped")
+				builder.err = errInjected
+			},
+			stage:   StageBuild,
+			created: true,
+		},
+		{
+			name: "upload",
+			setup: func(client *yesterdaygo.MockClient, _ *fakeBuilder, _ *fakeMonitor) {
+				setStatus(client, "stopped")
+				client.SetMockError(testAppPath+"/upload", errInjected)
+			},
+			stage:   StageDeploy,
+			created: true,
+		},
+		{
+			name: "installation",
+			setup: func(client *yesterdaygo.MockClient, _ *fakeBuilder, _ *fakeMonitor) {
+				setStatus(client, "stopped")
+				client.SetMockError(testAppPath+"/install-dev", errInjected)
+			},
+			stage:   StageDeploy,
+			created: true,
+		},
+		{
+			name: "monitoring",
+			setup: func(_ *yesterdaygo.MockClient, _ *fakeBuilder, monitor *fakeMonitor) {
+				monitor.err = errInjected
+			},
+			stage:   StageMonitor,
+			created: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workflow, client, builder, monitor := newTestWorkflow(t)
+			tt.setup(client, builder, monitor)
+
+			err := workflow.Run(context.Background())
+			var stageErr *StageError
+			if !errors.As(err, &stageErr) || stageErr.Stage != tt.stage {
+				t.Fatalf("Expected a %s StageError, got %v", tt.stage, err)
+			}
+			if !errors.Is(err, errInjected) {
+				t.Errorf("Expected the error to wrap the injected failure, got %v", err)
+			}
+
+			if tt.created {
+				yesterdaygo.AssertRequestMade(t, client, "DELETE", testAppPath)
+			}
+			for _, req := range client.GetRequestHistory() {
+				if !tt.created && req.Method == "DELETE" {
+					t.Errorf("Expected no cleanup of an application that was not created, got %+v", req)
+				}
+				if tt.stage == StageAuthenticate && req.Path != "/public/login" {
+					t.Errorf("Expected no requests after a failed login, got %+v", req)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkflowStartupFailure(t *testing.T) {
+	workflow, client, _, _ := newTestWorkflow(t)
+	client.SetMockResponse(testStatusPath, http.StatusOK, ApplicationStatus{
+		Status: "stopped",
+		Error:  "exited with status 1",
+	})
+
+	err := workflow.Run(context.Background())
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != StageDeploy {
+		t.Fatalf("Expected a deploy StageError for an application that fails to start, got %v", err)
+	}
+}
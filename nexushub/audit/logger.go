@@ -20,9 +20,18 @@ type EventType string
 const (
 	EventLogin                EventType = "login"
 	EventLogout               EventType = "logout"
+	EventLogoutAll            EventType = "logout_all"
 	EventAccessTokenRefresh   EventType = "access_token_refresh"
 	EventAccessTokenExpiry    EventType = "access_token_expiry"
 	EventInvalidRefreshToken  EventType = "invalid_refresh_token"
+	EventInternalSecretRotate EventType = "internal_secret_rotate"
+	EventPackageDownload      EventType = "package_download"
+	EventDatabaseDownload     EventType = "database_download"
+	EventUserDataExport       EventType = "user_data_export"
+	EventUserDataForget       EventType = "user_data_forget"
+	EventIntrospectionAlert   EventType = "introspection_alert"
+	EventPublishDenied        EventType = "event_publish_denied"
+	EventPublishInternal      EventType = "event_publish_internal"
 )
 
 // AuditEvent represents an audit log entry in the database
@@ -39,7 +48,8 @@ type AuditEvent struct {
 
 // Logger handles audit logging for authentication and authorization events
 type Logger struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	forwarder *Forwarder
 }
 
 // NewLogger creates a new audit logger instance
@@ -85,6 +95,12 @@ func DBInit(db *sqlx.DB) error {
 	return err
 }
 
+// SetForwarder forwards every subsequently logged event that passes the
+// forwarder's event-type filter to its webhook.
+func (l *Logger) SetForwarder(forwarder *Forwarder) {
+	l.forwarder = forwarder
+}
+
 // tokenFingerprint creates a SHA-256 hash of a token for audit logging
 // This allows us to track token usage without storing the actual token value
 func tokenFingerprint(token string) string {
@@ -95,9 +111,33 @@ func tokenFingerprint(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// insertEvent is a helper method to insert an audit event into the database
+// insertEvent is a helper method to insert an audit event into the database.
+// If a forwarder wants the event, it is queued in the same transaction.
 func (l *Logger) insertEvent(event *AuditEvent) error {
-	_, err := l.db.Exec(`
+	if l.forwarder == nil || !l.forwarder.wants(event.EventType) {
+		return insertEventTx(l.db, event)
+	}
+
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertEventTx(tx, event); err != nil {
+		return err
+	}
+	if err := l.forwarder.enqueue(tx, event); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	l.forwarder.notify()
+	return nil
+}
+
+func insertEventTx(db sqlx.Execer, event *AuditEvent) error {
+	_, err := db.Exec(`
 		INSERT INTO audit_events (
 			id, event_type, timestamp, user_id,
 			refresh_token_fingerprint, old_refresh_token_fingerprint,
@@ -139,6 +179,19 @@ func (l *Logger) LogLogout(userID int, refreshToken string) error {
 	return l.insertEvent(event)
 }
 
+// LogLogoutAll logs a user ending all of their sessions. The refresh token
+// is the one the request was made with.
+func (l *Logger) LogLogoutAll(userID int, refreshToken string) error {
+	event := &AuditEvent{
+		ID:                      uuid.New().String(),
+		EventType:               string(EventLogoutAll),
+		Timestamp:               time.Now().UTC().Unix(),
+		UserID:                  &userID,
+		RefreshTokenFingerprint: tokenFingerprint(refreshToken),
+	}
+	return l.insertEvent(event)
+}
+
 // LogAccessTokenRefresh logs an access token refresh event
 func (l *Logger) LogAccessTokenRefresh(userID int, oldRefreshToken string, newRefreshToken string, accessToken string) error {
 	event := &AuditEvent{
@@ -175,7 +228,79 @@ func (l *Logger) LogInvalidRefreshToken(refreshToken string) error {
 	return l.insertEvent(event)
 }
 
-// GetEventsByUserID retrieves audit events for a specific user
+// LogInternalSecretRotation logs a rotation of the hub's internal secret. The
+// reason (e.g. "scheduled" or "manual") is stored in place of a fingerprint
+// since there is no user or token associated with the event.
+func (l *Logger) LogInternalSecretRotation(reason string) error {
+	event := &AuditEvent{
+		ID:                     uuid.New().String(),
+		EventType:              string(EventInternalSecretRotate),
+		Timestamp:              time.Now().UTC().Unix(),
+		AccessTokenFingerprint: reason,
+	}
+	return l.insertEvent(event)
+}
+
+// LogDownload logs a download of an instance's package or database. The
+// instance ID is stored in place of a fingerprint. userID is nil when the
+// download was made with the internal secret rather than a user's token.
+func (l *Logger) LogDownload(eventType EventType, userID *int, instanceID string) error {
+	event := &AuditEvent{
+		ID:                     uuid.New().String(),
+		EventType:              string(eventType),
+		Timestamp:              time.Now().UTC().Unix(),
+		UserID:                 userID,
+		AccessTokenFingerprint: instanceID,
+	}
+	return l.insertEvent(event)
+}
+
+// LogUserData logs an export or deletion of a user's data. The user the data
+// is about is recorded as the event's user, and who asked for it (e.g.
+// "user:1" or "internal") in place of a fingerprint.
+func (l *Logger) LogUserData(eventType EventType, userID int, actor string) error {
+	event := &AuditEvent{
+		ID:                     uuid.New().String(),
+		EventType:              string(eventType),
+		Timestamp:              time.Now().UTC().Unix(),
+		UserID:                 &userID,
+		AccessTokenFingerprint: actor,
+	}
+	return l.insertEvent(event)
+}
+
+// LogInactiveIntrospections logs an unusual number of token introspections
+// finding inactive tokens, which may be a caller guessing or replaying
+// tokens. A summary (e.g. "120 in 1m0s") is stored in place of a
+// fingerprint.
+func (l *Logger) LogInactiveIntrospections(summary string) error {
+	event := &AuditEvent{
+		ID:                     uuid.New().String(),
+		EventType:              string(EventIntrospectionAlert),
+		Timestamp:              time.Now().UTC().Unix(),
+		AccessTokenFingerprint: summary,
+	}
+	return l.insertEvent(event)
+}
+
+// LogEventPublish logs a publish of an event type restricted to some
+// publishers: one refused (EventPublishDenied) or one made with the internal
+// secret, which is always allowed (EventPublishInternal). The published event
+// type is stored in place of a fingerprint. userID is nil for internal
+// publishes.
+func (l *Logger) LogEventPublish(eventType EventType, userID *int, publishedType string) error {
+	event := &AuditEvent{
+		ID:                     uuid.New().String(),
+		EventType:              string(eventType),
+		Timestamp:              time.Now().UTC().Unix(),
+		UserID:                 userID,
+		AccessTokenFingerprint: publishedType,
+	}
+	return l.insertEvent(event)
+}
+
+// GetEventsByUserID retrieves audit events for a specific user. A negative
+// limit retrieves all of them.
 func (l *Logger) GetEventsByUserID(userID int, limit int) ([]AuditEvent, error) {
 	var events []AuditEvent
 	err := l.db.Select(&events,
@@ -430,6 +430,8 @@ func TestEventTypes(t *testing.T) {
 		{"AccessTokenRefresh", EventAccessTokenRefresh, "access_token_refresh"},
 		{"AccessTokenExpiry", EventAccessTokenExpiry, "access_token_expiry"},
 		{"InvalidRefreshToken", EventInvalidRefreshToken, "invalid_refresh_token"},
+		{"PackageDownload", EventPackageDownload, "package_download"},
+		{"DatabaseDownload", EventDatabaseDownload, "database_download"},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,462 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of each webhook batch body,
+	// formatted as "sha256=<hex>", so the receiver can verify its integrity.
+	SignatureHeader = "X-Audit-Signature"
+
+	DefaultWebhookBatchSize     = 50
+	DefaultWebhookFlushInterval = 5 * time.Second
+	DefaultWebhookMaxAttempts   = 10
+	DefaultWebhookRetryBackoff  = 1 * time.Second
+	maxWebhookRetryBackoff      = 5 * time.Minute
+	webhookRequestTimeout       = 30 * time.Second
+)
+
+// WebhookConfig configures forwarding of audit events to an external webhook
+// such as a SIEM collector.
+type WebhookConfig struct {
+	URL           string
+	Secret        string        // Shared secret used to sign each batch
+	EventTypes    []EventType   // Optional, forwards all event types if empty
+	BatchSize     int           // Optional, defaults to DefaultWebhookBatchSize
+	FlushInterval time.Duration // Optional, defaults to DefaultWebhookFlushInterval
+	MaxAttempts   int           // Optional, defaults to DefaultWebhookMaxAttempts
+	RetryBackoff  time.Duration // Optional, defaults to DefaultWebhookRetryBackoff
+	HTTPClient    *http.Client  // Optional, defaults to a client with a 30s timeout
+	Logger        *slog.Logger  // Optional, defaults to slog.Default()
+	// Paused is optional; while it reports true, Run leaves events queued in
+	// the outbox, e.g. to stop updating the outbox when the disk is low.
+	Paused func() bool
+}
+
+// WebhookConfigFromEnv reads the webhook configuration from the environment:
+//
+//	AUDIT_WEBHOOK_URL            receiver URL; forwarding is disabled if unset
+//	AUDIT_WEBHOOK_SECRET         shared HMAC secret (required with a URL)
+//	AUDIT_WEBHOOK_EVENTS         comma-separated event types to forward
+//	AUDIT_WEBHOOK_BATCH_SIZE     maximum events per request
+//	AUDIT_WEBHOOK_FLUSH_INTERVAL e.g. "5s"
+//	AUDIT_WEBHOOK_MAX_ATTEMPTS   deliveries before an event is dead-lettered
+//
+// It returns nil if no webhook URL is configured.
+func WebhookConfigFromEnv() (*WebhookConfig, error) {
+	url := os.Getenv("AUDIT_WEBHOOK_URL")
+	if url == "" {
+		return nil, nil
+	}
+	config := &WebhookConfig{
+		URL:    url,
+		Secret: os.Getenv("AUDIT_WEBHOOK_SECRET"),
+	}
+	if config.Secret == "" {
+		return nil, fmt.Errorf("AUDIT_WEBHOOK_SECRET is required when AUDIT_WEBHOOK_URL is set")
+	}
+	if events := os.Getenv("AUDIT_WEBHOOK_EVENTS"); events != "" {
+		for _, eventType := range strings.Split(events, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				config.EventTypes = append(config.EventTypes, EventType(eventType))
+			}
+		}
+	}
+	var err error
+	if config.BatchSize, err = envInt("AUDIT_WEBHOOK_BATCH_SIZE"); err != nil {
+		return nil, err
+	}
+	if config.MaxAttempts, err = envInt("AUDIT_WEBHOOK_MAX_ATTEMPTS"); err != nil {
+		return nil, err
+	}
+	if value := os.Getenv("AUDIT_WEBHOOK_FLUSH_INTERVAL"); value != "" {
+		if config.FlushInterval, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid AUDIT_WEBHOOK_FLUSH_INTERVAL: %w", err)
+		}
+	}
+	return config, nil
+}
+
+func envInt(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// WebhookEvent is the JSON representation of an audit event sent to the
+// webhook.
+type WebhookEvent struct {
+	ID                         string `json:"id"`
+	EventType                  string `json:"eventType"`
+	Timestamp                  int64  `json:"timestamp"`
+	UserID                     *int   `json:"userId,omitempty"`
+	RefreshTokenFingerprint    string `json:"refreshTokenFingerprint,omitempty"`
+	OldRefreshTokenFingerprint string `json:"oldRefreshTokenFingerprint,omitempty"`
+	NewRefreshTokenFingerprint string `json:"newRefreshTokenFingerprint,omitempty"`
+	AccessTokenFingerprint     string `json:"accessTokenFingerprint,omitempty"`
+}
+
+// WebhookBatch is the body of each webhook request.
+type WebhookBatch struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// ForwarderStats is a snapshot of the forwarder's delivery counters.
+type ForwarderStats struct {
+	Sent         int64 // Events delivered successfully
+	Failed       int64 // Failed delivery attempts, counted per event
+	DeadLettered int64 // Events given up on after MaxAttempts
+	QueueDepth   int   // Events waiting in the outbox
+}
+
+type outboxEntry struct {
+	ID       int64  `db:"id"`
+	Payload  string `db:"payload"`
+	Attempts int    `db:"attempts"`
+}
+
+const createOutboxSql = `
+CREATE TABLE IF NOT EXISTS audit_webhook_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT
+);
+CREATE TABLE IF NOT EXISTS audit_webhook_dead_letter (
+	id INTEGER PRIMARY KEY,
+	event_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT,
+	failed_at INTEGER NOT NULL
+);
+`
+
+const enqueueOutboxSql = `
+INSERT INTO audit_webhook_outbox (event_id, payload) VALUES ($1, $2)
+`
+
+const selectDueOutboxSql = `
+SELECT id, payload, attempts FROM audit_webhook_outbox
+WHERE next_attempt <= $1 ORDER BY id LIMIT $2
+`
+
+const retryOutboxSql = `
+UPDATE audit_webhook_outbox SET attempts = $1, next_attempt = $2, last_error = $3 WHERE id = $4
+`
+
+const deadLetterOutboxSql = `
+INSERT INTO audit_webhook_dead_letter (id, event_id, payload, attempts, last_error, failed_at)
+SELECT id, event_id, payload, $1, $2, $3 FROM audit_webhook_outbox WHERE id = $4
+`
+
+// Forwarder delivers audit events to a webhook with at-least-once semantics.
+// Events are written to an outbox table in the audit database alongside the
+// audit event itself, and a background loop started with Run sends them in
+// signed batches, retrying with exponential backoff. Events that still fail
+// after MaxAttempts are moved to a dead-letter table.
+type Forwarder struct {
+	db     *sqlx.DB
+	config WebhookConfig
+	logger *slog.Logger
+	wake   chan struct{}
+
+	mu    sync.Mutex
+	stats ForwarderStats
+}
+
+// NewForwarder creates the outbox tables and returns a forwarder. Attach it
+// to a Logger with SetForwarder and start delivery with Run.
+func NewForwarder(db *sqlx.DB, config WebhookConfig) (*Forwarder, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webhook URL is required")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultWebhookBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultWebhookFlushInterval
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultWebhookRetryBackoff
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: webhookRequestTimeout}
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if _, err := db.Exec(createOutboxSql); err != nil {
+		return nil, fmt.Errorf("failed to create webhook outbox: %w", err)
+	}
+	return &Forwarder{
+		db:     db,
+		config: config,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Sign returns the signature of a batch body as sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// wants reports whether events of the given type pass the event-type filter.
+func (f *Forwarder) wants(eventType string) bool {
+	return len(f.config.EventTypes) == 0 || slices.Contains(f.config.EventTypes, EventType(eventType))
+}
+
+// enqueue adds an event to the outbox within the transaction that records it,
+// so an event is forwarded if and only if it was logged. It does no network
+// I/O, leaving delivery to the background loop.
+func (f *Forwarder) enqueue(tx *sqlx.Tx, event *AuditEvent) error {
+	payload, err := json.Marshal(WebhookEvent{
+		ID:                         event.ID,
+		EventType:                  event.EventType,
+		Timestamp:                  event.Timestamp,
+		UserID:                     event.UserID,
+		RefreshTokenFingerprint:    event.RefreshTokenFingerprint,
+		OldRefreshTokenFingerprint: event.OldRefreshTokenFingerprint,
+		NewRefreshTokenFingerprint: event.NewRefreshTokenFingerprint,
+		AccessTokenFingerprint:     event.AccessTokenFingerprint,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(enqueueOutboxSql, event.ID, string(payload))
+	return err
+}
+
+// notify wakes the delivery loop without blocking the caller.
+func (f *Forwarder) notify() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued events until the context is cancelled. Events are sent
+// every FlushInterval, or sooner when new events are logged. Delivery is
+// skipped while the Paused hook reports true and resumes once it doesn't.
+func (f *Forwarder) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		if f.config.Paused != nil && f.config.Paused() {
+			f.logger.Debug("Audit forwarding paused")
+		} else if err := f.Flush(ctx); err != nil {
+			f.logger.Error("Failed to forward audit events", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-f.wake:
+		}
+	}
+}
+
+// Flush sends every batch that is currently due, stopping at the first
+// failure. Events that fail stay in the outbox for a later attempt. It should
+// not run concurrently with Run, so during shutdown cancel Run first and then
+// Flush once more.
+func (f *Forwarder) Flush(ctx context.Context) error {
+	for {
+		sent, err := f.flush(ctx)
+		if err != nil || sent < f.config.BatchSize {
+			return err
+		}
+	}
+}
+
+// flush sends one batch of due events and returns the number delivered.
+func (f *Forwarder) flush(ctx context.Context) (int, error) {
+	var entries []outboxEntry
+	if err := f.db.Select(&entries, selectDueOutboxSql, time.Now().UnixMilli(), f.config.BatchSize); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	batch := WebhookBatch{Events: make([]json.RawMessage, len(entries))}
+	for i, entry := range entries {
+		batch.Events[i] = json.RawMessage(entry.Payload)
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	if sendErr := f.send(ctx, body); sendErr != nil {
+		return 0, f.recordFailure(entries, sendErr)
+	}
+
+	ids := make([]int64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	query, args, err := sqlx.In("DELETE FROM audit_webhook_outbox WHERE id IN (?)", ids)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.db.Exec(query, args...); err != nil {
+		// The batch will be redelivered, which at-least-once delivery allows
+		return 0, err
+	}
+
+	f.mu.Lock()
+	f.stats.Sent += int64(len(entries))
+	f.mu.Unlock()
+	return len(entries), nil
+}
+
+func (f *Forwarder) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(f.config.Secret, body))
+
+	resp, err := f.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordFailure schedules each event in a failed batch for retry, moving
+// events that have exhausted their attempts to the dead-letter table.
+func (f *Forwarder) recordFailure(entries []outboxEntry, sendErr error) error {
+	tx, err := f.db.Beginx()
+	if err != nil {
+		return errors.Join(sendErr, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	deadLettered := 0
+	for _, entry := range entries {
+		attempts := entry.Attempts + 1
+		if attempts >= f.config.MaxAttempts {
+			if _, err := tx.Exec(deadLetterOutboxSql, attempts, sendErr.Error(), now.Unix(), entry.ID); err != nil {
+				return errors.Join(sendErr, err)
+			}
+			if _, err := tx.Exec("DELETE FROM audit_webhook_outbox WHERE id = $1", entry.ID); err != nil {
+				return errors.Join(sendErr, err)
+			}
+			deadLettered++
+			continue
+		}
+		nextAttempt := now.Add(f.backoff(attempts)).UnixMilli()
+		if _, err := tx.Exec(retryOutboxSql, attempts, nextAttempt, sendErr.Error(), entry.ID); err != nil {
+			return errors.Join(sendErr, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Join(sendErr, err)
+	}
+
+	f.mu.Lock()
+	f.stats.Failed += int64(len(entries))
+	f.stats.DeadLettered += int64(deadLettered)
+	f.mu.Unlock()
+	if deadLettered > 0 {
+		f.logger.Warn("Dead-lettered audit events after repeated delivery failures", "count", deadLettered)
+	}
+	return sendErr
+}
+
+func (f *Forwarder) backoff(attempts int) time.Duration {
+	backoff := f.config.RetryBackoff
+	for i := 1; i < attempts && backoff < maxWebhookRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxWebhookRetryBackoff)
+}
+
+// EnableMetrics exports the forwarder's Stats in registry.
+func (f *Forwarder) EnableMetrics(registry *prometheus.Registry) {
+	counter := func(read func(ForwarderStats) int64) func() float64 {
+		return func() float64 {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return float64(read(f.stats))
+		}
+	}
+	registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "nexushub_audit_webhook_sent_total",
+			Help: "Audit events delivered to the webhook.",
+		}, counter(func(s ForwarderStats) int64 { return s.Sent })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "nexushub_audit_webhook_failed_total",
+			Help: "Failed attempts to deliver audit events to the webhook, counted per event.",
+		}, counter(func(s ForwarderStats) int64 { return s.Failed })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "nexushub_audit_webhook_dead_lettered_total",
+			Help: "Audit events given up on after the maximum delivery attempts.",
+		}, counter(func(s ForwarderStats) int64 { return s.DeadLettered })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "nexushub_audit_webhook_queue_depth",
+			Help: "Audit events waiting in the outbox to be delivered to the webhook.",
+		}, func() float64 {
+			stats, err := f.Stats()
+			if err != nil {
+				f.logger.Warn("Failed to read audit webhook queue depth", "error", err)
+				return math.NaN()
+			}
+			return float64(stats.QueueDepth)
+		}),
+	)
+}
+
+// Stats returns the delivery counters and the current outbox depth.
+func (f *Forwarder) Stats() (ForwarderStats, error) {
+	f.mu.Lock()
+	stats := f.stats
+	f.mu.Unlock()
+	err := f.db.Get(&stats.QueueDepth, "SELECT COUNT(*) FROM audit_webhook_outbox")
+	return stats, err
+}
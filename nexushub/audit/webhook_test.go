@@ -0,0 +1,340 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flakyWebhook fails the first failures requests and records every batch it
+// accepts after verifying its signature.
+type flakyWebhook struct {
+	t        *testing.T
+	secret   string
+	failures int
+
+	mu       sync.Mutex
+	requests int
+	received []WebhookEvent
+}
+
+func (w *flakyWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.t.Errorf("Failed to read webhook body: %v", err)
+		return
+	}
+	if got, want := r.Header.Get(SignatureHeader), Sign(w.secret, body); got != want {
+		w.t.Errorf("Expected signature %s, got %s", want, got)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.requests++
+	if w.requests <= w.failures {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var batch struct {
+		Events []WebhookEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		w.t.Errorf("Failed to decode webhook batch: %v", err)
+	}
+	w.received = append(w.received, batch.Events...)
+}
+
+func (w *flakyWebhook) events() []WebhookEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WebhookEvent(nil), w.received...)
+}
+
+// setupWebhookTestDB opens an audit database that tolerates the forwarder and
+// the logger writing concurrently.
+func setupWebhookTestDB(t *testing.T) *sqlx.DB {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "audit.db")+"?_busy_timeout=5000")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func startForwarder(t *testing.T, db *sqlx.DB, config WebhookConfig) (*Logger, *Forwarder) {
+	logger, err := NewLogger(db)
+	if err != nil {
+		t.Fatalf("NewLogger returned error: %v", err)
+	}
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	forwarder, err := NewForwarder(db, config)
+	if err != nil {
+		t.Fatalf("NewForwarder returned error: %v", err)
+	}
+	logger.SetForwarder(forwarder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		forwarder.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return logger, forwarder
+}
+
+func waitForStats(t *testing.T, forwarder *Forwarder, cond func(ForwarderStats) bool) ForwarderStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := forwarder.Stats()
+		if err != nil {
+			t.Fatalf("Stats returned error: %v", err)
+		}
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for forwarder, last stats %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForwarderRedeliversAfterFailures(t *testing.T) {
+	webhook := &flakyWebhook{t: t, secret: "shared-secret", failures: 2}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	logger, forwarder := startForwarder(t, setupWebhookTestDB(t), WebhookConfig{
+		URL:           server.URL,
+		Secret:        "shared-secret",
+		FlushInterval: 10 * time.Millisecond,
+		RetryBackoff:  10 * time.Millisecond,
+	})
+
+	if err := logger.LogLogin(1, "refresh-token"); err != nil {
+		t.Fatalf("LogLogin returned error: %v", err)
+	}
+	if err := logger.LogLogout(1, "refresh-token"); err != nil {
+		t.Fatalf("LogLogout returned error: %v", err)
+	}
+
+	stats := waitForStats(t, forwarder, func(s ForwarderStats) bool { return s.Sent == 2 })
+	if stats.QueueDepth != 0 {
+		t.Errorf("Expected empty outbox, got depth %d", stats.QueueDepth)
+	}
+	if stats.Failed == 0 {
+		t.Errorf("Expected failed attempts to be counted")
+	}
+
+	events := webhook.events()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events delivered, got %d", len(events))
+	}
+	if events[0].EventType != string(EventLogin) || events[1].EventType != string(EventLogout) {
+		t.Errorf("Expected login then logout, got %s then %s", events[0].EventType, events[1].EventType)
+	}
+	if events[0].RefreshTokenFingerprint != tokenFingerprint("refresh-token") {
+		t.Errorf("Expected refresh token fingerprint to be forwarded")
+	}
+}
+
+func TestForwarderDeadLettersAfterMaxAttempts(t *testing.T) {
+	webhook := &flakyWebhook{t: t, secret: "shared-secret", failures: 1000}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	db := setupWebhookTestDB(t)
+	logger, forwarder := startForwarder(t, db, WebhookConfig{
+		URL:           server.URL,
+		Secret:        "shared-secret",
+		FlushInterval: 10 * time.Millisecond,
+		RetryBackoff:  time.Millisecond,
+		MaxAttempts:   3,
+	})
+
+	if err := logger.LogInvalidRefreshToken("bad-token"); err != nil {
+		t.Fatalf("LogInvalidRefreshToken returned error: %v", err)
+	}
+
+	stats := waitForStats(t, forwarder, func(s ForwarderStats) bool { return s.DeadLettered == 1 })
+	if stats.QueueDepth != 0 || stats.Sent != 0 || stats.Failed != 3 {
+		t.Errorf("Unexpected stats after dead-lettering: %+v", stats)
+	}
+
+	var attempts int
+	if err := db.Get(&attempts, "SELECT attempts FROM audit_webhook_dead_letter"); err != nil {
+		t.Fatalf("Failed to query dead-letter table: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts recorded, got %d", attempts)
+	}
+}
+
+func TestForwarderFiltersEventTypes(t *testing.T) {
+	webhook := &flakyWebhook{t: t, secret: "shared-secret"}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	logger, forwarder := startForwarder(t, setupWebhookTestDB(t), WebhookConfig{
+		URL:           server.URL,
+		Secret:        "shared-secret",
+		EventTypes:    []EventType{EventInvalidRefreshToken},
+		FlushInterval: 10 * time.Millisecond,
+	})
+
+	if err := logger.LogLogin(1, "refresh-token"); err != nil {
+		t.Fatalf("LogLogin returned error: %v", err)
+	}
+	if err := logger.LogInvalidRefreshToken("bad-token"); err != nil {
+		t.Fatalf("LogInvalidRefreshToken returned error: %v", err)
+	}
+
+	waitForStats(t, forwarder, func(s ForwarderStats) bool { return s.Sent == 1 })
+	events := webhook.events()
+	if len(events) != 1 || events[0].EventType != string(EventInvalidRefreshToken) {
+		t.Errorf("Expected only the invalid refresh token event, got %+v", events)
+	}
+
+	// Filtered events are still recorded in the audit log itself
+	recent, err := logger.GetRecentEvents(10)
+	if err != nil {
+		t.Fatalf("GetRecentEvents returned error: %v", err)
+	}
+	if len(recent) != 2 {
+		t.Errorf("Expected 2 audit events, got %d", len(recent))
+	}
+}
+
+func TestForwarderFlushDeliversWithoutRun(t *testing.T) {
+	webhook := &flakyWebhook{t: t, secret: "shared-secret"}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	db := setupWebhookTestDB(t)
+	logger, err := NewLogger(db)
+	if err != nil {
+		t.Fatalf("NewLogger returned error: %v", err)
+	}
+	forwarder, err := NewForwarder(db, WebhookConfig{
+		URL:       server.URL,
+		Secret:    "shared-secret",
+		BatchSize: 2,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewForwarder returned error: %v", err)
+	}
+	logger.SetForwarder(forwarder)
+
+	for i := 0; i < 5; i++ {
+		if err := logger.LogLogin(i, "refresh-token"); err != nil {
+			t.Fatalf("LogLogin returned error: %v", err)
+		}
+	}
+	if err := forwarder.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if events := webhook.events(); len(events) != 5 {
+		t.Errorf("Expected all 5 events delivered across batches, got %d", len(events))
+	}
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign("secret", []byte(`{"events":[]}`))
+	if signature != Sign("secret", []byte(`{"events":[]}`)) {
+		t.Error("Expected signatures to be deterministic")
+	}
+	if signature == Sign("other-secret", []byte(`{"events":[]}`)) {
+		t.Error("Expected signature to depend on the secret")
+	}
+	if signature == Sign("secret", []byte(`{"events":[{}]}`)) {
+		t.Error("Expected signature to depend on the body")
+	}
+}
+
+func TestForwarderPausesAndResumes(t *testing.T) {
+	webhook := &flakyWebhook{t: t, secret: "shared-secret"}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	var paused atomic.Bool
+	paused.Store(true)
+	logger, forwarder := startForwarder(t, setupWebhookTestDB(t), WebhookConfig{
+		URL:           server.URL,
+		Secret:        "shared-secret",
+		FlushInterval: 10 * time.Millisecond,
+		Paused:        paused.Load,
+	})
+
+	if err := logger.LogLogin(1, "refresh-token"); err != nil {
+		t.Fatalf("LogLogin returned error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if stats, _ := forwarder.Stats(); stats.Sent != 0 || stats.QueueDepth != 1 {
+		t.Errorf("Expected the event to stay queued while paused, got %+v", stats)
+	}
+
+	paused.Store(false)
+	waitForStats(t, forwarder, func(s ForwarderStats) bool { return s.Sent == 1 })
+}
+
+func TestForwarderMetrics(t *testing.T) {
+	webhook := &flakyWebhook{t: t, secret: "shared-secret", failures: 1}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	var paused atomic.Bool
+	paused.Store(true)
+	logger, forwarder := startForwarder(t, setupWebhookTestDB(t), WebhookConfig{
+		URL:           server.URL,
+		Secret:        "shared-secret",
+		FlushInterval: 10 * time.Millisecond,
+		RetryBackoff:  10 * time.Millisecond,
+		Paused:        paused.Load,
+	})
+	registry := prometheus.NewRegistry()
+	forwarder.EnableMetrics(registry)
+	gather := func() map[string]float64 {
+		t.Helper()
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := map[string]float64{}
+		for _, family := range families {
+			metric := family.GetMetric()[0]
+			values[family.GetName()] = metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+		return values
+	}
+
+	if err := logger.LogLogin(1, "refresh-token"); err != nil {
+		t.Fatalf("LogLogin returned error: %v", err)
+	}
+	if values := gather(); values["nexushub_audit_webhook_queue_depth"] != 1 || values["nexushub_audit_webhook_sent_total"] != 0 {
+		t.Errorf("Expected one queued event, got %v", values)
+	}
+
+	paused.Store(false)
+	waitForStats(t, forwarder, func(s ForwarderStats) bool { return s.Sent == 1 })
+	values := gather()
+	if values["nexushub_audit_webhook_sent_total"] != 1 || values["nexushub_audit_webhook_failed_total"] != 1 || values["nexushub_audit_webhook_queue_depth"] != 0 {
+		t.Errorf("Expected one sent event after one failure, got %v", values)
+	}
+	if count := testutil.CollectAndCount(registry); count != 4 {
+		t.Errorf("Expected 4 webhook metrics, got %d", count)
+	}
+}
@@ -0,0 +1,285 @@
+// Package chunkstore is a content-addressed store for the chunks of uploaded
+// debug packages. Chunks are named by their SHA-256 hash and shared between
+// uploads, so a package that mostly matches a previous one only needs its
+// changed chunks uploaded.
+//
+// Each debug application references the chunks of its current package.
+// Chunks no application references are removed by GC once they are older
+// than the grace period, which leaves time for an upload in progress to
+// reference the chunks it has sent.
+package chunkstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGracePeriod is how long an unreferenced chunk is kept.
+const DefaultGracePeriod = time.Hour
+
+// refsFile holds the chunks referenced by each owner.
+const refsFile = "refs.json"
+
+// now is replaced in tests.
+var now = time.Now
+
+// ErrHashMismatch is returned by Put when the data does not match the hash
+// it was uploaded as.
+var ErrHashMismatch = errors.New("chunk data does not match its hash")
+
+// Store is a content-addressed chunk store in a directory.
+type Store struct {
+	dir         string
+	gracePeriod time.Duration
+
+	mu     sync.Mutex
+	refs   map[string][]string // Chunks referenced by each owner
+	counts map[string]int      // Number of owners referencing each chunk
+}
+
+// Open opens the chunk store in dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create chunk store: %w", err)
+	}
+	s := &Store{
+		dir:         dir,
+		gracePeriod: DefaultGracePeriod,
+		refs:        make(map[string][]string),
+		counts:      make(map[string]int),
+	}
+	data, err := os.ReadFile(filepath.Join(dir, refsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read chunk references: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.refs); err != nil {
+			return nil, fmt.Errorf("failed to parse chunk references: %w", err)
+		}
+	}
+	for _, hashes := range s.refs {
+		for _, hash := range unique(hashes) {
+			s.counts[hash]++
+		}
+	}
+	return s, nil
+}
+
+// SetGracePeriod sets how long an unreferenced chunk is kept before GC
+// removes it.
+func (s *Store) SetGracePeriod(gracePeriod time.Duration) {
+	s.gracePeriod = gracePeriod
+}
+
+// Dir returns the directory the chunks are stored in.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// ValidHash reports whether hash is a lowercase hex SHA-256 hash.
+func ValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// Has reports whether the chunk is stored.
+func (s *Store) Has(hash string) bool {
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+// Missing returns the hashes that are not stored, without duplicates, in
+// the order they are first listed. Stored chunks are touched so that GC
+// keeps them while the upload that listed them completes.
+func (s *Store) Missing(hashes []string) []string {
+	missing := []string{}
+	t := now()
+	for _, hash := range unique(hashes) {
+		if err := os.Chtimes(s.path(hash), t, t); err != nil {
+			missing = append(missing, hash)
+		}
+	}
+	return missing
+}
+
+// Put stores a chunk after checking that data matches hash.
+func (s *Store) Put(hash string, data []byte) error {
+	if !ValidHash(hash) {
+		return fmt.Errorf("invalid chunk hash %q", hash)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return ErrHashMismatch
+	}
+	path := s.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+	// Write to a temporary file so a partly written chunk is never visible
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create chunk file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store chunk: %w", err)
+	}
+	return nil
+}
+
+// Assemble writes the chunks to w in order.
+func (s *Store) Assemble(hashes []string, w io.Writer) error {
+	for _, hash := range hashes {
+		if !ValidHash(hash) {
+			return fmt.Errorf("invalid chunk hash %q", hash)
+		}
+		f, err := os.Open(s.path(hash))
+		if err != nil {
+			return fmt.Errorf("failed to open chunk %s: %w", hash, err)
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy chunk %s: %w", hash, err)
+		}
+	}
+	return nil
+}
+
+// SetRefs replaces the chunks referenced by owner.
+func (s *Store) SetRefs(owner string, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(owner)
+	hashes = unique(hashes)
+	s.refs[owner] = hashes
+	for _, hash := range hashes {
+		s.counts[hash]++
+	}
+	return s.saveRefs()
+}
+
+// Release drops the chunks referenced by owner.
+func (s *Store) Release(owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.refs[owner]; !ok {
+		return nil
+	}
+	s.release(owner)
+	return s.saveRefs()
+}
+
+func (s *Store) release(owner string) {
+	for _, hash := range s.refs[owner] {
+		if s.counts[hash]--; s.counts[hash] <= 0 {
+			delete(s.counts, hash)
+		}
+	}
+	delete(s.refs, owner)
+}
+
+// RefCount returns the number of owners referencing the chunk.
+func (s *Store) RefCount(hash string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[hash]
+}
+
+func (s *Store) saveRefs() error {
+	data, err := json.Marshal(s.refs)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, refsFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save chunk references: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, refsFile)); err != nil {
+		return fmt.Errorf("failed to save chunk references: %w", err)
+	}
+	return nil
+}
+
+// GC removes the chunks no owner references that were last stored or
+// listed longer than the grace period ago, and returns how many it removed.
+func (s *Store) GC() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := now().Add(-s.gracePeriod)
+	removed := 0
+	prefixes, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chunk store: %w", err)
+	}
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.dir, prefix.Name()))
+		if err != nil {
+			return removed, fmt.Errorf("failed to list chunk store: %w", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if s.counts[name] > 0 {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			// Leftover temporary files are removed along with chunks
+			if !ValidHash(name) && !strings.HasSuffix(name, ".tmp") {
+				continue
+			}
+			if err := os.Remove(filepath.Join(s.dir, prefix.Name(), name)); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("failed to remove chunk %s: %w", name, err)
+			}
+			if ValidHash(name) {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// unique returns hashes without duplicates, keeping the first occurrence.
+func unique(hashes []string) []string {
+	seen := make(map[string]bool, len(hashes))
+	result := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			result = append(result, hash)
+		}
+	}
+	return result
+}
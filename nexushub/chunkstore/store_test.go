@@ -0,0 +1,88 @@
+package chunkstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPutAndAssemble(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := []byte("first chunk"), []byte("second chunk")
+	if err := s.Put(hashOf(a), b); err != ErrHashMismatch {
+		t.Errorf("Expected a chunk that does not match its hash to be rejected, got %v", err)
+	}
+	if err := s.Put(hashOf(a), a); err != nil {
+		t.Fatal(err)
+	}
+	if missing := s.Missing([]string{hashOf(a), hashOf(b), hashOf(b)}); len(missing) != 1 || missing[0] != hashOf(b) {
+		t.Errorf("Expected only the second chunk to be missing, got %v", missing)
+	}
+	if err := s.Put(hashOf(b), b); err != nil {
+		t.Fatal(err)
+	}
+
+	var assembled bytes.Buffer
+	if err := s.Assemble([]string{hashOf(b), hashOf(a), hashOf(b)}, &assembled); err != nil {
+		t.Fatal(err)
+	}
+	if assembled.String() != "second chunkfirst chunksecond chunk" {
+		t.Errorf("Expected the chunks in manifest order, got %q", assembled.String())
+	}
+}
+
+func TestGCRemovesUnreferencedChunks(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, old, fresh := []byte("shared"), []byte("old"), []byte("fresh")
+	for _, chunk := range [][]byte{shared, old, fresh} {
+		if err := s.Put(hashOf(chunk), chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetRefs("app-1", []string{hashOf(shared), hashOf(old), hashOf(shared)})
+	s.SetRefs("app-2", []string{hashOf(shared)})
+	if count := s.RefCount(hashOf(shared)); count != 2 {
+		t.Errorf("Expected the shared chunk to be referenced twice, got %d", count)
+	}
+
+	// A new deployment of app-1 drops the old chunk
+	s.SetRefs("app-1", []string{hashOf(shared)})
+	if removed, err := s.GC(); err != nil || removed != 0 {
+		t.Errorf("Expected recent chunks to be kept, removed %d: %v", removed, err)
+	}
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Now().Add(2 * DefaultGracePeriod) }
+	// The fresh chunk is listed by an upload in progress
+	s.Missing([]string{hashOf(fresh)})
+	if removed, err := s.GC(); err != nil || removed != 1 {
+		t.Errorf("Expected only the old chunk to be removed, removed %d: %v", removed, err)
+	}
+	if s.Has(hashOf(old)) || !s.Has(hashOf(shared)) || !s.Has(hashOf(fresh)) {
+		t.Error("Expected the old chunk to be removed and the others kept")
+	}
+
+	// References survive reopening the store
+	s.Release("app-2")
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := reopened.RefCount(hashOf(shared)); count != 1 {
+		t.Errorf("Expected 1 reference after reopening, got %d", count)
+	}
+}
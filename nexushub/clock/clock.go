@@ -0,0 +1,140 @@
+// Package clock abstracts the system clock so that components measuring
+// elapsed time can be tested against clock steps.
+//
+// Times returned by Now carry a monotonic reading, so Since and After measure
+// elapsed time even if NTP steps the wall clock. Absolute times that are
+// stored or compared across processes, such as session expiries, only have
+// the wall clock to go by; compare them with a leeway, see
+// SkewLeewayFromEnv.
+package clock
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultSkewLeeway is how far past its expiry an absolute timestamp is still
+// accepted, to absorb small clock steps.
+const DefaultSkewLeeway = 30 * time.Second
+
+// Clock tells the time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Since returns the time elapsed since t, which should come from Now.
+	Since(t time.Time) time.Duration
+	// After sends the current time on the returned channel once d has
+	// elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+// SkewLeewayFromEnv returns the leeway set by CLOCK_SKEW_LEEWAY, or
+// DefaultSkewLeeway.
+func SkewLeewayFromEnv() (time.Duration, error) {
+	value := os.Getenv("CLOCK_SKEW_LEEWAY")
+	if value == "" {
+		return DefaultSkewLeeway, nil
+	}
+	leeway, err := time.ParseDuration(value)
+	if err != nil || leeway < 0 {
+		return 0, fmt.Errorf("invalid CLOCK_SKEW_LEEWAY %q", value)
+	}
+	return leeway, nil
+}
+
+// Fake is a Clock for tests that only moves when told to. Advance lets time
+// pass; Step moves the wall clock without time passing, as NTP does.
+type Fake struct {
+	mu      sync.Mutex
+	wall    time.Time
+	elapsed time.Duration
+	// readings holds the monotonic reading of every time returned by Now
+	readings map[time.Time]time.Duration
+	timers   []fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Duration
+	ch       chan time.Time
+}
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		wall:     now.Round(0),
+		readings: make(map[time.Time]time.Duration),
+	}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readings[f.wall] = f.elapsed
+	return f.wall
+}
+
+// Since uses the monotonic reading of t if it came from Now and the wall
+// clock otherwise, like time.Since.
+func (f *Fake) Since(t time.Time) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if reading, ok := f.readings[t]; ok {
+		return f.elapsed - reading
+	}
+	return f.wall.Sub(t)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.wall
+		return ch
+	}
+	f.timers = append(f.timers, fakeTimer{deadline: f.elapsed + d, ch: ch})
+	return ch
+}
+
+// Advance lets d pass, firing the timers that expire.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.elapsed += d
+	f.wall = f.wall.Add(d)
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.deadline <= f.elapsed {
+			timer.ch <- f.wall
+		} else {
+			pending = append(pending, timer)
+		}
+	}
+	f.timers = pending
+}
+
+// Step moves the wall clock by d, which may be negative, without any time
+// passing.
+func (f *Fake) Step(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wall = f.wall.Add(d)
+}
+
+// Timers returns the number of timers waiting to fire.
+func (f *Fake) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
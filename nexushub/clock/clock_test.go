@@ -0,0 +1,56 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeStepDoesNotPassTime(t *testing.T) {
+	fake := NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	start := fake.Now()
+	timer := fake.After(time.Minute)
+
+	fake.Step(-3 * time.Hour)
+	if elapsed := fake.Since(start); elapsed != 0 {
+		t.Errorf("Expected no time to pass after a backwards step, got %v", elapsed)
+	}
+	fake.Step(6 * time.Hour)
+	if elapsed := fake.Since(start); elapsed != 0 {
+		t.Errorf("Expected no time to pass after a forwards step, got %v", elapsed)
+	}
+	select {
+	case <-timer:
+		t.Fatal("Expected the timer not to fire on a clock step")
+	default:
+	}
+
+	fake.Advance(time.Minute)
+	if elapsed := fake.Since(start); elapsed != time.Minute {
+		t.Errorf("Expected a minute to pass, got %v", elapsed)
+	}
+	select {
+	case <-timer:
+	default:
+		t.Fatal("Expected the timer to fire once its duration passed")
+	}
+
+	// Times that did not come from the clock only have the wall clock
+	if elapsed := fake.Since(time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)); elapsed != 4*time.Hour+time.Minute {
+		t.Errorf("Expected the wall clock difference, got %v", elapsed)
+	}
+}
+
+func TestSkewLeewayFromEnv(t *testing.T) {
+	t.Setenv("CLOCK_SKEW_LEEWAY", "")
+	if leeway, err := SkewLeewayFromEnv(); err != nil || leeway != DefaultSkewLeeway {
+		t.Errorf("Expected the default leeway, got %v: %v", leeway, err)
+	}
+	t.Setenv("CLOCK_SKEW_LEEWAY", "2m")
+	if leeway, err := SkewLeewayFromEnv(); err != nil || leeway != 2*time.Minute {
+		t.Errorf("Expected 2m, got %v: %v", leeway, err)
+	}
+	t.Setenv("CLOCK_SKEW_LEEWAY", "-1s")
+	if _, err := SkewLeewayFromEnv(); err == nil {
+		t.Error("Expected a negative leeway to be rejected")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build embedded
+
+package main
+
+// Building with -tags embedded links the Admin application into the hub, so
+// that packages with the embedded transport can run it in-process.
+import _ "github.com/tomyedwab/yesterday/apps/admin/server"
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -15,16 +16,29 @@ import (
 
 	"net/http"
 
-	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/chunkstore"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/crashes"
+	"github.com/tomyedwab/yesterday/nexushub/desiredstate"
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
+	"github.com/tomyedwab/yesterday/nexushub/eventauth"
 	"github.com/tomyedwab/yesterday/nexushub/events"
 	"github.com/tomyedwab/yesterday/nexushub/httpsproxy"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/middleware"
+	"github.com/tomyedwab/yesterday/nexushub/idempotency"
+	"github.com/tomyedwab/yesterday/nexushub/internal/handlers"
 	"github.com/tomyedwab/yesterday/nexushub/packages"
 	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
 	"github.com/tomyedwab/yesterday/nexushub/sessions"
+	"github.com/tomyedwab/yesterday/nexushub/traces"
 )
 
 func main() {
@@ -36,13 +50,19 @@ func main() {
 	var httpProxy *httpsproxy.Proxy // Declare proxy variable for access in shutdown handler
 
 	proxyListenAddr := ":" + *port
-	internalSecret := uuid.New().String()
 	hostName := fmt.Sprintf("www.yesterday.localhost%s", proxyListenAddr)
 
 	// 1. Setup logger
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	slog.SetDefault(logger)
 
+	secretConfig, err := secrets.ConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid internal secret configuration", "error", err)
+		os.Exit(1)
+	}
+	secretStore := secrets.NewStore(secretConfig.GracePeriod)
+
 	logger.Info("Starting NexusHub Process Manager")
 
 	packageManager, err := packages.NewPackageManager()
@@ -52,21 +72,72 @@ func main() {
 	}
 	installDir := packageManager.GetInstallDir()
 
+	// How long instances keep running after their last request; some may be
+	// kept warm
+	idleTimeouts, err := packages.IdleTimeoutsFromEnv()
+	if err != nil {
+		logger.Error("Invalid idle timeout configuration", "error", err)
+		os.Exit(1)
+	}
+	packageManager.SetIdleTimeouts(idleTimeouts)
+	logger.Info("Idle timeouts configured", "default", idleTimeouts.Default, "overrides", idleTimeouts.Overrides, "keepWarm", idleTimeouts.KeepWarm)
+
+	// Watch free space on the volumes holding the databases and packages;
+	// the proxy adds its upload directory
+	diskConfig, err := diskspace.ConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid disk space configuration", "error", err)
+		os.Exit(1)
+	}
+	diskConfig.Paths = []string{installDir, packageManager.GetPkgDir()}
+	diskWatchdog := diskspace.NewWatchdog(diskConfig, nil, logger)
+
 	// 2. Initialize audit logger with database
-	auditDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "audit.db"))
+	auditDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "audit.db")+"?_busy_timeout=5000")
 	auditLogger, err := audit.NewLogger(auditDatabase)
 	if err != nil {
 		logger.Error("Failed to initialize audit logger", "error", err)
 		os.Exit(1)
 	}
 	logger.Info("Audit logger initialized")
+	secretStore.SetAuditLogger(auditLogger)
+
+	// Optionally forward audit events to an external webhook (e.g. a SIEM)
+	webhookConfig, err := audit.WebhookConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid audit webhook configuration", "error", err)
+		os.Exit(1)
+	}
+	var auditForwarder *audit.Forwarder
+	if webhookConfig != nil {
+		webhookConfig.Logger = logger
+		webhookConfig.Paused = diskWatchdog.Low
+		auditForwarder, err = audit.NewForwarder(auditDatabase, *webhookConfig)
+		if err != nil {
+			logger.Error("Failed to initialize audit webhook forwarder", "error", err)
+			os.Exit(1)
+		}
+		auditLogger.SetForwarder(auditForwarder)
+		logger.Info("Audit webhook forwarding enabled", "url", webhookConfig.URL)
+	}
 
-	sessionsDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "sessions.db"))
+	sessionsDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "sessions.db")+"?_busy_timeout=5000")
 	sessionManager, err := sessions.NewManager(sessionsDatabase, 15*time.Minute, 24*30*time.Hour, 1*time.Minute)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Tolerate small wall clock steps when checking session and access
+	// token expiry
+	skewLeeway, err := clock.SkewLeewayFromEnv()
+	if err != nil {
+		logger.Error("Invalid clock skew leeway", "error", err)
+		os.Exit(1)
+	}
+	sessionManager.SetExpiryLeeway(skewLeeway)
+	access.SetExpiryLeeway(skewLeeway)
+	logger.Info("Clock skew leeway configured", "leeway", skewLeeway)
+
 	// Create EventManager
 	eventsDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "events.db"))
 	eventManager, err := events.CreateEventManager(eventsDatabase)
@@ -74,6 +145,65 @@ func main() {
 		log.Fatal(err)
 	}
 
+	crashesDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "crashes.db")+"?_busy_timeout=5000")
+	crashStore, err := crashes.NewStore(crashesDatabase)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Keep a history of the desired instances for investigating incidents
+	desiredStateConfig, err := desiredstate.ConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid desired state configuration", "error", err)
+		os.Exit(1)
+	}
+	desiredStateDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "desiredstate.db")+"?_busy_timeout=5000")
+	desiredStateStore, err := desiredstate.NewStore(desiredStateDatabase, desiredStateConfig.Retention)
+	if err != nil {
+		log.Fatal(err)
+	}
+	desiredStateRecorder, err := desiredstate.NewRecorder(desiredStateStore, desiredStateConfig.Interval, clock.Real, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Index a sample of requests, and all failed ones, by trace ID
+	traceConfig, err := traces.ConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid trace index configuration", "error", err)
+		os.Exit(1)
+	}
+	var traceStore *traces.Store
+	var traceDatabase *sqlx.DB
+	if traceConfig.Enabled() {
+		traceDatabase = sqlx.MustConnect("sqlite3", path.Join(installDir, "traces.db")+"?_busy_timeout=5000")
+		traceStore, err = traces.NewStore(traceDatabase, traceConfig.Retention)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Limit the database size and event count of each instance
+	quotaConfig, err := quotas.ConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid quota configuration", "error", err)
+		os.Exit(1)
+	}
+	quotasDatabase := sqlx.MustConnect("sqlite3", path.Join(installDir, "quotas.db")+"?_busy_timeout=5000")
+	quotaStore, err := quotas.NewStore(quotasDatabase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	quotaCollector := quotas.NewCollector(quotaConfig, packageManager, eventManager, quotaStore, logger)
+
+	// Restrict who may publish the event types packages list publishers for
+	userRoles, err := eventauth.RolesFromEnv()
+	if err != nil {
+		logger.Error("Invalid user roles", "error", err)
+		os.Exit(1)
+	}
+	publishAuthorizer := eventauth.NewAuthorizer(packageManager, userRoles, auditLogger, logger)
+
 	// 3. Initialize PortManager
 	portManager, err := processes.NewPortManager(10000, 19999)
 	if err != nil {
@@ -90,6 +220,20 @@ func main() {
 	}
 	logger.Info("Project root", "path", projectRoot)
 
+	// Internal CA for mutual TLS between the hub and its instances
+	backendCA, err := processes.BackendTLSFromEnv(path.Join(installDir, "ca"))
+	if err != nil {
+		logger.Error("Failed to set up backend mTLS", "error", err)
+		os.Exit(1)
+	}
+
+	// Per-instance log files, if enabled
+	logFiles, err := processes.LogFilesFromEnv()
+	if err != nil {
+		logger.Error("Invalid app log file configuration", "error", err)
+		os.Exit(1)
+	}
+
 	pmConfig := processes.Config{
 		InstanceProvider:       packageManager,
 		PortManager:            portManager,
@@ -102,9 +246,12 @@ func main() {
 		GracefulShutdownPeriod: 5 * time.Second,
 		SubprocessWorkDir:      projectRoot, // Processes will run from the project root
 		EventManager:           eventManager,
+		CA:                     backendCA,
+		OnDesiredState:         desiredStateRecorder.Observe,
+		LogFiles:               logFiles,
 	}
 
-	processManager, err := processes.NewProcessManager(pmConfig, internalSecret)
+	processManager, err := processes.NewProcessManager(pmConfig, secretStore)
 	if err != nil {
 		logger.Error("Failed to create ProcessManager", "error", err)
 		os.Exit(1)
@@ -131,49 +278,69 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// The audit forwarder gets its own context so it can be stopped and
+	// flushed one last time before the process manager goes down
+	forwarderCtx, stopForwarder := context.WithCancel(ctx)
+	forwarderDone := make(chan struct{})
+	processManagerDone := make(chan struct{})
+
+	// Stages run in order, each with its own timeout, within an overall
+	// deadline after which the remaining work is abandoned.
+	shutdown := newShutdownCoordinator(logger, 60*time.Second)
+	shutdown.Add("drain proxy", 20*time.Second, func(ctx context.Context) error {
+		if httpProxy == nil {
+			return nil
+		}
+		return httpProxy.Stop(ctx)
+	})
+	shutdown.Add("flush audit forwarder", 10*time.Second, func(ctx context.Context) error {
+		if auditForwarder == nil {
+			return nil
+		}
+		stopForwarder()
+		<-forwarderDone
+		return auditForwarder.Flush(ctx)
+	})
+	shutdown.Add("stop processes", 20*time.Second, func(ctx context.Context) error {
+		// Stop returns once the manager's loops have exited; its Run then
+		// stops every child process before returning
+		processManager.Stop()
+		select {
+		case <-processManagerDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	shutdown.Add("close databases", 5*time.Second, func(ctx context.Context) error {
+		cancel()
+		desiredStateRecorder.Flush()
+		var traceErr error
+		if traceDatabase != nil {
+			traceErr = traceDatabase.Close()
+		}
+		return errors.Join(
+			auditDatabase.Close(),
+			sessionsDatabase.Close(),
+			eventsDatabase.Close(),
+			crashesDatabase.Close(),
+			desiredStateDatabase.Close(),
+			quotasDatabase.Close(),
+			traceErr,
+			// Lets another hub use the install directory
+			packageManager.Close(),
+		)
+	})
+
+	shutdownErr := make(chan error, 1)
 	go func() {
 		sig := <-sigChan
 		logger.Info("Received signal, initiating graceful shutdown...", "signal", sig.String())
-
-		// Initiate proxy shutdown first
+		// Report not ready right away so no new traffic is routed here
 		if httpProxy != nil {
-			if *httpMode {
-				logger.Info("Attempting to stop HTTP Proxy server...")
-			} else {
-				logger.Info("Attempting to stop HTTPS Proxy server...")
-			}
-			if err := httpProxy.Stop(); err != nil {
-				if *httpMode {
-					logger.Error("Error stopping HTTP Proxy server", "error", err)
-				} else {
-					logger.Error("Error stopping HTTPS Proxy server", "error", err)
-				}
-			} else {
-				if *httpMode {
-					logger.Info("HTTP Proxy server stopped gracefully.")
-				} else {
-					logger.Info("HTTPS Proxy server stopped gracefully.")
-				}
-			}
-		} else {
-			if *httpMode {
-				logger.Info("HTTP Proxy was not initialized, skipping stop.")
-			} else {
-				logger.Info("HTTPS Proxy was not initialized, skipping stop.")
-			}
+			httpProxy.BeginShutdown()
 		}
-
-		// Initiate process manager shutdown
-		logger.Info("Attempting to stop ProcessManager...")
-		if processManager != nil { // Good practice to check if it was initialized
-			processManager.Stop()
-			logger.Info("ProcessManager stop signal sent.")
-		} else {
-			logger.Info("ProcessManager was not initialized, skipping stop.")
-		}
-
-		logger.Info("Cancelling main context to allow all services to complete shutdown.")
-		cancel() // Cancel the main context
+		shutdownErr <- shutdown.Run(context.Background())
 	}()
 
 	// 6. Initialize the HTTPS Proxy
@@ -200,11 +367,138 @@ func main() {
 		hostName,
 		proxyCertFile,
 		proxyKeyFile,
-		internalSecret,
+		secretStore,
 		*httpMode,
 		processManager,
 		packageManager,
 		eventManager)
+	httpProxy.SetCrashStore(crashStore)
+	httpProxy.SetDesiredStateStore(desiredStateStore)
+	if traceStore != nil {
+		httpProxy.SetTraceIndex(traceStore, traceConfig)
+	}
+	httpProxy.SetDiskWatchdog(diskWatchdog)
+	httpProxy.SetQuotas(quotaCollector)
+	httpProxy.SetPublishAuthorizer(publishAuthorizer)
+	httpProxy.SetUserRoles(userRoles)
+
+	// Share the chunks of debug packages between uploads
+	chunkStore, err := chunkstore.Open(path.Join(installDir, "chunks"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	httpProxy.SetChunkStore(chunkStore)
+	httpProxy.EnableUserData(sessionManager, auditLogger)
+
+	// Optionally serve application instances on their own host names
+	hostRoutes, err := httpsproxy.HostRoutesFromEnv()
+	if err != nil {
+		logger.Error("Invalid host route configuration", "error", err)
+		os.Exit(1)
+	}
+	if hostRoutes != nil {
+		httpProxy.SetHostRoutes(hostRoutes)
+		logger.Info("Host routing enabled", "routes", hostRoutes)
+	}
+
+	// Optionally mirror a sample of read traffic to shadow instances
+	shadowRoutes, err := httpsproxy.ShadowRoutesFromEnv()
+	if err != nil {
+		logger.Error("Invalid shadow route configuration", "error", err)
+		os.Exit(1)
+	}
+	if shadowRoutes != nil {
+		httpProxy.SetShadowRoutes(shadowRoutes)
+		logger.Info("Shadow traffic enabled", "routes", shadowRoutes)
+	}
+
+	// Host names of clones are derived from their source's with a suffix
+	cloneHostSuffix, err := httpsproxy.CloneHostSuffixFromEnv()
+	if err != nil {
+		logger.Error("Invalid clone configuration", "error", err)
+		os.Exit(1)
+	}
+	httpProxy.SetCloneHostSuffix(cloneHostSuffix)
+
+	// Optionally replace the page browsers see while an instance is starting
+	maintenancePage, err := httpsproxy.MaintenancePageFromEnv()
+	if err == nil && maintenancePage != "" {
+		err = httpProxy.SetMaintenancePage(maintenancePage)
+	}
+	if err != nil {
+		logger.Error("Invalid maintenance page", "error", err)
+		os.Exit(1)
+	}
+
+	// How long requests wait for an instance that is not running to start
+	coldStartTimeout, err := httpsproxy.ColdStartTimeoutFromEnv()
+	if err != nil {
+		logger.Error("Invalid cold start timeout", "error", err)
+		os.Exit(1)
+	}
+	httpProxy.SetColdStartTimeout(coldStartTimeout)
+
+	// Limits on streaming debug application logs
+	logStreamConfig, err := handlers.LogStreamConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid log stream configuration", "error", err)
+		os.Exit(1)
+	}
+	httpProxy.SetLogStreamConfig(logStreamConfig)
+
+	// CORS policy of the hub's own endpoints and proxied responses
+	corsConfig, err := middleware.CORSConfigFromEnv()
+	if err != nil {
+		logger.Error("Invalid CORS configuration", "error", err)
+		os.Exit(1)
+	}
+	middleware.SetCORSConfig(corsConfig)
+	metricsRegistry := prometheus.NewRegistry()
+	httpProxy.EnableMetrics(metricsRegistry)
+	httpProxy.EnableShadowMetrics(metricsRegistry)
+	quotaCollector.EnableMetrics(metricsRegistry)
+	processManager.EnableMetrics(metricsRegistry)
+	if auditForwarder != nil {
+		auditForwarder.EnableMetrics(metricsRegistry)
+	}
+
+	// Remember responses to requests sent with an Idempotency-Key
+	idempotencyRetention, err := idempotency.RetentionFromEnv()
+	if err != nil {
+		logger.Error("Invalid idempotency configuration", "error", err)
+		os.Exit(1)
+	}
+	var idempotencyStore *idempotency.Store
+	if idempotencyRetention > 0 {
+		idempotencyStore, err = idempotency.NewStore(sessionsDatabase, idempotencyRetention)
+		if err != nil {
+			logger.Error("Failed to initialize idempotency store", "error", err)
+			os.Exit(1)
+		}
+		httpProxy.SetIdempotencyStore(idempotencyStore)
+		logger.Info("Idempotency keys enabled", "retention", idempotencyRetention)
+	}
+
+	// Optionally tighten the TLS versions, cipher suites and protocols
+	tlsPolicy, err := httpsproxy.TLSPolicyFromEnv()
+	if err == nil {
+		err = httpProxy.SetTLSPolicy(tlsPolicy)
+	}
+	if err != nil {
+		logger.Error("Invalid TLS policy", "error", err)
+		os.Exit(1)
+	}
+
+	// Optionally accept client certificates in place of the internal secret
+	// on a separate listener
+	mtlsConfig, err := httpsproxy.MTLSConfigFromEnv()
+	if err == nil {
+		err = httpProxy.SetMTLS(mtlsConfig)
+	}
+	if err != nil {
+		logger.Error("Invalid mTLS configuration", "error", err)
+		os.Exit(1)
+	}
 
 	contextFn := func(_ net.Listener) context.Context {
 		ctx := context.WithValue(context.Background(), sessions.SessionManagerKey, sessionManager)
@@ -236,10 +530,55 @@ func main() {
 		}
 	}()
 
-	// 8. Run the ProcessManager (this is blocking)
+	// Rotate the internal secret on a schedule (daily unless configured
+	// otherwise); it can also be rotated on demand via POST /secrets/rotate.
+	logger.Info("Internal secret rotation configured", "interval", secretConfig.RotationInterval, "gracePeriod", secretConfig.GracePeriod)
+	go secretStore.RunRotation(ctx, secretConfig.RotationInterval)
+
+	// Check free disk space; writers back off while it is low
+	logger.Info("Disk space watchdog configured", "softThreshold", diskConfig.SoftThreshold, "hardThreshold", diskConfig.HardThreshold)
+	go diskWatchdog.Run(ctx)
+
+	// Measure instances' usage against their quotas
+	logger.Info("Quotas configured", "defaults", quotaConfig.Defaults, "interval", quotaConfig.Interval)
+	go quotaCollector.Run(ctx)
+
+	// Uninstall clones once they expire
+	go httpProxy.RunCloneExpiry(ctx, time.Minute)
+
+	// Stop instances once they go idle
+	go httpProxy.RunIdleDeactivation(ctx, time.Minute)
+
+	// Expire desired state snapshots once they are past retention
+	go desiredStateStore.RunCleanup(ctx, time.Hour)
+
+	// Expire trace index entries once they are past retention
+	if traceStore != nil {
+		go traceStore.RunCleanup(ctx, time.Hour)
+	}
+
+	// Expire stored idempotent responses once they are past retention
+	if idempotencyStore != nil {
+		go idempotencyStore.RunCleanup(ctx, time.Hour)
+	}
+
+	go func() {
+		defer close(forwarderDone)
+		if auditForwarder != nil {
+			auditForwarder.Run(forwarderCtx)
+		}
+	}()
+
+	// 8. Run the ProcessManager until shutdown stops it
 	logger.Info("Running ProcessManager... Press Ctrl+C to exit.")
-	processManager.Run(ctx) // This blocks until Stop() is called or context is cancelled
-	<-ctx.Done()
+	go func() {
+		defer close(processManagerDone)
+		processManager.Run(ctx)
+	}()
 
+	if err := <-shutdownErr; err != nil {
+		logger.Error("NexusHub shutdown incomplete", "error", err)
+		os.Exit(1)
+	}
 	logger.Info("NexusHub components have completed their shutdown sequence. Exiting main.")
 }
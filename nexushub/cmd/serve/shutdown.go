@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// shutdownStage is one step of the shutdown sequence.
+type shutdownStage struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// shutdownCoordinator runs shutdown stages one after another, so each stage
+// can rely on the ones before it having finished: the proxy stops taking
+// traffic before the backends it forwards to are stopped, and databases are
+// closed only once nothing writes to them.
+//
+// Each stage gets its own timeout. A stage that fails or times out is
+// recorded and the sequence moves on. Once the overall deadline passes the
+// remaining stages are abandoned, including a stage that is still running.
+type shutdownCoordinator struct {
+	logger   *slog.Logger
+	deadline time.Duration
+	stages   []shutdownStage
+}
+
+func newShutdownCoordinator(logger *slog.Logger, deadline time.Duration) *shutdownCoordinator {
+	return &shutdownCoordinator{logger: logger, deadline: deadline}
+}
+
+// Add appends a stage to the sequence.
+func (c *shutdownCoordinator) Add(name string, timeout time.Duration, run func(ctx context.Context) error) {
+	c.stages = append(c.stages, shutdownStage{name: name, timeout: timeout, run: run})
+}
+
+// Run executes the stages in order and returns an error summarizing every
+// stage that failed, timed out or was abandoned.
+func (c *shutdownCoordinator) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.deadline)
+	defer cancel()
+
+	start := time.Now()
+	var errs []error
+	for i, stage := range c.stages {
+		if ctx.Err() != nil {
+			for _, skipped := range c.stages[i:] {
+				errs = append(errs, fmt.Errorf("%s: abandoned after shutdown deadline of %v", skipped.name, c.deadline))
+			}
+			break
+		}
+
+		c.logger.Info("Shutdown stage starting", "stage", stage.name, "timeout", stage.timeout)
+		stageStart := time.Now()
+		if err := c.runStage(ctx, stage); err != nil {
+			c.logger.Error("Shutdown stage failed", "stage", stage.name, "duration", time.Since(stageStart), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", stage.name, err))
+			continue
+		}
+		c.logger.Info("Shutdown stage complete", "stage", stage.name, "duration", time.Since(stageStart))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	c.logger.Info("Shutdown complete", "duration", time.Since(start))
+	return nil
+}
+
+// runStage runs a stage in the background and waits for it to finish or for
+// its timeout to pass. A stage that ignores its context is left running.
+func (c *shutdownCoordinator) runStage(ctx context.Context, stage shutdownStage) error {
+	ctx, cancel := context.WithTimeout(ctx, stage.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- stage.run(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("did not finish: %w", ctx.Err())
+	}
+}
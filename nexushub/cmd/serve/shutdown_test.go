@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCoordinator(deadline time.Duration) *shutdownCoordinator {
+	return newShutdownCoordinator(slog.New(slog.NewTextHandler(io.Discard, nil)), deadline)
+}
+
+func TestShutdownRunsStagesInOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	c := newTestCoordinator(time.Second)
+	c.Add("proxy", time.Second, record("proxy"))
+	c.Add("forwarder", time.Second, record("forwarder"))
+	c.Add("processes", time.Second, record("processes"))
+	c.Add("databases", time.Second, record("databases"))
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if got := strings.Join(order, ","); got != "proxy,forwarder,processes,databases" {
+		t.Errorf("Unexpected stage order %s", got)
+	}
+}
+
+func TestShutdownContinuesAfterStageTimeout(t *testing.T) {
+	ran := false
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestCoordinator(time.Second)
+	c.Add("stuck", 20*time.Millisecond, func(context.Context) error {
+		<-release // Ignores its context
+		return nil
+	})
+	c.Add("failing", time.Second, func(context.Context) error {
+		return errors.New("boom")
+	})
+	c.Add("after", time.Second, func(context.Context) error {
+		ran = true
+		return nil
+	})
+
+	err := c.Run(context.Background())
+	if !ran {
+		t.Error("Expected stages after a timed out stage to run")
+	}
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "stuck: did not finish") || !strings.Contains(err.Error(), "failing: boom") {
+		t.Errorf("Expected the error to summarize both failures, got %v", err)
+	}
+}
+
+func TestShutdownAbandonsStagesAfterDeadline(t *testing.T) {
+	ran := false
+	c := newTestCoordinator(50 * time.Millisecond)
+	c.Add("slow", time.Second, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c.Add("databases", time.Second, func(context.Context) error {
+		ran = true
+		return nil
+	})
+
+	start := time.Now()
+	err := c.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the overall deadline to cut the slow stage short, took %v", elapsed)
+	}
+	if ran {
+		t.Error("Expected stages after the deadline to be abandoned")
+	}
+	if err == nil || !strings.Contains(err.Error(), "databases: abandoned") {
+		t.Errorf("Expected the abandoned stage in the error, got %v", err)
+	}
+}
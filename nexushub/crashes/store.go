@@ -0,0 +1,188 @@
+// Package crashes stores the panic reports sent by applications, grouping
+// repeated crashes by a hash of their stack trace.
+package crashes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Report is a crash report as sent by applib.
+type Report struct {
+	Message     string          `json:"message"`
+	Stack       string          `json:"stack"`
+	Breadcrumbs []string        `json:"breadcrumbs,omitempty"`
+	Request     *RequestContext `json:"request,omitempty"`
+	Task        string          `json:"task,omitempty"`
+	Time        time.Time       `json:"time"`
+}
+
+// RequestContext describes the request being served when a handler panicked.
+type RequestContext struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// Crash is a group of reports from one instance that share a stack hash. The
+// details are those of the most recent report.
+type Crash struct {
+	ID         int64  `db:"id" json:"id"`
+	InstanceID string `db:"instance_id" json:"instanceId"`
+	StackHash  string `db:"stack_hash" json:"stackHash"`
+	Count      int64  `db:"count" json:"count"`
+	FirstSeen  int64  `db:"first_seen" json:"firstSeen"`
+	LastSeen   int64  `db:"last_seen" json:"lastSeen"`
+	Report
+	ReportJSON []byte `db:"report" json:"-"`
+}
+
+const crashSchema = `
+CREATE TABLE IF NOT EXISTS crash_reports_v1 (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	instance_id TEXT NOT NULL,
+	stack_hash TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	first_seen INTEGER NOT NULL,
+	last_seen INTEGER NOT NULL,
+	report TEXT NOT NULL,
+	UNIQUE (instance_id, stack_hash)
+);
+CREATE INDEX IF NOT EXISTS idx_crash_reports_v1_last_seen ON crash_reports_v1(instance_id, last_seen);
+`
+
+const recordCrashSql = `
+INSERT INTO crash_reports_v1 (instance_id, stack_hash, count, first_seen, last_seen, report)
+VALUES ($1, $2, 1, $3, $3, $4)
+ON CONFLICT (instance_id, stack_hash) DO UPDATE SET
+	count = count + 1,
+	last_seen = excluded.last_seen,
+	report = excluded.report;
+`
+
+const listCrashesSql = `
+SELECT id, instance_id, stack_hash, count, first_seen, last_seen, report FROM crash_reports_v1
+WHERE instance_id = $1 ORDER BY last_seen DESC, id DESC LIMIT $2 OFFSET $3;
+`
+
+const countCrashesSql = `
+SELECT COUNT(*) FROM crash_reports_v1 WHERE instance_id = $1;
+`
+
+const crashesByTraceSql = `
+SELECT id, instance_id, stack_hash, count, first_seen, last_seen, report FROM crash_reports_v1
+WHERE json_extract(report, '$.request.traceId') = $1 ORDER BY last_seen DESC, id DESC;
+`
+
+const crashCountsSql = `
+SELECT instance_id, SUM(count) AS total FROM crash_reports_v1 GROUP BY instance_id;
+`
+
+// Store records crash reports in the hub database.
+type Store struct {
+	db *sqlx.DB
+}
+
+// NewStore creates the crash report table if needed.
+func NewStore(db *sqlx.DB) (*Store, error) {
+	if _, err := db.Exec(crashSchema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Record stores a report for the given instance. A report whose stack hashes
+// the same as an earlier one from that instance increments its count instead
+// of adding a row.
+func (s *Store) Record(instanceID string, report Report) error {
+	if report.Time.IsZero() {
+		report.Time = time.Now().UTC()
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(recordCrashSql, instanceID, StackHash(report.Stack), report.Time.Unix(), string(reportJSON))
+	return err
+}
+
+// List returns a page of an instance's crashes, most recent first, along with
+// the total number of distinct crashes.
+func (s *Store) List(instanceID string, limit, offset int) ([]*Crash, int, error) {
+	var total int
+	if err := s.db.Get(&total, countCrashesSql, instanceID); err != nil {
+		return nil, 0, err
+	}
+	crashes := []*Crash{}
+	if err := s.db.Select(&crashes, listCrashesSql, instanceID, limit, offset); err != nil {
+		return nil, 0, err
+	}
+	for _, crash := range crashes {
+		if err := json.Unmarshal(crash.ReportJSON, &crash.Report); err != nil {
+			return nil, 0, err
+		}
+	}
+	return crashes, total, nil
+}
+
+// ByTraceID returns the crashes whose most recent report was made while
+// serving the request with the given trace ID. Earlier reports of a crash
+// are not kept, so a request whose crash has recurred since is not found.
+func (s *Store) ByTraceID(traceID string) ([]*Crash, error) {
+	crashes := []*Crash{}
+	if err := s.db.Select(&crashes, crashesByTraceSql, traceID); err != nil {
+		return nil, err
+	}
+	for _, crash := range crashes {
+		if err := json.Unmarshal(crash.ReportJSON, &crash.Report); err != nil {
+			return nil, err
+		}
+	}
+	return crashes, nil
+}
+
+// Counts returns the total number of reports received from each instance.
+func (s *Store) Counts() (map[string]int64, error) {
+	var rows []struct {
+		InstanceID string `db:"instance_id"`
+		Total      int64  `db:"total"`
+	}
+	if err := s.db.Select(&rows, crashCountsSql); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.InstanceID] = row.Total
+	}
+	return counts, nil
+}
+
+var (
+	goroutineHeader = regexp.MustCompile(`^goroutine \d+ \[[^\]]*\]:$`)
+	frameArgs       = regexp.MustCompile(`\([^()]*\)$`)
+	frameOffset     = regexp.MustCompile(` \+0x[0-9a-f]+$`)
+)
+
+// StackHash identifies a crash site. Goroutine IDs, argument values and
+// program counter offsets vary between otherwise identical panics, so they are
+// stripped before hashing.
+func StackHash(stack string) string {
+	var normalized []string
+	for _, line := range strings.Split(stack, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || goroutineHeader.MatchString(line) {
+			continue
+		}
+		line = frameArgs.ReplaceAllString(line, "()")
+		line = frameOffset.ReplaceAllString(line, "")
+		normalized = append(normalized, line)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(normalized, "\n")))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,127 @@
+package crashes
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestStore(t *testing.T) *Store {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "crashes.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	return store
+}
+
+const stackA = `goroutine 7 [running]:
+main.handleThing(0xc0001a2000, 0x1)
+	/src/app/main.go:42 +0x1d
+net/http.HandlerFunc.ServeHTTP(...)
+	/usr/local/go/src/net/http/server.go:2136 +0x29
+`
+
+// stackA from another goroutine with different arguments and offsets
+const stackARepeat = `goroutine 31 [running]:
+main.handleThing(0xc000ff0000, 0x2)
+	/src/app/main.go:42 +0x1f
+net/http.HandlerFunc.ServeHTTP(...)
+	/usr/local/go/src/net/http/server.go:2136 +0x2c
+`
+
+const stackB = `goroutine 7 [running]:
+main.handleOther({0x6a3b20?, 0x7e4f10?})
+	/src/app/main.go:57 +0x1d
+`
+
+func TestStackHash(t *testing.T) {
+	if StackHash(stackA) != StackHash(stackARepeat) {
+		t.Error("Expected the same crash site to hash identically")
+	}
+	if StackHash(stackA) == StackHash(stackB) {
+		t.Error("Expected different crash sites to hash differently")
+	}
+}
+
+func TestRecordDeduplicates(t *testing.T) {
+	store := setupTestStore(t)
+	first := time.Unix(1000, 0).UTC()
+	last := time.Unix(2000, 0).UTC()
+
+	for _, report := range []Report{
+		{Message: "nil map", Stack: stackA, Time: first},
+		{Message: "nil map again", Stack: stackARepeat, Time: last},
+		{Message: "other", Stack: stackB, Time: first},
+	} {
+		if err := store.Record("app1", report); err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+	// The same stack from another instance is a separate crash
+	if err := store.Record("app2", Report{Message: "nil map", Stack: stackA}); err != nil {
+		t.Fatalf("Record returned error: %v", err)
+	}
+
+	crashes, total, err := store.List("app1", 10, 0)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if total != 2 || len(crashes) != 2 {
+		t.Fatalf("Expected 2 distinct crashes, got total %d, %d rows", total, len(crashes))
+	}
+	crash := crashes[0]
+	if crash.Count != 2 || crash.Message != "nil map again" {
+		t.Errorf("Expected the repeated crash first with count 2 and the latest details, got %+v", crash)
+	}
+	if crash.FirstSeen != first.Unix() || crash.LastSeen != last.Unix() {
+		t.Errorf("Expected first/last seen %d/%d, got %d/%d", first.Unix(), last.Unix(), crash.FirstSeen, crash.LastSeen)
+	}
+
+	counts, err := store.Counts()
+	if err != nil {
+		t.Fatalf("Counts returned error: %v", err)
+	}
+	if counts["app1"] != 3 || counts["app2"] != 1 {
+		t.Errorf("Unexpected counts %v", counts)
+	}
+}
+
+func TestListPaginates(t *testing.T) {
+	store := setupTestStore(t)
+	store.Record("app1", Report{Stack: stackA, Time: time.Unix(1000, 0)})
+	store.Record("app1", Report{Stack: stackB, Time: time.Unix(2000, 0)})
+
+	page, total, err := store.List("app1", 1, 1)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if total != 2 || len(page) != 1 || page[0].StackHash != StackHash(stackA) {
+		t.Errorf("Expected the older crash on the second page, got total %d, %+v", total, page)
+	}
+}
+
+func TestByTraceID(t *testing.T) {
+	store := setupTestStore(t)
+	store.Record("app1", Report{Stack: stackA, Request: &RequestContext{Method: "GET", Path: "/a", TraceID: "trace-1"}, Time: time.Unix(1000, 0)})
+	store.Record("app2", Report{Stack: stackB, Request: &RequestContext{Method: "GET", Path: "/b", TraceID: "trace-1"}, Time: time.Unix(2000, 0)})
+	store.Record("app1", Report{Stack: stackB, Task: "sync", Time: time.Unix(3000, 0)})
+
+	found, err := store.ByTraceID("trace-1")
+	if err != nil {
+		t.Fatalf("ByTraceID returned error: %v", err)
+	}
+	if len(found) != 2 || found[0].InstanceID != "app2" || found[1].Request.Path != "/a" {
+		t.Errorf("Expected the two crashes of the request, got %+v", found)
+	}
+
+	// A recurrence replaces the report of the request
+	store.Record("app1", Report{Stack: stackARepeat, Request: &RequestContext{Method: "GET", Path: "/a", TraceID: "trace-2"}, Time: time.Unix(4000, 0)})
+	if found, _ := store.ByTraceID("trace-1"); len(found) != 1 {
+		t.Errorf("Expected only the crash that has not recurred, got %+v", found)
+	}
+}
@@ -0,0 +1,89 @@
+package desiredstate
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// Recorder records the desired state whenever it changes, at most once per
+// interval. A change within the interval after the last snapshot is held
+// back and recorded with the time it was observed once the interval has
+// passed, replaced by any later change in the meantime.
+type Recorder struct {
+	store    *Store
+	interval time.Duration
+	clock    clock.Clock
+	logger   *slog.Logger
+
+	mu           sync.Mutex
+	recorded     string    // Digest of the last snapshot recorded
+	lastRecorded time.Time // When it was recorded, from clock
+	pending      *Snapshot // Change to record once the interval has passed
+}
+
+// NewRecorder returns a recorder that appends snapshots to store. The desired
+// state recorded last, e.g. before the hub restarted, is not recorded again.
+func NewRecorder(store *Store, interval time.Duration, clk clock.Clock, logger *slog.Logger) (*Recorder, error) {
+	if clk == nil {
+		clk = clock.Real
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	recorder := &Recorder{store: store, interval: interval, clock: clk, logger: logger}
+	latest, err := store.Latest()
+	if err != nil && !errors.Is(err, ErrNoSnapshot) {
+		return nil, err
+	}
+	if latest != nil {
+		recorder.recorded = latest.Digest
+	}
+	return recorder, nil
+}
+
+// Observe notes the current desired state, recording it if it changed and
+// the interval has passed since the last snapshot. Pass it as
+// processes.Config.OnDesiredState.
+func (r *Recorder) Observe(instances []processes.AppInstance) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	snapshot := NewSnapshot(now, instances)
+	switch {
+	case snapshot.Digest == r.recorded:
+		// Changed back before a pending change was recorded
+		r.pending = nil
+	case r.pending == nil || r.pending.Digest != snapshot.Digest:
+		r.pending = snapshot
+	}
+	if r.pending != nil && (r.lastRecorded.IsZero() || r.clock.Since(r.lastRecorded) >= r.interval) {
+		r.recordPending()
+	}
+}
+
+// Flush records a pending change straight away, e.g. when the hub shuts
+// down.
+func (r *Recorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending != nil {
+		r.recordPending()
+	}
+}
+
+func (r *Recorder) recordPending() {
+	if err := r.store.Record(r.pending); err != nil {
+		// Keep it pending and try again on the next observation
+		r.logger.Error("Failed to record desired state snapshot", "error", err)
+		return
+	}
+	r.logger.Info("Recorded desired state snapshot", "version", r.pending.Version, "instances", len(r.pending.Instances))
+	r.recorded = r.pending.Digest
+	r.lastRecorded = r.clock.Now()
+	r.pending = nil
+}
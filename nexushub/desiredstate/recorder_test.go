@@ -0,0 +1,91 @@
+package desiredstate
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+func instancesWithIDs(ids ...string) []processes.AppInstance {
+	instances := make([]processes.AppInstance, len(ids))
+	for i, id := range ids {
+		instances[i] = processes.AppInstance{InstanceID: id}
+	}
+	return instances
+}
+
+func TestRecorderDebounces(t *testing.T) {
+	store := setupTestStore(t, DefaultRetention)
+	start := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	recorder, err := NewRecorder(store, 10*time.Second, clk, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	latestVersion := func() int64 {
+		snapshot, err := store.Latest()
+		if err != nil {
+			return 0
+		}
+		return snapshot.Version
+	}
+
+	// The first state is recorded straight away, and not again unchanged
+	recorder.Observe(instancesWithIDs("a"))
+	recorder.Observe(instancesWithIDs("a"))
+	if version := latestVersion(); version != 1 {
+		t.Fatalf("Expected the first state to be recorded once, got version %d", version)
+	}
+
+	// Changes within the interval are held back, the last one winning
+	clk.Advance(2 * time.Second)
+	recorder.Observe(instancesWithIDs("a", "b"))
+	clk.Advance(2 * time.Second)
+	changedAt := clk.Now()
+	recorder.Observe(instancesWithIDs("a", "b", "c"))
+	clk.Advance(2 * time.Second)
+	recorder.Observe(instancesWithIDs("a", "b", "c"))
+	if version := latestVersion(); version != 1 {
+		t.Fatalf("Expected no snapshot within the interval, got version %d", version)
+	}
+
+	// Once it has passed the change is recorded as of when it was observed
+	clk.Advance(5 * time.Second)
+	recorder.Observe(instancesWithIDs("a", "b", "c"))
+	snapshot, err := store.Latest()
+	if err != nil || snapshot.Version != 2 || len(snapshot.Instances) != 3 || !snapshot.TakenAt.Equal(changedAt) {
+		t.Fatalf("Expected the last change to be recorded as of %s, got %+v: %v", changedAt, snapshot, err)
+	}
+
+	// A change reverted within the interval is not recorded
+	clk.Advance(time.Second)
+	recorder.Observe(instancesWithIDs("a"))
+	recorder.Observe(instancesWithIDs("a", "b", "c"))
+	clk.Advance(time.Minute)
+	recorder.Observe(instancesWithIDs("a", "b", "c"))
+	if version := latestVersion(); version != 2 {
+		t.Errorf("Expected a reverted change not to be recorded, got version %d", version)
+	}
+
+	// Flush records a pending change without waiting
+	recorder.Observe(instancesWithIDs("d"))
+	recorder.Observe(instancesWithIDs("e"))
+	recorder.Flush()
+	if snapshot, err := store.Latest(); err != nil || snapshot.Version != 4 || snapshot.Instances[0].InstanceID != "e" {
+		t.Errorf("Expected the pending change to be flushed, got %+v: %v", snapshot, err)
+	}
+
+	// A new recorder doesn't record the last state again
+	restarted, err := NewRecorder(store, 10*time.Second, clk, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Observe(instancesWithIDs("e"))
+	if version := latestVersion(); version != 4 {
+		t.Errorf("Expected the unchanged state not to be recorded after a restart, got version %d", version)
+	}
+}
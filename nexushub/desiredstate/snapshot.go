@@ -0,0 +1,101 @@
+// Package desiredstate records versioned snapshots of the instances the
+// process manager is asked to run, so that operators can look up what should
+// have been running at a point in time and diff it against another.
+package desiredstate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// Instance is the recorded desired state of an instance. Environment values
+// and arguments may hold secrets, so only their hashes are recorded.
+type Instance struct {
+	InstanceID    string   `json:"instanceId"`
+	HostName      string   `json:"hostName,omitempty"`
+	PackageHash   string   `json:"packageHash,omitempty"`
+	Transport     string   `json:"transport,omitempty"`
+	RunSelfTest   bool     `json:"runSelfTest,omitempty"`
+	Sandbox       bool     `json:"sandbox,omitempty"`
+	StaticPath    string   `json:"staticPath,omitempty"`
+	Subscriptions []string `json:"subscriptions,omitempty"`
+	BinaryPath    string   `json:"binaryPath,omitempty"`
+	ArgsHash      string   `json:"argsHash,omitempty"`
+	WorkDir       string   `json:"workDir,omitempty"`
+	// Env maps the names of the instance's environment variables to hashes
+	// of their values.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Snapshot is the set of desired instances in effect from TakenAt until the
+// next snapshot.
+type Snapshot struct {
+	Version   int64      `json:"version"`
+	TakenAt   time.Time  `json:"takenAt"`
+	Digest    string     `json:"digest"`
+	Instances []Instance `json:"instances"`
+}
+
+// NewSnapshot returns an unsaved snapshot of the given instances, sorted by
+// instance ID.
+func NewSnapshot(takenAt time.Time, instances []processes.AppInstance) *Snapshot {
+	recorded := make([]Instance, len(instances))
+	for i, instance := range instances {
+		recorded[i] = newInstance(instance)
+	}
+	sort.Slice(recorded, func(i, j int) bool {
+		return recorded[i].InstanceID < recorded[j].InstanceID
+	})
+	return &Snapshot{
+		TakenAt:   takenAt,
+		Digest:    digest(recorded),
+		Instances: recorded,
+	}
+}
+
+func newInstance(instance processes.AppInstance) Instance {
+	recorded := Instance{
+		InstanceID:  instance.InstanceID,
+		HostName:    instance.HostName,
+		PackageHash: instance.PackageHash,
+		Transport:   string(instance.Transport),
+		RunSelfTest: instance.RunSelfTest,
+		Sandbox:     instance.Sandbox,
+		StaticPath:  instance.StaticPath,
+		BinaryPath:  instance.BinaryPath,
+		WorkDir:     instance.WorkDir,
+		Env:         make(map[string]string),
+	}
+	for eventType, subscribed := range instance.Subscriptions {
+		if subscribed {
+			recorded.Subscriptions = append(recorded.Subscriptions, eventType)
+		}
+	}
+	sort.Strings(recorded.Subscriptions)
+	if len(instance.Args) > 0 {
+		recorded.ArgsHash = hashValue(strings.Join(instance.Args, "\x00"))
+	}
+	for _, variable := range instance.Environment() {
+		name, value, _ := strings.Cut(variable, "=")
+		recorded.Env[name] = hashValue(value)
+	}
+	return recorded
+}
+
+func hashValue(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// digest identifies a set of instances, so that snapshots are only recorded
+// when it changes.
+func digest(instances []Instance) string {
+	data, _ := json.Marshal(instances)
+	return hashValue(string(data))
+}
@@ -0,0 +1,185 @@
+package desiredstate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultRetention is how long snapshots are kept when
+// DESIRED_STATE_RETENTION is unset.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// DefaultInterval is the shortest time between two snapshots when
+// DESIRED_STATE_INTERVAL is unset.
+const DefaultInterval = 10 * time.Second
+
+// ErrNoSnapshot is returned by At for a time before the first snapshot that
+// is kept.
+var ErrNoSnapshot = errors.New("no desired state snapshot at that time")
+
+const snapshotSchema = `
+CREATE TABLE IF NOT EXISTS desired_state_v1 (
+	version INTEGER PRIMARY KEY AUTOINCREMENT,
+	taken_at INTEGER NOT NULL,
+	digest TEXT NOT NULL,
+	instances TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_desired_state_v1_taken_at ON desired_state_v1(taken_at);
+`
+
+const insertSnapshotSql = `
+INSERT INTO desired_state_v1 (taken_at, digest, instances) VALUES ($1, $2, $3);
+`
+
+const snapshotAtSql = `
+SELECT version, taken_at, digest, instances FROM desired_state_v1
+WHERE taken_at <= $1 ORDER BY taken_at DESC, version DESC LIMIT 1;
+`
+
+const latestSnapshotSql = `
+SELECT version, taken_at, digest, instances FROM desired_state_v1
+ORDER BY version DESC LIMIT 1;
+`
+
+// The snapshot in effect at the cutoff is kept, since it still describes the
+// desired state after it.
+const deleteExpiredSnapshotsSql = `
+DELETE FROM desired_state_v1 WHERE taken_at < $1 AND version < (
+	SELECT COALESCE(MAX(version), 0) FROM desired_state_v1 WHERE taken_at <= $1
+);
+`
+
+// Config is how often snapshots are taken and how long they are kept.
+type Config struct {
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+// ConfigFromEnv reads the configuration from the environment:
+//
+//	DESIRED_STATE_INTERVAL  shortest time between snapshots, e.g. "10s"
+//	DESIRED_STATE_RETENTION how long snapshots are kept, e.g. "720h"
+func ConfigFromEnv() (Config, error) {
+	config := Config{Interval: DefaultInterval, Retention: DefaultRetention}
+	for _, setting := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"DESIRED_STATE_INTERVAL", &config.Interval},
+		{"DESIRED_STATE_RETENTION", &config.Retention},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return Config{}, fmt.Errorf("invalid %s %q: expected a positive duration", setting.name, value)
+		}
+		*setting.value = duration
+	}
+	return config, nil
+}
+
+type snapshotRow struct {
+	Version   int64  `db:"version"`
+	TakenAt   int64  `db:"taken_at"`
+	Digest    string `db:"digest"`
+	Instances string `db:"instances"`
+}
+
+// Store keeps snapshots in an append-only table of the hub database.
+type Store struct {
+	db        *sqlx.DB
+	retention time.Duration
+}
+
+// NewStore creates the snapshot table if needed. Snapshots are kept for the
+// retention period after they are superseded.
+func NewStore(db *sqlx.DB, retention time.Duration) (*Store, error) {
+	if _, err := db.Exec(snapshotSchema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db, retention: retention}, nil
+}
+
+// Record appends a snapshot, setting its version.
+func (s *Store) Record(snapshot *Snapshot) error {
+	instances, err := json.Marshal(snapshot.Instances)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Exec(insertSnapshotSql, snapshot.TakenAt.UnixMilli(), snapshot.Digest, string(instances))
+	if err != nil {
+		return err
+	}
+	snapshot.Version, err = result.LastInsertId()
+	return err
+}
+
+// At returns the snapshot in effect at t: the last one taken at or before
+// it. It returns ErrNoSnapshot if there is none.
+func (s *Store) At(t time.Time) (*Snapshot, error) {
+	return s.get(snapshotAtSql, t.UnixMilli())
+}
+
+// Latest returns the most recent snapshot, or ErrNoSnapshot if there is
+// none.
+func (s *Store) Latest() (*Snapshot, error) {
+	return s.get(latestSnapshotSql)
+}
+
+func (s *Store) get(query string, args ...any) (*Snapshot, error) {
+	var row snapshotRow
+	err := s.db.Get(&row, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoSnapshot
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		Version: row.Version,
+		TakenAt: time.UnixMilli(row.TakenAt).UTC(),
+		Digest:  row.Digest,
+	}
+	if err := json.Unmarshal([]byte(row.Instances), &snapshot.Instances); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// DeleteExpired removes the snapshots superseded before the retention
+// period, returning how many were removed.
+func (s *Store) DeleteExpired(now time.Time) (int64, error) {
+	result, err := s.db.Exec(deleteExpiredSnapshotsSql, now.Add(-s.retention).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RunCleanup deletes expired snapshots every interval until the context is
+// cancelled. It blocks, so callers should run it in a goroutine.
+func (s *Store) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(time.Now()); err != nil {
+				slog.Error("Failed to delete expired desired state snapshots", "error", err)
+			}
+		}
+	}
+}
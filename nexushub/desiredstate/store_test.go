@@ -0,0 +1,129 @@
+package desiredstate
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+func setupTestStore(t *testing.T, retention time.Duration) *Store {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "desiredstate.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(db, retention)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	return store
+}
+
+// recordAt records a snapshot of instances with the given IDs.
+func recordAt(t *testing.T, store *Store, takenAt time.Time, ids ...string) *Snapshot {
+	t.Helper()
+	instances := make([]processes.AppInstance, len(ids))
+	for i, id := range ids {
+		instances[i] = processes.AppInstance{InstanceID: id}
+	}
+	snapshot := NewSnapshot(takenAt, instances)
+	if err := store.Record(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestSnapshotAt(t *testing.T) {
+	store := setupTestStore(t, DefaultRetention)
+	base := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	if _, err := store.Latest(); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Expected no snapshot in an empty store, got %v", err)
+	}
+	first := recordAt(t, store, base, "a")
+	second := recordAt(t, store, base.Add(10*time.Minute), "a", "b")
+
+	for _, test := range []struct {
+		at      time.Time
+		version int64
+	}{
+		{base.Add(-time.Millisecond), 0},
+		{base, first.Version},
+		{base.Add(13 * time.Minute / 2), first.Version},
+		{base.Add(10*time.Minute - time.Millisecond), first.Version},
+		{base.Add(10 * time.Minute), second.Version},
+		{base.Add(24 * time.Hour), second.Version},
+	} {
+		snapshot, err := store.At(test.at)
+		if test.version == 0 {
+			if !errors.Is(err, ErrNoSnapshot) {
+				t.Errorf("Expected no snapshot at %s, got %v", test.at, err)
+			}
+			continue
+		}
+		if err != nil || snapshot.Version != test.version {
+			t.Errorf("Expected version %d at %s, got %+v: %v", test.version, test.at, snapshot, err)
+		}
+	}
+
+	snapshot, err := store.At(base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.TakenAt.Equal(second.TakenAt) || snapshot.Digest != second.Digest || len(snapshot.Instances) != 2 || snapshot.Instances[1].InstanceID != "b" {
+		t.Errorf("Expected the second snapshot to be read back, got %+v", snapshot)
+	}
+}
+
+func TestDeleteExpiredKeepsSnapshotInEffect(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	base := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	recordAt(t, store, base, "a")
+	inEffect := recordAt(t, store, base.Add(time.Minute), "b")
+	recordAt(t, store, base.Add(2*time.Hour), "c")
+
+	// The second snapshot was still in effect an hour before now
+	deleted, err := store.DeleteExpired(base.Add(90 * time.Minute))
+	if err != nil || deleted != 1 {
+		t.Fatalf("Expected one snapshot to be deleted, got %d: %v", deleted, err)
+	}
+	if _, err := store.At(base.Add(30 * time.Second)); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Expected the first snapshot to be gone, got %v", err)
+	}
+	if snapshot, err := store.At(base.Add(30 * time.Minute)); err != nil || snapshot.Version != inEffect.Version {
+		t.Errorf("Expected the snapshot in effect at the cutoff to be kept, got %+v: %v", snapshot, err)
+	}
+}
+
+func TestSnapshotHashesSecrets(t *testing.T) {
+	snapshot := NewSnapshot(time.Now(), []processes.AppInstance{{
+		InstanceID:    "b",
+		HostName:      "secret.example.com",
+		Args:          []string{"--token", "hunter2"},
+		Subscriptions: map[string]bool{"B": true, "A": true, "C": false},
+	}, {
+		InstanceID: "a",
+	}})
+	if snapshot.Instances[0].InstanceID != "a" {
+		t.Errorf("Expected instances sorted by ID, got %+v", snapshot.Instances)
+	}
+	instance := snapshot.Instances[1]
+	if instance.Env["HOST"] != hashValue("secret.example.com") || instance.ArgsHash == "" {
+		t.Errorf("Expected hashed environment values and arguments, got %+v", instance)
+	}
+	if len(instance.Subscriptions) != 2 || instance.Subscriptions[0] != "A" {
+		t.Errorf("Expected the sorted subscriptions, got %v", instance.Subscriptions)
+	}
+
+	// The digest doesn't depend on the order of the instances
+	reordered := NewSnapshot(time.Now(), []processes.AppInstance{{InstanceID: "a"}, {
+		InstanceID:    "b",
+		HostName:      "secret.example.com",
+		Args:          []string{"--token", "hunter2"},
+		Subscriptions: map[string]bool{"A": true, "B": true},
+	}})
+	if reordered.Digest != snapshot.Digest {
+		t.Error("Expected the same instances to have the same digest")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package diskspace
+
+import "syscall"
+
+// FreeSpace returns the bytes available to unprivileged users on the volume
+// containing path.
+func FreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
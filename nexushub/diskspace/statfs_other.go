@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package diskspace
+
+import "errors"
+
+// FreeSpace is not implemented on this platform, so the watchdog reports
+// every path as unmeasured and never leaves LevelOK.
+func FreeSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on this platform")
+}
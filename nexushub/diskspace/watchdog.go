@@ -0,0 +1,303 @@
+// Package diskspace watches the free space on the volumes the hub writes to
+// and reports when it runs low, so that writers can back off before the disk
+// fills up and corrupts a database.
+package diskspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSoftThreshold = 1 << 30   // 1GiB
+	DefaultHardThreshold = 256 << 20 // 256MiB
+	DefaultCheckInterval = 30 * time.Second
+)
+
+// Level is how short of space the watched volumes are.
+type Level int
+
+const (
+	// LevelOK means every volume has more free space than the soft threshold.
+	LevelOK Level = iota
+	// LevelLow means a volume is below the soft threshold. Non-essential
+	// writers pause.
+	LevelLow
+	// LevelCritical means a volume is below the hard threshold. Event
+	// publishes and new debug applications are rejected.
+	LevelCritical
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelOK:
+		return "ok"
+	case LevelLow:
+		return "low"
+	case LevelCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// MarshalText reports the level by name in JSON.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// Checker returns the number of bytes available to the hub on the volume
+// containing path. FreeSpace is the default; tests substitute their own.
+type Checker func(path string) (uint64, error)
+
+// Config configures the watchdog. A threshold of 0 disables it.
+type Config struct {
+	Paths         []string
+	SoftThreshold uint64
+	HardThreshold uint64
+	Interval      time.Duration
+}
+
+// ConfigFromEnv reads the thresholds from the environment:
+//
+//	DISK_SOFT_THRESHOLD  free space below which writers pause, e.g. "1GiB"
+//	DISK_HARD_THRESHOLD  free space below which writes are rejected, e.g. "256MiB"
+//	DISK_CHECK_INTERVAL  e.g. "30s"
+//
+// Paths are left for the caller to fill in.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		SoftThreshold: DefaultSoftThreshold,
+		HardThreshold: DefaultHardThreshold,
+		Interval:      DefaultCheckInterval,
+	}
+	var err error
+	if value := os.Getenv("DISK_SOFT_THRESHOLD"); value != "" {
+		if config.SoftThreshold, err = ParseSize(value); err != nil {
+			return config, fmt.Errorf("invalid DISK_SOFT_THRESHOLD: %w", err)
+		}
+	}
+	if value := os.Getenv("DISK_HARD_THRESHOLD"); value != "" {
+		if config.HardThreshold, err = ParseSize(value); err != nil {
+			return config, fmt.Errorf("invalid DISK_HARD_THRESHOLD: %w", err)
+		}
+	}
+	if value := os.Getenv("DISK_CHECK_INTERVAL"); value != "" {
+		if config.Interval, err = time.ParseDuration(value); err != nil || config.Interval <= 0 {
+			return config, fmt.Errorf("invalid DISK_CHECK_INTERVAL %q: expected a positive duration", value)
+		}
+	}
+	if config.SoftThreshold != 0 && config.HardThreshold > config.SoftThreshold {
+		return config, fmt.Errorf("DISK_HARD_THRESHOLD must not be larger than DISK_SOFT_THRESHOLD")
+	}
+	return config, nil
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a byte count such as "512MiB", "2GB" or "1048576".
+func ParseSize(value string) (uint64, error) {
+	number, multiplier := strings.TrimSpace(value), uint64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// VolumeStatus is the result of the last check of one watched path.
+type VolumeStatus struct {
+	Path      string `json:"path"`
+	FreeBytes uint64 `json:"freeBytes"`
+	Level     Level  `json:"level"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Status is the result of the last check of every watched path.
+type Status struct {
+	Level   Level          `json:"level"`
+	Volumes []VolumeStatus `json:"volumes"`
+}
+
+// Watchdog periodically checks the free space on its paths. A nil Watchdog
+// always reports LevelOK, so callers need not check whether one is
+// configured.
+type Watchdog struct {
+	config  Config
+	checker Checker
+	logger  *slog.Logger
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// NewWatchdog returns a watchdog for the configured paths. A nil checker
+// uses FreeSpace. The level is LevelOK until the first Check.
+func NewWatchdog(config Config, checker Checker, logger *slog.Logger) *Watchdog {
+	if checker == nil {
+		checker = FreeSpace
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultCheckInterval
+	}
+	return &Watchdog{config: config, checker: checker, logger: logger}
+}
+
+// AddPath watches another path, such as a directory created by a component
+// after the watchdog was configured. It takes effect at the next Check.
+func (d *Watchdog) AddPath(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, existing := range d.config.Paths {
+		if existing == path {
+			return
+		}
+	}
+	d.config.Paths = append(d.config.Paths, path)
+}
+
+// levelFor classifies the free space on one volume.
+func (d *Watchdog) levelFor(free uint64) Level {
+	switch {
+	case d.config.HardThreshold > 0 && free < d.config.HardThreshold:
+		return LevelCritical
+	case d.config.SoftThreshold > 0 && free < d.config.SoftThreshold:
+		return LevelLow
+	}
+	return LevelOK
+}
+
+// Check measures every path and updates the level, logging when it changes.
+// A path that cannot be measured is reported in the status but does not
+// change the level.
+func (d *Watchdog) Check() Level {
+	d.mu.RLock()
+	paths := append([]string(nil), d.config.Paths...)
+	d.mu.RUnlock()
+
+	status := Status{Level: LevelOK}
+	for _, path := range paths {
+		volume := VolumeStatus{Path: path}
+		free, err := d.checker(path)
+		if err != nil {
+			volume.Error = err.Error()
+			d.logger.Warn("Failed to check free disk space", "path", path, "error", err)
+		} else {
+			volume.FreeBytes = free
+			volume.Level = d.levelFor(free)
+		}
+		if volume.Level > status.Level {
+			status.Level = volume.Level
+		}
+		status.Volumes = append(status.Volumes, volume)
+	}
+
+	d.mu.Lock()
+	previous := d.status.Level
+	d.status = status
+	d.mu.Unlock()
+
+	if status.Level != previous {
+		d.logTransition(previous, status)
+	}
+	return status.Level
+}
+
+func (d *Watchdog) logTransition(previous Level, status Status) {
+	attrs := []any{"previous", previous.String()}
+	for _, volume := range status.Volumes {
+		if volume.Level != LevelOK {
+			attrs = append(attrs, volume.Path, volume.FreeBytes)
+		}
+	}
+	switch status.Level {
+	case LevelCritical:
+		d.logger.Error("Disk space critical: rejecting event publishes and new debug applications", attrs...)
+	case LevelLow:
+		d.logger.Warn("Disk space low: pausing non-essential writers", attrs...)
+	default:
+		d.logger.Info("Disk space recovered", attrs...)
+	}
+}
+
+// Level returns the level found by the last Check.
+func (d *Watchdog) Level() Level {
+	if d == nil {
+		return LevelOK
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.status.Level
+}
+
+// Low reports whether any volume is below the soft threshold. It suits
+// pause hooks such as audit.WebhookConfig.Paused.
+func (d *Watchdog) Low() bool {
+	return d.Level() >= LevelLow
+}
+
+// Status returns the result of the last Check.
+func (d *Watchdog) Status() Status {
+	if d == nil {
+		return Status{}
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return Status{Level: d.status.Level, Volumes: append([]VolumeStatus(nil), d.status.Volumes...)}
+}
+
+// Run checks the paths right away and then every interval until the context
+// is cancelled. It blocks, so callers should run it in a goroutine.
+func (d *Watchdog) Run(ctx context.Context) {
+	d.Check()
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Check()
+		}
+	}
+}
+
+// Reject responds with 507 Insufficient Storage and returns true if the
+// level is at or above the given one. The body is a JSON object with the
+// error and the current level.
+func (d *Watchdog) Reject(w http.ResponseWriter, level Level) bool {
+	current := d.Level()
+	if current == LevelOK || current < level {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInsufficientStorage)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": "insufficient storage",
+		"level": current,
+	})
+	return true
+}
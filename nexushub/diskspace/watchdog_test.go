@@ -0,0 +1,178 @@
+package diskspace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeVolumes is an injectable checker reporting the free space set per path
+type fakeVolumes map[string]uint64
+
+func (v fakeVolumes) check(path string) (uint64, error) {
+	free, ok := v[path]
+	if !ok {
+		return 0, errors.New("no such volume")
+	}
+	return free, nil
+}
+
+func newTestWatchdog(volumes fakeVolumes, paths ...string) *Watchdog {
+	config := Config{Paths: paths, SoftThreshold: 1000, HardThreshold: 100}
+	return NewWatchdog(config, volumes.check, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestWatchdogThresholds(t *testing.T) {
+	volumes := fakeVolumes{"/install": 5000, "/uploads": 5000}
+	d := newTestWatchdog(volumes, "/install", "/uploads")
+
+	if level := d.Check(); level != LevelOK || d.Low() {
+		t.Errorf("Expected LevelOK with plenty of space, got %v", level)
+	}
+
+	// The most constrained volume decides the level
+	volumes["/uploads"] = 999
+	if level := d.Check(); level != LevelLow || !d.Low() {
+		t.Errorf("Expected LevelLow below the soft threshold, got %v", level)
+	}
+	volumes["/install"] = 99
+	if level := d.Check(); level != LevelCritical {
+		t.Errorf("Expected LevelCritical below the hard threshold, got %v", level)
+	}
+	status := d.Status()
+	if len(status.Volumes) != 2 || status.Volumes[0].Level != LevelCritical || status.Volumes[1].FreeBytes != 999 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	// Freeing space recovers without intervention
+	volumes["/install"], volumes["/uploads"] = 5000, 5000
+	if level := d.Check(); level != LevelOK || d.Low() {
+		t.Errorf("Expected recovery to LevelOK, got %v", level)
+	}
+}
+
+func TestWatchdogCheckErrors(t *testing.T) {
+	d := newTestWatchdog(fakeVolumes{"/install": 50}, "/install")
+	d.AddPath("/missing")
+	if level := d.Check(); level != LevelCritical {
+		t.Errorf("Expected an unmeasured path not to mask a critical one, got %v", level)
+	}
+	status := d.Status()
+	if len(status.Volumes) != 2 || status.Volumes[1].Error == "" || status.Volumes[1].Level != LevelOK {
+		t.Errorf("Expected the failed check to be reported, got %+v", status)
+	}
+}
+
+func TestNilWatchdog(t *testing.T) {
+	var d *Watchdog
+	recorder := httptest.NewRecorder()
+	if d.Level() != LevelOK || d.Low() || d.Reject(recorder, LevelLow) {
+		t.Error("Expected a nil watchdog to report LevelOK")
+	}
+}
+
+func TestWatchdogReject(t *testing.T) {
+	volumes := fakeVolumes{"/install": 500}
+	d := newTestWatchdog(volumes, "/install")
+	d.Check()
+
+	// Low rejects non-essential writes but not essential ones
+	if d.Reject(httptest.NewRecorder(), LevelCritical) {
+		t.Error("Expected LevelLow not to reject at LevelCritical")
+	}
+	recorder := httptest.NewRecorder()
+	if !d.Reject(recorder, LevelLow) || recorder.Code != http.StatusInsufficientStorage {
+		t.Fatalf("Expected 507 at LevelLow, got %d", recorder.Code)
+	}
+
+	volumes["/install"] = 10
+	d.Check()
+	recorder = httptest.NewRecorder()
+	if !d.Reject(recorder, LevelCritical) || recorder.Code != http.StatusInsufficientStorage {
+		t.Fatalf("Expected 507 at LevelCritical, got %d", recorder.Code)
+	}
+	var body struct {
+		Error string `json:"error"`
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil || body.Error == "" || body.Level != "critical" {
+		t.Errorf("Expected a structured error, got %+v: %v", body, err)
+	}
+}
+
+func TestWatchdogRun(t *testing.T) {
+	volumes := fakeVolumes{"/install": 10}
+	d := newTestWatchdog(volumes, "/install")
+	d.config.Interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for d.Level() != LevelCritical && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if d.Level() != LevelCritical {
+		t.Fatal("Expected Run to check right away")
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(os.TempDir())
+	if err != nil {
+		t.Skipf("FreeSpace unsupported: %v", err)
+	}
+	if free == 0 {
+		t.Error("Expected some free space in the temp dir")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	config, err := ConfigFromEnv()
+	if err != nil || config.SoftThreshold != DefaultSoftThreshold || config.HardThreshold != DefaultHardThreshold {
+		t.Errorf("Expected the defaults, got %+v: %v", config, err)
+	}
+
+	t.Setenv("DISK_SOFT_THRESHOLD", "2GiB")
+	t.Setenv("DISK_HARD_THRESHOLD", "500MB")
+	t.Setenv("DISK_CHECK_INTERVAL", "1m")
+	config, err = ConfigFromEnv()
+	if err != nil || config.SoftThreshold != 2<<30 || config.HardThreshold != 500e6 || config.Interval != time.Minute {
+		t.Errorf("Unexpected config %+v: %v", config, err)
+	}
+
+	t.Setenv("DISK_HARD_THRESHOLD", "3GiB")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("Expected an error for a hard threshold above the soft one")
+	}
+	t.Setenv("DISK_HARD_THRESHOLD", "lots")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("Expected an error for an invalid size")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]uint64{"1048576": 1 << 20, "64KiB": 64 << 10, "1 GB": 1e9, "10B": 10} {
+		if got, err := ParseSize(value); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+	if _, err := ParseSize("-1MiB"); err == nil {
+		t.Error("Expected an error for a negative size")
+	}
+}
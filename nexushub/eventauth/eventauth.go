@@ -0,0 +1,232 @@
+// Package eventauth decides who may publish each event type. Applications
+// list in their manifest the roles allowed to publish the event types they
+// own, and the hub assigns roles to users in USER_ROLES. Publishes with the
+// internal secret, made by the hub and by other applications, are always
+// allowed but audited.
+package eventauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+)
+
+// RoleInternal, listed as an event type's only publisher, allows only
+// publishes with the internal secret.
+const RoleInternal = "internal"
+
+// RoleAdmin is the role of users who may operate the hub itself, e.g.
+// rotate its secrets or download instances' databases.
+const RoleAdmin = "admin"
+
+// Caller is who is publishing: a signed-in user, or the hub or an
+// application using the internal secret.
+type Caller struct {
+	UserID   int
+	Internal bool
+}
+
+// Instance is the publishers an installed instance's manifest lists, by
+// event type.
+type Instance struct {
+	InstanceID string
+	Publishers map[string][]string
+}
+
+// Source lists the installed instances' publishers.
+type Source interface {
+	PublishInstances() ([]Instance, error)
+}
+
+// ForbiddenError is returned by Authorizer.Check for an event type the
+// caller may not publish.
+type ForbiddenError struct {
+	EventType string `json:"eventType"`
+	// InstanceID is the instance restricting the event type
+	InstanceID string `json:"instanceId"`
+	// Roles are the roles allowed to publish it
+	Roles []string `json:"roles"`
+}
+
+func (e *ForbiddenError) Error() string {
+	if slices.Equal(e.Roles, []string{RoleInternal}) {
+		return fmt.Sprintf("%s events can only be published internally", e.EventType)
+	}
+	return fmt.Sprintf("%s events can only be published by %s", e.EventType, strings.Join(e.Roles, ", "))
+}
+
+// ValidatePublishers checks a manifest's publishers: every event type lists
+// at least one role, and RoleInternal is not combined with others, since
+// internal publishes are always allowed.
+func ValidatePublishers(publishers map[string][]string) error {
+	for eventType, roles := range publishers {
+		if eventType == "" {
+			return fmt.Errorf("invalid publishers: empty event type")
+		}
+		if len(roles) == 0 {
+			return fmt.Errorf("invalid publishers for %s: expected at least one role", eventType)
+		}
+		for _, role := range roles {
+			if strings.TrimSpace(role) == "" {
+				return fmt.Errorf("invalid publishers for %s: empty role", eventType)
+			}
+			if role == RoleInternal && len(roles) > 1 {
+				return fmt.Errorf("invalid publishers for %s: %q cannot be combined with other roles", eventType, RoleInternal)
+			}
+		}
+	}
+	return nil
+}
+
+// Roles are the roles of each user, by user ID.
+type Roles map[int][]string
+
+// Has reports whether the user has the role.
+func (r Roles) Has(userID int, role string) bool {
+	return slices.Contains(r[userID], role)
+}
+
+// ParseRoles parses a comma-separated list of userID:role pairs, e.g.
+// "1:admin,1:support,5:support". A user can have any number of roles.
+func ParseRoles(value string) (Roles, error) {
+	roles := Roles{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		user, role, ok := strings.Cut(entry, ":")
+		role = strings.TrimSpace(role)
+		userID, err := strconv.Atoi(strings.TrimSpace(user))
+		if !ok || err != nil || role == "" {
+			return nil, fmt.Errorf("invalid user role %q, expected userID:role", entry)
+		}
+		if role == RoleInternal {
+			return nil, fmt.Errorf("invalid user role %q: %q cannot be assigned to users", entry, RoleInternal)
+		}
+		if !slices.Contains(roles[userID], role) {
+			roles[userID] = append(roles[userID], role)
+		}
+	}
+	return roles, nil
+}
+
+// RolesFromEnv reads users' roles from the USER_ROLES environment variable
+// (see ParseRoles). Users have no roles if it is unset.
+func RolesFromEnv() (Roles, error) {
+	roles, err := ParseRoles(os.Getenv("USER_ROLES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid USER_ROLES: %w", err)
+	}
+	return roles, nil
+}
+
+// Authorizer checks publishes against the instances' publishers. Event types
+// no instance restricts can be published by anyone. An event type restricted
+// by several instances can only be published by callers every one of them
+// allows.
+type Authorizer struct {
+	source Source
+	roles  Roles
+	audit  *audit.Logger
+	logger *slog.Logger
+}
+
+// NewAuthorizer creates an Authorizer for the instances source lists and
+// the users' roles. Refused and internal publishes of restricted event types
+// are recorded with auditLogger, which may be nil.
+func NewAuthorizer(source Source, roles Roles, auditLogger *audit.Logger, logger *slog.Logger) *Authorizer {
+	return &Authorizer{source: source, roles: roles, audit: auditLogger, logger: logger}
+}
+
+// Check returns a *ForbiddenError for the first event type the caller may
+// not publish. A nil Authorizer allows everything.
+func (a *Authorizer) Check(caller Caller, eventTypes []string) error {
+	if a == nil {
+		return nil
+	}
+	instances, err := a.source.PublishInstances()
+	if err != nil {
+		return fmt.Errorf("failed to list event publishers: %w", err)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceID < instances[j].InstanceID
+	})
+
+	checked := make(map[string]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if checked[eventType] {
+			continue
+		}
+		checked[eventType] = true
+		restricted := false
+		for _, instance := range instances {
+			roles, ok := instance.Publishers[eventType]
+			if !ok {
+				continue
+			}
+			restricted = true
+			if !caller.Internal && !a.allows(caller.UserID, roles) {
+				a.record(audit.EventPublishDenied, &caller.UserID, eventType)
+				return &ForbiddenError{EventType: eventType, InstanceID: instance.InstanceID, Roles: roles}
+			}
+		}
+		if restricted && caller.Internal {
+			a.record(audit.EventPublishInternal, nil, eventType)
+		}
+	}
+	return nil
+}
+
+// allows reports whether the user has one of the roles.
+func (a *Authorizer) allows(userID int, roles []string) bool {
+	for _, role := range a.roles[userID] {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authorizer) record(eventType audit.EventType, userID *int, publishedType string) {
+	if eventType == audit.EventPublishDenied {
+		a.logger.Warn("Event publish refused", "eventType", publishedType, "userID", *userID)
+	}
+	if a.audit == nil {
+		return
+	}
+	if err := a.audit.LogEventPublish(eventType, userID, publishedType); err != nil {
+		a.logger.Error("Failed to audit event publish", "eventType", publishedType, "error", err)
+	}
+}
+
+// RejectPublish responds and returns true if Check refuses the event types:
+// with 403 Forbidden and a JSON object with the error and the event type
+// that was refused, or with 500 if the publishers could not be listed.
+func (a *Authorizer) RejectPublish(w http.ResponseWriter, caller Caller, eventTypes []string) bool {
+	err := a.Check(caller, eventTypes)
+	if err == nil {
+		return false
+	}
+	var forbidden *ForbiddenError
+	if !errors.As(err, &forbidden) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":     forbidden.Error(),
+		"forbidden": forbidden,
+	})
+	return true
+}
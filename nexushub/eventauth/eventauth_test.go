@@ -0,0 +1,155 @@
+package eventauth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+)
+
+type staticInstances []Instance
+
+func (s staticInstances) PublishInstances() ([]Instance, error) {
+	return s, nil
+}
+
+func newTestAuthorizer(t *testing.T, instances staticInstances, roles Roles) (*Authorizer, *audit.Logger) {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "audit.db"))
+	t.Cleanup(func() { db.Close() })
+	auditLogger, err := audit.NewLogger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthorizer(instances, roles, auditLogger, slog.New(slog.NewTextHandler(io.Discard, nil))), auditLogger
+}
+
+func TestCheck(t *testing.T) {
+	instances := staticInstances{
+		{InstanceID: "admin", Publishers: map[string][]string{
+			"User:Add":    {"admin"},
+			"User:Delete": {"admin"},
+			"Sync:Done":   {RoleInternal},
+		}},
+		{InstanceID: "helpdesk", Publishers: map[string][]string{
+			"User:Delete": {"admin", "support"},
+		}},
+	}
+	roles, err := ParseRoles("1:admin, 2:support, 1:support")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer, _ := newTestAuthorizer(t, instances, roles)
+
+	for _, tc := range []struct {
+		name       string
+		caller     Caller
+		eventTypes []string
+		forbidden  string
+	}{
+		{"unrestricted", Caller{UserID: 3}, []string{"Todo:Add"}, ""},
+		{"role allowed", Caller{UserID: 1}, []string{"User:Add"}, ""},
+		{"role missing", Caller{UserID: 2}, []string{"Todo:Add", "User:Add"}, "User:Add"},
+		{"no roles", Caller{UserID: 3}, []string{"User:Add"}, "User:Add"},
+		{"allowed by every instance", Caller{UserID: 1}, []string{"User:Delete"}, ""},
+		{"allowed by only one instance", Caller{UserID: 2}, []string{"User:Delete"}, "User:Delete"},
+		{"internal only", Caller{UserID: 1}, []string{"Sync:Done"}, "Sync:Done"},
+		{"internal", Caller{Internal: true}, []string{"Sync:Done", "User:Add"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := authorizer.Check(tc.caller, tc.eventTypes)
+			var forbidden *ForbiddenError
+			switch {
+			case tc.forbidden == "" && err != nil:
+				t.Errorf("Expected the publish to be allowed, got %v", err)
+			case tc.forbidden != "" && (!errors.As(err, &forbidden) || forbidden.EventType != tc.forbidden):
+				t.Errorf("Expected %s to be forbidden, got %v", tc.forbidden, err)
+			}
+		})
+	}
+
+	var nilAuthorizer *Authorizer
+	if err := nilAuthorizer.Check(Caller{UserID: 3}, []string{"User:Add"}); err != nil {
+		t.Errorf("Expected a nil authorizer to allow everything, got %v", err)
+	}
+}
+
+func TestCheckAudits(t *testing.T) {
+	instances := staticInstances{{InstanceID: "admin", Publishers: map[string][]string{"User:Add": {"admin"}}}}
+	authorizer, auditLogger := newTestAuthorizer(t, instances, Roles{1: {"admin"}})
+
+	authorizer.Check(Caller{UserID: 2}, []string{"User:Add"})
+	authorizer.Check(Caller{UserID: 1}, []string{"User:Add"})
+	authorizer.Check(Caller{Internal: true}, []string{"User:Add", "User:Add", "Todo:Add"})
+
+	denied, err := auditLogger.GetEventsByType(audit.EventPublishDenied, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(denied) != 1 || denied[0].UserID == nil || *denied[0].UserID != 2 || denied[0].AccessTokenFingerprint != "User:Add" {
+		t.Errorf("Expected one refused publish by user 2, got %+v", denied)
+	}
+	internal, err := auditLogger.GetEventsByType(audit.EventPublishInternal, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(internal) != 1 || internal[0].UserID != nil || internal[0].AccessTokenFingerprint != "User:Add" {
+		t.Errorf("Expected one internal publish of User:Add, got %+v", internal)
+	}
+}
+
+func TestRejectPublish(t *testing.T) {
+	instances := staticInstances{{InstanceID: "admin", Publishers: map[string][]string{"Sync:Done": {RoleInternal}}}}
+	authorizer, _ := newTestAuthorizer(t, instances, nil)
+
+	recorder := httptest.NewRecorder()
+	if authorizer.RejectPublish(recorder, Caller{UserID: 1}, []string{"Todo:Add"}) || recorder.Code != http.StatusOK {
+		t.Errorf("Expected an unrestricted publish not to be rejected, got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	if !authorizer.RejectPublish(recorder, Caller{UserID: 1}, []string{"Sync:Done"}) || recorder.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for an internal event type, got %d", recorder.Code)
+	}
+	var body struct {
+		Error     string          `json:"error"`
+		Forbidden *ForbiddenError `json:"forbidden"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil || body.Forbidden == nil ||
+		body.Forbidden.EventType != "Sync:Done" || body.Forbidden.InstanceID != "admin" || body.Error != "Sync:Done events can only be published internally" {
+		t.Errorf("Unexpected response body %+v, %v", body, err)
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles("")
+	if err != nil || len(roles) != 0 {
+		t.Errorf("Expected no roles, got %v, %v", roles, err)
+	}
+	for _, value := range []string{"admin", "x:admin", "1:", "1:internal"} {
+		if _, err := ParseRoles(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestValidatePublishers(t *testing.T) {
+	if err := ValidatePublishers(map[string][]string{"User:Add": {"admin", "support"}, "Sync:Done": {RoleInternal}}); err != nil {
+		t.Errorf("Expected valid publishers, got %v", err)
+	}
+	for _, publishers := range []map[string][]string{
+		{"User:Add": {}},
+		{"User:Add": {""}},
+		{"User:Add": {"admin", RoleInternal}},
+	} {
+		if err := ValidatePublishers(publishers); err == nil {
+			t.Errorf("Expected %v to be invalid", publishers)
+		}
+	}
+}
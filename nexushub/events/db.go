@@ -4,10 +4,37 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/httputils"
 )
 
+// Event is an event in the event log.
+type Event struct {
+	ID   int
+	Type string
+	Data []byte
+	// PublishedAt is zero for events published before publish times were
+	// recorded
+	PublishedAt time.Time
+	// GroupID is the ID of the first event of the group the event was
+	// published atomically with, or zero if it was published on its own
+	GroupID int
+}
+
+// NewEvent is an event to publish as part of a group, see
+// EventDBCreateEventGroup.
+type NewEvent struct {
+	ClientID string
+	Type     string
+	Data     []byte
+}
+
+// MaxEventGroupSize is the most events that can be published atomically as a
+// group.
+const MaxEventGroupSize = 100
+
 type DuplicateEventError struct {
 	Id       int
 	ClientId string
@@ -22,17 +49,48 @@ CREATE TABLE IF NOT EXISTS event_v1 (
 	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
 	client_id TEXT NOT NULL,
 	event_type TEXT NOT NULL,
-	event_data JSONB NOT NULL
+	event_data JSONB NOT NULL,
+	published_at TIMESTAMP,
+	group_id INTEGER
 );
 `
 
+// Logs created before publish times were recorded are migrated in
+// EventDBInit. Their existing events keep a NULL published_at. Publish times
+// are stored as UTC RFC 3339 text (httputils.FormatTime); older rows hold the
+// SQLite driver's layout and are read with httputils.ParseTime.
+const eventPublishedAtColumnSql = `
+SELECT COUNT(*) FROM pragma_table_info('event_v1') WHERE name = 'published_at';
+`
+
+const addEventPublishedAtColumnSql = `
+ALTER TABLE event_v1 ADD COLUMN published_at TIMESTAMP;
+`
+
+// Logs created before event groups are migrated in EventDBInit as well
+const eventGroupIdColumnSql = `
+SELECT COUNT(*) FROM pragma_table_info('event_v1') WHERE name = 'group_id';
+`
+
+const addEventGroupIdColumnSql = `
+ALTER TABLE event_v1 ADD COLUMN group_id INTEGER;
+`
+
+const setEventGroupIdV1Sql = `
+UPDATE event_v1 SET group_id = $1 WHERE id BETWEEN $1 AND $2;
+`
+
+const getEventGroupEndV1Sql = `
+SELECT MAX(id) FROM event_v1 WHERE group_id = $1;
+`
+
 const getEventByClientIdV1Sql = `
 SELECT id FROM event_v1 WHERE client_id = $1;
 `
 
 const insertEventV1Sql = `
-INSERT INTO event_v1 (event_data, client_id, event_type)
-VALUES ($1, $2, $3)
+INSERT INTO event_v1 (event_data, client_id, event_type, published_at)
+VALUES ($1, $2, $3, $4)
 RETURNING id;
 `
 
@@ -40,31 +98,124 @@ const getLatestEventIdV1Sql = `
 SELECT event_type, MAX(id) FROM event_v1 GROUP BY event_type;
 `
 
+// published_at is read as text so that every stored form reaches ParseTime,
+// rather than being parsed by the driver, which yields a zero time for forms
+// it does not know
 const getEventByIdV1Sql = `
-SELECT event_data, event_type FROM event_v1 WHERE id = $1;
+SELECT event_data, event_type, CAST(published_at AS TEXT), COALESCE(group_id, 0) FROM event_v1 WHERE id = $1;
+`
+
+const redactEventV1Sql = `
+UPDATE event_v1 SET event_data = $1 WHERE id = $2;
 `
 
 // EventDBInit initializes the event database schema. All events are stored as
-// JSON blobs in the event_v1 table.
+// JSON blobs in the event_v1 table, with aggregate statistics alongside.
 func EventDBInit(db *sqlx.DB) error {
 	_, err := db.Exec(eventSchema)
-	return err
+	if err != nil {
+		return err
+	}
+	var hasPublishedAt int
+	err = db.Get(&hasPublishedAt, eventPublishedAtColumnSql)
+	if err != nil {
+		return err
+	}
+	if hasPublishedAt == 0 {
+		_, err = db.Exec(addEventPublishedAtColumnSql)
+		if err != nil {
+			return err
+		}
+	}
+	var hasGroupId int
+	err = db.Get(&hasGroupId, eventGroupIdColumnSql)
+	if err != nil {
+		return err
+	}
+	if hasGroupId == 0 {
+		_, err = db.Exec(addEventGroupIdColumnSql)
+		if err != nil {
+			return err
+		}
+	}
+	return EventDBInitStats(db)
 }
 
 // EventDB inserts a new event into the events table.
 func EventDBCreateEvent(db *sqlx.DB, eventData []byte, clientId, eventType string) (int, error) {
-	eventId := 0
-
 	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
+	eventId, err := insertEvent(tx, eventData, clientId, eventType, now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Created new event with ID %d\n", eventId)
+	return eventId, nil
+}
+
+// EventDBCreateEventGroup inserts events published atomically, in a single
+// transaction. They are given consecutive IDs and the group ID of the first.
+// If any event is a duplicate none are inserted.
+func EventDBCreateEventGroup(db *sqlx.DB, events []NewEvent) ([]int, error) {
+	if len(events) == 0 || len(events) > MaxEventGroupSize {
+		return nil, fmt.Errorf("an event group must have between 1 and %d events, got %d", MaxEventGroupSize, len(events))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	publishedAt := now().UTC()
+	clientIds := make(map[string]bool, len(events))
+	eventIds := make([]int, 0, len(events))
+	for _, event := range events {
+		if clientIds[event.ClientID] {
+			return nil, &DuplicateEventError{ClientId: event.ClientID}
+		}
+		clientIds[event.ClientID] = true
+
+		eventId, err := insertEvent(tx, event.Data, event.ClientID, event.Type, publishedAt)
+		if err != nil {
+			return nil, err
+		}
+		// The transaction holds SQLite's write lock, so nothing else can
+		// take IDs in between
+		if len(eventIds) > 0 && eventId != eventIds[len(eventIds)-1]+1 {
+			return nil, fmt.Errorf("event group was not given consecutive IDs")
+		}
+		eventIds = append(eventIds, eventId)
+	}
+	_, err = tx.Exec(setEventGroupIdV1Sql, eventIds[0], eventIds[len(eventIds)-1])
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created new event group with IDs %d - %d\n", eventIds[0], eventIds[len(eventIds)-1])
+	return eventIds, nil
+}
+
+// insertEvent inserts an event within tx, failing with a DuplicateEventError
+// if an event with the same client ID exists.
+func insertEvent(tx *sql.Tx, eventData []byte, clientId, eventType string, publishedAt time.Time) (int, error) {
+	eventId := 0
+
 	// First, a quick check if an event with the given client ID already exists
 	// in the database. If so, this is a duplicate event, so just return the
 	// existing ID.
-	err = tx.QueryRow(getEventByClientIdV1Sql, clientId).Scan(&eventId)
+	err := tx.QueryRow(getEventByClientIdV1Sql, clientId).Scan(&eventId)
 	if err == nil {
 		return 0, &DuplicateEventError{
 			Id:       int(eventId),
@@ -75,13 +226,15 @@ func EventDBCreateEvent(db *sqlx.DB, eventData []byte, clientId, eventType strin
 		return 0, err
 	}
 
-	err = tx.QueryRow(insertEventV1Sql, eventData, clientId, eventType).Scan(&eventId)
+	err = tx.QueryRow(insertEventV1Sql, eventData, clientId, eventType, httputils.FormatTime(publishedAt)).Scan(&eventId)
 	if err != nil {
 		return 0, err
 	}
-	log.Printf("Created new event with ID %d\n", eventId)
-	tx.Commit()
-	return int(eventId), nil
+	err = recordEventStats(tx, eventType, len(eventData), publishedAt)
+	if err != nil {
+		return 0, err
+	}
+	return eventId, nil
 }
 
 func EventDBGetCurrentEventIDs(db *sqlx.DB) (map[string]int, error) {
@@ -102,13 +255,62 @@ func EventDBGetCurrentEventIDs(db *sqlx.DB) (map[string]int, error) {
 	return ret, nil
 }
 
-func EventDBGetEvent(db *sqlx.DB, eventId int) (string, []byte, error) {
-	var eventData []byte
-	var eventType string
+func EventDBGetEvent(db *sqlx.DB, eventId int) (*Event, error) {
+	event := &Event{ID: eventId}
+	var publishedAt sql.NullString
+	err := db.QueryRow(getEventByIdV1Sql, eventId).Scan(&event.Data, &event.Type, &publishedAt, &event.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	if event.PublishedAt, err = parsePublishedAt(publishedAt); err != nil {
+		return nil, fmt.Errorf("event %d: %w", eventId, err)
+	}
+	return event, nil
+}
+
+// EventDBGetGroupEnd returns the ID of the last event of the group starting
+// with event groupId.
+func EventDBGetGroupEnd(db *sqlx.DB, groupId int) (int, error) {
+	var groupEnd sql.NullInt64
+	if err := db.Get(&groupEnd, getEventGroupEndV1Sql, groupId); err != nil {
+		return 0, err
+	}
+	if !groupEnd.Valid {
+		return 0, fmt.Errorf("no event group %d", groupId)
+	}
+	return int(groupEnd.Int64), nil
+}
 
-	err := db.QueryRow(getEventByIdV1Sql, eventId).Scan(&eventData, &eventType)
+// parsePublishedAt reads a published_at column selected as text, returning
+// the zero time for NULL.
+func parsePublishedAt(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return httputils.ParseTime(value.String)
+}
+
+// EventDBRedactEvents replaces the data of events whose personal data was
+// redacted, in a single transaction. Events that do not exist are skipped.
+// It returns the number of events replaced.
+func EventDBRedactEvents(db *sqlx.DB, redacted map[int][]byte) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return "", nil, err
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for eventId, eventData := range redacted {
+		result, err := tx.Exec(redactEventV1Sql, eventData, eventId)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		count += int(affected)
 	}
-	return eventType, eventData, nil
+	return count, tx.Commit()
 }
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestEventPublishTimesInStandardForm(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "events.db"))
+	defer db.Close()
+	em, err := CreateEventManager(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := time.Date(2026, 10, 16, 14, 30, 0, 123456789, time.FixedZone("CEST", 2*60*60))
+	now = func() time.Time { return published }
+	defer func() { now = time.Now }()
+
+	id, err := em.PublishEvent("client-1", "Item:Add", []byte(`{"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := db.Get(&stored, `SELECT CAST(published_at AS TEXT) FROM event_v1 WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "2026-10-16T12:30:00.123456789Z" {
+		t.Errorf("Expected the publish time to be stored as UTC RFC 3339, got %q", stored)
+	}
+	event, err := em.GetEvent(id)
+	if err != nil || event.Type != "Item:Add" || string(event.Data) != `{"name":"x"}` || !event.PublishedAt.Equal(published) {
+		t.Errorf("Unexpected event %+v: %v", event, err)
+	}
+}
+
+func TestEventDBReadsLegacyPublishTimes(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "events.db"))
+	defer db.Close()
+
+	// An event log from before publish times were recorded
+	db.MustExec(`CREATE TABLE event_v1 (
+		id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		client_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		event_data JSONB NOT NULL
+	)`)
+	db.MustExec(`INSERT INTO event_v1 (client_id, event_type, event_data) VALUES ('old', 'Item:Add', '{}')`)
+	if err := EventDBInit(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows written by earlier versions: the SQLite driver's layout in the
+	// publisher's zone, and Unix seconds
+	want := time.Date(2026, 3, 1, 12, 0, 0, 500000000, time.UTC)
+	db.MustExec(`INSERT INTO event_v1 (client_id, event_type, event_data, published_at) VALUES ('driver', 'Item:Add', '{}', '2026-03-01 13:00:00.5+01:00')`)
+	db.MustExec(`INSERT INTO event_v1 (client_id, event_type, event_data, published_at) VALUES ('unix', 'Item:Add', '{}', 1772366400)`)
+
+	for id, expected := range map[int]time.Time{1: {}, 2: want, 3: want.Truncate(time.Second)} {
+		event, err := EventDBGetEvent(db, id)
+		if err != nil {
+			t.Fatalf("event %d: %v", id, err)
+		}
+		if !event.PublishedAt.Equal(expected) {
+			t.Errorf("event %d: expected publish time %v, got %v", id, expected, event.PublishedAt)
+		}
+	}
+
+	// The stats rebuild reads them the same way
+	db.MustExec(`DROP TABLE event_type_stats_v1`)
+	db.MustExec(`DROP TABLE event_day_stats_v1`)
+	if err := EventDBInitStats(db); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.Get(&count, `SELECT event_count FROM event_day_stats_v1 WHERE day = '2026-03-01' AND event_type = 'Item:Add'`); err != nil || count != 2 {
+		t.Errorf("Expected both dated events counted on 2026-03-01, got %d: %v", count, err)
+	}
+
+	db.MustExec(`INSERT INTO event_v1 (client_id, event_type, event_data, published_at) VALUES ('bad', 'Item:Add', '{}', 'last tuesday')`)
+	if _, err := EventDBGetEvent(db, 4); err == nil {
+		t.Error("Expected an unreadable publish time to be reported")
+	}
+}
+
+func TestEventGroupStoredTogether(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "events.db"))
+	defer db.Close()
+	em, err := CreateEventManager(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.PublishEvent("existing", "User:Create", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A duplicate anywhere in the group stores none of it
+	_, err = em.PublishEventGroup([]NewEvent{
+		{ClientID: "user", Type: "User:Create", Data: []byte(`{}`)},
+		{ClientID: "existing", Type: "Profile:Create", Data: []byte(`{}`)},
+	})
+	var duplicate *DuplicateEventError
+	if !errors.As(err, &duplicate) || duplicate.ClientId != "existing" {
+		t.Fatalf("Expected a duplicate event error, got %v", err)
+	}
+	var count int
+	if err := db.Get(&count, `SELECT COUNT(*) FROM event_v1`); err != nil || count != 1 {
+		t.Fatalf("Expected only the existing event to be stored, got %d: %v", count, err)
+	}
+
+	eventIds, err := em.PublishEventGroup([]NewEvent{
+		{ClientID: "user", Type: "User:Create", Data: []byte(`{}`)},
+		{ClientID: "profile", Type: "Profile:Create", Data: []byte(`{}`)},
+		{ClientID: "grant", Type: "Access:Grant", Data: []byte(`{}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, eventId := range eventIds {
+		event, err := em.GetEvent(eventId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eventId != eventIds[0]+i || event.GroupID != eventIds[0] {
+			t.Errorf("Expected event %d to be number %d of group %d, got %+v", eventId, i, eventIds[0], event)
+		}
+	}
+	if end, err := em.GetGroupEnd(eventIds[0]); err != nil || end != eventIds[2] {
+		t.Errorf("Expected the group to end at %d, got %d: %v", eventIds[2], end, err)
+	}
+	if em.GetCurrentEventID("Access:Grant") != eventIds[2] {
+		t.Errorf("Expected the latest Access:Grant event to be %d, got %d", eventIds[2], em.GetCurrentEventID("Access:Grant"))
+	}
+	if single, _ := em.GetEvent(1); single.GroupID != 0 {
+		t.Errorf("Expected an event published on its own to have no group, got %d", single.GroupID)
+	}
+	if _, err := em.PublishEventGroup(make([]NewEvent, MaxEventGroupSize+1)); err == nil {
+		t.Error("Expected an oversized group to be refused")
+	}
+}
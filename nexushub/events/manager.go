@@ -45,10 +45,44 @@ func (em *EventManager) PublishEvent(clientID, eventType string, payload []byte)
 	return newEventId, nil
 }
 
+// PublishEventGroup publishes events atomically: they are stored with
+// consecutive IDs in a single transaction, or not at all, and applications
+// apply them together. It returns the events' IDs.
+func (em *EventManager) PublishEventGroup(events []NewEvent) ([]int, error) {
+	eventIds, err := EventDBCreateEventGroup(em.DB, events)
+	if err != nil {
+		return nil, err
+	}
+	for i, event := range events {
+		em.LatestEventIds[event.Type] = eventIds[i]
+	}
+	log.Printf("Published group of %d events with IDs %d - %d", len(events), eventIds[0], eventIds[len(eventIds)-1])
+	return eventIds, nil
+}
+
+// GetGroupEnd returns the ID of the last event of the group starting with
+// event groupId.
+func (em *EventManager) GetGroupEnd(groupId int) (int, error) {
+	return EventDBGetGroupEnd(em.DB, groupId)
+}
+
 func (em *EventManager) GetCurrentEventID(eventType string) int {
 	return em.LatestEventIds[eventType]
 }
 
-func (em *EventManager) GetEvent(eventId int) (string, []byte, error) {
+func (em *EventManager) GetEvent(eventId int) (*Event, error) {
 	return EventDBGetEvent(em.DB, eventId)
 }
+
+// Stats returns statistics about the event log, with per-day counts for the
+// last days days.
+func (em *EventManager) Stats(days int) (*EventStats, error) {
+	return EventDBGetStats(em.DB, days)
+}
+
+// RedactEvents replaces the data of events whose personal data was redacted
+// by an application, so that instances replaying the log later see the same
+// tombstones. It returns the number of events replaced.
+func (em *EventManager) RedactEvents(redacted map[int][]byte) (int, error) {
+	return EventDBRedactEvents(em.DB, redacted)
+}
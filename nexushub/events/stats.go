@@ -0,0 +1,255 @@
+package events
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultStatsDays is the number of days of history returned by the event
+// stats endpoint when none is requested.
+const DefaultStatsDays = 30
+
+// Statistics about the event log are kept in aggregate tables which
+// EventDBCreateEvent updates in the same transaction as the insert, so
+// reading them never scans event_v1.
+const eventStatsSchema = `
+CREATE TABLE IF NOT EXISTS event_type_stats_v1 (
+	event_type TEXT PRIMARY KEY NOT NULL,
+	event_count INTEGER NOT NULL,
+	payload_bytes INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS event_day_stats_v1 (
+	day TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	event_count INTEGER NOT NULL,
+	PRIMARY KEY (day, event_type)
+);
+`
+
+const incrementTypeStatsV1Sql = `
+INSERT INTO event_type_stats_v1 (event_type, event_count, payload_bytes)
+VALUES ($1, 1, $2)
+ON CONFLICT (event_type) DO UPDATE SET
+	event_count = event_count + 1,
+	payload_bytes = payload_bytes + excluded.payload_bytes;
+`
+
+const incrementDayStatsV1Sql = `
+INSERT INTO event_day_stats_v1 (day, event_type, event_count)
+VALUES ($1, $2, 1)
+ON CONFLICT (day, event_type) DO UPDATE SET event_count = event_count + 1;
+`
+
+const getTypeStatsV1Sql = `
+SELECT event_type, event_count, payload_bytes FROM event_type_stats_v1;
+`
+
+const getDayStatsV1Sql = `
+SELECT day, SUM(event_count) FROM event_day_stats_v1 WHERE day >= $1 GROUP BY day;
+`
+
+// statsDayFormat is the format of the day column, in UTC
+const statsDayFormat = "2006-01-02"
+
+// now is the clock used to date events, replaced in tests
+var now = time.Now
+
+// EventTypeStats summarizes the events of one type.
+type EventTypeStats struct {
+	Type                string  `json:"type"`
+	Count               int64   `json:"count"`
+	PayloadBytes        int64   `json:"payloadBytes"`
+	AveragePayloadBytes float64 `json:"averagePayloadBytes"`
+}
+
+// EventDayStats counts the events published on one UTC day.
+type EventDayStats struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// InstanceEventStats counts the events delivered to an application instance,
+// i.e. the events of the types it subscribes to.
+type InstanceEventStats struct {
+	InstanceID string `json:"instanceId"`
+	Name       string `json:"name"`
+	Count      int64  `json:"count"`
+}
+
+// EventStats describes the event log as returned by /events/stats.
+type EventStats struct {
+	TotalEvents int64 `json:"totalEvents"`
+	// Types is ordered by count, most frequent first
+	Types []EventTypeStats `json:"types"`
+	// Days covers the requested number of days up to today, oldest first,
+	// including days without events
+	Days      []EventDayStats      `json:"days"`
+	Instances []InstanceEventStats `json:"instances"`
+}
+
+// AddInstance adds the breakdown for an instance subscribing to the given
+// event types.
+func (s *EventStats) AddInstance(instanceID, name string, subscriptions map[string]bool) {
+	instance := InstanceEventStats{InstanceID: instanceID, Name: name}
+	for _, typeStats := range s.Types {
+		if subscriptions[typeStats.Type] {
+			instance.Count += typeStats.Count
+		}
+	}
+	s.Instances = append(s.Instances, instance)
+}
+
+// EventDBInitStats creates the aggregate tables, filling them from the event
+// log if they are new.
+func EventDBInitStats(db *sqlx.DB) error {
+	if _, err := db.Exec(eventStatsSchema); err != nil {
+		return err
+	}
+	var statsRows, events int
+	if err := db.Get(&statsRows, `SELECT COUNT(*) FROM event_type_stats_v1`); err != nil {
+		return err
+	}
+	if err := db.Get(&events, `SELECT COUNT(*) FROM event_v1`); err != nil {
+		return err
+	}
+	if statsRows == 0 && events > 0 {
+		return EventDBRebuildStats(db)
+	}
+	return nil
+}
+
+// recordEventStats adds an event to the aggregates within the transaction
+// that inserts it.
+func recordEventStats(tx *sql.Tx, eventType string, payloadBytes int, publishedAt time.Time) error {
+	if _, err := tx.Exec(incrementTypeStatsV1Sql, eventType, payloadBytes); err != nil {
+		return err
+	}
+	_, err := tx.Exec(incrementDayStatsV1Sql, publishedAt.UTC().Format(statsDayFormat), eventType)
+	return err
+}
+
+// EventDBRebuildStats recomputes the aggregates with a full scan of the event
+// log. Events published before the log recorded publish times are counted
+// by type but not by day.
+func EventDBRebuildStats(db *sqlx.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM event_type_stats_v1`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM event_day_stats_v1`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO event_type_stats_v1 (event_type, event_count, payload_bytes)
+		SELECT event_type, COUNT(*), SUM(LENGTH(event_data)) FROM event_v1 GROUP BY event_type`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT event_type, CAST(published_at AS TEXT) FROM event_v1 WHERE published_at IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	type dayType struct{ day, eventType string }
+	counts := make(map[dayType]int)
+	for rows.Next() {
+		var eventType string
+		var value sql.NullString
+		if err := rows.Scan(&eventType, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		publishedAt, err := parsePublishedAt(value)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		counts[dayType{publishedAt.UTC().Format(statsDayFormat), eventType}]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for key, count := range counts {
+		_, err := tx.Exec(`INSERT INTO event_day_stats_v1 (day, event_type, event_count) VALUES ($1, $2, $3)`,
+			key.day, key.eventType, count)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// EventDBGetStats reads the aggregates, with per-day counts for the last days
+// days.
+func EventDBGetStats(db *sqlx.DB, days int) (*EventStats, error) {
+	stats := &EventStats{
+		Types:     []EventTypeStats{},
+		Days:      []EventDayStats{},
+		Instances: []InstanceEventStats{},
+	}
+
+	rows, err := db.Query(getTypeStatsV1Sql)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var typeStats EventTypeStats
+		if err := rows.Scan(&typeStats.Type, &typeStats.Count, &typeStats.PayloadBytes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if typeStats.Count > 0 {
+			typeStats.AveragePayloadBytes = float64(typeStats.PayloadBytes) / float64(typeStats.Count)
+		}
+		stats.TotalEvents += typeStats.Count
+		stats.Types = append(stats.Types, typeStats)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(stats.Types, func(i, j int) bool {
+		if stats.Types[i].Count != stats.Types[j].Count {
+			return stats.Types[i].Count > stats.Types[j].Count
+		}
+		return stats.Types[i].Type < stats.Types[j].Type
+	})
+
+	if days <= 0 {
+		return stats, nil
+	}
+	today := now().UTC()
+	first := today.AddDate(0, 0, -(days - 1)).Format(statsDayFormat)
+	dayCounts := make(map[string]int64)
+	rows, err = db.Query(getDayStatsV1Sql, first)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day string
+		var count int64
+		if err := rows.Scan(&day, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dayCounts[day] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format(statsDayFormat)
+		stats.Days = append(stats.Days, EventDayStats{Day: day, Count: dayCounts[day]})
+	}
+	return stats, nil
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestEventStatsMatchRecount(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "events.db"))
+	defer db.Close()
+	em, err := CreateEventManager(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	// Publish a random sequence over ten days, including duplicates which
+	// must not be counted
+	rng := rand.New(rand.NewSource(1))
+	types := []string{"User:Add", "User:Delete", "Item:Add", "Item:Update"}
+	type published struct {
+		eventType string
+		size      int
+		day       string
+	}
+	var log []published
+	for i := 0; i < 500; i++ {
+		clock = clock.Add(time.Duration(rng.Intn(40)) * time.Minute)
+		eventType := types[rng.Intn(len(types))]
+		payload := []byte(fmt.Sprintf(`{"value":"%s"}`, strings.Repeat("x", rng.Intn(200))))
+		clientID := fmt.Sprintf("client-%d", i)
+		if i > 0 && rng.Intn(10) == 0 {
+			clientID = fmt.Sprintf("client-%d", rng.Intn(i))
+		}
+		_, err := em.PublishEvent(clientID, eventType, payload)
+		if _, duplicate := err.(*DuplicateEventError); duplicate {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, published{eventType, len(payload), clock.Format(statsDayFormat)})
+	}
+
+	// Brute-force recount of the published events
+	const days = 7
+	expected := map[string]*EventTypeStats{}
+	dayCounts := map[string]int64{}
+	for _, event := range log {
+		typeStats, ok := expected[event.eventType]
+		if !ok {
+			typeStats = &EventTypeStats{Type: event.eventType}
+			expected[event.eventType] = typeStats
+		}
+		typeStats.Count++
+		typeStats.PayloadBytes += int64(event.size)
+		dayCounts[event.day]++
+	}
+
+	check := func(name string) {
+		stats, err := em.Stats(days)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.TotalEvents != int64(len(log)) {
+			t.Errorf("%s: expected %d events, got %d", name, len(log), stats.TotalEvents)
+		}
+		if len(stats.Types) != len(expected) {
+			t.Errorf("%s: expected %d types, got %+v", name, len(expected), stats.Types)
+		}
+		for i, typeStats := range stats.Types {
+			want := expected[typeStats.Type]
+			if want == nil || typeStats.Count != want.Count || typeStats.PayloadBytes != want.PayloadBytes {
+				t.Errorf("%s: type %s has %+v, expected %+v", name, typeStats.Type, typeStats, want)
+				continue
+			}
+			if avg := float64(want.PayloadBytes) / float64(want.Count); typeStats.AveragePayloadBytes != avg {
+				t.Errorf("%s: type %s has average %f, expected %f", name, typeStats.Type, typeStats.AveragePayloadBytes, avg)
+			}
+			if i > 0 && stats.Types[i-1].Count < typeStats.Count {
+				t.Errorf("%s: types are not ordered by count: %+v", name, stats.Types)
+			}
+		}
+		var expectedDays []EventDayStats
+		for i := days - 1; i >= 0; i-- {
+			day := clock.AddDate(0, 0, -i).Format(statsDayFormat)
+			expectedDays = append(expectedDays, EventDayStats{Day: day, Count: dayCounts[day]})
+		}
+		if !reflect.DeepEqual(stats.Days, expectedDays) {
+			t.Errorf("%s: expected days %+v, got %+v", name, expectedDays, stats.Days)
+		}
+	}
+	check("incremental")
+
+	// A full rebuild from the event log agrees with the incremental aggregates
+	if err := EventDBRebuildStats(db); err != nil {
+		t.Fatal(err)
+	}
+	check("rebuilt")
+}
+
+func TestEventStatsInstances(t *testing.T) {
+	stats := &EventStats{Types: []EventTypeStats{
+		{Type: "User:Add", Count: 5},
+		{Type: "Item:Add", Count: 3},
+	}}
+	stats.AddInstance("admin", "User admin", map[string]bool{"User:Add": true, "User:Delete": true})
+	stats.AddInstance("items", "Items", map[string]bool{"User:Add": true, "Item:Add": true})
+	expected := []InstanceEventStats{
+		{InstanceID: "admin", Name: "User admin", Count: 5},
+		{InstanceID: "items", Name: "Items", Count: 8},
+	}
+	if !reflect.DeepEqual(stats.Instances, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, stats.Instances)
+	}
+}
@@ -0,0 +1,52 @@
+package access
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// ChangePasswordEventType is the Admin app event that sets a user's
+// password, the only event a restricted access token may publish.
+const ChangePasswordEventType = "User:UpdatePassword"
+
+// PasswordExpiredHeader is set on responses rejected because the access
+// token is restricted, so that clients can prompt for a new password.
+const PasswordExpiredHeader = "X-Password-Expired"
+
+// maxRestrictedBody bounds the publish request read to check its event.
+const maxRestrictedBody = 64 * 1024
+
+// AllowedWhileRestricted reports whether a request made with a restricted
+// access token issued to userID is allowed: publishing a
+// ChangePasswordEventType event for the same user. The request body is read
+// and replaced so that it can still be forwarded.
+func AllowedWhileRestricted(r *http.Request, userID int) bool {
+	if r.Method == http.MethodOptions {
+		return true
+	}
+	if r.URL.Path != "/events/publish" || r.Method != http.MethodPost || r.Body == nil {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRestrictedBody+1))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) > maxRestrictedBody {
+		return false
+	}
+
+	var publishData types.EventPublishData
+	if err := json.Unmarshal(body, &publishData); err != nil || publishData.Type != ChangePasswordEventType {
+		return false
+	}
+	var event struct {
+		UserID int `json:"userId"`
+	}
+	if err := json.Unmarshal(publishData.Data, &event); err != nil {
+		return false
+	}
+	return event.UserID == userID
+}
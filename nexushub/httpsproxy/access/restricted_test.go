@@ -0,0 +1,47 @@
+package access
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllowedWhileRestricted(t *testing.T) {
+	changeOwnPassword := `{"clientId":"c1","type":"User:UpdatePassword","data":{"userId":7,"newPassword":"Correct-horse-7"}}`
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		allowed bool
+	}{
+		{"change own password", http.MethodPost, "/events/publish", changeOwnPassword, true},
+		{"preflight", http.MethodOptions, "/events/publish", "", true},
+		{"change another user's password", http.MethodPost, "/events/publish", strings.Replace(changeOwnPassword, `"userId":7`, `"userId":1`, 1), false},
+		{"other event", http.MethodPost, "/events/publish", `{"type":"User:Delete","data":{"userId":7}}`, false},
+		{"malformed event", http.MethodPost, "/events/publish", `{"type":`, false},
+		{"oversized event", http.MethodPost, "/events/publish", changeOwnPassword + strings.Repeat(" ", maxRestrictedBody), false},
+		{"application API", http.MethodGet, "/MBtskI6D/api/users", "", false},
+		{"other hub API", http.MethodGet, "/apps/list", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if allowed := AllowedWhileRestricted(req, 7); allowed != tc.allowed {
+				t.Errorf("Expected allowed=%v, got %v", tc.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestAllowedWhileRestrictedKeepsBody(t *testing.T) {
+	body := `{"type":"User:UpdatePassword","data":{"userId":7,"newPassword":"Correct-horse-7"}}`
+	req := httptest.NewRequest(http.MethodPost, "/events/publish", strings.NewReader(body))
+	if !AllowedWhileRestricted(req, 7) {
+		t.Fatal("Expected the password change to be allowed")
+	}
+	if forwarded, _ := io.ReadAll(req.Body); string(forwarded) != body {
+		t.Errorf("Expected the body to be forwarded unchanged, got %q", forwarded)
+	}
+}
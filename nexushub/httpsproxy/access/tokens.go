@@ -1,42 +1,195 @@
 package access
 
 import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
 	"github.com/tomyedwab/yesterday/nexushub/types"
 )
 
+// DefaultCacheSize bounds the number of access tokens held in memory. When
+// the cache is full the least recently used token is dropped, and its holder
+// has to exchange its refresh token for a new one.
+const DefaultCacheSize = 10000
+
+// AccessToken is an issued access token, as remembered by the proxy. Access
+// tokens are opaque, so the proxy can only accept tokens it issued itself.
 type AccessToken struct {
-	AccessToken string
-	SessionID   string
-	Expiry      int64
+	UserID int
+	Expiry int64
+	// Restricted tokens were issued to a user whose password has expired,
+	// and may only be used to change it, see AllowedWhileRestricted
+	Restricted bool
+}
+
+var (
+	// expiryLeeway is how long past its expiry an access token is still
+	// accepted, to tolerate small wall clock steps.
+	expiryLeeway = clock.DefaultSkewLeeway
+	// tokenClock is the clock token expiry is checked against.
+	tokenClock clock.Clock = clock.Real
+)
+
+// SetExpiryLeeway sets how long past its expiry an access token is still
+// accepted.
+func SetExpiryLeeway(leeway time.Duration) {
+	expiryLeeway = leeway
 }
 
-var AccessTokenStore = make(map[string]AccessToken)
+// SetClock replaces the clock token expiry is checked against, for tests.
+func SetClock(c clock.Clock) {
+	tokenClock = c
+}
 
-func CreateAccessToken(response *types.AccessTokenResponse) {
-	AccessTokenStore[response.AccessToken] = AccessToken{
-		AccessToken: response.AccessToken,
-		Expiry:      response.Expiry,
+// tokenCache is an LRU cache of issued access tokens keyed by the SHA-256
+// fingerprint of the token, so raw tokens are never kept in memory.
+type tokenCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	// order holds the entries from most to least recently used
+	order *list.List
+}
+
+type cacheEntry struct {
+	fingerprint string
+	token       AccessToken
+}
+
+func newTokenCache(capacity int) *tokenCache {
+	return &tokenCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
 	}
 }
 
-func ValidateAccessToken(token string, auditLogger *audit.Logger) bool {
-	_, ok := AccessTokenStore[token]
+var tokens = newTokenCache(DefaultCacheSize)
+
+func fingerprint(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *tokenCache) add(token string, accessToken AccessToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := fingerprint(token)
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cacheEntry).token = accessToken
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{fingerprint: key, token: accessToken})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// get returns the token if it is known. Tokens that expired more than leeway
+// before now are removed and reported with expired set.
+func (c *tokenCache) get(token string, now time.Time, leeway time.Duration) (accessToken AccessToken, ok, expired bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[fingerprint(token)]
 	if !ok {
+		return AccessToken{}, false, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if now.Sub(time.Unix(entry.token.Expiry, 0)) > leeway {
+		c.remove(element)
+		return AccessToken{}, false, true
+	}
+	c.order.MoveToFront(element)
+	return entry.token, true, false
+}
+
+// revokeUser removes every token issued to the user.
+func (c *tokenCache) revokeUser(userID int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	revoked := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cacheEntry).token.UserID == userID {
+			c.remove(element)
+			revoked++
+		}
+		element = next
+	}
+	return revoked
+}
+
+// revoke removes the token if it was issued to the user.
+func (c *tokenCache) revoke(token string, userID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[fingerprint(token)]
+	if !ok || element.Value.(*cacheEntry).token.UserID != userID {
 		return false
 	}
-	if time.Now().Unix() > AccessTokenStore[token].Expiry {
+	c.remove(element)
+	return true
+}
+
+func (c *tokenCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).fingerprint)
+}
+
+// CreateAccessToken remembers a newly issued access token for the user until
+// it expires.
+func CreateAccessToken(response *types.AccessTokenResponse, userID int) {
+	tokens.add(response.AccessToken, AccessToken{
+		UserID: userID,
+		Expiry: response.Expiry,
+	})
+}
+
+// CreateRestrictedAccessToken is like CreateAccessToken, but the token may
+// only be used to change the user's password.
+func CreateRestrictedAccessToken(response *types.AccessTokenResponse, userID int) {
+	tokens.add(response.AccessToken, AccessToken{
+		UserID:     userID,
+		Expiry:     response.Expiry,
+		Restricted: true,
+	})
+}
+
+// RevokeUserTokens invalidates every access token issued to the user, e.g.
+// when the user logs out and their sessions are deleted.
+func RevokeUserTokens(userID int) int {
+	return tokens.revokeUser(userID)
+}
+
+// RevokeToken invalidates a single access token if it was issued to the
+// user, e.g. when the user logs out on one device.
+func RevokeToken(token string, userID int) bool {
+	return tokens.revoke(token, userID)
+}
+
+// LookupAccessToken returns the access token if it was issued by this proxy
+// and has not expired or been revoked.
+func LookupAccessToken(token string, auditLogger *audit.Logger) (AccessToken, bool) {
+	accessToken, ok, expired := tokens.get(token, tokenClock.Now(), expiryLeeway)
+	if expired {
 		// Log access token expiry
 		if auditLogger != nil {
 			if err := auditLogger.LogAccessTokenExpiry(token); err != nil {
 				fmt.Printf("Failed to log access token expiry audit event: %v\n", err)
 			}
 		}
-		delete(AccessTokenStore, token)
-		return false
 	}
-	return true
+	return accessToken, ok
+}
+
+func ValidateAccessToken(token string, auditLogger *audit.Logger) bool {
+	_, ok := LookupAccessToken(token, auditLogger)
+	return ok
 }
@@ -0,0 +1,88 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func TestAccessTokenExpiry(t *testing.T) {
+	cache := newTokenCache(10)
+	now := time.Now()
+	cache.add("valid", AccessToken{UserID: 1, Expiry: now.Add(time.Minute).Unix()})
+	cache.add("stale", AccessToken{UserID: 1, Expiry: now.Add(-time.Second).Unix()})
+
+	if _, ok, _ := cache.get("valid", now, 0); !ok {
+		t.Error("Expected unexpired token to validate")
+	}
+	if _, ok, expired := cache.get("stale", now, 0); ok || !expired {
+		t.Errorf("Expected expired token to be rejected as expired, got ok=%v expired=%v", ok, expired)
+	}
+	// Expired tokens are dropped, so a second attempt is simply unknown
+	if _, ok, expired := cache.get("stale", now, 0); ok || expired {
+		t.Errorf("Expected expired token to be forgotten, got ok=%v expired=%v", ok, expired)
+	}
+	if _, ok, _ := cache.get("unknown", now, 0); ok {
+		t.Error("Expected unknown token to be rejected")
+	}
+}
+
+func TestAccessTokenToleratesClockStep(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	SetClock(fake)
+	t.Cleanup(func() { SetClock(clock.Real) })
+	CreateAccessToken(&types.AccessTokenResponse{AccessToken: "skewed", Expiry: fake.Now().Add(time.Minute).Unix()}, 1)
+
+	// A step within the leeway past the expiry is tolerated
+	fake.Step(time.Minute + clock.DefaultSkewLeeway/2)
+	if !ValidateAccessToken("skewed", nil) {
+		t.Error("Expected a token within the expiry leeway to validate")
+	}
+	fake.Step(clock.DefaultSkewLeeway)
+	if ValidateAccessToken("skewed", nil) {
+		t.Error("Expected a token past the expiry leeway to be rejected")
+	}
+}
+
+func TestAccessTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTokenCache(2)
+	now := time.Now()
+	expiry := now.Add(time.Minute).Unix()
+	cache.add("a", AccessToken{Expiry: expiry})
+	cache.add("b", AccessToken{Expiry: expiry})
+	cache.get("a", now, 0)
+	cache.add("c", AccessToken{Expiry: expiry})
+
+	if _, ok, _ := cache.get("b", now, 0); ok {
+		t.Error("Expected least recently used token to be evicted")
+	}
+	for _, token := range []string{"a", "c"} {
+		if _, ok, _ := cache.get(token, now, 0); !ok {
+			t.Errorf("Expected token %s to be kept", token)
+		}
+	}
+	for key := range cache.entries {
+		if key == "a" || key == "c" {
+			t.Error("Expected tokens to be keyed by fingerprint")
+		}
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	expiry := time.Now().Add(time.Minute).Unix()
+	CreateAccessToken(&types.AccessTokenResponse{AccessToken: "user-1-a", Expiry: expiry}, 1)
+	CreateAccessToken(&types.AccessTokenResponse{AccessToken: "user-1-b", Expiry: expiry}, 1)
+	CreateAccessToken(&types.AccessTokenResponse{AccessToken: "user-2", Expiry: expiry}, 2)
+
+	if revoked := RevokeUserTokens(1); revoked != 2 {
+		t.Errorf("Expected 2 tokens revoked, got %d", revoked)
+	}
+	if ValidateAccessToken("user-1-a", nil) || ValidateAccessToken("user-1-b", nil) {
+		t.Error("Expected revoked tokens to be rejected")
+	}
+	if !ValidateAccessToken("user-2", nil) {
+		t.Error("Expected other users' tokens to stay valid")
+	}
+}
@@ -0,0 +1,156 @@
+package httpsproxy
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/eventauth"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// Authorizer decides whether a request may reach the route it is for, by the
+// route's class (see routeClasses) and the credentials the request carries.
+type Authorizer struct {
+	secrets *secrets.Store
+	// clientCert returns the subject of a verified client certificate that
+	// authorizes the request; nil accepts no client certificates.
+	clientCert func(*http.Request) (string, bool)
+	// roles are the users' roles, for RouteAdmin routes
+	roles eventauth.Roles
+}
+
+// NewAuthorizer returns an Authorizer accepting the internal secrets in
+// secretStore and the client certificates reported by clientCert, which may
+// be nil.
+func NewAuthorizer(secretStore *secrets.Store, clientCert func(*http.Request) (string, bool)) *Authorizer {
+	return &Authorizer{secrets: secretStore, clientCert: clientCert}
+}
+
+// SetRoles sets the users' roles. Users with eventauth.RoleAdmin may reach
+// RouteAdmin routes; without roles, only internal requests can.
+func (a *Authorizer) SetRoles(roles eventauth.Roles) {
+	a.roles = roles
+}
+
+// Decision is the outcome of authorizing a request.
+type Decision struct {
+	// Pattern and Class identify the route the request matched
+	Pattern string
+	Class   RouteClass
+	// Status is zero if the request is allowed, otherwise the status it is
+	// refused with
+	Status int
+	// Reason explains a refusal in the proxy log
+	Reason string
+	// PasswordExpired is set when a restricted access token was refused
+	PasswordExpired bool
+	// Token is the access token the request was authorized by. It is empty
+	// for the internal secret, client certificates and routes that check no
+	// credentials.
+	Token access.AccessToken
+	// Internal is set for requests authorized by the internal secret or a
+	// client certificate
+	Internal bool
+	// Admin is set for internal requests and access tokens of users with
+	// the admin role
+	Admin bool
+}
+
+// Allowed reports whether the request may reach its route.
+func (d Decision) Allowed() bool {
+	return d.Status == 0
+}
+
+// credential is the kind of credential a request carries.
+type credential int
+
+const (
+	credentialNone credential = iota
+	credentialInvalid
+	credentialToken
+	credentialInternal
+)
+
+// Authorize decides the request. It does not write a response.
+func (a *Authorizer) Authorize(r *http.Request) Decision {
+	pattern, class, ok := routeClasses.match(r.URL.Path)
+	if !ok {
+		return Decision{Status: http.StatusNotFound, Reason: "No route found"}
+	}
+	decision := Decision{Pattern: pattern, Class: class}
+	refuse := func(status int, reason string) Decision {
+		decision.Status = status
+		decision.Reason = reason
+		return decision
+	}
+
+	// Login endpoints check their own credentials, and CORS preflights carry
+	// none
+	if class == RoutePublic || class == RouteCookieAuth || r.Method == http.MethodOptions {
+		return decision
+	}
+
+	token, kind := a.credentials(r)
+	switch kind {
+	case credentialNone:
+		if class == RouteDebug {
+			return decision
+		}
+		return refuse(http.StatusUnauthorized, "Missing token")
+	case credentialInvalid:
+		return refuse(http.StatusUnauthorized, "Invalid token")
+	case credentialInternal:
+		decision.Internal = true
+		decision.Admin = true
+		return decision
+	}
+
+	if class == RouteInternalOnly {
+		return refuse(http.StatusForbidden, "Not an internal request")
+	}
+	decision.Token = token
+	decision.Admin = a.roles.Has(token.UserID, eventauth.RoleAdmin)
+	if class == RouteAdmin && !decision.Admin {
+		return refuse(http.StatusForbidden, "Not an admin")
+	}
+	// A user whose password has expired may only change it
+	if token.Restricted && !access.AllowedWhileRestricted(r, token.UserID) {
+		decision.PasswordExpired = true
+		return refuse(http.StatusForbidden, "Password expired")
+	}
+	return decision
+}
+
+// credentials identifies the credential the request carries: a verified
+// client certificate, the internal secret or an access token issued by this
+// proxy, returned for access tokens. Checking an expired access token records
+// an audit event with the request's audit logger.
+func (a *Authorizer) credentials(r *http.Request) (access.AccessToken, credential) {
+	// A verified client certificate stands in for the internal secret
+	if a.clientCert != nil {
+		if subject, ok := a.clientCert(r); ok {
+			log.Printf("Authorized %s by client certificate %s", r.URL.Path, subject)
+			return access.AccessToken{}, credentialInternal
+		}
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return access.AccessToken{}, credentialNone
+	}
+	if a.secrets != nil && a.secrets.Validate(token) {
+		return access.AccessToken{}, credentialInternal
+	}
+	// Get audit logger from context (may be nil if not set)
+	var auditLogger *audit.Logger
+	if al := r.Context().Value(audit.AuditLoggerKey); al != nil {
+		auditLogger = al.(*audit.Logger)
+	}
+	accessToken, ok := access.LookupAccessToken(token, auditLogger)
+	if !ok {
+		return access.AccessToken{}, credentialInvalid
+	}
+	return accessToken, credentialToken
+}
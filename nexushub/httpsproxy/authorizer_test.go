@@ -0,0 +1,317 @@
+package httpsproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/crashes"
+	"github.com/tomyedwab/yesterday/nexushub/eventauth"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/internal/handlers"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// conformanceRequest is a request the matrix sends to a route of each class,
+// chosen so that it succeeds once it reaches the route's handler.
+type conformanceRequest struct {
+	method, path, body string
+	// served is the status of the request once it reaches the handler
+	served int
+}
+
+var conformanceRequests = map[RouteClass]conformanceRequest{
+	RoutePublic: {http.MethodGet, "/healthz", "", http.StatusOK},
+	// Without a refresh token cookie the logout handler refuses the request
+	// itself, whatever bearer credentials are sent
+	RouteCookieAuth: {http.MethodPost, "/public/logout", "", http.StatusBadRequest},
+	// The conformance proxy has no shadows, so the handler answers 404
+	RouteBearerAuth:   {http.MethodGet, "/apps/abc/shadow-report", "", http.StatusNotFound},
+	RouteAdmin:        {http.MethodPost, "/secrets/rotate", "", http.StatusNoContent},
+	RouteInternalOnly: {http.MethodPost, "/internal/crash-reports", `{"stack":"panic: test"}`, http.StatusOK},
+	RouteDebug: {http.MethodPost, "/debug/application",
+		`{"appId":"a","displayName":"A","hostName":"a.example.com","dbName":"a"}`, http.StatusCreated},
+}
+
+// credentialCase sets one kind of credential on a request.
+type credentialCase struct {
+	name  string
+	apply func(p *Proxy, r *http.Request)
+}
+
+func bearer(r *http.Request, token string) {
+	r.Header.Set("Authorization", "Bearer "+token)
+}
+
+// newToken issues a fresh access token for user 5, so that each request
+// sees an unused token even after an expired one has been removed.
+func newToken(expiry time.Time, restricted bool) string {
+	response := &types.AccessTokenResponse{AccessToken: uuid.New().String(), Expiry: expiry.Unix()}
+	if restricted {
+		access.CreateRestrictedAccessToken(response, 5)
+	} else {
+		access.CreateAccessToken(response, 5)
+	}
+	return response.AccessToken
+}
+
+// adminUserID is the user given the admin role in the tests.
+const adminUserID = 1
+
+// newUserToken issues a fresh access token for the user.
+func newUserToken(userID int) string {
+	response := &types.AccessTokenResponse{AccessToken: uuid.New().String(), Expiry: time.Now().Add(time.Hour).Unix()}
+	access.CreateAccessToken(response, userID)
+	return response.AccessToken
+}
+
+var credentialCases = []credentialCase{
+	{"none", func(p *Proxy, r *http.Request) {}},
+	{"expired token", func(p *Proxy, r *http.Request) {
+		bearer(r, newToken(time.Now().Add(-time.Hour), false))
+	}},
+	{"user token", func(p *Proxy, r *http.Request) {
+		bearer(r, newToken(time.Now().Add(time.Hour), false))
+	}},
+	// Restricted tokens stand in for a token without the required role
+	{"restricted token", func(p *Proxy, r *http.Request) {
+		bearer(r, newToken(time.Now().Add(time.Hour), true))
+	}},
+	{"admin token", func(p *Proxy, r *http.Request) {
+		bearer(r, newUserToken(adminUserID))
+	}},
+	{"client certificate", func(p *Proxy, r *http.Request) {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "app-1"}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}},
+	{"internal secret", func(p *Proxy, r *http.Request) {
+		bearer(r, p.secrets.Current())
+	}},
+}
+
+// outcome is the expected result of a request: its status, or reachesHandler
+// for the status of its conformanceRequest, and the audit event it records.
+type outcome struct {
+	status int
+	event  audit.EventType
+}
+
+const reachesHandler = -1
+
+var (
+	allowed = outcome{status: reachesHandler}
+	expired = outcome{status: http.StatusUnauthorized, event: audit.EventAccessTokenExpiry}
+)
+
+// conformanceMatrix lists the expected outcome for each route class, in the
+// order of credentialCases.
+var conformanceMatrix = map[RouteClass][]outcome{
+	RoutePublic:     {allowed, allowed, allowed, allowed, allowed, allowed, allowed},
+	RouteCookieAuth: {allowed, allowed, allowed, allowed, allowed, allowed, allowed},
+	RouteBearerAuth: {
+		{status: http.StatusUnauthorized}, expired, allowed,
+		{status: http.StatusForbidden}, allowed, allowed, allowed,
+	},
+	RouteAdmin: {
+		{status: http.StatusUnauthorized}, expired, {status: http.StatusForbidden},
+		{status: http.StatusForbidden}, allowed, allowed, allowed,
+	},
+	RouteInternalOnly: {
+		{status: http.StatusUnauthorized}, expired, {status: http.StatusForbidden},
+		{status: http.StatusForbidden}, {status: http.StatusForbidden}, allowed, allowed,
+	},
+	RouteDebug: {
+		allowed, expired, allowed,
+		{status: http.StatusForbidden}, allowed, allowed, allowed,
+	},
+}
+
+// newConformanceProxy returns a proxy able to serve each conformance request
+// and a context carrying the audit logger and session manager.
+func newConformanceProxy(t *testing.T) (*Proxy, *audit.Logger, context.Context) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "hub.db"))
+	t.Cleanup(func() { db.Close() })
+	auditLogger, err := audit.NewLogger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionManager, err := sessions.NewManager(db, 15*time.Minute, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashStore, err := crashes.NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p := &Proxy{
+		secrets:      secrets.NewStore(time.Minute),
+		debugHandler: handlers.NewDebugHandler(nil, logger, nil),
+	}
+	p.SetCrashStore(crashStore)
+	p.SetUserRoles(eventauth.Roles{adminUserID: {eventauth.RoleAdmin}})
+	if err := p.SetMTLS(&MTLSConfig{ListenAddr: ":0", ClientCAs: x509.NewCertPool()}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), audit.AuditLoggerKey, auditLogger)
+	ctx = context.WithValue(ctx, sessions.SessionManagerKey, sessionManager)
+	return p, auditLogger, ctx
+}
+
+func TestAuthorizationConformance(t *testing.T) {
+	p, auditLogger, ctx := newConformanceProxy(t)
+
+	// Every declared class is covered
+	for _, class := range routeClasses {
+		if _, ok := conformanceMatrix[class]; !ok {
+			t.Fatalf("Route class %s has no row in the conformance matrix", class)
+		}
+	}
+
+	for class, request := range conformanceRequests {
+		if pattern, routeClass, _ := routeClasses.match(request.path); routeClass != class {
+			t.Fatalf("Expected %s to be a %s route, matched %s (%s)", request.path, class, pattern, routeClass)
+		}
+		for i, credential := range credentialCases {
+			t.Run(string(class)+"/"+credential.name, func(t *testing.T) {
+				want := conformanceMatrix[class][i]
+				if want.status == reachesHandler {
+					want.status = request.served
+				}
+				before, err := auditLogger.GetRecentEvents(1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				r := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body)).WithContext(ctx)
+				r.Header.Set("X-Application-Id", "app-1")
+				credential.apply(p, r)
+				recorder := httptest.NewRecorder()
+				p.handleRequest(recorder, r)
+				if recorder.Code != want.status {
+					t.Errorf("Expected %d, got %d: %s", want.status, recorder.Code, recorder.Body.String())
+				}
+
+				after, err := auditLogger.GetRecentEvents(1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var events []string
+				for _, event := range after[:len(after)-len(before)] {
+					events = append(events, event.EventType)
+				}
+				if want.event == "" && len(events) != 0 {
+					t.Errorf("Expected no audit events, got %v", events)
+				}
+				if want.event != "" && (len(events) != 1 || events[0] != string(want.event)) {
+					t.Errorf("Expected a %s audit event, got %v", want.event, events)
+				}
+			})
+		}
+	}
+}
+
+func TestRestrictedTokenMayChangePassword(t *testing.T) {
+	a := NewAuthorizer(secrets.NewStore(time.Minute), nil)
+	token := newToken(time.Now().Add(time.Hour), true)
+
+	body := `{"type":"` + access.ChangePasswordEventType + `","data":{"userId":5}}`
+	r := httptest.NewRequest(http.MethodPost, "/events/publish", strings.NewReader(body))
+	bearer(r, token)
+	if decision := a.Authorize(r); !decision.Allowed() || decision.Token.UserID != 5 {
+		t.Errorf("Expected the password change to be allowed for user 5, got %+v", decision)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/apps/list", nil)
+	bearer(r, token)
+	if decision := a.Authorize(r); decision.Status != http.StatusForbidden || !decision.PasswordExpired {
+		t.Errorf("Expected other requests to be refused with an expired password, got %+v", decision)
+	}
+}
+
+func TestPreflightNeedsNoCredentials(t *testing.T) {
+	a := NewAuthorizer(secrets.NewStore(time.Minute), nil)
+	for _, path := range []string{"/apps/list", "/internal/crash-reports", "/app/api/items"} {
+		if decision := a.Authorize(httptest.NewRequest(http.MethodOptions, path, nil)); !decision.Allowed() {
+			t.Errorf("Expected a preflight for %s to be allowed, got %+v", path, decision)
+		}
+	}
+}
+
+func TestIntrospectionNeedsInternalCredentials(t *testing.T) {
+	store := secrets.NewStore(time.Minute)
+	a := NewAuthorizer(store, nil)
+	r := httptest.NewRequest(http.MethodPost, "/public/introspect", nil)
+	bearer(r, newToken(time.Now().Add(time.Hour), false))
+	if decision := a.Authorize(r); decision.Status != http.StatusForbidden {
+		t.Errorf("Expected an access token to be refused, got %+v", decision)
+	}
+	bearer(r, store.Current())
+	if decision := a.Authorize(r); !decision.Allowed() || decision.Class != RouteInternalOnly {
+		t.Errorf("Expected the internal secret to be allowed, got %+v", decision)
+	}
+}
+
+func TestAdminRoutesNeedAdminRole(t *testing.T) {
+	store := secrets.NewStore(time.Minute)
+	a := NewAuthorizer(store, nil)
+	a.SetRoles(eventauth.Roles{adminUserID: {eventauth.RoleAdmin}, 2: {"support"}})
+	adminPaths := []string{
+		"/secrets/rotate",
+		"/tls/rotate-ca",
+		"/apps/admin/package",
+		"/apps/admin/database",
+		"/apps/admin/clone",
+		"/apps/admin/quota",
+		"/apps/usage",
+		"/events/stats",
+		"/debug/trace/abc",
+		"/apps/admin/restart",
+		"/apps/admin/loglevel",
+		"/apps/crashes",
+		"/apps/desired-state",
+		"/apps/admin/probe/db",
+		"/metrics",
+	}
+	for _, path := range adminPaths {
+		for _, tc := range []struct {
+			name    string
+			token   string
+			allowed bool
+		}{
+			{"user token", newUserToken(2), false},
+			{"admin token", newUserToken(adminUserID), true},
+			{"internal secret", store.Current(), true},
+		} {
+			r := httptest.NewRequest(http.MethodPost, path, nil)
+			bearer(r, tc.token)
+			decision := a.Authorize(r)
+			if tc.allowed != decision.Allowed() || (!tc.allowed && decision.Status != http.StatusForbidden) {
+				t.Errorf("%s with %s: expected allowed=%v, got %+v", path, tc.name, tc.allowed, decision)
+			}
+		}
+	}
+
+	// The admin role only matters on admin routes
+	r := httptest.NewRequest(http.MethodGet, "/apps/list", nil)
+	bearer(r, newUserToken(2))
+	if decision := a.Authorize(r); !decision.Allowed() || decision.Admin {
+		t.Errorf("Expected a user token to be allowed as a non-admin, got %+v", decision)
+	}
+}
@@ -0,0 +1,295 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+const (
+	// DefaultCloneTTL is how long a clone lives unless the request asks for
+	// another TTL.
+	DefaultCloneTTL = 24 * time.Hour
+	// MaxCloneTTL is the longest TTL a clone may be given.
+	MaxCloneTTL = 7 * 24 * time.Hour
+	// DefaultCloneHostSuffix is appended to the first label of the source's
+	// host name to name a clone's host, e.g. tasks-clone.example.com.
+	DefaultCloneHostSuffix = "-clone"
+)
+
+// CloneRequest is the body of POST /apps/{id}/clone. Every field is
+// optional.
+type CloneRequest struct {
+	// TTL is how long the clone lives, e.g. "2h"; DefaultCloneTTL if empty
+	TTL string `json:"ttl"`
+	// Sandbox suppresses the clone's cross-service calls to other
+	// applications; true if unset
+	Sandbox *bool `json:"sandbox"`
+	// HostName overrides the host name derived from the source's
+	HostName string `json:"hostName"`
+}
+
+// CloneResponse describes a new clone.
+type CloneResponse struct {
+	InstanceID string    `json:"instanceId"`
+	CloneOf    string    `json:"cloneOf"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	HostName   string    `json:"hostName,omitempty"`
+	Sandbox    bool      `json:"sandbox"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// SetCloneHostSuffix sets the suffix clone host names are derived with,
+// DefaultCloneHostSuffix by default.
+func (p *Proxy) SetCloneHostSuffix(suffix string) {
+	p.cloneHostSuffix = suffix
+}
+
+// CloneHostSuffixFromEnv reads the clone host name suffix from the
+// CLONE_HOST_SUFFIX environment variable, defaulting to
+// DefaultCloneHostSuffix.
+func CloneHostSuffixFromEnv() (string, error) {
+	suffix := os.Getenv("CLONE_HOST_SUFFIX")
+	if suffix == "" {
+		return DefaultCloneHostSuffix, nil
+	}
+	suffix = strings.ToLower(suffix)
+	for _, c := range suffix {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", fmt.Errorf("invalid CLONE_HOST_SUFFIX %q: only letters, digits and '-' are allowed", suffix)
+		}
+	}
+	return suffix, nil
+}
+
+// handleClone serves /apps/{id}/clone: POST clones the instance and DELETE
+// removes the instance, which must be a clone, before it expires.
+func (p *Proxy) handleClone(w http.ResponseWriter, r *http.Request) {
+	instanceID := strings.Split(r.URL.Path, "/")[2]
+	switch r.Method {
+	case http.MethodPost:
+		p.createClone(w, r, instanceID)
+	case http.MethodDelete:
+		p.deleteClone(w, r, instanceID)
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *Proxy) createClone(w http.ResponseWriter, r *http.Request, sourceID string) {
+	var request CloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid clone request: %v", err), http.StatusBadRequest)
+		return
+	}
+	ttl := DefaultCloneTTL
+	if request.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(request.TTL)
+		if err != nil || ttl <= 0 || ttl > MaxCloneTTL {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid ttl %q: must be a positive duration of at most %s", request.TTL, MaxCloneTTL), http.StatusBadRequest)
+			return
+		}
+	}
+	sandbox := request.Sandbox == nil || *request.Sandbox
+
+	source, err := p.packageManager.GetPackageByInstanceID(sourceID)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to look up package info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if source == nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("application %s not found", sourceID), http.StatusNotFound)
+		return
+	}
+
+	cloneID := packages.NewInstanceID()
+	hostName, err := p.reserveCloneHost(sourceID, cloneID, request.HostName)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusConflict)
+		return
+	}
+	options := packages.CloneOptions{
+		HostName:  hostName,
+		Sandbox:   sandbox,
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+	}
+	if err := p.packageManager.CloneInstance(sourceID, cloneID, options, p.pm); err != nil {
+		p.releaseCloneHost(cloneID)
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to clone %s: %v", sourceID, err), http.StatusInternalServerError)
+		return
+	}
+	p.debugHandler.AddClone(cloneID, sourceID, "Clone of "+source.Name, hostName)
+	log.Printf("Cloned %s as %s (host %q, sandbox %v, expires %s)", sourceID, cloneID, hostName, sandbox, options.ExpiresAt.Format(time.RFC3339))
+
+	httputils.HandleAPIResponse(w, r, CloneResponse{
+		InstanceID: cloneID,
+		CloneOf:    sourceID,
+		Name:       source.Name,
+		Version:    source.Version,
+		HostName:   hostName,
+		Sandbox:    sandbox,
+		ExpiresAt:  options.ExpiresAt,
+	}, nil, http.StatusOK)
+}
+
+func (p *Proxy) deleteClone(w http.ResponseWriter, r *http.Request, instanceID string) {
+	err := p.packageManager.RemoveClone(instanceID, p.pm)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("application %s not found", instanceID), http.StatusNotFound)
+		return
+	case errors.Is(err, packages.ErrNotClone):
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("application %s is not a clone", instanceID), http.StatusBadRequest)
+		return
+	case err != nil:
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to remove clone %s: %v", instanceID, err), http.StatusInternalServerError)
+		return
+	}
+	p.cloneRemoved(instanceID)
+	httputils.HandleAPIResponse(w, r, map[string]string{
+		"instanceId": instanceID,
+	}, nil, http.StatusOK)
+}
+
+// RunCloneExpiry uninstalls clones once they expire, checking every
+// interval until ctx is done.
+func (p *Proxy) RunCloneExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.expireClones(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireClones removes the clones that expired by now.
+func (p *Proxy) expireClones(now time.Time) {
+	removed, err := p.packageManager.UninstallExpiredClones(now, p.pm)
+	for _, instanceID := range removed {
+		p.cloneRemoved(instanceID)
+		log.Printf("Removed expired clone %s", instanceID)
+	}
+	if err != nil {
+		log.Printf("Failed to remove expired clones: %v", err)
+	}
+}
+
+// cloneRemoved forgets a removed clone's host name and debug application.
+func (p *Proxy) cloneRemoved(instanceID string) {
+	p.releaseCloneHost(instanceID)
+	p.debugHandler.RemoveClone(instanceID)
+}
+
+// loadCloneHosts routes the host names of the installed clones.
+func (p *Proxy) loadCloneHosts() {
+	if p.packageManager == nil {
+		return
+	}
+	clones, err := p.packageManager.GetClones()
+	if err != nil {
+		log.Printf("Failed to load clone host names: %v", err)
+		return
+	}
+	p.cloneHostsMu.Lock()
+	defer p.cloneHostsMu.Unlock()
+	p.cloneHosts = make(map[string]string)
+	for _, clone := range clones {
+		if clone.HostName != "" {
+			p.cloneHosts[clone.HostName] = clone.InstanceID
+		}
+	}
+}
+
+// reserveCloneHost routes a host name to the clone: requested if set,
+// otherwise derived from the source's host name. Clones of instances without
+// a host name get none unless one is requested.
+func (p *Proxy) reserveCloneHost(sourceID, cloneID, requested string) (string, error) {
+	p.cloneHostsMu.Lock()
+	defer p.cloneHostsMu.Unlock()
+	if p.cloneHosts == nil {
+		p.cloneHosts = make(map[string]string)
+	}
+
+	if requested != "" {
+		host := normalizeHost(requested)
+		if host == "" || p.hostRoutedLocked(host) {
+			return "", fmt.Errorf("host name %s is already in use", requested)
+		}
+		p.cloneHosts[host] = cloneID
+		return host, nil
+	}
+
+	// Use the first of the source's host names, so repeated clones are named
+	// alike
+	sourceHost := ""
+	for _, routes := range []map[string]string{p.hostRoutes, p.cloneHosts} {
+		for host, instanceID := range routes {
+			if instanceID == sourceID && (sourceHost == "" || host < sourceHost) {
+				sourceHost = host
+			}
+		}
+	}
+	if sourceHost == "" {
+		return "", nil
+	}
+	suffix := p.cloneHostSuffix
+	if suffix == "" {
+		suffix = DefaultCloneHostSuffix
+	}
+	label, domain, _ := strings.Cut(sourceHost, ".")
+	for n := 1; ; n++ {
+		host := label + suffix
+		if n > 1 {
+			host += "-" + strconv.Itoa(n)
+		}
+		if domain != "" {
+			host += "." + domain
+		}
+		if !p.hostRoutedLocked(host) {
+			p.cloneHosts[host] = cloneID
+			return host, nil
+		}
+	}
+}
+
+// releaseCloneHost stops routing the clone's host name.
+func (p *Proxy) releaseCloneHost(cloneID string) {
+	p.cloneHostsMu.Lock()
+	defer p.cloneHostsMu.Unlock()
+	for host, instanceID := range p.cloneHosts {
+		if instanceID == cloneID {
+			delete(p.cloneHosts, host)
+		}
+	}
+}
+
+// hostRoutedLocked reports whether the host is the hub's or already routed
+// to an instance. The caller holds cloneHostsMu.
+func (p *Proxy) hostRoutedLocked(host string) bool {
+	if host == normalizeHost(p.host) {
+		return true
+	}
+	if _, ok := p.hostRoutes[host]; ok {
+		return true
+	}
+	_, ok := p.cloneHosts[host]
+	return ok
+}
@@ -0,0 +1,194 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/internal/handlers"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+// newCloneTestProxy returns a proxy serving instance "abc" on
+// tasks.example.com.
+func newCloneTestProxy(t *testing.T) *Proxy {
+	installDir := t.TempDir()
+	packageManager, err := packages.OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { packageManager.DB.Close() })
+	binary := filepath.Join(installDir, "abc", "app", "bin", "app")
+	if err := os.MkdirAll(filepath.Dir(binary), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(binary, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := packages.PackageDBInsert(packageManager.DB, "abc", "hash", "tasks", "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	pm := &staticProcessManager{packages: packageManager}
+	p := &Proxy{
+		host:           "hub.example.com",
+		pm:             pm,
+		packageManager: packageManager,
+		debugHandler:   handlers.NewDebugHandler(pm, slog.Default(), nil),
+	}
+	p.SetHostRoutes(map[string]string{"tasks.example.com": "abc"})
+	return p
+}
+
+func cloneRequest(t *testing.T, p *Proxy, method, instanceID, body string) (int, CloneResponse) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	p.handleClone(recorder, httptest.NewRequest(method, "/apps/"+instanceID+"/clone", strings.NewReader(body)))
+	var response CloneResponse
+	if recorder.Code == http.StatusOK && method == http.MethodPost {
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code, response
+}
+
+func TestCloneInstance(t *testing.T) {
+	p := newCloneTestProxy(t)
+
+	status, clone := cloneRequest(t, p, http.MethodPost, "abc", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected the clone to be created, got %d", status)
+	}
+	if clone.CloneOf != "abc" || clone.Name != "tasks" || clone.Version != "1.0.0" || !clone.Sandbox || clone.HostName != "tasks-clone.example.com" {
+		t.Errorf("Expected a sandboxed clone on tasks-clone.example.com, got %+v", clone)
+	}
+	if ttl := time.Until(clone.ExpiresAt); ttl < DefaultCloneTTL-time.Minute || ttl > DefaultCloneTTL {
+		t.Errorf("Expected the clone to expire after the default TTL, got %s", ttl)
+	}
+	if instanceID, ok := p.instanceForHost("Tasks-Clone.example.com:443"); !ok || instanceID != clone.InstanceID {
+		t.Errorf("Expected the clone's host to be routed to it, got %q", instanceID)
+	}
+	if app, ok := p.debugHandler.GetDebugApplication(clone.InstanceID); !ok || app.CloneOf != "abc" || app.Status != "running" {
+		t.Errorf("Expected the clone to be tracked for nexusdebug, got %+v", app)
+	}
+
+	// Further clones get the next free host name, and the sandbox can be
+	// turned off
+	status, second := cloneRequest(t, p, http.MethodPost, "abc", `{"ttl":"1h","sandbox":false}`)
+	if status != http.StatusOK || second.HostName != "tasks-clone-2.example.com" || second.Sandbox {
+		t.Errorf("Expected an unsandboxed clone on tasks-clone-2.example.com, got %d %+v", status, second)
+	}
+	if ttl := time.Until(second.ExpiresAt); ttl > time.Hour || ttl < 58*time.Minute {
+		t.Errorf("Expected the requested TTL, got %s", ttl)
+	}
+	status, named := cloneRequest(t, p, http.MethodPost, "abc", `{"hostName":"Repro.example.com"}`)
+	if status != http.StatusOK || named.HostName != "repro.example.com" {
+		t.Errorf("Expected the requested host name, got %d %+v", status, named)
+	}
+
+	// A new proxy routes the installed clones' host names
+	restarted := &Proxy{packageManager: p.packageManager}
+	restarted.loadCloneHosts()
+	if instanceID, ok := restarted.instanceForHost("tasks-clone-2.example.com"); !ok || instanceID != second.InstanceID {
+		t.Errorf("Expected clone host names to be restored, got %q", instanceID)
+	}
+
+	// Clones of instances without a host name are routed by ID only
+	if err := os.MkdirAll(filepath.Join(p.packageManager.GetInstallDir(), "nohost"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := packages.PackageDBInsert(p.packageManager.DB, "nohost", "other", "other", "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if status, clone := cloneRequest(t, p, http.MethodPost, "nohost", ""); status != http.StatusOK || clone.HostName != "" {
+		t.Errorf("Expected a clone without a host name, got %d %+v", status, clone)
+	}
+}
+
+func TestCloneRequestErrors(t *testing.T) {
+	p := newCloneTestProxy(t)
+
+	for _, tc := range []struct {
+		name, method, instanceID, body string
+		status                         int
+	}{
+		{"missing source", http.MethodPost, "missing", "", http.StatusNotFound},
+		{"malformed ttl", http.MethodPost, "abc", `{"ttl":"forever"}`, http.StatusBadRequest},
+		{"ttl too long", http.MethodPost, "abc", `{"ttl":"720h"}`, http.StatusBadRequest},
+		{"negative ttl", http.MethodPost, "abc", `{"ttl":"-1h"}`, http.StatusBadRequest},
+		{"routed host", http.MethodPost, "abc", `{"hostName":"tasks.example.com"}`, http.StatusConflict},
+		{"hub host", http.MethodPost, "abc", `{"hostName":"hub.example.com"}`, http.StatusConflict},
+		{"remove source", http.MethodDelete, "abc", "", http.StatusBadRequest},
+		{"remove missing", http.MethodDelete, "missing", "", http.StatusNotFound},
+		{"wrong method", http.MethodGet, "abc", "", http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, _ := cloneRequest(t, p, tc.method, tc.instanceID, tc.body); status != tc.status {
+				t.Errorf("Expected %d, got %d", tc.status, status)
+			}
+		})
+	}
+	if clones, err := p.packageManager.GetClones(); err != nil || len(clones) != 0 {
+		t.Errorf("Expected no clones to be created, got %d: %v", len(clones), err)
+	}
+}
+
+// cloneRemovedEverywhere checks that nothing of the clone is left.
+func cloneRemovedEverywhere(t *testing.T, p *Proxy, clone CloneResponse) {
+	t.Helper()
+	if pkg, err := p.packageManager.GetPackageByInstanceID(clone.InstanceID); err != nil || pkg != nil {
+		t.Errorf("Expected the clone to be uninstalled, got %+v: %v", pkg, err)
+	}
+	if _, err := os.Stat(filepath.Join(p.packageManager.GetInstallDir(), clone.InstanceID)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the clone's files to be deleted, got %v", err)
+	}
+	if _, ok := p.instanceForHost(clone.HostName); ok {
+		t.Error("Expected the clone's host name to be released")
+	}
+	if _, ok := p.debugHandler.GetDebugApplication(clone.InstanceID); ok {
+		t.Error("Expected the clone to no longer be tracked for nexusdebug")
+	}
+}
+
+func TestRemoveClone(t *testing.T) {
+	p := newCloneTestProxy(t)
+	_, clone := cloneRequest(t, p, http.MethodPost, "abc", "")
+
+	if status, _ := cloneRequest(t, p, http.MethodDelete, clone.InstanceID, ""); status != http.StatusOK {
+		t.Fatalf("Expected the clone to be removed, got %d", status)
+	}
+	cloneRemovedEverywhere(t, p, clone)
+
+	// Its host name is free for the next clone
+	if _, next := cloneRequest(t, p, http.MethodPost, "abc", ""); next.HostName != clone.HostName {
+		t.Errorf("Expected the host name to be reused, got %q", next.HostName)
+	}
+}
+
+func TestCloneExpiry(t *testing.T) {
+	p := newCloneTestProxy(t)
+	_, short := cloneRequest(t, p, http.MethodPost, "abc", `{"ttl":"1h"}`)
+	_, long := cloneRequest(t, p, http.MethodPost, "abc", `{"ttl":"2h"}`)
+
+	p.expireClones(time.Now())
+	if !p.packageManager.IsInstalled(short.InstanceID) {
+		t.Fatal("Expected the clone to be kept until it expires")
+	}
+
+	p.expireClones(short.ExpiresAt)
+	cloneRemovedEverywhere(t, p, short)
+	if !p.packageManager.IsInstalled(long.InstanceID) || !p.packageManager.IsInstalled("abc") {
+		t.Error("Expected the other clone and the source to stay installed")
+	}
+	if instanceID, ok := p.instanceForHost(long.HostName); !ok || instanceID != long.InstanceID {
+		t.Errorf("Expected the other clone to stay routed, got %q", instanceID)
+	}
+}
@@ -0,0 +1,76 @@
+package httpsproxy
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// instanceLookups coalesces concurrent lookups of the same instance, so that
+// a burst of requests for an idle instance activates it and waits for it to
+// start once, and every request shares the result. The zero value is ready
+// to use.
+type instanceLookups struct {
+	mu      sync.Mutex
+	pending map[string]*instanceLookup
+}
+
+// instanceLookup is a lookup in progress. done is closed once the result is
+// set.
+type instanceLookup struct {
+	done     chan struct{}
+	waiters  int
+	instance *processes.AppInstance
+	port     int
+	err      error
+}
+
+// do returns the result of lookup for the instance, running it only if no
+// lookup of the same instance is in progress and otherwise waiting for that
+// one's result.
+func (l *instanceLookups) do(instanceID string, lookup func() (*processes.AppInstance, int, error)) (*processes.AppInstance, int, error) {
+	l.mu.Lock()
+	if call, ok := l.pending[instanceID]; ok {
+		call.waiters++
+		l.mu.Unlock()
+		<-call.done
+		return call.instance, call.port, call.err
+	}
+	call := &instanceLookup{done: make(chan struct{})}
+	if l.pending == nil {
+		l.pending = make(map[string]*instanceLookup)
+	}
+	l.pending[instanceID] = call
+	l.mu.Unlock()
+
+	defer func() {
+		// Waiters must not hang if the lookup panics
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("%w: lookup of app ID %s failed: %v", ErrInstanceUnavailable, instanceID, r)
+			l.finish(instanceID, call)
+			panic(r)
+		}
+		l.finish(instanceID, call)
+	}()
+	call.instance, call.port, call.err = lookup()
+	return call.instance, call.port, call.err
+}
+
+func (l *instanceLookups) finish(instanceID string, call *instanceLookup) {
+	l.mu.Lock()
+	delete(l.pending, instanceID)
+	l.mu.Unlock()
+	close(call.done)
+}
+
+// waiting returns how many lookups are waiting on the one in progress for
+// the instance.
+func (l *instanceLookups) waiting(instanceID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if call, ok := l.pending[instanceID]; ok {
+		return call.waiters
+	}
+	return 0
+}
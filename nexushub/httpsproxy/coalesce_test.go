@@ -0,0 +1,150 @@
+package httpsproxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// countingProcessManager counts activations and cold start waits of an
+// instance that is ready once ready is closed.
+type countingProcessManager struct {
+	startingProcessManager
+	refreshes atomic.Int32
+	waits     atomic.Int32
+}
+
+func (pm *countingProcessManager) Refresh() {
+	pm.refreshes.Add(1)
+}
+
+func (pm *countingProcessManager) WaitForInstance(ctx context.Context, id string) (*processes.AppInstance, int, error) {
+	pm.waits.Add(1)
+	return pm.startingProcessManager.WaitForInstance(ctx, id)
+}
+
+// newColdStartProxy returns a proxy with instance "abc" installed but not
+// yet running.
+func newColdStartProxy(t *testing.T) (*Proxy, *countingProcessManager) {
+	packageManager, err := packages.OpenPackageManager(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { packageManager.DB.Close() })
+	if err := packages.PackageDBInsert(packageManager.DB, "abc", "hash", "app", "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	pm := &countingProcessManager{startingProcessManager: startingProcessManager{ready: make(chan struct{})}}
+	return &Proxy{pm: pm, packageManager: packageManager}, pm
+}
+
+// waitForWaiters waits until n lookups are waiting on the one in progress.
+func waitForWaiters(t *testing.T, p *Proxy, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for p.instanceLookups.waiting("abc") < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d waiters, got %d", n, p.instanceLookups.waiting("abc"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentLookupsShareColdStart(t *testing.T) {
+	p, pm := newColdStartProxy(t)
+
+	const requests = 20
+	var wg sync.WaitGroup
+	ports := make([]int, requests)
+	errs := make([]error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, ports[i], errs[i] = p.GetAppInstanceByID("abc")
+		}(i)
+	}
+	waitForWaiters(t, p, requests-1)
+	close(pm.ready)
+	wg.Wait()
+
+	for i := 0; i < requests; i++ {
+		if errs[i] != nil || ports[i] != 10001 {
+			t.Errorf("Request %d: expected the instance, got %d: %v", i, ports[i], errs[i])
+		}
+	}
+	if refreshes, waits := pm.refreshes.Load(), pm.waits.Load(); refreshes != 1 || waits != 1 {
+		t.Errorf("Expected one activation and one wait, got %d and %d", refreshes, waits)
+	}
+
+	// Later lookups activate the instance again to keep it alive
+	if _, _, err := p.GetAppInstanceByID("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if refreshes := pm.refreshes.Load(); refreshes != 2 {
+		t.Errorf("Expected a new activation once the first lookup finished, got %d", refreshes)
+	}
+}
+
+func TestConcurrentLookupsShareFailure(t *testing.T) {
+	p, pm := newColdStartProxy(t)
+	p.SetColdStartTimeout(500 * time.Millisecond)
+
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, _, err := p.GetAppInstanceByID("abc")
+			results <- err
+		}()
+	}
+	waitForWaiters(t, p, 2)
+	for i := 0; i < 3; i++ {
+		if err := <-results; !errors.Is(err, ErrInstanceStarting) {
+			t.Errorf("Expected ErrInstanceStarting, got %v", err)
+		}
+	}
+	if waits := pm.waits.Load(); waits != 1 {
+		t.Errorf("Expected the timeout to be waited for once, got %d waits", waits)
+	}
+
+	// Lookups of an instance that is not installed fail on their own
+	if _, _, err := p.GetAppInstanceByID("missing"); !errors.Is(err, ErrInstanceNotFound) {
+		t.Errorf("Expected ErrInstanceNotFound, got %v", err)
+	}
+}
+
+func TestLookupPanicReleasesWaiters(t *testing.T) {
+	var lookups instanceLookups
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		lookups.do("abc", func() (*processes.AppInstance, int, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := lookups.do("abc", func() (*processes.AppInstance, int, error) {
+			t.Error("Expected the lookup in progress to be joined")
+			return nil, 0, nil
+		})
+		result <- err
+	}()
+	for lookups.waiting("abc") < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-result; !errors.Is(err, ErrInstanceUnavailable) {
+		t.Errorf("Expected the waiter to get ErrInstanceUnavailable, got %v", err)
+	}
+}
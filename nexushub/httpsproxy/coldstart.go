@@ -0,0 +1,87 @@
+package httpsproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// DefaultColdStartTimeout is how long a request waits for an instance that
+// is not running to become ready, unless configured otherwise.
+const DefaultColdStartTimeout = 30 * time.Second
+
+// ErrInstanceStarting is returned by GetAppInstanceByID when the instance was
+// started but did not become ready within the cold start timeout. It wraps
+// ErrInstanceUnavailable.
+var ErrInstanceStarting = fmt.Errorf("%w: still starting", ErrInstanceUnavailable)
+
+// SetColdStartTimeout sets how long GetAppInstanceByID waits for an instance
+// that is not running to become ready before giving up with
+// ErrInstanceStarting.
+func (p *Proxy) SetColdStartTimeout(timeout time.Duration) {
+	p.coldStartTimeout = timeout
+}
+
+// ColdStartTimeoutFromEnv reads the cold start timeout from the
+// COLD_START_TIMEOUT environment variable, e.g. "90s". It returns
+// DefaultColdStartTimeout if the variable is unset.
+func ColdStartTimeoutFromEnv() (time.Duration, error) {
+	value := os.Getenv("COLD_START_TIMEOUT")
+	if value == "" {
+		return DefaultColdStartTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid COLD_START_TIMEOUT %q: expected a positive duration", value)
+	}
+	return timeout, nil
+}
+
+// EnableMetrics records the proxy's metrics in registry and serves them in
+// the Prometheus text format at /metrics to authorized requests.
+func (p *Proxy) EnableMetrics(registry *prometheus.Registry) {
+	p.coldStarts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nexushub_instance_cold_start_seconds",
+		Help:    "Time requests waited for an instance that was not running to become ready, by instance and outcome.",
+		Buckets: []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"instance", "outcome"})
+	registry.MustRegister(p.coldStarts)
+	p.metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// waitForInstance returns the instance if it is ready to serve, otherwise
+// waits up to the cold start timeout for it to become ready and records how
+// long that took.
+func (p *Proxy) waitForInstance(instanceID string) (*processes.AppInstance, int, error) {
+	if instance, port, err := p.pm.GetAppInstanceByID(instanceID); err == nil {
+		return instance, port, nil
+	}
+
+	timeout := p.coldStartTimeout
+	if timeout <= 0 {
+		timeout = DefaultColdStartTimeout
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	instance, port, err := p.pm.WaitForInstance(ctx, instanceID)
+
+	outcome := "ready"
+	if errors.Is(err, context.DeadlineExceeded) {
+		outcome = "timeout"
+		err = fmt.Errorf("%w: app ID %s not ready after %s", ErrInstanceStarting, instanceID, timeout)
+	} else if err != nil {
+		outcome = "error"
+		err = fmt.Errorf("%w: instance not serving for app ID %s: %v", ErrInstanceUnavailable, instanceID, err)
+	}
+	if p.coldStarts != nil {
+		p.coldStarts.WithLabelValues(instanceID, outcome).Observe(time.Since(start).Seconds())
+	}
+	return instance, port, err
+}
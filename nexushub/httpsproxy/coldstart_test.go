@@ -0,0 +1,105 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// startingProcessManager reports its instance ready once ready is closed.
+type startingProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+	ready chan struct{}
+}
+
+func (pm *startingProcessManager) GetAppInstanceByID(id string) (*processes.AppInstance, int, error) {
+	select {
+	case <-pm.ready:
+		return &processes.AppInstance{InstanceID: id}, 10001, nil
+	default:
+		return nil, 0, errors.New("not running")
+	}
+}
+
+func (pm *startingProcessManager) WaitForInstance(ctx context.Context, id string) (*processes.AppInstance, int, error) {
+	select {
+	case <-pm.ready:
+		return pm.GetAppInstanceByID(id)
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+func TestWaitForInstanceTimesOut(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := &Proxy{pm: &startingProcessManager{ready: make(chan struct{})}}
+	p.SetColdStartTimeout(20 * time.Millisecond)
+	p.EnableMetrics(registry)
+
+	_, _, err := p.waitForInstance("abc")
+	if !errors.Is(err, ErrInstanceStarting) || !errors.Is(err, ErrInstanceUnavailable) {
+		t.Fatalf("Expected ErrInstanceStarting, got %v", err)
+	}
+	if count := testutil.CollectAndCount(registry, "nexushub_instance_cold_start_seconds"); count != 1 {
+		t.Errorf("Expected the cold start to be recorded, got %d series", count)
+	}
+
+	recorder := httptest.NewRecorder()
+	p.serveInstanceError(recorder, httptest.NewRequest(http.MethodGet, "/abc/api/items", nil), "trace", "abc", err)
+	var body struct {
+		Error    string `json:"error"`
+		Starting bool   `json:"starting"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || !body.Starting {
+		t.Errorf("Expected a starting response, got %q: %v", recorder.Body.String(), err)
+	}
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d", recorder.Code)
+	}
+}
+
+func TestWaitForInstanceRecordsColdStarts(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	pm := &startingProcessManager{ready: make(chan struct{})}
+	p := &Proxy{pm: pm}
+	p.EnableMetrics(registry)
+
+	time.AfterFunc(10*time.Millisecond, func() { close(pm.ready) })
+	if _, port, err := p.waitForInstance("abc"); err != nil || port != 10001 {
+		t.Fatalf("Expected the instance once ready, got %d: %v", port, err)
+	}
+	// Requests to a running instance are not cold starts
+	if _, _, err := p.waitForInstance("abc"); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil || len(families) != 1 {
+		t.Fatalf("Expected the cold start histogram, got %v: %v", families, err)
+	}
+	if count := families[0].GetMetric()[0].GetHistogram().GetSampleCount(); count != 1 {
+		t.Errorf("Expected one cold start, got %d", count)
+	}
+}
+
+func TestColdStartTimeoutFromEnv(t *testing.T) {
+	if timeout, err := ColdStartTimeoutFromEnv(); err != nil || timeout != DefaultColdStartTimeout {
+		t.Errorf("Expected the default, got %s: %v", timeout, err)
+	}
+	t.Setenv("COLD_START_TIMEOUT", "90s")
+	if timeout, err := ColdStartTimeoutFromEnv(); err != nil || timeout != 90*time.Second {
+		t.Errorf("Expected 90s, got %s: %v", timeout, err)
+	}
+	t.Setenv("COLD_START_TIMEOUT", "0")
+	if _, err := ColdStartTimeoutFromEnv(); err == nil {
+		t.Error("Expected an error for a zero timeout")
+	}
+}
@@ -0,0 +1,127 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/apps/admin/server"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+)
+
+// TestEmbeddedAdminLogin runs the Admin application embedded in the hub and
+// logs in through the proxy, which calls the application over its in-memory
+// listener, then adds a user and lists users with the access token.
+func TestEmbeddedAdminLogin(t *testing.T) {
+	packageManager, err := packages.OpenPackageManager(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packageManager.DB.Close()
+	subscriptions := map[string]bool{"User:Add": true}
+	if err := packages.PackageDBInsert(packageManager.DB, "MBtskI6D", "hash", server.Name, "1.0.0", subscriptions, string(processes.TransportEmbedded), false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	hubDB := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "hub.db"))
+	defer hubDB.Close()
+	eventManager, err := events.CreateEventManager(hubDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionManager, err := sessions.NewManager(hubDB, time.Minute, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLogger, err := audit.NewLogger(hubDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portManager, err := processes.NewPortManager(20120, 20130)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretStore := secrets.NewStore(time.Minute)
+	pm, err := processes.NewProcessManager(processes.Config{
+		InstanceProvider: packageManager,
+		PortManager:      portManager,
+		EventManager:     eventManager,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, secretStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pm.Run(ctx)
+	defer pm.Stop()
+
+	p := NewProxy(":0", "hub.test", "", "", secretStore, true, pm, packageManager, eventManager)
+	do := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		reqCtx := context.WithValue(r.Context(), sessions.SessionManagerKey, sessionManager)
+		reqCtx = context.WithValue(reqCtx, audit.AuditLoggerKey, auditLogger)
+		recorder := httptest.NewRecorder()
+		p.handleRequest(recorder, r.WithContext(reqCtx))
+		return recorder
+	}
+
+	// Logging in starts the instance and checks the password with it
+	login := do(http.MethodPost, "/public/login", `{"username":"admin","password":"admin"}`, nil)
+	if login.Code != http.StatusOK || login.Body.String() != "ok" {
+		t.Fatalf("Expected the login to succeed, got %d %q", login.Code, login.Body.String())
+	}
+	cookie := strings.Split(login.Header().Get("Set-Cookie"), ";")[0]
+
+	refresh := do(http.MethodPost, "/public/access_token", "", http.Header{"Cookie": {cookie}})
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(refresh.Body.Bytes(), &token); err != nil || token.AccessToken == "" {
+		t.Fatalf("Expected an access token, got %d %q", refresh.Code, refresh.Body.String())
+	}
+
+	// Events are delivered to the embedded instance before it is called
+	if _, err := eventManager.PublishEvent("add-1", "User:Add", []byte(`{"username":"embedded","password":"Correct-horse-9"}`)); err != nil {
+		t.Fatal(err)
+	}
+	users := do(http.MethodGet, "/MBtskI6D/api/users", "", http.Header{"Authorization": {"Bearer " + token.AccessToken}})
+	if users.Code != http.StatusOK || !strings.Contains(users.Body.String(), `"username":"embedded"`) {
+		t.Fatalf("Expected the new user in the user list, got %d %q", users.Code, users.Body.String())
+	}
+	profile := do(http.MethodGet, "/MBtskI6D/api/user_profile?username=embedded", "", http.Header{"Authorization": {"Bearer " + token.AccessToken}})
+	if profile.Code != http.StatusOK || !strings.Contains(profile.Body.String(), `"username":"embedded"`) {
+		t.Errorf("Expected the new user's profile, got %d %q", profile.Code, profile.Body.String())
+	}
+	invalid := do(http.MethodGet, "/MBtskI6D/api/user_profile?id=0&username=embedded", "", http.Header{"Authorization": {"Bearer " + token.AccessToken}})
+	if invalid.Code != http.StatusBadRequest || !strings.Contains(invalid.Body.String(), `{"field":"id","message":"must be at least 1"}`) {
+		t.Errorf("Expected the invalid ID to be reported, got %d %q", invalid.Code, invalid.Body.String())
+	}
+
+	// Health checks reach the instance like any other
+	instance, _, err := pm.GetAppInstanceByID("MBtskI6D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, eventID, err := processes.NewHTTPHealthChecker(time.Second).Check(&processes.ManagedProcess{Instance: *instance})
+	if err != nil || state != processes.StateRunning || eventID != 1 {
+		t.Errorf("Expected a healthy instance at event 1, got %v %d: %v", state, eventID, err)
+	}
+}
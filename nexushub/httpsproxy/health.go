@@ -0,0 +1,48 @@
+package httpsproxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
+)
+
+// BeginShutdown marks the proxy as not ready, so /readyz reports 503 while
+// the proxy keeps serving requests until it is stopped.
+func (p *Proxy) BeginShutdown() {
+	p.shuttingDown.Store(true)
+}
+
+// SetDiskWatchdog reports free disk space in /readyz and rejects writes when
+// it runs low: debug upload chunks below the soft threshold, and event
+// publishes and new debug applications below the hard threshold. The debug
+// upload directory is added to the watched paths.
+func (p *Proxy) SetDiskWatchdog(watchdog *diskspace.Watchdog) {
+	p.diskWatchdog = watchdog
+	p.debugHandler.SetDiskWatchdog(watchdog)
+	watchdog.AddPath(p.debugHandler.UploadDir())
+}
+
+// handleHealth serves the unauthenticated health endpoints. /healthz reports
+// that the hub is alive; /readyz additionally reports 503 once shutdown has
+// begun, so load balancers stop sending new traffic. While disk space is low
+// /readyz stays 200, since reads are still served, but lists the affected
+// volumes.
+func (p *Proxy) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Path == "/readyz" && p.shuttingDown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("shutting down\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+	if r.URL.Path == "/readyz" {
+		status := p.diskWatchdog.Status()
+		for _, volume := range status.Volumes {
+			if volume.Level != diskspace.LevelOK {
+				fmt.Fprintf(w, "disk %s: %s has %d bytes free\n", volume.Level, volume.Path, volume.FreeBytes)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package httpsproxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
+	"github.com/tomyedwab/yesterday/nexushub/internal/handlers"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+func TestReadyzReportsShutdown(t *testing.T) {
+	p := &Proxy{}
+	check := func(path string) int {
+		recorder := httptest.NewRecorder()
+		p.handleRequest(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	if code := check("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz to be 200 before shutdown, got %d", code)
+	}
+	p.BeginShutdown()
+	if code := check("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to be 503 during shutdown, got %d", code)
+	}
+	if code := check("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to stay 200 during shutdown, got %d", code)
+	}
+}
+
+// newDiskTestProxy returns a proxy whose disk watchdog reports the free space
+// in *free for every volume.
+func newDiskTestProxy(t *testing.T, free *uint64) (*Proxy, *diskspace.Watchdog) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p := &Proxy{
+		secrets:      secrets.NewStore(time.Minute),
+		debugHandler: handlers.NewDebugHandler(nil, logger, nil),
+	}
+	watchdog := diskspace.NewWatchdog(diskspace.Config{
+		Paths:         []string{"/install"},
+		SoftThreshold: 1000,
+		HardThreshold: 100,
+	}, func(string) (uint64, error) { return *free, nil }, logger)
+	p.SetDiskWatchdog(watchdog)
+	return p, watchdog
+}
+
+func TestReadyzReportsLowDiskSpace(t *testing.T) {
+	free := uint64(500)
+	p, watchdog := newDiskTestProxy(t, &free)
+	watchdog.Check()
+
+	recorder := httptest.NewRecorder()
+	p.handleRequest(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.Contains(body, "disk low: /install has 500 bytes free") {
+		t.Errorf("Expected /readyz to report the low volume, got %d %q", recorder.Code, body)
+	}
+	if !strings.Contains(body, p.debugHandler.UploadDir()) {
+		t.Errorf("Expected the upload directory to be watched, got %q", body)
+	}
+
+	free = 5000
+	watchdog.Check()
+	recorder = httptest.NewRecorder()
+	p.handleRequest(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if body := recorder.Body.String(); body != "ok\n" {
+		t.Errorf("Expected /readyz to recover, got %q", body)
+	}
+}
+
+func TestDiskWatchdogRejectsWrites(t *testing.T) {
+	free := uint64(500)
+	p, watchdog := newDiskTestProxy(t, &free)
+	send := func(method, path, body string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+p.secrets.Current())
+		recorder := httptest.NewRecorder()
+		p.handleRequest(recorder, r)
+		return recorder.Code
+	}
+	upload := func() int { return send(http.MethodPost, "/debug/application/app-1/upload", "") }
+	createApp := func() int {
+		return send(http.MethodPost, "/debug/application", `{"appId":"a"}`)
+	}
+	publish := func() int { return send(http.MethodPost, "/events/publish", "{}") }
+
+	// Below the soft threshold only upload chunks are rejected
+	watchdog.Check()
+	if code := upload(); code != http.StatusInsufficientStorage {
+		t.Errorf("Expected upload chunks to be rejected when low, got %d", code)
+	}
+	if code := createApp(); code == http.StatusInsufficientStorage {
+		t.Error("Expected debug applications to be accepted when low")
+	}
+
+	// Below the hard threshold publishes and new applications are too
+	free = 50
+	watchdog.Check()
+	if code := publish(); code != http.StatusInsufficientStorage {
+		t.Errorf("Expected publishes to be rejected when critical, got %d", code)
+	}
+	if code := createApp(); code != http.StatusInsufficientStorage {
+		t.Errorf("Expected debug applications to be rejected when critical, got %d", code)
+	}
+
+	// Freeing space recovers automatically
+	free = 5000
+	watchdog.Check()
+	if code := upload(); code == http.StatusInsufficientStorage {
+		t.Error("Expected uploads to resume once space is freed")
+	}
+	if code := createApp(); code == http.StatusInsufficientStorage {
+		t.Error("Expected debug applications to resume once space is freed")
+	}
+}
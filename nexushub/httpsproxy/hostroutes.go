@@ -0,0 +1,77 @@
+package httpsproxy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ParseHostRoutes parses a comma-separated list of host=instanceID pairs,
+// e.g. "admin.example.com=MBtskI6D,tasks.example.com=3bf3e3c0".
+func ParseHostRoutes(value string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, instanceID, ok := strings.Cut(entry, "=")
+		host = normalizeHost(host)
+		instanceID = strings.TrimSpace(instanceID)
+		if !ok || host == "" || instanceID == "" {
+			return nil, fmt.Errorf("invalid host route %q, expected host=instanceID", entry)
+		}
+		if existing, ok := routes[host]; ok && existing != instanceID {
+			return nil, fmt.Errorf("host %s is routed to both %s and %s", host, existing, instanceID)
+		}
+		routes[host] = instanceID
+	}
+	return routes, nil
+}
+
+// HostRoutesFromEnv reads host routes from the HOST_ROUTES environment
+// variable. It returns nil if the variable is unset.
+func HostRoutesFromEnv() (map[string]string, error) {
+	value := os.Getenv("HOST_ROUTES")
+	if value == "" {
+		return nil, nil
+	}
+	routes, err := ParseHostRoutes(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HOST_ROUTES: %w", err)
+	}
+	return routes, nil
+}
+
+// normalizeHost lowercases a host name and strips any port.
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// SetHostRoutes routes requests for the given host names to application
+// instances, keyed by host name. Requests to a mapped host are proxied with
+// their full path; other requests are routed by the first path segment.
+func (p *Proxy) SetHostRoutes(routes map[string]string) {
+	p.hostRoutes = make(map[string]string, len(routes))
+	for host, instanceID := range routes {
+		p.hostRoutes[normalizeHost(host)] = instanceID
+	}
+}
+
+// instanceForHost returns the instance ID the request's host is routed to,
+// by the host routes or as a clone's host name.
+func (p *Proxy) instanceForHost(host string) (string, bool) {
+	host = normalizeHost(host)
+	if instanceID, ok := p.hostRoutes[host]; ok {
+		return instanceID, true
+	}
+	p.cloneHostsMu.RLock()
+	defer p.cloneHostsMu.RUnlock()
+	instanceID, ok := p.cloneHosts[host]
+	return instanceID, ok
+}
@@ -0,0 +1,65 @@
+package httpsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseHostRoutes(t *testing.T) {
+	routes, err := ParseHostRoutes(" Admin.Example.com=MBtskI6D, tasks.example.com:8443=abc123 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes["admin.example.com"] != "MBtskI6D" || routes["tasks.example.com"] != "abc123" {
+		t.Errorf("Unexpected routes: %v", routes)
+	}
+
+	for _, value := range []string{"admin.example.com", "=MBtskI6D", "admin.example.com=", "a.com=x,a.com=y"} {
+		if _, err := ParseHostRoutes(value); err == nil {
+			t.Errorf("Expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestInstanceForHost(t *testing.T) {
+	p := &Proxy{}
+	if _, ok := p.instanceForHost("admin.example.com"); ok {
+		t.Error("Expected no route without configuration")
+	}
+
+	p.SetHostRoutes(map[string]string{"Admin.example.com": "MBtskI6D"})
+	for _, host := range []string{"admin.example.com", "ADMIN.example.com:8443", "admin.example.com."} {
+		if instanceID, ok := p.instanceForHost(host); !ok || instanceID != "MBtskI6D" {
+			t.Errorf("Expected %s to route to MBtskI6D, got %q", host, instanceID)
+		}
+	}
+	if _, ok := p.instanceForHost("www.example.com"); ok {
+		t.Error("Expected unmapped host to fall through")
+	}
+}
+
+func TestHostRoutePreservesPath(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.RequestURI()
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	p := &Proxy{transport: &http.Transport{}}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.proxyToInstance(w, r, "trace", backendURL.Host, r.URL.Path)
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/MBtskI6D/api/users?limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-received; got != "/MBtskI6D/api/users?limit=5" {
+		t.Errorf("Expected the full path to be forwarded, got %s", got)
+	}
+}
@@ -0,0 +1,166 @@
+package httpsproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/idempotency"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the keys stored in the database
+	maxIdempotencyKeyLength = 255
+	// maxInMemoryBody is how much of a request body is buffered in memory
+	// for fingerprinting before spilling to a temporary file
+	maxInMemoryBody = 1 << 20
+)
+
+// SetIdempotencyStore enables Idempotency-Key support for mutating requests,
+// recording responses in the given store.
+func (p *Proxy) SetIdempotencyStore(store *idempotency.Store) {
+	p.idempotencyStore = store
+}
+
+// isMutatingMethod reports whether requests with the method may change state
+// and so honor Idempotency-Key.
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// beginIdempotentRequest handles the Idempotency-Key of an authorized request.
+// If the request should be executed it returns a writer that records the
+// response and a function to call once the handler returns, with completed
+// false if it panicked. Otherwise it has already responded, with the stored
+// response for a replay or an error, and returns ok false.
+func (p *Proxy) beginIdempotentRequest(w http.ResponseWriter, r *http.Request, traceID string, userID int) (http.ResponseWriter, func(completed bool), bool) {
+	keyValue := r.Header.Get(idempotencyKeyHeader)
+	if p.idempotencyStore == nil || keyValue == "" || !isMutatingMethod(r.Method) {
+		return w, func(bool) {}, true
+	}
+	if len(keyValue) > maxIdempotencyKeyLength {
+		http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength), http.StatusBadRequest)
+		log.Printf("<%s> %s %s => 400 [Idempotency key too long]", traceID, r.Host, r.URL.Path)
+		return nil, nil, false
+	}
+
+	fingerprint, cleanup, err := fingerprintRequest(r)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		log.Printf("<%s> %s %s => 400 [%v]", traceID, r.Host, r.URL.Path, err)
+		return nil, nil, false
+	}
+
+	key := idempotency.Key{Key: keyValue, Path: r.URL.Path, UserID: userID}
+	stored, err := p.idempotencyStore.Begin(key, fingerprint)
+	switch {
+	case errors.Is(err, idempotency.ErrConflict):
+		cleanup()
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		log.Printf("<%s> %s %s => 422 [Idempotency key reused]", traceID, r.Host, r.URL.Path)
+		return nil, nil, false
+	case errors.Is(err, idempotency.ErrInProgress):
+		cleanup()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusConflict)
+		log.Printf("<%s> %s %s => 409 [Idempotent request in progress]", traceID, r.Host, r.URL.Path)
+		return nil, nil, false
+	case err != nil:
+		cleanup()
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		log.Printf("<%s> %s %s => 500 [Idempotency store: %v]", traceID, r.Host, r.URL.Path, err)
+		return nil, nil, false
+	case stored != nil:
+		cleanup()
+		if stored.ContentType != "" {
+			w.Header().Set("Content-Type", stored.ContentType)
+		}
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+		log.Printf("<%s> %s %s => %d [Idempotent replay]", traceID, r.Host, r.URL.Path, stored.Status)
+		return nil, nil, false
+	}
+
+	recorder := httputils.NewResponseRecorder(w, idempotency.MaxStoredBody)
+	finish := func(completed bool) {
+		cleanup()
+		status := recorder.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// Server errors, panics and responses too large to replay are not
+		// stored, so that a retry executes the request again
+		if !completed || status >= 500 || recorder.Truncated() {
+			err = p.idempotencyStore.Release(key)
+		} else {
+			err = p.idempotencyStore.Complete(key, idempotency.Response{
+				Status:      status,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.Body(),
+			})
+		}
+		if err != nil {
+			log.Printf("<%s> %s %s: failed to record idempotent response: %v", traceID, r.Host, r.URL.Path, err)
+		}
+	}
+	return recorder, finish, true
+}
+
+// fingerprintRequest hashes the method, query and body of a request. The body
+// is buffered so that the handler can still read it, in memory or, past
+// maxInMemoryBody, in a temporary file that cleanup removes.
+func fingerprintRequest(r *http.Request) (string, func(), error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", r.Method, r.URL.RawQuery)
+	if r.Body == nil || r.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), func() {}, nil
+	}
+	defer r.Body.Close()
+
+	var buffer bytes.Buffer
+	n, err := io.CopyN(io.MultiWriter(&buffer, hash), r.Body, maxInMemoryBody+1)
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	if n <= maxInMemoryBody {
+		r.Body = io.NopCloser(&buffer)
+		return hex.EncodeToString(hash.Sum(nil)), func() {}, nil
+	}
+
+	file, err := os.CreateTemp("", "idempotent-request-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	if _, err := buffer.WriteTo(file); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := io.Copy(io.MultiWriter(file, hash), r.Body); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	r.Body = io.NopCloser(file)
+	return hex.EncodeToString(hash.Sum(nil)), cleanup, nil
+}
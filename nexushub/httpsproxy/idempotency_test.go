@@ -0,0 +1,177 @@
+package httpsproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/idempotency"
+)
+
+// newIdempotentServer serves a handler behind beginIdempotentRequest, the way
+// handleRequest wraps the endpoints after authorization. The handler echoes
+// the size of the request body and counts its executions.
+func newIdempotentServer(t *testing.T, status int) (*httptest.Server, *int) {
+	return newIdempotentHandlerServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Repeat("x", len(body)%10) + "!"))
+	})
+}
+
+// newIdempotentHandlerServer serves handler like newIdempotentServer.
+func newIdempotentHandlerServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *int) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "sessions.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := idempotency.NewStore(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{}
+	p.SetIdempotencyStore(store)
+
+	executions := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, finish, ok := p.beginIdempotentRequest(w, r, "trace", 1)
+		if !ok {
+			return
+		}
+		completed := false
+		defer func() { finish(completed) }()
+		executions++
+		handler(w, r)
+		completed = true
+	}))
+	t.Cleanup(server.Close)
+	return server, &executions
+}
+
+func postWithKey(t *testing.T, url, key string, body []byte) (*http.Response, string) {
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp, string(respBody)
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	server, executions := newIdempotentServer(t, http.StatusCreated)
+
+	resp, body := postWithKey(t, server.URL+"/apps/install", "key-1", []byte("abc"))
+	if resp.StatusCode != http.StatusCreated || body != "xxx!" || resp.Header.Get(idempotentReplayedHeader) != "" {
+		t.Fatalf("Unexpected first response %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = postWithKey(t, server.URL+"/apps/install", "key-1", []byte("abc"))
+	if resp.StatusCode != http.StatusCreated || body != "xxx!" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected the stored response, got %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get(idempotentReplayedHeader) != "true" {
+		t.Error("Expected the replay to be marked")
+	}
+	if *executions != 1 {
+		t.Errorf("Expected one execution, got %d", *executions)
+	}
+
+	// Requests without a key, or on another path, are executed
+	postWithKey(t, server.URL+"/apps/install", "", []byte("abc"))
+	postWithKey(t, server.URL+"/events/publish", "key-1", []byte("abc"))
+	if *executions != 3 {
+		t.Errorf("Expected three executions, got %d", *executions)
+	}
+}
+
+func TestIdempotentConflict(t *testing.T) {
+	server, executions := newIdempotentServer(t, http.StatusOK)
+
+	postWithKey(t, server.URL+"/events/publish", "key-1", []byte(`{"type":"A"}`))
+	resp, _ := postWithKey(t, server.URL+"/events/publish", "key-1", []byte(`{"type":"B"}`))
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a different body with the same key, got %d", resp.StatusCode)
+	}
+	if *executions != 1 {
+		t.Errorf("Expected the conflicting request not to execute, got %d executions", *executions)
+	}
+}
+
+func TestIdempotentServerErrorsAreRetried(t *testing.T) {
+	server, executions := newIdempotentServer(t, http.StatusServiceUnavailable)
+
+	postWithKey(t, server.URL+"/apps/install", "key-1", []byte("abc"))
+	resp, _ := postWithKey(t, server.URL+"/apps/install", "key-1", []byte("abc"))
+	if resp.Header.Get(idempotentReplayedHeader) != "" || *executions != 2 {
+		t.Errorf("Expected a 503 not to be replayed, got %d executions", *executions)
+	}
+}
+
+func TestIdempotentLargeRequestBody(t *testing.T) {
+	server, executions := newIdempotentServer(t, http.StatusOK)
+
+	// Larger than maxInMemoryBody, so fingerprinted through a temporary file
+	large := bytes.Repeat([]byte("y"), maxInMemoryBody+7)
+	_, body := postWithKey(t, server.URL+"/apps/install", "key-1", large)
+	if body != strings.Repeat("x", len(large)%10)+"!" {
+		t.Errorf("Expected the handler to read the whole body, got %q", body)
+	}
+	postWithKey(t, server.URL+"/apps/install", "key-1", large)
+	if *executions != 1 {
+		t.Errorf("Expected the replay of a large request to match, got %d executions", *executions)
+	}
+	resp, _ := postWithKey(t, server.URL+"/apps/install", "key-1", append(large, 'z'))
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a different large body, got %d", resp.StatusCode)
+	}
+}
+
+func TestIdempotentPanicsAreRetried(t *testing.T) {
+	fail := true
+	server, executions := newIdempotentHandlerServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			panic(http.ErrAbortHandler)
+		}
+		w.Write([]byte("done"))
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/apps/install", strings.NewReader("abc"))
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected the panicking request to be aborted")
+	}
+
+	fail = false
+	resp, body := postWithKey(t, server.URL+"/apps/install", "key-1", []byte("abc"))
+	if resp.StatusCode != http.StatusOK || body != "done" || *executions != 2 {
+		t.Errorf("Expected the retry to execute, got %d %q after %d executions", resp.StatusCode, body, *executions)
+	}
+}
+
+func TestIdempotentLargeResponsesAreRetried(t *testing.T) {
+	server, executions := newIdempotentHandlerServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("z"), idempotency.MaxStoredBody+1))
+	})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		resp, body := postWithKey(t, server.URL+"/apps/install", "key-1", []byte("abc"))
+		if len(body) != idempotency.MaxStoredBody+1 || resp.Header.Get(idempotentReplayedHeader) != "" {
+			t.Errorf("Attempt %d: expected the whole response to be executed, got %d bytes", attempt, len(body))
+		}
+	}
+	if *executions != 2 {
+		t.Errorf("Expected a response too large to store not to be replayed, got %d executions", *executions)
+	}
+}
@@ -0,0 +1,39 @@
+package httpsproxy
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunIdleDeactivation stops instances that had no requests for their idle
+// timeout, checking every interval until ctx is done. The next request for a
+// stopped instance cold-starts it.
+func (p *Proxy) RunIdleDeactivation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			since = p.deactivateIdle(since, now)
+		}
+	}
+}
+
+// deactivateIdle stops the instances that went idle after since and by now.
+// It returns the time to check from next.
+func (p *Proxy) deactivateIdle(since, now time.Time) time.Time {
+	idle, err := p.packageManager.DeactivateIdle(since, now, p.pm)
+	if err != nil {
+		log.Printf("Failed to check for idle instances: %v", err)
+		return since
+	}
+	for _, pkg := range idle {
+		timeout, _ := p.packageManager.IdleTimeout(pkg.InstanceID)
+		log.Printf("Stopping idle instance %s (%s): no requests for %s", pkg.InstanceID, pkg.Name, timeout)
+	}
+	return now
+}
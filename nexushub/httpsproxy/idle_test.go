@@ -0,0 +1,47 @@
+package httpsproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+func TestDeactivateIdle(t *testing.T) {
+	packageManager, err := packages.OpenPackageManager(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packageManager.DB.Close()
+	packageManager.SetIdleTimeouts(packages.IdleTimeouts{Default: time.Minute})
+	if err := packages.PackageDBInsert(packageManager.DB, "abc", "hash", "tasks", "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{pm: &staticProcessManager{packages: packageManager}, packageManager: packageManager}
+	if err := packageManager.SetPackageActive("abc", p.pm); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if next := p.deactivateIdle(now, now.Add(30*time.Second)); !next.Equal(now.Add(30 * time.Second)) {
+		t.Errorf("Expected the next check to start where this one ended, got %s", next)
+	}
+	if active, _ := packageManager.GetActivePackages(); len(active) != 1 {
+		t.Error("Expected the instance to stay active within its idle timeout")
+	}
+
+	// Once its idle timeout passes without requests it is no longer among
+	// the instances to run, until the next request
+	if err := packages.PackageDBUpdateTTL(packageManager.DB, "abc", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if instances, err := packageManager.GetAppInstances(); err != nil || len(instances) != 0 {
+		t.Errorf("Expected the idle instance to be stopped, got %+v: %v", instances, err)
+	}
+	if err := packageManager.SetPackageActive("abc", p.pm); err != nil {
+		t.Fatal(err)
+	}
+	if instances, err := packageManager.GetAppInstances(); err != nil || len(instances) != 1 {
+		t.Errorf("Expected a request to start the instance again, got %+v: %v", instances, err)
+	}
+}
@@ -0,0 +1,53 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// handleLogLevel serves /apps/{id}/loglevel: GET returns the log level spec
+// of the instance's running process and PUT changes it until the process
+// restarts, see applib.ParseLogLevels.
+func (p *Proxy) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	instanceID := strings.Split(r.URL.Path, "/")[2]
+	var level string
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		level, err = p.pm.GetLogLevel(r.Context(), instanceID)
+	case http.MethodPut:
+		var request types.LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid log level request: %v", err), http.StatusBadRequest)
+			return
+		}
+		level, err = p.pm.SetLogLevel(r.Context(), instanceID, request.Level)
+		if err == nil {
+			userID, _ := httputils.RequestUserID(r)
+			log.Printf("Log level of %s set to %s by user %d", instanceID, level, userID)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, processes.ErrInstanceNotRunning):
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusConflict)
+	case errors.Is(err, processes.ErrLogLevelRejected):
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+	case err != nil:
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadGateway)
+	default:
+		httputils.HandleAPIResponse(w, r, types.LogLevel{Level: level}, nil, http.StatusOK)
+	}
+}
@@ -0,0 +1,75 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// logLevelProcessManager runs instance "abc" at level.
+type logLevelProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+	level string
+}
+
+func (pm *logLevelProcessManager) GetLogLevel(ctx context.Context, id string) (string, error) {
+	if id != "abc" {
+		return "", fmt.Errorf("%w: %s", processes.ErrInstanceNotRunning, id)
+	}
+	return pm.level, nil
+}
+
+func (pm *logLevelProcessManager) SetLogLevel(ctx context.Context, id, level string) (string, error) {
+	if _, err := pm.GetLogLevel(ctx, id); err != nil {
+		return "", err
+	}
+	if level == "loud" {
+		return "", fmt.Errorf("%w: unknown log level", processes.ErrLogLevelRejected)
+	}
+	pm.level = level
+	return level, nil
+}
+
+func TestHandleLogLevel(t *testing.T) {
+	pm := &logLevelProcessManager{level: "info"}
+	p := &Proxy{pm: pm}
+	request := func(method, instanceID, body string) (int, string) {
+		recorder := httptest.NewRecorder()
+		p.handleLogLevel(recorder, httptest.NewRequest(method, "/apps/"+instanceID+"/loglevel", strings.NewReader(body)))
+		var response types.LogLevel
+		json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder.Code, response.Level
+	}
+
+	if status, level := request(http.MethodGet, "abc", ""); status != http.StatusOK || level != "info" {
+		t.Errorf("Expected the current level, got %d %q", status, level)
+	}
+	if status, level := request(http.MethodPut, "abc", `{"level":"warn,database=debug"}`); status != http.StatusOK || level != "warn,database=debug" || pm.level != level {
+		t.Errorf("Expected the level to be changed, got %d %q", status, level)
+	}
+
+	for _, tc := range []struct {
+		name, method, instanceID, body string
+		status                         int
+	}{
+		{"not running", http.MethodGet, "missing", "", http.StatusConflict},
+		{"rejected", http.MethodPut, "abc", `{"level":"loud"}`, http.StatusBadRequest},
+		{"malformed", http.MethodPut, "abc", `level=debug`, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "abc", "", http.StatusMethodNotAllowed},
+	} {
+		if status, _ := request(tc.method, tc.instanceID, tc.body); status != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, status)
+		}
+	}
+	if pm.level != "warn,database=debug" {
+		t.Errorf("Expected failed requests to leave the level alone, got %q", pm.level)
+	}
+}
@@ -0,0 +1,120 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInstanceNotFound is returned by GetAppInstanceByID when no package
+	// is installed for the instance ID.
+	ErrInstanceNotFound = errors.New("application instance not found")
+	// ErrInstanceUnavailable is returned by GetAppInstanceByID when the
+	// instance is installed but could not be brought up in time.
+	ErrInstanceUnavailable = errors.New("application instance unavailable")
+)
+
+// maintenanceRetryAfter is the Retry-After value, in seconds, sent while an
+// instance is unavailable.
+const maintenanceRetryAfter = 10
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.RetryAfter}}">
+<title>Temporarily unavailable</title>
+<style>
+body { font-family: sans-serif; max-width: 32em; margin: 4em auto; color: #333; }
+</style>
+</head>
+<body>
+<h1>Temporarily unavailable</h1>
+<p>This application is starting up or being updated. This page will reload in {{.RetryAfter}} seconds.</p>
+</body>
+</html>
+`
+
+// maintenancePageData is passed to the maintenance page template.
+type maintenancePageData struct {
+	InstanceID string
+	RetryAfter int
+}
+
+var defaultMaintenanceTemplate = template.Must(template.New("maintenance").Parse(defaultMaintenancePage))
+
+// SetMaintenancePage replaces the HTML page served to browsers while an
+// instance is unavailable. The page is an html/template that may refer to
+// {{.InstanceID}} and {{.RetryAfter}}.
+func (p *Proxy) SetMaintenancePage(page string) error {
+	tmpl, err := template.New("maintenance").Parse(page)
+	if err != nil {
+		return fmt.Errorf("invalid maintenance page: %w", err)
+	}
+	p.maintenancePage = tmpl
+	return nil
+}
+
+// MaintenancePageFromEnv reads the maintenance page from the file named by
+// the MAINTENANCE_PAGE environment variable. It returns "" if the variable is
+// unset.
+func MaintenancePageFromEnv() (string, error) {
+	path := os.Getenv("MAINTENANCE_PAGE")
+	if path == "" {
+		return "", nil
+	}
+	page, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read MAINTENANCE_PAGE: %w", err)
+	}
+	return string(page), nil
+}
+
+// serveInstanceError responds to a request whose instance could not be
+// resolved. Unknown instances get a plain 404; instances that are starting or
+// have failed get a 503 with Retry-After, as an HTML page for browsers and
+// JSON for everything else, which says whether the instance is still
+// starting.
+func (p *Proxy) serveInstanceError(w http.ResponseWriter, r *http.Request, traceID, instanceID string, err error) {
+	p.traceInstance(r, instanceID)
+	if !errors.Is(err, ErrInstanceUnavailable) {
+		http.Error(w, "Application instance not found for instance ID "+instanceID, http.StatusNotFound)
+		log.Printf("<%s> %s %s 404 [Application instance not found]", traceID, r.Host, r.URL.Path)
+		return
+	}
+
+	log.Printf("<%s> %s %s 503 [%v]", traceID, r.Host, r.URL.Path, err)
+	w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		tmpl := p.maintenancePage
+		if tmpl == nil {
+			tmpl = defaultMaintenanceTemplate
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := tmpl.Execute(w, maintenancePageData{InstanceID: instanceID, RetryAfter: maintenanceRetryAfter}); err != nil {
+			log.Printf("<%s> Failed to render maintenance page: %v", traceID, err)
+		}
+		return
+	}
+	message := "Application instance is temporarily unavailable"
+	if errors.Is(err, ErrInstanceStarting) {
+		message = "Application instance is still starting, retry in a few seconds"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":      message,
+		"instanceId": instanceID,
+		"starting":   errors.Is(err, ErrInstanceStarting),
+		"retryAfter": maintenanceRetryAfter,
+	})
+}
@@ -0,0 +1,65 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeInstanceErrorNotFound(t *testing.T) {
+	p := &Proxy{}
+	recorder := httptest.NewRecorder()
+	err := fmt.Errorf("%w for app ID abc", ErrInstanceNotFound)
+	p.serveInstanceError(recorder, httptest.NewRequest(http.MethodGet, "/abc/", nil), "trace", "abc", err)
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected a plain 404, got %d with Retry-After %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestServeInstanceErrorUnavailable(t *testing.T) {
+	p := &Proxy{}
+	err := fmt.Errorf("%w: instance not serving for app ID abc", ErrInstanceUnavailable)
+
+	// API clients get JSON
+	recorder := httptest.NewRecorder()
+	p.serveInstanceError(recorder, httptest.NewRequest(http.MethodGet, "/abc/api/items", nil), "trace", "abc", err)
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected 503 with Retry-After, got %d with %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+	var body struct {
+		InstanceID string `json:"instanceId"`
+		RetryAfter int    `json:"retryAfter"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || body.InstanceID != "abc" || body.RetryAfter != 10 {
+		t.Errorf("Unexpected JSON body %q: %v", recorder.Body.String(), err)
+	}
+
+	// Browsers get the configured page
+	if err := p.SetMaintenancePage(`<p>{{.InstanceID}} is down for {{.RetryAfter}}s</p>`); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/abc/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	recorder = httptest.NewRecorder()
+	p.serveInstanceError(recorder, req, "trace", "abc", err)
+	if recorder.Code != http.StatusServiceUnavailable || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected an HTML 503, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if got := recorder.Body.String(); got != "<p>abc is down for 10s</p>" {
+		t.Errorf("Unexpected maintenance page %q", got)
+	}
+}
+
+func TestDefaultMaintenancePage(t *testing.T) {
+	p := &Proxy{}
+	req := httptest.NewRequest(http.MethodGet, "/abc/", nil)
+	req.Header.Set("Accept", "text/html")
+	recorder := httptest.NewRecorder()
+	p.serveInstanceError(recorder, req, "trace", "abc", ErrInstanceUnavailable)
+	if !strings.Contains(recorder.Body.String(), "Temporarily unavailable") {
+		t.Errorf("Expected the built-in page, got %q", recorder.Body.String())
+	}
+}
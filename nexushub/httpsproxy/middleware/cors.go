@@ -1,16 +1,102 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultCORSMaxAge is how long browsers may cache a preflight response,
+// unless configured otherwise. Chromium caps it at 2h.
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSConfig is the CORS policy applied by CorsMiddleware.
+type CORSConfig struct {
+	AllowedOrigin  string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// MaxAge is sent as Access-Control-Max-Age on preflight responses; zero
+	// leaves it out, so browsers preflight every request
+	MaxAge time.Duration
+}
+
+// DefaultCORSConfig returns the policy used unless SetCORSConfig is called.
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		// TODO(tom) STOPSHIP: Allow-list certain origins
+		AllowedOrigin:  "https://www.yellowstone.localhost:8100",
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-Request-Deadline", "Idempotency-Key", "Cache-Control", "X-Time-Format"},
+		MaxAge:         DefaultCORSMaxAge,
+	}
+}
+
+var corsConfig atomic.Pointer[CORSConfig]
+
+// SetCORSConfig replaces the policy applied by CorsMiddleware.
+func SetCORSConfig(config CORSConfig) {
+	corsConfig.Store(&config)
+}
+
+// CORSConfigFromEnv reads the CORS policy from CORS_ALLOWED_ORIGIN,
+// CORS_ALLOWED_METHODS and CORS_ALLOWED_HEADERS (comma-separated lists) and
+// CORS_MAX_AGE (e.g. "1h", or "0" to disable preflight caching). Unset
+// variables keep the DefaultCORSConfig values.
+func CORSConfigFromEnv() (CORSConfig, error) {
+	config := DefaultCORSConfig()
+	if value := os.Getenv("CORS_ALLOWED_ORIGIN"); value != "" {
+		config.AllowedOrigin = value
+	}
+	if value := os.Getenv("CORS_ALLOWED_METHODS"); value != "" {
+		config.AllowedMethods = splitList(value)
+	}
+	if value := os.Getenv("CORS_ALLOWED_HEADERS"); value != "" {
+		config.AllowedHeaders = splitList(value)
+	}
+	if value := os.Getenv("CORS_MAX_AGE"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge < 0 {
+			return CORSConfig{}, fmt.Errorf("invalid CORS_MAX_AGE %q: expected a duration", value)
+		}
+		config.MaxAge = maxAge
+	}
+	if len(config.AllowedMethods) == 0 {
+		return CORSConfig{}, fmt.Errorf("CORS_ALLOWED_METHODS lists no methods")
+	}
+	return config, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// CorsMiddleware sets the CORS headers of the configured policy and answers
+// preflight requests, calling next for every other request.
 func CorsMiddleware(w http.ResponseWriter, r *http.Request, next func(w http.ResponseWriter, r *http.Request)) {
-	// TODO(tom) STOPSHIP: Allow-list certain origins
-	w.Header().Set("Access-Control-Allow-Origin", "https://www.yellowstone.localhost:8100")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	config := corsConfig.Load()
+	if config == nil {
+		defaultConfig := DefaultCORSConfig()
+		config = &defaultConfig
+	}
+	w.Header().Set("Access-Control-Allow-Origin", config.AllowedOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	if r.Method == http.MethodOptions {
+		if seconds := int(config.MaxAge / time.Second); seconds > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(seconds))
+		}
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSConfigFromEnv(t *testing.T) {
+	config, err := CORSConfigFromEnv()
+	if err != nil || config.MaxAge != DefaultCORSMaxAge || config.AllowedOrigin != DefaultCORSConfig().AllowedOrigin {
+		t.Errorf("Expected the default config, got %+v: %v", config, err)
+	}
+
+	t.Setenv("CORS_ALLOWED_ORIGIN", "https://app.example.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET, POST,")
+	t.Setenv("CORS_ALLOWED_HEADERS", "Authorization")
+	t.Setenv("CORS_MAX_AGE", "1h")
+	config, err = CORSConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AllowedOrigin != "https://app.example.com" || len(config.AllowedMethods) != 2 || config.AllowedMethods[1] != "POST" ||
+		len(config.AllowedHeaders) != 1 || config.MaxAge != time.Hour {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for name, value := range map[string]string{"CORS_MAX_AGE": "soon", "CORS_ALLOWED_METHODS": " , "} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := CORSConfigFromEnv(); err == nil {
+				t.Errorf("Expected %s=%q to be rejected", name, value)
+			}
+		})
+	}
+}
+
+func TestCorsMiddleware(t *testing.T) {
+	defer SetCORSConfig(DefaultCORSConfig())
+	SetCORSConfig(CORSConfig{
+		AllowedOrigin:  "https://app.example.com",
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         90 * time.Second,
+	})
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request) { called = true }
+
+	recorder := httptest.NewRecorder()
+	CorsMiddleware(recorder, httptest.NewRequest(http.MethodOptions, "/apps/list", nil), next)
+	header := recorder.Header()
+	if called || recorder.Code != http.StatusOK {
+		t.Errorf("Expected the preflight to be answered, got %d (next called: %v)", recorder.Code, called)
+	}
+	if header.Get("Access-Control-Allow-Origin") != "https://app.example.com" || header.Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+		header.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" || header.Get("Access-Control-Max-Age") != "90" {
+		t.Errorf("Unexpected preflight headers %v", header)
+	}
+
+	recorder = httptest.NewRecorder()
+	CorsMiddleware(recorder, httptest.NewRequest(http.MethodGet, "/apps/list", nil), next)
+	if !called || recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || recorder.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("Expected the request to be served with CORS headers only, got %v", recorder.Header())
+	}
+
+	// A zero max age leaves preflight caching to the browser's default
+	SetCORSConfig(CORSConfig{AllowedOrigin: "*", AllowedMethods: []string{"GET"}})
+	recorder = httptest.NewRecorder()
+	CorsMiddleware(recorder, httptest.NewRequest(http.MethodOptions, "/apps/list", nil), next)
+	if _, ok := recorder.Header()["Access-Control-Max-Age"]; ok {
+		t.Errorf("Expected no Access-Control-Max-Age, got %v", recorder.Header())
+	}
+}
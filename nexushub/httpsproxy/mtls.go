@@ -0,0 +1,98 @@
+package httpsproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MTLSConfig enables an internal listener that requires client certificates
+// signed by ClientCAs. Requests over it are authorized by their certificate
+// and skip the bearer token check; requests over the main listener still
+// need a token or the internal secret.
+type MTLSConfig struct {
+	// ListenAddr is the address of the internal listener, e.g. ":8444".
+	ListenAddr string
+	// ClientCAs verifies client certificates.
+	ClientCAs *x509.CertPool
+	// PathPrefixes limits the paths a client certificate authorizes. Requests
+	// for other paths on the internal listener need a bearer token as usual.
+	// Empty means all paths.
+	PathPrefixes []string
+}
+
+// MTLSConfigFromEnv reads the mTLS configuration from MTLS_LISTEN_ADDR,
+// MTLS_CLIENT_CA (a PEM file of CA certificates) and MTLS_PATH_PREFIXES
+// (comma-separated). It returns nil if MTLS_LISTEN_ADDR is unset.
+func MTLSConfigFromEnv() (*MTLSConfig, error) {
+	addr := os.Getenv("MTLS_LISTEN_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	caFile := os.Getenv("MTLS_CLIENT_CA")
+	if caFile == "" {
+		return nil, fmt.Errorf("MTLS_CLIENT_CA is required when MTLS_LISTEN_ADDR is set")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MTLS_CLIENT_CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in MTLS_CLIENT_CA %s", caFile)
+	}
+	config := &MTLSConfig{ListenAddr: addr, ClientCAs: pool}
+	for _, prefix := range strings.Split(os.Getenv("MTLS_PATH_PREFIXES"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			if !strings.HasPrefix(prefix, "/") {
+				return nil, fmt.Errorf("invalid MTLS_PATH_PREFIXES entry %q: expected a path starting with /", prefix)
+			}
+			config.PathPrefixes = append(config.PathPrefixes, prefix)
+		}
+	}
+	return config, nil
+}
+
+// SetMTLS enables the internal mTLS listener when the proxy starts in HTTPS
+// mode.
+func (p *Proxy) SetMTLS(config *MTLSConfig) error {
+	if config != nil && (config.ListenAddr == "" || config.ClientCAs == nil) {
+		return fmt.Errorf("mTLS requires a listen address and client CAs")
+	}
+	p.mtls = config
+	return nil
+}
+
+// tlsConfig derives the internal listener's TLS configuration from the main
+// listener's, additionally requiring a verified client certificate.
+func (config *MTLSConfig) tlsConfig(base *tls.Config) *tls.Config {
+	tlsConfig := base.Clone()
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.ClientCAs = config.ClientCAs
+	return tlsConfig
+}
+
+// clientCertSubject returns the subject of the verified client certificate
+// of a request over the internal listener, if that certificate authorizes the
+// request's path.
+func (p *Proxy) clientCertSubject(r *http.Request) (string, bool) {
+	if p.mtls == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	if len(p.mtls.PathPrefixes) > 0 {
+		allowed := false
+		for _, prefix := range p.mtls.PathPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", false
+		}
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String(), true
+}
@@ -0,0 +1,182 @@
+package httpsproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// testCA issues client certificates for the mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issueClientCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// startMTLSServer serves a handler reporting the result of authorize over a
+// listener configured like the proxy's internal listener.
+func startMTLSServer(t *testing.T, config *MTLSConfig) *httptest.Server {
+	p := &Proxy{secrets: secrets.NewStore(time.Minute)}
+	if err := p.SetMTLS(config); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := p.authorize(r)
+		fmt.Fprintf(w, "%d %v", userID, ok)
+	}))
+	// StartTLS fills in its own test certificate
+	server.TLS = config.tlsConfig(&tls.Config{})
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func mtlsGet(t *testing.T, server *httptest.Server, path string, cert *tls.Certificate) (string, error) {
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL + path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func TestMTLSAuthorizesClientCertificates(t *testing.T) {
+	ca := newTestCA(t, "internal CA")
+	server := startMTLSServer(t, &MTLSConfig{ListenAddr: ":0", ClientCAs: ca.pool()})
+
+	cert := ca.issueClientCert(t, "app-1")
+	if body, err := mtlsGet(t, server, "/internal/crash-reports", &cert); err != nil || body != "0 true" {
+		t.Errorf("Expected a valid client certificate to be authorized, got %q: %v", body, err)
+	}
+
+	// No certificate, or one from another CA, fails the handshake
+	if _, err := mtlsGet(t, server, "/internal/crash-reports", nil); err == nil {
+		t.Error("Expected a client without a certificate to be refused")
+	}
+	other := newTestCA(t, "other CA").issueClientCert(t, "app-1")
+	if _, err := mtlsGet(t, server, "/internal/crash-reports", &other); err == nil {
+		t.Error("Expected a certificate from another CA to be refused")
+	}
+}
+
+func TestMTLSPathPrefixes(t *testing.T) {
+	ca := newTestCA(t, "internal CA")
+	server := startMTLSServer(t, &MTLSConfig{ListenAddr: ":0", ClientCAs: ca.pool(), PathPrefixes: []string{"/internal/"}})
+
+	cert := ca.issueClientCert(t, "app-1")
+	if body, _ := mtlsGet(t, server, "/internal/crash-reports", &cert); body != "0 true" {
+		t.Errorf("Expected /internal/ to be authorized by the certificate, got %q", body)
+	}
+	if body, _ := mtlsGet(t, server, "/apps/install", &cert); body != "0 false" {
+		t.Errorf("Expected other paths to still need a token, got %q", body)
+	}
+}
+
+func TestMTLSIgnoredOnMainListener(t *testing.T) {
+	// Without a verified chain, as on the main listener, the bearer check
+	// applies
+	ca := newTestCA(t, "internal CA")
+	p := &Proxy{secrets: secrets.NewStore(time.Minute)}
+	p.SetMTLS(&MTLSConfig{ListenAddr: ":0", ClientCAs: ca.pool()})
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/apps/list", nil)
+	r.TLS = &tls.ConnectionState{}
+	if _, ok := p.authorize(r); ok {
+		t.Error("Expected a request without a client certificate to be unauthorized")
+	}
+}
+
+func TestMTLSConfigFromEnv(t *testing.T) {
+	if config, err := MTLSConfigFromEnv(); config != nil || err != nil {
+		t.Errorf("Expected mTLS to be disabled by default, got %+v: %v", config, err)
+	}
+
+	ca := newTestCA(t, "internal CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644)
+
+	t.Setenv("MTLS_LISTEN_ADDR", ":8444")
+	if _, err := MTLSConfigFromEnv(); err == nil {
+		t.Error("Expected an error without MTLS_CLIENT_CA")
+	}
+	t.Setenv("MTLS_CLIENT_CA", caFile)
+	t.Setenv("MTLS_PATH_PREFIXES", "/internal/, /events/publish")
+	config, err := MTLSConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ListenAddr != ":8444" || len(config.PathPrefixes) != 2 || config.PathPrefixes[1] != "/events/publish" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	t.Setenv("MTLS_PATH_PREFIXES", "internal")
+	if _, err := MTLSConfigFromEnv(); err == nil {
+		t.Error("Expected an error for a relative path prefix")
+	}
+}
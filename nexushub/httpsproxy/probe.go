@@ -0,0 +1,39 @@
+package httpsproxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// handleProbe serves /apps/{id}/probe/{name}: it runs the instance's named
+// probe, see applib.AddProbe, and reports the result with status 200 if the
+// probe passed and 503 if it failed, so monitors can go by the status alone.
+func (p *Proxy) handleProbe(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(r.URL.Path, "/")
+	instanceID, name := segments[2], segments[4]
+	result, err := p.pm.RunProbe(r.Context(), instanceID, name)
+	switch {
+	case errors.Is(err, processes.ErrInstanceNotRunning):
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusConflict)
+	case errors.Is(err, processes.ErrProbeNotFound):
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusNotFound)
+	case err != nil:
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadGateway)
+	default:
+		body, err := httputils.MarshalJSON(result, httputils.RequestTimeFormat(r))
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !result.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(body)
+	}
+}
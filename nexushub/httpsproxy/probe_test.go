@@ -0,0 +1,63 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// probeProcessManager runs instance "abc", whose "db" probe passes and
+// "cache" probe fails.
+type probeProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+}
+
+func (pm *probeProcessManager) RunProbe(ctx context.Context, id, name string) (*types.ProbeResult, error) {
+	switch {
+	case id != "abc":
+		return nil, fmt.Errorf("%w: %s", processes.ErrInstanceNotRunning, id)
+	case name == "db":
+		return &types.ProbeResult{Name: name, Healthy: true, LatencyMs: 4}, nil
+	case name == "cache":
+		return &types.ProbeResult{Name: name, Error: "timed out after 5s"}, nil
+	case name == "broken":
+		return nil, fmt.Errorf("probe %s of %s failed: connection refused", name, id)
+	}
+	return nil, fmt.Errorf("%w: %s has no probe %s", processes.ErrProbeNotFound, id, name)
+}
+
+func TestHandleProbe(t *testing.T) {
+	p := &Proxy{pm: &probeProcessManager{}}
+	for _, tc := range []struct {
+		path    string
+		status  int
+		healthy bool
+	}{
+		{"/apps/abc/probe/db", http.StatusOK, true},
+		{"/apps/abc/probe/cache", http.StatusServiceUnavailable, false},
+		{"/apps/abc/probe/missing", http.StatusNotFound, false},
+		{"/apps/abc/probe/broken", http.StatusBadGateway, false},
+		{"/apps/def/probe/db", http.StatusConflict, false},
+	} {
+		recorder := httptest.NewRecorder()
+		p.handleProbe(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.path, tc.status, recorder.Code, recorder.Body)
+			continue
+		}
+		if recorder.Header().Get("Content-Type") != "application/json" {
+			continue
+		}
+		var result types.ProbeResult
+		if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil || result.Healthy != tc.healthy {
+			t.Errorf("%s: unexpected result %+v: %v", tc.path, result, err)
+		}
+	}
+}
@@ -3,33 +3,59 @@ package httpsproxy
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"log/slog"
 	"net"
 	"net/http" // For file system operations
 	"net/http/httputil"
 	"net/url"
-	"strconv"
+	"sync"
+	"sync/atomic"
 
 	// For path manipulation
 	"strings" // For string manipulation
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/chunkstore"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/crashes"
+	"github.com/tomyedwab/yesterday/nexushub/desiredstate"
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
+	"github.com/tomyedwab/yesterday/nexushub/eventauth"
 	"github.com/tomyedwab/yesterday/nexushub/events"
 	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
 	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/middleware"
 	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/idempotency"
 	"github.com/tomyedwab/yesterday/nexushub/internal/handlers"
 	app_handlers "github.com/tomyedwab/yesterday/nexushub/internal/handlers/applications"
+	crash_handlers "github.com/tomyedwab/yesterday/nexushub/internal/handlers/crashes"
+	desiredstate_handlers "github.com/tomyedwab/yesterday/nexushub/internal/handlers/desiredstate"
 	event_handlers "github.com/tomyedwab/yesterday/nexushub/internal/handlers/events"
 	"github.com/tomyedwab/yesterday/nexushub/internal/handlers/login"
 	"github.com/tomyedwab/yesterday/nexushub/packages"
 	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+	"github.com/tomyedwab/yesterday/nexushub/traces"
+	"github.com/tomyedwab/yesterday/nexushub/userdata"
 )
 
+// requestTimeout bounds how long the proxy waits on a single request. It is
+// used for the server's read/write timeouts and as the default deadline sent
+// to backends in the X-Request-Deadline header.
+const requestTimeout = 60 * time.Second
+
+// statusClientClosedRequest is logged when the client disconnects before the
+// backend responds, following the nginx convention.
+const statusClientClosedRequest = 499
+
 // Proxy represents the HTTPS reverse proxy server.
 // It listens for incoming HTTPS requests, terminates SSL, and proxies them
 // to the appropriate backend service based on the URL path or host name.
@@ -44,9 +70,69 @@ type Proxy struct {
 	packageManager *packages.PackageManager
 	server         *http.Server
 	transport      *http.Transport
-	internalSecret string
+	secrets        *secrets.Store
 	debugHandler   *handlers.DebugHandler
 	eventManager   *events.EventManager
+	crashStore     *crashes.Store
+	hostRoutes     map[string]string
+	tlsPolicy      TLSPolicy
+	// idempotencyStore records responses to requests with an
+	// Idempotency-Key; nil disables idempotency keys.
+	idempotencyStore *idempotency.Store
+	// mtls enables the internal listener authorized by client certificates;
+	// nil disables it.
+	mtls         *MTLSConfig
+	mtlsServer   *http.Server
+	shuttingDown atomic.Bool
+	// diskWatchdog reports when free disk space runs low; nil never does.
+	diskWatchdog *diskspace.Watchdog
+	// userData exports and forgets users' data; nil disables the user data
+	// endpoints.
+	userData *userdata.Service
+	// desiredState holds the history of the desired instances; nil disables
+	// the desired state endpoint.
+	desiredState *desiredstate.Store
+	// traces indexes the requests traceConfig samples; nil disables the
+	// trace index and its endpoint.
+	traces      *traces.Store
+	traceConfig traces.Config
+	// quotas enforces instances' quotas and reports their usage; nil
+	// enforces none and disables the usage endpoints.
+	quotas *quotas.Collector
+	// publishAuth refuses publishes of event types the caller may not
+	// publish; nil allows every publish.
+	publishAuth *eventauth.Authorizer
+	// userRoles are the users' roles, see Authorizer.SetRoles
+	userRoles eventauth.Roles
+	// maintenancePage is served to browsers while an instance is
+	// unavailable; nil means the built-in page.
+	maintenancePage *template.Template
+	// coldStartTimeout is how long requests wait for an instance to start;
+	// zero means DefaultColdStartTimeout.
+	coldStartTimeout time.Duration
+	// coldStarts and metricsHandler are set by EnableMetrics.
+	coldStarts     *prometheus.HistogramVec
+	metricsHandler http.Handler
+	// shadows mirror instances' reads to shadow instances, keyed by instance
+	// ID, see SetShadowRoutes; shadowSlots bounds the mirrored requests in
+	// flight. shadowRequests and shadowLatencyDelta are set by
+	// EnableShadowMetrics.
+	shadows            map[string]*shadow
+	shadowSlots        chan struct{}
+	shadowRequests     *prometheus.CounterVec
+	shadowLatencyDelta *prometheus.SummaryVec
+	// instanceLookups coalesces concurrent GetAppInstanceByID calls.
+	instanceLookups instanceLookups
+	// cloneHosts routes the host names of clones to their instance IDs, see
+	// handleClone; cloneHostSuffix names them, DefaultCloneHostSuffix if
+	// empty.
+	cloneHostsMu    sync.RWMutex
+	cloneHosts      map[string]string
+	cloneHostSuffix string
+	// authorizer and routes are created by setup on first use.
+	setupOnce  sync.Once
+	authorizer *Authorizer
+	routes     routeTable[routeHandler]
 }
 
 // NewProxy creates and returns a new Proxy instance.
@@ -56,8 +142,8 @@ func NewProxy(
 	listenAddr,
 	host,
 	certFile,
-	keyFile,
-	internalSecret string,
+	keyFile string,
+	secretStore *secrets.Store,
 	httpMode bool,
 	pm httpsproxy_types.ProcessManagerInterface,
 	packageManager *packages.PackageManager,
@@ -67,15 +153,12 @@ func NewProxy(
 		Timeout:   600 * time.Second,
 		KeepAlive: 600 * time.Second,
 	}
-	transport := &http.Transport{
-		Proxy:               http.ProxyFromEnvironment,
-		Dial:                dialer.Dial,
-		TLSHandshakeTimeout: 180 * time.Second,
-	}
+	transport := processes.NewBackendTransport(&dialer)
+	transport.TLSHandshakeTimeout = 180 * time.Second
 
 	// Create logger for debug handler
 	logger := slog.Default()
-	debugHandler := handlers.NewDebugHandler(pm, logger, internalSecret)
+	debugHandler := handlers.NewDebugHandler(pm, logger, secretStore)
 
 	return &Proxy{
 		listenAddr:     listenAddr,
@@ -86,19 +169,55 @@ func NewProxy(
 		pm:             pm,
 		packageManager: packageManager,
 		transport:      transport,
-		internalSecret: internalSecret,
+		secrets:        secretStore,
 		debugHandler:   debugHandler,
 		eventManager:   eventManager,
+		tlsPolicy:      DefaultTLSPolicy(),
 	}
 }
 
+// SetDesiredStateStore enables the desired state history endpoint, reading
+// snapshots from the given store.
+func (p *Proxy) SetDesiredStateStore(store *desiredstate.Store) {
+	p.desiredState = store
+}
+
+// SetPublishAuthorizer refuses publishes of event types the caller may not
+// publish, as the authorizer decides.
+func (p *Proxy) SetPublishAuthorizer(authorizer *eventauth.Authorizer) {
+	p.publishAuth = authorizer
+}
+
+// SetUserRoles sets the users' roles. Only users with the admin role may use
+// the endpoints that operate the hub itself, see RouteAdmin.
+func (p *Proxy) SetUserRoles(roles eventauth.Roles) {
+	p.userRoles = roles
+}
+
+// SetCrashStore enables the crash report endpoints, recording reports in the
+// given store.
+func (p *Proxy) SetCrashStore(store *crashes.Store) {
+	p.crashStore = store
+}
+
+// SetChunkStore enables deduplicated debug uploads, which store package
+// chunks in the given content-addressed store.
+func (p *Proxy) SetChunkStore(store *chunkstore.Store) {
+	p.debugHandler.SetChunkStore(store)
+}
+
+// SetLogStreamConfig limits streaming of debug application logs.
+func (p *Proxy) SetLogStreamConfig(config handlers.LogStreamConfig) {
+	p.debugHandler.SetLogStreamConfig(config)
+}
+
 func (p *Proxy) Start(contextFn func(net.Listener) context.Context) error {
 	p.server = &http.Server{
 		BaseContext:  contextFn,
 		Addr:         p.listenAddr,
 		Handler:      http.HandlerFunc(p.handleRequest),
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
+		ReadTimeout:  requestTimeout,
+		WriteTimeout: requestTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -113,152 +232,355 @@ func (p *Proxy) Start(contextFn func(net.Listener) context.Context) error {
 			return err // Return error instead of panic to allow main to handle
 		}
 
-		p.server.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
+		p.server.TLSConfig = p.tlsPolicy.tlsConfig(cert)
+		p.server.Handler = p.tlsPolicy.requireHTTP2(p.server.Handler)
+
+		if p.mtls != nil {
+			if err := p.startMTLS(contextFn); err != nil {
+				return err
+			}
 		}
 
 		log.Printf("Starting HTTPS proxy server on %s", p.listenAddr)
+		log.Printf("TLS policy: %s", p.tlsPolicy)
 		return p.server.ListenAndServeTLS("", "") // Cert and key are in TLSConfig
 	}
 }
 
-// handleRequest is the HTTP handler function for the proxy.
-// It checks for "X-Application-Id" header. If set, uses it to find the AppInstance.
-// Otherwise, it extracts the hostname from the request and resolves it to a backend AppInstance.
-// If the instance has a StaticPath and the requested resource exists as a file, it serves the static file.
-// Otherwise, it proxies the request to that instance.
-// If resolution fails or the backend is unavailable, it returns an appropriate error.
-func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
-	//var err error
-	//var resolutionIdentifier string // For logging: "AppID 'xyz'" or "hostname 'abc.com'"
-	//var originalHostForLog string = r.Host // Capture original host for logging before it's modified
+// routeHandler serves a request the Authorizer allowed.
+type routeHandler func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision)
 
-	traceID := uuid.New().String()
+// handle registers the handler of a route. It panics if the pattern's class
+// is not declared in routeClasses, so that no route is served without a
+// decision on how it is authorized.
+func (p *Proxy) handle(pattern string, handler routeHandler) {
+	if _, ok := routeClasses[pattern]; !ok {
+		panic(fmt.Sprintf("httpsproxy: route %s has no declared class", pattern))
+	}
+	if _, ok := p.routes[pattern]; ok {
+		panic(fmt.Sprintf("httpsproxy: route %s registered twice", pattern))
+	}
+	p.routes[pattern] = handler
+}
+
+// served adapts a plain handler into a route handler that logs the request
+// once it has been served.
+func served(handler http.HandlerFunc) routeHandler {
+	return func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		handler(w, r)
+		log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+	}
+}
+
+// withCORS wraps a handler with the CORS headers and preflight handling.
+func withCORS(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		middleware.CorsMiddleware(w, r, handler)
+	}
+}
+
+// allowMethod wraps a handler to refuse requests with other methods.
+func allowMethod(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// setup creates the proxy's Authorizer and registers its routes on first use.
+func (p *Proxy) setup() {
+	p.setupOnce.Do(func() {
+		p.authorizer = NewAuthorizer(p.secrets, p.clientCertSubject)
+		p.authorizer.SetRoles(p.userRoles)
+		p.routes = make(routeTable[routeHandler])
+		p.registerRoutes()
+		p.loadCloneHosts()
+	})
+}
 
-	// Handle debug API endpoints first
-	if strings.HasPrefix(r.URL.Path, "/debug/application") {
-		// TODO(tom) STOPSHIP deprecate all this
-		if r.URL.Path == "/debug/application" && r.Method == http.MethodPost {
-			p.debugHandler.HandleCreateApplication(w, r)
+// registerRoutes registers the handlers of every route in routeClasses.
+func (p *Proxy) registerRoutes() {
+	p.handle("/healthz", func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		p.handleHealth(w, r)
+	})
+	p.handle("/readyz", func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		p.handleHealth(w, r)
+	})
+
+	// Login endpoints
+	p.handle("/public/logout", served(withCORS(login.HandleLogout)))
+	p.handle("/public/logout_all", served(withCORS(login.HandleLogoutAll)))
+	p.handle("/public/login", p.adminHandler(login.HandleLogin))
+	p.handle("/public/access_token", p.adminHandler(login.HandleAccessToken))
+	// Resource servers outside the hub check access tokens here
+	introspector := login.NewIntrospector(login.DefaultIntrospectionRateLimit, login.DefaultInactiveAlertThreshold, clock.Real)
+	p.handle("/public/introspect", served(allowMethod(http.MethodPost, introspector.HandleIntrospect)))
+
+	// Debug API
+	p.handle("/debug/application", func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		withCORS(p.handleDebug)(w, r)
+	})
+	p.handle("/debug/application/", func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		withCORS(p.handleDebug)(w, r)
+	})
+	p.handle("/debug/application/*/events/", served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+		p.debugHandler.HandleEventLog(w, r)
+	})))
+	p.handle("/debug/trace/*", served(withCORS(allowMethod(http.MethodGet, p.handleTrace))))
+
+	// Application registration endpoints
+	p.handle("/apps/register", served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+		app_handlers.HandleRegistration(w, r, p.packageManager)
+	})))
+	p.handle("/apps/list", served(withCORS(allowMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		app_handlers.HandleList(w, r, p.packageManager, p.quotas)
+	}))))
+	p.handle("/apps/install", served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+		app_handlers.HandleInstall(w, r, p.packageManager, p.pm)
+	})))
+	p.handle("/apps/uninstall", served(withCORS(allowMethod(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		app_handlers.HandleUninstall(w, r, p.packageManager, p.pm)
+	}))))
+
+	// Package and database downloads: /apps/{instanceID}/package|database
+	p.handle("/apps/*/package", served(allowMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		app_handlers.HandlePackageDownload(w, r, p.packageManager, strings.Split(r.URL.Path, "/")[2])
+	})))
+	p.handle("/apps/*/database", served(allowMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		app_handlers.HandleDatabaseDownload(w, r, p.packageManager, strings.Split(r.URL.Path, "/")[2])
+	})))
+
+	// Clones of instances for experimenting against a copy of their data
+	p.handle("/apps/*/clone", served(withCORS(p.handleClone)))
+
+	// Runtime log levels of running instances
+	p.handle("/apps/*/loglevel", served(withCORS(p.handleLogLevel)))
+
+	// Usage and limits of instances' quotas
+	p.handle("/apps/usage", served(withCORS(allowMethod(http.MethodGet, p.handleUsage))))
+	p.handle("/apps/*/quota", served(withCORS(p.handleQuota)))
+
+	// Restarts of single instances, e.g. to recover one that is stuck
+	p.handle("/apps/*/restart", served(withCORS(allowMethod(http.MethodPost, p.handleRestart))))
+
+	// Application probes run on behalf of external monitors
+	p.handle("/apps/*/probe/*", served(withCORS(allowMethod(http.MethodGet, p.handleProbe))))
+
+	// Comparison of instances with their shadows
+	p.handle("/apps/*/shadow-report", served(withCORS(allowMethod(http.MethodGet, p.handleShadowReport))))
+
+	// Internal secret rotation
+	p.handle("/secrets/rotate", served(withCORS(p.handleRotateSecret)))
+	p.handle("/tls/rotate-ca", served(withCORS(p.handleRotateBackendCA)))
+
+	// User data exports and deletions
+	userData := func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		if p.userData == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
 			return
 		}
-		if strings.HasPrefix(r.URL.Path, "/debug/application/") && r.Method == http.MethodDelete {
-			p.debugHandler.HandleDeleteApplication(w, r)
+		served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+			p.handleUserData(w, r, decision)
+		}))(w, r, traceID, decision)
+	}
+	p.handle("/users/export", userData)
+	p.handle("/users/forget", userData)
+
+	// Crash reports
+	p.handle("/internal/crash-reports", served(func(w http.ResponseWriter, r *http.Request) {
+		if p.crashStore == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
 			return
 		}
-		// Handle upload endpoints
-		if strings.Contains(r.URL.Path, "/upload") {
-			if strings.HasSuffix(r.URL.Path, "/upload") && r.Method == http.MethodPost {
-				p.debugHandler.HandleUpload(w, r)
-				return
-			}
-			if strings.HasSuffix(r.URL.Path, "/upload/status") && r.Method == http.MethodGet {
-				p.debugHandler.HandleUploadStatus(w, r)
+		crash_handlers.HandleReport(w, r, p.crashStore)
+	}))
+	p.handle("/apps/crashes", served(withCORS(allowMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		if p.crashStore == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		crash_handlers.HandleList(w, r, p.crashStore)
+	}))))
+
+	// Desired state history
+	p.handle("/apps/desired-state", served(withCORS(allowMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		if p.desiredState == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		desiredstate_handlers.HandleGet(w, r, p.desiredState)
+	}))))
+
+	// Event endpoints
+	p.handle("/events/publish", func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+			if p.diskWatchdog.Reject(w, diskspace.LevelCritical) {
+				log.Printf("<%s> %s %s => 507 [Disk space critical]", traceID, r.Host, r.URL.Path)
 				return
 			}
-		}
-		// Handle status endpoint
-		if strings.HasSuffix(r.URL.Path, "/status") && r.Method == http.MethodGet {
-			p.debugHandler.HandleApplicationStatus(w, r)
+			caller := eventauth.Caller{UserID: decision.Token.UserID, Internal: decision.Internal}
+			event_handlers.HandleEventPublish(w, r, caller, p.publishAuth, p.eventManager, p.pm, p.quotas)
+		}))(w, r, traceID, decision)
+	})
+	p.handle("/events/stats", served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+		event_handlers.HandleEventStats(w, r, p.eventManager, p.packageManager)
+	})))
+	p.handle("/events/poll", served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+		event_handlers.HandleEventPoll(w, r, p.packageManager, p.pm)
+	})))
+	p.handle("/metrics", served(allowMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		if p.metricsHandler == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
 			return
 		}
-		// Handle logs endpoint
-		if strings.HasSuffix(r.URL.Path, "/logs") && r.Method == http.MethodGet {
-			p.debugHandler.HandleLogStream(w, r)
+		p.metricsHandler.ServeHTTP(w, r)
+	})))
+
+	// Application instances
+	p.handle("/", func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		p.handleApplication(w, r, traceID)
+	})
+}
+
+// adminHandler serves a login endpoint that calls the Admin application.
+func (p *Proxy) adminHandler(handler func(w http.ResponseWriter, r *http.Request, adminHost string)) routeHandler {
+	return func(w http.ResponseWriter, r *http.Request, traceID string, decision Decision) {
+		instance, port, err := p.GetAppInstanceByID("MBtskI6D")
+		if err != nil {
+			p.serveInstanceError(w, r, traceID, "MBtskI6D", err)
 			return
 		}
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
+		adminHost := instance.BackendURL(port)
+		served(withCORS(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r, adminHost)
+		}))(w, r, traceID, decision)
 	}
+}
 
-	// Login endpoints
+// handleRequest is the HTTP handler function for the proxy. The Authorizer
+// decides the request by the class of the route it matches, and allowed
+// requests are served by the route's handler. Every request gets a trace ID,
+// returned in the X-Trace-ID header, and may be recorded in the trace index.
+// Requests authorized by an access token are forwarded with the token's user
+// ID. Requests for an application instance with a StaticPath are served the
+// requested file if it exists there, and proxied to the instance otherwise.
+func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	p.setup()
+	traceID := uuid.New().String()
+	// Clients can quote it when reporting a failed request
+	w.Header().Set(httputils.TraceIDHeader, traceID)
+	if p.traces != nil {
+		var finishTrace func()
+		w, r, finishTrace = p.beginTrace(w, r, traceID)
+		defer finishTrace()
+	}
 
-	if r.URL.Path == "/public/logout" {
-		middleware.CorsMiddleware(w, r, login.HandleLogout)
-		log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+	// Applications trust the user ID header, so only the proxy may set it.
+	r.Header.Del(httputils.UserIDHeader)
+	decision := p.authorizer.Authorize(r)
+	if !decision.Allowed() {
+		if decision.PasswordExpired {
+			w.Header().Set(access.PasswordExpiredHeader, "true")
+			http.Error(w, "Password change required", decision.Status)
+		} else {
+			http.Error(w, http.StatusText(decision.Status), decision.Status)
+		}
+		log.Printf("<%s> %s %s => %d [%s]", traceID, r.Host, r.URL.Path, decision.Status, decision.Reason)
 		return
 	}
-	if r.URL.Path == "/public/login" || r.URL.Path == "/public/access_token" {
-		_, port, err := p.GetAppInstanceByID("MBtskI6D")
-		if err != nil {
-			http.Error(w, "Service not found for admin", http.StatusNotFound)
-			log.Printf("<%s> %s %s 404 [Service not found]", traceID, r.Host, r.URL.Path)
+	userID := decision.Token.UserID
+	if userID != 0 {
+		httputils.SetUserID(r.Header, userID)
+	}
+
+	handler, ok := p.routes[decision.Pattern]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		log.Printf("<%s> %s %s 404 [No route found]", traceID, r.Host, r.URL.Path)
+		return
+	}
+
+	// Replays of mutating requests with an Idempotency-Key get the stored
+	// response instead of being executed again
+	completed := false
+	if decision.Class == RouteBearerAuth || decision.Class == RouteAdmin || decision.Class == RouteInternalOnly {
+		var finishIdempotent func(completed bool)
+		w, finishIdempotent, ok = p.beginIdempotentRequest(w, r, traceID, userID)
+		if !ok {
 			return
 		}
-		adminHost := fmt.Sprintf("http://localhost:%d", port)
+		defer func() { finishIdempotent(completed) }()
+	}
+
+	handler(w, r, traceID, decision)
+	completed = true
+}
 
-		if r.URL.Path == "/public/login" {
-			middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-				login.HandleLogin(w, r, adminHost)
-			})
-			log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+// handleDebug serves the debug API, except for the event log endpoints.
+func (p *Proxy) handleDebug(w http.ResponseWriter, r *http.Request) {
+	// TODO(tom) STOPSHIP deprecate all this
+	if r.URL.Path == "/debug/application" && r.Method == http.MethodPost {
+		p.debugHandler.HandleCreateApplication(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/debug/application/") && r.Method == http.MethodDelete {
+		p.debugHandler.HandleDeleteApplication(w, r)
+		return
+	}
+	// Handle upload endpoints
+	if strings.Contains(r.URL.Path, "/upload") {
+		if strings.HasSuffix(r.URL.Path, "/upload") && r.Method == http.MethodPost {
+			p.debugHandler.HandleUpload(w, r)
 			return
 		}
-		if r.URL.Path == "/public/access_token" {
-			middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-				login.HandleAccessToken(w, r, adminHost)
-			})
-			log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/upload/status") && r.Method == http.MethodGet {
+			p.debugHandler.HandleUploadStatus(w, r)
 			return
 		}
-	}
-
-	// Validate authorization for API endpoints
-	if r.Method != "OPTIONS" {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			log.Printf("<%s> %s %s => 401 [Missing token]", traceID, r.Host, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/upload/manifest") && r.Method == http.MethodPost {
+			p.debugHandler.HandleUploadManifest(w, r)
 			return
 		}
-
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-
-		valid := token == p.internalSecret
-		if !valid {
-			// Get audit logger from context (may be nil if not set)
-			var auditLogger *audit.Logger
-			if al := r.Context().Value(audit.AuditLoggerKey); al != nil {
-				auditLogger = al.(*audit.Logger)
-			}
-			valid = access.ValidateAccessToken(token, auditLogger)
+		if strings.HasSuffix(r.URL.Path, "/upload/chunk") && r.Method == http.MethodPost {
+			p.debugHandler.HandleUploadDedupChunk(w, r)
+			return
 		}
-		if !valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			log.Printf("<%s> %s %s => 401 [Invalid token]", traceID, r.Host, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/upload/commit") && r.Method == http.MethodPost {
+			p.debugHandler.HandleUploadCommit(w, r)
 			return
 		}
 	}
-
-	// Application registration endpoints
-	if r.URL.Path == "/apps/register" {
-		middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-			app_handlers.HandleRegistration(w, r, p.packageManager)
-		})
-		log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+	// Handle status endpoint
+	if strings.HasSuffix(r.URL.Path, "/status") && r.Method == http.MethodGet {
+		p.debugHandler.HandleApplicationStatus(w, r)
 		return
 	}
-	if r.URL.Path == "/apps/install" {
-		middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-			app_handlers.HandleInstall(w, r, p.packageManager, p.pm)
-		})
-		log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+	// Handle logs endpoint
+	if strings.HasSuffix(r.URL.Path, "/logs") && r.Method == http.MethodGet {
+		p.debugHandler.HandleLogStream(w, r)
 		return
 	}
+	http.Error(w, "Not Found", http.StatusNotFound)
+}
 
-	// Event endpoints
-	if r.URL.Path == "/events/publish" {
-		middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-			event_handlers.HandleEventPublish(w, r, p.eventManager, p.pm)
-		})
-		log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
-		return
-	}
-	if r.URL.Path == "/events/poll" {
-		middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-			event_handlers.HandleEventPoll(w, r, p.packageManager, p.pm)
-		})
-		log.Printf("<%s> %s %s", traceID, r.Host, r.URL.Path)
+// handleApplication proxies the request to an application instance, chosen
+// by the request's host if it is in the host routes and otherwise by the
+// first path segment.
+func (p *Proxy) handleApplication(w http.ResponseWriter, r *http.Request, traceID string) {
+	// Hosts mapped to an application instance keep their full path
+	if instanceID, ok := p.instanceForHost(r.Host); ok {
+		instance, port, err := p.GetAppInstanceByID(instanceID)
+		if err != nil {
+			p.serveInstanceError(w, r, traceID, instanceID, err)
+			return
+		}
+		if serveStatic(w, r, traceID, instance.StaticPath, r.URL.Path) {
+			return
+		}
+		p.proxyToPrimary(w, r, traceID, instanceID, instance.BackendHost(port), r.URL.Path)
 		return
 	}
 
@@ -267,28 +589,18 @@ func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	if len(parts) > 1 {
 		instanceID := parts[1]
 		if instanceID != "" {
-			_, port, err := p.GetAppInstanceByID(instanceID)
+			instance, port, err := p.GetAppInstanceByID(instanceID)
 			if err != nil {
-				http.Error(w, "Application instance not found for instance ID "+instanceID, http.StatusNotFound)
-				log.Printf("<%s> %s %s 404 [Application instance not found]", traceID, r.Host, r.URL.Path)
+				p.serveInstanceError(w, r, traceID, instanceID, err)
 				return
 			}
 
-			// Token is valid, proxy the request
-			targetURL := &url.URL{
-				Scheme: "http", // Backend services are HTTP
-				Host:   "localhost:" + strconv.Itoa(port),
+			// Token is valid, serve a static file or proxy the request
+			path := strings.TrimPrefix(r.URL.Path, "/"+instanceID)
+			if serveStatic(w, r, traceID, instance.StaticPath, path) {
+				return
 			}
-
-			origPath := r.URL.Path
-			reverseProxy := httputil.NewSingleHostReverseProxy(targetURL)
-			reverseProxy.Transport = p.transport
-			r.Host = targetURL.Host
-			r.URL.Path = r.URL.Path[len("/"+instanceID+"/"):]
-			r.Header.Add("X-Trace-ID", traceID)
-
-			log.Printf("<%s> %s %s => %s", traceID, r.Host, origPath, targetURL.String())
-			middleware.CorsMiddleware(w, r, reverseProxy.ServeHTTP)
+			p.proxyToPrimary(w, r, traceID, instanceID, instance.BackendHost(port), path)
 			return
 		}
 	}
@@ -322,13 +634,106 @@ func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	log.Printf("<%s> %s %s 404 [No route found]", traceID, r.Host, r.URL.Path)
 }
 
+// proxyToInstance forwards the request to the application instance at the
+// given backend host (see AppInstance.BackendHost), rewriting the request
+// path to path. The backend is told how long the proxy will wait via the
+// X-Request-Deadline header, and the backend request is cancelled when that
+// deadline passes or the client disconnects.
+func (p *Proxy) proxyToInstance(w http.ResponseWriter, r *http.Request, traceID string, backendHost string, path string) {
+	targetURL := &url.URL{
+		Scheme: "http", // Backend services are HTTP
+		Host:   backendHost,
+	}
+
+	// Clients may ask for a shorter deadline than the proxy's own timeout
+	deadline := time.Now().Add(requestTimeout)
+	if hint, ok := httputils.RequestDeadline(r); ok && hint.Before(deadline) {
+		deadline = hint
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	origPath := r.URL.Path
+	reverseProxy := httputil.NewSingleHostReverseProxy(targetURL)
+	reverseProxy.Transport = p.transport
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		switch {
+		case errors.Is(r.Context().Err(), context.Canceled):
+			// Nobody is left to read a response
+			log.Printf("<%s> %s %s => %d [Client closed request]", traceID, r.Host, origPath, statusClientClosedRequest)
+		case errors.Is(r.Context().Err(), context.DeadlineExceeded):
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+			log.Printf("<%s> %s %s => 504 [Deadline exceeded]", traceID, r.Host, origPath)
+		default:
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			log.Printf("<%s> %s %s => 502 [%v]", traceID, r.Host, origPath, err)
+		}
+	}
+	r.Host = targetURL.Host
+	r.URL.Path = path
+	r.Header.Set(httputils.TraceIDHeader, traceID)
+	httputils.SetRequestDeadline(r.Header, deadline)
+
+	log.Printf("<%s> %s %s => %s", traceID, r.Host, origPath, targetURL.String())
+	middleware.CorsMiddleware(w, r, reverseProxy.ServeHTTP)
+}
+
+// authorize reports whether the request carries the internal secret, a
+// verified client certificate or a valid access token, and for access tokens
+// the ID of the user it was issued to.
+func (p *Proxy) authorize(r *http.Request) (userID int, ok bool) {
+	p.setup()
+	accessToken, kind := p.authorizer.credentials(r)
+	return accessToken.UserID, kind == credentialToken || kind == credentialInternal
+}
+
+// handleRotateSecret rotates the internal secret on demand. The previous
+// secret stays valid for the store's grace period.
+func (p *Proxy) handleRotateSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.secrets.Rotate("manual")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotateBackendCA replaces the CA for mutual TLS with instances.
+// Running instances keep their certificates until they restart.
+func (p *Proxy) handleRotateBackendCA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.pm.RotateBackendCA(); errors.Is(err, processes.ErrBackendTLSDisabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to rotate internal CA: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAppInstanceByID activates the installed instance and returns it once it
+// is ready to serve, waiting up to the cold start timeout for it to start.
+// Concurrent calls for the same instance share a single activation and wait.
 func (p *Proxy) GetAppInstanceByID(instanceID string) (*processes.AppInstance, int, error) {
+	return p.instanceLookups.do(instanceID, func() (*processes.AppInstance, int, error) {
+		return p.lookupAppInstance(instanceID)
+	})
+}
+
+// lookupAppInstance is GetAppInstanceByID without coalescing.
+func (p *Proxy) lookupAppInstance(instanceID string) (*processes.AppInstance, int, error) {
 	pkg, err := p.packageManager.GetPackageByInstanceID(instanceID)
 	if err != nil {
-		return nil, 0, fmt.Errorf("application instance not found for app ID %s: %v", instanceID, err)
+		return nil, 0, fmt.Errorf("%w for app ID %s: %v", ErrInstanceNotFound, instanceID, err)
 	}
 	if pkg == nil {
-		return nil, 0, fmt.Errorf("application instance not found for app ID %s", instanceID)
+		return nil, 0, fmt.Errorf("%w for app ID %s", ErrInstanceNotFound, instanceID)
 	}
 
 	// Make sure the package is active. This will start the process if
@@ -336,45 +741,67 @@ func (p *Proxy) GetAppInstanceByID(instanceID string) (*processes.AppInstance, i
 	// next few minutes.
 	err = p.packageManager.SetPackageActive(pkg.InstanceID, p.pm)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to activate app ID %s: %v", instanceID, err)
+		return nil, 0, fmt.Errorf("%w: failed to activate app ID %s: %v", ErrInstanceUnavailable, instanceID, err)
 	}
 
-	// The instance might not be running immediately, so wait a little while for
-	// it to start, up to 30 seconds.
-	// TODO: Move to a configurable setting
-	backoffInterval := time.Millisecond * 250
-	backoffMaxTime := time.Second * 30
-	startTime := time.Now()
-
-	for {
-		instance, port, err := p.pm.GetAppInstanceByID(instanceID)
-		if err == nil && instance != nil {
-			return instance, port, nil
-		}
-
-		if time.Since(startTime) > backoffMaxTime {
-			return nil, 0, fmt.Errorf("instance not serving for app ID %s: %v", instanceID, err)
-		}
-
-		time.Sleep(backoffInterval)
-		backoffInterval *= 2
-	}
+	// The instance might not be running immediately, so wait for the process
+	// manager to report it ready
+	return p.waitForInstance(instanceID)
 }
 
 func (p *Proxy) GetServiceHost(instanceID string) (string, error) {
-	_, port, err := p.pm.GetAppInstanceByID(instanceID)
+	instance, port, err := p.pm.GetAppInstanceByID(instanceID)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("http://localhost:%d", port), nil
+	return instance.BackendURL(port), nil
 }
 
-// Stop gracefully shuts down the proxy server.
-func (p *Proxy) Stop() error {
+// Stop gracefully shuts down the proxy server. It stops accepting
+// connections immediately, marks the proxy as not ready, and waits for
+// in-flight requests until ctx is done, after which remaining connections are
+// closed.
+func (p *Proxy) Stop(ctx context.Context) error {
+	p.shuttingDown.Store(true)
 	if p.server == nil {
 		log.Printf("Proxy server was not running or not initialized, nothing to stop.")
 		return nil
 	}
 	log.Printf("Stopping HTTPS proxy server...")
-	return p.server.Shutdown(context.TODO()) // Use context.WithTimeout for graceful shutdown if needed
+	err := p.server.Shutdown(ctx)
+	if err != nil {
+		// Drop whatever is still in flight, e.g. long-polling clients
+		p.server.Close()
+	}
+	if p.mtlsServer != nil {
+		if mtlsErr := p.mtlsServer.Shutdown(ctx); mtlsErr != nil {
+			p.mtlsServer.Close()
+			err = errors.Join(err, mtlsErr)
+		}
+	}
+	return err
+}
+
+// startMTLS starts the internal listener, sharing the main server's handler
+// and TLS configuration. It returns once the listener is open.
+func (p *Proxy) startMTLS(contextFn func(net.Listener) context.Context) error {
+	listener, err := net.Listen("tcp", p.mtls.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for mTLS on %s: %w", p.mtls.ListenAddr, err)
+	}
+	p.mtlsServer = &http.Server{
+		BaseContext:  contextFn,
+		Handler:      p.server.Handler,
+		TLSConfig:    p.mtls.tlsConfig(p.server.TLSConfig),
+		ReadTimeout:  p.server.ReadTimeout,
+		WriteTimeout: p.server.WriteTimeout,
+		IdleTimeout:  p.server.IdleTimeout,
+	}
+	log.Printf("Starting mTLS listener on %s (paths: %v)", p.mtls.ListenAddr, p.mtls.PathPrefixes)
+	go func() {
+		if err := p.mtlsServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+			log.Printf("mTLS listener stopped: %v", err)
+		}
+	}()
+	return nil
 }
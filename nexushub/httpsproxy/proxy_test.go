@@ -0,0 +1,231 @@
+package httpsproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/middleware"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// newInstanceProxy returns a server that proxies every request to the backend
+// as if it were application instance "app".
+func newInstanceProxy(t *testing.T, backend *httptest.Server) *httptest.Server {
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{transport: &http.Transport{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.proxyToInstance(w, r, "trace", backendURL.Host, strings.TrimPrefix(r.URL.Path, "/app"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxyCancelsBackendWhenClientDisconnects(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer backend.Close()
+	proxy := newInstanceProxy(t, backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+"/app/api/slow", nil)
+	go http.DefaultClient.Do(req)
+
+	<-started
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Backend did not observe the client disconnecting")
+	}
+}
+
+func TestProxySendsRequestDeadline(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(httputils.RequestDeadlineHeader)
+	}))
+	defer backend.Close()
+	proxy := newInstanceProxy(t, backend)
+
+	// Without a hint the deadline comes from the proxy's own timeout
+	before := time.Now()
+	resp, err := http.Get(proxy.URL + "/app/api/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	deadline, err := time.Parse(time.RFC3339Nano, <-received)
+	if err != nil {
+		t.Fatalf("Invalid deadline header: %v", err)
+	}
+	if deadline.Before(before.Add(requestTimeout)) || deadline.After(time.Now().Add(requestTimeout)) {
+		t.Errorf("Expected deadline about %v from now, got %v", requestTimeout, deadline.Sub(before))
+	}
+
+	// A shorter client hint is passed through
+	hint := time.Now().Add(5 * time.Second).Round(0)
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/app/api/test", nil)
+	httputils.SetRequestDeadline(req.Header, hint)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-received; got != hint.UTC().Format(time.RFC3339Nano) {
+		t.Errorf("Expected client deadline %v to be forwarded, got %s", hint, got)
+	}
+}
+
+func TestProxyReturnsGatewayTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+	proxy := newInstanceProxy(t, backend)
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/app/api/slow", nil)
+	httputils.SetRequestDeadline(req.Header, time.Now().Add(50*time.Millisecond))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyOverUnixSocket(t *testing.T) {
+	instance := processes.AppInstance{
+		InstanceID: "sockapp",
+		PkgPath:    t.TempDir(),
+		Transport:  processes.TransportUnix,
+	}
+	os.MkdirAll(filepath.Dir(instance.SocketPath()), 0755)
+	listener, err := net.Listen("unix", instance.SocketPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &httptest.Server{
+		Listener: listener,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/status" {
+				w.Write([]byte(`{"current_event_id": 3}`))
+				return
+			}
+			w.Write([]byte("path " + r.URL.Path))
+		})},
+	}
+	backend.Start()
+	defer backend.Close()
+	processes.RegisterBackendSocket(instance)
+	defer processes.UnregisterBackendSocket(instance.InstanceID)
+
+	p := &Proxy{transport: processes.NewBackendTransport(&net.Dialer{})}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.proxyToInstance(w, r, "trace", instance.BackendHost(0), strings.TrimPrefix(r.URL.Path, "/sockapp"))
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/sockapp/api/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "path /api/items" {
+		t.Errorf("Expected the request to reach the socket backend, got %d %q", resp.StatusCode, body)
+	}
+
+	// The health checker reaches the same socket without a port
+	state, eventID, err := processes.NewHTTPHealthChecker(time.Second).Check(&processes.ManagedProcess{Instance: instance})
+	if err != nil || state != processes.StateRunning || eventID != 3 {
+		t.Errorf("Expected a healthy socket backend at event 3, got %v %d: %v", state, eventID, err)
+	}
+
+	// An instance with no registered socket fails instead of dialing TCP
+	processes.UnregisterBackendSocket(instance.InstanceID)
+	p.transport.CloseIdleConnections()
+	resp, err = http.Get(proxy.URL + "/sockapp/api/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected 502 for an unregistered socket, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthorizeReturnsTokenUser(t *testing.T) {
+	p := &Proxy{secrets: secrets.NewStore(time.Minute)}
+	access.CreateAccessToken(&types.AccessTokenResponse{AccessToken: "user-token", Expiry: time.Now().Add(time.Minute).Unix()}, 7)
+
+	authorize := func(token string) (int, bool) {
+		req := httptest.NewRequest(http.MethodGet, "/app/api/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return p.authorize(req)
+	}
+	if userID, ok := authorize("user-token"); !ok || userID != 7 {
+		t.Errorf("Expected an access token to authorize user 7, got %d, %v", userID, ok)
+	}
+	if userID, ok := authorize(p.secrets.Current()); !ok || userID != 0 {
+		t.Errorf("Expected the internal secret to authorize without a user, got %d, %v", userID, ok)
+	}
+	if _, ok := authorize("unknown"); ok {
+		t.Error("Expected an unknown token to be rejected")
+	}
+}
+
+func TestRestrictedTokenOnlyChangesPassword(t *testing.T) {
+	p := &Proxy{secrets: secrets.NewStore(time.Minute)}
+	access.CreateRestrictedAccessToken(&types.AccessTokenResponse{AccessToken: "expired-password", Expiry: time.Now().Add(time.Minute).Unix()}, 9)
+
+	req := httptest.NewRequest(http.MethodGet, "/apps/list", nil)
+	req.Header.Set("Authorization", "Bearer expired-password")
+	w := httptest.NewRecorder()
+	p.handleRequest(w, req)
+	if w.Code != http.StatusForbidden || w.Header().Get(access.PasswordExpiredHeader) != "true" {
+		t.Errorf("Expected a restricted token to be refused with 403, got %d", w.Code)
+	}
+
+	// The token still identifies the user
+	if userID, ok := p.authorize(req); !ok || userID != 9 {
+		t.Errorf("Expected the restricted token to authorize user 9, got %d, %v", userID, ok)
+	}
+}
+
+func TestDebugPreflightUsesCORSPolicy(t *testing.T) {
+	p := &Proxy{}
+	for _, path := range []string{"/debug/application", "/debug/application/abc/logs", "/debug/application/abc/events/export"} {
+		recorder := httptest.NewRecorder()
+		p.handleRequest(recorder, httptest.NewRequest(http.MethodOptions, path, nil))
+		header := recorder.Header()
+		if recorder.Code != http.StatusOK || header.Get("Access-Control-Allow-Origin") != middleware.DefaultCORSConfig().AllowedOrigin || header.Get("Access-Control-Max-Age") == "" {
+			t.Errorf("Expected the preflight for %s to be answered with the CORS policy, got %d %v", path, recorder.Code, header)
+		}
+	}
+}
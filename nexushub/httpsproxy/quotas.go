@@ -0,0 +1,98 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
+)
+
+// QuotaView is an instance's quota as served by /apps/{id}/quota.
+type QuotaView struct {
+	InstanceID string `json:"instanceId"`
+	// Usage is nil until the instance has been measured
+	Usage *quotas.Usage `json:"usage,omitempty"`
+	// Override is the admin's limits for the instance, nil if there are
+	// none
+	Override *quotas.Limits `json:"override,omitempty"`
+}
+
+// SetQuotas enforces the collector's quotas: event publishes delivered to an
+// instance at a hard limit and debug packages over the size limit are
+// rejected. Usage and limits are served by /apps/usage and /apps/{id}/quota
+// and listed by /apps/list.
+func (p *Proxy) SetQuotas(collector *quotas.Collector) {
+	p.quotas = collector
+	p.debugHandler.SetQuotas(collector)
+}
+
+// handleUsage serves GET /apps/usage, the usage and limits of every
+// installed instance as of the last measurement.
+func (p *Proxy) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if p.quotas == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, map[string]any{"instances": p.quotas.AllUsage()}, nil, http.StatusOK)
+}
+
+// handleQuota serves /apps/{id}/quota: GET returns the instance's QuotaView,
+// PUT replaces the admin's override with the quotas.Limits in the body, whose
+// limits left at zero are inherited, and DELETE removes it. PUT and DELETE
+// respond with the updated QuotaView.
+func (p *Proxy) handleQuota(w http.ResponseWriter, r *http.Request) {
+	if p.quotas == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	instanceID := strings.Split(r.URL.Path, "/")[2]
+	userID, _ := httputils.RequestUserID(r)
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var limits quotas.Limits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid quota request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := limits.Validate(); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+			return
+		}
+		if err = p.quotas.SetOverride(instanceID, limits); err == nil {
+			log.Printf("Quota of %s overridden by user %d: %+v", instanceID, userID, limits)
+		}
+	case http.MethodDelete:
+		if err = p.quotas.ClearOverride(instanceID); err == nil {
+			log.Printf("Quota override of %s removed by user %d", instanceID, userID)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, quotas.ErrOverridesDisabled) {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	view := QuotaView{InstanceID: instanceID}
+	if usage, ok := p.quotas.Usage(instanceID); ok {
+		view.Usage = &usage
+	}
+	if view.Override, err = p.quotas.Override(instanceID); err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, view, nil, http.StatusOK)
+}
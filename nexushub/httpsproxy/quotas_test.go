@@ -0,0 +1,100 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
+)
+
+// quotaHub installs instance "abc" subscribing to Todo:Add events, of which
+// there are events in the log.
+type quotaHub struct {
+	databaseDir string
+	events      int64
+}
+
+func (h *quotaHub) QuotaInstances() ([]quotas.Instance, error) {
+	return []quotas.Instance{{InstanceID: "abc", Name: "todo", Subscriptions: map[string]bool{"Todo:Add": true}, DatabaseDir: h.databaseDir}}, nil
+}
+
+func (h *quotaHub) Stats(days int) (*events.EventStats, error) {
+	return &events.EventStats{Types: []events.EventTypeStats{{Type: "Todo:Add", Count: h.events}}}, nil
+}
+
+func newTestQuotaCollector(t *testing.T, hub *quotaHub, defaults quotas.Limits) *quotas.Collector {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "quotas.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := quotas.NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := quotas.NewCollector(quotas.Config{Defaults: defaults}, hub, hub, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := collector.Check(); err != nil {
+		t.Fatal(err)
+	}
+	return collector
+}
+
+func TestHandleQuota(t *testing.T) {
+	hub := &quotaHub{databaseDir: t.TempDir(), events: 5}
+	p := &Proxy{}
+	request := func(method, target, body string) (int, QuotaView) {
+		recorder := httptest.NewRecorder()
+		p.handleQuota(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var view QuotaView
+		json.NewDecoder(recorder.Body).Decode(&view)
+		return recorder.Code, view
+	}
+
+	if status, _ := request(http.MethodGet, "/apps/abc/quota", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 without quotas, got %d", status)
+	}
+
+	p.quotas = newTestQuotaCollector(t, hub, quotas.Limits{Events: quotas.Limit{Soft: 3, Hard: 10}})
+	status, view := request(http.MethodGet, "/apps/abc/quota", "")
+	if status != http.StatusOK || view.Usage == nil || view.Usage.Events != 5 || view.Usage.EventsLevel != quotas.LevelSoft || view.Override != nil {
+		t.Errorf("Unexpected quota %d %+v", status, view)
+	}
+
+	status, view = request(http.MethodPut, "/apps/abc/quota", `{"events":{"hard":5}}`)
+	if status != http.StatusOK || view.Override == nil || view.Usage.Limits.Events != (quotas.Limit{Soft: 3, Hard: 5}) || view.Usage.EventsLevel != quotas.LevelHard {
+		t.Errorf("Expected the override to apply, got %d %+v", status, view)
+	}
+	status, view = request(http.MethodDelete, "/apps/abc/quota", "")
+	if status != http.StatusOK || view.Override != nil || view.Usage.EventsLevel != quotas.LevelSoft {
+		t.Errorf("Expected the override to be removed, got %d %+v", status, view)
+	}
+
+	for _, tc := range []struct {
+		name, method, body string
+		status             int
+	}{
+		{"negative", http.MethodPut, `{"packageBytes":-1}`, http.StatusBadRequest},
+		{"hard below soft", http.MethodPut, `{"databaseBytes":{"soft":10,"hard":5}}`, http.StatusBadRequest},
+		{"malformed", http.MethodPut, `events=5`, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "", http.StatusMethodNotAllowed},
+	} {
+		if status, _ := request(tc.method, "/apps/abc/quota", tc.body); status != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, status)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	p.handleUsage(recorder, httptest.NewRequest(http.MethodGet, "/apps/usage", nil))
+	var usage struct {
+		Instances []quotas.Usage `json:"instances"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&usage)
+	if recorder.Code != http.StatusOK || len(usage.Instances) != 1 || usage.Instances[0].InstanceID != "abc" {
+		t.Errorf("Unexpected usage %d %+v", recorder.Code, usage)
+	}
+}
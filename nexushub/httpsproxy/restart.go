@@ -0,0 +1,33 @@
+package httpsproxy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// handleRestart serves POST /apps/{id}/restart: it stops the instance's
+// process, letting it drain, and starts it again straight away, responding
+// with the instance's status. Unknown instances get 404 and restarts that
+// fail 502.
+func (p *Proxy) handleRestart(w http.ResponseWriter, r *http.Request) {
+	instanceID := strings.Split(r.URL.Path, "/")[2]
+	userID, _ := httputils.RequestUserID(r)
+	log.Printf("Restart of %s requested by user %d", instanceID, userID)
+
+	err := p.pm.RestartInstance(r.Context(), instanceID)
+	switch {
+	case errors.Is(err, processes.ErrInstanceNotFound):
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusNotFound)
+	case err != nil:
+		log.Printf("Restart of %s failed: %v", instanceID, err)
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadGateway)
+	default:
+		status, _ := p.pm.GetInstanceStatus(instanceID)
+		httputils.HandleAPIResponse(w, r, status, nil, http.StatusOK)
+	}
+}
@@ -0,0 +1,63 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// restartProcessManager has instance "abc", which fails to restart when
+// broken.
+type restartProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+	broken    bool
+	restarted []string
+}
+
+func (pm *restartProcessManager) RestartInstance(ctx context.Context, id string) error {
+	if id != "abc" {
+		return fmt.Errorf("%w: %s", processes.ErrInstanceNotFound, id)
+	}
+	if pm.broken {
+		return fmt.Errorf("%w: failed to start %s", processes.ErrRestartFailed, id)
+	}
+	pm.restarted = append(pm.restarted, id)
+	return nil
+}
+
+func (pm *restartProcessManager) GetInstanceStatus(id string) (*processes.InstanceStatus, bool) {
+	return &processes.InstanceStatus{State: processes.StateRunning}, true
+}
+
+func TestHandleRestart(t *testing.T) {
+	pm := &restartProcessManager{}
+	p := &Proxy{pm: pm}
+	request := func(instanceID string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		p.handleRestart(recorder, httptest.NewRequest(http.MethodPost, "/apps/"+instanceID+"/restart", nil))
+		return recorder
+	}
+
+	recorder := request("abc")
+	var status struct {
+		State string `json:"state"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&status)
+	if recorder.Code != http.StatusOK || status.State != "Running" || len(pm.restarted) != 1 {
+		t.Errorf("Expected the instance to be restarted, got %d %+v", recorder.Code, status)
+	}
+
+	if recorder := request("missing"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown instance to get 404, got %d", recorder.Code)
+	}
+	pm.broken = true
+	if recorder := request("abc"); recorder.Code != http.StatusBadGateway {
+		t.Errorf("Expected a failed restart to get 502, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package httpsproxy
+
+import "strings"
+
+// RouteClass says which credentials a route requires. The Authorizer decides
+// every request by the class of the route it matches.
+type RouteClass string
+
+const (
+	// RoutePublic routes need no credentials, e.g. health checks.
+	RoutePublic RouteClass = "public"
+	// RouteCookieAuth routes are the login endpoints, which authenticate the
+	// request themselves from the refresh token cookie or the login
+	// credentials. Bearer tokens are ignored.
+	RouteCookieAuth RouteClass = "cookie-auth"
+	// RouteBearerAuth routes need an access token, the internal secret or a
+	// verified client certificate.
+	RouteBearerAuth RouteClass = "bearer-auth"
+	// RouteAdmin routes operate the hub itself. They need the internal
+	// secret, a verified client certificate or an access token of a user with
+	// the admin role.
+	RouteAdmin RouteClass = "admin"
+	// RouteInternalOnly routes need the internal secret or a verified client
+	// certificate; access tokens are refused.
+	RouteInternalOnly RouteClass = "internal-only"
+	// RouteDebug routes serve the debug API to nexusdebug, which sends no
+	// credentials. Credentials that are sent must still be valid.
+	RouteDebug RouteClass = "debug"
+)
+
+// routeClasses declares the class of every route the proxy serves. The proxy
+// refuses to register a handler for a pattern that is not declared here, and
+// the authorization conformance tests exercise every class.
+var routeClasses = routeTable[RouteClass]{
+	"/healthz": RoutePublic,
+	"/readyz":  RoutePublic,
+
+	"/public/login":        RouteCookieAuth,
+	"/public/access_token": RouteCookieAuth,
+	"/public/logout":       RouteCookieAuth,
+	"/public/logout_all":   RouteCookieAuth,
+
+	"/debug/application":  RouteDebug,
+	"/debug/application/": RouteDebug,
+	// Event log exports and imports are forwarded with the internal secret
+	"/debug/application/*/events/": RouteBearerAuth,
+	"/debug/trace/*":               RouteAdmin,
+
+	"/apps/register":        RouteBearerAuth,
+	"/apps/list":            RouteBearerAuth,
+	"/apps/install":         RouteBearerAuth,
+	"/apps/uninstall":       RouteBearerAuth,
+	"/apps/crashes":         RouteAdmin,
+	"/apps/desired-state":   RouteAdmin,
+	"/apps/usage":           RouteAdmin,
+	"/apps/*/package":       RouteAdmin,
+	"/apps/*/database":      RouteAdmin,
+	"/apps/*/shadow-report": RouteBearerAuth,
+	"/apps/*/clone":         RouteAdmin,
+	"/apps/*/loglevel":      RouteAdmin,
+	"/apps/*/quota":         RouteAdmin,
+	"/apps/*/restart":       RouteAdmin,
+	"/apps/*/probe/*":       RouteAdmin,
+	"/secrets/rotate":       RouteAdmin,
+	"/tls/rotate-ca":        RouteAdmin,
+	"/users/export":         RouteBearerAuth,
+	"/users/forget":         RouteBearerAuth,
+	"/events/publish":       RouteBearerAuth,
+	"/events/stats":         RouteAdmin,
+	"/events/poll":          RouteBearerAuth,
+	"/metrics":              RouteAdmin,
+
+	"/internal/crash-reports": RouteInternalOnly,
+	// Token introspection for resource servers outside the hub
+	"/public/introspect": RouteInternalOnly,
+
+	// Everything else is proxied to an application instance
+	"/": RouteBearerAuth,
+}
+
+// routeTable maps path patterns to values. A pattern matches the path equal
+// to it, or if it ends in "/" every path below it; a "*" segment matches any
+// single non-empty segment. The longest matching pattern wins.
+type routeTable[T any] map[string]T
+
+// match returns the longest pattern matching the path and its value.
+func (t routeTable[T]) match(path string) (string, T, bool) {
+	var best string
+	var value T
+	found := false
+	for pattern, v := range t {
+		if (!found || len(pattern) > len(best)) && matchPattern(pattern, path) {
+			best, value, found = pattern, v, true
+		}
+	}
+	return best, value, found
+}
+
+// matchPattern reports whether the path matches the pattern, see routeTable.
+func matchPattern(pattern, path string) bool {
+	prefix := strings.HasSuffix(pattern, "/")
+	patternSegments := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	pathSegments := strings.Split(path, "/")
+	if prefix && len(pathSegments) <= len(patternSegments) {
+		return false
+	}
+	if !prefix && len(pathSegments) != len(patternSegments) {
+		return false
+	}
+	for i, segment := range patternSegments {
+		if segment == "*" && pathSegments[i] != "" {
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package httpsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+func TestRouteTableMatch(t *testing.T) {
+	for _, tc := range []struct {
+		path    string
+		pattern string
+	}{
+		{"/healthz", "/healthz"},
+		{"/healthz/extra", "/"},
+		{"/debug/application", "/debug/application"},
+		{"/debug/application/app-1/upload", "/debug/application/"},
+		{"/debug/application/app-1/events/export", "/debug/application/*/events/"},
+		{"/debug/application//events/export", "/debug/application/"},
+		{"/debug/applicationx", "/"},
+		{"/apps/list", "/apps/list"},
+		{"/apps/app-1/package", "/apps/*/package"},
+		{"/apps/app-1/package/extra", "/"},
+		{"/apps/usage", "/apps/usage"},
+		{"/apps/app-1/quota", "/apps/*/quota"},
+		{"/app-1/api/items", "/"},
+		{"/", "/"},
+	} {
+		if pattern, _, ok := routeClasses.match(tc.path); !ok || pattern != tc.pattern {
+			t.Errorf("Expected %s to match %s, got %q", tc.path, tc.pattern, pattern)
+		}
+	}
+	if _, _, ok := routeClasses.match("*"); ok {
+		t.Error("Expected a path without a leading slash not to match")
+	}
+}
+
+func TestEveryRouteHasHandler(t *testing.T) {
+	p := &Proxy{}
+	p.setup()
+	for pattern := range routeClasses {
+		if _, ok := p.routes[pattern]; !ok {
+			t.Errorf("Route %s is declared but has no handler", pattern)
+		}
+	}
+}
+
+func TestUndeclaredRouteIsRefused(t *testing.T) {
+	p := &Proxy{}
+	p.setup()
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering an undeclared route to panic")
+		}
+	}()
+	p.handle("/undeclared", served(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func TestDebugEventLogNeedsCredentials(t *testing.T) {
+	a := NewAuthorizer(secrets.NewStore(time.Minute), nil)
+	r := httptest.NewRequest(http.MethodGet, "/debug/application/app-1/events/export", nil)
+	if decision := a.Authorize(r); decision.Status != http.StatusUnauthorized {
+		t.Errorf("Expected the event log export to need credentials, got %+v", decision)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/debug/application/app-1/status", nil)
+	if decision := a.Authorize(r); !decision.Allowed() || decision.Class != RouteDebug {
+		t.Errorf("Expected other debug routes to need no credentials, got %+v", decision)
+	}
+}
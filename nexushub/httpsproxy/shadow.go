@@ -0,0 +1,379 @@
+package httpsproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+// ShadowHeader is set on requests mirrored to a shadow instance, so that it
+// can tell them apart from real traffic.
+const ShadowHeader = "X-Shadow"
+
+const (
+	// maxShadowRequests bounds the shadow requests in flight. Sampled
+	// requests beyond it are dropped rather than queued.
+	maxShadowRequests = 64
+	// shadowLatencyWindow is how many recent comparisons the latency
+	// percentiles of a shadow report cover.
+	shadowLatencyWindow = 1024
+	// sampleScale is the resolution of sampling rates, in parts per million.
+	sampleScale = 1000000
+)
+
+// ShadowRoute mirrors a sample of an instance's read-only traffic to another
+// instance, e.g. a new version of the application, to compare how it
+// behaves under real load.
+type ShadowRoute struct {
+	// Target is the instance ID requests are mirrored to. It must be running;
+	// mirroring does not start it.
+	Target string
+	// Rate is the fraction of GET and HEAD requests mirrored, in (0, 1]
+	Rate float64
+}
+
+// ParseShadowRoutes parses a comma-separated list of instanceID=target@rate
+// entries, e.g. "3bf3e3c0=7c1d2e4f@0.1" to mirror 10% of the reads of
+// 3bf3e3c0 to 7c1d2e4f.
+func ParseShadowRoutes(value string) (map[string]ShadowRoute, error) {
+	routes := make(map[string]ShadowRoute)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		instanceID, rest, ok := strings.Cut(entry, "=")
+		target, rateValue, hasRate := strings.Cut(rest, "@")
+		instanceID = strings.TrimSpace(instanceID)
+		target = strings.TrimSpace(target)
+		if !ok || !hasRate || instanceID == "" || target == "" {
+			return nil, fmt.Errorf("invalid shadow route %q, expected instanceID=target@rate", entry)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateValue), 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid rate in shadow route %q, expected a fraction in (0, 1]", entry)
+		}
+		if instanceID == target {
+			return nil, fmt.Errorf("instance %s cannot shadow itself", instanceID)
+		}
+		if _, ok := routes[instanceID]; ok {
+			return nil, fmt.Errorf("instance %s has more than one shadow route", instanceID)
+		}
+		routes[instanceID] = ShadowRoute{Target: target, Rate: rate}
+	}
+	return routes, nil
+}
+
+// ShadowRoutesFromEnv reads shadow routes from the SHADOW_ROUTES environment
+// variable. It returns nil if the variable is unset.
+func ShadowRoutesFromEnv() (map[string]ShadowRoute, error) {
+	value := os.Getenv("SHADOW_ROUTES")
+	if value == "" {
+		return nil, nil
+	}
+	routes, err := ParseShadowRoutes(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHADOW_ROUTES: %w", err)
+	}
+	return routes, nil
+}
+
+// SetShadowRoutes mirrors a sample of the GET and HEAD requests of the given
+// instances, keyed by instance ID, to their shadow instances. Mirrored
+// requests are sent asynchronously with ShadowHeader set and their responses
+// are discarded after comparing their status and latency with the primary's,
+// so a failing shadow never affects the primary response. Requests with
+// other methods, and so their bodies, are never mirrored.
+func (p *Proxy) SetShadowRoutes(routes map[string]ShadowRoute) {
+	p.shadows = make(map[string]*shadow, len(routes))
+	for instanceID, route := range routes {
+		p.shadows[instanceID] = &shadow{
+			route:   route,
+			sampler: newSampler(route.Rate),
+		}
+	}
+	p.shadowSlots = make(chan struct{}, maxShadowRequests)
+}
+
+// sampler picks a fraction of requests spread evenly over time: request n
+// (from zero) is sampled when floor((n+1)*rate) > floor(n*rate), so exactly
+// floor(n*rate) of the first n requests are.
+type sampler struct {
+	// rate is in parts per million, so the arithmetic is exact
+	rate  uint64
+	count atomic.Uint64
+}
+
+func newSampler(rate float64) *sampler {
+	return &sampler{rate: uint64(math.Round(rate * sampleScale))}
+}
+
+func (s *sampler) sample() bool {
+	n := s.count.Add(1) - 1
+	return (n+1)*s.rate/sampleScale > n*s.rate/sampleScale
+}
+
+// shadow is the mirroring state and comparison statistics of one instance.
+type shadow struct {
+	route   ShadowRoute
+	sampler *sampler
+
+	mu         sync.Mutex
+	compared   int64
+	mismatches int64
+	failures   int64
+	dropped    int64
+	// deltas holds the latest shadow minus primary latencies, as a ring
+	// buffer of up to shadowLatencyWindow entries starting at next
+	deltas []time.Duration
+	next   int
+}
+
+// shadowResult is the outcome of the primary or the shadow request.
+type shadowResult struct {
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// record compares a mirrored request with its primary and returns the
+// outcome: "match", "mismatch" or "error", or "" if the primary was never
+// answered, e.g. because the client disconnected.
+func (s *shadow) record(primary, mirrored shadowResult) string {
+	if primary.status == 0 {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mirrored.err != nil {
+		s.failures++
+		return "error"
+	}
+	s.compared++
+	delta := mirrored.latency - primary.latency
+	if len(s.deltas) < shadowLatencyWindow {
+		s.deltas = append(s.deltas, delta)
+	} else {
+		s.deltas[s.next] = delta
+		s.next = (s.next + 1) % shadowLatencyWindow
+	}
+	if mirrored.status != primary.status {
+		s.mismatches++
+		return "mismatch"
+	}
+	return "match"
+}
+
+func (s *shadow) drop() {
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+}
+
+// ShadowReport summarizes the comparison of an instance with its shadow, as
+// served at /apps/{instanceID}/shadow-report.
+type ShadowReport struct {
+	InstanceID string  `json:"instanceId"`
+	Target     string  `json:"target"`
+	SampleRate float64 `json:"sampleRate"`
+	// Compared counts mirrored requests the shadow answered
+	Compared int64 `json:"compared"`
+	// StatusMismatches counts compared requests whose status differed
+	StatusMismatches int64 `json:"statusMismatches"`
+	// ShadowErrors counts mirrored requests the shadow failed to answer
+	ShadowErrors int64 `json:"shadowErrors"`
+	// Dropped counts sampled requests not mirrored because too many shadow
+	// requests were in flight
+	Dropped int64 `json:"dropped"`
+	// LatencyDeltaMs holds percentiles of the shadow's latency minus the
+	// primary's, in milliseconds, over the latest compared requests
+	LatencyDeltaMs LatencyPercentiles `json:"latencyDeltaMs"`
+}
+
+// LatencyPercentiles are percentiles of a latency distribution.
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+func (s *shadow) report(instanceID string) ShadowReport {
+	s.mu.Lock()
+	deltas := append([]time.Duration(nil), s.deltas...)
+	report := ShadowReport{
+		InstanceID:       instanceID,
+		Target:           s.route.Target,
+		SampleRate:       s.route.Rate,
+		Compared:         s.compared,
+		StatusMismatches: s.mismatches,
+		ShadowErrors:     s.failures,
+		Dropped:          s.dropped,
+	}
+	s.mu.Unlock()
+
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	report.LatencyDeltaMs = LatencyPercentiles{
+		P50: percentileMs(deltas, 0.5),
+		P90: percentileMs(deltas, 0.9),
+		P99: percentileMs(deltas, 0.99),
+	}
+	return report
+}
+
+// percentileMs returns the nearest-rank percentile of sorted durations in
+// milliseconds, or zero if there are none.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+// startShadow mirrors the request to the instance's shadow if the instance
+// has one and the request is a sampled GET or HEAD request. The request is
+// copied before it returns, without a body. The returned function must be
+// called with the primary's status and latency once the primary has been
+// served; it is nil if the request is not mirrored.
+func (p *Proxy) startShadow(r *http.Request, traceID, instanceID, path string) func(status int, latency time.Duration) {
+	s, ok := p.shadows[instanceID]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) || !s.sampler.sample() {
+		return nil
+	}
+	select {
+	case p.shadowSlots <- struct{}{}:
+	default:
+		s.drop()
+		p.observeShadow(instanceID, "dropped", nil)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	req, err := http.NewRequestWithContext(ctx, r.Method, "http://shadow"+path, nil)
+	if err != nil {
+		cancel()
+		<-p.shadowSlots
+		return nil
+	}
+	req.URL.RawQuery = r.URL.RawQuery
+	req.Header = r.Header.Clone()
+	req.Header.Set(ShadowHeader, "true")
+	req.Header.Set(httputils.TraceIDHeader, traceID)
+	httputils.SetRequestDeadline(req.Header, time.Now().Add(requestTimeout))
+
+	primary := make(chan shadowResult, 1)
+	go func() {
+		defer func() { <-p.shadowSlots }()
+		defer cancel()
+		mirrored := p.sendShadow(req, s.route.Target)
+		primaryResult := <-primary
+		switch outcome := s.record(primaryResult, mirrored); outcome {
+		case "":
+		case "error":
+			p.observeShadow(instanceID, outcome, nil)
+		default:
+			delta := mirrored.latency - primaryResult.latency
+			p.observeShadow(instanceID, outcome, &delta)
+		}
+	}()
+	return func(status int, latency time.Duration) {
+		primary <- shadowResult{status: status, latency: latency}
+	}
+}
+
+// sendShadow sends a mirrored request to the shadow instance and discards
+// the response body. Panics are recovered and reported as errors, so a
+// failing shadow never takes down the proxy.
+func (p *Proxy) sendShadow(req *http.Request, target string) (result shadowResult) {
+	defer func() {
+		if value := recover(); value != nil {
+			result = shadowResult{err: fmt.Errorf("panic: %v", value)}
+		}
+	}()
+	instance, port, err := p.pm.GetAppInstanceByID(target)
+	if err != nil {
+		return shadowResult{err: err}
+	}
+	req.URL.Host = instance.BackendHost(port)
+	req.Host = req.URL.Host
+
+	start := time.Now()
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		log.Printf("<%s> shadow %s %s failed: %v", req.Header.Get(httputils.TraceIDHeader), target, req.URL.Path, err)
+		return shadowResult{err: err}
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return shadowResult{err: err}
+	}
+	return shadowResult{status: resp.StatusCode, latency: time.Since(start)}
+}
+
+// EnableShadowMetrics records the outcomes of mirrored requests in registry.
+func (p *Proxy) EnableShadowMetrics(registry *prometheus.Registry) {
+	p.shadowRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nexushub_shadow_requests_total",
+		Help: "Requests mirrored to shadow instances, by primary instance and outcome (match, mismatch, error or dropped).",
+	}, []string{"instance", "outcome"})
+	p.shadowLatencyDelta = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "nexushub_shadow_latency_delta_seconds",
+		Help:       "Latency of shadow instances minus that of their primary for the same request, by primary instance.",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		MaxAge:     10 * time.Minute,
+	}, []string{"instance"})
+	registry.MustRegister(p.shadowRequests, p.shadowLatencyDelta)
+}
+
+// observeShadow records a shadow outcome in the metrics, if enabled.
+func (p *Proxy) observeShadow(instanceID, outcome string, delta *time.Duration) {
+	if p.shadowRequests == nil {
+		return
+	}
+	p.shadowRequests.WithLabelValues(instanceID, outcome).Inc()
+	if delta != nil {
+		p.shadowLatencyDelta.WithLabelValues(instanceID).Observe(delta.Seconds())
+	}
+}
+
+// proxyToPrimary proxies the request to an instance like proxyToInstance,
+// mirroring it to the instance's shadow if it has one.
+func (p *Proxy) proxyToPrimary(w http.ResponseWriter, r *http.Request, traceID, instanceID, backendHost, path string) {
+	p.traceInstance(r, instanceID)
+	finish := p.startShadow(r, traceID, instanceID, path)
+	if finish == nil {
+		p.proxyToInstance(w, r, traceID, backendHost, path)
+		return
+	}
+	recorder := httputils.NewResponseRecorder(w, 0)
+	start := time.Now()
+	defer func() { finish(recorder.Status(), time.Since(start)) }()
+	p.proxyToInstance(recorder, r, traceID, backendHost, path)
+}
+
+// handleShadowReport serves the ShadowReport of /apps/{instanceID}/shadow-report.
+func (p *Proxy) handleShadowReport(w http.ResponseWriter, r *http.Request) {
+	instanceID := strings.Split(r.URL.Path, "/")[2]
+	s, ok := p.shadows[instanceID]
+	if !ok {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("instance %s has no shadow", instanceID), http.StatusNotFound)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, s.report(instanceID), nil, http.StatusOK)
+}
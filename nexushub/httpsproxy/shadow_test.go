@@ -0,0 +1,281 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// shadowProcessManager serves the shadow instance "v2" at the given port, or
+// reports it not running if the port is zero.
+type shadowProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+	port int
+}
+
+func (pm *shadowProcessManager) GetAppInstanceByID(id string) (*processes.AppInstance, int, error) {
+	if id != "v2" || pm.port == 0 {
+		return nil, 0, errors.New("not running")
+	}
+	return &processes.AppInstance{InstanceID: id}, pm.port, nil
+}
+
+func serverPort(t *testing.T, server *httptest.Server) int {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
+
+// newShadowTestProxy returns a proxy serving instance "v1" from primary and
+// mirroring all of its reads to "v2", served by shadow if non-nil.
+func newShadowTestProxy(t *testing.T, primary, shadow *httptest.Server) (*Proxy, *httptest.Server) {
+	pm := &shadowProcessManager{}
+	if shadow != nil {
+		pm.port = serverPort(t, shadow)
+	}
+	p := &Proxy{pm: pm, transport: &http.Transport{}}
+	p.SetShadowRoutes(map[string]ShadowRoute{"v1": {Target: "v2", Rate: 1}})
+	primaryURL, _ := url.Parse(primary.URL)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.proxyToPrimary(w, r, "trace", "v1", primaryURL.Host, strings.TrimPrefix(r.URL.Path, "/v1"))
+	}))
+	t.Cleanup(server.Close)
+	return p, server
+}
+
+// waitForReport waits until the shadow report of v1 satisfies done.
+func waitForReport(t *testing.T, p *Proxy, done func(ShadowReport) bool) ShadowReport {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		report := p.shadows["v1"].report("v1")
+		if done(report) {
+			return report
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the shadow report, got %+v", report)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestParseShadowRoutes(t *testing.T) {
+	routes, err := ParseShadowRoutes("v1=v2@0.25, tasks = tasks2 @ 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes["v1"] != (ShadowRoute{Target: "v2", Rate: 0.25}) || routes["tasks"] != (ShadowRoute{Target: "tasks2", Rate: 1}) {
+		t.Errorf("Unexpected routes %+v", routes)
+	}
+
+	for _, value := range []string{"v1=v2", "v1@0.5", "v1=v2@0", "v1=v2@1.5", "v1=v2@x", "v1=v1@0.5", "v1=v2@0.5,v1=v3@0.5"} {
+		if _, err := ParseShadowRoutes(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestSamplerMath(t *testing.T) {
+	for _, tc := range []struct {
+		rate float64
+		want int
+	}{
+		{1, 1000},
+		{0.5, 500},
+		{0.25, 250},
+		{0.1, 100},
+		{1.0 / 3, 333},
+		{0.001, 1},
+		{0.0001, 0},
+	} {
+		s := newSampler(tc.rate)
+		sampled := 0
+		for i := 0; i < 1000; i++ {
+			if s.sample() {
+				sampled++
+			}
+		}
+		if sampled != tc.want {
+			t.Errorf("Rate %v sampled %d of 1000 requests, expected %d", tc.rate, sampled, tc.want)
+		}
+	}
+
+	// Samples are spread evenly rather than bunched together
+	s := newSampler(0.25)
+	for i := 0; i < 12; i++ {
+		if got, want := s.sample(), i%4 == 3; got != want {
+			t.Errorf("Request %d: sampled %v, expected %v", i, got, want)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var deltas []time.Duration
+	for i := 1; i <= 100; i++ {
+		deltas = append(deltas, time.Duration(i)*time.Millisecond)
+	}
+	if p50, p90, p99 := percentileMs(deltas, 0.5), percentileMs(deltas, 0.9), percentileMs(deltas, 0.99); p50 != 50 || p90 != 90 || p99 != 99 {
+		t.Errorf("Expected 50/90/99, got %v/%v/%v", p50, p90, p99)
+	}
+	if p := percentileMs(nil, 0.5); p != 0 {
+		t.Errorf("Expected no percentile without samples, got %v", p)
+	}
+}
+
+func TestShadowMirrorsReads(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+	mirrored := make(chan *http.Request, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r
+		if r.URL.Path == "/api/missing" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("shadow"))
+	}))
+	defer shadow.Close()
+	p, server := newShadowTestProxy(t, primary, shadow)
+	registry := prometheus.NewRegistry()
+	p.EnableShadowMetrics(registry)
+
+	resp, err := http.Get(server.URL + "/v1/api/items?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "primary" {
+		t.Errorf("Expected the primary's response, got %q", body)
+	}
+	r := <-mirrored
+	if r.URL.Path != "/api/items" || r.URL.RawQuery != "page=2" || r.Header.Get(ShadowHeader) != "true" {
+		t.Errorf("Unexpected mirrored request %s %s?%s with %s %q", r.Method, r.URL.Path, r.URL.RawQuery, ShadowHeader, r.Header.Get(ShadowHeader))
+	}
+
+	// A status the primary doesn't share is a mismatch
+	resp, err = http.Get(server.URL + "/v1/api/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	<-mirrored
+	report := waitForReport(t, p, func(r ShadowReport) bool { return r.Compared == 2 })
+	if report.StatusMismatches != 1 || report.ShadowErrors != 0 || report.Target != "v2" {
+		t.Errorf("Expected one mismatch, got %+v", report)
+	}
+	if count := testutil.ToFloat64(p.shadowRequests.WithLabelValues("v1", "mismatch")); count != 1 {
+		t.Errorf("Expected a mismatch to be counted in the metrics, got %v", count)
+	}
+	if count := testutil.CollectAndCount(registry, "nexushub_shadow_latency_delta_seconds"); count != 1 {
+		t.Errorf("Expected latency deltas to be recorded, got %d series", count)
+	}
+
+	// Mutating requests, and so their bodies, are never mirrored
+	resp, err = http.Post(server.URL+"/v1/api/items", "application/json", strings.NewReader(`{"secret":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case r := <-mirrored:
+		t.Errorf("Expected a POST not to be mirrored, got %s %s", r.Method, r.URL.Path)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestShadowFailuresDoNotAffectPrimary(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+	get := func(server *httptest.Server) {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/v1/api/items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "primary" {
+			t.Errorf("Expected the primary's response, got %d %q", resp.StatusCode, body)
+		}
+	}
+
+	// A shadow that is not running
+	p, server := newShadowTestProxy(t, primary, nil)
+	get(server)
+	waitForReport(t, p, func(r ShadowReport) bool { return r.ShadowErrors == 1 })
+
+	// A shadow that resets the connection
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer broken.Close()
+	p, server = newShadowTestProxy(t, primary, broken)
+	get(server)
+	waitForReport(t, p, func(r ShadowReport) bool { return r.ShadowErrors == 1 })
+
+	// A shadow that hangs neither delays the primary nor piles up requests
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	p, server = newShadowTestProxy(t, primary, slow)
+	p.shadowSlots = make(chan struct{}, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		get(server)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the primary not to wait for the shadow, took %v", elapsed)
+	}
+	report := waitForReport(t, p, func(r ShadowReport) bool { return r.Dropped == 2 })
+	if report.Compared != 0 {
+		t.Errorf("Expected no comparisons while the shadow hangs, got %+v", report)
+	}
+}
+
+func TestShadowReportEndpoint(t *testing.T) {
+	p := &Proxy{secrets: secrets.NewStore(time.Minute)}
+	p.SetShadowRoutes(map[string]ShadowRoute{"v1": {Target: "v2", Rate: 0.5}})
+	p.shadows["v1"].record(shadowResult{status: 200, latency: 10 * time.Millisecond}, shadowResult{status: 200, latency: 15 * time.Millisecond})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer "+p.secrets.Current())
+		recorder := httptest.NewRecorder()
+		p.handleRequest(recorder, r)
+		return recorder
+	}
+	recorder := get("/apps/v1/shadow-report")
+	var report ShadowReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid report %q: %v", recorder.Body.String(), err)
+	}
+	if report.InstanceID != "v1" || report.SampleRate != 0.5 || report.Compared != 1 || report.LatencyDeltaMs.P50 != 5 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if recorder := get("/apps/v3/shadow-report"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an instance without a shadow, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package httpsproxy
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/middleware"
+)
+
+// staticCacheControl is sent with static files other than HTML pages. Pages
+// are revalidated on every request so that a new install is picked up
+// immediately, and they in turn reference the other assets.
+const staticCacheControl = "public, max-age=3600"
+
+// serveStatic serves the file at urlPath under an instance's StaticPath and
+// reports whether it did. Requests for "/" or a directory are served its
+// index.html. Only GET and HEAD requests are served, and paths that don't
+// name a regular file inside staticPath, including hidden files and symlinks
+// leading outside it, are left for the backend.
+func serveStatic(w http.ResponseWriter, r *http.Request, traceID, staticPath, urlPath string) bool {
+	if staticPath == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	name, ok := resolveStaticFile(staticPath, urlPath)
+	if !ok {
+		return false
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	middleware.CorsMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(name, ".html") {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", staticCacheControl)
+		}
+		// ServeContent sets the content type from the extension, sniffing the
+		// content if it is unknown, and answers conditional and range requests
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	})
+	log.Printf("<%s> %s %s => static %s", traceID, r.Host, r.URL.Path, name)
+	return true
+}
+
+// resolveStaticFile maps a URL path to a file under staticPath, following
+// symlinks, and reports whether it stays inside staticPath.
+func resolveStaticFile(staticPath, urlPath string) (string, bool) {
+	// Cleaning a rooted path removes every ".." segment
+	cleaned := path.Clean("/" + urlPath)
+	for _, segment := range strings.Split(cleaned, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	name := filepath.Join(staticPath, filepath.FromSlash(cleaned))
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		name = filepath.Join(name, "index.html")
+	}
+
+	root, err := filepath.EvalSymlinks(staticPath)
+	if err != nil {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return resolved, true
+}
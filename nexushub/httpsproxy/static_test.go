@@ -0,0 +1,178 @@
+package httpsproxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// newStaticDir creates dir/static with an index page, assets and a hidden
+// file, next to a secret that must not be reachable from it, and returns it.
+func newStaticDir(t *testing.T, dir string) string {
+	static := filepath.Join(dir, "static")
+	files := map[string]string{
+		"secret.txt":                 "secret",
+		"static/index.html":          "<html>index</html>",
+		"static/assets/app.css":      "body {}",
+		"static/docs/index.html":     "<html>docs</html>",
+		"static/.env":                "TOKEN=secret",
+		"static/assets/data.unknown": "{}",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(static, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return static
+}
+
+func TestServeStatic(t *testing.T) {
+	static := newStaticDir(t, t.TempDir())
+	for _, tc := range []struct {
+		path, body, contentType, cacheControl string
+	}{
+		{"/", "<html>index</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/index.html", "<html>index</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/docs/", "<html>docs</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/assets/app.css", "body {}", "text/css; charset=utf-8", staticCacheControl},
+		{"/assets/data.unknown", "{}", "text/plain; charset=utf-8", staticCacheControl},
+		// Dot segments are resolved before the file is looked up
+		{"/assets/../index.html", "<html>index</html>", "text/html; charset=utf-8", "no-cache"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		if !serveStatic(recorder, r, "trace", static, tc.path) {
+			t.Errorf("%s: expected a static file", tc.path)
+			continue
+		}
+		if recorder.Code != http.StatusOK || recorder.Body.String() != tc.body {
+			t.Errorf("%s: expected %q, got %d %q", tc.path, tc.body, recorder.Code, recorder.Body.String())
+		}
+		if got := recorder.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tc.path, tc.contentType, got)
+		}
+		if got := recorder.Header().Get("Cache-Control"); got != tc.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tc.path, tc.cacheControl, got)
+		}
+		if recorder.Header().Get("Last-Modified") == "" {
+			t.Errorf("%s: expected Last-Modified to be set", tc.path)
+		}
+	}
+}
+
+func TestServeStaticLeavesOtherRequestsToBackend(t *testing.T) {
+	static := newStaticDir(t, t.TempDir())
+	for _, tc := range []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/api/items"},
+		{http.MethodGet, "/assets/"},
+		{http.MethodPost, "/index.html"},
+		{http.MethodGet, "/../secret.txt"},
+		{http.MethodGet, "/../../secret.txt"},
+		{http.MethodGet, "/link.txt"},
+		{http.MethodGet, "/.env"},
+	} {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		recorder := httptest.NewRecorder()
+		if serveStatic(recorder, r, "trace", static, tc.path) {
+			t.Errorf("%s %s: expected no static file, got %d %q", tc.method, tc.path, recorder.Code, recorder.Body.String())
+		}
+	}
+	if serveStatic(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), "trace", "", "/index.html") {
+		t.Error("Expected no static files without a StaticPath")
+	}
+}
+
+func TestServeStaticConditionalRequests(t *testing.T) {
+	static := newStaticDir(t, t.TempDir())
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	serveStatic(recorder, r, "trace", static, "/assets/app.css")
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-Modified-Since", recorder.Header().Get("Last-Modified"))
+	recorder = httptest.NewRecorder()
+	serveStatic(recorder, r, "trace", static, "/assets/app.css")
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for an unchanged file, got %d", recorder.Code)
+	}
+}
+
+// staticProcessManager runs the instances of a package manager on backend.
+type staticProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+	packages *packages.PackageManager
+	port     int
+}
+
+func (pm *staticProcessManager) Refresh() {}
+
+func (pm *staticProcessManager) GetAppInstanceByID(id string) (*processes.AppInstance, int, error) {
+	instances, err := pm.packages.GetAppInstances()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, instance := range instances {
+		if instance.InstanceID == id {
+			return &instance, pm.port, nil
+		}
+	}
+	return nil, 0, errors.New("not running")
+}
+
+func TestApplicationStaticFilesBeforeBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend " + r.URL.Path))
+	}))
+	defer backend.Close()
+	installDir := t.TempDir()
+	packageManager, err := packages.OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packageManager.DB.Close()
+	newStaticDir(t, filepath.Join(installDir, "abc", "app"))
+	if err := packages.PackageDBInsert(packageManager.DB, "abc", "hash", "app", "1.0.0", map[string]bool{}, "", false, "static", ""); err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{
+		pm:             &staticProcessManager{packages: packageManager, port: serverPort(t, backend)},
+		packageManager: packageManager,
+		transport:      &http.Transport{},
+	}
+
+	get := func(path string) string {
+		recorder := httptest.NewRecorder()
+		p.handleApplication(recorder, httptest.NewRequest(http.MethodGet, path, nil), "trace")
+		body, _ := io.ReadAll(recorder.Body)
+		return string(body)
+	}
+	if body := get("/abc/assets/app.css"); body != "body {}" {
+		t.Errorf("Expected the static file, got %q", body)
+	}
+	if body := get("/abc/"); body != "<html>index</html>" {
+		t.Errorf("Expected the index page, got %q", body)
+	}
+	if body := get("/abc/api/items"); body != "backend /api/items" {
+		t.Errorf("Expected missing files to be proxied, got %q", body)
+	}
+	if body := get("/abc/../secret.txt"); body == "secret" {
+		t.Error("Expected files outside the StaticPath not to be served")
+	}
+}
@@ -0,0 +1,182 @@
+package httpsproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TLSPolicy controls the protocol versions and cipher suites the proxy
+// accepts when serving HTTPS.
+type TLSPolicy struct {
+	// MinVersion is the lowest accepted TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+	// CipherSuites lists the accepted TLS 1.2 cipher suites in order of
+	// preference; nil means Go's defaults. TLS 1.3 suites are not
+	// configurable.
+	CipherSuites []uint16
+	// DisableHTTP1 rejects requests that did not negotiate HTTP/2.
+	DisableHTTP1 bool
+}
+
+// DefaultTLSPolicy requires TLS 1.2 or later with Go's default cipher suites
+// and serves both HTTP/1.1 and HTTP/2.
+func DefaultTLSPolicy() TLSPolicy {
+	return TLSPolicy{MinVersion: tls.VersionTLS12}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version such as "1.2" or "TLS1.3".
+func ParseTLSVersion(value string) (uint16, error) {
+	name := strings.TrimSpace(value)
+	name = strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(name), "TLS"), "V")
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2, 1.3", value)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses a comma-separated list of cipher suite names as
+// listed by tls.CipherSuites, e.g.
+// "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384".
+// Suites with known security issues are refused.
+func ParseCipherSuites(value string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Validate checks that the policy can be applied.
+func (policy TLSPolicy) Validate() error {
+	if policy.MinVersion < tls.VersionTLS10 || policy.MinVersion > tls.VersionTLS13 {
+		return fmt.Errorf("unknown TLS version 0x%04x", policy.MinVersion)
+	}
+	if policy.MinVersion < tls.VersionTLS12 && policy.DisableHTTP1 {
+		// HTTP/2 requires TLS 1.2, see RFC 9113 section 9.2
+		return fmt.Errorf("HTTP/2-only serving requires a minimum version of TLS 1.2")
+	}
+	if len(policy.CipherSuites) > 0 && policy.MinVersion >= tls.VersionTLS13 {
+		return fmt.Errorf("cipher suites cannot be configured when the minimum version is TLS 1.3")
+	}
+	return nil
+}
+
+// TLSPolicyFromEnv reads the TLS policy from the TLS_MIN_VERSION (default
+// 1.2), TLS_CIPHER_SUITES (comma-separated names) and TLS_DISABLE_HTTP1
+// environment variables.
+func TLSPolicyFromEnv() (TLSPolicy, error) {
+	policy := DefaultTLSPolicy()
+	if value := os.Getenv("TLS_MIN_VERSION"); value != "" {
+		version, err := ParseTLSVersion(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid TLS_MIN_VERSION: %w", err)
+		}
+		policy.MinVersion = version
+	}
+	if value := os.Getenv("TLS_CIPHER_SUITES"); value != "" {
+		suites, err := ParseCipherSuites(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid TLS_CIPHER_SUITES: %w", err)
+		}
+		policy.CipherSuites = suites
+	}
+	if value := os.Getenv("TLS_DISABLE_HTTP1"); value != "" {
+		disable, err := strconv.ParseBool(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid TLS_DISABLE_HTTP1: %w", err)
+		}
+		policy.DisableHTTP1 = disable
+	}
+	return policy, policy.Validate()
+}
+
+// SetTLSPolicy replaces the TLS policy used when the proxy starts in HTTPS
+// mode.
+func (p *Proxy) SetTLSPolicy(policy TLSPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS policy: %w", err)
+	}
+	p.tlsPolicy = policy
+	return nil
+}
+
+// tlsConfig returns the server TLS configuration for the policy.
+func (policy TLSPolicy) tlsConfig(cert tls.Certificate) *tls.Config {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   policy.MinVersion,
+		CipherSuites: policy.CipherSuites,
+	}
+	if policy.DisableHTTP1 {
+		// http.Server still offers http/1.1 over ALPN, so requireHTTP2 turns
+		// away clients that negotiate it.
+		config.NextProtos = []string{"h2"}
+	}
+	return config
+}
+
+// requireHTTP2 wraps handler to refuse requests made over HTTP/1.x when the
+// policy disables it.
+func (policy TLSPolicy) requireHTTP2(handler http.Handler) http.Handler {
+	if !policy.DisableHTTP1 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 2 {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "HTTP/2 required", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// String describes the policy for the startup log.
+func (policy TLSPolicy) String() string {
+	suites := "Go defaults"
+	if len(policy.CipherSuites) > 0 {
+		names := make([]string, len(policy.CipherSuites))
+		for i, id := range policy.CipherSuites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		suites = strings.Join(names, ",")
+	}
+	protocols := "HTTP/1.1,HTTP/2"
+	if policy.DisableHTTP1 {
+		protocols = "HTTP/2"
+	}
+	return fmt.Sprintf("min version %s, cipher suites %s, protocols %s",
+		tls.VersionName(policy.MinVersion), suites, protocols)
+}
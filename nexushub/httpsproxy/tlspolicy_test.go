@@ -0,0 +1,117 @@
+package httpsproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestTLSPolicyFromEnv(t *testing.T) {
+	policy, err := TLSPolicyFromEnv()
+	if err != nil || policy.MinVersion != tls.VersionTLS12 || policy.CipherSuites != nil || policy.DisableHTTP1 {
+		t.Errorf("Expected the default policy, got %+v: %v", policy, err)
+	}
+
+	t.Setenv("TLS_MIN_VERSION", "TLS1.2")
+	t.Setenv("TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	t.Setenv("TLS_DISABLE_HTTP1", "true")
+	policy, err = TLSPolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSuites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if !slices.Equal(policy.CipherSuites, expectedSuites) || !policy.DisableHTTP1 {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	for _, env := range [][2]string{
+		{"TLS_MIN_VERSION", "1.4"},
+		{"TLS_CIPHER_SUITES", "TLS_RSA_WITH_RC4_128_SHA"},
+		{"TLS_CIPHER_SUITES", "TLS_NOT_A_SUITE"},
+		{"TLS_DISABLE_HTTP1", "maybe"},
+	} {
+		t.Run(env[1], func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if _, err := TLSPolicyFromEnv(); err == nil {
+				t.Errorf("Expected an error for %s=%s", env[0], env[1])
+			}
+		})
+	}
+}
+
+func TestTLSPolicyValidate(t *testing.T) {
+	invalid := []TLSPolicy{
+		{},
+		{MinVersion: tls.VersionTLS11, DisableHTTP1: true},
+		{MinVersion: tls.VersionTLS13, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}},
+	}
+	for _, policy := range invalid {
+		if err := (&Proxy{}).SetTLSPolicy(policy); err == nil {
+			t.Errorf("Expected %+v to be rejected", policy)
+		}
+	}
+}
+
+// startTLSPolicyServer serves a handler that reports the protocol with the
+// given policy applied.
+func startTLSPolicyServer(t *testing.T, policy TLSPolicy) *httptest.Server {
+	server := httptest.NewUnstartedServer(policy.requireHTTP2(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})))
+	server.EnableHTTP2 = true
+	// StartTLS fills in its own test certificate
+	server.TLS = policy.tlsConfig(tls.Certificate{})
+	server.TLS.Certificates = nil
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTLSPolicyMinVersion(t *testing.T) {
+	server := startTLSPolicyServer(t, TLSPolicy{MinVersion: tls.VersionTLS13})
+
+	tls12 := server.Client().Transport.(*http.Transport).Clone()
+	tls12.TLSClientConfig.MaxVersion = tls.VersionTLS12
+	if resp, err := (&http.Client{Transport: tls12}).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("Expected a TLS 1.2 client to be refused")
+	}
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %s", tls.VersionName(resp.TLS.Version))
+	}
+}
+
+func TestTLSPolicyDisableHTTP1(t *testing.T) {
+	server := startTLSPolicyServer(t, TLSPolicy{MinVersion: tls.VersionTLS12, DisableHTTP1: true})
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Errorf("Expected an HTTP/2 response, got %d over %s", resp.StatusCode, resp.Proto)
+	}
+
+	// A client that only speaks HTTP/1.1
+	http1 := server.Client().Transport.(*http.Transport).Clone()
+	http1.ForceAttemptHTTP2 = false
+	http1.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	http1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	resp, err = (&http.Client{Transport: http1}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusHTTPVersionNotSupported {
+		t.Errorf("Expected HTTP/1.1 to be refused with 505, got %d", resp.StatusCode)
+	}
+}
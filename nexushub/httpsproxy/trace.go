@@ -0,0 +1,150 @@
+package httpsproxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/crashes"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/traces"
+)
+
+// TraceView is everything the hub knows about a request, served by
+// /debug/trace/{traceID}.
+type TraceView struct {
+	TraceID string `json:"traceId"`
+	// Request is the proxy's record of the request; nil if it was not
+	// indexed
+	Request *traces.Record `json:"request,omitempty"`
+	// Logs are the lines the instance logged while serving the request
+	Logs []processes.ProcessLogEntry `json:"logs"`
+	// LogError says why the logs could not be read
+	LogError string `json:"logError,omitempty"`
+	// Crashes are the crashes reported while serving the request
+	Crashes []*crashes.Crash `json:"crashes"`
+}
+
+// SetTraceIndex enables the trace index, recording the requests the config
+// samples in the given store.
+func (p *Proxy) SetTraceIndex(store *traces.Store, config traces.Config) {
+	p.traces = store
+	p.traceConfig = config
+}
+
+// requestTrace is what the proxy learns about a request while serving it,
+// for the trace index.
+type requestTrace struct {
+	instanceID  string
+	logPosition processes.LogPosition
+}
+
+type traceContextKey struct{}
+
+// beginTrace prepares to index the request if it is sampled once it has been
+// served. The returned function must be called when it has been.
+func (p *Proxy) beginTrace(w http.ResponseWriter, r *http.Request, traceID string) (http.ResponseWriter, *http.Request, func()) {
+	startedAt := time.Now()
+	// The request is rewritten when it is proxied
+	method, host, path := r.Method, r.Host, r.URL.Path
+	trace := &requestTrace{}
+	recorder := httputils.NewResponseRecorder(w, traces.MaxErrorLength)
+	r = r.WithContext(context.WithValue(r.Context(), traceContextKey{}, trace))
+	return recorder, r, func() {
+		status := recorder.Status()
+		if status == 0 {
+			status = http.StatusOK
+			if errors.Is(r.Context().Err(), context.Canceled) {
+				status = statusClientClosedRequest
+			}
+		}
+		if !p.traceConfig.Sample(status) {
+			return
+		}
+		// The proxy sets the user ID header of requests made with an access
+		// token after removing the client's
+		userID, _ := httputils.RequestUserID(r)
+		var failure string
+		if status >= http.StatusBadRequest {
+			failure = strings.TrimSpace(string(recorder.Body()))
+		}
+		err := p.traces.Record(traces.Record{
+			TraceID:     traceID,
+			InstanceID:  trace.instanceID,
+			UserID:      userID,
+			Method:      method,
+			Host:        host,
+			Path:        path,
+			Status:      status,
+			StartedAt:   startedAt,
+			FinishedAt:  time.Now(),
+			Error:       failure,
+			LogPosition: trace.logPosition,
+		})
+		if err != nil {
+			log.Printf("<%s> Failed to index trace: %v", traceID, err)
+		}
+	}
+}
+
+// traceInstance notes the instance serving the request for its trace, along
+// with where the instance's output stands before it does.
+func (p *Proxy) traceInstance(r *http.Request, instanceID string) {
+	trace, ok := r.Context().Value(traceContextKey{}).(*requestTrace)
+	if !ok {
+		return
+	}
+	trace.instanceID = instanceID
+	if position, ok := p.pm.GetLogPosition(instanceID); ok {
+		trace.logPosition = position
+	}
+}
+
+// handleTrace serves the TraceView of /debug/trace/{traceID}. Requests that
+// were not indexed are still found by the crashes they caused.
+func (p *Proxy) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if p.traces == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	traceID := strings.Split(r.URL.Path, "/")[3]
+	view := TraceView{TraceID: traceID, Logs: []processes.ProcessLogEntry{}, Crashes: []*crashes.Crash{}}
+
+	record, err := p.traces.Get(traceID)
+	if err != nil && !errors.Is(err, traces.ErrNotFound) {
+		log.Printf("Failed to read trace %s: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if record != nil {
+		view.Request = record
+		if record.InstanceID != "" {
+			// Lines logged after the response are still part of it
+			logs, err := p.pm.GetTraceLogs(record.InstanceID, traceID, record.LogPosition, record.StartedAt)
+			if err != nil {
+				view.LogError = err.Error()
+			} else {
+				view.Logs = logs
+			}
+		}
+	}
+	if p.crashStore != nil {
+		found, err := p.crashStore.ByTraceID(traceID)
+		if err != nil {
+			log.Printf("Failed to find crashes of trace %s: %v", traceID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		view.Crashes = found
+	}
+
+	if view.Request == nil && len(view.Crashes) == 0 {
+		httputils.HandleAPIResponse(w, r, nil, traces.ErrNotFound, http.StatusNotFound)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, view, nil, http.StatusOK)
+}
@@ -0,0 +1,243 @@
+package httpsproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/crashes"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/traces"
+)
+
+// tracingProcessManager serves instance "abc" from a backend whose output
+// goes to logs, as the process manager reads it from a process, and has
+// instance "down" installed but never running.
+type tracingProcessManager struct {
+	staticProcessManager
+	logs *processes.LogBuffer
+}
+
+const tracingPID = 100
+
+func (pm *tracingProcessManager) GetAppInstanceByID(id string) (*processes.AppInstance, int, error) {
+	if id == "down" {
+		return nil, 0, errors.New("not running")
+	}
+	return pm.staticProcessManager.GetAppInstanceByID(id)
+}
+
+func (pm *tracingProcessManager) WaitForInstance(ctx context.Context, id string) (*processes.AppInstance, int, error) {
+	return pm.GetAppInstanceByID(id)
+}
+
+func (pm *tracingProcessManager) GetLogPosition(id string) (processes.LogPosition, bool) {
+	if id != "abc" {
+		return processes.LogPosition{}, false
+	}
+	return processes.LogPosition{PID: tracingPID, ID: pm.logs.GetLatestID()}, true
+}
+
+func (pm *tracingProcessManager) GetTraceLogs(id, traceID string, from processes.LogPosition, since time.Time) ([]processes.ProcessLogEntry, error) {
+	entries := []processes.ProcessLogEntry{}
+	if id != "abc" {
+		return entries, nil
+	}
+	for _, entry := range pm.logs.GetEntriesFromID(from.ID) {
+		if entry.TraceID == traceID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// logWriter adds each line written to it to a log buffer.
+type logWriter struct {
+	logs *processes.LogBuffer
+}
+
+func (w logWriter) Write(line []byte) (int, error) {
+	w.logs.AddEntry("info", "stderr", strings.TrimSuffix(string(line), "\n"), tracingPID)
+	return len(line), nil
+}
+
+// newTracingProxy returns a proxy indexing failed requests to instance "abc",
+// served by a backend that logs like an applib application and fails as the
+// request path says, and the crash store the backend reports panics to.
+func newTracingProxy(t *testing.T) (*Proxy, context.Context) {
+	p, _, ctx := newConformanceProxy(t)
+	logs := processes.NewLogBuffer(100)
+	logger := slog.New(slog.NewTextHandler(logWriter{logs}, nil))
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// applib tags the lines logged with the request's context with its
+		// trace ID as their last attribute
+		traceID, _ := httputils.RequestTraceID(r)
+		logger.Info("unrelated background work")
+		logger.Info("handling request", "path", r.URL.Path, httputils.TraceIDLogKey, traceID)
+		switch r.URL.Path {
+		case "/api/fail":
+			logger.Error("query failed", "error", "database is locked", httputils.TraceIDLogKey, traceID)
+			http.Error(w, "database is locked", http.StatusInternalServerError)
+		case "/api/panic":
+			p.crashStore.Record("abc", crashes.Report{
+				Message: "nil map",
+				Stack:   "goroutine 1 [running]:\nmain.handler()\n",
+				Request: &crashes.RequestContext{Method: r.Method, Path: r.URL.Path, TraceID: traceID},
+			})
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	t.Cleanup(backend.Close)
+
+	packageManager, err := packages.OpenPackageManager(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { packageManager.DB.Close() })
+	for _, id := range []string{"abc", "down"} {
+		if err := packages.PackageDBInsert(packageManager.DB, id, "hash", "app", "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.pm = &tracingProcessManager{
+		staticProcessManager: staticProcessManager{packages: packageManager, port: serverPort(t, backend)},
+		logs:                 logs,
+	}
+	p.packageManager = packageManager
+	p.transport = &http.Transport{}
+	p.SetColdStartTimeout(time.Millisecond)
+
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "traces.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := traces.NewStore(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTraceIndex(store, traces.Config{SampleRate: 0, ErrorSampleRate: 1})
+	return p, ctx
+}
+
+// tracedRequest makes a request as user 5 and returns its trace ID.
+func tracedRequest(t *testing.T, p *Proxy, ctx context.Context, path string, status int) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+	bearer(r, newToken(time.Now().Add(time.Hour), false))
+	recorder := httptest.NewRecorder()
+	p.handleRequest(recorder, r)
+	if recorder.Code != status {
+		t.Fatalf("Expected %d for %s, got %d: %s", status, path, recorder.Code, recorder.Body.String())
+	}
+	traceID := recorder.Header().Get(httputils.TraceIDHeader)
+	if traceID == "" {
+		t.Fatalf("Expected a trace ID for %s", path)
+	}
+	return traceID
+}
+
+// getTrace looks up a trace with the internal secret.
+func getTrace(t *testing.T, p *Proxy, ctx context.Context, traceID string) (int, TraceView) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/debug/trace/"+traceID, nil).WithContext(ctx)
+	bearer(r, p.secrets.Current())
+	recorder := httptest.NewRecorder()
+	p.handleRequest(recorder, r)
+	var view TraceView
+	if recorder.Code == http.StatusOK {
+		if err := json.NewDecoder(recorder.Body).Decode(&view); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code, view
+}
+
+func TestTraceCorrelatesFailedRequest(t *testing.T) {
+	p, ctx := newTracingProxy(t)
+	tracedRequest(t, p, ctx, "/abc/api/other", http.StatusOK)
+	traceID := tracedRequest(t, p, ctx, "/abc/api/fail", http.StatusInternalServerError)
+	tracedRequest(t, p, ctx, "/abc/api/other", http.StatusOK)
+
+	status, view := getTrace(t, p, ctx, traceID)
+	if status != http.StatusOK || view.Request == nil {
+		t.Fatalf("Expected the trace to be indexed, got %d", status)
+	}
+	request := view.Request
+	if request.InstanceID != "abc" || request.UserID != 5 || request.Path != "/abc/api/fail" || request.Status != 500 || request.Error != "database is locked" {
+		t.Errorf("Unexpected access record %+v", request)
+	}
+	if request.LogPosition.PID != tracingPID || request.LogPosition.ID != 2 {
+		t.Errorf("Expected the position after the first request's lines, got %+v", request.LogPosition)
+	}
+
+	// Only the lines logged for the request, not the background work or the
+	// other requests
+	var messages []string
+	for _, entry := range view.Logs {
+		if entry.TraceID != traceID {
+			t.Errorf("Unexpected line %+v", entry)
+		}
+		messages = append(messages, entry.Message)
+	}
+	if len(messages) != 2 || !strings.Contains(messages[0], "handling request") || !strings.Contains(messages[1], `msg="query failed" error="database is locked"`) {
+		t.Errorf("Expected the request's two lines, got %q", messages)
+	}
+	if len(view.Crashes) != 0 {
+		t.Errorf("Expected no crashes, got %+v", view.Crashes)
+	}
+}
+
+func TestTraceIncludesCrashReport(t *testing.T) {
+	p, ctx := newTracingProxy(t)
+	traceID := tracedRequest(t, p, ctx, "/abc/api/panic", http.StatusInternalServerError)
+
+	_, view := getTrace(t, p, ctx, traceID)
+	if len(view.Crashes) != 1 || view.Crashes[0].Message != "nil map" || view.Crashes[0].Request.TraceID != traceID {
+		t.Errorf("Expected the crash report, got %+v", view.Crashes)
+	}
+	if len(view.Logs) != 1 {
+		t.Errorf("Expected the request's line, got %+v", view.Logs)
+	}
+}
+
+func TestTraceOfUnavailableInstance(t *testing.T) {
+	p, ctx := newTracingProxy(t)
+	traceID := tracedRequest(t, p, ctx, "/down/api/items", http.StatusServiceUnavailable)
+
+	_, view := getTrace(t, p, ctx, traceID)
+	if view.Request == nil || view.Request.InstanceID != "down" || view.Request.Status != 503 || !strings.Contains(view.Request.Error, "temporarily unavailable") {
+		t.Errorf("Expected the 503 to be indexed, got %+v", view.Request)
+	}
+	if len(view.Logs) != 0 {
+		t.Errorf("Expected no lines from an instance that is not running, got %+v", view.Logs)
+	}
+}
+
+func TestTraceSampling(t *testing.T) {
+	p, ctx := newTracingProxy(t)
+
+	// Successful requests are not sampled at a rate of 0
+	traceID := tracedRequest(t, p, ctx, "/abc/api/items", http.StatusOK)
+	if status, _ := getTrace(t, p, ctx, traceID); status != http.StatusNotFound {
+		t.Errorf("Expected an unsampled request not to be found, got %d", status)
+	}
+
+	// Nor are failures once their rate is 0 too, though their crashes are
+	// still found
+	p.traceConfig = traces.Config{}
+	traceID = tracedRequest(t, p, ctx, "/abc/api/panic", http.StatusInternalServerError)
+	status, view := getTrace(t, p, ctx, traceID)
+	if status != http.StatusOK || view.Request != nil || len(view.Crashes) != 1 {
+		t.Errorf("Expected only the crash, got %d %+v", status, view)
+	}
+}
@@ -1,7 +1,11 @@
 package types
 
 import (
+	"context"
+	"time"
+
 	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/types"
 )
 
 // ProcessManagerInterface defines the methods the HostnameResolver needs
@@ -10,6 +14,12 @@ import (
 type ProcessManagerInterface interface {
 	GetAppInstanceByHostName(hostname string) (*processes.AppInstance, int, error)
 	GetAppInstanceByID(id string) (*processes.AppInstance, int, error) // Added for AppID lookup
+	// WaitForInstance is GetAppInstanceByID, waiting until ctx is done for an
+	// instance that is not ready yet
+	WaitForInstance(ctx context.Context, id string) (*processes.AppInstance, int, error)
+	// GetInstanceStatus reports an instance's state whether or not it is
+	// running, including why it last failed
+	GetInstanceStatus(id string) (*processes.InstanceStatus, bool)
 
 	EventPublished()
 	AddEventStateCallback() (string, chan processes.EventCallbackInfo)
@@ -20,7 +30,34 @@ type ProcessManagerInterface interface {
 	GetProcessLogs(instanceID string, fromID int64) ([]processes.ProcessLogEntry, error)
 	GetLatestProcessLogs(instanceID string, count int) ([]processes.ProcessLogEntry, error)
 	GetProcessLogLatestID(instanceID string) (int64, error)
-	AddLogCallback(callback processes.LogCallback)
+	AddLogCallback(callback processes.LogCallback) processes.LogCallbackHandle
+	RemoveLogCallback(handle processes.LogCallbackHandle)
+
+	// GetLogPosition and GetTraceLogs find the log lines an instance wrote
+	// while serving a request, by the request's trace ID
+	GetLogPosition(instanceID string) (processes.LogPosition, bool)
+	GetTraceLogs(instanceID, traceID string, from processes.LogPosition, since time.Time) ([]processes.ProcessLogEntry, error)
+
+	// GetLogLevel and SetLogLevel read and change the log level spec of an
+	// instance's running process
+	GetLogLevel(ctx context.Context, id string) (string, error)
+	SetLogLevel(ctx context.Context, id, level string) (string, error)
+
+	// RestartInstance stops an instance's process, letting it drain, and
+	// starts it again without waiting for a restart backoff
+	RestartInstance(ctx context.Context, id string) error
+
+	// RunProbe runs a named probe of an instance's running process, reusing
+	// recent results
+	RunProbe(ctx context.Context, id, name string) (*types.ProbeResult, error)
+
+	// RotateBackendCA replaces the CA for mutual TLS with instances, which
+	// switch to it when they restart
+	RotateBackendCA() error
+
+	// DryRunEvents applies events to the running instances subscribed to
+	// them and rolls back, returning each event's outcome
+	DryRunEvents(ctx context.Context, events []types.EventPublishData) ([]types.EventDryRunResult, error)
 
 	// Trigger a run of the reconciler ASAP
 	Refresh()
@@ -0,0 +1,100 @@
+package httpsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+	"github.com/tomyedwab/yesterday/nexushub/userdata"
+)
+
+// EnableUserData serves the user data endpoints:
+//
+//	GET  /users/export?userId=N  the archive of everything held about the user
+//	POST /users/forget?userId=N  forgets the user, see userdata.Service.Forget
+func (p *Proxy) EnableUserData(sessionManager *sessions.SessionManager, auditLogger *audit.Logger) {
+	p.userData = userdata.NewService(sessionManager, auditLogger, p.eventManager, p.userDataInstances, p.secrets.Current)
+}
+
+// userDataInstances returns every installed application instance, starting
+// the ones that are not running.
+func (p *Proxy) userDataInstances() ([]userdata.Instance, error) {
+	pkgs, err := p.packageManager.GetActivePackages()
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]userdata.Instance, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		instance, port, err := p.GetAppInstanceByID(pkg.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, userdata.Instance{
+			InstanceID: pkg.InstanceID,
+			Name:       pkg.Name,
+			BaseURL:    instance.BackendURL(port),
+		})
+	}
+	return instances, nil
+}
+
+// handleUserData serves the user data endpoints for an authorized request.
+// Users may export or forget only themselves; admins and internal requests
+// may name any user.
+func (p *Proxy) handleUserData(w http.ResponseWriter, r *http.Request, decision Decision) {
+	subject, err := strconv.Atoi(r.URL.Query().Get("userId"))
+	if err != nil || subject <= 0 {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid userId %q", r.URL.Query().Get("userId")), http.StatusBadRequest)
+		return
+	}
+	if !decision.Admin && decision.Token.UserID != subject {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("not allowed to access the data of user %d", subject), http.StatusForbidden)
+		return
+	}
+	actor := "internal"
+	if userID := decision.Token.UserID; userID != 0 {
+		actor = "user:" + strconv.Itoa(userID)
+	}
+
+	switch {
+	case r.URL.Path == "/users/export" && r.Method == http.MethodGet:
+		archive, err := p.userData.Export(r.Context(), subject, actor)
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d.json"`, subject))
+		httputils.HandleAPIResponse(w, r, archive, nil, http.StatusOK)
+
+	case r.URL.Path == "/users/forget" && r.Method == http.MethodPost:
+		// Access tokens and stored responses are the hub's own copies of
+		// the user's sessions and requests
+		revoked := access.RevokeUserTokens(subject)
+		if p.idempotencyStore != nil {
+			if _, err := p.idempotencyStore.DeleteForUser(subject); err != nil {
+				httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to delete stored responses: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		result, err := p.userData.Forget(r.Context(), subject, actor)
+		log.Printf("Forgot user %d for %s: revoked %d access tokens, %+v, %v", subject, actor, revoked, result, err)
+		if errors.Is(err, userdata.ErrIncomplete) {
+			// The result says which applications to retry
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+		httputils.HandleAPIResponse(w, r, result, err, http.StatusInternalServerError)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
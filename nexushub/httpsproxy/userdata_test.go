@@ -0,0 +1,32 @@
+package httpsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+)
+
+func TestUserDataOnlyForSelfOrAdmin(t *testing.T) {
+	p := &Proxy{}
+	for _, tc := range []struct {
+		name     string
+		decision Decision
+		want     int
+	}{
+		{"other user", Decision{Token: access.AccessToken{UserID: 2}}, http.StatusForbidden},
+		// Requests that pass the check reach the method switch
+		{"same user", Decision{Token: access.AccessToken{UserID: 7}}, http.StatusMethodNotAllowed},
+		{"admin", Decision{Token: access.AccessToken{UserID: adminUserID}, Admin: true}, http.StatusMethodNotAllowed},
+		{"internal", Decision{Internal: true, Admin: true}, http.StatusMethodNotAllowed},
+	} {
+		for _, path := range []string{"/users/export", "/users/forget"} {
+			recorder := httptest.NewRecorder()
+			p.handleUserData(recorder, httptest.NewRequest(http.MethodPut, path+"?userId=7", nil), tc.decision)
+			if recorder.Code != tc.want {
+				t.Errorf("%s %s: expected %d, got %d", tc.name, path, tc.want, recorder.Code)
+			}
+		}
+	}
+}
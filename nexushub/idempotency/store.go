@@ -0,0 +1,241 @@
+// Package idempotency remembers the responses to requests sent with an
+// Idempotency-Key header, so that a retried or replayed request gets the
+// original response instead of being executed again.
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultRetention is how long responses are kept when IDEMPOTENCY_RETENTION
+// is unset.
+const DefaultRetention = 24 * time.Hour
+
+// MaxStoredBody is the largest response body that is stored for replay.
+// Replays of larger responses get the original status with an empty body.
+const MaxStoredBody = 64 * 1024
+
+// lockTimeout is how long a key stays claimed by a request that has not
+// completed. After that the request is assumed lost (e.g. the hub restarted)
+// and the key may be claimed again.
+const lockTimeout = 5 * time.Minute
+
+var (
+	// ErrConflict is returned by Begin when the key was used for a request
+	// with a different fingerprint.
+	ErrConflict = errors.New("idempotency key reused with a different request")
+	// ErrInProgress is returned by Begin when a request with the key is
+	// still being executed.
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrTooLarge is returned by Complete when the response body is larger
+	// than MaxStoredBody. Nothing is stored; release the key instead.
+	ErrTooLarge = errors.New("response too large to store for replay")
+)
+
+// A status of 0 marks a key claimed by a request that has not completed.
+const idempotencySchema = `
+CREATE TABLE IF NOT EXISTS idempotency_keys_v1 (
+	idempotency_key TEXT NOT NULL,
+	path TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	fingerprint TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	content_type TEXT NOT NULL,
+	body BLOB,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (idempotency_key, path, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_v1_created_at ON idempotency_keys_v1(created_at);
+`
+
+const releaseStaleKeySql = `
+DELETE FROM idempotency_keys_v1
+WHERE idempotency_key = $1 AND path = $2 AND user_id = $3
+	AND (created_at <= $4 OR (status = 0 AND created_at <= $5));
+`
+
+const claimKeySql = `
+INSERT INTO idempotency_keys_v1 (idempotency_key, path, user_id, fingerprint, status, content_type, created_at)
+VALUES ($1, $2, $3, $4, 0, '', $5)
+ON CONFLICT DO NOTHING;
+`
+
+const getKeySql = `
+SELECT fingerprint, status, content_type, body FROM idempotency_keys_v1
+WHERE idempotency_key = $1 AND path = $2 AND user_id = $3;
+`
+
+const completeKeySql = `
+UPDATE idempotency_keys_v1 SET status = $1, content_type = $2, body = $3
+WHERE idempotency_key = $4 AND path = $5 AND user_id = $6 AND status = 0;
+`
+
+const releaseKeySql = `
+DELETE FROM idempotency_keys_v1
+WHERE idempotency_key = $1 AND path = $2 AND user_id = $3 AND status = 0;
+`
+
+const deleteExpiredKeysSql = `
+DELETE FROM idempotency_keys_v1 WHERE created_at <= $1;
+`
+
+// now is the store's clock, replaced in tests
+var now = time.Now
+
+// Key identifies a request: the same key may be reused on different paths or
+// by different users without conflict.
+type Key struct {
+	Key    string
+	Path   string
+	UserID int
+}
+
+// Response is a stored response.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps responses in the hub's sessions database.
+type Store struct {
+	db        *sqlx.DB
+	retention time.Duration
+}
+
+// NewStore creates the idempotency table if needed. Responses are kept for
+// the retention period.
+func NewStore(db *sqlx.DB, retention time.Duration) (*Store, error) {
+	if _, err := db.Exec(idempotencySchema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db, retention: retention}, nil
+}
+
+// RetentionFromEnv reads the retention period from the IDEMPOTENCY_RETENTION
+// environment variable. "off" disables idempotency keys and returns 0.
+func RetentionFromEnv() (time.Duration, error) {
+	value := os.Getenv("IDEMPOTENCY_RETENTION")
+	if value == "" {
+		return DefaultRetention, nil
+	}
+	if value == "off" {
+		return 0, nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return 0, fmt.Errorf("invalid IDEMPOTENCY_RETENTION %q: expected a positive duration or \"off\"", value)
+	}
+	return retention, nil
+}
+
+// Begin claims key for a request with the given fingerprint. It returns a nil
+// response if the caller should execute the request and then call Complete
+// or Release, or the stored response if the request was already executed.
+// It returns ErrConflict if the key was used with a different fingerprint and
+// ErrInProgress if the original request has not completed yet.
+func (s *Store) Begin(key Key, fingerprint string) (*Response, error) {
+	t := now()
+	_, err := s.db.Exec(releaseStaleKeySql, key.Key, key.Path, key.UserID,
+		t.Add(-s.retention).Unix(), t.Add(-lockTimeout).Unix())
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.db.Exec(claimKeySql, key.Key, key.Path, key.UserID, fingerprint, t.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if claimed, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if claimed == 1 {
+		return nil, nil
+	}
+
+	var row struct {
+		Fingerprint string `db:"fingerprint"`
+		Status      int    `db:"status"`
+		ContentType string `db:"content_type"`
+		Body        []byte `db:"body"`
+	}
+	err = s.db.Get(&row, getKeySql, key.Key, key.Path, key.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the insert and the select; let the client retry
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if row.Fingerprint != fingerprint {
+		return nil, ErrConflict
+	}
+	if row.Status == 0 {
+		return nil, ErrInProgress
+	}
+	return &Response{Status: row.Status, ContentType: row.ContentType, Body: row.Body}, nil
+}
+
+// Complete stores the response to a request claimed with Begin. Responses
+// whose body is larger than MaxStoredBody cannot be replayed, and Complete
+// returns ErrTooLarge without storing them.
+func (s *Store) Complete(key Key, response Response) error {
+	body := response.Body
+	if len(body) > MaxStoredBody {
+		return ErrTooLarge
+	} else if body == nil {
+		body = []byte{}
+	}
+	_, err := s.db.Exec(completeKeySql, response.Status, response.ContentType, body, key.Key, key.Path, key.UserID)
+	return err
+}
+
+// Release gives up the claim on a key whose request did not complete, so that
+// a retry executes it again.
+func (s *Store) Release(key Key) error {
+	_, err := s.db.Exec(releaseKeySql, key.Key, key.Path, key.UserID)
+	return err
+}
+
+// DeleteExpired removes the responses older than the retention period,
+// returning how many were removed.
+func (s *Store) DeleteExpired() (int64, error) {
+	result, err := s.db.Exec(deleteExpiredKeysSql, now().Add(-s.retention).Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteForUser removes the stored responses to a user's requests, e.g.
+// when the user is forgotten, returning how many were removed.
+func (s *Store) DeleteForUser(userID int) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM idempotency_keys_v1 WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RunCleanup deletes expired responses every interval until the context is
+// cancelled. It blocks, so callers should run it in a goroutine.
+func (s *Store) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(); err != nil {
+				slog.Error("Failed to delete expired idempotency keys", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package idempotency
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestStore(t *testing.T, retention time.Duration) *Store {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "sessions.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(db, retention)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	return store
+}
+
+// setClock replaces the store's clock for the duration of the test
+func setClock(t *testing.T, clock *time.Time) {
+	now = func() time.Time { return *clock }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestBeginReplaysCompletedResponse(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	key := Key{Key: "k1", Path: "/apps/install", UserID: 1}
+
+	if stored, err := store.Begin(key, "fp"); err != nil || stored != nil {
+		t.Fatalf("Expected to claim a new key, got %+v: %v", stored, err)
+	}
+	if _, err := store.Begin(key, "fp"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Expected ErrInProgress while the request runs, got %v", err)
+	}
+	if err := store.Complete(key, Response{Status: 201, ContentType: "application/json", Body: []byte(`{"id":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Begin(key, "fp")
+	if err != nil || stored == nil || stored.Status != 201 || stored.ContentType != "application/json" || string(stored.Body) != `{"id":1}` {
+		t.Errorf("Expected the stored response, got %+v: %v", stored, err)
+	}
+
+	// The same key on another path or for another user is independent
+	for _, other := range []Key{{Key: "k1", Path: "/events/publish", UserID: 1}, {Key: "k1", Path: "/apps/install", UserID: 2}} {
+		if stored, err := store.Begin(other, "other"); err != nil || stored != nil {
+			t.Errorf("Expected %+v to be claimed, got %+v: %v", other, stored, err)
+		}
+	}
+}
+
+func TestBeginConflict(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	key := Key{Key: "k1", Path: "/events/publish", UserID: 1}
+
+	store.Begin(key, "fp")
+	if _, err := store.Begin(key, "different"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict while in progress, got %v", err)
+	}
+	store.Complete(key, Response{Status: 200})
+	if _, err := store.Begin(key, "different"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict after completion, got %v", err)
+	}
+}
+
+func TestReleaseAllowsRetry(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	key := Key{Key: "k1", Path: "/apps/install", UserID: 1}
+
+	store.Begin(key, "fp")
+	if err := store.Release(key); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := store.Begin(key, "fp"); err != nil || stored != nil {
+		t.Errorf("Expected a released key to be claimed again, got %+v: %v", stored, err)
+	}
+}
+
+func TestCompleteRefusesLargeBodies(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	key := Key{Key: "k1", Path: "/apps/install", UserID: 1}
+
+	store.Begin(key, "fp")
+	err := store.Complete(key, Response{Status: 200, Body: make([]byte, MaxStoredBody+1)})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge, got %v", err)
+	}
+	if _, err := store.Begin(key, "fp"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Expected nothing to be stored, got %v", err)
+	}
+}
+
+func TestRetentionExpiry(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	setClock(t, &clock)
+	key := Key{Key: "k1", Path: "/events/publish", UserID: 1}
+	other := Key{Key: "k2", Path: "/events/publish", UserID: 1}
+
+	store.Begin(key, "fp")
+	store.Complete(key, Response{Status: 200})
+	clock = clock.Add(30 * time.Minute)
+	store.Begin(other, "fp")
+	store.Complete(other, Response{Status: 200})
+
+	// Within the retention period the key still conflicts
+	clock = clock.Add(20 * time.Minute)
+	if _, err := store.Begin(key, "different"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict within retention, got %v", err)
+	}
+
+	// Past it the key may be reused for a new request
+	clock = clock.Add(20 * time.Minute)
+	if stored, err := store.Begin(key, "different"); err != nil || stored != nil {
+		t.Errorf("Expected an expired key to be claimed again, got %+v: %v", stored, err)
+	}
+	store.Release(key)
+
+	// Cleanup removes only the expired response
+	if deleted, err := store.DeleteExpired(); err != nil || deleted != 0 {
+		t.Errorf("Expected nothing to delete yet, deleted %d: %v", deleted, err)
+	}
+	clock = clock.Add(time.Hour)
+	if deleted, err := store.DeleteExpired(); err != nil || deleted != 1 {
+		t.Errorf("Expected the second response to expire, deleted %d: %v", deleted, err)
+	}
+}
+
+func TestStaleClaimIsReleased(t *testing.T) {
+	store := setupTestStore(t, 24*time.Hour)
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	setClock(t, &clock)
+	key := Key{Key: "k1", Path: "/apps/install", UserID: 1}
+
+	// A request that never completes, e.g. because the hub restarted
+	store.Begin(key, "fp")
+	clock = clock.Add(lockTimeout + time.Second)
+	if stored, err := store.Begin(key, "fp"); err != nil || stored != nil {
+		t.Errorf("Expected an abandoned claim to be reclaimed, got %+v: %v", stored, err)
+	}
+}
+
+func TestRetentionFromEnv(t *testing.T) {
+	if retention, err := RetentionFromEnv(); err != nil || retention != DefaultRetention {
+		t.Errorf("Expected the default retention, got %v: %v", retention, err)
+	}
+	t.Setenv("IDEMPOTENCY_RETENTION", "2h")
+	if retention, err := RetentionFromEnv(); err != nil || retention != 2*time.Hour {
+		t.Errorf("Expected 2h, got %v: %v", retention, err)
+	}
+	t.Setenv("IDEMPOTENCY_RETENTION", "off")
+	if retention, err := RetentionFromEnv(); err != nil || retention != 0 {
+		t.Errorf("Expected idempotency keys to be disabled, got %v: %v", retention, err)
+	}
+	t.Setenv("IDEMPOTENCY_RETENTION", "-1h")
+	if _, err := RetentionFromEnv(); err == nil {
+		t.Error("Expected an error for a negative retention")
+	}
+}
@@ -0,0 +1,80 @@
+package applications
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+// DigestHeader carries the hex SHA-256 of the complete file being downloaded,
+// so clients can verify a download that was resumed with Range requests.
+const DigestHeader = "X-Content-Sha256"
+
+// HandlePackageDownload serves the package zip the instance was installed
+// from.
+func HandlePackageDownload(w http.ResponseWriter, r *http.Request, packageManager *packages.PackageManager, instanceID string) {
+	file, err := packageManager.PackageFile(instanceID)
+	if err != nil {
+		handleDownloadError(w, r, instanceID, err)
+		return
+	}
+	defer file.Close()
+	serveDownload(w, r, file, instanceID+".zip", "application/zip", audit.EventPackageDownload, instanceID)
+}
+
+// HandleDatabaseDownload serves a consistent snapshot of the instance's
+// database. Snapshots are reused for packages.SnapshotTTL, so a download
+// resumed within that time gets the same bytes.
+func HandleDatabaseDownload(w http.ResponseWriter, r *http.Request, packageManager *packages.PackageManager, instanceID string) {
+	file, err := packageManager.DatabaseSnapshot(instanceID)
+	if err != nil {
+		handleDownloadError(w, r, instanceID, err)
+		return
+	}
+	defer file.Close()
+	serveDownload(w, r, file, instanceID+".sqlite", "application/vnd.sqlite3", audit.EventDatabaseDownload, instanceID)
+}
+
+func handleDownloadError(w http.ResponseWriter, r *http.Request, instanceID string, err error) {
+	switch {
+	case errors.Is(err, packages.ErrPackageNotRetained):
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusNotFound)
+	case errors.Is(err, os.ErrNotExist):
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("instance %s not found", instanceID), http.StatusNotFound)
+	default:
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+	}
+}
+
+// serveDownload serves the file with support for Range and If-Range
+// requests, using the digest as its ETag, and records the download in the
+// audit log.
+func serveDownload(w http.ResponseWriter, r *http.Request, file *packages.DownloadFile, name, contentType string, eventType audit.EventType, instanceID string) {
+	info, err := file.Stat()
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+		return
+	}
+
+	if auditLogger, ok := r.Context().Value(audit.AuditLoggerKey).(*audit.Logger); ok {
+		var userID *int
+		if id, ok := httputils.RequestUserID(r); ok {
+			userID = &id
+		}
+		if err := auditLogger.LogDownload(eventType, userID, instanceID); err != nil {
+			log.Printf("Failed to log %s audit event: %v", eventType, err)
+		}
+	}
+
+	w.Header().Set(DigestHeader, file.Digest)
+	w.Header().Set("ETag", `"`+file.Digest+`"`)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
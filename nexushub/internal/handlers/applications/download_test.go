@@ -0,0 +1,117 @@
+package applications
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+// setupDownloads returns a package manager with instance "inst" installed
+// and given a database with some rows.
+func setupDownloads(t *testing.T) *packages.PackageManager {
+	installDir := t.TempDir()
+	pm, err := packages.OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatalf("OpenPackageManager returned error: %v", err)
+	}
+	t.Cleanup(func() { pm.DB.Close() })
+	if err := packages.PackageDBInsert(pm.DB, "inst", "hash", "app", "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	dbDir := filepath.Join(installDir, "inst", "db")
+	os.MkdirAll(dbDir, 0755)
+	db := sqlx.MustConnect("sqlite3", filepath.Join(dbDir, "app.sqlite"))
+	defer db.Close()
+	db.MustExec(`CREATE TABLE items (name TEXT)`)
+	for i := 0; i < 100; i++ {
+		db.MustExec(`INSERT INTO items (name) VALUES ('item')`)
+	}
+	return pm
+}
+
+func download(t *testing.T, pm *packages.PackageManager, ctx context.Context, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	HandleDatabaseDownload(rec, req, pm, "inst")
+	return rec
+}
+
+func TestDatabaseDownloadRanges(t *testing.T) {
+	pm := setupDownloads(t)
+	auditDB := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "audit.db"))
+	defer auditDB.Close()
+	auditLogger, err := audit.NewLogger(auditDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), audit.AuditLoggerKey, auditLogger)
+
+	header := http.Header{}
+	header.Set(httputils.UserIDHeader, "7")
+	full := download(t, pm, ctx, "/apps/inst/database", header)
+	if full.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", full.Code, full.Body)
+	}
+	body := full.Body.Bytes()
+	hash := sha256.Sum256(body)
+	digest := full.Header().Get(DigestHeader)
+	if digest != hex.EncodeToString(hash[:]) {
+		t.Errorf("Expected the digest header to match the body, got %s", digest)
+	}
+
+	// Resuming against the same snapshot returns the rest of the file
+	header.Set("Range", "bytes=100-")
+	header.Set("If-Range", full.Header().Get("ETag"))
+	partial := download(t, pm, ctx, "/apps/inst/database", header)
+	if partial.Code != http.StatusPartialContent || partial.Body.String() != string(body[100:]) {
+		t.Errorf("Expected 206 with the rest of the file, got %d with %d bytes", partial.Code, partial.Body.Len())
+	}
+
+	// A stale ETag gets the whole current file
+	header.Set("If-Range", `"stale"`)
+	restart := download(t, pm, ctx, "/apps/inst/database", header)
+	if restart.Code != http.StatusOK || restart.Body.Len() != len(body) {
+		t.Errorf("Expected 200 with the full file for a stale ETag, got %d with %d bytes", restart.Code, restart.Body.Len())
+	}
+
+	events, err := auditLogger.GetEventsByType(audit.EventDatabaseDownload, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].UserID == nil || *events[0].UserID != 7 || events[0].AccessTokenFingerprint != "inst" {
+		t.Errorf("Expected each download to be audited with the user, got %+v", events)
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	pm := setupDownloads(t)
+
+	rec := httptest.NewRecorder()
+	HandleDatabaseDownload(rec, httptest.NewRequest(http.MethodGet, "/apps/missing/database", nil), pm, "missing")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown instance, got %d", rec.Code)
+	}
+
+	// The instance was not installed through InstallPackage, so its package
+	// was not retained
+	rec = httptest.NewRecorder()
+	HandlePackageDownload(rec, httptest.NewRequest(http.MethodGet, "/apps/inst/package", nil), pm, "inst")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a package that was not retained, got %d", rec.Code)
+	}
+}
@@ -1,14 +1,10 @@
 package applications
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/tomyedwab/yesterday/applib/httputils"
@@ -71,12 +67,7 @@ func HandleInstall(w http.ResponseWriter, r *http.Request, packageManager *packa
 
 	// TODO(tom) STOPSHIP: Validate the hash?
 
-	// Create a 6-byte sequence from the current timestamp and two random bytes
-	// Then base64-encode the sequence to derive a new instance ID
-	seq := make([]byte, 6)
-	binary.BigEndian.PutUint32(seq[:4], uint32(time.Now().Unix()))
-	rand.Read(seq[4:])
-	instanceID := base64.URLEncoding.EncodeToString(seq)
+	instanceID := packages.NewInstanceID()
 
 	err = packageManager.InstallPackage(packageName, hash, instanceID, processManager)
 	if err != nil {
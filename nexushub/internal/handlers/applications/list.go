@@ -0,0 +1,53 @@
+package applications
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
+)
+
+// ApplicationInfo describes an installed application instance.
+type ApplicationInfo struct {
+	InstanceID  string    `json:"instanceId"`
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	PackageHash string    `json:"packageHash"`
+	ActiveUntil time.Time `json:"activeUntil"`
+	// Warm is set for instances kept running without traffic
+	Warm bool `json:"warm,omitempty"`
+	// Usage is the instance's quota usage, if quotas are enabled and it has
+	// been measured
+	Usage *quotas.Usage `json:"usage,omitempty"`
+}
+
+// HandleList returns the active application instances.
+func HandleList(w http.ResponseWriter, r *http.Request, packageManager *packages.PackageManager, quotaCollector *quotas.Collector) {
+	pkgs, err := packageManager.GetActivePackages()
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to list packages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	applications := make([]ApplicationInfo, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		info := ApplicationInfo{
+			InstanceID:  pkg.InstanceID,
+			Name:        pkg.Name,
+			Version:     pkg.Version,
+			PackageHash: pkg.PackageHash,
+			ActiveUntil: pkg.ActiveTtl,
+			Warm:        packageManager.KeptWarm(pkg),
+		}
+		if usage, ok := quotaCollector.Usage(pkg.InstanceID); ok {
+			info.Usage = &usage
+		}
+		applications = append(applications, info)
+	}
+	httputils.HandleAPIResponse(w, r, map[string]any{
+		"applications": applications,
+	}, nil, http.StatusOK)
+}
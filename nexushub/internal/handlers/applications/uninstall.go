@@ -0,0 +1,51 @@
+package applications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+// AdminInstanceID is the instance ID of the Admin app, which can never be
+// uninstalled.
+const AdminInstanceID = "MBtskI6D"
+
+// HandleUninstall removes the application instance named in the request body.
+func HandleUninstall(w http.ResponseWriter, r *http.Request, packageManager *packages.PackageManager, processManager httpsproxy_types.ProcessManagerInterface) {
+	var request struct {
+		InstanceID string `json:"instanceId"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.InstanceID == "" {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("missing instanceId"), http.StatusBadRequest)
+		return
+	}
+	if request.InstanceID == AdminInstanceID {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("the admin application cannot be uninstalled"), http.StatusForbidden)
+		return
+	}
+
+	pkg, err := packageManager.GetPackageByInstanceID(request.InstanceID)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to look up package info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if pkg == nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("application %s not found", request.InstanceID), http.StatusNotFound)
+		return
+	}
+
+	err = packageManager.UninstallPackage(request.InstanceID, processManager)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to uninstall package: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	httputils.HandleAPIResponse(w, r, map[string]string{
+		"instanceId": request.InstanceID,
+	}, nil, http.StatusOK)
+}
@@ -0,0 +1,92 @@
+package crashes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/crashes"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// HandleReport records a crash report posted by the application named in the
+// X-Application-Id header.
+func HandleReport(w http.ResponseWriter, r *http.Request, store *crashes.Store) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	instanceID := r.Header.Get("X-Application-Id")
+	if instanceID == "" {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("missing X-Application-Id header"), http.StatusBadRequest)
+		return
+	}
+
+	var report crashes.Report
+	err := json.NewDecoder(r.Body).Decode(&report)
+	if err != nil || report.Stack == "" {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid crash report"), http.StatusBadRequest)
+		return
+	}
+	err = store.Record(instanceID, report)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to record crash report: %v", err), http.StatusInternalServerError)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, map[string]string{
+		"stackHash": crashes.StackHash(report.Stack),
+	}, nil, http.StatusOK)
+}
+
+// HandleList returns a page of an instance's crashes, most recent first, for
+// GET /apps/crashes?instance=X&limit=N&offset=M. Without an instance it
+// returns the number of reports received from each instance.
+func HandleList(w http.ResponseWriter, r *http.Request, store *crashes.Store) {
+	query := r.URL.Query()
+	instanceID := query.Get("instance")
+	if instanceID == "" {
+		counts, err := store.Counts()
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to count crashes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		httputils.HandleAPIResponse(w, r, map[string]any{
+			"counts": counts,
+		}, nil, http.StatusOK)
+		return
+	}
+
+	limit, err := intParam(query.Get("limit"), defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+		return
+	}
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid offset"), http.StatusBadRequest)
+		return
+	}
+
+	list, total, err := store.List(instanceID, limit, offset)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to list crashes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, map[string]any{
+		"crashes": list,
+		"total":   total,
+	}, nil, http.StatusOK)
+}
+
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -21,7 +21,12 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/tomyedwab/yesterday/nexushub/chunkstore"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
 	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
 )
 
 // DebugApplicationRequest represents the request payload for creating debug applications
@@ -43,7 +48,8 @@ type DebugApplication struct {
 	StaticServiceURL string `json:"staticServiceUrl,omitempty"`
 	Status           string `json:"status"`
 	CreatedAt        string `json:"createdAt"`
-	PackagePath      string `json:"-"` // Path to uploaded package (not exposed via JSON)
+	CloneOf          string `json:"cloneOf,omitempty"` // Instance the application is a clone of, if any
+	PackagePath      string `json:"-"`                 // Path to uploaded package (not exposed via JSON)
 }
 
 // UploadChunk represents a single chunk of an uploaded file
@@ -82,15 +88,20 @@ type DebugHandler struct {
 	logger           *slog.Logger
 	debugApps        map[string]*DebugApplication  // In-memory storage for debug apps
 	uploadSessions   map[string]*UploadSession     // In-memory storage for upload sessions
+	manifests        map[string]*ChunkManifest     // Manifests of deduplicated uploads in progress
 	cleanupCancels   map[string]context.CancelFunc // Cleanup timer cancellation functions
 	uploadDir        string                        // Directory for storing uploaded packages
-	internalSecret   string
-	logStreamer      *LogStreamer // Log streaming manager
-	mu               sync.RWMutex // Protects debugApps, uploadSessions, and cleanupCancels
+	secrets          *secrets.Store
+	logStreamer      *LogStreamer        // Log streaming manager
+	diskWatchdog     *diskspace.Watchdog // Optional, rejects writes when disk space runs low
+	clock            clock.Clock         // Times inactivity cleanup
+	chunkStore       *chunkstore.Store   // Optional, enables deduplicated uploads
+	quotas           *quotas.Collector   // Optional, limits the size of uploaded packages
+	mu               sync.RWMutex        // Protects debugApps, uploadSessions, manifests, and cleanupCancels
 }
 
 // NewDebugHandler creates a new debug handler instance
-func NewDebugHandler(processManager httpsproxy_types.ProcessManagerInterface, logger *slog.Logger, internalSecret string) *DebugHandler {
+func NewDebugHandler(processManager httpsproxy_types.ProcessManagerInterface, logger *slog.Logger, secretStore *secrets.Store) *DebugHandler {
 	// Create upload directory
 	uploadDir := filepath.Join(os.TempDir(), "nexushub-debug-uploads")
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -103,12 +114,37 @@ func NewDebugHandler(processManager httpsproxy_types.ProcessManagerInterface, lo
 		logger:         logger,
 		debugApps:      make(map[string]*DebugApplication),
 		uploadSessions: make(map[string]*UploadSession),
+		manifests:      make(map[string]*ChunkManifest),
 		cleanupCancels: make(map[string]context.CancelFunc),
 		uploadDir:      uploadDir,
-		internalSecret: internalSecret,
+		clock:          clock.Real,
+		secrets:        secretStore,
+		logStreamer:    NewLogStreamer(logger, LogStreamConfig{}),
 	}
 }
 
+// SetDiskWatchdog rejects upload chunks while disk space is low and new debug
+// applications while it is critical.
+func (h *DebugHandler) SetDiskWatchdog(watchdog *diskspace.Watchdog) {
+	h.diskWatchdog = watchdog
+}
+
+// SetQuotas rejects uploaded packages larger than the application's package
+// size limit.
+func (h *DebugHandler) SetQuotas(collector *quotas.Collector) {
+	h.quotas = collector
+}
+
+// SetClock replaces the clock that times inactivity cleanup, for tests.
+func (h *DebugHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// UploadDir returns the directory uploaded packages are stored in.
+func (h *DebugHandler) UploadDir() string {
+	return h.uploadDir
+}
+
 // HandleUpload handles POST /debug/application/{id}/upload for chunked file uploads
 func (h *DebugHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -130,6 +166,12 @@ func (h *DebugHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Uploads are the first writers to pause when the disk runs low
+	if h.diskWatchdog.Reject(w, diskspace.LevelLow) {
+		h.logger.Warn("Rejected upload chunk: disk space low", "id", appID)
+		return
+	}
+
 	// Check if debug application exists
 	h.mu.RLock()
 	debugApp, exists := h.debugApps[appID]
@@ -191,6 +233,17 @@ func (h *DebugHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Packages over the application's size limit are refused before they
+	// are assembled
+	if limit := h.quotas.PackageLimit(appID); limit > 0 && h.uploadedBytes(appID, chunkIndex)+int64(len(chunkData)) > limit {
+		h.logger.Warn("Rejected upload: package over its size limit", "id", appID, "limit", limit)
+		h.mu.Lock()
+		delete(h.uploadSessions, appID)
+		h.mu.Unlock()
+		http.Error(w, fmt.Sprintf("Package exceeds the size limit of %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
 	h.logger.Info("Received chunk upload",
 		"appId", appID, "chunkIndex", chunkIndex, "totalChunks", totalChunks,
 		"chunkSize", len(chunkData), "fileHash", fileHash)
@@ -232,6 +285,11 @@ func (h *DebugHandler) HandleCreateApplication(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if h.diskWatchdog.Reject(w, diskspace.LevelCritical) {
+		h.logger.Warn("Rejected debug application: disk space critical")
+		return
+	}
+
 	// Parse request body
 	var req DebugApplicationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -324,6 +382,7 @@ func (h *DebugHandler) HandleDeleteApplication(w http.ResponseWriter, r *http.Re
 	// Remove from storage
 	delete(h.debugApps, appID)
 	delete(h.uploadSessions, appID)
+	h.releaseChunks(appID)
 
 	h.logger.Info("Debug application deleted", "id", appID, "appId", debugApp.AppID)
 
@@ -352,6 +411,7 @@ func (h *DebugHandler) cleanupExistingApplication(appID string) error {
 			// Remove from storage
 			delete(h.debugApps, id)
 			delete(h.uploadSessions, id)
+			h.releaseChunks(id)
 		}
 	}
 	return nil
@@ -370,6 +430,39 @@ func (h *DebugHandler) stopDebugApplication(app *DebugApplication) error {
 	return nil
 }
 
+// AddClone tracks a clone of an installed instance as a running debug
+// application, so nexusdebug can monitor and deploy to it. The clone's process
+// is managed like any other instance's, so cleaning up the debug application
+// only stops tracking it.
+func (h *DebugHandler) AddClone(instanceID, cloneOf, displayName, hostName string) *DebugApplication {
+	app := &DebugApplication{
+		ID:          instanceID,
+		AppID:       instanceID,
+		DisplayName: displayName,
+		HostName:    hostName,
+		Status:      "running",
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		CloneOf:     cloneOf,
+	}
+	h.mu.Lock()
+	h.debugApps[instanceID] = app
+	h.mu.Unlock()
+	return app
+}
+
+// RemoveClone stops tracking a clone once it is removed.
+func (h *DebugHandler) RemoveClone(instanceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cancel, exists := h.cleanupCancels[instanceID]; exists {
+		cancel()
+		delete(h.cleanupCancels, instanceID)
+	}
+	delete(h.debugApps, instanceID)
+	delete(h.uploadSessions, instanceID)
+	h.releaseChunks(instanceID)
+}
+
 // GetDebugApplication retrieves a debug application by ID
 func (h *DebugHandler) GetDebugApplication(appID string) (*DebugApplication, bool) {
 	app, exists := h.debugApps[appID]
@@ -0,0 +1,335 @@
+// Package handlers implements the NexusHub debug API endpoints for deduplicated package uploads.
+//
+// Instead of uploading the whole package, the client splits it into chunks and sends the
+// manifest of their SHA-256 hashes. The hub answers with the chunks missing from its shared
+// chunk store, the client uploads only those, and the hub assembles and verifies the package.
+//
+// Reference: spec/nexushub.md - Task nexushub-debug-upload-dedup
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/chunkstore"
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
+)
+
+// maxManifestBody bounds the size of an upload manifest
+const maxManifestBody = 4 << 20
+
+// maxDedupChunk bounds the size of a single deduplicated chunk
+const maxDedupChunk = 32 << 20
+
+// ChunkManifest lists the chunks of a package in order
+type ChunkManifest struct {
+	Chunks   []string `json:"chunks"`   // SHA-256 hash of each chunk
+	FileHash string   `json:"fileHash"` // SHA-256 hash of the whole package
+}
+
+// ManifestResponse lists the chunks of a manifest the hub does not have
+type ManifestResponse struct {
+	Missing []string `json:"missing"`
+}
+
+// SetChunkStore enables deduplicated uploads backed by the chunk store.
+// Without one the manifest endpoint responds 404, and clients fall back to
+// uploading the whole package.
+func (h *DebugHandler) SetChunkStore(store *chunkstore.Store) {
+	h.chunkStore = store
+}
+
+// dedupAppID extracts the application ID from /debug/application/{id}/upload/{action}
+// and checks that the application exists and deduplication is enabled.
+func (h *DebugHandler) dedupAppID(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	if h.chunkStore == nil {
+		http.Error(w, "Deduplicated uploads are not supported", http.StatusNotFound)
+		return "", false
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/debug/application/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] != "upload" || parts[2] != action {
+		http.Error(w, "Invalid upload URL format", http.StatusBadRequest)
+		return "", false
+	}
+	appID := parts[0]
+
+	h.mu.RLock()
+	_, exists := h.debugApps[appID]
+	h.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Debug application not found", http.StatusNotFound)
+		return "", false
+	}
+	return appID, true
+}
+
+// HandleUploadManifest handles POST /debug/application/{id}/upload/manifest,
+// starting a deduplicated upload. It responds with the chunks the client
+// has to upload.
+func (h *DebugHandler) HandleUploadManifest(w http.ResponseWriter, r *http.Request) {
+	appID, ok := h.dedupAppID(w, r, "manifest")
+	if !ok {
+		return
+	}
+
+	var manifest ChunkManifest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxManifestBody)).Decode(&manifest); err != nil {
+		http.Error(w, "Invalid manifest", http.StatusBadRequest)
+		return
+	}
+	if !chunkstore.ValidHash(manifest.FileHash) {
+		http.Error(w, "Invalid file hash", http.StatusBadRequest)
+		return
+	}
+	for _, hash := range manifest.Chunks {
+		if !chunkstore.ValidHash(hash) {
+			http.Error(w, fmt.Sprintf("Invalid chunk hash %q", hash), http.StatusBadRequest)
+			return
+		}
+	}
+
+	missing := h.chunkStore.Missing(manifest.Chunks)
+
+	h.mu.Lock()
+	h.manifests[appID] = &manifest
+	h.uploadSessions[appID] = &UploadSession{
+		ApplicationID: appID,
+		TotalChunks:   len(manifest.Chunks),
+		Chunks:        make(map[int]*UploadChunk),
+		FileHash:      manifest.FileHash,
+		CreatedAt:     time.Now(),
+	}
+	h.mu.Unlock()
+
+	h.logger.Info("Received upload manifest",
+		"appId", appID, "chunks", len(manifest.Chunks), "missing", len(missing), "fileHash", manifest.FileHash)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ManifestResponse{Missing: missing})
+}
+
+// HandleUploadDedupChunk handles POST /debug/application/{id}/upload/chunk,
+// storing one chunk listed in the application's manifest. The multipart form
+// carries the chunk's hash in "hash" and its data in "chunk".
+func (h *DebugHandler) HandleUploadDedupChunk(w http.ResponseWriter, r *http.Request) {
+	appID, ok := h.dedupAppID(w, r, "chunk")
+	if !ok {
+		return
+	}
+
+	// Uploads are the first writers to pause when the disk runs low
+	if h.diskWatchdog.Reject(w, diskspace.LevelLow) {
+		h.logger.Warn("Rejected upload chunk: disk space low", "id", appID)
+		return
+	}
+
+	h.mu.RLock()
+	manifest := h.manifests[appID]
+	h.mu.RUnlock()
+	if manifest == nil {
+		http.Error(w, "No upload manifest for this application", http.StatusConflict)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxDedupChunk+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Failed to parse upload form", http.StatusBadRequest)
+		return
+	}
+	hash := r.FormValue("hash")
+	listed := false
+	for _, chunk := range manifest.Chunks {
+		if chunk == hash {
+			listed = true
+			break
+		}
+	}
+	if !listed {
+		http.Error(w, "Chunk is not listed in the upload manifest", http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("chunk")
+	if err != nil {
+		http.Error(w, "Failed to get chunk file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxDedupChunk+1))
+	if err != nil {
+		http.Error(w, "Failed to read chunk data", http.StatusInternalServerError)
+		return
+	}
+	if len(data) > maxDedupChunk {
+		http.Error(w, "Chunk too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := h.chunkStore.Put(hash, data); err != nil {
+		if errors.Is(err, chunkstore.ErrHashMismatch) {
+			http.Error(w, "Chunk data does not match its hash", http.StatusBadRequest)
+			return
+		}
+		h.logger.Error("Failed to store upload chunk", "appId", appID, "hash", hash, "error", err)
+		http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Debug("Stored upload chunk", "appId", appID, "hash", hash, "size", len(data))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "received",
+		"message": "Chunk received successfully",
+	})
+}
+
+// HandleUploadCommit handles POST /debug/application/{id}/upload/commit,
+// assembling the package from the chunks of the manifest and verifying its
+// hash. If chunks are still missing it responds 409 with their hashes.
+func (h *DebugHandler) HandleUploadCommit(w http.ResponseWriter, r *http.Request) {
+	appID, ok := h.dedupAppID(w, r, "commit")
+	if !ok {
+		return
+	}
+
+	h.mu.RLock()
+	manifest := h.manifests[appID]
+	h.mu.RUnlock()
+	if manifest == nil {
+		http.Error(w, "No upload manifest for this application", http.StatusConflict)
+		return
+	}
+
+	if missing := h.chunkStore.Missing(manifest.Chunks); len(missing) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ManifestResponse{Missing: missing})
+		return
+	}
+
+	packagePath, err := h.assembleFromChunks(appID, manifest)
+	if errors.Is(err, errPackageTooLarge) {
+		h.logger.Warn("Rejected upload: package over its size limit", "appId", appID, "error", err)
+		h.mu.Lock()
+		delete(h.manifests, appID)
+		delete(h.uploadSessions, appID)
+		h.mu.Unlock()
+		http.Error(w, "Package exceeds its size limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to assemble package from chunks", "appId", appID, "error", err)
+		http.Error(w, "Failed to assemble package", http.StatusInternalServerError)
+		return
+	}
+
+	// The application now references the chunks of its new package instead
+	// of the previous one
+	if err := h.chunkStore.SetRefs(appID, manifest.Chunks); err != nil {
+		h.logger.Warn("Failed to record package chunks", "appId", appID, "error", err)
+	}
+	h.collectChunks()
+
+	h.mu.Lock()
+	if debugApp, exists := h.debugApps[appID]; exists {
+		debugApp.PackagePath = packagePath
+	}
+	delete(h.manifests, appID)
+	if session, exists := h.uploadSessions[appID]; exists {
+		session.mu.Lock()
+		for i := range manifest.Chunks {
+			session.Chunks[i] = &UploadChunk{ChunkIndex: i, Received: true}
+		}
+		session.Completed = true
+		session.mu.Unlock()
+	}
+	h.mu.Unlock()
+
+	h.logger.Info("Package assembled from chunks",
+		"appId", appID, "packagePath", packagePath, "chunks", len(manifest.Chunks), "hash", manifest.FileHash)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "completed",
+		"message": "Package upload completed successfully",
+	})
+}
+
+// errPackageTooLarge is returned by assembleFromChunks for a package over the
+// application's size limit
+var errPackageTooLarge = errors.New("package too large")
+
+// assembleFromChunks writes the package to the upload directory and
+// verifies its hash and size
+func (h *DebugHandler) assembleFromChunks(appID string, manifest *ChunkManifest) (string, error) {
+	packagePath := filepath.Join(h.uploadDir, fmt.Sprintf("%s-package.zip", appID))
+	tmp, err := os.CreateTemp(h.uploadDir, appID+"-package-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	err = h.chunkStore.Assemble(manifest.Chunks, io.MultiWriter(tmp, hasher))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if limit := h.quotas.PackageLimit(appID); limit > 0 {
+		info, err := os.Stat(tmp.Name())
+		if err != nil {
+			return "", err
+		}
+		if info.Size() > limit {
+			return "", fmt.Errorf("%w: %d bytes, the limit is %d", errPackageTooLarge, info.Size(), limit)
+		}
+	}
+	if calculated := hex.EncodeToString(hasher.Sum(nil)); calculated != manifest.FileHash {
+		return "", fmt.Errorf("file hash verification failed: expected %s, got %s", manifest.FileHash, calculated)
+	}
+	if err := os.Rename(tmp.Name(), packagePath); err != nil {
+		return "", fmt.Errorf("failed to move package into place: %w", err)
+	}
+	return packagePath, nil
+}
+
+// releaseChunks drops the application's references to the chunks of its
+// package. The caller must hold h.mu.
+func (h *DebugHandler) releaseChunks(appID string) {
+	if h.chunkStore == nil {
+		return
+	}
+	delete(h.manifests, appID)
+	if err := h.chunkStore.Release(appID); err != nil {
+		h.logger.Warn("Failed to release package chunks", "appId", appID, "error", err)
+	}
+	h.collectChunks()
+}
+
+// collectChunks removes the chunks no application references anymore
+func (h *DebugHandler) collectChunks() {
+	removed, err := h.chunkStore.GC()
+	if err != nil {
+		h.logger.Warn("Failed to collect unreferenced chunks", "error", err)
+	}
+	if removed > 0 {
+		h.logger.Info("Removed unreferenced chunks", "count", removed)
+	}
+}
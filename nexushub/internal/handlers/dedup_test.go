@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/chunkstore"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newDedupTestHandler(t *testing.T) (*DebugHandler, *chunkstore.Store) {
+	store, err := chunkstore.Open(filepath.Join(t.TempDir(), "chunks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewDebugHandler(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), secrets.NewStore(time.Minute))
+	h.uploadDir = t.TempDir()
+	h.SetChunkStore(store)
+	h.debugApps["app"] = &DebugApplication{ID: "app", Status: "stopped"}
+	return h, store
+}
+
+// uploadDeduplicated uploads the chunks like the client does and returns the
+// hashes the hub asked for
+func uploadDeduplicated(t *testing.T, h *DebugHandler, chunks [][]byte) []string {
+	t.Helper()
+	var manifest ChunkManifest
+	var whole []byte
+	byHash := make(map[string][]byte)
+	for _, chunk := range chunks {
+		hash := sha256Hex(chunk)
+		manifest.Chunks = append(manifest.Chunks, hash)
+		byHash[hash] = chunk
+		whole = append(whole, chunk...)
+	}
+	manifest.FileHash = sha256Hex(whole)
+
+	body, _ := json.Marshal(manifest)
+	w := httptest.NewRecorder()
+	h.HandleUploadManifest(w, httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/manifest", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the manifest to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	var response ManifestResponse
+	json.NewDecoder(w.Body).Decode(&response)
+
+	for _, hash := range response.Missing {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		writer.WriteField("hash", hash)
+		part, _ := writer.CreateFormFile("chunk", "chunk")
+		part.Write(byHash[hash])
+		writer.Close()
+		r := httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/chunk", &form)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		h.HandleUploadDedupChunk(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected chunk %s to be stored, got %d: %s", hash, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleUploadCommit(w, httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/commit", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the upload to be committed, got %d: %s", w.Code, w.Body.String())
+	}
+	packageData, err := os.ReadFile(h.debugApps["app"].PackagePath)
+	if err != nil || !bytes.Equal(packageData, whole) {
+		t.Fatalf("Expected the assembled package to match, got %d bytes: %v", len(packageData), err)
+	}
+	return response.Missing
+}
+
+func TestDeduplicatedUpload(t *testing.T) {
+	h, store := newDedupTestHandler(t)
+	first := [][]byte{[]byte("vendored assets"), []byte("application code v1"), []byte("more assets")}
+	if missing := uploadDeduplicated(t, h, first); len(missing) != 3 {
+		t.Errorf("Expected every chunk of the first upload to be requested, got %d", len(missing))
+	}
+
+	second := [][]byte{first[0], []byte("application code v2"), first[2]}
+	missing := uploadDeduplicated(t, h, second)
+	if len(missing) != 1 || missing[0] != sha256Hex(second[1]) {
+		t.Errorf("Expected only the changed chunk to be requested, got %v", missing)
+	}
+	if store.RefCount(sha256Hex(first[1])) != 0 || store.RefCount(sha256Hex(second[1])) != 1 {
+		t.Error("Expected the application to reference only the chunks of its new package")
+	}
+
+	// Deleting the application releases its chunks
+	w := httptest.NewRecorder()
+	h.HandleDeleteApplication(w, httptest.NewRequest(http.MethodDelete, "/debug/application/app", nil))
+	if store.RefCount(sha256Hex(first[0])) != 0 {
+		t.Error("Expected the deleted application's chunks to be released")
+	}
+}
+
+func TestDeduplicatedUploadRejectsBadChunks(t *testing.T) {
+	h, _ := newDedupTestHandler(t)
+	chunk := []byte("chunk")
+	body, _ := json.Marshal(ChunkManifest{Chunks: []string{sha256Hex(chunk)}, FileHash: sha256Hex(chunk)})
+	h.HandleUploadManifest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/manifest", bytes.NewReader(body)))
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("hash", sha256Hex(chunk))
+	part, _ := writer.CreateFormFile("chunk", "chunk")
+	part.Write([]byte("tampered"))
+	writer.Close()
+	r := httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/chunk", &form)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.HandleUploadDedupChunk(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a chunk that does not match its hash to be rejected, got %d", w.Code)
+	}
+
+	// Committing before every chunk arrived lists the missing ones
+	w = httptest.NewRecorder()
+	h.HandleUploadCommit(w, httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/commit", nil))
+	var response ManifestResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if w.Code != http.StatusConflict || len(response.Missing) != 1 {
+		t.Errorf("Expected 409 with the missing chunk, got %d: %v", w.Code, response.Missing)
+	}
+}
+
+func TestDeduplicationDisabled(t *testing.T) {
+	h := NewDebugHandler(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), secrets.NewStore(time.Minute))
+	h.debugApps["app"] = &DebugApplication{ID: "app"}
+	w := httptest.NewRecorder()
+	h.HandleUploadManifest(w, httptest.NewRequest(http.MethodPost, "/debug/application/app/upload/manifest", bytes.NewReader([]byte("{}"))))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a chunk store so clients fall back, got %d", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package desiredstate
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/desiredstate"
+)
+
+// HandleGet returns the desired state snapshot in effect at a point in time
+// for GET /apps/desired-state?at=T, where T is an RFC 3339 timestamp or Unix
+// seconds. Without at it returns the latest snapshot.
+func HandleGet(w http.ResponseWriter, r *http.Request, store *desiredstate.Store) {
+	var snapshot *desiredstate.Snapshot
+	var err error
+	if value := r.URL.Query().Get("at"); value != "" {
+		at, parseErr := parseTime(value)
+		if parseErr != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid at %q: expected an RFC 3339 timestamp or Unix seconds", value), http.StatusBadRequest)
+			return
+		}
+		snapshot, err = store.At(at)
+	} else {
+		snapshot, err = store.Latest()
+	}
+	if errors.Is(err, desiredstate.ErrNoSnapshot) {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to get desired state: %v", err), http.StatusInternalServerError)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, snapshot, nil, http.StatusOK)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// HandleEventLog handles GET /debug/application/{id}/events/export and
+// POST /debug/application/{id}/events/import[?force=true] by forwarding them
+// to the instance's internal event log endpoints, which only accept the
+// internal secret. Like uploads, imports are sent as multipart form data,
+// with the export in the "events" part.
+func (h *DebugHandler) HandleEventLog(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/debug/application/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[1] != "events" {
+		http.Error(w, "Invalid event log URL format", http.StatusBadRequest)
+		return
+	}
+	appID, action := parts[0], parts[2]
+
+	switch {
+	case action == "export" && r.Method == http.MethodGet:
+	case action == "import" && r.Method == http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.RLock()
+	_, exists := h.debugApps[appID]
+	h.mu.RUnlock()
+	if !exists {
+		http.Error(w, "Debug application not found", http.StatusNotFound)
+		return
+	}
+
+	instance, port, err := h.processManager.GetAppInstanceByID(appID)
+	if err != nil {
+		http.Error(w, "Debug application is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	var body io.Reader
+	if action == "import" {
+		body, err = eventsPart(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	target := instance.BackendURL(port) + "/internal/events/" + action
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, body)
+	if err != nil {
+		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+h.secrets.Current())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	resp, err := processes.BackendClient.Do(req)
+	if err != nil {
+		h.logger.Error("Event log request failed", "id", appID, "action", action, "error", err)
+		http.Error(w, "Failed to reach debug application", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	h.logger.Info("Forwarded event log request", "id", appID, "action", action, "status", resp.StatusCode)
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// eventsPart returns the "events" part of a multipart import request.
+func eventsPart(r *http.Request) (io.Reader, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse multipart form: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing events part")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read form data: %v", err)
+		}
+		if part.FormName() == "events" {
+			return part, nil
+		}
+	}
+}
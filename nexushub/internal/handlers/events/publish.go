@@ -2,21 +2,24 @@ package events
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/eventauth"
 	"github.com/tomyedwab/yesterday/nexushub/events"
 	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
 	"github.com/tomyedwab/yesterday/nexushub/types"
 )
 
-func HandleEventPublish(w http.ResponseWriter, r *http.Request, eventManager *events.EventManager, processManager httpsproxy_types.ProcessManagerInterface) {
-	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Methods", "POST")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		return
-	}
+// HandleEventPublish publishes a single event, or a batch of them. Event
+// types the caller may not publish are refused, see
+// eventauth.Authorizer.RejectPublish, and events delivered to an instance at
+// a hard quota are rejected, see quotas.Collector.RejectPublish.
+func HandleEventPublish(w http.ResponseWriter, r *http.Request, caller eventauth.Caller, publishAuth *eventauth.Authorizer, eventManager *events.EventManager, processManager httpsproxy_types.ProcessManagerInterface, quotaCollector *quotas.Collector) {
 	if r.Method != "POST" {
 		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
 		return
@@ -28,19 +31,147 @@ func HandleEventPublish(w http.ResponseWriter, r *http.Request, eventManager *ev
 		return
 	}
 
+	if r.URL.Query().Get("dryRun") == "true" {
+		handleDryRun(w, r, buf, caller, publishAuth, processManager)
+		return
+	}
+
+	var batch struct {
+		Events []types.EventPublishData `json:"events"`
+	}
+	if err := json.Unmarshal(buf, &batch); err == nil && batch.Events != nil {
+		handleBatchPublish(w, r, batch.Events, r.URL.Query().Get("atomic") == "true", caller, publishAuth, eventManager, processManager, quotaCollector)
+		return
+	}
+
 	var publishData types.EventPublishData
 	if err := json.Unmarshal(buf, &publishData); err != nil {
 		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
 		return
 	}
 
+	if publishAuth.RejectPublish(w, caller, []string{publishData.Type}) {
+		return
+	}
+	if quotaCollector.RejectPublish(w, []string{publishData.Type}) {
+		return
+	}
 	newEventId, err := eventManager.PublishEvent(publishData.ClientID, publishData.Type, publishData.Data)
 	if err != nil {
 		httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
 		return
 	}
 
+	quotaCollector.Published([]string{publishData.Type})
 	processManager.EventPublished()
 
 	httputils.HandleAPIResponse(w, r, map[string]any{"status": "success", "id": newEventId, "clientId": publishData.ClientID}, err, http.StatusInternalServerError)
 }
+
+// handleBatchPublish publishes a batch of events ({"events": [...]}) in
+// order. With atomic set they are published as a group: given consecutive
+// IDs and stored, and later applied by each application, in a single
+// transaction, so that either all of them take effect or none do. Otherwise
+// each is published on its own, stopping at the first that fails. The batch
+// is refused as a whole if the caller may not publish any of its events.
+func handleBatchPublish(w http.ResponseWriter, r *http.Request, batch []types.EventPublishData, atomic bool, caller eventauth.Caller, publishAuth *eventauth.Authorizer, eventManager *events.EventManager, processManager httpsproxy_types.ProcessManagerInterface, quotaCollector *quotas.Collector) {
+	if len(batch) == 0 {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("no events in batch"), http.StatusBadRequest)
+		return
+	}
+	eventTypes := make([]string, len(batch))
+	for i, event := range batch {
+		if event.Type == "" {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("event %d has no type", i), http.StatusBadRequest)
+			return
+		}
+		eventTypes[i] = event.Type
+	}
+	if publishAuth.RejectPublish(w, caller, eventTypes) {
+		return
+	}
+	if quotaCollector.RejectPublish(w, eventTypes) {
+		return
+	}
+
+	var eventIds []int
+	if atomic {
+		if len(batch) > events.MaxEventGroupSize {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("an atomic batch can have at most %d events, got %d", events.MaxEventGroupSize, len(batch)), http.StatusBadRequest)
+			return
+		}
+		group := make([]events.NewEvent, len(batch))
+		for i, event := range batch {
+			group[i] = events.NewEvent{ClientID: event.ClientID, Type: event.Type, Data: event.Data}
+		}
+		var err error
+		eventIds, err = eventManager.PublishEventGroup(group)
+		var duplicate *events.DuplicateEventError
+		if errors.As(err, &duplicate) {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		for i, event := range batch {
+			eventId, err := eventManager.PublishEvent(event.ClientID, event.Type, event.Data)
+			if err != nil {
+				if len(eventIds) > 0 {
+					quotaCollector.Published(eventTypes[:i])
+					processManager.EventPublished()
+				}
+				httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("published %d of %d events, event %d failed: %w", i, len(batch), i, err), http.StatusInternalServerError)
+				return
+			}
+			eventIds = append(eventIds, eventId)
+		}
+	}
+
+	quotaCollector.Published(eventTypes)
+	processManager.EventPublished()
+
+	httputils.HandleAPIResponse(w, r, map[string]any{"status": "success", "ids": eventIds, "atomic": atomic}, nil, http.StatusOK)
+}
+
+// handleDryRun applies a single event, or a batch of them ({"events": [...]}),
+// to the running instances and reports the outcome of each without
+// publishing anything. Event types the caller may not publish are refused as
+// they would be when published.
+func handleDryRun(w http.ResponseWriter, r *http.Request, buf []byte, caller eventauth.Caller, publishAuth *eventauth.Authorizer, processManager httpsproxy_types.ProcessManagerInterface) {
+	var batch struct {
+		Events []types.EventPublishData `json:"events"`
+	}
+	if err := json.Unmarshal(buf, &batch); err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+		return
+	}
+	if batch.Events == nil {
+		var publishData types.EventPublishData
+		if err := json.Unmarshal(buf, &publishData); err != nil {
+			httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+			return
+		}
+		batch.Events = []types.EventPublishData{publishData}
+	}
+	eventTypes := make([]string, len(batch.Events))
+	for i, event := range batch.Events {
+		if event.Type == "" {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("event %d has no type", i), http.StatusBadRequest)
+			return
+		}
+		eventTypes[i] = event.Type
+	}
+	if publishAuth.RejectPublish(w, caller, eventTypes) {
+		return
+	}
+
+	results, err := processManager.DryRunEvents(r.Context(), batch.Events)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadGateway)
+		return
+	}
+	httputils.HandleAPIResponse(w, r, map[string]any{"dryRun": true, "results": results}, nil, http.StatusOK)
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+	"github.com/tomyedwab/yesterday/nexushub/packages"
+)
+
+// maxStatsDays bounds the days parameter of /events/stats
+const maxStatsDays = 366
+
+// HandleEventStats handles /events/stats[?days=N], returning statistics about
+// the event log with per-day counts for the last N days (default
+// events.DefaultStatsDays) and the events delivered to each active instance.
+func HandleEventStats(w http.ResponseWriter, r *http.Request, eventManager *events.EventManager, packageManager *packages.PackageManager) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := events.DefaultStatsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > maxStatsDays {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("days must be between 0 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	stats, err := eventManager.Stats(days)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to read event stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	pkgs, err := packageManager.GetActivePackages()
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to list packages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, pkg := range pkgs {
+		stats.AddInstance(pkg.InstanceID, pkg.Name, pkg.Subscriptions)
+	}
+	httputils.HandleAPIResponse(w, r, stats, nil, http.StatusOK)
+}
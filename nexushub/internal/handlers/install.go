@@ -18,6 +18,7 @@ import (
 
 	"github.com/tomyedwab/yesterday/nexushub/packages"
 	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
 )
 
 // InstallRequest represents the request payload for installing debug applications
@@ -40,9 +41,15 @@ type ApplicationStatus struct {
 	ProcessID     int                    `json:"processId,omitempty"`
 	Port          int                    `json:"port,omitempty"`
 	HealthCheck   string                 `json:"healthCheck,omitempty"`
+	EventErrors   int                    `json:"eventErrors,omitempty"`
 	Error         string                 `json:"error,omitempty"`
 	LastUpdated   string                 `json:"lastUpdated"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	// History is the instance's recent state transitions
+	History []processes.StateTransition `json:"history,omitempty"`
+	// Quota is the instance's quota usage, if quotas are enabled and it has
+	// been measured
+	Quota *quotas.Usage `json:"quota,omitempty"`
 }
 
 // HandleApplicationStatus handles GET /debug/application/{id}/status for application health monitoring
@@ -100,11 +107,27 @@ func (h *DebugHandler) HandleApplicationStatus(w http.ResponseWriter, r *http.Re
 			status.Port = port
 			status.HealthCheck = "healthy" // Process manager only returns running instances
 			status.ProcessID = 0           // ProcessID not available from this interface
+			if count, err := h.getEventErrorCount(r.Context(), appInstance.BackendURL(port)); err == nil {
+				status.EventErrors = count
+			} else {
+				h.logger.Warn("Failed to get event errors", "id", appID, "error", err)
+			}
+		} else if instanceStatus, ok := h.processManager.GetInstanceStatus(appID); ok && instanceStatus.Failure != "" {
+			// Failed for a known reason, e.g. its self-tests, and is being
+			// restarted. The failure is kept until it is next running
+			status.Status = "failed"
+			status.Error = instanceStatus.Failure
 		} else {
 			// Application should be running but not found in process manager
 			status.Status = "pending"
 		}
 	}
+	if instanceStatus, ok := h.processManager.GetInstanceStatus(appID); ok {
+		status.History = instanceStatus.History
+	}
+	if usage, ok := h.quotas.Usage(appID); ok {
+		status.Quota = &usage
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(status); err != nil {
@@ -113,6 +136,33 @@ func (h *DebugHandler) HandleApplicationStatus(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// getEventErrorCount asks the instance at backendURL how many events it has
+// failed to apply.
+func (h *DebugHandler) getEventErrorCount(ctx context.Context, backendURL string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, backendURL+"/internal/event-errors", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.secrets.Current())
+	resp, err := processes.BackendClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var response struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
 // preparePackageForInstallation copies the uploaded package to the expected location for package manager
 func (h *DebugHandler) preparePackageForInstallation(debugApp *DebugApplication, packageManager *packages.PackageManager) error {
 	// Get PKG_DIR from package manager (via environment)
@@ -232,7 +282,7 @@ func (h *DebugHandler) scheduleApplicationCleanup(appID string) {
 	go func() {
 		// Wait for 1 hour or cancellation
 		select {
-		case <-time.After(1 * time.Hour):
+		case <-h.clock.After(1 * time.Hour):
 			// Timer expired, proceed with cleanup
 			h.performApplicationCleanup(appID)
 		case <-ctx.Done():
@@ -257,8 +307,9 @@ func (h *DebugHandler) performApplicationCleanup(appID string) {
 		return
 	}
 
-	// Stop the application
-	if debugApp.Status == "running" {
+	// Stop the application. Clones keep running until they are removed or
+	// expire
+	if debugApp.Status == "running" && debugApp.CloneOf == "" {
 		h.stopDebugApplicationInstance(debugApp)
 	}
 
@@ -267,6 +318,7 @@ func (h *DebugHandler) performApplicationCleanup(appID string) {
 	delete(h.debugApps, appID)
 	delete(h.cleanupCancels, appID)
 	delete(h.uploadSessions, appID)
+	h.releaseChunks(appID)
 	h.mu.Unlock()
 
 	// Clean up uploaded package file
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+func waitForTimers(t *testing.T, fake *clock.Fake, count int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.Timers() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d timers, got %d", count, fake.Timers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCleanupTimerIgnoresClockSteps(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	h := NewDebugHandler(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), secrets.NewStore(time.Minute))
+	h.SetClock(fake)
+	h.debugApps["app"] = &DebugApplication{ID: "app", Status: "stopped"}
+
+	h.scheduleApplicationCleanup("app")
+	waitForTimers(t, fake, 1)
+
+	// The wall clock jumping a day ahead must not clean up the application
+	fake.Step(24 * time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	h.mu.RLock()
+	_, exists := h.debugApps["app"]
+	h.mu.RUnlock()
+	if !exists {
+		t.Fatal("Expected a clock step not to clean up the application")
+	}
+
+	fake.Advance(time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.RLock()
+		_, exists = h.debugApps["app"]
+		h.mu.RUnlock()
+		if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the application to be cleaned up after an hour")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
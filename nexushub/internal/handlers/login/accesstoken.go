@@ -12,6 +12,7 @@ import (
 	admin_types "github.com/tomyedwab/yesterday/apps/admin/types"
 	"github.com/tomyedwab/yesterday/nexushub/audit"
 	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
 	"github.com/tomyedwab/yesterday/nexushub/sessions"
 )
 
@@ -41,7 +42,7 @@ func HandleAccessToken(w http.ResponseWriter, r *http.Request, adminServiceHost
 		UserID: session.UserID,
 	})
 	var accessResponse admin_types.AccessResponse
-	resp, err := http.Post(adminServiceHost+"/internal/checkAccess", "application/json", io.NopCloser(bytes.NewReader([]byte(body))))
+	resp, err := processes.BackendClient.Post(adminServiceHost+"/internal/checkAccess", "application/json", io.NopCloser(bytes.NewReader([]byte(body))))
 	if err != nil {
 		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to make cross-service request: %v", err), http.StatusInternalServerError)
 		return
@@ -77,7 +78,11 @@ func HandleAccessToken(w http.ResponseWriter, r *http.Request, adminServiceHost
 		return
 	}
 
-	access.CreateAccessToken(response)
+	if accessResponse.MustChangePassword {
+		access.CreateRestrictedAccessToken(response, session.UserID)
+	} else {
+		access.CreateAccessToken(response, session.UserID)
+	}
 
 	// Log access token refresh
 	if err := auditLogger.LogAccessTokenRefresh(session.UserID, oldRefreshToken, response.RefreshToken, response.AccessToken); err != nil {
@@ -93,8 +98,9 @@ func HandleAccessToken(w http.ResponseWriter, r *http.Request, adminServiceHost
 	w.Header().Set("Set-Cookie", "YRT="+response.RefreshToken+"; Path=/; Domain="+targetDomain+"; HttpOnly; Secure; SameSite=None")
 	w.WriteHeader(http.StatusOK)
 
-	respJson, _ := json.Marshal(map[string]string{
+	respJson, _ := json.Marshal(map[string]any{
 		"access_token": response.AccessToken,
+		"must_change":  accessResponse.MustChangePassword,
 	})
 	w.Write(respJson)
 }
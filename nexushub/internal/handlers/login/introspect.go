@@ -0,0 +1,182 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+)
+
+const (
+	// DefaultIntrospectionRateLimit is how many introspection requests are
+	// answered per IntrospectionWindow; the rest get 429.
+	DefaultIntrospectionRateLimit = 600
+	// DefaultInactiveAlertThreshold is how many inactive results in one
+	// IntrospectionWindow are audited as suspicious.
+	DefaultInactiveAlertThreshold = 100
+	// IntrospectionWindow is the period rate limits and alerts are counted
+	// over.
+	IntrospectionWindow = time.Minute
+
+	// maxIntrospectionBody bounds the JSON request body read.
+	maxIntrospectionBody = 4096
+)
+
+// IntrospectionRequest is the JSON body of a /public/introspect request. The
+// token may also be sent as a form value, as in RFC 7662.
+type IntrospectionRequest struct {
+	Token string `json:"token"`
+}
+
+// IntrospectionResponse is the response to /public/introspect, modeled on
+// RFC 7662. Only Active is set for tokens that are not active.
+type IntrospectionResponse struct {
+	Active bool `json:"active"`
+	// UserID is the user the token was issued to
+	UserID int `json:"user_id,omitempty"`
+	// Expiry is when the token expires, in seconds since the epoch
+	Expiry int64 `json:"exp,omitempty"`
+	// Restricted tokens may only be used to change the user's expired
+	// password
+	Restricted bool   `json:"restricted,omitempty"`
+	TokenType  string `json:"token_type,omitempty"`
+}
+
+// Introspector answers token introspection requests from resource servers
+// outside the hub, which are handed access tokens by shared frontends. It
+// rate limits the requests and audits unusual numbers of inactive results.
+type Introspector struct {
+	rateLimit      int
+	inactiveAlert  int
+	clock          clock.Clock
+	mu             sync.Mutex
+	windowStart    time.Time
+	requests       int
+	inactive       int
+	inactiveLogged bool
+}
+
+// NewIntrospector returns an Introspector answering rateLimit requests and
+// auditing inactiveAlert inactive results per IntrospectionWindow.
+func NewIntrospector(rateLimit, inactiveAlert int, c clock.Clock) *Introspector {
+	return &Introspector{
+		rateLimit:     rateLimit,
+		inactiveAlert: inactiveAlert,
+		clock:         c,
+		windowStart:   c.Now(),
+	}
+}
+
+// admit counts a request, returning false and how long until requests are
+// answered again if the rate limit is exceeded.
+func (i *Introspector) admit() (bool, time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	elapsed := i.clock.Since(i.windowStart)
+	if elapsed >= IntrospectionWindow {
+		i.windowStart = i.clock.Now()
+		i.requests, i.inactive, i.inactiveLogged = 0, 0, false
+		elapsed = 0
+	}
+	if i.requests >= i.rateLimit {
+		return false, IntrospectionWindow - elapsed
+	}
+	i.requests++
+	return true, 0
+}
+
+// countInactive counts an inactive result and reports whether the alert
+// threshold was just reached, along with a summary for the audit log.
+func (i *Introspector) countInactive() (bool, string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.inactive++
+	if i.inactive < i.inactiveAlert || i.inactiveLogged {
+		return false, ""
+	}
+	i.inactiveLogged = true
+	return true, fmt.Sprintf("%d in %v", i.inactive, IntrospectionWindow)
+}
+
+// HandleIntrospect reports whether the presented access token is active,
+// using the same validation as the proxy. Tokens of users who no longer have
+// any session, e.g. after their data was forgotten, are reported inactive
+// and revoked.
+func (i *Introspector) HandleIntrospect(w http.ResponseWriter, r *http.Request) {
+	sessionManager := r.Context().Value(sessions.SessionManagerKey).(*sessions.SessionManager)
+	auditLogger := r.Context().Value(audit.AuditLoggerKey).(*audit.Logger)
+
+	if ok, retryAfter := i.admit(); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("too many introspection requests"), http.StatusTooManyRequests)
+		return
+	}
+
+	token, err := introspectionToken(r)
+	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, err, http.StatusBadRequest)
+		return
+	}
+
+	response := IntrospectionResponse{}
+	if accessToken, ok := access.LookupAccessToken(token, auditLogger); ok {
+		userSessions, err := sessionManager.GetSessionsForUser(accessToken.UserID)
+		if err != nil {
+			httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to check sessions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(userSessions) > 0 {
+			response = IntrospectionResponse{
+				Active:     true,
+				UserID:     accessToken.UserID,
+				Expiry:     accessToken.Expiry,
+				Restricted: accessToken.Restricted,
+				TokenType:  "Bearer",
+			}
+		} else {
+			access.RevokeToken(token, accessToken.UserID)
+		}
+	}
+
+	if !response.Active {
+		if alert, summary := i.countInactive(); alert {
+			if err := auditLogger.LogInactiveIntrospections(summary); err != nil {
+				fmt.Printf("Failed to log inactive introspections audit event: %v\n", err)
+			}
+		}
+	}
+
+	// Introspection results must not be reused by intermediaries
+	w.Header().Set("Cache-Control", "no-store")
+	httputils.HandleAPIResponse(w, r, response, nil, http.StatusOK)
+}
+
+// introspectionToken reads the token from a JSON or form request body.
+func introspectionToken(r *http.Request) (string, error) {
+	var token string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var request IntrospectionRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxIntrospectionBody)).Decode(&request); err != nil {
+			return "", fmt.Errorf("invalid request body: %v", err)
+		}
+		token = request.Token
+	} else {
+		token = r.PostFormValue("token")
+	}
+	if token == "" {
+		return "", fmt.Errorf("missing token")
+	}
+	return token, nil
+}
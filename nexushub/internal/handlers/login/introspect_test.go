@@ -0,0 +1,179 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func introspect(t *testing.T, i *Introspector, ctx context.Context, token string) (int, IntrospectionResponse) {
+	t.Helper()
+	body := url.Values{"token": {token}}.Encode()
+	r := httptest.NewRequest(http.MethodPost, "/public/introspect", strings.NewReader(body)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	i.HandleIntrospect(w, r)
+	var response IntrospectionResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Error("Expected introspection results not to be cached")
+		}
+	}
+	return w.Code, response
+}
+
+func TestIntrospectTokens(t *testing.T) {
+	ctx, sessionManager, _ := setupLogout(t)
+	i := NewIntrospector(100, 100, clock.Real)
+	laptop, laptopToken := loginSession(t, sessionManager, 1)
+	_, phoneToken := loginSession(t, sessionManager, 1)
+
+	status, response := introspect(t, i, ctx, laptopToken)
+	if status != http.StatusOK || !response.Active || response.UserID != 1 || response.Expiry == 0 || response.TokenType != "Bearer" {
+		t.Fatalf("Expected an active token for user 1, got %d %+v", status, response)
+	}
+
+	// Expired
+	expiredToken := "expired-token"
+	access.CreateAccessToken(&types.AccessTokenResponse{AccessToken: expiredToken, Expiry: time.Now().Add(-time.Hour).Unix()}, 1)
+	// Malformed or never issued
+	for _, token := range []string{expiredToken, "not-a-token", strings.Repeat("x", 1000)} {
+		if status, response := introspect(t, i, ctx, token); status != http.StatusOK || response != (IntrospectionResponse{}) {
+			t.Errorf("Expected %.20q to be inactive, got %d %+v", token, status, response)
+		}
+	}
+
+	// Revoked by logging out
+	w := httptest.NewRecorder()
+	HandleLogout(w, logoutRequest(ctx, "/public/logout", laptop.RefreshToken, laptopToken))
+	if status, response := introspect(t, i, ctx, laptopToken); status != http.StatusOK || response.Active {
+		t.Errorf("Expected a logged out token to be inactive, got %d %+v", status, response)
+	}
+
+	// Revoked because the user has no sessions left, though the token is
+	// still in the proxy's cache
+	if err := sessionManager.DeleteSessionsForUser(1); err != nil {
+		t.Fatal(err)
+	}
+	if status, response := introspect(t, i, ctx, phoneToken); status != http.StatusOK || response.Active {
+		t.Errorf("Expected the token of a user without sessions to be inactive, got %d %+v", status, response)
+	}
+	if access.ValidateAccessToken(phoneToken, nil) {
+		t.Error("Expected the token of a user without sessions to be revoked")
+	}
+}
+
+func TestIntrospectRestrictedToken(t *testing.T) {
+	ctx, sessionManager, _ := setupLogout(t)
+	session, err := sessionManager.CreateSession(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := sessionManager.CreateAccessToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access.CreateRestrictedAccessToken(response, 4)
+
+	status, result := introspect(t, NewIntrospector(100, 100, clock.Real), ctx, response.AccessToken)
+	if status != http.StatusOK || !result.Active || !result.Restricted {
+		t.Errorf("Expected an active restricted token, got %d %+v", status, result)
+	}
+}
+
+func TestIntrospectRequests(t *testing.T) {
+	ctx, sessionManager, _ := setupLogout(t)
+	i := NewIntrospector(100, 100, clock.Real)
+	_, token := loginSession(t, sessionManager, 5)
+
+	for _, tc := range []struct {
+		name, contentType, body string
+		status                  int
+	}{
+		{"json", "application/json", `{"token":"` + token + `"}`, http.StatusOK},
+		{"missing token", "application/x-www-form-urlencoded", "", http.StatusBadRequest},
+		{"empty json token", "application/json", `{"token":""}`, http.StatusBadRequest},
+		{"malformed json", "application/json", `{"token":`, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/public/introspect", strings.NewReader(tc.body)).WithContext(ctx)
+			r.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			i.HandleIntrospect(w, r)
+			if w.Code != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestIntrospectRateLimit(t *testing.T) {
+	ctx, sessionManager, _ := setupLogout(t)
+	fake := clock.NewFake(time.Now())
+	i := NewIntrospector(3, 100, fake)
+	_, token := loginSession(t, sessionManager, 6)
+
+	for n := 0; n < 3; n++ {
+		if status, _ := introspect(t, i, ctx, token); status != http.StatusOK {
+			t.Fatalf("Expected request %d to be answered, got %d", n, status)
+		}
+	}
+	fake.Advance(20 * time.Second)
+	r := httptest.NewRequest(http.MethodPost, "/public/introspect", strings.NewReader("token="+token)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	i.HandleIntrospect(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "40" {
+		t.Errorf("Expected 429 with Retry-After 40, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	fake.Advance(40 * time.Second)
+	if status, _ := introspect(t, i, ctx, token); status != http.StatusOK {
+		t.Errorf("Expected requests to be answered in the next window, got %d", status)
+	}
+}
+
+func TestIntrospectAuditsInactiveVolume(t *testing.T) {
+	ctx, _, auditLogger := setupLogout(t)
+	fake := clock.NewFake(time.Now())
+	i := NewIntrospector(100, 3, fake)
+
+	alerts := func() int {
+		events, err := auditLogger.GetEventsByType(audit.EventIntrospectionAlert, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(events)
+	}
+	for n := 0; n < 5; n++ {
+		introspect(t, i, ctx, "guessed-token")
+	}
+	if count := alerts(); count != 1 {
+		t.Errorf("Expected one alert per window, got %d", count)
+	}
+
+	fake.Advance(IntrospectionWindow)
+	for n := 0; n < 2; n++ {
+		introspect(t, i, ctx, "guessed-token")
+	}
+	if count := alerts(); count != 1 {
+		t.Errorf("Expected no alert below the threshold, got %d", count)
+	}
+	introspect(t, i, ctx, "guessed-token")
+	if count := alerts(); count != 2 {
+		t.Errorf("Expected an alert once the threshold is reached again, got %d", count)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/tomyedwab/yesterday/applib/httputils"
 	"github.com/tomyedwab/yesterday/apps/admin/types"
 	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
 	"github.com/tomyedwab/yesterday/nexushub/sessions"
 )
 
@@ -23,7 +24,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request, adminServiceHost string
 	// Make a service request to the admin service to verify the credentials
 	// before creating a new session.
 	var loginResponse types.AdminLoginResponse
-	resp, err := http.Post(adminServiceHost+"/internal/dologin", "application/json", io.NopCloser(bytes.NewReader([]byte(body))))
+	resp, err := processes.BackendClient.Post(adminServiceHost+"/internal/dologin", "application/json", io.NopCloser(bytes.NewReader([]byte(body))))
 	if err != nil {
 		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to make cross-service request: %v", err), http.StatusInternalServerError)
 		return
@@ -65,5 +66,12 @@ func HandleLogin(w http.ResponseWriter, r *http.Request, adminServiceHost string
 	}
 
 	w.Header().Set("Set-Cookie", "YRT="+session.RefreshToken+"; Path=/; Domain="+domain+"; HttpOnly; Secure; SameSite=None")
+	if loginResponse.MustChange {
+		// The session's access tokens are restricted to changing the
+		// password until it is changed
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"must_change":true}`))
+		return
+	}
 	w.Write([]byte("ok"))
 }
@@ -3,21 +3,27 @@ package login
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/tomyedwab/yesterday/applib/httputils"
 	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
 	"github.com/tomyedwab/yesterday/nexushub/sessions"
 )
 
-func HandleLogout(w http.ResponseWriter, r *http.Request) {
-	sessionManager := r.Context().Value(sessions.SessionManagerKey).(*sessions.SessionManager)
-	auditLogger := r.Context().Value(audit.AuditLoggerKey).(*audit.Logger)
-	var err error
+// LogoutAllResponse is the response to /public/logout_all
+type LogoutAllResponse struct {
+	// SessionsRevoked is the number of sessions that were ended
+	SessionsRevoked int `json:"sessions_revoked"`
+}
 
+// sessionFromRefreshToken looks up the session of the YRT cookie, writing
+// the error response if there is none.
+func sessionFromRefreshToken(w http.ResponseWriter, r *http.Request, sessionManager *sessions.SessionManager, auditLogger *audit.Logger) (*sessions.Session, string, bool) {
 	refreshToken, err := r.Cookie("YRT")
 	if err != nil {
 		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("missing refresh token"), http.StatusBadRequest)
-		return
+		return nil, "", false
 	}
 
 	session, err := sessionManager.GetSessionByRefreshToken(refreshToken.Value)
@@ -27,20 +33,72 @@ func HandleLogout(w http.ResponseWriter, r *http.Request) {
 			fmt.Printf("Failed to log invalid refresh token audit event: %v\n", auditErr)
 		}
 		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("invalid refresh token"), http.StatusBadRequest)
+		return nil, "", false
+	}
+	return session, refreshToken.Value, true
+}
+
+// HandleLogout ends the session of the presented refresh token. The user's
+// sessions on other devices are unaffected.
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	sessionManager := r.Context().Value(sessions.SessionManagerKey).(*sessions.SessionManager)
+	auditLogger := r.Context().Value(audit.AuditLoggerKey).(*audit.Logger)
+
+	session, refreshToken, ok := sessionFromRefreshToken(w, r, sessionManager, auditLogger)
+	if !ok {
 		return
 	}
 
 	// Log logout before deleting the session
-	if err := auditLogger.LogLogout(session.UserID, refreshToken.Value); err != nil {
+	if err := auditLogger.LogLogout(session.UserID, refreshToken); err != nil {
 		// Log the error but don't fail the request
 		fmt.Printf("Failed to log logout audit event: %v\n", err)
 	}
 
-	err = sessionManager.DeleteSessionsForUser(session.UserID)
+	if err := sessionManager.DeleteSession(session); err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to delete session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// The access token sent along with the request stops working now rather
+	// than at its expiry
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		access.RevokeToken(token, session.UserID)
+	}
+
+	httputils.HandleAPIResponse(w, r, nil, nil, http.StatusOK)
+}
+
+// HandleLogoutAll ends every session of the user the presented refresh
+// token belongs to, on all devices, e.g. after a suspected credential
+// compromise.
+func HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	sessionManager := r.Context().Value(sessions.SessionManagerKey).(*sessions.SessionManager)
+	auditLogger := r.Context().Value(audit.AuditLoggerKey).(*audit.Logger)
+
+	session, refreshToken, ok := sessionFromRefreshToken(w, r, sessionManager, auditLogger)
+	if !ok {
+		return
+	}
+
+	userSessions, err := sessionManager.GetSessionsForUser(session.UserID)
 	if err != nil {
+		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to list sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := auditLogger.LogLogoutAll(session.UserID, refreshToken); err != nil {
+		// Log the error but don't fail the request
+		fmt.Printf("Failed to log logout all audit event: %v\n", err)
+	}
+
+	if err := sessionManager.DeleteSessionsForUser(session.UserID); err != nil {
 		httputils.HandleAPIResponse(w, r, nil, fmt.Errorf("failed to delete sessions: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// Access tokens issued for the deleted sessions stop working now rather
+	// than at their expiry
+	revoked := access.RevokeUserTokens(session.UserID)
+	fmt.Printf("Logged out user %d everywhere: %d sessions, %d access tokens\n", session.UserID, len(userSessions), revoked)
 
-	httputils.HandleAPIResponse(w, r, nil, nil, http.StatusOK)
+	httputils.HandleAPIResponse(w, r, LogoutAllResponse{SessionsRevoked: len(userSessions)}, nil, http.StatusOK)
 }
@@ -0,0 +1,129 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/httpsproxy/access"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+)
+
+func setupLogout(t *testing.T) (context.Context, *sessions.SessionManager, *audit.Logger) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "sessions.db"))
+	t.Cleanup(func() { db.Close() })
+	sessionManager, err := sessions.NewManager(db, 15*time.Minute, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLogger, err := audit.NewLogger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), sessions.SessionManagerKey, sessionManager)
+	ctx = context.WithValue(ctx, audit.AuditLoggerKey, auditLogger)
+	return ctx, sessionManager, auditLogger
+}
+
+// loginSession creates a session for the user with an access token, as if
+// they had logged in on another device
+func loginSession(t *testing.T, sessionManager *sessions.SessionManager, userID int) (*sessions.Session, string) {
+	session, err := sessionManager.CreateSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := sessionManager.CreateAccessToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access.CreateAccessToken(response, userID)
+	return session, response.AccessToken
+}
+
+func logoutRequest(ctx context.Context, path, refreshToken, accessToken string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, nil).WithContext(ctx)
+	r.AddCookie(&http.Cookie{Name: "YRT", Value: refreshToken})
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return r
+}
+
+func TestLogoutEndsOnlyTheCurrentSession(t *testing.T) {
+	ctx, sessionManager, _ := setupLogout(t)
+	laptop, laptopToken := loginSession(t, sessionManager, 1)
+	phone, phoneToken := loginSession(t, sessionManager, 1)
+
+	w := httptest.NewRecorder()
+	HandleLogout(w, logoutRequest(ctx, "/public/logout", laptop.RefreshToken, laptopToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := sessionManager.GetSessionByRefreshToken(laptop.RefreshToken); err == nil {
+		t.Error("Expected the logged out session to be deleted")
+	}
+	if access.ValidateAccessToken(laptopToken, nil) {
+		t.Error("Expected the logged out session's access token to be revoked")
+	}
+	if _, err := sessionManager.GetSessionByRefreshToken(phone.RefreshToken); err != nil {
+		t.Errorf("Expected the other session to be kept: %v", err)
+	}
+	if !access.ValidateAccessToken(phoneToken, nil) {
+		t.Error("Expected the other session's access token to stay valid")
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	ctx, sessionManager, auditLogger := setupLogout(t)
+	laptop, laptopToken := loginSession(t, sessionManager, 2)
+	_, phoneToken := loginSession(t, sessionManager, 2)
+	other, otherToken := loginSession(t, sessionManager, 3)
+
+	w := httptest.NewRecorder()
+	HandleLogoutAll(w, logoutRequest(ctx, "/public/logout_all", laptop.RefreshToken, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var response LogoutAllResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	// Each access token rotated the refresh token, leaving the old session
+	// around for the reuse window
+	if response.SessionsRevoked != 4 {
+		t.Errorf("Expected 4 sessions revoked, got %d", response.SessionsRevoked)
+	}
+
+	if userSessions, _ := sessionManager.GetSessionsForUser(2); len(userSessions) != 0 {
+		t.Errorf("Expected every session of the user to be deleted, %d left", len(userSessions))
+	}
+	if access.ValidateAccessToken(laptopToken, nil) || access.ValidateAccessToken(phoneToken, nil) {
+		t.Error("Expected every access token of the user to be revoked")
+	}
+	if _, err := sessionManager.GetSessionByRefreshToken(other.RefreshToken); err != nil || !access.ValidateAccessToken(otherToken, nil) {
+		t.Error("Expected other users to stay logged in")
+	}
+
+	events, err := auditLogger.GetEventsByType(audit.EventLogoutAll, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UserID == nil || *events[0].UserID != 2 {
+		t.Errorf("Expected one logout_all audit event for user 2, got %+v", events)
+	}
+
+	// The refresh token no longer works for another attempt
+	w = httptest.NewRecorder()
+	HandleLogoutAll(w, logoutRequest(ctx, "/public/logout_all", laptop.RefreshToken, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a revoked refresh token, got %d", w.Code)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,19 +31,130 @@ type LogEntry struct {
 	PID       int    `json:"pid,omitempty"`
 }
 
+// Defaults for LogStreamConfig.
+const (
+	DefaultLogStreamMaxClients        = 8
+	DefaultLogStreamBufferSize        = 256
+	DefaultLogStreamKeepaliveInterval = 30 * time.Second
+)
+
+// recentLogEntries is how many of an application's latest log entries a new
+// log stream client is sent before new ones.
+const recentLogEntries = 50
+
+// LogStreamConfig limits log streaming. Zero fields take their defaults.
+type LogStreamConfig struct {
+	// MaxClients is how many clients may stream an application's logs at
+	// once; further clients are refused with 429 Too Many Requests.
+	MaxClients int
+	// BufferSize is how many entries are queued for a client that is
+	// reading slowly before the oldest are dropped.
+	BufferSize int
+	// KeepaliveInterval is how often a keepalive comment line is sent to
+	// detect disconnected clients.
+	KeepaliveInterval time.Duration
+}
+
+func (c LogStreamConfig) withDefaults() LogStreamConfig {
+	if c.MaxClients <= 0 {
+		c.MaxClients = DefaultLogStreamMaxClients
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = DefaultLogStreamBufferSize
+	}
+	if c.KeepaliveInterval <= 0 {
+		c.KeepaliveInterval = DefaultLogStreamKeepaliveInterval
+	}
+	return c
+}
+
+// LogStreamConfigFromEnv reads the log streaming limits from
+// LOG_STREAM_MAX_CLIENTS, LOG_STREAM_BUFFER_SIZE and LOG_STREAM_KEEPALIVE
+// (a duration such as "15s"). Unset variables take their defaults.
+func LogStreamConfigFromEnv() (LogStreamConfig, error) {
+	var config LogStreamConfig
+	for _, setting := range []struct {
+		name  string
+		value *int
+	}{
+		{"LOG_STREAM_MAX_CLIENTS", &config.MaxClients},
+		{"LOG_STREAM_BUFFER_SIZE", &config.BufferSize},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return LogStreamConfig{}, fmt.Errorf("invalid %s %q: expected a positive number", setting.name, value)
+		}
+		*setting.value = n
+	}
+	if value := os.Getenv("LOG_STREAM_KEEPALIVE"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return LogStreamConfig{}, fmt.Errorf("invalid LOG_STREAM_KEEPALIVE %q: expected a positive duration", value)
+		}
+		config.KeepaliveInterval = interval
+	}
+	return config.withDefaults(), nil
+}
+
 // LogStreamClient represents a connected log streaming client using Server-Sent Events
 type LogStreamClient struct {
 	writer        http.ResponseWriter
 	flusher       http.Flusher
-	send          chan LogEntry
-	done          chan struct{}
 	applicationID string
-	mu            sync.RWMutex
+
+	mu       sync.Mutex
+	queue    []LogEntry    // Entries waiting to be written, oldest first
+	capacity int           // Most entries queued before the oldest are dropped
+	dropped  int           // Entries dropped from the queue since it was last taken
+	wake     chan struct{} // Signalled when entries are queued
+}
+
+func newLogStreamClient(w http.ResponseWriter, flusher http.Flusher, appID string, capacity int) *LogStreamClient {
+	return &LogStreamClient{
+		writer:        w,
+		flusher:       flusher,
+		applicationID: appID,
+		capacity:      capacity,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues an entry to be written to the client, dropping the oldest
+// queued entry if the queue is full. It never blocks, so a slow client
+// cannot stall the process whose output is being logged.
+func (c *LogStreamClient) enqueue(entry LogEntry) {
+	c.mu.Lock()
+	if len(c.queue) >= c.capacity {
+		c.queue = c.queue[1:]
+		c.dropped++
+	}
+	c.queue = append(c.queue, entry)
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take empties the queue, returning its entries and how many were dropped
+// before them.
+func (c *LogStreamClient) take() ([]LogEntry, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, dropped := c.queue, c.dropped
+	c.queue, c.dropped = nil, 0
+	return entries, dropped
 }
 
 // LogStreamer manages log streaming for multiple applications
 type LogStreamer struct {
 	clients map[string]map[*LogStreamClient]bool // applicationID -> clients
+	config  LogStreamConfig
 	mu      sync.RWMutex
 	logger  interface {
 		Info(msg string, args ...interface{})
@@ -55,13 +168,20 @@ func NewLogStreamer(logger interface {
 	Info(msg string, args ...interface{})
 	Error(msg string, args ...interface{})
 	Debug(msg string, args ...interface{})
-}) *LogStreamer {
+}, config LogStreamConfig) *LogStreamer {
 	return &LogStreamer{
 		clients: make(map[string]map[*LogStreamClient]bool),
+		config:  config.withDefaults(),
 		logger:  logger,
 	}
 }
 
+// SetLogStreamConfig changes the limits of log streaming, see
+// LogStreamConfigFromEnv. It must be called before logs are streamed.
+func (h *DebugHandler) SetLogStreamConfig(config LogStreamConfig) {
+	h.logStreamer = NewLogStreamer(h.logger, config)
+}
+
 // HandleLogStream handles GET /debug/application/{id}/logs for real-time log streaming
 func (h *DebugHandler) HandleLogStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -107,20 +227,6 @@ func (h *DebugHandler) HandleLogStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Info("Starting log stream for debug application", "appId", appID, "port", port)
-
-	// Initialize log streamer if not already done
-	if h.logStreamer == nil {
-		h.logStreamer = NewLogStreamer(h.logger)
-	}
-
-	// Set up Server-Sent Events headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
-
 	// Check if the response writer supports flushing
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -129,36 +235,43 @@ func (h *DebugHandler) HandleLogStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create log stream client
-	client := &LogStreamClient{
-		writer:        w,
-		flusher:       flusher,
-		send:          make(chan LogEntry, 256), // Buffer for log entries
-		done:          make(chan struct{}),
-		applicationID: appID,
+	// Register the client, unless the application has too many already
+	client := newLogStreamClient(w, flusher, appID, h.logStreamer.config.BufferSize)
+	if !h.logStreamer.addClient(appID, client) {
+		http.Error(w, "Too many log stream clients", http.StatusTooManyRequests)
+		return
 	}
+	defer h.logStreamer.removeClient(appID, client)
 
-	// Register client with log streamer
-	h.logStreamer.addClient(appID, client)
+	h.logger.Info("Starting log stream for debug application", "appId", appID, "port", port)
 
-	// Start streaming logs from the ProcessManager
-	go h.logStreamer.streamProcessLogs(client, appID, h.processManager)
+	// Set up Server-Sent Events headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
 
-	// Handle client connection (this will block until the client disconnects)
-	h.logStreamer.handleClient(client, r.Context())
+	// Blocks until the client disconnects
+	h.logStreamer.stream(r.Context(), client, h.processManager)
 }
 
-// addClient registers a new client for log streaming
-func (ls *LogStreamer) addClient(appID string, client *LogStreamClient) {
+// addClient registers a new client for log streaming. It returns false,
+// registering nothing, if the application already has the most clients
+// allowed.
+func (ls *LogStreamer) addClient(appID string, client *LogStreamClient) bool {
 	ls.mu.Lock()
 	defer ls.mu.Unlock()
 
+	if len(ls.clients[appID]) >= ls.config.MaxClients {
+		ls.logger.Info("Log stream client refused", "appId", appID, "totalClients", len(ls.clients[appID]))
+		return false
+	}
 	if ls.clients[appID] == nil {
 		ls.clients[appID] = make(map[*LogStreamClient]bool)
 	}
 	ls.clients[appID][client] = true
 
 	ls.logger.Info("Log stream client connected", "appId", appID, "totalClients", len(ls.clients[appID]))
+	return true
 }
 
 // removeClient unregisters a client from log streaming
@@ -176,159 +289,101 @@ func (ls *LogStreamer) removeClient(appID string, client *LogStreamClient) {
 	ls.logger.Info("Log stream client disconnected", "appId", appID)
 }
 
-// streamProcessLogs streams logs from the ProcessManager to the client
-func (ls *LogStreamer) streamProcessLogs(client *LogStreamClient, appID string, processManager interface{}) {
-	defer func() {
-		ls.removeClient(client.applicationID, client)
-		close(client.send)
-		close(client.done)
-	}()
-
-	// Cast to ProcessManagerInterface
-	pm, ok := processManager.(httpsproxy_types.ProcessManagerInterface)
-	if !ok {
-		ls.logger.Error("ProcessManager does not support log streaming interface", "type", fmt.Sprintf("%T", processManager))
-		return
+func toLogEntry(entry processes.ProcessLogEntry) LogEntry {
+	return LogEntry{
+		ID:        entry.ID,
+		Timestamp: entry.Timestamp.Format(time.RFC3339),
+		Level:     entry.Level,
+		Source:    entry.Source,
+		Message:   entry.Message,
+		PID:       entry.PID,
 	}
+}
 
-	ls.logger.Info("Starting log stream for application", "appId", client.applicationID)
-
-	// Send initial connection message
-	select {
-	case client.send <- LogEntry{
-		ID:        0,
+// systemLogEntry is a message from the log stream itself rather than the
+// application. System entries have ID 0.
+func systemLogEntry(level, message string) LogEntry {
+	return LogEntry{
 		Timestamp: time.Now().Format(time.RFC3339),
-		Level:     "info",
+		Level:     level,
 		Source:    "system",
-		Message:   fmt.Sprintf("Log stream started for application %s", client.applicationID),
-	}:
-	case <-client.done:
-		return
+		Message:   message,
 	}
+}
 
-	// Get recent logs and send them first
-	recentLogs, err := pm.GetLatestProcessLogs(appID, 50) // Get last 50 log entries
-	if err != nil {
-		ls.logger.Error("Failed to get recent logs", "appId", appID, "error", err)
-	} else {
-		// Send recent logs
-		for _, logEntry := range recentLogs {
-			select {
-			case client.send <- LogEntry{
-				ID:        logEntry.ID,
-				Timestamp: logEntry.Timestamp.Format(time.RFC3339),
-				Level:     logEntry.Level,
-				Source:    logEntry.Source,
-				Message:   logEntry.Message,
-				PID:       logEntry.PID,
-			}:
-			case <-client.done:
-				return
-			}
+// stream sends the client a connection notice and the application's recent
+// log entries, then new entries as they are logged, until ctx is done or a
+// write fails. The log callback it registers is removed when it returns.
+func (ls *LogStreamer) stream(ctx context.Context, client *LogStreamClient, pm httpsproxy_types.ProcessManagerInterface) {
+	appID := client.applicationID
+
+	// Register for new entries before reading the recent ones, so that none
+	// are missed in between
+	handle := pm.AddLogCallback(func(instanceID string, logEntry processes.ProcessLogEntry) {
+		if instanceID == appID {
+			client.enqueue(toLogEntry(logEntry))
 		}
+	})
+	defer pm.RemoveLogCallback(handle)
+
+	if err := ls.writeLogEntry(client, systemLogEntry("info", fmt.Sprintf("Log stream started for application %s", appID))); err != nil {
+		return
 	}
 
-	// Get the latest log ID to poll from
-	lastID, err := pm.GetProcessLogLatestID(appID)
+	// Entries logged while the recent ones were read are queued as well
+	var skipThrough int64
+	recentLogs, err := pm.GetLatestProcessLogs(appID, recentLogEntries)
 	if err != nil {
-		ls.logger.Error("Failed to get latest log ID", "appId", appID, "error", err)
-		lastID = 0
+		ls.logger.Error("Failed to get recent logs", "appId", appID, "error", err)
 	}
-
-	// Set up a callback to receive new log entries
-	pm.AddLogCallback(func(instanceID string, logEntry processes.ProcessLogEntry) {
-		if instanceID != appID {
+	for _, logEntry := range recentLogs {
+		if err := ls.writeLogEntry(client, toLogEntry(logEntry)); err != nil {
 			return
 		}
+		skipThrough = logEntry.ID
+	}
 
-		// Send the new log entry to the client
-		select {
-		case client.send <- LogEntry{
-			ID:        logEntry.ID,
-			Timestamp: logEntry.Timestamp.Format(time.RFC3339),
-			Level:     logEntry.Level,
-			Source:    logEntry.Source,
-			Message:   logEntry.Message,
-			PID:       logEntry.PID,
-		}:
-		case <-client.done:
-			return
-		}
-	})
+	ls.handleClient(ctx, client, skipThrough)
+}
 
-	// Poll for new logs periodically as backup
-	ticker := time.NewTicker(2 * time.Second)
+// handleClient writes queued log entries to the client, and keepalive
+// comments while there are none, until ctx is done or a write fails. Queued
+// entries up to skipThrough, already sent by stream, are skipped; entries
+// dropped because the client was too slow are replaced by a notice saying
+// how many.
+func (ls *LogStreamer) handleClient(ctx context.Context, client *LogStreamClient, skipThrough int64) {
+	ticker := time.NewTicker(ls.config.KeepaliveInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			// Poll for new logs since the last ID
-			newLogs, err := pm.GetProcessLogs(appID, lastID)
-			if err != nil {
-				ls.logger.Error("Failed to poll for new logs", "appId", appID, "error", err)
-				continue
-			}
-
-			// Send new logs
-			for _, logEntry := range newLogs {
-				select {
-				case client.send <- LogEntry{
-					ID:        logEntry.ID,
-					Timestamp: logEntry.Timestamp.Format(time.RFC3339),
-					Level:     logEntry.Level,
-					Source:    logEntry.Source,
-					Message:   logEntry.Message,
-					PID:       logEntry.PID,
-				}:
-					lastID = logEntry.ID
-				case <-client.done:
+		case <-client.wake:
+			entries, dropped := client.take()
+			if dropped > 0 {
+				ls.logger.Debug("Dropped log entries for slow client", "appId", client.applicationID, "dropped", dropped)
+				notice := systemLogEntry("warn", fmt.Sprintf("%d log entries dropped", dropped))
+				if err := ls.writeLogEntry(client, notice); err != nil {
 					return
 				}
 			}
-
-		case <-client.done:
-			ls.logger.Info("Log stream stopped", "appId", client.applicationID)
-			return
-		}
-	}
-}
-
-// handleClient handles Server-Sent Events communication with a log streaming client
-func (ls *LogStreamer) handleClient(client *LogStreamClient, ctx context.Context) {
-	defer func() {
-		ls.removeClient(client.applicationID, client)
-	}()
-
-	// Send keepalive messages to detect client disconnection
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case logEntry, ok := <-client.send:
-			if !ok {
-				// Channel closed, client is done
-				return
-			}
-
-			// Convert log entry to JSON
-			logData, err := json.Marshal(logEntry)
-			if err != nil {
-				ls.logger.Error("Failed to marshal log entry to JSON", "error", err)
-				continue
-			}
-
-			// Send SSE event
-			if err := ls.writeSSEEvent(client, "log", string(logData)); err != nil {
-				ls.logger.Error("Failed to write SSE event", "error", err)
-				return
+			for _, logEntry := range entries {
+				if skipThrough > 0 {
+					if logEntry.ID <= skipThrough {
+						continue
+					}
+					skipThrough = 0
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if err := ls.writeLogEntry(client, logEntry); err != nil {
+					return
+				}
 			}
 
 		case <-ticker.C:
-			// Send keepalive event
-			if err := ls.writeSSEEvent(client, "keepalive", ""); err != nil {
-				ls.logger.Error("Failed to write keepalive event", "error", err)
+			if err := ls.writeSSE(client, ": keepalive\n\n"); err != nil {
+				ls.logger.Error("Failed to write keepalive", "error", err)
 				return
 			}
 
@@ -336,71 +391,48 @@ func (ls *LogStreamer) handleClient(client *LogStreamClient, ctx context.Context
 			// Client disconnected
 			ls.logger.Info("Client disconnected", "appId", client.applicationID)
 			return
-
-		case <-client.done:
-			return
 		}
 	}
 }
 
-// writeSSEEvent writes a Server-Sent Event to the client
-func (ls *LogStreamer) writeSSEEvent(client *LogStreamClient, eventType, data string) error {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-
-	// Write event type
-	if eventType != "" {
-		if _, err := fmt.Fprintf(client.writer, "event: %s\n", eventType); err != nil {
-			return err
-		}
+// writeLogEntry writes a log entry to the client as a "log" event.
+func (ls *LogStreamer) writeLogEntry(client *LogStreamClient, logEntry LogEntry) error {
+	logData, err := json.Marshal(logEntry)
+	if err != nil {
+		ls.logger.Error("Failed to marshal log entry to JSON", "error", err)
+		return nil
 	}
-
-	// Write data
-	if data != "" {
-		if _, err := fmt.Fprintf(client.writer, "data: %s\n", data); err != nil {
-			return err
-		}
+	if err := ls.writeSSE(client, "event: log\ndata: "+string(logData)+"\n\n"); err != nil {
+		ls.logger.Error("Failed to write SSE event", "error", err)
+		return err
 	}
+	return nil
+}
 
-	// Write empty line to complete the event
-	if _, err := fmt.Fprintf(client.writer, "\n"); err != nil {
+// writeSSE writes text, a complete Server-Sent Event, to the client and
+// flushes it.
+func (ls *LogStreamer) writeSSE(client *LogStreamClient, text string) error {
+	if _, err := client.writer.Write([]byte(text)); err != nil {
 		return err
 	}
-
-	// Flush the data
 	client.flusher.Flush()
 	return nil
 }
 
-// BroadcastLog sends a log entry to all clients streaming logs for the given application
+// BroadcastLog sends a log entry to all clients streaming logs for the given
+// application. Clients that are reading slowly lose their oldest queued
+// entries instead of holding up the others.
 func (ls *LogStreamer) BroadcastLog(appID string, logEntry LogEntry) {
 	ls.mu.RLock()
-	clients, exists := ls.clients[appID]
-	ls.mu.RUnlock()
+	defer ls.mu.RUnlock()
 
-	if !exists {
-		return
-	}
-
-	for client := range clients {
-		select {
-		case client.send <- logEntry:
-		default:
-			// Client's send channel is full, skip this log entry
-			ls.logger.Debug("Skipping log entry for slow client", "appId", appID)
-		}
+	for client := range ls.clients[appID] {
+		client.enqueue(logEntry)
 	}
 }
 
 // GetLogStatus returns the current log streaming status for an application
 func (h *DebugHandler) GetLogStatus(appID string) map[string]interface{} {
-	if h.logStreamer == nil {
-		return map[string]interface{}{
-			"streaming": false,
-			"clients":   0,
-		}
-	}
-
 	h.logStreamer.mu.RLock()
 	clients, exists := h.logStreamer.clients[appID]
 	clientCount := 0
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+// logStreamProcessManager records the log callbacks registered with it.
+type logStreamProcessManager struct {
+	httpsproxy_types.ProcessManagerInterface
+
+	mu        sync.Mutex
+	callbacks map[processes.LogCallbackHandle]processes.LogCallback
+	next      processes.LogCallbackHandle
+}
+
+func (pm *logStreamProcessManager) AddLogCallback(callback processes.LogCallback) processes.LogCallbackHandle {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.callbacks == nil {
+		pm.callbacks = make(map[processes.LogCallbackHandle]processes.LogCallback)
+	}
+	pm.next++
+	pm.callbacks[pm.next] = callback
+	return pm.next
+}
+
+func (pm *logStreamProcessManager) RemoveLogCallback(handle processes.LogCallbackHandle) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.callbacks, handle)
+}
+
+func (pm *logStreamProcessManager) GetLatestProcessLogs(instanceID string, count int) ([]processes.ProcessLogEntry, error) {
+	return nil, nil
+}
+
+func (pm *logStreamProcessManager) log(instanceID string, entry processes.ProcessLogEntry) {
+	pm.mu.Lock()
+	callbacks := make([]processes.LogCallback, 0, len(pm.callbacks))
+	for _, callback := range pm.callbacks {
+		callbacks = append(callbacks, callback)
+	}
+	pm.mu.Unlock()
+	for _, callback := range callbacks {
+		callback(instanceID, entry)
+	}
+}
+
+func (pm *logStreamProcessManager) callbackCount() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return len(pm.callbacks)
+}
+
+// gatedWriter is a streaming response writer whose writes block until gate
+// is closed.
+type gatedWriter struct {
+	header http.Header
+	gate   chan struct{}
+
+	mu   sync.Mutex
+	body bytes.Buffer
+}
+
+func (w *gatedWriter) Header() http.Header { return w.header }
+func (w *gatedWriter) WriteHeader(int)     {}
+func (w *gatedWriter) Flush()              {}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.Write(p)
+}
+
+func (w *gatedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.String()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLogStreamDropsOldestEntriesForSlowClient(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	streamer := NewLogStreamer(logger, LogStreamConfig{BufferSize: 4, KeepaliveInterval: time.Hour})
+	pm := &logStreamProcessManager{}
+	w := &gatedWriter{header: http.Header{}, gate: make(chan struct{})}
+	client := newLogStreamClient(w, w, "app", streamer.config.BufferSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		streamer.stream(ctx, client, pm)
+		close(done)
+	}()
+	waitFor(t, "the log callback", func() bool { return pm.callbackCount() == 1 })
+
+	// The client is stuck writing the connection notice, so logging must
+	// not block and only the newest entries are kept
+	for i := 1; i <= 10; i++ {
+		pm.log("app", processes.ProcessLogEntry{ID: int64(i), Timestamp: time.Now(), Level: "info", Message: fmt.Sprintf("message %d", i)})
+	}
+	pm.log("other", processes.ProcessLogEntry{ID: 1, Timestamp: time.Now(), Level: "info", Message: "other app"})
+	close(w.gate)
+
+	waitFor(t, "the newest entry", func() bool { return strings.Contains(w.String(), `"message 10"`) })
+	body := w.String()
+	if !strings.Contains(body, "6 log entries dropped") {
+		t.Errorf("Expected a notice of 6 dropped entries, got %q", body)
+	}
+	if strings.Contains(body, `"message 6"`) || !strings.Contains(body, `"message 7"`) {
+		t.Errorf("Expected only messages 7 to 10 to be sent, got %q", body)
+	}
+	if strings.Contains(body, "other app") {
+		t.Errorf("Expected another application's entries not to be sent, got %q", body)
+	}
+
+	cancel()
+	<-done
+	if count := pm.callbackCount(); count != 0 {
+		t.Errorf("Expected the log callback to be removed, got %d callbacks", count)
+	}
+}
+
+func TestLogStreamSendsKeepalives(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	streamer := NewLogStreamer(logger, LogStreamConfig{KeepaliveInterval: 5 * time.Millisecond})
+	w := &gatedWriter{header: http.Header{}, gate: make(chan struct{})}
+	close(w.gate)
+	client := newLogStreamClient(w, w, "app", streamer.config.BufferSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go streamer.stream(ctx, client, &logStreamProcessManager{})
+	waitFor(t, "a keepalive", func() bool { return strings.Contains(w.String(), ": keepalive\n\n") })
+}
+
+func TestLogStreamRefusesClientsOverLimit(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	streamer := NewLogStreamer(logger, LogStreamConfig{MaxClients: 2})
+	newClient := func(appID string) *LogStreamClient {
+		w := httptest.NewRecorder()
+		return newLogStreamClient(w, w, appID, streamer.config.BufferSize)
+	}
+
+	first := newClient("app")
+	if !streamer.addClient("app", first) || !streamer.addClient("app", newClient("app")) {
+		t.Fatal("Expected clients up to the limit to be added")
+	}
+	if streamer.addClient("app", newClient("app")) {
+		t.Error("Expected a client over the limit to be refused")
+	}
+	if !streamer.addClient("other", newClient("other")) {
+		t.Error("Expected the limit to apply per application")
+	}
+
+	streamer.removeClient("app", first)
+	if !streamer.addClient("app", newClient("app")) {
+		t.Error("Expected a client to be added after another disconnected")
+	}
+}
+
+func TestLogStreamConfigFromEnv(t *testing.T) {
+	config, err := LogStreamConfigFromEnv()
+	if err != nil || config != (LogStreamConfig{DefaultLogStreamMaxClients, DefaultLogStreamBufferSize, DefaultLogStreamKeepaliveInterval}) {
+		t.Errorf("Expected the defaults, got %+v, %v", config, err)
+	}
+
+	t.Setenv("LOG_STREAM_MAX_CLIENTS", "3")
+	t.Setenv("LOG_STREAM_BUFFER_SIZE", "1000")
+	t.Setenv("LOG_STREAM_KEEPALIVE", "15s")
+	config, err = LogStreamConfigFromEnv()
+	if err != nil || config != (LogStreamConfig{3, 1000, 15 * time.Second}) {
+		t.Errorf("Expected the configured limits, got %+v, %v", config, err)
+	}
+
+	t.Setenv("LOG_STREAM_BUFFER_SIZE", "0")
+	if _, err := LogStreamConfigFromEnv(); err == nil {
+		t.Error("Expected an error for a zero buffer size")
+	}
+	t.Setenv("LOG_STREAM_BUFFER_SIZE", "")
+	t.Setenv("LOG_STREAM_KEEPALIVE", "soon")
+	if _, err := LogStreamConfigFromEnv(); err == nil {
+		t.Error("Expected an error for an invalid keepalive interval")
+	}
+}
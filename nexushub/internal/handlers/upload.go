@@ -70,6 +70,26 @@ func (h *DebugHandler) processUploadChunk(appID string, chunkIndex, totalChunks
 	return nil
 }
 
+// uploadedBytes returns the size of the chunks of the application's upload
+// received so far, except the one at skipIndex, which is being replaced.
+func (h *DebugHandler) uploadedBytes(appID string, skipIndex int) int64 {
+	h.mu.RLock()
+	session, exists := h.uploadSessions[appID]
+	h.mu.RUnlock()
+	if !exists {
+		return 0
+	}
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	var size int64
+	for index, chunk := range session.Chunks {
+		if index != skipIndex {
+			size += int64(len(chunk.Data))
+		}
+	}
+	return size
+}
+
 // assembleUploadedFile assembles all chunks into the final package file
 func (h *DebugHandler) assembleUploadedFile(appID string, session *UploadSession) error {
 	// Create package file path
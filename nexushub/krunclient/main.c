@@ -7,18 +7,26 @@ extern char **environ;
 int main(int argc, char *argv[])
 {
 	if (argc != 3) {
-		fprintf(stderr, "Usage: %s <root_path> <local_port>\n", argv[0]);
+		fprintf(stderr, "Usage: %s <root_path> <local_port>|unix\n", argv[0]);
 		return 1;
 	}
 
+	// In unix mode the app listens on LISTEN_SOCKET under the root path, so
+	// no ports are mapped at all
+	int unix_mode = !strcmp(argv[2], "unix");
+
 	// Build port mapping string dynamically
 	char port_mapping[32];
 	snprintf(port_mapping, sizeof(port_mapping), "%s:80", argv[2]);
 	const char *port_map[] = {port_mapping, NULL};
+	const char *no_ports[] = {NULL};
 
 	char * envp[] = {
 		"HOST=",
 		"INTERNAL_SECRET=",
+		"INTERNAL_SECRET_FILE=",
+		"INSTANCE_ID=",
+		"LISTEN_SOCKET=",
 		0,
 	};
 	for (int i = 0; environ[i] != NULL; ++i) {
@@ -30,6 +38,16 @@ int main(int argc, char *argv[])
 			printf("Setting INTERNAL_SECRET environment variable\n");
 	        envp[1] = strdup(environ[i]);
 	    }
+	    if (!strncmp(environ[i], "INTERNAL_SECRET_FILE=", 21)) {
+	        envp[2] = strdup(environ[i]);
+	    }
+	    if (!strncmp(environ[i], "INSTANCE_ID=", 12)) {
+	        envp[3] = strdup(environ[i]);
+	    }
+	    if (!strncmp(environ[i], "LISTEN_SOCKET=", 14)) {
+			printf("Setting LISTEN_SOCKET environment variable to %s\n", &environ[i][14]);
+	        envp[4] = strdup(environ[i]);
+	    }
 	}
 
 	int ctx_id = krun_create_ctx();
@@ -37,8 +55,13 @@ int main(int argc, char *argv[])
 	krun_set_vm_config(ctx_id, 1, 512);
 	printf("Setting VM root to %s\n", argv[1]);
 	krun_set_root(ctx_id, argv[1]);
-	printf("Mapping TCP ports %s\n", port_mapping);
-	krun_set_port_map(ctx_id, port_map);
+	if (unix_mode) {
+		printf("Listening on a unix socket, not mapping TCP ports\n");
+		krun_set_port_map(ctx_id, no_ports);
+	} else {
+		printf("Mapping TCP ports %s\n", port_mapping);
+		krun_set_port_map(ctx_id, port_map);
+	}
 	printf("Executing /bin/app in VM...\n");
 	krun_set_exec(ctx_id, "/app/bin/app", 0, (const char* const*)&envp[0]);
 	krun_start_enter(ctx_id);
@@ -0,0 +1,209 @@
+package packages
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+)
+
+// ErrNotClone is returned by RemoveClone for instances that are not clones.
+var ErrNotClone = errors.New("instance is not a clone")
+
+// CloneOptions are the settings of a new clone.
+type CloneOptions struct {
+	// HostName is the host the clone is served on, empty to route it by its
+	// instance ID only
+	HostName string
+	// Sandbox clones are started with SANDBOX=1, which stops applib from
+	// making cross-service requests to other applications
+	Sandbox bool
+	// ExpiresAt is when the clone is uninstalled, see UninstallExpiredClones
+	ExpiresAt time.Time
+}
+
+// cloneSkipped names the parts of an install directory a clone does not
+// copy: databases are backed up separately, and the internal secret is
+// written again when the clone starts.
+var cloneSkipped = map[string]bool{
+	"db":                            true,
+	"secrets":                       true,
+	snapshotFileName:                true,
+	snapshotFileName + digestSuffix: true,
+}
+
+// NewInstanceID derives a new instance ID from the current time and two
+// random bytes.
+func NewInstanceID() string {
+	seq := make([]byte, 6)
+	binary.BigEndian.PutUint32(seq[:4], uint32(time.Now().Unix()))
+	rand.Read(seq[4:])
+	return base64.URLEncoding.EncodeToString(seq)
+}
+
+// CloneInstance installs a copy of the source instance as cloneID, running
+// the same package version against a backup of each of the source's
+// databases taken while it keeps running. The clone is an instance like any
+// other until it is removed with RemoveClone.
+func (pm *PackageManager) CloneInstance(sourceID, cloneID string, options CloneOptions, processManager httpsproxy_types.ProcessManagerInterface) error {
+	source, err := pm.GetPackageByInstanceID(sourceID)
+	if err != nil {
+		return err
+	}
+	if source == nil {
+		return os.ErrNotExist
+	}
+
+	sourceDir := filepath.Join(pm.installDir, sourceID)
+	cloneDir := filepath.Join(pm.installDir, cloneID)
+	if _, err := os.Stat(cloneDir); err == nil {
+		return fmt.Errorf("install directory for %s already exists", cloneID)
+	}
+	if err := copyInstallDir(sourceDir, cloneDir); err != nil {
+		os.RemoveAll(cloneDir)
+		return fmt.Errorf("failed to copy installation: %w", err)
+	}
+	if err := copyDatabases(filepath.Join(sourceDir, "db"), filepath.Join(cloneDir, "db")); err != nil {
+		os.RemoveAll(cloneDir)
+		return fmt.Errorf("failed to copy databases: %w", err)
+	}
+	if err := PackageDBInsertClone(pm.DB, source, cloneID, options); err != nil {
+		os.RemoveAll(cloneDir)
+		return err
+	}
+
+	processManager.Refresh()
+	return nil
+}
+
+// GetClones returns every installed clone.
+func (pm *PackageManager) GetClones() ([]*Package, error) {
+	return PackageDBGetClones(pm.DB)
+}
+
+// RemoveClone uninstalls a clone and deletes its install directory. Unlike
+// UninstallPackage nothing is left to recover, since the clone's data is a
+// copy.
+func (pm *PackageManager) RemoveClone(instanceID string, processManager httpsproxy_types.ProcessManagerInterface) error {
+	pkg, err := pm.GetPackageByInstanceID(instanceID)
+	if err != nil {
+		return err
+	}
+	if pkg == nil {
+		return os.ErrNotExist
+	}
+	if pkg.CloneOf == "" {
+		return ErrNotClone
+	}
+	if err := pm.UninstallPackage(instanceID, processManager); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(pm.installDir, instanceID))
+}
+
+// UninstallExpiredClones removes the clones that expired by now and returns
+// their instance IDs.
+func (pm *PackageManager) UninstallExpiredClones(now time.Time, processManager httpsproxy_types.ProcessManagerInterface) ([]string, error) {
+	clones, err := pm.GetClones()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	var errs []error
+	for _, clone := range clones {
+		if clone.ExpiresAt == nil || now.Before(*clone.ExpiresAt) {
+			continue
+		}
+		if err := pm.RemoveClone(clone.InstanceID, processManager); err != nil {
+			errs = append(errs, fmt.Errorf("clone %s: %w", clone.InstanceID, err))
+			continue
+		}
+		removed = append(removed, clone.InstanceID)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// copyInstallDir copies the regular files and directories of an install
+// directory, except for cloneSkipped.
+func copyInstallDir(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if cloneSkipped[strings.Split(filepath.ToSlash(rel), "/")[0]] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Sockets and the like belong to the running source instance
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dest string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyDatabases backs up every SQLite database in the src directory into
+// dest. Journal files are skipped; the backups include their contents.
+func copyDatabases(src, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasSuffix(name, "-wal") || strings.HasSuffix(name, "-shm") || strings.HasSuffix(name, "-journal") {
+			continue
+		}
+		if err := backupDatabase(filepath.Join(src, name), filepath.Join(dest, name)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package packages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+)
+
+// refreshCounter counts requests to reconcile the running instances.
+type refreshCounter struct {
+	httpsproxy_types.ProcessManagerInterface
+	refreshes int
+}
+
+func (pm *refreshCounter) Refresh() {
+	pm.refreshes++
+}
+
+// newSourceInstance installs instance "abc" with a binary, a database
+// holding one row and a running instance's secret.
+func newSourceInstance(t *testing.T) *PackageManager {
+	installDir := t.TempDir()
+	pm, err := OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pm.DB.Close() })
+
+	dir := filepath.Join(installDir, "abc")
+	for path, contents := range map[string]string{
+		"app/bin/app":             "binary",
+		"app/manifest.json":       `{"name":"app","version":"1.0.0"}`,
+		packageFileName:           "zip",
+		"secrets/internal_secret": "secret",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	execSQL(t, filepath.Join(dir, "db", "app.sqlite"),
+		`CREATE TABLE items (name TEXT)`,
+		`INSERT INTO items VALUES ('production')`)
+	if err := PackageDBInsert(pm.DB, "abc", "hash", "app", "1.0.0", map[string]bool{"app": true}, "", false, "static", ""); err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+func execSQL(t *testing.T, path string, statements ...string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func itemNames(t *testing.T, path string) []string {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var names []string
+	if err := db.Select(&names, `SELECT name FROM items ORDER BY name`); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestCloneInstance(t *testing.T) {
+	pm := newSourceInstance(t)
+	processManager := &refreshCounter{}
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	err := pm.CloneInstance("abc", "clone", CloneOptions{HostName: "app-clone.example.com", Sandbox: true, ExpiresAt: expiresAt}, processManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processManager.refreshes != 1 {
+		t.Errorf("Expected the clone to be started, got %d refreshes", processManager.refreshes)
+	}
+
+	clone, err := pm.GetPackageByInstanceID("clone")
+	if err != nil || clone == nil {
+		t.Fatalf("Expected the clone to be installed: %v", err)
+	}
+	if clone.Version != "1.0.0" || clone.PackageHash != "hash" || clone.StaticPath != "static" || !clone.Subscriptions["app"] {
+		t.Errorf("Expected the source's package settings, got %+v", clone)
+	}
+	if clone.CloneOf != "abc" || !clone.Sandbox || clone.HostName != "app-clone.example.com" || clone.ExpiresAt == nil || !clone.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected the clone settings, got %+v", clone)
+	}
+	// Registration of the package still finds the source
+	if pkg, err := pm.GetPackageByHash("hash"); err != nil || pkg.InstanceID != "abc" {
+		t.Errorf("Expected the package hash to find the source, got %+v: %v", pkg, err)
+	}
+
+	instances, err := pm.GetAppInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, instance := range instances {
+		if instance.InstanceID == "clone" && (!instance.Sandbox || instance.HostName != "app-clone.example.com") {
+			t.Errorf("Expected a sandboxed instance on the clone's host, got %+v", instance)
+		}
+	}
+
+	cloneDir := filepath.Join(pm.GetInstallDir(), "clone")
+	if !pm.IsInstalled("clone") {
+		t.Error("Expected the clone's binary to be copied")
+	}
+	if info, err := os.Stat(filepath.Join(cloneDir, "app", "bin", "app")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Expected the binary to stay executable: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cloneDir, "secrets")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the source's secret not to be copied, got %v", err)
+	}
+
+	// The clone's database is a copy: writes to either are not seen by the
+	// other
+	cloneDB := filepath.Join(cloneDir, "db", "app.sqlite")
+	sourceDB := filepath.Join(pm.GetInstallDir(), "abc", "db", "app.sqlite")
+	execSQL(t, cloneDB, `INSERT INTO items VALUES ('experiment')`)
+	execSQL(t, sourceDB, `INSERT INTO items VALUES ('later')`)
+	if names := itemNames(t, cloneDB); len(names) != 2 || names[0] != "experiment" || names[1] != "production" {
+		t.Errorf("Expected the clone to have its own copy, got %v", names)
+	}
+	if names := itemNames(t, sourceDB); len(names) != 2 || names[0] != "later" || names[1] != "production" {
+		t.Errorf("Expected the source to be unaffected, got %v", names)
+	}
+}
+
+func TestCloneInstanceErrors(t *testing.T) {
+	pm := newSourceInstance(t)
+	processManager := &refreshCounter{}
+
+	if err := pm.CloneInstance("missing", "clone", CloneOptions{}, processManager); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for a missing source, got %v", err)
+	}
+	if err := pm.CloneInstance("abc", "abc", CloneOptions{}, processManager); err == nil {
+		t.Error("Expected cloning over an existing install directory to fail")
+	}
+	if err := pm.RemoveClone("abc", processManager); !errors.Is(err, ErrNotClone) {
+		t.Errorf("Expected ErrNotClone when removing the source, got %v", err)
+	}
+	if processManager.refreshes != 0 || !pm.IsInstalled("abc") {
+		t.Error("Expected failed clones to leave the source alone")
+	}
+}
+
+func TestUninstallExpiredClones(t *testing.T) {
+	pm := newSourceInstance(t)
+	processManager := &refreshCounter{}
+	now := time.Now()
+	if err := pm.CloneInstance("abc", "short", CloneOptions{ExpiresAt: now.Add(time.Minute)}, processManager); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.CloneInstance("abc", "long", CloneOptions{ExpiresAt: now.Add(time.Hour)}, processManager); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := pm.UninstallExpiredClones(now, processManager)
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("Expected no clone to have expired yet, got %v: %v", removed, err)
+	}
+
+	removed, err = pm.UninstallExpiredClones(now.Add(time.Minute), processManager)
+	if err != nil || len(removed) != 1 || removed[0] != "short" {
+		t.Fatalf("Expected the short-lived clone to expire, got %v: %v", removed, err)
+	}
+	if pkg, _ := pm.GetPackageByInstanceID("short"); pkg != nil {
+		t.Error("Expected the expired clone to be uninstalled")
+	}
+	if _, err := os.Stat(filepath.Join(pm.GetInstallDir(), "short")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the expired clone's files to be deleted, got %v", err)
+	}
+	if processManager.refreshes != 3 {
+		t.Errorf("Expected the expired clone to be stopped, got %d refreshes", processManager.refreshes)
+	}
+
+	// The other clone and the source are untouched
+	if !pm.IsInstalled("long") || !pm.IsInstalled("abc") {
+		t.Error("Expected the unexpired clone and the source to stay installed")
+	}
+	if names := itemNames(t, filepath.Join(pm.GetInstallDir(), "abc", "db", "app.sqlite")); len(names) != 1 {
+		t.Errorf("Expected the source's database to be untouched, got %v", names)
+	}
+}
@@ -9,9 +9,6 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-// TODO: Move to a configurable setting
-const TTLInterval time.Duration = time.Minute * 5
-
 type Package struct {
 	InstanceID        string          `db:"instance_id"`
 	PackageHash       string          `db:"package_hash"`
@@ -20,6 +17,19 @@ type Package struct {
 	SubscriptionsJson []byte          `db:"subscriptions"`
 	Subscriptions     map[string]bool `db:"-"`
 	ActiveTtl         time.Time       `db:"active_ttl"`
+	Transport         string          `db:"transport"`
+	RunSelfTest       bool            `db:"run_self_test"`
+	StaticPath        string          `db:"static_path"`
+	HealthCheckJson   string          `db:"health_check"` // The manifest's healthCheck as JSON, or empty
+	QuotaJson         string          `db:"quota"`        // The manifest's quota as JSON, or empty
+	Warm              bool            `db:"warm"`
+	WarmupJson        string          `db:"warmup"`     // The manifest's warmup paths as JSON, or empty
+	PublishersJson    string          `db:"publishers"` // The manifest's publishers as JSON, or empty
+	// Set for clones of another instance, see PackageManager.CloneInstance
+	CloneOf   string     `db:"clone_of"`
+	Sandbox   bool       `db:"sandbox"`
+	HostName  string     `db:"host_name"`
+	ExpiresAt *time.Time `db:"expires_at"`
 }
 
 const packageSchema = `
@@ -29,34 +39,106 @@ CREATE TABLE IF NOT EXISTS package_v1 (
 	name STRING NOT NULL,
 	version STRING NOT NULL,
 	subscriptions JSONB NOT NULL,
-	active_ttl TIMESTAMP
+	active_ttl TIMESTAMP,
+	transport STRING NOT NULL DEFAULT '',
+	run_self_test BOOLEAN NOT NULL DEFAULT FALSE,
+	static_path STRING NOT NULL DEFAULT '',
+	health_check STRING NOT NULL DEFAULT '',
+	clone_of STRING NOT NULL DEFAULT '',
+	sandbox BOOLEAN NOT NULL DEFAULT FALSE,
+	host_name STRING NOT NULL DEFAULT '',
+	expires_at TIMESTAMP,
+	quota STRING NOT NULL DEFAULT '',
+	warm BOOLEAN NOT NULL DEFAULT FALSE,
+	warmup STRING NOT NULL DEFAULT '',
+	publishers STRING NOT NULL DEFAULT ''
 );
 `
 
+// Databases created before the transport, run_self_test, static_path, clone,
+// health_check, quota, warm-up and publishers columns were added are migrated in PackageDBInit.
+const packageColumnSql = `
+SELECT COUNT(*) FROM pragma_table_info('package_v1') WHERE name = $1;
+`
+
+var addedPackageColumns = []struct{ name, sql string }{
+	{"transport", `ALTER TABLE package_v1 ADD COLUMN transport STRING NOT NULL DEFAULT '';`},
+	{"run_self_test", `ALTER TABLE package_v1 ADD COLUMN run_self_test BOOLEAN NOT NULL DEFAULT FALSE;`},
+	{"static_path", `ALTER TABLE package_v1 ADD COLUMN static_path STRING NOT NULL DEFAULT '';`},
+	{"clone_of", `ALTER TABLE package_v1 ADD COLUMN clone_of STRING NOT NULL DEFAULT '';`},
+	{"sandbox", `ALTER TABLE package_v1 ADD COLUMN sandbox BOOLEAN NOT NULL DEFAULT FALSE;`},
+	{"host_name", `ALTER TABLE package_v1 ADD COLUMN host_name STRING NOT NULL DEFAULT '';`},
+	{"expires_at", `ALTER TABLE package_v1 ADD COLUMN expires_at TIMESTAMP;`},
+	{"health_check", `ALTER TABLE package_v1 ADD COLUMN health_check STRING NOT NULL DEFAULT '';`},
+	{"quota", `ALTER TABLE package_v1 ADD COLUMN quota STRING NOT NULL DEFAULT '';`},
+	{"warm", `ALTER TABLE package_v1 ADD COLUMN warm BOOLEAN NOT NULL DEFAULT FALSE;`},
+	{"warmup", `ALTER TABLE package_v1 ADD COLUMN warmup STRING NOT NULL DEFAULT '';`},
+	{"publishers", `ALTER TABLE package_v1 ADD COLUMN publishers STRING NOT NULL DEFAULT '';`},
+}
+
 const getPackageByInstanceIDV1Sql = `
-SELECT instance_id, package_hash, name, version, subscriptions, active_ttl FROM package_v1 WHERE instance_id = $1;
+SELECT instance_id, package_hash, name, version, subscriptions, active_ttl, transport, run_self_test, static_path, health_check, clone_of, sandbox, host_name, expires_at, quota, warm, warmup, publishers FROM package_v1 WHERE instance_id = $1;
 `
 
 const getPackageByHashV1Sql = `
-SELECT instance_id, package_hash, name, version, subscriptions, active_ttl FROM package_v1 WHERE package_hash = $1;
+SELECT instance_id, package_hash, name, version, subscriptions, active_ttl, transport, run_self_test, static_path, health_check, clone_of, sandbox, host_name, expires_at, quota, warm, warmup, publishers FROM package_v1 WHERE package_hash = $1 AND clone_of = '';
 `
 
-const getActivePackagesV1Sql = `
-SELECT instance_id, package_hash, name, version, subscriptions, active_ttl FROM package_v1 WHERE active_ttl > CURRENT_TIMESTAMP;
+const getAllPackagesV1Sql = `
+SELECT instance_id, package_hash, name, version, subscriptions, active_ttl, transport, run_self_test, static_path, health_check, clone_of, sandbox, host_name, expires_at, quota, warm, warmup, publishers FROM package_v1;
 `
 
 const insertPackageV1Sql = `
-INSERT INTO package_v1 (instance_id, package_hash, name, version, subscriptions, active_ttl)
-VALUES ($1, $2, $3, $4, $5, $6);
+INSERT INTO package_v1 (instance_id, package_hash, name, version, subscriptions, active_ttl, transport, run_self_test, static_path, health_check)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+`
+
+const getClonesV1Sql = `
+SELECT instance_id, package_hash, name, version, subscriptions, active_ttl, transport, run_self_test, static_path, health_check, clone_of, sandbox, host_name, expires_at, quota, warm, warmup, publishers FROM package_v1 WHERE clone_of != '';
+`
+
+const insertCloneV1Sql = `
+INSERT INTO package_v1 (instance_id, package_hash, name, version, subscriptions, active_ttl, transport, run_self_test, static_path, health_check, clone_of, sandbox, host_name, expires_at, quota, warmup, publishers)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17);
 `
 
 const updatePackageV1Sql = `
 UPDATE package_v1 SET active_ttl = $1 WHERE instance_id = $2;
 `
 
+const updatePackageQuotaV1Sql = `
+UPDATE package_v1 SET quota = $1 WHERE instance_id = $2;
+`
+
+const updatePackageWarmV1Sql = `
+UPDATE package_v1 SET warm = $1, warmup = $2 WHERE instance_id = $3;
+`
+
+const updatePackagePublishersV1Sql = `
+UPDATE package_v1 SET publishers = $1 WHERE instance_id = $2;
+`
+
+const deletePackageV1Sql = `
+DELETE FROM package_v1 WHERE instance_id = $1;
+`
+
 func PackageDBInit(db *sqlx.DB) error {
 	_, err := db.Exec(packageSchema)
-	return err
+	if err != nil {
+		return err
+	}
+	for _, column := range addedPackageColumns {
+		var hasColumn int
+		if err := db.Get(&hasColumn, packageColumnSql, column.name); err != nil {
+			return err
+		}
+		if hasColumn == 0 {
+			if _, err := db.Exec(column.sql); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func PackageDBGetByInstanceID(db *sqlx.DB, instanceID string) (*Package, error) {
@@ -85,9 +167,19 @@ func PackageDBGetByHash(db *sqlx.DB, hash string) (*Package, error) {
 	return &pkg, err
 }
 
-func PackageDBGetActive(db *sqlx.DB) ([]*Package, error) {
+// PackageDBGetAll returns every installed package, active or not.
+func PackageDBGetAll(db *sqlx.DB) ([]*Package, error) {
+	return packageDBSelect(db, getAllPackagesV1Sql)
+}
+
+// PackageDBGetClones returns every installed clone, active or not.
+func PackageDBGetClones(db *sqlx.DB) ([]*Package, error) {
+	return packageDBSelect(db, getClonesV1Sql)
+}
+
+func packageDBSelect(db *sqlx.DB, query string) ([]*Package, error) {
 	var pkgs []*Package
-	err := db.Select(&pkgs, getActivePackagesV1Sql)
+	err := db.Select(&pkgs, query)
 	if err != nil {
 		return nil, err
 	}
@@ -100,18 +192,62 @@ func PackageDBGetActive(db *sqlx.DB) ([]*Package, error) {
 	return pkgs, err
 }
 
-func PackageDBInsert(db *sqlx.DB, instanceID, hash, name, version string, subscriptions map[string]bool) error {
+// PackageDBInsert records an installed package. transport, runSelfTest,
+// staticPath and healthCheck are the manifest's settings, transport empty
+// for the default and healthCheck JSON or empty for the default.
+func PackageDBInsert(db *sqlx.DB, instanceID, hash, name, version string, subscriptions map[string]bool, transport string, runSelfTest bool, staticPath string, healthCheck string) error {
 	jsonSubscriptions, err := json.Marshal(subscriptions)
 	if err != nil {
 		return err
 	}
-	activeTTL := time.Now().UTC().Add(TTLInterval)
-	_, err = db.Exec(insertPackageV1Sql, instanceID, hash, name, version, jsonSubscriptions, activeTTL)
+	activeTTL := time.Now().UTC().Add(DefaultIdleTimeout)
+	_, err = db.Exec(insertPackageV1Sql, instanceID, hash, name, version, jsonSubscriptions, activeTTL, transport, runSelfTest, staticPath, healthCheck)
+	return err
+}
+
+// PackageDBInsertClone records a clone of source installed as instanceID.
+func PackageDBInsertClone(db *sqlx.DB, source *Package, instanceID string, options CloneOptions) error {
+	jsonSubscriptions, err := json.Marshal(source.Subscriptions)
+	if err != nil {
+		return err
+	}
+	activeTTL := time.Now().UTC().Add(DefaultIdleTimeout)
+	expiresAt := options.ExpiresAt.UTC()
+	_, err = db.Exec(insertCloneV1Sql, instanceID, source.PackageHash, source.Name, source.Version, jsonSubscriptions, activeTTL,
+		source.Transport, source.RunSelfTest, source.StaticPath, source.HealthCheckJson, source.InstanceID, options.Sandbox, options.HostName, expiresAt,
+		source.QuotaJson, source.WarmupJson, source.PublishersJson)
 	return err
 }
 
-func PackageDBUpdateTTL(db *sqlx.DB, instanceID string) error {
-	activeTTL := time.Now().UTC().Add(TTLInterval)
+// PackageDBUpdateTTL keeps the package active for ttl from now.
+func PackageDBUpdateTTL(db *sqlx.DB, instanceID string, ttl time.Duration) error {
+	activeTTL := time.Now().UTC().Add(ttl)
 	_, err := db.Exec(updatePackageV1Sql, activeTTL, instanceID)
 	return err
 }
+
+// PackageDBSetQuota records the manifest's quota of an installed package, as
+// JSON or empty for none.
+func PackageDBSetQuota(db *sqlx.DB, instanceID, quota string) error {
+	_, err := db.Exec(updatePackageQuotaV1Sql, quota, instanceID)
+	return err
+}
+
+// PackageDBSetWarm sets whether the instance is kept warm and the paths it is
+// warmed up with, as JSON.
+func PackageDBSetWarm(db *sqlx.DB, instanceID string, warm bool, warmup string) error {
+	_, err := db.Exec(updatePackageWarmV1Sql, warm, warmup, instanceID)
+	return err
+}
+
+// PackageDBSetPublishers records who may publish the event types of an
+// installed package, as JSON or empty for no restrictions.
+func PackageDBSetPublishers(db *sqlx.DB, instanceID, publishers string) error {
+	_, err := db.Exec(updatePackagePublishersV1Sql, publishers, instanceID)
+	return err
+}
+
+func PackageDBDelete(db *sqlx.DB, instanceID string) error {
+	_, err := db.Exec(deletePackageV1Sql, instanceID)
+	return err
+}
@@ -0,0 +1,171 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SnapshotTTL is how long a database snapshot is reused for downloads, so an
+// interrupted download can resume against the same bytes.
+const SnapshotTTL = 15 * time.Minute
+
+// ErrPackageNotRetained is returned by PackageFile for instances installed
+// before package files were kept alongside the installation.
+var ErrPackageNotRetained = errors.New("package file was not retained for this instance")
+
+const (
+	packageFileName  = "package.zip"
+	snapshotFileName = "snapshot.sqlite"
+	// digestSuffix names the file holding the hex SHA-256 of a file
+	digestSuffix = ".sha256"
+)
+
+// snapshotMu serializes snapshot creation so concurrent downloads share one
+// snapshot.
+var snapshotMu sync.Mutex
+
+// DownloadFile is an open file prepared for download and its SHA-256
+// digest. The caller must close it.
+type DownloadFile struct {
+	*os.File
+	Digest string
+}
+
+func openDownload(path, digest string) (*DownloadFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DownloadFile{File: f, Digest: strings.TrimSpace(digest)}, nil
+}
+
+// retainPackage copies the package zip into the instance's install directory
+// and records its digest, so the exact package can be downloaded later.
+func (pm *PackageManager) retainPackage(pkgPath, instanceID string) error {
+	dest := filepath.Join(pm.installDir, instanceID, packageFileName)
+	digest, err := copyWithDigest(pkgPath, dest)
+	if err != nil {
+		return fmt.Errorf("failed to retain package: %w", err)
+	}
+	return os.WriteFile(dest+digestSuffix, []byte(digest), 0644)
+}
+
+// PackageFile returns the package zip the instance was installed from.
+func (pm *PackageManager) PackageFile(instanceID string) (*DownloadFile, error) {
+	if pkg, err := pm.GetPackageByInstanceID(instanceID); err != nil {
+		return nil, err
+	} else if pkg == nil {
+		return nil, os.ErrNotExist
+	}
+	path := filepath.Join(pm.installDir, instanceID, packageFileName)
+	digest, err := os.ReadFile(path + digestSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrPackageNotRetained
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openDownload(path, string(digest))
+}
+
+// DatabaseSnapshot returns a consistent copy of the instance's database,
+// taken with VACUUM INTO while the instance keeps running. A snapshot younger
+// than SnapshotTTL is reused.
+func (pm *PackageManager) DatabaseSnapshot(instanceID string) (*DownloadFile, error) {
+	if pkg, err := pm.GetPackageByInstanceID(instanceID); err != nil {
+		return nil, err
+	} else if pkg == nil {
+		return nil, os.ErrNotExist
+	}
+	dbPath := filepath.Join(pm.installDir, instanceID, "db", "app.sqlite")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	path := filepath.Join(pm.installDir, instanceID, snapshotFileName)
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < SnapshotTTL {
+		if digest, err := os.ReadFile(path + digestSuffix); err == nil {
+			return openDownload(path, string(digest))
+		}
+	}
+
+	// VACUUM INTO refuses to overwrite, so write to a fresh file and move it
+	// into place
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	if err := backupDatabase(dbPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	digest, err := fileDigest(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+digestSuffix, []byte(digest), 0644); err != nil {
+		return nil, err
+	}
+	return openDownload(path, digest)
+}
+
+// backupDatabase copies the SQLite database at src to dest, which must not
+// exist, while the database stays in use.
+func backupDatabase(src, dest string) error {
+	db, err := sqlx.Open("sqlite3", src+"?_busy_timeout=5000")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(`VACUUM INTO $1`, dest)
+	return err
+}
+
+func copyWithDigest(src, dest string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hasher), in); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,156 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
+)
+
+// DefaultIdleTimeout is how long an instance is kept running after its last
+// request unless configured otherwise. Newly installed instances are active
+// for this long before their first request.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// IdleTimeouts configures how long instances are kept running after their
+// last request before the process manager stops them.
+type IdleTimeouts struct {
+	// Default applies to instances without an override
+	Default time.Duration
+	// Overrides replaces the default for the given instance IDs
+	Overrides map[string]time.Duration
+	// KeepWarm lists instance IDs that never go idle: they are started with
+	// the hub and kept running. Packages whose manifest sets warm are kept
+	// warm too, unless Overrides gives them a timeout
+	KeepWarm map[string]bool
+}
+
+// ParseIdleTimeoutOverrides parses a comma-separated list of
+// instanceID=timeout pairs, where timeout is a duration or "never" to keep
+// the instance warm, e.g. "MBtskI6D=never,3bf3e3c0=30m".
+func ParseIdleTimeoutOverrides(value string) (map[string]time.Duration, map[string]bool, error) {
+	overrides := make(map[string]time.Duration)
+	keepWarm := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		instanceID, timeout, ok := strings.Cut(entry, "=")
+		instanceID = strings.TrimSpace(instanceID)
+		timeout = strings.TrimSpace(timeout)
+		if !ok || instanceID == "" || timeout == "" {
+			return nil, nil, fmt.Errorf("invalid idle timeout %q, expected instanceID=timeout", entry)
+		}
+		if _, ok := overrides[instanceID]; ok || keepWarm[instanceID] {
+			return nil, nil, fmt.Errorf("instance %s has more than one idle timeout", instanceID)
+		}
+		if timeout == "never" {
+			keepWarm[instanceID] = true
+			continue
+		}
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid idle timeout %q for %s: expected a positive duration or \"never\"", timeout, instanceID)
+		}
+		overrides[instanceID] = d
+	}
+	return overrides, keepWarm, nil
+}
+
+// IdleTimeoutsFromEnv reads the default idle timeout from the IDLE_TIMEOUT
+// environment variable, e.g. "15m", and per-instance overrides from
+// IDLE_TIMEOUT_OVERRIDES (see ParseIdleTimeoutOverrides). The default is
+// DefaultIdleTimeout if IDLE_TIMEOUT is unset.
+func IdleTimeoutsFromEnv() (IdleTimeouts, error) {
+	timeouts := IdleTimeouts{Default: DefaultIdleTimeout}
+	if value := os.Getenv("IDLE_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return IdleTimeouts{}, fmt.Errorf("invalid IDLE_TIMEOUT %q: expected a positive duration", value)
+		}
+		timeouts.Default = d
+	}
+	overrides, keepWarm, err := ParseIdleTimeoutOverrides(os.Getenv("IDLE_TIMEOUT_OVERRIDES"))
+	if err != nil {
+		return IdleTimeouts{}, fmt.Errorf("invalid IDLE_TIMEOUT_OVERRIDES: %w", err)
+	}
+	timeouts.Overrides = overrides
+	timeouts.KeepWarm = keepWarm
+	return timeouts, nil
+}
+
+// SetIdleTimeouts sets how long instances are kept running after their last
+// request. Instances use DefaultIdleTimeout until it is called.
+func (pm *PackageManager) SetIdleTimeouts(timeouts IdleTimeouts) {
+	pm.idleTimeouts = timeouts
+}
+
+// IdleTimeout returns how long the instance is kept running after its last
+// request, and whether it is kept warm regardless.
+func (pm *PackageManager) IdleTimeout(instanceID string) (time.Duration, bool) {
+	if pm.idleTimeouts.KeepWarm[instanceID] {
+		return 0, true
+	}
+	if timeout, ok := pm.idleTimeouts.Overrides[instanceID]; ok {
+		return timeout, false
+	}
+	if pm.idleTimeouts.Default > 0 {
+		return pm.idleTimeouts.Default, false
+	}
+	return DefaultIdleTimeout, false
+}
+
+// KeptWarm reports whether the package is kept running without traffic:
+// the admin keeps it warm, or its manifest sets warm and the admin did not
+// give it an idle timeout instead.
+func (pm *PackageManager) KeptWarm(pkg *Package) bool {
+	if pm.idleTimeouts.KeepWarm[pkg.InstanceID] {
+		return true
+	}
+	_, overridden := pm.idleTimeouts.Overrides[pkg.InstanceID]
+	return pkg.Warm && !overridden
+}
+
+// isActive reports whether the package should be running at now: it was
+// requested within its idle timeout, or is kept warm.
+func (pm *PackageManager) isActive(pkg *Package, now time.Time) bool {
+	return pm.KeptWarm(pkg) || pkg.ActiveTtl.After(now)
+}
+
+// activePackages returns the packages that should be running at now.
+func (pm *PackageManager) activePackages(now time.Time) ([]*Package, error) {
+	pkgs, err := PackageDBGetAll(pm.DB)
+	if err != nil {
+		return nil, err
+	}
+	active := pkgs[:0]
+	for _, pkg := range pkgs {
+		if pm.isActive(pkg, now) {
+			active = append(active, pkg)
+		}
+	}
+	return active, nil
+}
+
+// DeactivateIdle returns the packages that went idle after since and by now,
+// having had no requests for their idle timeout, and has the process manager
+// stop them. The next request for one of them starts it again.
+func (pm *PackageManager) DeactivateIdle(since, now time.Time, processManager httpsproxy_types.ProcessManagerInterface) ([]*Package, error) {
+	pkgs, err := PackageDBGetAll(pm.DB)
+	if err != nil {
+		return nil, err
+	}
+	var idle []*Package
+	for _, pkg := range pkgs {
+		if !pm.isActive(pkg, now) && pkg.ActiveTtl.After(since) {
+			idle = append(idle, pkg)
+		}
+	}
+	if len(idle) > 0 {
+		processManager.Refresh()
+	}
+	return idle, nil
+}
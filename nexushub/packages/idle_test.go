@@ -0,0 +1,190 @@
+package packages
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseIdleTimeoutOverrides(t *testing.T) {
+	overrides, keepWarm, err := ParseIdleTimeoutOverrides(" MBtskI6D=never, 3bf3e3c0=30m ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || overrides["3bf3e3c0"] != 30*time.Minute {
+		t.Errorf("Expected a 30m override, got %v", overrides)
+	}
+	if len(keepWarm) != 1 || !keepWarm["MBtskI6D"] {
+		t.Errorf("Expected MBtskI6D to be kept warm, got %v", keepWarm)
+	}
+
+	for _, value := range []string{"abc", "abc=", "=1m", "abc=soon", "abc=-1m", "abc=0s", "abc=1m,abc=never"} {
+		if _, _, err := ParseIdleTimeoutOverrides(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestIdleTimeoutsFromEnv(t *testing.T) {
+	timeouts, err := IdleTimeoutsFromEnv()
+	if err != nil || timeouts.Default != DefaultIdleTimeout || len(timeouts.Overrides) != 0 || len(timeouts.KeepWarm) != 0 {
+		t.Errorf("Expected the default idle timeout, got %+v: %v", timeouts, err)
+	}
+
+	t.Setenv("IDLE_TIMEOUT", "15m")
+	t.Setenv("IDLE_TIMEOUT_OVERRIDES", "abc=never")
+	timeouts, err = IdleTimeoutsFromEnv()
+	if err != nil || timeouts.Default != 15*time.Minute || !timeouts.KeepWarm["abc"] {
+		t.Errorf("Expected a 15m default keeping abc warm, got %+v: %v", timeouts, err)
+	}
+
+	t.Setenv("IDLE_TIMEOUT", "0")
+	if _, err := IdleTimeoutsFromEnv(); err == nil {
+		t.Error("Expected a zero IDLE_TIMEOUT to be rejected")
+	}
+}
+
+// newIdleTestManager installs instances "default", "short" and "warm", none
+// of them requested recently.
+func newIdleTestManager(t *testing.T) *PackageManager {
+	installDir := t.TempDir()
+	pm, err := OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pm.DB.Close() })
+	for _, instanceID := range []string{"default", "short", "warm"} {
+		if err := PackageDBInsert(pm.DB, instanceID, instanceID, instanceID, "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := PackageDBUpdateTTL(pm.DB, instanceID, -time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pm.SetIdleTimeouts(IdleTimeouts{
+		Default:   10 * time.Minute,
+		Overrides: map[string]time.Duration{"short": time.Minute},
+		KeepWarm:  map[string]bool{"warm": true},
+	})
+	return pm
+}
+
+func instanceIDs(pkgs []*Package) map[string]bool {
+	ids := make(map[string]bool)
+	for _, pkg := range pkgs {
+		ids[pkg.InstanceID] = true
+	}
+	return ids
+}
+
+func TestIdleTimeouts(t *testing.T) {
+	pm := newIdleTestManager(t)
+	processManager := &refreshCounter{}
+
+	// Only the instance kept warm runs without requests
+	active, err := pm.GetActivePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := instanceIDs(active); len(ids) != 1 || !ids["warm"] {
+		t.Errorf("Expected only the warm instance to be active, got %v", ids)
+	}
+	instances, err := pm.GetAppInstances()
+	if err != nil || len(instances) != 1 || instances[0].InstanceID != "warm" {
+		t.Errorf("Expected the warm instance to be started, got %+v: %v", instances, err)
+	}
+
+	// A request keeps an instance running for its own idle timeout
+	for _, instanceID := range []string{"default", "short"} {
+		if err := pm.SetPackageActive(instanceID, processManager); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for instanceID, timeout := range map[string]time.Duration{"default": 10 * time.Minute, "short": time.Minute} {
+		pkg, err := pm.GetPackageByInstanceID(instanceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := pkg.ActiveTtl.Sub(now); ttl > timeout || ttl < timeout-time.Minute/2 {
+			t.Errorf("Expected %s to be kept active for %s, got %s", instanceID, timeout, ttl)
+		}
+	}
+	if active, _ := pm.GetActivePackages(); len(active) != 3 {
+		t.Errorf("Expected all instances to be active, got %v", instanceIDs(active))
+	}
+}
+
+func TestDeactivateIdle(t *testing.T) {
+	pm := newIdleTestManager(t)
+	processManager := &refreshCounter{}
+	for _, instanceID := range []string{"default", "short", "warm"} {
+		if err := pm.SetPackageActive(instanceID, processManager); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processManager.refreshes = 0
+	now := time.Now()
+
+	idle, err := pm.DeactivateIdle(now, now.Add(30*time.Second), processManager)
+	if err != nil || len(idle) != 0 || processManager.refreshes != 0 {
+		t.Fatalf("Expected no instance to be idle yet, got %v (%d refreshes): %v", instanceIDs(idle), processManager.refreshes, err)
+	}
+
+	// The short timeout runs out first; the warm instance never goes idle
+	idle, err = pm.DeactivateIdle(now.Add(30*time.Second), now.Add(2*time.Minute), processManager)
+	if ids := instanceIDs(idle); err != nil || len(ids) != 1 || !ids["short"] {
+		t.Errorf("Expected short to go idle, got %v: %v", ids, err)
+	}
+	if processManager.refreshes != 1 {
+		t.Errorf("Expected the idle instance to be stopped, got %d refreshes", processManager.refreshes)
+	}
+
+	// Instances are reported once, when they go idle
+	idle, err = pm.DeactivateIdle(now.Add(2*time.Minute), now.Add(time.Hour), processManager)
+	if ids := instanceIDs(idle); err != nil || len(ids) != 1 || !ids["default"] {
+		t.Errorf("Expected only default to go idle, got %v: %v", ids, err)
+	}
+}
+
+func TestWarmPackages(t *testing.T) {
+	pm := newIdleTestManager(t)
+	processManager := &refreshCounter{}
+	for instanceID, warm := range map[string]bool{"manifest-warm": true, "overridden": true, "warmup-only": false} {
+		if err := PackageDBInsert(pm.DB, instanceID, instanceID, instanceID, "1.0.0", map[string]bool{}, "", false, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := PackageDBUpdateTTL(pm.DB, instanceID, -time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := PackageDBSetWarm(pm.DB, instanceID, warm, `["/api/items","/api/status"]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An admin's idle timeout takes precedence over the manifest
+	pm.idleTimeouts.Overrides["overridden"] = time.Minute
+
+	// Instances warm by manifest are started before any request
+	instances, err := pm.GetAppInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(map[string][]string)
+	for _, instance := range instances {
+		started[instance.InstanceID] = instance.Warmup
+	}
+	if len(started) != 2 || started["warm"] != nil || len(started["manifest-warm"]) != 2 || started["manifest-warm"][0] != "/api/items" {
+		t.Errorf("Expected warm and manifest-warm to be started, got %v", started)
+	}
+
+	// and never go idle
+	for _, instanceID := range []string{"manifest-warm", "overridden", "warmup-only"} {
+		if err := pm.SetPackageActive(instanceID, processManager); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	idle, err := pm.DeactivateIdle(now, now.Add(time.Hour), processManager)
+	if ids := instanceIDs(idle); err != nil || ids["manifest-warm"] || !ids["overridden"] || !ids["warmup-only"] {
+		t.Errorf("Expected only the instances not kept warm to go idle, got %v: %v", ids, err)
+	}
+}
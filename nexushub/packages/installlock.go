@@ -0,0 +1,77 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// InstallLockFile is the file in the install directory locked by the hub
+// that manages it, so that a second hub started against the same directory
+// fails instead of fighting the first over ports and databases.
+const InstallLockFile = ".nexushub.lock"
+
+// InstallDirLockedError is returned by OpenPackageManager when another
+// process holds the install directory's lock.
+type InstallDirLockedError struct {
+	Dir string
+	// PID is the process holding the lock, or 0 if unknown
+	PID int
+}
+
+func (e *InstallDirLockedError) Error() string {
+	holder := "another process"
+	if e.PID != 0 {
+		holder = fmt.Sprintf("another process (pid %d)", e.PID)
+	}
+	return fmt.Sprintf("install directory %s is in use by %s; is another NexusHub running against it?", e.Dir, holder)
+}
+
+// installLock is a held lock on an install directory. The operating system
+// releases it if the process dies, so a crashed hub never leaves it behind.
+type installLock struct {
+	file *os.File
+}
+
+// lockInstallDir takes the install directory's lock without waiting and
+// records this process's PID in the lock file for the error reported to the
+// next hub.
+func lockInstallDir(dir string) (*installLock, error) {
+	path := filepath.Join(dir, InstallLockFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open install lock %s: %w", path, err)
+	}
+	locked, err := tryLockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock install directory %s: %w", dir, err)
+	}
+	if !locked {
+		contents, _ := os.ReadFile(path)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(contents)))
+		file.Close()
+		return nil, &InstallDirLockedError{Dir: dir, PID: pid}
+	}
+
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &installLock{file: file}, nil
+}
+
+// release unlocks the install directory. The lock file is left in place:
+// deleting it could let two hubs lock different files of the same name.
+func (l *installLock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
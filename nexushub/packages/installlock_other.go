@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package packages
+
+import "os"
+
+// tryLockFile always succeeds on platforms without flock, so the install
+// directory is not protected there.
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package packages
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestInstallDirLock(t *testing.T) {
+	installDir := t.TempDir()
+	pm, err := OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenPackageManager(t.TempDir(), installDir)
+	var locked *InstallDirLockedError
+	if !errors.As(err, &locked) || locked.PID != os.Getpid() {
+		t.Fatalf("Expected the install directory to be locked by this process, got %v", err)
+	}
+
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = OpenPackageManager(t.TempDir(), installDir)
+	if err != nil {
+		t.Fatalf("Expected the install directory to be free once closed, got %v", err)
+	}
+	pm.Close()
+}
@@ -9,17 +9,23 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/applib"
+	"github.com/tomyedwab/yesterday/nexushub/eventauth"
 	httpsproxy_types "github.com/tomyedwab/yesterday/nexushub/httpsproxy/types"
 	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/quotas"
 	"github.com/tomyedwab/yesterday/nexushub/types"
 )
 
 type PackageManager struct {
-	DB         *sqlx.DB
-	pkgDir     string
-	installDir string
+	DB           *sqlx.DB
+	pkgDir       string
+	installDir   string
+	idleTimeouts IdleTimeouts
+	lock         *installLock
 }
 
 func NewPackageManager() (*PackageManager, error) {
@@ -33,9 +39,24 @@ func NewPackageManager() (*PackageManager, error) {
 		installDir = "/usr/local/etc/nexushub/install"
 	}
 
+	return OpenPackageManager(pkgDir, installDir)
+}
+
+// OpenPackageManager returns a package manager for the given package and
+// install directories. Only one package manager may have an install directory
+// open at a time, across processes; opening it again fails with an
+// InstallDirLockedError until the first is closed.
+func OpenPackageManager(pkgDir, installDir string) (*PackageManager, error) {
+	lock, err := lockInstallDir(installDir)
+	if err != nil {
+		return nil, err
+	}
+
 	db := sqlx.MustConnect("sqlite3", path.Join(installDir, "packages.db"))
-	err := PackageDBInit(db)
+	err = PackageDBInit(db)
 	if err != nil {
+		db.Close()
+		lock.release()
 		return nil, err
 	}
 
@@ -43,9 +64,21 @@ func NewPackageManager() (*PackageManager, error) {
 		DB:         db,
 		pkgDir:     pkgDir,
 		installDir: installDir,
+		lock:       lock,
 	}, nil
 }
 
+// Close closes the package database and releases the install directory, so
+// that another hub can open it. Call it once the managed processes have
+// stopped.
+func (pm *PackageManager) Close() error {
+	err := pm.DB.Close()
+	if lockErr := pm.lock.release(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
 func (pm *PackageManager) GetPkgDir() string {
 	return pm.pkgDir
 }
@@ -143,8 +176,10 @@ func (pm *PackageManager) GetPackageByHash(hash string) (*Package, error) {
 	return PackageDBGetByHash(pm.DB, hash)
 }
 
+// GetActivePackages returns the packages that should be running: those
+// requested within their idle timeout and those kept warm.
 func (pm *PackageManager) GetActivePackages() ([]*Package, error) {
-	return PackageDBGetActive(pm.DB)
+	return pm.activePackages(time.Now())
 }
 
 func (pm *PackageManager) InstallPackage(name, hash, instanceID string, processManager httpsproxy_types.ProcessManagerInterface) error {
@@ -164,6 +199,10 @@ func (pm *PackageManager) InstallPackage(name, hash, instanceID string, processM
 	if err != nil {
 		return err
 	}
+	err = pm.retainPackage(pkgPath, instanceID)
+	if err != nil {
+		return err
+	}
 
 	// Read manifest.json in the newly-installed directory
 	manifestPath := filepath.Join(pm.installDir, instanceID, "app", "manifest.json")
@@ -182,17 +221,100 @@ func (pm *PackageManager) InstallPackage(name, hash, instanceID string, processM
 		subscriptionsMap[subscription] = true
 	}
 
-	err = PackageDBInsert(pm.DB, instanceID, hash, manifest.Name, manifest.Version, subscriptionsMap)
+	transport, err := processes.ParseTransport(manifest.Transport)
 	if err != nil {
 		return err
 	}
+	if transport == processes.TransportEmbedded && !applib.IsEmbedded(manifest.Name) {
+		return fmt.Errorf("package %s uses the embedded transport but is not built into this hub", manifest.Name)
+	}
+	if manifest.StaticPath != "" && !filepath.IsLocal(manifest.StaticPath) {
+		return fmt.Errorf("static path %q is not a directory inside the package", manifest.StaticPath)
+	}
+	if _, err := processes.ParseHealthCheck(manifest.HealthCheck); err != nil {
+		return err
+	}
+	healthCheck := ""
+	if manifest.HealthCheck != nil {
+		healthCheckBytes, err := json.Marshal(manifest.HealthCheck)
+		if err != nil {
+			return err
+		}
+		healthCheck = string(healthCheckBytes)
+	}
+	if _, err := quotas.ParsePackageQuota(manifest.Quota); err != nil {
+		return err
+	}
+	for _, path := range manifest.Warmup {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid warmup path %q: expected an absolute path", path)
+		}
+	}
+	if err := eventauth.ValidatePublishers(manifest.Publishers); err != nil {
+		return err
+	}
+	quota := ""
+	if manifest.Quota != nil {
+		quotaBytes, err := json.Marshal(manifest.Quota)
+		if err != nil {
+			return err
+		}
+		quota = string(quotaBytes)
+	}
+
+	err = PackageDBInsert(pm.DB, instanceID, hash, manifest.Name, manifest.Version, subscriptionsMap, manifest.Transport, manifest.RunSelfTest, manifest.StaticPath, healthCheck)
+	if err != nil {
+		return err
+	}
+	if quota != "" {
+		if err := PackageDBSetQuota(pm.DB, instanceID, quota); err != nil {
+			return err
+		}
+	}
+	if manifest.Warm || len(manifest.Warmup) > 0 {
+		warmup := ""
+		if len(manifest.Warmup) > 0 {
+			warmupBytes, err := json.Marshal(manifest.Warmup)
+			if err != nil {
+				return err
+			}
+			warmup = string(warmupBytes)
+		}
+		if err := PackageDBSetWarm(pm.DB, instanceID, manifest.Warm, warmup); err != nil {
+			return err
+		}
+	}
+	if len(manifest.Publishers) > 0 {
+		publishersBytes, err := json.Marshal(manifest.Publishers)
+		if err != nil {
+			return err
+		}
+		if err := PackageDBSetPublishers(pm.DB, instanceID, string(publishersBytes)); err != nil {
+			return err
+		}
+	}
 
 	processManager.Refresh()
 	return nil
 }
 
+// SetPackageActive starts the instance if it isn't running and keeps it
+// running for its idle timeout from now.
 func (pm *PackageManager) SetPackageActive(instanceID string, processManager httpsproxy_types.ProcessManagerInterface) error {
-	err := PackageDBUpdateTTL(pm.DB, instanceID)
+	timeout, _ := pm.IdleTimeout(instanceID)
+	err := PackageDBUpdateTTL(pm.DB, instanceID, timeout)
+	if err != nil {
+		return err
+	}
+	processManager.Refresh()
+	return nil
+}
+
+// UninstallPackage removes an installed instance. The reconciler stops its
+// process on the next refresh; the install directory is left on disk so the
+// instance's database can still be recovered by hand.
+func (pm *PackageManager) UninstallPackage(instanceID string, processManager httpsproxy_types.ProcessManagerInterface) error {
+	err := PackageDBDelete(pm.DB, instanceID)
 	if err != nil {
 		return err
 	}
@@ -200,18 +322,108 @@ func (pm *PackageManager) SetPackageActive(instanceID string, processManager htt
 	return nil
 }
 
+// packageHealthCheck returns the health check settings stored for a
+// package.
+func packageHealthCheck(pkg *Package) (processes.HealthCheck, error) {
+	if pkg.HealthCheckJson == "" {
+		return processes.HealthCheck{}, nil
+	}
+	var config types.PackageHealthCheck
+	if err := json.Unmarshal([]byte(pkg.HealthCheckJson), &config); err != nil {
+		return processes.HealthCheck{}, fmt.Errorf("invalid health check settings: %w", err)
+	}
+	return processes.ParseHealthCheck(&config)
+}
+
+// QuotaInstances returns every installed instance, active or not, with the
+// limits of its package, for quotas.Collector.
+func (pm *PackageManager) QuotaInstances() ([]quotas.Instance, error) {
+	pkgs, err := PackageDBGetAll(pm.DB)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]quotas.Instance, len(pkgs))
+	for i, pkg := range pkgs {
+		var limits quotas.Limits
+		if pkg.QuotaJson != "" {
+			var quota types.PackageQuota
+			if err := json.Unmarshal([]byte(pkg.QuotaJson), &quota); err != nil {
+				return nil, fmt.Errorf("package %s: invalid quota: %w", pkg.InstanceID, err)
+			}
+			if limits, err = quotas.ParsePackageQuota(&quota); err != nil {
+				return nil, fmt.Errorf("package %s: %w", pkg.InstanceID, err)
+			}
+		}
+		instances[i] = quotas.Instance{
+			InstanceID:    pkg.InstanceID,
+			Name:          pkg.Name,
+			Subscriptions: pkg.Subscriptions,
+			DatabaseDir:   filepath.Join(pm.installDir, pkg.InstanceID, "db"),
+			Limits:        limits,
+		}
+	}
+	return instances, nil
+}
+
+// PublishInstances returns every installed instance, active or not, with the
+// publishers its manifest lists, for eventauth.Authorizer.
+func (pm *PackageManager) PublishInstances() ([]eventauth.Instance, error) {
+	pkgs, err := PackageDBGetAll(pm.DB)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]eventauth.Instance, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if pkg.PublishersJson == "" {
+			continue
+		}
+		instance := eventauth.Instance{InstanceID: pkg.InstanceID}
+		if err := json.Unmarshal([]byte(pkg.PublishersJson), &instance.Publishers); err != nil {
+			return nil, fmt.Errorf("package %s: invalid publishers: %w", pkg.InstanceID, err)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
 func (pm *PackageManager) GetAppInstances() ([]processes.AppInstance, error) {
-	packages, err := PackageDBGetActive(pm.DB)
+	packages, err := pm.activePackages(time.Now())
 	if err != nil {
 		return nil, err
 	}
 	ret := make([]processes.AppInstance, len(packages))
 	for i, pkg := range packages {
+		transport, err := processes.ParseTransport(pkg.Transport)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", pkg.InstanceID, err)
+		}
+		healthCheck, err := packageHealthCheck(pkg)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", pkg.InstanceID, err)
+		}
+		var warmup []string
+		if pkg.WarmupJson != "" {
+			if err := json.Unmarshal([]byte(pkg.WarmupJson), &warmup); err != nil {
+				return nil, fmt.Errorf("package %s: invalid warmup: %w", pkg.InstanceID, err)
+			}
+		}
 		ret[i] = processes.AppInstance{
 			InstanceID:    pkg.InstanceID,
-			HostName:      "",
+			HostName:      pkg.HostName,
 			PkgPath:       filepath.Join(pm.installDir, pkg.InstanceID),
 			Subscriptions: pkg.Subscriptions,
+			Transport:     transport,
+			RunSelfTest:   pkg.RunSelfTest,
+			Sandbox:       pkg.Sandbox,
+			HealthCheck:   healthCheck,
+			Warmup:        warmup,
+			PackageHash:   pkg.PackageHash,
+		}
+		if transport == processes.TransportEmbedded {
+			ret[i].EmbeddedApp = pkg.Name
+		}
+		if pkg.StaticPath != "" {
+			ret[i].StaticPath = filepath.Join(pm.installDir, pkg.InstanceID, "app", pkg.StaticPath)
 		}
 	}
 	return ret, nil
@@ -0,0 +1,336 @@
+package processes
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// guestTLSDir is where an instance's TLS files appear inside the
+	// application VM, next to the internal secret.
+	guestTLSDir = "/secrets/tls"
+
+	caCertFile     = "ca.crt"
+	caKeyFile      = "ca.key"
+	serverCertFile = "server.crt"
+	serverKeyFile  = "server.key"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// backendCertValidity bounds how long an instance can run on one set of
+	// certificates; they are issued again whenever it restarts
+	backendCertValidity = 365 * 24 * time.Hour
+)
+
+// ErrBackendTLSDisabled is returned by RotateBackendCA when the hub talks to
+// instances over plain HTTP.
+var ErrBackendTLSDisabled = errors.New("backend mTLS is disabled")
+
+// CertificateAuthority is the hub's internal CA for mutual TLS between the
+// hub and its instances. For every TCP instance it starts, the hub issues a
+// server certificate the instance serves with and a client certificate the
+// hub presents to it; applib refuses connections without the latter, so
+// other processes on the host cannot reach the instance's endpoints
+// directly.
+type CertificateAuthority struct {
+	mu      sync.Mutex
+	dir     string // Where the CA is kept; empty for an in-memory CA
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// BackendTLSFromEnv returns the CA kept in dir, creating it on first start,
+// unless BACKEND_MTLS is "off", e.g. for local development, in which case it
+// returns nil and TCP instances are served over plain HTTP.
+func BackendTLSFromEnv(dir string) (*CertificateAuthority, error) {
+	switch value := os.Getenv("BACKEND_MTLS"); value {
+	case "", "on":
+		return LoadOrCreateCA(dir)
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid BACKEND_MTLS %q: expected \"on\" or \"off\"", value)
+	}
+}
+
+// LoadOrCreateCA loads the CA kept in dir, creating and saving a new one if
+// there is none.
+func LoadOrCreateCA(dir string) (*CertificateAuthority, error) {
+	ca := &CertificateAuthority{dir: dir}
+	certPEM, certErr := os.ReadFile(filepath.Join(dir, caCertFile))
+	keyPEM, keyErr := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		if err := ca.Rotate(); err != nil {
+			return nil, err
+		}
+		return ca, nil
+	}
+	if err := errors.Join(certErr, keyErr); err != nil {
+		return nil, fmt.Errorf("failed to read internal CA: %w", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid internal CA in %s: %w", dir, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid internal CA in %s: %w", dir, err)
+	}
+	ca.cert, ca.key, ca.certPEM = cert, pair.PrivateKey.(crypto.Signer), certPEM
+	return ca, nil
+}
+
+// NewCertificateAuthority returns a CA that is only kept in memory.
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	ca := &CertificateAuthority{}
+	if err := ca.Rotate(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Rotate replaces the CA with a new one, saving it if the CA is kept on
+// disk. Instances started afterwards get certificates from the new CA;
+// running instances keep theirs, and keep working, until they restart.
+func (ca *CertificateAuthority) Rotate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := certificateTemplate("NexusHub internal CA", caValidity)
+	if err != nil {
+		return err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("failed to create internal CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if ca.dir != "" {
+		keyPEM, err := encodeKey(key)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(ca.dir, 0700); err != nil {
+			return fmt.Errorf("failed to create internal CA directory: %w", err)
+		}
+		// The key is written first, so a crash in between leaves a pair
+		// that fails to load rather than a certificate for a lost key
+		if err := writeFileAtomic(filepath.Join(ca.dir, caKeyFile), keyPEM); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(ca.dir, caCertFile), certPEM); err != nil {
+			return err
+		}
+	}
+
+	ca.mu.Lock()
+	ca.cert, ca.key, ca.certPEM = cert, key, certPEM
+	ca.mu.Unlock()
+	return nil
+}
+
+// CertPEM returns the CA certificate.
+func (ca *CertificateAuthority) CertPEM() []byte {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return ca.certPEM
+}
+
+// backendCredentials are the certificates issued for one run of an
+// instance.
+type backendCredentials struct {
+	caPEM         []byte
+	serverCertPEM []byte
+	serverKeyPEM  []byte
+	// client is the configuration the hub dials the instance with
+	client *tls.Config
+}
+
+// issueBackendCredentials issues a server certificate for the instance's
+// process, valid for the instance ID and localhost, and a client certificate
+// with the instance ID as its common name for the hub to present to it.
+func (ca *CertificateAuthority) issueBackendCredentials(instanceID string) (*backendCredentials, error) {
+	ca.mu.Lock()
+	caCert, caKey, caPEM := ca.cert, ca.key, ca.certPEM
+	ca.mu.Unlock()
+
+	server, err := certificateTemplate(instanceID, backendCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	server.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	server.DNSNames = []string{instanceID, "localhost"}
+	server.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	serverCertPEM, serverKey, err := issueCertificate(server, caCert, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue server certificate for %s: %w", instanceID, err)
+	}
+	serverKeyPEM, err := encodeKey(serverKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := certificateTemplate(instanceID, backendCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	client.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	clientCertPEM, clientKey, err := issueCertificate(client, caCert, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue client certificate for %s: %w", instanceID, err)
+	}
+	clientKeyPEM, err := encodeKey(clientKey)
+	if err != nil {
+		return nil, err
+	}
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	return &backendCredentials{
+		caPEM:         caPEM,
+		serverCertPEM: serverCertPEM,
+		serverKeyPEM:  serverKeyPEM,
+		client: &tls.Config{
+			Certificates: []tls.Certificate{clientCert},
+			RootCAs:      roots,
+			ServerName:   instanceID,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}, nil
+}
+
+func certificateTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"NexusHub"}},
+		// Allow for clocks that are slightly behind
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(validity),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+// issueCertificate signs a certificate for a new key with the CA.
+func issueCertificate(template, caCert *x509.Certificate, caKey crypto.Signer) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key, nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFileAtomic replaces a file readable only by its owner.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeTLSFiles writes the CA certificate and the instance's server
+// certificate and key where the instance finds them, see tlsEnv.
+func writeTLSFiles(instance AppInstance, credentials *backendCredentials) error {
+	dir := filepath.Join(instance.PkgPath, guestTLSDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create TLS directory: %w", err)
+	}
+	for name, data := range map[string][]byte{
+		caCertFile:     credentials.caPEM,
+		serverCertFile: credentials.serverCertPEM,
+		serverKeyFile:  credentials.serverKeyPEM,
+	} {
+		if err := writeFileAtomic(filepath.Join(dir, name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlsEnv returns the environment telling applib to serve with the files
+// written by writeTLSFiles and require the hub's client certificate.
+func tlsEnv(instance AppInstance) []string {
+	return []string{
+		"TLS_CERT_FILE=" + processPath(instance, guestTLSDir+"/"+serverCertFile),
+		"TLS_KEY_FILE=" + processPath(instance, guestTLSDir+"/"+serverKeyFile),
+		"TLS_CLIENT_CA_FILE=" + processPath(instance, guestTLSDir+"/"+caCertFile),
+	}
+}
+
+// backendTLS maps the backend hosts (see BackendHost) of instances served
+// over TLS to the configuration DialBackend connects to them with.
+var backendTLS sync.Map
+
+// RegisterBackendTLS makes DialBackend connect to host over TLS with the
+// given configuration. The process manager registers every TCP instance it
+// starts while backend mTLS is enabled.
+func RegisterBackendTLS(host string, config *tls.Config) {
+	backendTLS.Store(strings.ToLower(host), config)
+}
+
+// UnregisterBackendTLS forgets the TLS configuration of a backend host that
+// is no longer in use.
+func UnregisterBackendTLS(host string) {
+	backendTLS.Delete(strings.ToLower(host))
+}
+
+// RotateBackendCA replaces the internal CA, see CertificateAuthority.Rotate.
+func (pm *ProcessManager) RotateBackendCA() error {
+	if pm.ca == nil {
+		return ErrBackendTLSDisabled
+	}
+	if err := pm.ca.Rotate(); err != nil {
+		return err
+	}
+	pm.logger.Info("Rotated the internal CA; running instances switch to it when they restart")
+	return nil
+}
@@ -0,0 +1,158 @@
+package processes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// startTLSBackend serves the instance's server certificate from credentials,
+// requiring client certificates from the same CA like applib does, and
+// returns the instance and port the hub would dial it with.
+func startTLSBackend(t *testing.T, instance AppInstance, credentials *backendCredentials) (*AppInstance, int) {
+	t.Helper()
+	cert, err := tls.X509KeyPair(credentials.serverCertPEM, credentials.serverKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(credentials.caPEM)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+	t.Cleanup(func() { UnregisterBackendTLS(instance.BackendHost(port)) })
+	return &instance, port
+}
+
+func getBackend(instance *AppInstance, port int) (string, error) {
+	client := &http.Client{Transport: NewBackendTransport(&net.Dialer{})}
+	resp, err := client.Get(instance.BackendURL(port) + "/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestBackendMutualTLS(t *testing.T) {
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := ca.issueBackendCredentials("inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, port := startTLSBackend(t, AppInstance{InstanceID: "inst"}, credentials)
+
+	// Without the client certificate the backend cannot be reached
+	if _, err := getBackend(instance, port); err == nil {
+		t.Error("Expected plain HTTP to a TLS backend to fail")
+	}
+
+	RegisterBackendTLS(instance.BackendHost(port), credentials.client)
+	if peer, err := getBackend(instance, port); err != nil || peer != "inst" {
+		t.Errorf("Expected the backend to accept the hub's certificate, got %q: %v", peer, err)
+	}
+
+	// Certificates from another CA are rejected in both directions
+	other, err := NewCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCredentials, err := other.issueBackendCredentials("inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterBackendTLS(instance.BackendHost(port), otherCredentials.client)
+	if _, err := getBackend(instance, port); err == nil {
+		t.Error("Expected a client certificate from another CA to be rejected")
+	}
+	otherClient := otherCredentials.client.Clone()
+	otherClient.RootCAs = credentials.client.RootCAs
+	RegisterBackendTLS(instance.BackendHost(port), otherClient)
+	if _, err := getBackend(instance, port); err == nil {
+		t.Error("Expected a client certificate from another CA to be rejected by the backend")
+	}
+
+	// The server certificate must be for the instance being dialed
+	impostor, port := startTLSBackend(t, AppInstance{InstanceID: "inst2"}, credentials)
+	inst2Credentials, err := ca.issueBackendCredentials("inst2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterBackendTLS(impostor.BackendHost(port), inst2Credentials.client)
+	if _, err := getBackend(impostor, port); err == nil {
+		t.Error("Expected a server certificate for another instance to be rejected")
+	}
+}
+
+func TestRotateCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ca.issueBackendCredentials("inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.CertPEM(), ca.CertPEM()) {
+		t.Error("Expected the CA to be kept across restarts")
+	}
+
+	if err := ca.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(before.caPEM, ca.CertPEM()) {
+		t.Fatal("Expected a new CA")
+	}
+	if loaded, err := LoadOrCreateCA(dir); err != nil || !bytes.Equal(loaded.CertPEM(), ca.CertPEM()) {
+		t.Errorf("Expected the rotated CA to be saved: %v", err)
+	}
+
+	// A restarted instance gets certificates from the new CA, which the
+	// old ones do not work with
+	after, err := ca.issueBackendCredentials("inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, port := startTLSBackend(t, AppInstance{InstanceID: "inst"}, after)
+	RegisterBackendTLS(instance.BackendHost(port), before.client)
+	if _, err := getBackend(instance, port); err == nil {
+		t.Error("Expected certificates from the old CA to be rejected")
+	}
+	RegisterBackendTLS(instance.BackendHost(port), after.client)
+	if peer, err := getBackend(instance, port); err != nil || peer != "inst" {
+		t.Errorf("Expected certificates from the new CA to be accepted, got %q: %v", peer, err)
+	}
+}
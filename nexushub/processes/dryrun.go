@@ -0,0 +1,123 @@
+package processes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// dryRunEvent is an event sent to an instance's /internal/events/dry-run,
+// in the form events are delivered to /internal/publish_events.
+type dryRunEvent struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// DryRunEvents applies events, in order, to every running instance
+// subscribed to them without committing anything, and returns the outcome
+// of each event on each instance. No event IDs are assigned and instances'
+// event numbers do not advance.
+//
+// Instances apply the dry run to their current state, so an instance that
+// is behind on events, or not running, is checked against older state or
+// not at all.
+func (pm *ProcessManager) DryRunEvents(ctx context.Context, events []types.EventPublishData) ([]types.EventDryRunResult, error) {
+	results := make([]types.EventDryRunResult, len(events))
+	for i, event := range events {
+		results[i] = types.EventDryRunResult{
+			ClientID:      event.ClientID,
+			Type:          event.Type,
+			DryRunOutcome: types.DryRunOutcome{Status: types.DryRunNoop},
+			Instances:     []types.InstanceDryRunOutcome{},
+		}
+	}
+
+	pm.mu.RLock()
+	processes := make([]*ManagedProcess, 0, len(pm.actualState))
+	for _, process := range pm.actualState {
+		if state := process.GetState(); state == StateRunning || state == StateUnhealthy {
+			processes = append(processes, process)
+		}
+	}
+	pm.mu.RUnlock()
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].Instance.InstanceID < processes[j].Instance.InstanceID
+	})
+
+	for _, process := range processes {
+		// Each instance only sees the events it subscribes to
+		var indexes []int
+		var batch []dryRunEvent
+		for i, event := range events {
+			if process.Instance.Subscriptions[event.Type] {
+				indexes = append(indexes, i)
+				batch = append(batch, dryRunEvent{Type: event.Type, Data: string(event.Data)})
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		outcomes, err := pm.dryRunRequest(ctx, process, batch)
+		if err != nil {
+			return nil, err
+		}
+		for j, outcome := range outcomes {
+			result := &results[indexes[j]]
+			result.Instances = append(result.Instances, types.InstanceDryRunOutcome{
+				InstanceID:    process.Instance.InstanceID,
+				DryRunOutcome: outcome,
+			})
+			switch {
+			case outcome.Status == types.DryRunRejected && result.Status != types.DryRunRejected:
+				result.DryRunOutcome = types.DryRunOutcome{
+					Status: types.DryRunRejected,
+					Error:  fmt.Sprintf("%s: %s", process.Instance.InstanceID, outcome.Error),
+				}
+			case outcome.Status == types.DryRunAccepted && result.Status == types.DryRunNoop:
+				result.Status = types.DryRunAccepted
+			}
+		}
+	}
+	return results, nil
+}
+
+// dryRunRequest sends events to the /internal/events/dry-run endpoint of
+// the instance's process.
+func (pm *ProcessManager) dryRunRequest(ctx context.Context, process *ManagedProcess, events []dryRunEvent) ([]types.DryRunOutcome, error) {
+	id := process.Instance.InstanceID
+	body, err := json.Marshal(struct {
+		Events []dryRunEvent `json:"events"`
+	}{Events: events})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, process.Instance.BackendURL(process.Port)+"/internal/events/dry-run", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+pm.secrets.Current())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := BackendClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("dry run request for %s failed: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dry run request for %s returned status %s", id, resp.Status)
+	}
+	var response struct {
+		Outcomes []types.DryRunOutcome `json:"outcomes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode dry run outcomes of %s: %w", id, err)
+	}
+	if len(response.Outcomes) != len(events) {
+		return nil, fmt.Errorf("dry run of %s returned %d outcomes for %d events", id, len(response.Outcomes), len(events))
+	}
+	return response.Outcomes, nil
+}
@@ -0,0 +1,91 @@
+package processes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// newDryRunBackend serves dry runs, accepting events whose data is
+// {"ok":true}, rejecting {"ok":false} and ignoring anything else.
+func newDryRunBackend(t *testing.T, pm *ProcessManager, id string, subscriptions ...string) *[]dryRunEvent {
+	var received []dryRunEvent
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/events/dry-run" || r.Header.Get("Authorization") != "Bearer "+pm.secrets.Current() {
+			http.NotFound(w, r)
+			return
+		}
+		var request struct {
+			Events []dryRunEvent `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		received = request.Events
+		outcomes := []types.DryRunOutcome{}
+		for _, event := range request.Events {
+			switch event.Data {
+			case `{"ok":true}`:
+				outcomes = append(outcomes, types.DryRunOutcome{Status: types.DryRunAccepted})
+			case `{"ok":false}`:
+				outcomes = append(outcomes, types.DryRunOutcome{Status: types.DryRunRejected, Error: "not ok"})
+			default:
+				outcomes = append(outcomes, types.DryRunOutcome{Status: types.DryRunNoop})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"outcomes": outcomes})
+	}))
+	t.Cleanup(backend.Close)
+
+	process := newLogLevelProcess(t, pm, backend)
+	process.Instance = AppInstance{InstanceID: id, Subscriptions: map[string]bool{}}
+	for _, eventType := range subscriptions {
+		process.Instance.Subscriptions[eventType] = true
+	}
+	pm.mu.Lock()
+	delete(pm.actualState, "app")
+	pm.actualState[id] = process
+	pm.mu.Unlock()
+	return &received
+}
+
+func TestDryRunEventsAggregatesInstances(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	receivedA := newDryRunBackend(t, pm, "a", "Add", "Delete")
+	receivedB := newDryRunBackend(t, pm, "b", "Add")
+
+	results, err := pm.DryRunEvents(context.Background(), []types.EventPublishData{
+		{ClientID: "1", Type: "Add", Data: json.RawMessage(`{"ok":true}`)},
+		{ClientID: "2", Type: "Delete", Data: json.RawMessage(`{"ok":false}`)},
+		{ClientID: "3", Type: "Add", Data: json.RawMessage(`{}`)},
+		{ClientID: "4", Type: "Other", Data: json.RawMessage(`{"ok":true}`)},
+	})
+	if err != nil {
+		t.Fatalf("DryRunEvents returned error: %v", err)
+	}
+
+	// Instances only see the events they subscribe to
+	if len(*receivedA) != 3 || len(*receivedB) != 2 || (*receivedB)[1].Data != `{}` {
+		t.Errorf("Unexpected events sent, a: %+v, b: %+v", *receivedA, *receivedB)
+	}
+	expected := []struct {
+		status    types.DryRunStatus
+		instances int
+	}{
+		{types.DryRunAccepted, 2},
+		{types.DryRunRejected, 1},
+		{types.DryRunNoop, 2},
+		{types.DryRunNoop, 0},
+	}
+	for i, result := range results {
+		if result.Status != expected[i].status || len(result.Instances) != expected[i].instances {
+			t.Errorf("Event %s: expected %s on %d instances, got %+v", result.ClientID, expected[i].status, expected[i].instances, result)
+		}
+	}
+	if !strings.HasPrefix(results[1].Error, "a: not ok") {
+		t.Errorf("Expected the rejection to name the instance, got %q", results[1].Error)
+	}
+}
@@ -0,0 +1,170 @@
+package processes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tomyedwab/yesterday/applib"
+)
+
+// embeddedHostSuffix marks the placeholder host names used in URLs for
+// embedded instances, see BackendHost.
+const embeddedHostSuffix = ".embedded"
+
+// memListener is the in-memory listener an embedded instance is served on.
+// Its connections are the server ends of pipes whose client ends dial
+// returns.
+type memListener struct {
+	instanceID string
+	conns      chan net.Conn
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+func newMemListener(instanceID string) *memListener {
+	return &memListener{
+		instanceID: instanceID,
+		conns:      make(chan net.Conn),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr(l.instanceID + embeddedHostSuffix)
+}
+
+// dial connects to the listener, waiting until ctx is done for the server
+// to accept the connection.
+func (l *memListener) dial(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+	var err error
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		err = fmt.Errorf("embedded instance %s is not serving", l.instanceID)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	server.Close()
+	client.Close()
+	return nil, err
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return "memory" }
+func (a memAddr) String() string  { return string(a) }
+
+// backendListeners maps the instance IDs of running embedded instances to
+// their listeners.
+var backendListeners sync.Map
+
+// dialEmbedded connects to the listener of a running embedded instance.
+func dialEmbedded(ctx context.Context, instanceID string) (net.Conn, error) {
+	listener, ok := backendListeners.Load(instanceID)
+	if !ok {
+		return nil, fmt.Errorf("no embedded instance %s is running", instanceID)
+	}
+	return listener.(*memListener).dial(ctx)
+}
+
+// embeddedProcess stands in for the process of an embedded instance: it
+// serves the application until stopped like a subprocess would be, draining
+// in-flight requests when interrupted.
+type embeddedProcess struct {
+	server   *http.Server
+	listener *memListener
+
+	shutdownOnce sync.Once
+	drained      chan struct{} // Closed once the server has shut down
+}
+
+// Signal starts a graceful shutdown of the server, whatever the signal.
+func (p *embeddedProcess) Signal(os.Signal) error {
+	p.shutdownOnce.Do(func() {
+		go func() {
+			p.server.Shutdown(context.Background())
+			close(p.drained)
+		}()
+	})
+	return nil
+}
+
+// Kill closes the server and its connections at once.
+func (p *embeddedProcess) Kill() error {
+	err := p.server.Close()
+	p.Signal(os.Kill)
+	return err
+}
+
+// serve serves the application until the server is shut down or closed and
+// has finished.
+func (p *embeddedProcess) serve() error {
+	err := p.server.Serve(p.listener)
+	backendListeners.CompareAndDelete(p.listener.instanceID, p.listener)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-p.drained
+	return nil
+}
+
+// launchEmbedded runs an embedded instance in the hub's own process, like
+// launchProcess runs a subprocess.
+func (pm *ProcessManager) launchEmbedded(ctx context.Context, instance AppInstance, previous *ManagedProcess) error {
+	fail := func(err error) error {
+		pm.logger.Error("Failed to start embedded instance", "instanceID", instance.InstanceID, "app", instance.EmbeddedApp, "error", err)
+		pm.mu.Lock()
+		if proc, ok := pm.actualState[instance.InstanceID]; ok {
+			proc.UpdateState(StateFailed)
+		}
+		pm.mu.Unlock()
+		return err
+	}
+
+	// The application reads the internal secret from the same file as a
+	// subprocess would, so it sees rotations
+	if err := writeSecretFile(instance, pm.secrets.Current()); err != nil {
+		return fail(err)
+	}
+	server, err := applib.StartEmbedded(instance.EmbeddedApp, applib.EmbeddedConfig{
+		InstanceID:   instance.InstanceID,
+		DatabasePath: filepath.Join(instance.PkgPath, "db", "app.sqlite"),
+		SecretFile:   secretFilePath(instance),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	listener := newMemListener(instance.InstanceID)
+	process := &embeddedProcess{server: server, listener: listener, drained: make(chan struct{})}
+	backendListeners.Store(instance.InstanceID, listener)
+	mp := newProcessEntry(instance, process, 0, pm.clock)
+	pm.addProcess(mp, previous)
+	pm.logger.Info("Embedded instance started", "instanceID", instance.InstanceID, "app", instance.EmbeddedApp)
+
+	pm.TriggerHealthCheck()
+	pm.watchExit(ctx, mp, process.serve)
+	return nil
+}
@@ -1,14 +1,102 @@
 package processes
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/tomyedwab/yesterday/nexushub/types"
 )
 
+// defaultHealthCheckPath is requested by health checks of instances whose
+// package doesn't set a path.
+const defaultHealthCheckPath = "/api/status"
+
+// maxHealthCheckBody is the most of a health check response that is read.
+const maxHealthCheckBody = 1 << 20
+
+// ErrHealthCheckNotFound is wrapped by health check errors for a 404 Not
+// Found response, which usually means the check is requesting the wrong path
+// rather than that the app is unhealthy.
+var ErrHealthCheckNotFound = errors.New("health check path not found")
+
+// HealthCheck configures the health checks of an instance. The zero value
+// expects 200 OK from /api/status.
+type HealthCheck struct {
+	Path           string        // Path to request; empty means /api/status.
+	ExpectedStatus []int         // Healthy status codes; empty means just 200.
+	BodyContains   string        // Text the response body must contain, if set.
+	JSONField      string        // Dot-separated path of a field the JSON response must have, not null or false, if set.
+	Timeout        time.Duration // Request timeout; zero means the checker's default.
+}
+
+// ParseHealthCheck validates a package's health check settings. A nil
+// config gives the default health check.
+func ParseHealthCheck(config *types.PackageHealthCheck) (HealthCheck, error) {
+	if config == nil {
+		return HealthCheck{}, nil
+	}
+	check := HealthCheck{
+		Path:           config.Path,
+		ExpectedStatus: config.ExpectedStatus,
+		BodyContains:   config.BodyContains,
+		JSONField:      config.JSONField,
+	}
+	if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+		return HealthCheck{}, fmt.Errorf("health check path %q does not start with /", check.Path)
+	}
+	for _, status := range check.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return HealthCheck{}, fmt.Errorf("invalid health check status %d", status)
+		}
+	}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil || timeout <= 0 {
+			return HealthCheck{}, fmt.Errorf("invalid health check timeout %q", config.Timeout)
+		}
+		check.Timeout = timeout
+	}
+	return check, nil
+}
+
+func (check *HealthCheck) path() string {
+	if check.Path == "" {
+		return defaultHealthCheckPath
+	}
+	return check.Path
+}
+
+func (check *HealthCheck) expectsStatus(status int) bool {
+	if len(check.ExpectedStatus) == 0 {
+		return status == http.StatusOK
+	}
+	return slices.Contains(check.ExpectedStatus, status)
+}
+
+// hasJSONField reports whether body is JSON with a value other than null or
+// false at the dot-separated path.
+func hasJSONField(body []byte, path string) bool {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return false
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return false
+		}
+		value = object[key]
+	}
+	return value != nil && value != false
+}
+
 // HealthChecker defines the interface for performing health checks on a managed process.
 type HealthChecker interface {
 	// Check performs a health check on the given process.
@@ -19,53 +107,121 @@ type HealthChecker interface {
 }
 
 // HTTPHealthChecker implements HealthChecker using HTTP GET requests.
-// It checks the /api/status endpoint of a subprocess.
+// It checks the /api/status endpoint of a subprocess, or whatever the
+// instance's HealthCheck configures.
 type HTTPHealthChecker struct {
 	client         *http.Client
 	requestTimeout time.Duration // Timeout for a single HTTP health check request
 }
 
 // NewHTTPHealthChecker creates a new HTTPHealthChecker.
-// requestTimeout specifies the timeout for each health check HTTP request.
+// requestTimeout specifies the timeout for each health check HTTP request
+// of instances that don't set their own.
 func NewHTTPHealthChecker(requestTimeout time.Duration) *HTTPHealthChecker {
 	return &HTTPHealthChecker{
 		client: &http.Client{
-			Timeout: requestTimeout,
+			Transport: BackendClient.Transport,
 		},
 		requestTimeout: requestTimeout,
 	}
 }
 
 // Check performs an HTTP health check on the given ManagedProcess.
-// It targets http://localhost:<PORT>/api/status.
+// It targets the instance's health check path on the process's port or, for
+// socket-mode instances, its unix socket. The event ID is read from the
+// response's current_event_id; a custom path that doesn't report one keeps
+// the process's last known event ID.
 func (h *HTTPHealthChecker) Check(process *ManagedProcess) (ProcessState, int, error) {
-	if process.Port <= 0 {
-		return StateFailed, -1, fmt.Errorf("invalid port %d for health check on instance %s", process.Port, process.Instance.InstanceID)
+	id := process.Instance.InstanceID
+	if process.Port <= 0 && !process.Instance.UsesSocket() && !process.Instance.Embedded() {
+		return StateFailed, -1, fmt.Errorf("invalid port %d for health check on instance %s", process.Port, id)
 	}
 
-	url := fmt.Sprintf("http://localhost:%d/api/status", process.Port)
+	check := &process.Instance.HealthCheck
+	url := process.Instance.BackendURL(process.Port) + check.path()
+	timeout := h.requestTimeout
+	if check.Timeout > 0 {
+		timeout = check.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return StateFailed, -1, fmt.Errorf("failed to create health check request for %s: %w", process.Instance.InstanceID, err)
+		return StateFailed, -1, fmt.Errorf("failed to create health check request for %s: %w", id, err)
 	}
 
 	resp, err := h.client.Do(req)
 	if err != nil {
 		// Network error, timeout, connection refused, etc.
-		return StateUnhealthy, -1, fmt.Errorf("health check HTTP request for %s failed: %w", process.Instance.InstanceID, err)
+		return StateUnhealthy, -1, fmt.Errorf("health check HTTP request for %s failed: %w", id, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
+	if !check.expectsStatus(resp.StatusCode) {
+		// An unexpected status code indicates an issue with the service itself,
+		// unless the path doesn't exist
+		if resp.StatusCode == http.StatusNotFound {
+			return StateUnhealthy, -1, fmt.Errorf("health check for %s at %s returned status %s: %w", id, url, resp.Status, ErrHealthCheckNotFound)
+		}
+		return StateUnhealthy, -1, fmt.Errorf("health check for %s at %s returned status %s", id, url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+	if err != nil {
+		return StateUnhealthy, -1, fmt.Errorf("failed to read health check response for %s: %w", id, err)
+	}
+	if check.BodyContains != "" && !strings.Contains(string(body), check.BodyContains) {
+		return StateUnhealthy, -1, fmt.Errorf("health check response for %s does not contain %q", id, check.BodyContains)
+	}
+	if check.JSONField != "" && !hasJSONField(body, check.JSONField) {
+		return StateUnhealthy, -1, fmt.Errorf("health check response for %s has no field %s", id, check.JSONField)
+	}
+
+	if check.Path == "" {
 		var statusInfo types.ApplicationStatusInfo
-		err = json.NewDecoder(resp.Body).Decode(&statusInfo)
-		if err != nil {
-			return StateUnhealthy, -1, fmt.Errorf("failed to decode health check response for %s: %w", process.Instance.InstanceID, err)
+		if err := json.Unmarshal(body, &statusInfo); err != nil {
+			return StateUnhealthy, -1, fmt.Errorf("failed to decode health check response for %s: %w", id, err)
 		}
 		return StateRunning, statusInfo.CurrentEventId, nil // Healthy
 	}
+	var statusInfo struct {
+		CurrentEventId *int `json:"current_event_id"`
+	}
+	if json.Unmarshal(body, &statusInfo) == nil && statusInfo.CurrentEventId != nil {
+		return StateRunning, *statusInfo.CurrentEventId, nil
+	}
+	return StateRunning, process.GetEventId(), nil
+}
+
+// recordHealthCheckResult counts consecutive health checks failing with the
+// same error. Once the health check path has answered 404 Not Found
+// consecutiveFailures times in a row the check is probably misconfigured:
+// this is noted in the instance's history and logged once, and the process
+// is no longer restarted for being unhealthy, which would not help. It
+// reports whether the health check is considered misconfigured.
+func (pm *ProcessManager) recordHealthCheckResult(process *ManagedProcess, err error) bool {
+	process.mu.Lock()
+	defer process.mu.Unlock()
+	if err == nil {
+		process.healthFailure = ""
+		process.healthFailures = 0
+		process.healthMisconfigured = false
+		return false
+	}
 
-	// Non-200 status code indicates an issue with the service itself.
-	return StateUnhealthy, -1, fmt.Errorf("health check for %s at %s returned status %s", process.Instance.InstanceID, url, resp.Status)
+	if err.Error() == process.healthFailure {
+		process.healthFailures++
+	} else {
+		process.healthFailure = err.Error()
+		process.healthFailures = 1
+		process.healthMisconfigured = false
+	}
+	if errors.Is(err, ErrHealthCheckNotFound) && process.healthFailures == pm.consecutiveFailures {
+		process.healthMisconfigured = true
+		path := process.Instance.HealthCheck.path()
+		process.appendHistoryLocked(process.State, fmt.Sprintf("health check misconfigured? %s returned 404 Not Found %d times", path, process.healthFailures))
+		pm.logger.Error("Health check misconfigured? Not restarting the process", "instanceID", process.Instance.InstanceID, "path", path, "failures", process.healthFailures)
+	}
+	return process.healthMisconfigured
 }
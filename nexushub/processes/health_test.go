@@ -0,0 +1,355 @@
+package processes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// unhealthyChecker reports every process as unhealthy
+type unhealthyChecker struct{}
+
+func (unhealthyChecker) Check(process *ManagedProcess) (ProcessState, int, error) {
+	return StateUnhealthy, 0, nil
+}
+
+func TestUnhealthyDurationIgnoresClockSteps(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	portManager, err := NewPortManager(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider:    staticInstances{},
+		PortManager:         portManager,
+		HealthChecker:       unhealthyChecker{},
+		HealthCheckInterval: time.Second,
+		ConsecutiveFailures: 3,
+		Logger:              slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:               fake,
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app"}, Port: 20001, State: StateRunning, clock: fake}
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+
+	pm.checkAndUpdateHealth(context.Background(), process)
+	if state := process.GetState(); state != StateUnhealthy {
+		t.Fatalf("Expected the process to be unhealthy, got %s", state)
+	}
+
+	// The wall clock jumping an hour ahead must not trigger a restart
+	fake.Step(time.Hour)
+	pm.checkAndUpdateHealth(context.Background(), process)
+	if state := process.GetState(); state != StateUnhealthy {
+		t.Errorf("Expected a clock step not to restart the process, got %s", state)
+	}
+	if duration, ok := process.unhealthyFor(); !ok || duration != 0 {
+		t.Errorf("Expected no time to have passed, got %s", duration)
+	}
+
+	fake.Advance(3 * time.Second)
+	if duration, ok := process.unhealthyFor(); !ok || duration != 3*time.Second {
+		t.Errorf("Expected the process to be unhealthy for 3s, got %s", duration)
+	}
+}
+
+// checkBackend runs a health check with the given settings against a
+// backend answering every request with status and body.
+func checkBackend(t *testing.T, check HealthCheck, status int, body string) (ProcessState, int, error) {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != check.path() {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app", HealthCheck: check}, Port: port, currentEventId: 7}
+	return NewHTTPHealthChecker(time.Second).Check(process)
+}
+
+func TestHealthCheckMatchers(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   HealthCheck
+		status  int
+		body    string
+		healthy bool
+		eventID int
+	}{
+		{"default", HealthCheck{}, 200, `{"current_event_id": 3}`, true, 3},
+		{"default non-JSON", HealthCheck{}, 200, `ok`, false, -1},
+		{"custom path keeps event ID", HealthCheck{Path: "/api/healthz"}, 200, `ok`, true, 7},
+		{"custom path reports event ID", HealthCheck{Path: "/api/healthz"}, 200, `{"current_event_id": 9}`, true, 9},
+		{"default status", HealthCheck{Path: "/api/healthz"}, 204, ``, false, -1},
+		{"expected status", HealthCheck{Path: "/api/healthz", ExpectedStatus: []int{200, 204}}, 204, ``, true, 7},
+		{"unexpected status", HealthCheck{ExpectedStatus: []int{204}}, 200, `{}`, false, -1},
+		{"body contains", HealthCheck{Path: "/healthz", BodyContains: "all good"}, 200, `status: all good`, true, 7},
+		{"body missing text", HealthCheck{Path: "/healthz", BodyContains: "all good"}, 200, `status: degraded`, false, -1},
+		{"JSON field", HealthCheck{Path: "/healthz", JSONField: "db.ok"}, 200, `{"db": {"ok": "yes"}}`, true, 7},
+		{"JSON field false", HealthCheck{Path: "/healthz", JSONField: "db.ok"}, 200, `{"db": {"ok": false}}`, false, -1},
+		{"JSON field missing", HealthCheck{Path: "/healthz", JSONField: "db.ok"}, 200, `{"db": true}`, false, -1},
+		{"JSON field not JSON", HealthCheck{Path: "/healthz", JSONField: "db"}, 200, `db`, false, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, eventID, err := checkBackend(t, test.check, test.status, test.body)
+			if healthy := state == StateRunning; healthy != test.healthy || (err == nil) != test.healthy {
+				t.Fatalf("Expected healthy %v, got %s: %v", test.healthy, state, err)
+			}
+			if eventID != test.eventID {
+				t.Errorf("Expected event ID %d, got %d", test.eventID, eventID)
+			}
+		})
+	}
+}
+
+func TestHealthCheckNotFound(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+
+	_, _, err := NewHTTPHealthChecker(time.Second).Check(&ManagedProcess{Instance: AppInstance{InstanceID: "app"}, Port: port})
+	if !errors.Is(err, ErrHealthCheckNotFound) {
+		t.Errorf("Expected a 404 to be reported as ErrHealthCheckNotFound, got %v", err)
+	}
+	// A 404 the package expects is healthy
+	state, _, err := checkBackend(t, HealthCheck{Path: "/gone", ExpectedStatus: []int{404}}, 404, ``)
+	if err != nil || state != StateRunning {
+		t.Errorf("Expected an expected 404 to be healthy, got %s: %v", state, err)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app", HealthCheck: HealthCheck{Timeout: 50 * time.Millisecond}}, Port: port}
+	start := time.Now()
+	state, _, err := NewHTTPHealthChecker(time.Minute).Check(process)
+	if err == nil || state != StateUnhealthy {
+		t.Fatalf("Expected the check to time out, got %s: %v", state, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the instance's timeout to be used, took %s", elapsed)
+	}
+}
+
+func TestHealthCheckOverUnixSocket(t *testing.T) {
+	instance := AppInstance{
+		InstanceID:  "sockhealth",
+		PkgPath:     t.TempDir(),
+		Transport:   TransportUnix,
+		HealthCheck: HealthCheck{Path: "/api/healthz", BodyContains: "ok"},
+	}
+	os.MkdirAll(filepath.Dir(instance.SocketPath()), 0755)
+	listener, err := net.Listen("unix", instance.SocketPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &httptest.Server{
+		Listener: listener,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/healthz" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte("ok"))
+		})},
+	}
+	backend.Start()
+	defer backend.Close()
+	RegisterBackendSocket(instance)
+	defer UnregisterBackendSocket(instance.InstanceID)
+
+	state, _, err := NewHTTPHealthChecker(time.Second).Check(&ManagedProcess{Instance: instance})
+	if err != nil || state != StateRunning {
+		t.Errorf("Expected a healthy socket backend, got %s: %v", state, err)
+	}
+}
+
+func TestParseHealthCheck(t *testing.T) {
+	check, err := ParseHealthCheck(&types.PackageHealthCheck{Path: "/api/healthz", ExpectedStatus: []int{200, 204}, Timeout: "5s"})
+	if err != nil || check.Path != "/api/healthz" || check.Timeout != 5*time.Second || len(check.ExpectedStatus) != 2 {
+		t.Errorf("Unexpected health check %+v: %v", check, err)
+	}
+	if check, err := ParseHealthCheck(nil); err != nil || check.path() != "/api/status" {
+		t.Errorf("Expected the default health check, got %+v: %v", check, err)
+	}
+	for _, config := range []types.PackageHealthCheck{
+		{Path: "api/healthz"},
+		{ExpectedStatus: []int{42}},
+		{Timeout: "soon"},
+		{Timeout: "-1s"},
+	} {
+		if _, err := ParseHealthCheck(&config); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
+
+// notFoundChecker reports every process as unhealthy with a 404
+type notFoundChecker struct{}
+
+func (notFoundChecker) Check(process *ManagedProcess) (ProcessState, int, error) {
+	return StateUnhealthy, -1, fmt.Errorf("health check for %s returned status 404 Not Found: %w", process.Instance.InstanceID, ErrHealthCheckNotFound)
+}
+
+func TestMisconfiguredHealthCheckIsNotRestarted(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	portManager, err := NewPortManager(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider:    staticInstances{},
+		PortManager:         portManager,
+		HealthChecker:       notFoundChecker{},
+		HealthCheckInterval: time.Second,
+		ConsecutiveFailures: 3,
+		Logger:              slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:               fake,
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app"}, Port: 20001, State: StateRunning, clock: fake}
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+
+	for i := 0; i < 10; i++ {
+		pm.checkAndUpdateHealth(context.Background(), process)
+		fake.Advance(time.Second)
+	}
+	if state := process.GetState(); state != StateUnhealthy {
+		t.Errorf("Expected the process to stay unhealthy rather than restart, got %s", state)
+	}
+	var notes int
+	for _, transition := range process.history {
+		if strings.HasPrefix(transition.Reason, "health check misconfigured?") {
+			notes++
+		}
+	}
+	if notes != 1 {
+		t.Errorf("Expected one misconfiguration note in the history, got %+v", process.history)
+	}
+
+	// A different failure is restarted as usual
+	process.UpdateState(StateUnhealthy)
+	pm.healthChecker = unhealthyChecker{}
+	pm.checkAndUpdateHealth(context.Background(), process)
+	if state := process.GetState(); state != StateFailed {
+		t.Errorf("Expected a persistently unhealthy process to be restarted, got %s", state)
+	}
+}
+
+// slowChecker reports every process as healthy after delay, recording the
+// most checks it was running at once.
+type slowChecker struct {
+	delay time.Duration
+
+	mu      sync.Mutex
+	running int
+	most    int
+	checked int
+}
+
+func (c *slowChecker) Check(process *ManagedProcess) (ProcessState, int, error) {
+	c.mu.Lock()
+	c.running++
+	c.most = max(c.most, c.running)
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	c.running--
+	c.checked++
+	c.mu.Unlock()
+	return StateRunning, 1, nil
+}
+
+func TestHealthChecksRunInParallel(t *testing.T) {
+	portManager, err := NewPortManager(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := &slowChecker{delay: 20 * time.Millisecond}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider:       staticInstances{},
+		PortManager:            portManager,
+		HealthChecker:          checker,
+		HealthCheckParallelism: 3,
+		Logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var processes []*ManagedProcess
+	pm.mu.Lock()
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("app%d", i)
+		process := &ManagedProcess{Instance: AppInstance{InstanceID: id}, Port: 20000 + i, State: StateStarting, clock: clock.Real}
+		pm.actualState[id] = process
+		processes = append(processes, process)
+	}
+	pm.mu.Unlock()
+
+	pm.performHealthChecks(context.Background())
+	if checker.checked != 10 {
+		t.Errorf("Expected 10 checks, got %d", checker.checked)
+	}
+	if checker.most != 3 {
+		t.Errorf("Expected at most 3 checks at once, got %d", checker.most)
+	}
+	for _, process := range processes {
+		if state := process.GetState(); state != StateRunning || process.GetEventId() != 1 {
+			t.Errorf("Expected %s to be running at event 1, got %s at %d", process.Instance.InstanceID, state, process.GetEventId())
+		}
+	}
+
+	// No checks start once the manager is stopping
+	checker.checked = 0
+	close(pm.stopChan)
+	pm.performHealthChecks(context.Background())
+	if checker.checked != 0 {
+		t.Errorf("Expected no checks once stopping, got %d", checker.checked)
+	}
+}
@@ -1,5 +1,17 @@
 package processes
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ErrBinaryNotExecutable is returned when starting an instance whose binary
+// is missing or can't be executed.
+var ErrBinaryNotExecutable = errors.New("binary is not executable")
+
 // AppInstance defines the desired state of an application instance.
 // It includes all necessary information to launch and manage a servicehost subprocess.
 type AppInstance struct {
@@ -7,4 +19,77 @@ type AppInstance struct {
 	HostName      string // Hostname for reverse proxy routing.
 	PkgPath       string // File system path to the binary for this instance.
 	Subscriptions map[string]bool
+	Transport     Transport   // How the hub reaches the process; empty means TransportTCP.
+	RunSelfTest   bool        // Whether the process must pass its self-tests before it is running.
+	StaticPath    string      // Directory of static files the proxy serves for this instance, if any.
+	Sandbox       bool        // Whether the process is started with SANDBOX=1, suppressing its cross-service calls.
+	HealthCheck   HealthCheck // How the process's health is checked, from the package manifest.
+	EmbeddedApp   string      // Name the application TransportEmbedded instances run is registered under.
+	Warmup        []string    // Paths requested once the process first passes its health check after starting.
+	// BinaryPath, Args and WorkDir override how the process is started, for
+	// apps that aren't run by krunclient from their package, see Command.
+	BinaryPath string   // Executable to run; empty means <PkgPath>/bin/krunclient(.exe).
+	Args       []string // Extra arguments, passed after the package path and listen address.
+	WorkDir    string   // Working directory of the process; empty means PkgPath.
+	// PackageHash identifies the package the instance was installed from,
+	// if its provider knows it.
+	PackageHash string
+}
+
+// Environment returns the variables the instance's process is started with
+// on top of the hub's own environment, other than the internal secret and
+// TLS settings, which change every time it starts.
+func (instance *AppInstance) Environment() []string {
+	env := []string{
+		fmt.Sprintf("HOST=%s", instance.HostName),
+		fmt.Sprintf("INSTANCE_ID=%s", instance.InstanceID),
+		fmt.Sprintf("LD_LIBRARY_PATH=%s", filepath.Join(instance.PkgPath, "lib")),
+	}
+	if instance.UsesSocket() {
+		env = append(env, fmt.Sprintf("LISTEN_SOCKET=%s", processPath(*instance, guestSocketFile)))
+	}
+	if useShutdownFile {
+		env = append(env, fmt.Sprintf("SHUTDOWN_FILE=%s", processPath(*instance, guestShutdownFile)))
+	}
+	if instance.Sandbox {
+		env = append(env, "SANDBOX=1")
+	}
+	return env
+}
+
+// Command returns the executable, arguments and working directory the
+// instance's process is started with, given the port or "unix" it listens on.
+func (instance *AppInstance) Command(listenArg string) (string, []string, string) {
+	binPath := instance.BinaryPath
+	if binPath == "" {
+		binPath = filepath.Join(instance.PkgPath, "bin", defaultBinaryName)
+	}
+	args := append([]string{instance.PkgPath, listenArg}, instance.Args...)
+	dir := instance.WorkDir
+	if dir == "" {
+		dir = instance.PkgPath
+	}
+	return binPath, args, dir
+}
+
+// sameCommand reports whether two instances' processes are started the same
+// way.
+func sameCommand(a, b AppInstance) bool {
+	return a.BinaryPath == b.BinaryPath && a.WorkDir == b.WorkDir && slices.Equal(a.Args, b.Args)
+}
+
+// checkBinary returns ErrBinaryNotExecutable unless path is a regular file
+// that can be run, see isExecutable.
+func checkBinary(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBinaryNotExecutable, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is not a regular file", ErrBinaryNotExecutable, path)
+	}
+	if !isExecutable(info) {
+		return fmt.Errorf("%w: %s has no execute permission", ErrBinaryNotExecutable, path)
+	}
+	return nil
 }
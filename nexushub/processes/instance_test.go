@@ -0,0 +1,105 @@
+package processes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// commandScript records the arguments and working directory it was started
+// with, then waits to be stopped.
+const commandScript = `#!/bin/sh
+echo "$@" > "$OUT.tmp"
+pwd >> "$OUT.tmp"
+mv "$OUT.tmp" "$OUT"
+while true; do sleep 0.01; done
+`
+
+// requireShell skips tests whose processes are shell scripts where there is
+// no /bin/sh to run them.
+func requireShell(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("test processes are shell scripts")
+	}
+}
+
+func TestStartProcessWithCommandOverride(t *testing.T) {
+	requireShell(t)
+	binDir, workDir := t.TempDir(), t.TempDir()
+	out := filepath.Join(t.TempDir(), "command")
+	t.Setenv("OUT", out)
+	binPath := filepath.Join(binDir, "app")
+	if err := os.WriteFile(binPath, []byte(commandScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	portManager, err := NewPortManager(20140, 20150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := AppInstance{
+		InstanceID: "app",
+		PkgPath:    t.TempDir(),
+		BinaryPath: binPath,
+		Args:       []string{"-native", "--verbose"},
+		WorkDir:    workDir,
+	}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider: staticInstances{instance},
+		PortManager:      portManager,
+		HealthChecker:    healthyChecker{},
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	current := func() *ManagedProcess {
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		return pm.actualState["app"]
+	}
+
+	pm.startProcess(ctx, instance)
+	process := current()
+	waitForFile(t, out)
+	command, _ := os.ReadFile(out)
+	expected := instance.PkgPath + " " + strconv.Itoa(process.Port) + " -native --verbose\n" + workDir + "\n"
+	if string(command) != expected {
+		t.Errorf("Expected the process to be started with %q, got %q", expected, command)
+	}
+
+	if err := pm.stopProcess(ctx, process, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing binary fails the start with a clear reason
+	instance.BinaryPath = filepath.Join(binDir, "missing")
+	pm.startProcess(ctx, instance)
+	failed := current()
+	if failed.GetState() != StateFailed || !strings.Contains(failed.history[len(failed.history)-1].Reason, "binary is not executable") {
+		t.Errorf("Expected the instance to fail for its binary, got %s %+v", failed.GetState(), failed.history)
+	}
+	if err := pm.launchProcess(ctx, instance, nil); !errors.Is(err, ErrBinaryNotExecutable) {
+		t.Errorf("Expected a missing binary to be reported, got %v", err)
+	}
+
+	// So does one that can't be executed
+	if err := os.Chmod(binPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	instance.BinaryPath = binPath
+	if err := pm.launchProcess(ctx, instance, nil); !errors.Is(err, ErrBinaryNotExecutable) {
+		t.Errorf("Expected a binary without execute permission to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package processes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+)
+
+const (
+	defaultLogFileMaxSize    = 10 << 20 // 10 MiB
+	defaultLogFileMaxAge     = 24 * time.Hour
+	defaultLogFileMaxBackups = 5
+
+	// rotatedLogTimeFormat suffixes rotated files so they sort by age
+	rotatedLogTimeFormat = "20060102-150405.000"
+)
+
+// LogFileConfig writes the output of each instance's processes to its own
+// file, <Dir>/<InstanceID>.log, one JSON ProcessLogEntry per line. The file
+// is kept across restarts of the instance. Once it reaches MaxSize, or
+// MaxAge after the manager opened it, it is renamed to
+// <InstanceID>.log.<time> and a new one is started; only the newest
+// MaxBackups renamed files are kept.
+type LogFileConfig struct {
+	Dir        string
+	MaxSize    int64         // Optional, in bytes, defaults to 10 MiB
+	MaxAge     time.Duration // Optional, defaults to 24h
+	MaxBackups int           // Optional, defaults to 5; negative keeps none
+	// FilesOnly stops the output from also being logged to the manager's
+	// Logger.
+	FilesOnly bool
+}
+
+// LogFilesFromEnv reads the configuration of per-instance log files from the
+// environment, returning nil if APP_LOG_DIR is unset:
+//
+//	APP_LOG_DIR         directory of the files
+//	APP_LOG_MAX_SIZE_MB size in MiB at which a file is rotated
+//	APP_LOG_MAX_AGE     age at which a file is rotated, e.g. "24h"
+//	APP_LOG_MAX_BACKUPS rotated files kept per instance
+//	APP_LOG_OUTPUT      "both" (default) to also write output to the hub's
+//	                    log, or "files"
+func LogFilesFromEnv() (*LogFileConfig, error) {
+	dir := os.Getenv("APP_LOG_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	config := &LogFileConfig{Dir: dir}
+	if value := os.Getenv("APP_LOG_MAX_SIZE_MB"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid APP_LOG_MAX_SIZE_MB %q: expected a positive number", value)
+		}
+		config.MaxSize = size << 20
+	}
+	if value := os.Getenv("APP_LOG_MAX_AGE"); value != "" {
+		age, err := time.ParseDuration(value)
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("invalid APP_LOG_MAX_AGE %q: expected a positive duration", value)
+		}
+		config.MaxAge = age
+	}
+	if value := os.Getenv("APP_LOG_MAX_BACKUPS"); value != "" {
+		backups, err := strconv.Atoi(value)
+		if err != nil || backups < 0 {
+			return nil, fmt.Errorf("invalid APP_LOG_MAX_BACKUPS %q: expected a number", value)
+		}
+		config.MaxBackups = backups
+		if backups == 0 {
+			config.MaxBackups = -1
+		}
+	}
+	switch value := os.Getenv("APP_LOG_OUTPUT"); value {
+	case "", "both":
+	case "files":
+		config.FilesOnly = true
+	default:
+		return nil, fmt.Errorf("invalid APP_LOG_OUTPUT %q: expected \"both\" or \"files\"", value)
+	}
+	return config, nil
+}
+
+// withDefaults fills in the optional settings.
+func (c LogFileConfig) withDefaults() LogFileConfig {
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultLogFileMaxSize
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = defaultLogFileMaxAge
+	}
+	if c.MaxBackups == 0 {
+		c.MaxBackups = defaultLogFileMaxBackups
+	}
+	return c
+}
+
+// logFile is an instance's rotating log file. It is opened on the first
+// write after being closed, so writes from output readers that outlive a
+// Close still land in the file.
+type logFile struct {
+	mu       sync.Mutex
+	path     string
+	config   LogFileConfig
+	clock    clock.Clock
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	failing  bool // The last write failed, so the next failure is not reported again
+}
+
+func newLogFile(config LogFileConfig, instanceID string, clk clock.Clock) *logFile {
+	return &logFile{
+		path:   filepath.Join(config.Dir, instanceID+".log"),
+		config: config,
+		clock:  clk,
+	}
+}
+
+// Write appends entry to the file, rotating it first if it is due. It
+// returns an error only for the first of a run of failed writes.
+func (f *logFile) Write(entry ProcessLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err = f.write(line)
+	if err != nil && f.failing {
+		return nil
+	}
+	f.failing = err != nil
+	return err
+}
+
+func (f *logFile) write(line []byte) error {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && (f.size+int64(len(line)) > f.config.MaxSize || f.clock.Since(f.openedAt) >= f.config.MaxAge) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+func (f *logFile) open() error {
+	if err := os.MkdirAll(f.config.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = f.clock.Now()
+	return nil
+}
+
+// rotate closes the current file, renames it aside and removes the oldest
+// rotated files beyond MaxBackups.
+func (f *logFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	rotated := f.path + "." + f.clock.Now().UTC().Format(rotatedLogTimeFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > max(f.config.MaxBackups, 0) {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove rotated log file: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close closes the file until the next write.
+func (f *logFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// instanceLogFile returns the instance's log file, or nil if log files are
+// disabled.
+func (pm *ProcessManager) instanceLogFile(instanceID string) *logFile {
+	if pm.logFileConfig == nil {
+		return nil
+	}
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+	file, ok := pm.logFiles[instanceID]
+	if !ok {
+		file = newLogFile(*pm.logFileConfig, instanceID, pm.clock)
+		pm.logFiles[instanceID] = file
+	}
+	return file
+}
+
+// closeLogFiles closes the open log files.
+func (pm *ProcessManager) closeLogFiles() {
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+	for instanceID, file := range pm.logFiles {
+		if err := file.Close(); err != nil {
+			pm.logger.Error("Failed to close log file", "instanceID", instanceID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package processes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// readLogFile returns the messages of the entries in a log file.
+func readLogFile(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var messages []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry ProcessLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", scanner.Text(), err)
+		}
+		messages = append(messages, entry.Message)
+	}
+	return messages
+}
+
+func TestLogFileRotates(t *testing.T) {
+	dir := t.TempDir()
+	clk := clock.NewFake(time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC))
+	config := LogFileConfig{Dir: dir, MaxSize: 300, MaxAge: time.Hour, MaxBackups: 2}.withDefaults()
+	file := newLogFile(config, "app", clk)
+	defer file.Close()
+	write := func(message string) {
+		t.Helper()
+		if err := file.Write(ProcessLogEntry{Level: "info", Source: "stdout", Message: message}); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Millisecond)
+	}
+	backups := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(dir, "app.log.*"))
+		return matches
+	}
+
+	// Each entry is about 120 bytes, so the third one starts a new file
+	write("one")
+	write("two")
+	if len(backups()) != 0 {
+		t.Fatalf("Expected no rotation below the size limit, got %v", backups())
+	}
+	write("three")
+	if rotated := backups(); len(rotated) != 1 || strings.Join(readLogFile(t, rotated[0]), ",") != "one,two" {
+		t.Fatalf("Expected the first two entries to be rotated, got %v", rotated)
+	}
+
+	// A file that reaches its maximum age is rotated on the next write
+	clk.Advance(time.Hour)
+	write("four")
+	if messages := readLogFile(t, filepath.Join(dir, "app.log")); strings.Join(messages, ",") != "four" {
+		t.Errorf("Expected a new file after the maximum age, got %v", messages)
+	}
+
+	// Only the newest rotated files are kept
+	for _, message := range []string{"five", "six", "seven"} {
+		write(message)
+	}
+	rotated := backups()
+	if len(rotated) != 2 || strings.Join(readLogFile(t, rotated[1]), ",") != "four,five" {
+		t.Errorf("Expected the two newest rotated files, got %v", rotated)
+	}
+
+	// The file is reopened after being closed, counting what it has
+	file.Close()
+	write("eight")
+	rotated = backups()
+	if messages := readLogFile(t, filepath.Join(dir, "app.log")); strings.Join(messages, ",") != "eight" || strings.Join(readLogFile(t, rotated[1]), ",") != "six,seven" {
+		t.Errorf("Expected the reopened file to be rotated when full, got %v", messages)
+	}
+}
+
+// outputScript writes to both output streams, then waits to be stopped.
+const outputScript = `#!/bin/sh
+echo "to stdout"
+echo "to stderr" >&2
+while true; do sleep 0.01; done
+`
+
+func TestProcessOutputWrittenToLogFile(t *testing.T) {
+	requireShell(t)
+	binPath := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(binPath, []byte(outputScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	portManager, err := NewPortManager(20150, 20160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logDir := t.TempDir()
+	var hubLog bytes.Buffer
+	instance := AppInstance{InstanceID: "app", PkgPath: t.TempDir(), BinaryPath: binPath}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider: staticInstances{instance},
+		PortManager:      portManager,
+		HealthChecker:    healthyChecker{},
+		Logger:           slog.New(slog.NewTextHandler(&hubLog, nil)),
+		LogFiles:         &LogFileConfig{Dir: logDir, FilesOnly: true},
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	pm.startProcess(ctx, instance)
+	pm.mu.RLock()
+	process := pm.actualState["app"]
+	pm.mu.RUnlock()
+
+	path := filepath.Join(logDir, "app.log")
+	deadline := time.Now().Add(5 * time.Second)
+	var messages []string
+	for len(messages) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if _, err := os.Stat(path); err == nil {
+			messages = readLogFile(t, path)
+		}
+	}
+	// The in-memory buffer still has it for streaming
+	if entries, err := pm.GetLatestProcessLogs("app", 10); err != nil || len(entries) != 2 {
+		t.Errorf("Expected the output in the log buffer, got %v: %v", entries, err)
+	}
+	if err := pm.stopProcess(ctx, process, true); err != nil {
+		t.Fatal(err)
+	}
+	pm.Stop()
+
+	if strings.Join(messages, ",") != "to stdout,to stderr" && strings.Join(messages, ",") != "to stderr,to stdout" {
+		t.Fatalf("Expected both streams in the log file, got %v", messages)
+	}
+	if strings.Contains(hubLog.String(), "to stdout") {
+		t.Errorf("Expected the output not to be logged to the hub's log, got %s", hubLog.String())
+	}
+}
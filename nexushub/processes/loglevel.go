@@ -0,0 +1,133 @@
+package processes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// ErrLogLevelRejected is returned by SetLogLevel when the instance does not
+// accept the log level spec.
+var ErrLogLevelRejected = errors.New("log level rejected")
+
+// ErrInstanceNotRunning is returned for requests to an instance that has no
+// running process.
+var ErrInstanceNotRunning = errors.New("instance is not running")
+
+// parseLogLevel returns the level of a line logged by an applib application,
+// which logs in slog's text format ("time=... level=INFO msg=..."), or
+// fallback for lines without one.
+func parseLogLevel(line, fallback string) string {
+	value := ""
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, "msg=") {
+			// The level comes before the message, which may quote anything
+			break
+		}
+		if level, ok := strings.CutPrefix(field, "level="); ok {
+			value = level
+			break
+		}
+	}
+	// Levels between the named ones are written as e.g. INFO+2
+	if i := strings.IndexAny(value, "+-"); i >= 0 {
+		value = value[:i]
+	}
+	switch value {
+	case "DEBUG":
+		return "debug"
+	case "INFO":
+		return "info"
+	case "WARN":
+		return "warn"
+	case "ERROR":
+		return "error"
+	}
+	return fallback
+}
+
+// slogLevel maps a level tagged by parseLogLevel to the hub's logger level.
+func slogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// GetLogLevel returns the log level spec of the instance's running process.
+func (pm *ProcessManager) GetLogLevel(ctx context.Context, id string) (string, error) {
+	_, level, err := pm.logLevelRequest(ctx, id, http.MethodGet, nil)
+	return level, err
+}
+
+// SetLogLevel changes the log level spec of the instance's running process,
+// e.g. "info,database=debug", until it restarts. It returns the spec the
+// process reports, and records the change in the instance's history.
+func (pm *ProcessManager) SetLogLevel(ctx context.Context, id, level string) (string, error) {
+	process, current, err := pm.logLevelRequest(ctx, id, http.MethodPut, &types.LogLevel{Level: level})
+	if err != nil {
+		return "", err
+	}
+	process.recordHistory("log level set to " + current)
+	pm.logger.Info("Instance log level changed", "instanceID", id, "level", current)
+	return current, nil
+}
+
+// logLevelRequest sends a request to the /internal/loglevel endpoint of the
+// instance's running process.
+func (pm *ProcessManager) logLevelRequest(ctx context.Context, id, method string, body *types.LogLevel) (*ManagedProcess, string, error) {
+	pm.mu.RLock()
+	process, exists := pm.actualState[id]
+	pm.mu.RUnlock()
+	if !exists {
+		return nil, "", fmt.Errorf("%w: %s", ErrInstanceNotRunning, id)
+	}
+	if state := process.GetState(); state != StateRunning && state != StateUnhealthy {
+		return nil, "", fmt.Errorf("%w: %s is %s", ErrInstanceNotRunning, id, state)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, process.Instance.BackendURL(process.Port)+"/internal/loglevel", reader)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+pm.secrets.Current())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := BackendClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("log level request for %s failed: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, "", fmt.Errorf("%w: %s", ErrLogLevelRejected, strings.TrimSpace(string(message)))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("log level request for %s returned status %s", id, resp.Status)
+	}
+	var response types.LogLevel
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, "", fmt.Errorf("failed to decode log level of %s: %w", id, err)
+	}
+	return process, response.Level, nil
+}
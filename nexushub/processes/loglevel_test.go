@@ -0,0 +1,112 @@
+package processes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, tc := range []struct {
+		line, fallback, want string
+	}{
+		{`time=2026-10-16T12:00:00.000Z level=DEBUG msg="Handler applied" module=database`, "error", "debug"},
+		{`time=2026-10-16T12:00:00.000Z level=INFO msg="Starting server"`, "error", "info"},
+		{`time=2026-10-16T12:00:00.000Z level=WARN msg=busy`, "info", "warn"},
+		{`time=2026-10-16T12:00:00.000Z level=ERROR msg="Failed to apply event 7: boom"`, "info", "error"},
+		{`time=2026-10-16T12:00:00.000Z level=INFO+2 msg=notice`, "error", "info"},
+		{`time=2026-10-16T12:00:00.000Z level=DEBUG-4 msg=trace`, "error", "debug"},
+		// Unstructured output keeps the stream's level
+		{`2026/10/16 12:00:00 Starting server`, "error", "error"},
+		{`panic: runtime error`, "info", "info"},
+		// A level mentioned in the message is not the record's
+		{`time=2026-10-16T12:00:00.000Z msg="set level=DEBUG"`, "info", "info"},
+		{`time=2026-10-16T12:00:00.000Z level=LOUD msg=x`, "info", "info"},
+	} {
+		if got := parseLogLevel(tc.line, tc.fallback); got != tc.want {
+			t.Errorf("parseLogLevel(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+// newLogLevelProcess runs a process for an instance served by backend.
+func newLogLevelProcess(t *testing.T, pm *ProcessManager, backend *httptest.Server) *ManagedProcess {
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(backendURL.Port())
+	process := &ManagedProcess{
+		Instance: AppInstance{InstanceID: "app"},
+		Port:     port,
+		State:    StateRunning,
+		history:  []StateTransition{{Time: time.Now(), State: StateRunning}},
+	}
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+	return process
+}
+
+func TestSetLogLevel(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	level := "info"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/loglevel" || r.Header.Get("Authorization") != "Bearer "+pm.secrets.Current() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPut {
+			var request types.LogLevel
+			json.NewDecoder(r.Body).Decode(&request)
+			if strings.Contains(request.Level, "loud") {
+				http.Error(w, `unknown log level "loud"`, http.StatusBadRequest)
+				return
+			}
+			level = request.Level
+		}
+		json.NewEncoder(w).Encode(types.LogLevel{Level: level})
+	}))
+	defer backend.Close()
+	process := newLogLevelProcess(t, pm, backend)
+
+	if current, err := pm.GetLogLevel(context.Background(), "app"); err != nil || current != "info" {
+		t.Errorf("Expected the current level, got %q: %v", current, err)
+	}
+	current, err := pm.SetLogLevel(context.Background(), "app", "info,database=debug")
+	if err != nil || current != "info,database=debug" {
+		t.Fatalf("Expected the level to be changed, got %q: %v", current, err)
+	}
+	status, _ := pm.GetInstanceStatus("app")
+	last := status.History[len(status.History)-1]
+	if last.State != StateRunning || last.Reason != "log level set to info,database=debug" {
+		t.Errorf("Expected the change in the instance history, got %+v", last)
+	}
+	if process.GetState() != StateRunning {
+		t.Errorf("Expected the process to keep running, got %s", process.GetState())
+	}
+
+	if _, err := pm.SetLogLevel(context.Background(), "app", "loud"); !errors.Is(err, ErrLogLevelRejected) {
+		t.Errorf("Expected ErrLogLevelRejected, got %v", err)
+	}
+	if status, _ := pm.GetInstanceStatus("app"); len(status.History) != 2 {
+		t.Errorf("Expected a rejected change not to be recorded, got %+v", status.History)
+	}
+
+	if _, err := pm.GetLogLevel(context.Background(), "missing"); !errors.Is(err, ErrInstanceNotRunning) {
+		t.Errorf("Expected ErrInstanceNotRunning for an unknown instance, got %v", err)
+	}
+	process.UpdateState(StateStopped)
+	if _, err := pm.SetLogLevel(context.Background(), "app", "debug"); !errors.Is(err, ErrInstanceNotRunning) {
+		t.Errorf("Expected ErrInstanceNotRunning for a stopped instance, got %v", err)
+	}
+}
@@ -3,28 +3,34 @@ package processes
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
 	"github.com/tomyedwab/yesterday/nexushub/events"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
 )
 
 const (
 	defaultHealthCheckInterval     = 15 * time.Second
 	defaultHealthCheckIntervalFast = 1 * time.Second // For starting/unhealthy processes
 	defaultHealthCheckTimeout      = 5 * time.Second
+	defaultHealthCheckParallelism  = 4
 	defaultConsecutiveFailures     = 30
 	defaultRestartBackoffInitial   = 1 * time.Second
 	defaultRestartBackoffMax       = 30 * time.Second
 	defaultGracefulShutdownPeriod  = 10 * time.Second
+	defaultWarmupTimeout           = 30 * time.Second // Per warm-up request
 )
 
 // AppInstanceProvider defines an interface to get the current list of desired app instances.
@@ -33,9 +39,14 @@ type AppInstanceProvider interface {
 	GetAppInstances() ([]AppInstance, error)
 }
 
-// LogCallback is a function type for handling new log entries
+// LogCallback is a function type for handling new log entries. Callbacks
+// are called on the goroutine reading the process's output, so they must
+// not block.
 type LogCallback func(instanceID string, logEntry ProcessLogEntry)
 
+// LogCallbackHandle identifies a callback registered with AddLogCallback.
+type LogCallbackHandle uint64
+
 type EventCallbackInfo struct {
 	InstanceID string
 	EventID    int
@@ -51,19 +62,28 @@ type ProcessManager struct {
 	desiredStateProvider AppInstanceProvider
 	actualState          map[string]*ManagedProcess // Keyed by InstanceID
 
-	portManager   *PortManager
-	healthChecker HealthChecker
-	logger        *slog.Logger
-	eventManager  *events.EventManager
+	portManager     *PortManager
+	healthChecker   HealthChecker
+	selfTestChecker SelfTestChecker
+	warmer          Warmer
+	logger          *slog.Logger
+	eventManager    *events.EventManager
 
 	// Configuration
 	healthCheckInterval     time.Duration
-	healthCheckIntervalFast time.Duration // Faster interval for starting/unhealthy processes
-	consecutiveFailures     int           // Number of consecutive health check failures before restart
-	restartBackoffInitial   time.Duration // Initial delay for restart backoff
-	restartBackoffMax       time.Duration // Maximum delay for restart backoff
-	gracefulShutdownPeriod  time.Duration // Time to wait for graceful shutdown before SIGKILL
-	internalSecret          string        // Secret for authorizing cross-service requests
+	healthCheckIntervalFast time.Duration  // Faster interval for starting/unhealthy processes
+	healthCheckParallelism  int            // Most health checks run at once
+	consecutiveFailures     int            // Number of consecutive health check failures before restart
+	restartBackoffInitial   time.Duration  // Initial delay for restart backoff
+	restartBackoffMax       time.Duration  // Maximum delay for restart backoff
+	gracefulShutdownPeriod  time.Duration  // Time to wait for graceful shutdown before SIGKILL
+	secrets                 *secrets.Store // Secret for authorizing cross-service requests
+	clock                   clock.Clock    // Times restart backoffs and unhealthy processes
+	probeCacheTTL           time.Duration  // How long probe results are reused
+	criticalProbeFailures   int            // Failures of a critical probe that degrade an instance
+
+	// Issues TLS certificates for TCP instances, nil if backend mTLS is disabled
+	ca *CertificateAuthority
 
 	// Control channels
 	stopChan        chan struct{}  // Signals the manager to stop
@@ -72,6 +92,10 @@ type ProcessManager struct {
 	healthCheckChan chan struct{}  // Signals immediate health check needed
 	wg              sync.WaitGroup // Waits for goroutines to finish
 
+	// Context passed to Run, which processes started outside of its loops,
+	// e.g. by RestartInstance, run under
+	runCtx context.Context
+
 	// Working directory for subprocesses
 	subprocessWorkDir string
 
@@ -80,9 +104,29 @@ type ProcessManager struct {
 	firstReconcileComplete   bool       // Flag to track if first reconcile has completed
 	callbackMu               sync.Mutex // Protects callback-related fields
 
+	// Per-instance readiness notification
+	onInstanceReady func(instance AppInstance)
+	readyWaiters    map[string]chan struct{} // Closed when the instance next becomes ready
+	readyMu         sync.Mutex               // Protects readyWaiters
+
+	// Called with the desired instances, see Config.OnDesiredState
+	onDesiredState func(instances []AppInstance)
+
+	// Per-instance log files, nil if disabled, see Config.LogFiles
+	logFileConfig *LogFileConfig
+	logFiles      map[string]*logFile // Protected by logMu
+
+	// Probe results, see RunProbe
+	probes probeCache
+
+	// Start and warm-up durations, nil until EnableMetrics is called
+	startDurations  *prometheus.HistogramVec
+	warmupDurations *prometheus.HistogramVec
+
 	// Log handling
-	logCallbacks []LogCallback // Callbacks to notify when new log entries are added
-	logMu        sync.RWMutex  // Protects log-related fields
+	logCallbacks    map[LogCallbackHandle]LogCallback // Callbacks to notify when new log entries are added
+	nextLogCallback LogCallbackHandle
+	logMu           sync.RWMutex // Protects log-related fields
 
 	// Process event state callbacks
 	eventStateCallbacks map[string]chan EventCallbackInfo
@@ -95,14 +139,24 @@ type Config struct {
 	HealthChecker           HealthChecker // Optional, defaults to HTTPHealthChecker
 	Logger                  *slog.Logger  // Optional, defaults to slog.Default()
 	EventManager            *events.EventManager
-	HealthCheckInterval     time.Duration // Optional, defaults to 15s
-	HealthCheckIntervalFast time.Duration // Optional, defaults to 5s for starting/unhealthy processes
-	HealthCheckTimeout      time.Duration // Optional, for default HTTPHealthChecker, defaults to 5s
-	ConsecutiveFailures     int           // Optional, defaults to 3
-	RestartBackoffInitial   time.Duration // Optional, defaults to 1s
-	RestartBackoffMax       time.Duration // Optional, defaults to 30s
-	GracefulShutdownPeriod  time.Duration // Optional, defaults to 10s
-	SubprocessWorkDir       string        // Optional, defaults to current directory
+	HealthCheckInterval     time.Duration   // Optional, defaults to 15s
+	HealthCheckIntervalFast time.Duration   // Optional, defaults to 5s for starting/unhealthy processes
+	HealthCheckTimeout      time.Duration   // Optional, for default HTTPHealthChecker, defaults to 5s
+	HealthCheckParallelism  int             // Optional, most health checks run at once, defaults to 4
+	SelfTestChecker         SelfTestChecker // Optional, defaults to HTTPSelfTestChecker
+	Warmer                  Warmer          // Optional, defaults to HTTPWarmer
+	ConsecutiveFailures     int             // Optional, defaults to 3
+	RestartBackoffInitial   time.Duration   // Optional, defaults to 1s
+	RestartBackoffMax       time.Duration   // Optional, defaults to 30s
+	GracefulShutdownPeriod  time.Duration   // Optional, defaults to 10s
+	SubprocessWorkDir       string          // Optional, defaults to current directory
+	Clock                   clock.Clock     // Optional, defaults to clock.Real
+	ProbeCacheTTL           time.Duration   // Optional, defaults to 5s
+	CriticalProbeFailures   int             // Optional, defaults to 3
+	// CA issues the certificates for mutual TLS between the hub and TCP
+	// instances, see CertificateAuthority. Optional, if nil TCP instances are
+	// served over plain HTTP.
+	CA *CertificateAuthority
 	// OnFirstReconcileComplete is an optional callback function that will be called exactly once
 	// when the first reconciliation process completes successfully with all desired processes
 	// running and healthy. This is useful for:
@@ -112,30 +166,55 @@ type Config struct {
 	// - Sending notifications that the system is operational
 	// The callback is executed in a separate goroutine to avoid blocking the reconciliation process.
 	OnFirstReconcileComplete func()
+	// OnInstanceReady is an optional callback function that is called every time an
+	// instance's process enters the running state, whether newly started, restarted or
+	// recovered from being unhealthy. Like OnFirstReconcileComplete it is executed in a
+	// separate goroutine. To wait for one instance, use WaitForInstance instead.
+	OnInstanceReady func(instance AppInstance)
+	// OnDesiredState is an optional callback function that is called with the
+	// desired instances at the start of every reconciliation, e.g. to record
+	// how they change over time. Unlike the other callbacks it is called
+	// synchronously, so it must return quickly.
+	OnDesiredState func(instances []AppInstance)
+	// LogFiles optionally writes the output of each instance's processes to
+	// its own rotating file, see LogFileConfig. By default it is only logged
+	// to Logger and kept in the in-memory log buffer.
+	LogFiles *LogFileConfig
 }
 
 // NewProcessManager creates a new ProcessManager instance.
-func NewProcessManager(config Config, internalSecret string) (*ProcessManager, error) {
+func NewProcessManager(config Config, secretStore *secrets.Store) (*ProcessManager, error) {
 	if config.InstanceProvider == nil {
 		return nil, fmt.Errorf("InstanceProvider is required")
 	}
 	if config.PortManager == nil {
 		return nil, fmt.Errorf("PortManager is required")
 	}
+	if secretStore == nil {
+		return nil, fmt.Errorf("secret store is required")
+	}
 
 	logger := config.Logger
 	if logger == nil {
 		logger = slog.Default()
 	}
 
+	hcTimeout := config.HealthCheckTimeout
+	if hcTimeout == 0 {
+		hcTimeout = defaultHealthCheckTimeout
+	}
 	healthChecker := config.HealthChecker
 	if healthChecker == nil {
-		hcTimeout := config.HealthCheckTimeout
-		if hcTimeout == 0 {
-			hcTimeout = defaultHealthCheckTimeout
-		}
 		healthChecker = NewHTTPHealthChecker(hcTimeout)
 	}
+	selfTestChecker := config.SelfTestChecker
+	if selfTestChecker == nil {
+		selfTestChecker = NewHTTPSelfTestChecker(hcTimeout, secretStore)
+	}
+	warmer := config.Warmer
+	if warmer == nil {
+		warmer = NewHTTPWarmer(defaultWarmupTimeout)
+	}
 
 	hcInterval := config.HealthCheckInterval
 	if hcInterval == 0 {
@@ -145,6 +224,10 @@ func NewProcessManager(config Config, internalSecret string) (*ProcessManager, e
 	if hcIntervalFast == 0 {
 		hcIntervalFast = defaultHealthCheckIntervalFast
 	}
+	hcParallelism := config.HealthCheckParallelism
+	if hcParallelism <= 0 {
+		hcParallelism = defaultHealthCheckParallelism
+	}
 	consFailures := config.ConsecutiveFailures
 	if consFailures == 0 {
 		consFailures = defaultConsecutiveFailures
@@ -162,6 +245,19 @@ func NewProcessManager(config Config, internalSecret string) (*ProcessManager, e
 		gracefulShutdown = defaultGracefulShutdownPeriod
 	}
 
+	clk := config.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	probeCacheTTL := config.ProbeCacheTTL
+	if probeCacheTTL == 0 {
+		probeCacheTTL = defaultProbeCacheTTL
+	}
+	criticalProbeFailures := config.CriticalProbeFailures
+	if criticalProbeFailures == 0 {
+		criticalProbeFailures = defaultCriticalProbeFailures
+	}
+
 	workDir := config.SubprocessWorkDir
 	if workDir == "" {
 		wd, err := os.Getwd()
@@ -176,22 +272,38 @@ func NewProcessManager(config Config, internalSecret string) (*ProcessManager, e
 		actualState:              make(map[string]*ManagedProcess),
 		portManager:              config.PortManager,
 		healthChecker:            healthChecker,
+		selfTestChecker:          selfTestChecker,
+		warmer:                   warmer,
 		logger:                   logger.With("component", "ProcessManager"),
 		eventManager:             config.EventManager,
 		healthCheckInterval:      hcInterval,
 		healthCheckIntervalFast:  hcIntervalFast,
+		healthCheckParallelism:   hcParallelism,
 		consecutiveFailures:      consFailures,
 		restartBackoffInitial:    restartInitial,
 		restartBackoffMax:        restartMax,
 		gracefulShutdownPeriod:   gracefulShutdown,
 		stopChan:                 make(chan struct{}),
+		runCtx:                   context.Background(),
 		eventChan:                make(chan struct{}),
 		reloadChan:               make(chan struct{}),
 		healthCheckChan:          make(chan struct{}),
 		subprocessWorkDir:        workDir,
-		internalSecret:           internalSecret,
+		secrets:                  secretStore,
+		clock:                    clk,
+		probeCacheTTL:            probeCacheTTL,
+		criticalProbeFailures:    criticalProbeFailures,
+		ca:                       config.CA,
 		onFirstReconcileComplete: config.OnFirstReconcileComplete,
+		onInstanceReady:          config.OnInstanceReady,
+		onDesiredState:           config.OnDesiredState,
+		logFiles:                 make(map[string]*logFile),
 	}
+	if config.LogFiles != nil {
+		logFileConfig := config.LogFiles.withDefaults()
+		pm.logFileConfig = &logFileConfig
+	}
+	secretStore.OnRotate(pm.distributeSecret)
 
 	return pm, nil
 }
@@ -290,6 +402,9 @@ func (pm *ProcessManager) triggerEventStateCallbacks(instanceID string, eventID
 // It blocks until Stop() is called or the context is cancelled.
 func (pm *ProcessManager) Run(ctx context.Context) {
 	pm.logger.Info("ProcessManager starting...")
+	pm.mu.Lock()
+	pm.runCtx = ctx
+	pm.mu.Unlock()
 	pm.wg.Add(2) // For reconciler and health monitor goroutines
 
 	go pm.reconcilerLoop(ctx)
@@ -312,6 +427,7 @@ func (pm *ProcessManager) Stop() {
 	pm.logger.Info("Stopping ProcessManager...")
 	close(pm.stopChan)
 	pm.wg.Wait()
+	pm.closeLogFiles()
 	pm.logger.Info("ProcessManager stopped.")
 }
 
@@ -375,11 +491,26 @@ func (pm *ProcessManager) GetAppInstanceByID(id string) (*AppInstance, int, erro
 	return nil, 0, fmt.Errorf("instance with ID '%s' found but not in a running state (current state: %s)", id, process.GetState().String())
 }
 
-// AddLogCallback adds a callback to be called when new log entries are added to any managed process
-func (pm *ProcessManager) AddLogCallback(callback LogCallback) {
+// AddLogCallback adds a callback to be called when new log entries are added
+// to any managed process. The callback is called until it is removed with
+// RemoveLogCallback and the returned handle.
+func (pm *ProcessManager) AddLogCallback(callback LogCallback) LogCallbackHandle {
 	pm.logMu.Lock()
 	defer pm.logMu.Unlock()
-	pm.logCallbacks = append(pm.logCallbacks, callback)
+	if pm.logCallbacks == nil {
+		pm.logCallbacks = make(map[LogCallbackHandle]LogCallback)
+	}
+	pm.nextLogCallback++
+	pm.logCallbacks[pm.nextLogCallback] = callback
+	return pm.nextLogCallback
+}
+
+// RemoveLogCallback stops calling a callback added with AddLogCallback. It
+// may still be running when this returns, but is not called again.
+func (pm *ProcessManager) RemoveLogCallback(handle LogCallbackHandle) {
+	pm.logMu.Lock()
+	defer pm.logMu.Unlock()
+	delete(pm.logCallbacks, handle)
 }
 
 // GetProcessLogs returns log entries for a specific process, starting from the given ID
@@ -436,23 +567,32 @@ func (pm *ProcessManager) GetProcessLogLatestID(instanceID string) (int64, error
 // notifyLogCallbacks notifies all registered log callbacks about a new log entry
 func (pm *ProcessManager) notifyLogCallbacks(instanceID string, logEntry ProcessLogEntry) {
 	pm.logMu.RLock()
-	callbacks := make([]LogCallback, len(pm.logCallbacks))
-	copy(callbacks, pm.logCallbacks)
+	callbacks := make([]LogCallback, 0, len(pm.logCallbacks))
+	for _, callback := range pm.logCallbacks {
+		callbacks = append(callbacks, callback)
+	}
 	pm.logMu.RUnlock()
 
+	// Called in order, so that entries reach each callback in the order
+	// they were logged
 	for _, callback := range callbacks {
-		go callback(instanceID, logEntry) // Run in goroutine to avoid blocking
+		callback(instanceID, logEntry)
 	}
 }
 
 // shutdown handles the graceful termination of all managed subprocesses.
 func (pm *ProcessManager) shutdown(ctx context.Context) {
 	pm.logger.Info("Shutting down all managed processes...")
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	// stopProcess takes the lock to remove each process from actualState
+	pm.mu.RLock()
+	running := make(map[string]*ManagedProcess, len(pm.actualState))
+	for instanceID, process := range pm.actualState {
+		running[instanceID] = process
+	}
+	pm.mu.RUnlock()
 
 	var shutdownWg sync.WaitGroup
-	for instanceID, process := range pm.actualState {
+	for instanceID, process := range running {
 		if process.GetState() == StateRunning || process.GetState() == StateStarting || process.GetState() == StateUnhealthy {
 			shutdownWg.Add(1)
 			go func(id string, proc *ManagedProcess) {
@@ -615,54 +755,36 @@ func (pm *ProcessManager) reconcileState(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to get desired app instances: %w", err)
 	}
+	if pm.onDesiredState != nil {
+		pm.onDesiredState(desiredInstances)
+	}
 
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	desiredMap := make(map[string]AppInstance)
-	for _, inst := range desiredInstances {
-		desiredMap[inst.InstanceID] = inst
-	}
-
-	// 1. Identify processes to start (in desired but not actual, or actual but not running correctly)
-	for instanceID, desired := range desiredMap {
-		actual, exists := pm.actualState[instanceID]
-		if exists && (actual.GetState() == StateRunning || actual.GetState() == StateUnhealthy || actual.GetState() == StateStarting) {
-			// Process exists and is in a running-like state, check for configuration changes
-			if actual.Instance.PkgPath != desired.PkgPath {
-				pm.logger.Info("Configuration changed for process, initiating restart", "instanceID", instanceID, "oldPkgPath", actual.Instance.PkgPath, "newPkgPath", desired.PkgPath)
-				// Stop the process. The reconciler or exit handler will then pick it up for a restart with the new config.
-				// We run this in a goroutine to avoid blocking the reconciler loop.
-				go func(procToStop *ManagedProcess) {
-					if err := pm.stopProcess(ctx, procToStop, true); err != nil { // true to remove from actualState, allowing a clean restart
-						pm.logger.Error("Failed to stop process for config update", "instanceID", procToStop.Instance.InstanceID, "error", err)
-					}
-				}(actual)
-				// After stopping, the next part of the logic will handle starting it if it's now considered 'missing' or 'stopped'.
-				// Or, we can continue to the next desired instance, and let the next reconciliation cycle handle the start.
-				// For simplicity, we'll let the stop happen and the existing logic below will catch it.
-			}
-		}
-
-		// This part now correctly handles starting if it doesn't exist, or if it exists but is stopped/failed (e.g. after a config change stop)
-		actual, exists = pm.actualState[instanceID] // Re-fetch actual state as it might have been removed by stopProcess
-		if !exists || actual.GetState() == StateStopped || actual.GetState() == StateFailed {
-			pm.logger.Info("Process needs to be started", "instanceID", instanceID)
-			go pm.startProcess(ctx, desired) // Run in a goroutine to avoid blocking reconciler
-		}
-	}
-
-	// 2. Identify processes to stop (in actual but not in desired)
-	for instanceID, actual := range pm.actualState {
-		if _, existsInDesired := desiredMap[instanceID]; !existsInDesired {
-			if actual.GetState() == StateRunning || actual.GetState() == StateStarting || actual.GetState() == StateUnhealthy {
-				pm.logger.Info("Process needs to be stopped (no longer in desired state)", "instanceID", instanceID)
-				go func(procToStop *ManagedProcess) { // Goroutine to avoid blocking
-					if err := pm.stopProcess(ctx, procToStop, true); err != nil {
-						pm.logger.Error("Failed to stop undesired process", "instanceID", procToStop.Instance.InstanceID, "error", err)
-					}
-				}(actual)
-			}
+	// Processes are started and stopped in goroutines to avoid blocking the
+	// reconciler. A process stopped for a configuration change is removed
+	// from actualState, so the next cycle starts it with the new config.
+	for _, action := range planReconcile(desiredInstances, pm.actualState).Actions {
+		switch action.Action {
+		case ReconcileStart:
+			pm.logger.Info("Process needs to be started", "instanceID", action.InstanceID, "reason", action.Reason)
+			go pm.startProcess(ctx, action.instance)
+		case ReconcileRestart:
+			actual := pm.actualState[action.InstanceID]
+			pm.logger.Info("Configuration changed for process, initiating restart", "instanceID", action.InstanceID, "reason", action.Reason, "oldPkgPath", actual.Instance.PkgPath, "newPkgPath", action.instance.PkgPath)
+			go func(procToStop *ManagedProcess) {
+				if err := pm.stopProcess(ctx, procToStop, true); err != nil {
+					pm.logger.Error("Failed to stop process for config update", "instanceID", procToStop.Instance.InstanceID, "error", err)
+				}
+			}(actual)
+		case ReconcileStop:
+			pm.logger.Info("Process needs to be stopped (no longer in desired state)", "instanceID", action.InstanceID)
+			go func(procToStop *ManagedProcess) {
+				if err := pm.stopProcess(ctx, procToStop, true); err != nil {
+					pm.logger.Error("Failed to stop undesired process", "instanceID", procToStop.Instance.InstanceID, "error", err)
+				}
+			}(pm.actualState[action.InstanceID])
 		}
 	}
 	pm.logger.Debug("Reconciliation cycle finished.")
@@ -705,41 +827,118 @@ func (pm *ProcessManager) startProcess(ctx context.Context, instance AppInstance
 		backoffDuration := calculateBackoff(existingProcess.GetRestartCount(), pm.restartBackoffInitial, pm.restartBackoffMax)
 		pm.logger.Info("Applying restart backoff", "instanceID", instance.InstanceID, "duration", backoffDuration, "restartCount", existingProcess.GetRestartCount())
 		pm.mu.Unlock() // Unlock before sleep
-		time.Sleep(backoffDuration)
+		<-pm.clock.After(backoffDuration)
 		pm.mu.Lock() // Re-lock to continue
+		if pm.actualState[instance.InstanceID] != existingProcess {
+			// Restarted or removed while backing off, e.g. by RestartInstance
+			pm.logger.Info("Process was replaced during restart backoff", "instanceID", instance.InstanceID)
+			pm.mu.Unlock()
+			return
+		}
 	} else {
 		// Create a new ManagedProcess entry if it doesn't exist, will be populated further down
 		// This is a temporary placeholder to mark it as 'being started'
-		mpPlaceholder := &ManagedProcess{Instance: instance, State: StateStarting}
+		mpPlaceholder := &ManagedProcess{Instance: instance, State: StateStarting, clock: pm.clock}
 		pm.actualState[instance.InstanceID] = mpPlaceholder
 	}
 	pm.mu.Unlock()
 
-	port, err := pm.portManager.AllocatePort()
-	if err != nil {
-		pm.logger.Error("Failed to allocate port", "instanceID", instance.InstanceID, "error", err)
+	pm.launchProcess(ctx, instance, existingProcess)
+}
+
+// launchProcess runs the subprocess of an instance whose entry in actualState
+// is marked as starting, replacing previous, if any, which it inherits the
+// history of. If it fails the entry is marked as failed and the error is
+// returned, after being logged.
+func (pm *ProcessManager) launchProcess(ctx context.Context, instance AppInstance, previous *ManagedProcess) error {
+	if instance.Embedded() {
+		return pm.launchEmbedded(ctx, instance, previous)
+	}
+
+	// Fail clearly, before allocating anything, if there is nothing to run
+	binPath, _, _ := instance.Command("")
+	if err := checkBinary(binPath); err != nil {
+		pm.logger.Error("Cannot start process", "instanceID", instance.InstanceID, "error", err)
 		pm.mu.Lock()
 		if proc, ok := pm.actualState[instance.InstanceID]; ok {
-			proc.UpdateState(StateFailed)
+			proc.UpdateStateWithReason(StateFailed, err.Error())
 		}
 		pm.mu.Unlock()
-		return
+		return err
 	}
-	pm.logger.Info("Allocated port for process", "instanceID", instance.InstanceID, "port", port)
 
-	cmdArgs := []string{
-		instance.PkgPath,
-		fmt.Sprintf("%d", port),
+	// Socket-mode instances listen on a unix socket and need no port
+	port := 0
+	listenArg := string(TransportUnix)
+	if instance.UsesSocket() {
+		if err := prepareSocket(instance); err != nil {
+			pm.logger.Error("Failed to prepare socket", "instanceID", instance.InstanceID, "error", err)
+			pm.mu.Lock()
+			if proc, ok := pm.actualState[instance.InstanceID]; ok {
+				proc.UpdateState(StateFailed)
+			}
+			pm.mu.Unlock()
+			return err
+		}
+		RegisterBackendSocket(instance)
+		pm.logger.Info("Using socket for process", "instanceID", instance.InstanceID, "socket", instance.SocketPath())
+	} else {
+		var err error
+		port, err = pm.portManager.AllocatePort()
+		if err != nil {
+			pm.logger.Error("Failed to allocate port", "instanceID", instance.InstanceID, "error", err)
+			pm.mu.Lock()
+			if proc, ok := pm.actualState[instance.InstanceID]; ok {
+				proc.UpdateState(StateFailed)
+			}
+			pm.mu.Unlock()
+			return err
+		}
+		pm.logger.Info("Allocated port for process", "instanceID", instance.InstanceID, "port", port)
+		listenArg = fmt.Sprintf("%d", port)
+	}
+
+	// TCP instances get new certificates every time they start, socket-mode
+	// instances are only reachable through the file system and skip mTLS
+	var credentials *backendCredentials
+	if pm.ca != nil && !instance.UsesSocket() {
+		var err error
+		credentials, err = pm.ca.issueBackendCredentials(instance.InstanceID)
+		if err == nil {
+			err = writeTLSFiles(instance, credentials)
+		}
+		if err != nil {
+			pm.logger.Error("Failed to issue TLS certificates", "instanceID", instance.InstanceID, "error", err)
+			pm.portManager.ReleasePort(port)
+			pm.mu.Lock()
+			if proc, ok := pm.actualState[instance.InstanceID]; ok {
+				proc.UpdateState(StateFailed)
+			}
+			pm.mu.Unlock()
+			return err
+		}
 	}
 
-	binPath := filepath.Join(instance.PkgPath, "bin", "krunclient")
-	pm.logger.Info("Starting process with command line", binPath, strings.Join(cmdArgs, " "))
+	binPath, cmdArgs, workDir := instance.Command(listenArg)
+	pm.logger.Info("Starting process with command line", "instanceID", instance.InstanceID, "binary", binPath, "args", strings.Join(cmdArgs, " "), "dir", workDir)
 	cmd := exec.CommandContext(ctx, binPath, cmdArgs...)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, fmt.Sprintf("HOST=%s", instance.HostName))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("INTERNAL_SECRET=%s", pm.internalSecret))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("LD_LIBRARY_PATH=%s", filepath.Join(instance.PkgPath, "lib")))
-	cmd.Dir = instance.PkgPath
+	configureCommand(cmd)
+	cmd.Env = append(os.Environ(), instance.Environment()...)
+	if useShutdownFile {
+		if err := removeShutdownFile(instance); err != nil {
+			pm.logger.Warn("Failed to remove stale shutdown file", "instanceID", instance.InstanceID, "error", err)
+		}
+	}
+	internalSecret := pm.secrets.Current()
+	if err := writeSecretFile(instance, internalSecret); err != nil {
+		pm.logger.Warn("Failed to write internal secret file, app will not see rotations", "instanceID", instance.InstanceID, "error", err)
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("INTERNAL_SECRET=%s", internalSecret))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("INTERNAL_SECRET_FILE=%s", processPath(instance, guestSecretFile)))
+	if credentials != nil {
+		cmd.Env = append(cmd.Env, tlsEnv(instance)...)
+	}
+	cmd.Dir = workDir
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
 		pm.logger.Error("Failed to get stdout pipe", "instanceID", instance.InstanceID, "error", err)
@@ -749,7 +948,7 @@ func (pm *ProcessManager) startProcess(ctx context.Context, instance AppInstance
 			proc.UpdateState(StateFailed)
 		}
 		pm.mu.Unlock()
-		return
+		return err
 	}
 
 	stderrPipe, err := cmd.StderrPipe()
@@ -762,7 +961,7 @@ func (pm *ProcessManager) startProcess(ctx context.Context, instance AppInstance
 			proc.UpdateState(StateFailed)
 		}
 		pm.mu.Unlock()
-		return
+		return err
 	}
 
 	if err := cmd.Start(); err != nil {
@@ -773,87 +972,114 @@ func (pm *ProcessManager) startProcess(ctx context.Context, instance AppInstance
 			proc.UpdateState(StateFailed)
 		}
 		pm.mu.Unlock()
-		return
+		return err
 	}
 
-	mp := NewManagedProcess(instance, cmd, port)
-	mp.UpdateState(StateRunning) // Initially assume running, health check will verify
-
-	// Set up log buffer callback to notify ProcessManager when new log entries are added
-	if mp.LogBuffer != nil {
-		mp.LogBuffer.AddCallback(func(logEntry ProcessLogEntry) {
-			pm.notifyLogCallbacks(instance.InstanceID, logEntry)
-		})
+	if credentials != nil {
+		RegisterBackendTLS(instance.BackendHost(port), credentials.client)
 	}
 
-	pm.mu.Lock()
-	pm.actualState[instance.InstanceID] = mp
-	pm.mu.Unlock()
+	mp := newManagedProcess(instance, cmd, port, pm.clock)
+	pm.addProcess(mp, previous)
 
 	pm.logger.Info("Subprocess starting", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "port", port, "command", cmd.String())
 
-	// Goroutine to log stdout
-	pm.wg.Add(1)
-	go func() {
-		defer pm.wg.Done()
-		defer stdoutPipe.Close()
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			message := scanner.Text()
-			// Log to structured logger as before
-			pm.logger.Info("Subprocess stdout", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "output", message)
-			// Add to log buffer
-			if mp.LogBuffer != nil {
-				mp.LogBuffer.AddEntry("info", "stdout", message, cmd.Process.Pid)
-			}
+	// Lines logged by applib carry their level; the rest of stderr is taken
+	// as errors
+	logFile := pm.instanceLogFile(instance.InstanceID)
+	pm.wg.Add(2)
+	go pm.readOutput(ctx, mp, stdoutPipe, "stdout", "info", logFile)
+	go pm.readOutput(ctx, mp, stderrPipe, "stderr", "error", logFile)
+
+	pm.logger.Info("Subprocess started successfully and output streams captured", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "port", port)
+
+	// Trigger immediate health check for the new process
+	pm.TriggerHealthCheck()
+
+	pm.watchExit(ctx, mp, cmd.Wait)
+	return nil
+}
+
+// readOutput captures the lines a process writes to one of its output
+// streams, logging them and adding them to its log buffer and log file.
+func (pm *ProcessManager) readOutput(ctx context.Context, mp *ManagedProcess, pipe io.ReadCloser, source, defaultLevel string, logFile *logFile) {
+	defer pm.wg.Done()
+	defer pipe.Close()
+	instanceID := mp.Instance.InstanceID
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		message := scanner.Text()
+		level := parseLogLevel(message, defaultLevel)
+		if logFile == nil || !pm.logFileConfig.FilesOnly {
+			pm.logger.Log(ctx, slogLevel(level), "Subprocess "+source, "instanceID", instanceID, "pid", mp.PID, "output", message)
 		}
-		if err := scanner.Err(); err != nil {
-			pm.logger.Error("Error reading stdout from subprocess", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "error", err)
+		entry := ProcessLogEntry{Timestamp: time.Now(), Level: level, Source: source, Message: message, PID: mp.PID, TraceID: parseTraceID(message)}
+		if mp.LogBuffer != nil {
+			entry = mp.LogBuffer.AddEntry(level, source, message, mp.PID)
 		}
-	}()
-
-	// Goroutine to log stderr
-	pm.wg.Add(1)
-	go func() {
-		defer pm.wg.Done()
-		defer stderrPipe.Close()
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			message := scanner.Text()
-			// Log to structured logger as before
-			pm.logger.Error("Subprocess stderr", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "output", message)
-			// Add to log buffer
-			if mp.LogBuffer != nil {
-				mp.LogBuffer.AddEntry("error", "stderr", message, cmd.Process.Pid)
+		if logFile != nil {
+			if err := logFile.Write(entry); err != nil {
+				pm.logger.Error("Failed to write to log file", "instanceID", instanceID, "error", err)
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			pm.logger.Error("Error reading stderr from subprocess", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "error", err)
-		}
-	}()
+	}
+	if err := scanner.Err(); err != nil {
+		pm.logger.Error("Error reading "+source+" from subprocess", "instanceID", instanceID, "pid", mp.PID, "error", err)
+	}
+}
 
-	pm.logger.Info("Subprocess started successfully and output streams captured", "instanceID", instance.InstanceID, "pid", cmd.Process.Pid, "port", port)
+// addProcess makes mp the instance's entry in actualState, replacing
+// previous, if any, which it inherits the history of.
+func (pm *ProcessManager) addProcess(mp *ManagedProcess, previous *ManagedProcess) {
+	if previous != nil {
+		mp.inherit(previous)
+	}
+	if !mp.Instance.RunSelfTest {
+		mp.UpdateState(StateRunning) // Initially assume running, health check will verify
+	}
+	// Otherwise it stays starting until the health check finds its self-tests passed
 
-	// Trigger immediate health check for the new process
-	pm.TriggerHealthCheck()
+	// Set up log buffer callback to notify ProcessManager when new log entries are added
+	if mp.LogBuffer != nil {
+		mp.LogBuffer.AddCallback(func(logEntry ProcessLogEntry) {
+			pm.notifyLogCallbacks(mp.Instance.InstanceID, logEntry)
+		})
+	}
 
-	// Goroutine to wait for the process to exit and handle cleanup
+	pm.mu.Lock()
+	pm.actualState[mp.Instance.InstanceID] = mp
+	if mp.GetState() == StateRunning {
+		pm.notifyInstanceReady(mp.Instance)
+	}
+	pm.mu.Unlock()
+}
+
+// watchExit waits for the process to exit with wait, in a goroutine, and
+// handles its exit.
+func (pm *ProcessManager) watchExit(ctx context.Context, mp *ManagedProcess, wait func() error) {
 	pm.wg.Add(1)
 	go func() {
 		defer pm.wg.Done()
-		err := cmd.Wait()
-		pm.handleProcessExit(ctx, mp, err)
+		mp.exitErr = wait()
+		close(mp.exited)
+		pm.handleProcessExit(ctx, mp, mp.exitErr)
 	}()
 }
 
 // stopProcess handles the logic for stopping a running subprocess.
-// It sends SIGTERM, waits for graceful shutdown, then SIGKILL if necessary.
+// It asks the process to stop (see requestStop), waits for graceful
+// shutdown, then kills it if necessary.
 // If `removeFromActual` is true, it removes the process from actualState map.
 func (pm *ProcessManager) stopProcess(ctx context.Context, process *ManagedProcess, removeFromActual bool) error {
 	process.UpdateState(StateStopping)
 	pm.logger.Info("Stopping process", "instanceID", process.Instance.InstanceID, "pid", process.PID)
 
-	if process.Cmd == nil || process.Cmd.Process == nil {
+	// The exit goroutine started with the process waits for it and clears
+	// the handle once it has exited
+	process.mu.Lock()
+	handle := process.handle
+	process.mu.Unlock()
+	if handle == nil || process.exited == nil {
 		pm.logger.Warn("Process command or process itself is nil, cannot stop", "instanceID", process.Instance.InstanceID)
 		process.UpdateState(StateStopped)
 		if removeFromActual {
@@ -862,63 +1088,54 @@ func (pm *ProcessManager) stopProcess(ctx context.Context, process *ManagedProce
 			pm.mu.Unlock()
 		}
 		pm.portManager.ReleasePort(process.Port)
+		UnregisterBackendTLS(process.Instance.BackendHost(process.Port))
 		return nil
 	}
 
 	// Attempt graceful shutdown
-	if err := process.Cmd.Process.Signal(os.Interrupt); err != nil { // os.Interrupt is often SIGINT, syscall.SIGTERM on Unix
-		pm.logger.Error("Failed to send SIGTERM to process", "instanceID", process.Instance.InstanceID, "pid", process.PID, "error", err)
+	if err := requestStop(process, handle); err != nil {
+		pm.logger.Error("Failed to ask process to stop", "instanceID", process.Instance.InstanceID, "pid", process.PID, "error", err)
 		// If signal fails, proceed to SIGKILL or log and consider it potentially stopped/crashed
 	}
 
 	gracefulShutdownTimer := time.NewTimer(pm.gracefulShutdownPeriod)
-	processExitChan := make(chan error, 1)
-
-	go func() {
-		processExitChan <- process.Cmd.Wait() // Wait for the process to exit
-	}()
 
 	select {
-	case err := <-processExitChan:
+	case <-process.exited:
 		gracefulShutdownTimer.Stop()
-		if err != nil {
+		if err := process.exitErr; err != nil {
 			pm.logger.Info("Process exited after SIGTERM (with error)", "instanceID", process.Instance.InstanceID, "pid", process.PID, "error", err)
 		} else {
 			pm.logger.Info("Process exited gracefully after SIGTERM", "instanceID", process.Instance.InstanceID, "pid", process.PID)
 		}
 	case <-gracefulShutdownTimer.C:
 		pm.logger.Warn("Process did not exit gracefully, sending SIGKILL", "instanceID", process.Instance.InstanceID, "pid", process.PID)
-		// Check if process is still valid before attempting to kill
-		if process.Cmd != nil && process.Cmd.Process != nil {
-			if err := process.Cmd.Process.Kill(); err != nil {
-				pm.logger.Error("Failed to send SIGKILL to process", "instanceID", process.Instance.InstanceID, "pid", process.PID, "error", err)
-				// At this point, the process might be orphaned or in an unrecoverable state
-				process.UpdateState(StateFailed) // Or a new state like StateOrphaned
-				// Not removing from actual state here as it might need manual intervention or further checks
-				return fmt.Errorf("failed to kill process %s (PID %d): %w", process.Instance.InstanceID, process.PID, err)
-			} else {
-				pm.logger.Info("Process killed with SIGKILL", "instanceID", process.Instance.InstanceID, "pid", process.PID)
-				// Wait for a short period to allow OS to clean up, then check Wait() again if needed
-				<-processExitChan // Should now receive the exit error from Kill
-			}
-		} else {
-			pm.logger.Info("Process already cleaned up, no need to send SIGKILL", "instanceID", process.Instance.InstanceID, "pid", process.PID)
+		if err := handle.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			pm.logger.Error("Failed to send SIGKILL to process", "instanceID", process.Instance.InstanceID, "pid", process.PID, "error", err)
+			// At this point, the process might be orphaned or in an unrecoverable state
+			process.UpdateState(StateFailed) // Or a new state like StateOrphaned
+			// Not removing from actual state here as it might need manual intervention or further checks
+			return fmt.Errorf("failed to kill process %s (PID %d): %w", process.Instance.InstanceID, process.PID, err)
 		}
+		pm.logger.Info("Process killed with SIGKILL", "instanceID", process.Instance.InstanceID, "pid", process.PID)
+		<-process.exited
 	case <-ctx.Done():
 		pm.logger.Warn("Stop process context cancelled", "instanceID", process.Instance.InstanceID, "pid", process.PID)
 		// Attempt a quick kill if context is cancelled during stop
-		process.Cmd.Process.Kill()
+		handle.Kill()
 		process.UpdateState(StateFailed)
 		return ctx.Err()
 	}
 
 	process.UpdateState(StateStopped)
 	pm.portManager.ReleasePort(process.Port)
+	UnregisterBackendTLS(process.Instance.BackendHost(process.Port))
 
 	if removeFromActual {
 		pm.mu.Lock()
 		delete(pm.actualState, process.Instance.InstanceID)
 		pm.mu.Unlock()
+		UnregisterBackendSocket(process.Instance.InstanceID)
 		pm.logger.Info("Process removed from actual state", "instanceID", process.Instance.InstanceID)
 	}
 
@@ -938,9 +1155,14 @@ func (pm *ProcessManager) handleProcessExit(ctx context.Context, process *Manage
 	// This check is important because stopProcess also releases the port.
 	if process.State != StateStopping && process.State != StateStopped {
 		pm.portManager.ReleasePort(process.Port)
+		UnregisterBackendTLS(process.Instance.BackendHost(process.Port))
 	}
 
-	process.UpdateState(StateFailed) // Mark as failed due to unexpected exit
+	// Mark as failed due to unexpected exit, stopProcess marks processes it
+	// stops as stopped
+	if currentState != StateStopping && currentState != StateStopped {
+		process.UpdateState(StateFailed)
+	}
 
 	// If the manager is stopping, or the process was intentionally stopped, don't restart.
 	select {
@@ -974,6 +1196,7 @@ func (pm *ProcessManager) handleProcessExit(ctx context.Context, process *Manage
 	if !stillDesired {
 		pm.logger.Info("Process no longer in desired state, not restarting", "instanceID", process.Instance.InstanceID)
 		delete(pm.actualState, process.Instance.InstanceID) // Clean up from actual state
+		UnregisterBackendSocket(process.Instance.InstanceID)
 		return
 	}
 
@@ -994,20 +1217,7 @@ func (pm *ProcessManager) performHealthChecks(ctx context.Context) {
 	}
 	pm.mu.RUnlock()
 
-	for _, process := range processesToCheck {
-		// Check context before each potentially long operation
-		select {
-		case <-ctx.Done():
-			pm.logger.Info("Health check context cancelled, stopping checks.")
-			return
-		case <-pm.stopChan:
-			pm.logger.Info("Manager stopping, stopping health checks.")
-			return
-		default:
-		}
-
-		pm.checkAndUpdateHealth(ctx, process)
-	}
+	pm.checkProcesses(ctx, processesToCheck)
 }
 
 // performHealthChecksForStableProcesses checks health of stable (running) processes.
@@ -1021,20 +1231,7 @@ func (pm *ProcessManager) performHealthChecksForStableProcesses(ctx context.Cont
 	}
 	pm.mu.RUnlock()
 
-	for _, process := range processesToCheck {
-		// Check context before each potentially long operation
-		select {
-		case <-ctx.Done():
-			pm.logger.Info("Health check context cancelled, stopping checks.")
-			return
-		case <-pm.stopChan:
-			pm.logger.Info("Manager stopping, stopping health checks.")
-			return
-		default:
-		}
-
-		pm.checkAndUpdateHealth(ctx, process)
-	}
+	pm.checkProcesses(ctx, processesToCheck)
 }
 
 // performHealthChecksForUnstableProcesses checks health of unstable (starting/unhealthy) processes.
@@ -1048,8 +1245,25 @@ func (pm *ProcessManager) performHealthChecksForUnstableProcesses(ctx context.Co
 	}
 	pm.mu.RUnlock()
 
-	for _, process := range processesToCheck {
-		// Check context before each potentially long operation
+	pm.checkProcesses(ctx, processesToCheck)
+}
+
+// checkProcesses checks the health of processes, running up to
+// healthCheckParallelism checks at once so that a slow check does not hold up
+// the rest. It returns once the checks it started have completed; no more are
+// started once the context is cancelled or the manager is stopping.
+func (pm *ProcessManager) checkProcesses(ctx context.Context, processes []*ManagedProcess) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	slots := make(chan struct{}, pm.healthCheckParallelism)
+	for _, process := range processes {
+		// Wait for a free slot, unless the checks should stop
+		select {
+		case <-ctx.Done():
+		case <-pm.stopChan:
+		case slots <- struct{}{}:
+		}
 		select {
 		case <-ctx.Done():
 			pm.logger.Info("Health check context cancelled, stopping checks.")
@@ -1060,7 +1274,12 @@ func (pm *ProcessManager) performHealthChecksForUnstableProcesses(ctx context.Co
 		default:
 		}
 
-		pm.checkAndUpdateHealth(ctx, process)
+		wg.Add(1)
+		go func(process *ManagedProcess) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			pm.checkAndUpdateHealth(ctx, process)
+		}(process)
 	}
 }
 
@@ -1069,6 +1288,18 @@ func (pm *ProcessManager) performHealthChecksForUnstableProcesses(ctx context.Co
 func (pm *ProcessManager) checkAndUpdateHealth(ctx context.Context, process *ManagedProcess) {
 	pm.logger.Debug("Performing health check", "instanceID", process.Instance.InstanceID, "port", process.Port)
 	newState, eventId, err := pm.healthChecker.Check(process)
+	misconfigured := pm.recordHealthCheckResult(process, err)
+
+	// A healthy process that must pass its self-tests is only running once
+	// they have
+	failureReason := ""
+	if newState == StateRunning && process.awaitingSelfTest() {
+		var selfTestErr error
+		newState, failureReason, selfTestErr = pm.checkSelfTest(process)
+		if selfTestErr != nil {
+			pm.logger.Warn("Failed to get self-test report", "instanceID", process.Instance.InstanceID, "error", selfTestErr)
+		}
+	}
 
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -1087,38 +1318,65 @@ func (pm *ProcessManager) checkAndUpdateHealth(ctx context.Context, process *Man
 
 	currentInternalState := process.GetState() // Get state from the locked process object
 
+	eventIdUnknown := process.GetEventId() < 0
 	process.UpdateEventId(eventId)
 	pm.triggerEventStateCallbacks(process.Instance.InstanceID, eventId)
 
+	if failureReason != "" {
+		pm.logger.Error("Process failed its self-tests, restarting it", "instanceID", process.Instance.InstanceID, "reason", failureReason)
+		process.mu.Lock()
+		handle := process.handle
+		process.mu.Unlock()
+		process.UpdateStateWithReason(StateFailed, failureReason)
+		// The exit handler restarts the process with backoff
+		if handle != nil {
+			if err := handle.Kill(); err != nil {
+				pm.logger.Error("Failed to kill process that failed its self-tests", "instanceID", process.Instance.InstanceID, "error", err)
+			}
+		}
+		return
+	}
+	if newState == StateStarting {
+		// Healthy but its self-tests are still running
+		if currentInternalState != StateStarting {
+			process.UpdateState(StateStarting)
+		}
+		return
+	}
+
 	if newState == StateRunning {
+		pm.processStarted(ctx, process)
 		if currentInternalState != StateRunning {
 			pm.logger.Info("Process is now healthy", "instanceID", process.Instance.InstanceID)
 			process.UpdateState(StateRunning)
 			process.unhealthySince = time.Time{} // Reset unhealthy timer
 			process.restartCount = 0             // Reset restart count on successful health after being unhealthy/failed
+			pm.notifyInstanceReady(process.Instance)
+		} else if eventIdUnknown {
+			// It was assumed running when it started, but can only be
+			// looked up once its event ID is known
+			pm.notifyInstanceReady(process.Instance)
 		}
-		process.lastHealthCh = time.Now()
+		process.lastHealthCh = pm.clock.Now()
 	} else { // Unhealthy or some other failure state from check
 		if currentInternalState == StateRunning {
 			pm.logger.Warn("Process became unhealthy", "instanceID", process.Instance.InstanceID)
 			process.UpdateState(StateUnhealthy)
-			process.unhealthySince = time.Now()
 		} else if currentInternalState == StateUnhealthy {
-			// Already unhealthy, check for consecutive failures
-			if !process.unhealthySince.IsZero() && time.Since(process.unhealthySince) >= time.Duration(pm.consecutiveFailures)*pm.healthCheckInterval {
-				pm.logger.Error("Process persistently unhealthy, triggering restart", "instanceID", process.Instance.InstanceID, "unhealthyDuration", time.Since(process.unhealthySince))
+			// Already unhealthy, check for consecutive failures. Restarting
+			// won't fix a misconfigured health check.
+			if unhealthyFor, ok := process.unhealthyFor(); ok && !misconfigured && unhealthyFor >= time.Duration(pm.consecutiveFailures)*pm.healthCheckInterval {
+				pm.logger.Error("Process persistently unhealthy, triggering restart", "instanceID", process.Instance.InstanceID, "unhealthyDuration", unhealthyFor)
 				process.UpdateState(StateFailed) // Mark as failed to trigger restart logic
-				// Unlock before calling startProcess as it will re-lock
-				desiredConfig := process.Instance // Use existing config for restart
-				pm.mu.Unlock()
+				// startProcess takes the lock once this returns
+				desiredConfig := process.Instance      // Use existing config for restart
 				go pm.startProcess(ctx, desiredConfig) // Restart logic is handled by startProcess
-				return                                 // Return early as lock is released
+				return
 			}
 		} else if currentInternalState == StateStarting {
 			// If it's still 'Starting' after a health check interval, and the check fails, mark as unhealthy.
 			pm.logger.Warn("Process failed first health check after starting", "instanceID", process.Instance.InstanceID)
 			process.UpdateState(StateUnhealthy)
-			process.unhealthySince = time.Now()
 		}
 		// If newState is StateFailed from the checker, update it directly
 		if newState == StateFailed && currentInternalState != StateFailed {
@@ -1126,7 +1384,6 @@ func (pm *ProcessManager) checkAndUpdateHealth(ctx context.Context, process *Man
 			// Potentially trigger restart if appropriate (similar to persistent unhealthiness)
 			pm.logger.Error("Health checker reported process as failed, triggering restart", "instanceID", process.Instance.InstanceID)
 			desiredConfig := process.Instance
-			pm.mu.Unlock()
 			go pm.startProcess(ctx, desiredConfig)
 			return
 		}
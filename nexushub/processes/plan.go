@@ -0,0 +1,94 @@
+package processes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// ReconcileAction is what reconciliation does with one instance.
+type ReconcileAction string
+
+const (
+	// ReconcileStart starts a process for an instance that has none, or
+	// whose process stopped or failed, after the restart backoff.
+	ReconcileStart ReconcileAction = "start"
+	// ReconcileRestart stops a process whose configuration changed. It is
+	// started with the new configuration on the next cycle.
+	ReconcileRestart ReconcileAction = "restart"
+	// ReconcileStop stops the process of an instance that is no longer
+	// desired.
+	ReconcileStop ReconcileAction = "stop"
+)
+
+// PlannedAction is an action reconciliation takes for an instance.
+type PlannedAction struct {
+	InstanceID string          `json:"instanceId"`
+	Action     ReconcileAction `json:"action"`
+	Reason     string          `json:"reason"`
+
+	instance AppInstance // Desired configuration, unless stopping
+}
+
+// ReconcilePlan lists the actions a reconciliation cycle takes, sorted by
+// instance ID. Instances that are left alone are not listed.
+type ReconcilePlan struct {
+	Actions []PlannedAction `json:"actions"`
+}
+
+// PlanReconcile returns what the next reconciliation cycle would do with the
+// current desired instances without doing it. Processes change state on
+// their own, so the plan only holds until they do.
+func (pm *ProcessManager) PlanReconcile(ctx context.Context) (ReconcilePlan, error) {
+	desiredInstances, err := pm.desiredStateProvider.GetAppInstances()
+	if err != nil {
+		return ReconcilePlan{}, fmt.Errorf("failed to get desired app instances: %w", err)
+	}
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return planReconcile(desiredInstances, pm.actualState), nil
+}
+
+// planReconcile compares desired instances with the managed processes. The
+// caller holds pm.mu.
+func planReconcile(desired []AppInstance, actual map[string]*ManagedProcess) ReconcilePlan {
+	plan := ReconcilePlan{Actions: []PlannedAction{}}
+	desiredIDs := make(map[string]bool, len(desired))
+	for _, instance := range desired {
+		desiredIDs[instance.InstanceID] = true
+		process, exists := actual[instance.InstanceID]
+		if !exists {
+			plan.add(instance.InstanceID, ReconcileStart, "not running", instance)
+			continue
+		}
+		switch process.GetState() {
+		case StateStopped:
+			plan.add(instance.InstanceID, ReconcileStart, "process stopped", instance)
+		case StateFailed:
+			plan.add(instance.InstanceID, ReconcileStart, "process failed", instance)
+		case StateRunning, StateUnhealthy, StateStarting:
+			if process.Instance.PkgPath != instance.PkgPath {
+				plan.add(instance.InstanceID, ReconcileRestart, "package changed", instance)
+			} else if !sameCommand(process.Instance, instance) {
+				plan.add(instance.InstanceID, ReconcileRestart, "command changed", instance)
+			}
+		}
+	}
+	for id, process := range actual {
+		if desiredIDs[id] {
+			continue
+		}
+		switch process.GetState() {
+		case StateRunning, StateStarting, StateUnhealthy:
+			plan.add(id, ReconcileStop, "no longer desired", AppInstance{})
+		}
+	}
+	sort.Slice(plan.Actions, func(i, j int) bool {
+		return plan.Actions[i].InstanceID < plan.Actions[j].InstanceID
+	})
+	return plan
+}
+
+func (plan *ReconcilePlan) add(id string, action ReconcileAction, reason string, instance AppInstance) {
+	plan.Actions = append(plan.Actions, PlannedAction{InstanceID: id, Action: action, Reason: reason, instance: instance})
+}
@@ -0,0 +1,58 @@
+package processes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPlanReconcile(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	pm.desiredStateProvider = staticInstances{
+		{InstanceID: "new", PkgPath: "/pkg/new"},
+		{InstanceID: "same", PkgPath: "/pkg/same"},
+		{InstanceID: "upgraded", PkgPath: "/pkg/upgraded-v2"},
+		{InstanceID: "overridden", PkgPath: "/pkg/overridden", Args: []string{"--verbose"}},
+		{InstanceID: "crashed", PkgPath: "/pkg/crashed"},
+		{InstanceID: "stopping", PkgPath: "/pkg/stopping"},
+	}
+	pm.mu.Lock()
+	for id, process := range map[string]*ManagedProcess{
+		"same":       {Instance: AppInstance{InstanceID: "same", PkgPath: "/pkg/same"}, State: StateRunning},
+		"upgraded":   {Instance: AppInstance{InstanceID: "upgraded", PkgPath: "/pkg/upgraded-v1"}, State: StateUnhealthy},
+		"overridden": {Instance: AppInstance{InstanceID: "overridden", PkgPath: "/pkg/overridden"}, State: StateStarting},
+		"crashed":    {Instance: AppInstance{InstanceID: "crashed", PkgPath: "/pkg/crashed"}, State: StateFailed},
+		"stopping":   {Instance: AppInstance{InstanceID: "stopping", PkgPath: "/pkg/stopping"}, State: StateStopping},
+		"removed":    {Instance: AppInstance{InstanceID: "removed"}, State: StateRunning},
+		"gone":       {Instance: AppInstance{InstanceID: "gone"}, State: StateStopped},
+	} {
+		pm.actualState[id] = process
+	}
+	pm.mu.Unlock()
+
+	plan, err := pm.PlanReconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, action.InstanceID+" "+string(action.Action)+": "+action.Reason)
+	}
+	expected := []string{
+		"crashed start: process failed",
+		"new start: not running",
+		"overridden restart: command changed",
+		"removed stop: no longer desired",
+		"upgraded restart: package changed",
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %q, got %q", expected, actions)
+	}
+
+	// Nothing was started or stopped
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if len(pm.actualState) != 7 || pm.actualState["removed"].GetState() != StateRunning {
+		t.Errorf("Expected the processes to be left alone, got %v", pm.actualState)
+	}
+}
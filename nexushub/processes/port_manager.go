@@ -54,10 +54,7 @@ func (pm *PortManager) AllocatePort() (int, error) {
 		}
 
 		// Check if the port is actually available by trying to listen on it
-		l, err := net.Listen("tcp", fmt.Sprintf(":%d", portToTry))
-		if err == nil {
-			// Port is available
-			l.Close() // Close the listener immediately
+		if portFree(portToTry) {
 			pm.allocated[portToTry] = true
 			return portToTry, nil
 		}
@@ -69,6 +66,19 @@ func (pm *PortManager) AllocatePort() (int, error) {
 	}
 }
 
+// portFree reports whether nothing else is listening on the port, by
+// listening on each of its portProbeAddresses.
+func portFree(port int) bool {
+	for _, address := range portProbeAddresses(port) {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return false
+		}
+		l.Close()
+	}
+	return true
+}
+
 // ReleasePort marks a previously allocated port as available again.
 func (pm *PortManager) ReleasePort(port int) {
 	pm.mu.Lock()
@@ -0,0 +1,193 @@
+package processes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+const (
+	defaultProbeCacheTTL         = 5 * time.Second
+	defaultCriticalProbeFailures = 3
+	// maxProbeDuration bounds a probe request to an instance, whatever
+	// timeout the application gives the probe
+	maxProbeDuration = 30 * time.Second
+)
+
+// ErrProbeNotFound is returned by RunProbe when the instance has no probe
+// with the name.
+var ErrProbeNotFound = errors.New("probe not found")
+
+// probeKey identifies a probe of an instance.
+type probeKey struct {
+	instanceID string
+	name       string
+}
+
+// probeRun is a probe result, or one being fetched.
+type probeRun struct {
+	done    chan struct{} // Closed once result and err are set
+	result  *types.ProbeResult
+	err     error
+	expires time.Time
+}
+
+// probeCache keeps probe results for a short time so that monitors polling
+// the hub do not run an application's probes more often than that. Requests
+// for a probe that is already running wait for its result.
+type probeCache struct {
+	mu   sync.Mutex
+	runs map[probeKey]*probeRun
+}
+
+// RunProbe runs the named probe of the instance's running process, see
+// applib.AddProbe, returning a result less than the probe cache TTL old if
+// there is one. Results of fresh runs of critical probes update whether the
+// instance is degraded: it is once the probe has failed CriticalProbeFailures
+// times in a row, until it next succeeds.
+func (pm *ProcessManager) RunProbe(ctx context.Context, id, name string) (*types.ProbeResult, error) {
+	key := probeKey{instanceID: id, name: name}
+	pm.probes.mu.Lock()
+	if pm.probes.runs == nil {
+		pm.probes.runs = make(map[probeKey]*probeRun)
+	}
+	run, ok := pm.probes.runs[key]
+	if ok {
+		select {
+		case <-run.done:
+			if pm.clock.Now().Before(run.expires) && run.err == nil {
+				pm.probes.mu.Unlock()
+				cached := *run.result
+				cached.Cached = true
+				return &cached, nil
+			}
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		run = &probeRun{done: make(chan struct{})}
+		pm.probes.runs[key] = run
+		pm.probes.mu.Unlock()
+		go pm.fetchProbe(key, run)
+	} else {
+		pm.probes.mu.Unlock()
+	}
+
+	select {
+	case <-run.done:
+		if run.err != nil {
+			return nil, run.err
+		}
+		result := *run.result
+		return &result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchProbe runs a probe for RunProbe. It is not bound to the context of
+// the request that started it, since other requests may be waiting for it.
+func (pm *ProcessManager) fetchProbe(key probeKey, run *probeRun) {
+	ctx, cancel := context.WithTimeout(context.Background(), maxProbeDuration)
+	defer cancel()
+	process, result, err := pm.probeRequest(ctx, key.instanceID, key.name)
+	if err == nil {
+		pm.recordProbeResult(process, result)
+	}
+
+	pm.probes.mu.Lock()
+	run.result, run.err = result, err
+	run.expires = pm.clock.Now().Add(pm.probeCacheTTL)
+	if err != nil {
+		// Errors reaching the probe are not cached
+		delete(pm.probes.runs, key)
+	}
+	pm.probes.mu.Unlock()
+	close(run.done)
+}
+
+// probeRequest requests /internal/probes/{name} from the instance's running
+// process.
+func (pm *ProcessManager) probeRequest(ctx context.Context, id, name string) (*ManagedProcess, *types.ProbeResult, error) {
+	pm.mu.RLock()
+	process, exists := pm.actualState[id]
+	pm.mu.RUnlock()
+	if !exists {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInstanceNotRunning, id)
+	}
+	if state := process.GetState(); state != StateRunning && state != StateUnhealthy {
+		return nil, nil, fmt.Errorf("%w: %s is %s", ErrInstanceNotRunning, id, state)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, process.Instance.BackendURL(process.Port)+"/internal/probes/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+pm.secrets.Current())
+	resp, err := BackendClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("probe %s of %s failed: %w", name, id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("%w: %s has no probe %s", ErrProbeNotFound, id, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, nil, fmt.Errorf("probe %s of %s returned status %s: %s", name, id, resp.Status, strings.TrimSpace(string(message)))
+	}
+	var result types.ProbeResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode probe %s of %s: %w", name, id, err)
+	}
+	result.Name = name
+	return process, &result, nil
+}
+
+// recordProbeResult counts consecutive failures of critical probes, marking
+// the process degraded once a probe reaches the limit and clearing it once
+// every failing probe has recovered.
+func (pm *ProcessManager) recordProbeResult(process *ManagedProcess, result *types.ProbeResult) {
+	if !result.Critical {
+		return
+	}
+	process.mu.Lock()
+	defer process.mu.Unlock()
+	if process.probeFailures == nil {
+		process.probeFailures = make(map[string]int)
+	}
+
+	if result.Healthy {
+		if process.probeFailures[result.Name] >= pm.criticalProbeFailures {
+			process.appendHistoryLocked(process.State, "probe "+result.Name+" recovered")
+		}
+		delete(process.probeFailures, result.Name)
+	} else {
+		process.probeFailures[result.Name]++
+		if process.probeFailures[result.Name] == pm.criticalProbeFailures {
+			reason := fmt.Sprintf("probe %s failed %d times: %s", result.Name, pm.criticalProbeFailures, result.Error)
+			process.appendHistoryLocked(process.State, reason)
+			pm.logger.Warn("Instance degraded", "instanceID", process.Instance.InstanceID, "probe", result.Name, "error", result.Error)
+		}
+	}
+
+	var failing []string
+	for name, failures := range process.probeFailures {
+		if failures >= pm.criticalProbeFailures {
+			failing = append(failing, name)
+		}
+	}
+	sort.Strings(failing)
+	process.degraded = failing
+}
@@ -0,0 +1,146 @@
+package processes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func TestRunProbeCachesResults(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	fakeClock := clock.NewFake(time.Now())
+	pm.clock = fakeClock
+	var runs atomic.Int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/probes/db" || r.Header.Get("Authorization") != "Bearer "+pm.secrets.Current() {
+			http.NotFound(w, r)
+			return
+		}
+		runs.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(types.ProbeResult{Name: "db", Healthy: true, LatencyMs: 2})
+	}))
+	defer backend.Close()
+	newLogLevelProcess(t, pm, backend)
+
+	// Concurrent requests share one run of the probe
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, err := pm.RunProbe(context.Background(), "app", "db"); err != nil || !result.Healthy || result.Cached {
+				t.Errorf("Unexpected result %+v: %v", result, err)
+			}
+		}()
+	}
+	for runs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	result, err := pm.RunProbe(context.Background(), "app", "db")
+	if err != nil || !result.Cached || result.LatencyMs != 2 {
+		t.Errorf("Expected a cached result, got %+v: %v", result, err)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("Expected the probe to run once, ran %d times", runs.Load())
+	}
+
+	fakeClock.Advance(defaultProbeCacheTTL)
+	if result, err := pm.RunProbe(context.Background(), "app", "db"); err != nil || result.Cached || runs.Load() != 2 {
+		t.Errorf("Expected the probe to run again once the result expired, got %+v after %d runs: %v", result, runs.Load(), err)
+	}
+
+	if _, err := pm.RunProbe(context.Background(), "app", "cache"); !errors.Is(err, ErrProbeNotFound) {
+		t.Errorf("Expected ErrProbeNotFound, got %v", err)
+	}
+	if _, err := pm.RunProbe(context.Background(), "other", "db"); !errors.Is(err, ErrInstanceNotRunning) {
+		t.Errorf("Expected ErrInstanceNotRunning, got %v", err)
+	}
+}
+
+func TestRunProbeWaitsForContext(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		json.NewEncoder(w).Encode(types.ProbeResult{Name: "db", Healthy: true})
+	}))
+	defer backend.Close()
+	defer close(release)
+	newLogLevelProcess(t, pm, backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pm.RunProbe(ctx, "app", "db"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to give up with its context, got %v", err)
+	}
+}
+
+func TestCriticalProbeFailuresDegradeInstance(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	fakeClock := clock.NewFake(time.Now())
+	pm.clock = fakeClock
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := types.ProbeResult{Healthy: healthy.Load(), Critical: r.URL.Path == "/internal/probes/db"}
+		if !result.Healthy {
+			result.Error = "connection refused"
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer backend.Close()
+	newLogLevelProcess(t, pm, backend)
+
+	probe := func(name string) {
+		t.Helper()
+		if _, err := pm.RunProbe(context.Background(), "app", name); err != nil {
+			t.Fatal(err)
+		}
+		// Only fresh results count
+		pm.RunProbe(context.Background(), "app", name)
+		fakeClock.Advance(defaultProbeCacheTTL)
+	}
+	degraded := func() []string {
+		status, _ := pm.GetInstanceStatus("app")
+		return status.Degraded
+	}
+
+	// Failures of probes that are not critical are only reported
+	for i := 0; i < defaultCriticalProbeFailures; i++ {
+		probe("cache")
+	}
+	for i := 0; i < defaultCriticalProbeFailures-1; i++ {
+		probe("db")
+	}
+	if got := degraded(); len(got) != 0 {
+		t.Errorf("Expected the instance not to be degraded yet, got %v", got)
+	}
+	probe("db")
+	status, _ := pm.GetInstanceStatus("app")
+	if len(status.Degraded) != 1 || status.Degraded[0] != "db" || status.State != StateRunning {
+		t.Errorf("Expected the instance to be degraded by db and still running, got %+v", status)
+	}
+	if last := status.History[len(status.History)-1]; last.Reason != "probe db failed 3 times: connection refused" {
+		t.Errorf("Expected the failure in the history, got %+v", last)
+	}
+
+	healthy.Store(true)
+	probe("db")
+	status, _ = pm.GetInstanceStatus("app")
+	if len(status.Degraded) != 0 || status.History[len(status.History)-1].Reason != "probe db recovered" {
+		t.Errorf("Expected the instance to recover, got %+v", status)
+	}
+}
@@ -2,15 +2,17 @@ package processes
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
 	"sync"
 	"time"
 
+	"github.com/tomyedwab/yesterday/applib/httputils"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
 	"github.com/tomyedwab/yesterday/nexushub/events"
 )
 
@@ -22,6 +24,9 @@ type ProcessLogEntry struct {
 	Source    string    `json:"source"` // "stdout" or "stderr"
 	Message   string    `json:"message"`
 	PID       int       `json:"pid"`
+	// TraceID is the trace ID of the request being served when the line was
+	// logged, if the application tagged it with one
+	TraceID string `json:"traceId,omitempty"`
 }
 
 // LogBuffer maintains a circular buffer of recent log entries
@@ -42,10 +47,10 @@ func NewLogBuffer(capacity int) *LogBuffer {
 	}
 }
 
-// AddEntry adds a new log entry to the buffer
-func (lb *LogBuffer) AddEntry(level, source, message string, pid int) {
+// AddEntry adds a new log entry to the buffer and then calls the callbacks,
+// which must not block. It returns the entry.
+func (lb *LogBuffer) AddEntry(level, source, message string, pid int) ProcessLogEntry {
 	lb.mu.Lock()
-	defer lb.mu.Unlock()
 
 	entry := ProcessLogEntry{
 		ID:        lb.nextID,
@@ -54,6 +59,7 @@ func (lb *LogBuffer) AddEntry(level, source, message string, pid int) {
 		Source:    source,
 		Message:   message,
 		PID:       pid,
+		TraceID:   parseTraceID(message),
 	}
 
 	// Add to buffer (circular buffer behavior)
@@ -63,11 +69,14 @@ func (lb *LogBuffer) AddEntry(level, source, message string, pid int) {
 	}
 	lb.entries = append(lb.entries, entry)
 	lb.nextID++
+	callbacks := append([]func(ProcessLogEntry){}, lb.callbacks...)
+	lb.mu.Unlock()
 
 	// Notify callbacks
-	for _, callback := range lb.callbacks {
-		go callback(entry) // Run in goroutine to avoid blocking
+	for _, callback := range callbacks {
+		callback(entry)
 	}
+	return entry
 }
 
 // GetEntriesFromID returns all log entries with ID greater than the specified ID
@@ -163,58 +172,176 @@ func (ps ProcessState) String() string {
 	}
 }
 
+// MarshalText encodes the state as its name.
+func (ps ProcessState) MarshalText() ([]byte, error) {
+	return []byte(ps.String()), nil
+}
+
 // ManagedProcess represents a subprocess that is being managed by the process manager.
 // It holds information about the desired AppInstance, the actual running os/exec.Cmd, and its current state.
 type ManagedProcess struct {
 	Instance  AppInstance  // The desired configuration for this process.
-	Cmd       *exec.Cmd    // The running command.
-	Port      int          // The TCP port assigned to this process.
-	PID       int          // Process ID of the running subprocess.
+	Cmd       *exec.Cmd    // The running command, nil for an embedded instance.
+	Port      int          // The TCP port assigned to this process, or 0 for a socket-mode or embedded instance.
+	PID       int          // Process ID of the running subprocess, 0 for an embedded instance.
 	State     ProcessState // Current health/lifecycle state of the process.
 	LogBuffer *LogBuffer   // Buffer for storing recent log entries from this process.
 
-	mu             sync.Mutex // Protects access to this struct's mutable fields.
-	startTime      time.Time  // Time when the process was last started.
-	lastHealthCh   time.Time  // Time of the last successful health check.
-	unhealthySince time.Time  // Time when the process first became unhealthy.
-	restartCount   int        // Number of times this process has been restarted.
+	mu             sync.Mutex     // Protects access to this struct's mutable fields.
+	startTime      time.Time      // Time when the process was last started.
+	lastHealthCh   time.Time      // Time of the last successful health check.
+	unhealthySince time.Time      // Time when the process first became unhealthy.
+	restartCount   int            // Number of times this process has been restarted.
+	selfTestPassed bool           // Whether the process has passed its self-tests.
+	failure        string         // Why the instance last failed; cleared once it is running.
+	probeFailures  map[string]int // Consecutive failures of each critical probe.
+	degraded       []string       // Critical probes that have failed too often.
+	history        []StateTransition
+
+	healthFailure       string // Error of the last failed health check.
+	healthFailures      int    // Consecutive health checks that failed with healthFailure.
+	healthMisconfigured bool   // Whether the health check keeps getting 404, see recordHealthCheckResult.
+	healthySinceStart   bool   // Whether a health check has passed since the process started, see processStarted.
 
 	currentEventId int // Current event ID for this process.
+
+	handle  processHandle // Signals the running process; cleared with Cmd.
+	exited  chan struct{} // Closed once the subprocess has exited and been waited for.
+	exitErr error         // What waiting for the subprocess returned, set before exited is closed.
+
+	clock clock.Clock // Measures how long the process has been unhealthy; nil means clock.Real.
+}
+
+// maxStateHistory is the number of state transitions kept per instance.
+const maxStateHistory = 20
+
+// StateTransition records a change of an instance's process state.
+type StateTransition struct {
+	Time   time.Time    `json:"time"`
+	State  ProcessState `json:"state"`
+	Reason string       `json:"reason,omitempty"`
 }
 
 // NewManagedProcess creates a new ManagedProcess instance.
 func NewManagedProcess(instance AppInstance, cmd *exec.Cmd, port int) *ManagedProcess {
+	return newManagedProcess(instance, cmd, port, clock.Real)
+}
+
+// processHandle is how the manager stops a running instance: the
+// os.Process of a subprocess, or the server of an embedded instance.
+type processHandle interface {
+	Signal(sig os.Signal) error
+	Kill() error
+}
+
+func newManagedProcess(instance AppInstance, cmd *exec.Cmd, port int, clk clock.Clock) *ManagedProcess {
+	mp := newProcessEntry(instance, cmd.Process, port, clk) // Assumes cmd.Process is not nil (i.e., process started)
+	mp.Cmd = cmd
+	mp.PID = cmd.Process.Pid
+	return mp
+}
+
+// newProcessEntry creates the entry for a started instance, which handle
+// stops.
+func newProcessEntry(instance AppInstance, handle processHandle, port int, clk clock.Clock) *ManagedProcess {
+	now := clk.Now()
 	return &ManagedProcess{
 		Instance:       instance,
-		Cmd:            cmd,
 		Port:           port,
-		PID:            cmd.Process.Pid,    // Assumes cmd.Process is not nil (i.e., process started)
+		handle:         handle,
 		State:          StateStarting,      // Initial state after starting
 		LogBuffer:      NewLogBuffer(1000), // Keep last 1000 log entries
-		startTime:      time.Now(),
+		startTime:      now,
+		history:        []StateTransition{{Time: now, State: StateStarting}},
 		currentEventId: -1,
+		exited:         make(chan struct{}),
+		clock:          clk,
+	}
+}
+
+func (mp *ManagedProcess) getClock() clock.Clock {
+	if mp.clock == nil {
+		return clock.Real
+	}
+	return mp.clock
+}
+
+// unhealthyFor returns how long the process has been unhealthy, measured on
+// the monotonic clock so that wall clock steps don't trigger or delay a
+// restart. ok is false if the process is not unhealthy.
+func (mp *ManagedProcess) unhealthyFor() (duration time.Duration, ok bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if mp.unhealthySince.IsZero() {
+		return 0, false
 	}
+	return mp.getClock().Since(mp.unhealthySince), true
 }
 
 // UpdateState sets the process state thread-safely.
 func (mp *ManagedProcess) UpdateState(newState ProcessState) {
+	mp.UpdateStateWithReason(newState, "")
+}
+
+// UpdateStateWithReason is UpdateState, recording why the state changed in
+// the instance's history. The reason for entering StateFailed is reported as
+// the instance's failure until it is next running.
+func (mp *ManagedProcess) UpdateStateWithReason(newState ProcessState, reason string) {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
+	if newState != mp.State || reason != "" {
+		mp.appendHistoryLocked(newState, reason)
+	}
 	mp.State = newState
 
 	switch newState {
 	case StateRunning:
-		mp.lastHealthCh = time.Now()
+		mp.lastHealthCh = mp.getClock().Now()
 		mp.unhealthySince = time.Time{} // Reset unhealthy timer
+		mp.failure = ""
 	case StateUnhealthy:
 		if mp.unhealthySince.IsZero() {
-			mp.unhealthySince = time.Now()
+			mp.unhealthySince = mp.getClock().Now()
 		}
 	case StateFailed, StateStopped:
 		mp.Cmd = nil // Clear the command as it's no longer running
+		mp.handle = nil
+	}
+	if newState == StateFailed && reason != "" {
+		mp.failure = reason
+	}
+}
+
+// recordHistory notes something that happened to the instance without
+// changing its state, e.g. a log level change, in its history.
+func (mp *ManagedProcess) recordHistory(reason string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.appendHistoryLocked(mp.State, reason)
+}
+
+func (mp *ManagedProcess) appendHistoryLocked(state ProcessState, reason string) {
+	mp.history = append(mp.history, StateTransition{Time: mp.getClock().Now(), State: state, Reason: reason})
+	if len(mp.history) > maxStateHistory {
+		mp.history = mp.history[len(mp.history)-maxStateHistory:]
 	}
 }
 
+// inherit carries the history, failure and restart count of the process
+// this one replaces over to it, so they survive restarts.
+func (mp *ManagedProcess) inherit(previous *ManagedProcess) {
+	previous.mu.Lock()
+	history := append([]StateTransition{}, previous.history...)
+	failure, restartCount := previous.failure, previous.restartCount
+	previous.mu.Unlock()
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.history = append(history, mp.history...)
+	mp.failure = failure
+	mp.restartCount = restartCount
+}
+
 func (mp *ManagedProcess) UpdateEventId(eventId int) {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
@@ -257,21 +384,42 @@ func (mp *ManagedProcess) ProcessPendingEvents(eventManager *events.EventManager
 
 	log.Printf("Process %s has pending events %d - %d", mp.Instance.InstanceID, mp.currentEventId+1, expectedEventId)
 
-	// Collect events to process in batches
-	type eventInfo struct {
-		id        int
-		eventType string
-		data      string
+	// Collect events to process in batches. Publish times are sent in the
+	// standard form, and left out for events published before they were
+	// recorded. Events published atomically carry their group ID.
+	type batchEvent struct {
+		ID          int       `json:"id"`
+		Type        string    `json:"type"`
+		Data        string    `json:"data"`
+		PublishedAt time.Time `json:"publishedAt,omitempty"`
+		GroupID     int       `json:"groupId,omitempty"`
 	}
-	var eventsToProcess []eventInfo
-
-	for eventId := mp.currentEventId + 1; eventId <= expectedEventId && len(eventsToProcess) < batchSize; eventId++ {
-		eventType, eventData, err := eventManager.GetEvent(eventId)
+	var eventsToProcess []batchEvent
+
+	// A group is always sent whole, so that the service can apply it in a
+	// single transaction, even if that takes the batch past its size or past
+	// an expected event ID read while the group was being published
+	groupEnd := 0
+	for eventId := mp.currentEventId + 1; eventId <= expectedEventId || eventId <= groupEnd; eventId++ {
+		if eventId > groupEnd && len(eventsToProcess) >= batchSize {
+			break
+		}
+		event, err := eventManager.GetEvent(eventId)
 		if err != nil {
 			return 0, err
 		}
-		if mp.Instance.Subscriptions[eventType] {
-			eventsToProcess = append(eventsToProcess, eventInfo{id: eventId, eventType: eventType, data: string(eventData)})
+		if event.GroupID == eventId {
+			end, err := eventManager.GetGroupEnd(event.GroupID)
+			if err != nil {
+				return 0, err
+			}
+			if len(eventsToProcess) > 0 && len(eventsToProcess)+end-eventId+1 > batchSize {
+				break // Send the group in the next batch
+			}
+			groupEnd = end
+		}
+		if mp.Instance.Subscriptions[event.Type] {
+			eventsToProcess = append(eventsToProcess, batchEvent{ID: eventId, Type: event.Type, Data: string(event.Data), PublishedAt: event.PublishedAt, GroupID: event.GroupID})
 		}
 	}
 
@@ -282,32 +430,17 @@ func (mp *ManagedProcess) ProcessPendingEvents(eventManager *events.EventManager
 	// Send batch of events
 	log.Printf("Sending batch of %d events to service %s", len(eventsToProcess), mp.Instance.InstanceID)
 
-	// Create batch payload
-	batchPayload := struct {
-		Events []struct {
-			ID   int    `json:"id"`
-			Type string `json:"type"`
-			Data string `json:"data"`
-		} `json:"events"`
-	}{}
-
-	for _, event := range eventsToProcess {
-		batchPayload.Events = append(batchPayload.Events, struct {
-			ID   int    `json:"id"`
-			Type string `json:"type"`
-			Data string `json:"data"`
-		}{ID: event.id, Type: event.eventType, Data: event.data})
-	}
-
 	// Marshal the batch payload
-	batchJSON, err := json.Marshal(batchPayload)
+	batchJSON, err := httputils.MarshalJSON(struct {
+		Events []batchEvent `json:"events"`
+	}{Events: eventsToProcess}, httputils.TimeFormatRFC3339)
 	if err != nil {
 		return 0, fmt.Errorf("failed to marshal batch payload: %v", err)
 	}
 
 	// Send the batch to the service
-	url := fmt.Sprintf("http://localhost:%d/internal/publish_events", mp.Port)
-	resp, err := http.Post(url, "application/json", io.NopCloser(bytes.NewReader(batchJSON)))
+	url := mp.Instance.BackendURL(mp.Port) + "/internal/publish_events"
+	resp, err := BackendClient.Post(url, "application/json", io.NopCloser(bytes.NewReader(batchJSON)))
 	if err != nil {
 		log.Printf("Failed to send batch of events to service %s: %v", mp.Instance.InstanceID, err)
 		return 0, err
@@ -321,7 +454,7 @@ func (mp *ManagedProcess) ProcessPendingEvents(eventManager *events.EventManager
 	}
 
 	// Update current event ID to the last event in the batch
-	mp.currentEventId = eventsToProcess[len(eventsToProcess)-1].id
+	mp.currentEventId = eventsToProcess[len(eventsToProcess)-1].ID
 
 	log.Printf("Done processing batch of events. Now at event %d", mp.currentEventId)
 	return mp.currentEventId, nil
@@ -332,7 +465,7 @@ func (mp *ManagedProcess) RecordRestart() {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 	mp.restartCount++
-	mp.startTime = time.Now()
+	mp.startTime = mp.getClock().Now()
 }
 
 // GetRestartCount returns the current restart count.
@@ -0,0 +1,86 @@
+package processes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+)
+
+type deliveredEvent struct {
+	ID      int    `json:"id"`
+	Type    string `json:"type"`
+	GroupID int    `json:"groupId"`
+}
+
+func TestPendingEventsKeepGroupsWhole(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "events.db"))
+	defer db.Close()
+	em, err := events.CreateEventManager(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 99; i++ {
+		if _, err := em.PublishEvent(fmt.Sprintf("single-%d", i), "A", []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groupIds, err := em.PublishEventGroup([]events.NewEvent{
+		{ClientID: "group-1", Type: "A", Data: []byte(`{}`)},
+		{ClientID: "group-2", Type: "B", Data: []byte(`{}`)},
+		{ClientID: "group-3", Type: "A", Data: []byte(`{}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groupIds[0] != 100 || groupIds[2] != 102 {
+		t.Fatalf("Expected the group to have IDs 100 - 102, got %v", groupIds)
+	}
+
+	var batches [][]deliveredEvent
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Events []deliveredEvent `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		batches = append(batches, batch.Events)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+	process := &ManagedProcess{
+		Instance: AppInstance{InstanceID: "app", Subscriptions: map[string]bool{"A": true}},
+		Port:     port,
+		State:    StateRunning,
+	}
+
+	// The group does not fit in the first batch, so it is sent in the next
+	if eventId, err := process.ProcessPendingEvents(em); err != nil || eventId != 99 {
+		t.Fatalf("Expected the first batch to end at event 99, got %d: %v", eventId, err)
+	}
+	if len(batches[0]) != 99 {
+		t.Errorf("Expected 99 events in the first batch, got %d", len(batches[0]))
+	}
+
+	// The group is sent whole even if the latest event IDs were read while
+	// it was being published
+	em.LatestEventIds["A"] = 100
+	if eventId, err := process.ProcessPendingEvents(em); err != nil || eventId != 102 {
+		t.Fatalf("Expected the second batch to end at event 102, got %d: %v", eventId, err)
+	}
+	group := batches[1]
+	if len(group) != 2 || group[0].ID != 100 || group[1].ID != 102 || group[0].GroupID != 100 || group[1].GroupID != 100 {
+		t.Errorf("Expected the subscribed events of group 100 in order, got %+v", group)
+	}
+}
@@ -0,0 +1,65 @@
+package processes
+
+import (
+	"context"
+	"fmt"
+)
+
+// instanceReadyChan returns a channel that is closed the next time the
+// instance becomes ready to serve.
+func (pm *ProcessManager) instanceReadyChan(id string) <-chan struct{} {
+	pm.readyMu.Lock()
+	defer pm.readyMu.Unlock()
+	if pm.readyWaiters == nil {
+		pm.readyWaiters = make(map[string]chan struct{})
+	}
+	ch, ok := pm.readyWaiters[id]
+	if !ok {
+		ch = make(chan struct{})
+		pm.readyWaiters[id] = ch
+	}
+	return ch
+}
+
+// notifyInstanceReady wakes everything waiting for the instance and fires the
+// OnInstanceReady callback. It is called whenever a process enters
+// StateRunning.
+func (pm *ProcessManager) notifyInstanceReady(instance AppInstance) {
+	pm.readyMu.Lock()
+	if ch, ok := pm.readyWaiters[instance.InstanceID]; ok {
+		close(ch)
+		delete(pm.readyWaiters, instance.InstanceID)
+	}
+	pm.readyMu.Unlock()
+
+	if pm.onInstanceReady != nil {
+		// Fire the callback in a separate goroutine to avoid blocking
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					pm.logger.Error("Instance ready callback panicked", "instanceID", instance.InstanceID, "error", r)
+				}
+			}()
+			pm.onInstanceReady(instance)
+		}()
+	}
+}
+
+// WaitForInstance returns the instance like GetAppInstanceByID, waiting until
+// it is ready to serve if it isn't yet. Rather than polling it wakes when the
+// process manager reports the instance ready, and gives up when ctx is done.
+func (pm *ProcessManager) WaitForInstance(ctx context.Context, id string) (*AppInstance, int, error) {
+	for {
+		// Subscribe before checking, so a transition in between isn't missed
+		ready := pm.instanceReadyChan(id)
+		instance, port, err := pm.GetAppInstanceByID(id)
+		if err == nil {
+			return instance, port, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, 0, fmt.Errorf("%w waiting for instance %s: %v", ctx.Err(), id, err)
+		}
+	}
+}
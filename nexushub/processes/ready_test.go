@@ -0,0 +1,92 @@
+package processes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+type staticInstances []AppInstance
+
+func (s staticInstances) GetAppInstances() ([]AppInstance, error) {
+	return s, nil
+}
+
+// healthyChecker reports every process as healthy
+type healthyChecker struct{}
+
+func (healthyChecker) Check(process *ManagedProcess) (ProcessState, int, error) {
+	return StateRunning, 0, nil
+}
+
+func newReadyTestManager(t *testing.T, onReady func(AppInstance)) *ProcessManager {
+	portManager, err := NewPortManager(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider: staticInstances{},
+		PortManager:      portManager,
+		HealthChecker:    healthyChecker{},
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		OnInstanceReady:  onReady,
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+func TestWaitForInstanceWakesWhenReady(t *testing.T) {
+	readyCallback := make(chan string, 1)
+	pm := newReadyTestManager(t, func(instance AppInstance) { readyCallback <- instance.InstanceID })
+
+	// A process that is up but has not passed a health check yet
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app"}, Port: 20001, State: StateUnhealthy}
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pm.checkAndUpdateHealth(context.Background(), process)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	instance, port, err := pm.WaitForInstance(ctx, "app")
+	if err != nil || instance.InstanceID != "app" || port != 20001 {
+		t.Fatalf("Expected the instance once healthy, got %+v %d: %v", instance, port, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the wait to end when the instance became ready, took %v", elapsed)
+	}
+	select {
+	case id := <-readyCallback:
+		if id != "app" {
+			t.Errorf("Expected the ready callback for app, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the ready callback to fire")
+	}
+
+	// An instance that is already ready returns right away
+	if _, _, err := pm.WaitForInstance(context.Background(), "app"); err != nil {
+		t.Errorf("Expected a ready instance to be returned, got %v", err)
+	}
+}
+
+func TestWaitForInstanceTimesOut(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := pm.WaitForInstance(ctx, "missing"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
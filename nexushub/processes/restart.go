@@ -0,0 +1,79 @@
+package processes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrInstanceNotFound is returned for requests to an instance that is not
+// installed.
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// ErrRestartFailed is returned by RestartInstance when the instance could not
+// be stopped or started again.
+var ErrRestartFailed = errors.New("restart failed")
+
+// RestartInstance stops the instance's process, which drains in-flight
+// requests like any graceful stop, and starts it again straight away with its
+// current configuration. Unlike restarts after a crash it waits for no
+// backoff, and the instance's restart count starts over. If ctx is done
+// before the process has drained it is killed and not started again, leaving
+// it to the reconciler.
+func (pm *ProcessManager) RestartInstance(ctx context.Context, id string) error {
+	desiredInstances, err := pm.desiredStateProvider.GetAppInstances()
+	if err != nil {
+		return fmt.Errorf("%w: failed to get desired instances: %v", ErrRestartFailed, err)
+	}
+	var instance *AppInstance
+	for i := range desiredInstances {
+		if desiredInstances[i].InstanceID == id {
+			instance = &desiredInstances[i]
+			break
+		}
+	}
+	if instance == nil {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, id)
+	}
+
+	pm.mu.RLock()
+	process := pm.actualState[id]
+	runCtx := pm.runCtx
+	pm.mu.RUnlock()
+	if process != nil {
+		switch process.GetState() {
+		case StateRunning, StateUnhealthy, StateStarting:
+			if process.exited == nil {
+				// A placeholder for a process that is being launched
+				return fmt.Errorf("%w: %s is already starting", ErrRestartFailed, id)
+			}
+			if err := pm.stopProcess(ctx, process, false); err != nil {
+				return fmt.Errorf("%w: failed to stop %s: %v", ErrRestartFailed, id, err)
+			}
+		case StateStopping:
+			return fmt.Errorf("%w: %s is stopping", ErrRestartFailed, id)
+		}
+	}
+
+	// Take the instance over, so that neither the reconciler nor a restart
+	// after a crash starts it at the same time
+	placeholder := &ManagedProcess{Instance: *instance, State: StateStarting, clock: pm.clock}
+	pm.mu.Lock()
+	if current := pm.actualState[id]; current != process {
+		pm.mu.Unlock()
+		return fmt.Errorf("%w: %s was started again while stopping", ErrRestartFailed, id)
+	}
+	if process != nil {
+		placeholder.inherit(process)
+	}
+	placeholder.restartCount = 0
+	placeholder.UpdateStateWithReason(StateStarting, "restarted by operator")
+	pm.actualState[id] = placeholder
+	pm.mu.Unlock()
+
+	pm.logger.Info("Restarting instance", "instanceID", id)
+	if err := pm.launchProcess(runCtx, *instance, placeholder); err != nil {
+		return fmt.Errorf("%w: failed to start %s: %v", ErrRestartFailed, id, err)
+	}
+	return nil
+}
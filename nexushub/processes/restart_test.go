@@ -0,0 +1,149 @@
+package processes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// testAppEnv makes the test binary run as a stand-in application, so that
+// tests can start it as an instance's process on any platform.
+const testAppEnv = "PROCESSES_TEST_APP"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testAppEnv) == "1" {
+		runTestApp(os.Args[1])
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runTestApp stands in for an application, noting in its package directory
+// when it is ready and when it is asked to stop and exits. Like applib, it
+// stops on an interrupt or once its shutdown file exists.
+func runTestApp(pkgPath string) {
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	os.WriteFile(filepath.Join(pkgPath, "ready"), nil, 0644)
+	shutdownFile := os.Getenv("SHUTDOWN_FILE")
+	for {
+		select {
+		case <-interrupted:
+		case <-time.After(10 * time.Millisecond):
+			if _, err := os.Stat(shutdownFile); shutdownFile == "" || err != nil {
+				continue
+			}
+		}
+		drained, _ := os.OpenFile(filepath.Join(pkgPath, "drained"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		drained.WriteString("drained\n")
+		drained.Close()
+		return
+	}
+}
+
+// testAppInstance returns an instance whose process is the test binary
+// running as runTestApp.
+func testAppInstance(t *testing.T) AppInstance {
+	t.Helper()
+	testApp, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(testAppEnv, "1")
+	return AppInstance{InstanceID: "app", HostName: "app.test", PkgPath: t.TempDir(), BinaryPath: testApp}
+}
+
+// waitForFile waits for the script to create a file in the package directory.
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", path)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRestartInstance(t *testing.T) {
+	instances := staticInstances{testAppInstance(t)}
+	pkgPath := instances[0].PkgPath
+	portManager, err := NewPortManager(20100, 20110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider:       instances,
+		PortManager:            portManager,
+		HealthChecker:          healthyChecker{},
+		GracefulShutdownPeriod: 5 * time.Second,
+		Logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	current := func() *ManagedProcess {
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		return pm.actualState["app"]
+	}
+
+	if err := pm.RestartInstance(ctx, "missing"); !errors.Is(err, ErrInstanceNotFound) {
+		t.Errorf("Expected an unknown instance not to be found, got %v", err)
+	}
+
+	pm.startProcess(ctx, instances[0])
+	first := current()
+	if first.GetState() != StateRunning {
+		t.Fatalf("Expected the instance to be running, got %s", first.GetState())
+	}
+	waitForFile(t, filepath.Join(pkgPath, "ready"))
+	first.mu.Lock()
+	first.restartCount = 5
+	first.mu.Unlock()
+
+	if err := pm.RestartInstance(ctx, "app"); err != nil {
+		t.Fatalf("RestartInstance returned error: %v", err)
+	}
+	second := current()
+	defer pm.stopProcess(ctx, second, true)
+	if second == first || second.PID == first.PID || second.GetState() != StateRunning {
+		t.Fatalf("Expected a new running process, got PID %d (%s)", second.PID, second.GetState())
+	}
+	if drained, _ := os.ReadFile(filepath.Join(pkgPath, "drained")); string(drained) != "drained\n" {
+		t.Errorf("Expected the old process to be interrupted, got %q", drained)
+	}
+	if count := second.GetRestartCount(); count != 0 {
+		t.Errorf("Expected the restart count to start over, got %d", count)
+	}
+	restarted := false
+	for _, transition := range second.history {
+		restarted = restarted || transition.Reason == "restarted by operator"
+	}
+	if !restarted || second.history[0].State != StateStarting {
+		t.Errorf("Expected the restart in the instance's history, got %+v", second.history)
+	}
+
+	// A process that fails to start is reported as such
+	instances[0].BinaryPath = filepath.Join(pkgPath, "missing")
+	err = pm.RestartInstance(ctx, "app")
+	if !errors.Is(err, ErrRestartFailed) || !strings.Contains(err.Error(), "failed to start") {
+		t.Errorf("Expected the restart to fail, got %v", err)
+	}
+	if state := current().GetState(); state != StateFailed {
+		t.Errorf("Expected the instance to have failed, got %s", state)
+	}
+}
@@ -0,0 +1,53 @@
+package processes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// guestSecretFile is where the internal secret file appears inside the
+	// application VM, whose root is the instance's package path.
+	guestSecretFile = "/secrets/internal_secret"
+)
+
+// secretFilePath returns the host path of the internal secret file for an instance.
+func secretFilePath(instance AppInstance) string {
+	return filepath.Join(instance.PkgPath, guestSecretFile)
+}
+
+// writeSecretFile atomically replaces the internal secret file for an
+// instance so that the application picks up the new value on its next
+// cross-service request.
+func writeSecretFile(instance AppInstance, secret string) error {
+	path := secretFilePath(instance)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(secret), 0600); err != nil {
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace secret file: %w", err)
+	}
+	return nil
+}
+
+// distributeSecret writes a rotated internal secret to every managed process.
+func (pm *ProcessManager) distributeSecret(secret string) {
+	pm.mu.RLock()
+	instances := make([]AppInstance, 0, len(pm.actualState))
+	for _, process := range pm.actualState {
+		instances = append(instances, process.Instance)
+	}
+	pm.mu.RUnlock()
+
+	for _, instance := range instances {
+		if err := writeSecretFile(instance, secret); err != nil {
+			pm.logger.Error("Failed to distribute rotated internal secret", "instanceID", instance.InstanceID, "error", err)
+		}
+	}
+}
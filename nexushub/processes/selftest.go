@@ -0,0 +1,121 @@
+package processes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// SelfTestChecker fetches the self-test report of a process whose instance
+// sets RunSelfTest.
+type SelfTestChecker interface {
+	CheckSelfTest(process *ManagedProcess) (*types.SelfTestReport, error)
+}
+
+// HTTPSelfTestChecker implements SelfTestChecker by requesting
+// /internal/selftest from the process with the internal secret.
+type HTTPSelfTestChecker struct {
+	client  *http.Client
+	secrets *secrets.Store
+}
+
+// NewHTTPSelfTestChecker creates a new HTTPSelfTestChecker.
+// requestTimeout specifies the timeout for each request.
+func NewHTTPSelfTestChecker(requestTimeout time.Duration, secretStore *secrets.Store) *HTTPSelfTestChecker {
+	return &HTTPSelfTestChecker{
+		client: &http.Client{
+			Transport: BackendClient.Transport,
+			Timeout:   requestTimeout,
+		},
+		secrets: secretStore,
+	}
+}
+
+// CheckSelfTest returns the process's self-test report.
+func (c *HTTPSelfTestChecker) CheckSelfTest(process *ManagedProcess) (*types.SelfTestReport, error) {
+	req, err := http.NewRequest(http.MethodGet, process.Instance.BackendURL(process.Port)+"/internal/selftest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.secrets.Current())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("self-test request for %s failed: %w", process.Instance.InstanceID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("self-test for %s returned status %s", process.Instance.InstanceID, resp.Status)
+	}
+	var report types.SelfTestReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode self-test report for %s: %w", process.Instance.InstanceID, err)
+	}
+	return &report, nil
+}
+
+// awaitingSelfTest reports whether the process must pass its self-tests
+// before it is running.
+func (mp *ManagedProcess) awaitingSelfTest() bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.Instance.RunSelfTest && !mp.selfTestPassed
+}
+
+// checkSelfTest gates a healthy process on its self-tests. It returns
+// StateRunning once they have passed, StateStarting while they are still
+// running and StateFailed with the reason if any failed.
+func (pm *ProcessManager) checkSelfTest(process *ManagedProcess) (ProcessState, string, error) {
+	report, err := pm.selfTestChecker.CheckSelfTest(process)
+	if err != nil {
+		// An application that is still starting may not serve the report
+		// yet, so this only delays the running state
+		return StateStarting, "", err
+	}
+	switch report.Status {
+	case types.SelfTestPassed:
+		process.mu.Lock()
+		process.selfTestPassed = true
+		process.mu.Unlock()
+		return StateRunning, "", nil
+	case types.SelfTestFailed:
+		return StateFailed, "self-test failed: " + strings.Join(report.FailedTests(), ", "), nil
+	default:
+		return StateStarting, "", nil
+	}
+}
+
+// InstanceStatus describes the state of an instance's process.
+type InstanceStatus struct {
+	State ProcessState `json:"state"`
+	// Failure is why the instance last failed, e.g. the self-tests that
+	// failed. It is cleared once the instance is running.
+	Failure string `json:"failure,omitempty"`
+	// Degraded lists the critical probes that have failed repeatedly, see
+	// RunProbe. The instance keeps serving while it is degraded.
+	Degraded []string          `json:"degraded,omitempty"`
+	History  []StateTransition `json:"history"`
+}
+
+// GetInstanceStatus returns the status of an instance's process, whether or
+// not it is running, or false if the process manager has not started it.
+func (pm *ProcessManager) GetInstanceStatus(id string) (*InstanceStatus, bool) {
+	pm.mu.RLock()
+	process, exists := pm.actualState[id]
+	pm.mu.RUnlock()
+	if !exists {
+		return nil, false
+	}
+	process.mu.Lock()
+	defer process.mu.Unlock()
+	return &InstanceStatus{
+		State:    process.State,
+		Failure:  process.failure,
+		Degraded: append([]string(nil), process.degraded...),
+		History:  append([]StateTransition{}, process.history...),
+	}, true
+}
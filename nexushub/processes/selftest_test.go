@@ -0,0 +1,88 @@
+package processes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// staticSelfTestChecker reports the same self-test report for every process
+type staticSelfTestChecker struct {
+	report types.SelfTestReport
+}
+
+func (c *staticSelfTestChecker) CheckSelfTest(process *ManagedProcess) (*types.SelfTestReport, error) {
+	report := c.report
+	return &report, nil
+}
+
+// newSelfTestProcess returns a process that has just started, without a
+// subprocess behind it
+func newSelfTestProcess() *ManagedProcess {
+	return &ManagedProcess{
+		Instance: AppInstance{InstanceID: "app", RunSelfTest: true},
+		Port:     20001,
+		State:    StateStarting,
+		history:  []StateTransition{{Time: time.Now(), State: StateStarting}},
+	}
+}
+
+func TestSelfTestGatesRunningState(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	checker := &staticSelfTestChecker{report: types.SelfTestReport{Status: types.SelfTestRunning}}
+	pm.selfTestChecker = checker
+
+	process := newSelfTestProcess()
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+
+	// Healthy, but the self-tests have not finished
+	pm.checkAndUpdateHealth(context.Background(), process)
+	if state := process.GetState(); state != StateStarting {
+		t.Fatalf("Expected the process to stay starting while its self-tests run, got %s", state)
+	}
+
+	checker.report = types.SelfTestReport{Status: types.SelfTestPassed}
+	pm.checkAndUpdateHealth(context.Background(), process)
+	if state := process.GetState(); state != StateRunning {
+		t.Fatalf("Expected the process to be running once its self-tests passed, got %s", state)
+	}
+	if _, _, err := pm.GetAppInstanceByID("app"); err != nil {
+		t.Errorf("Expected the instance to be served, got %v", err)
+	}
+}
+
+func TestSelfTestFailureIsReported(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	pm.selfTestChecker = &staticSelfTestChecker{report: types.SelfTestReport{
+		Status: types.SelfTestFailed,
+		Tests: []types.SelfTestResult{
+			{Name: "schema", Passed: true},
+			{Name: "config", Passed: false, Error: "SMTP_HOST is not set"},
+		},
+	}}
+
+	process := newSelfTestProcess()
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+
+	pm.checkAndUpdateHealth(context.Background(), process)
+	if _, _, err := pm.GetAppInstanceByID("app"); err == nil {
+		t.Error("Expected an instance that failed its self-tests not to be served")
+	}
+	status, ok := pm.GetInstanceStatus("app")
+	if !ok {
+		t.Fatal("Expected the instance status")
+	}
+	if status.State != StateFailed || status.Failure != "self-test failed: config" {
+		t.Errorf("Expected the failed self-test to be reported, got %+v", status)
+	}
+	last := status.History[len(status.History)-1]
+	if len(status.History) != 2 || status.History[0].State != StateStarting || last.State != StateFailed || last.Reason != status.Failure {
+		t.Errorf("Unexpected history %+v", status.History)
+	}
+}
@@ -0,0 +1,41 @@
+package processes
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// guestShutdownFile is where the hub creates a file to ask a process to stop
+// on platforms where it can't be interrupted, relative to the guest root. It
+// is passed to the process as SHUTDOWN_FILE, which applib watches.
+const guestShutdownFile = "/run/shutdown"
+
+// shutdownFilePath returns the host path of an instance's shutdown file.
+func shutdownFilePath(instance AppInstance) string {
+	return filepath.Join(instance.PkgPath, guestShutdownFile)
+}
+
+// writeShutdownFile asks the instance's process to stop.
+func writeShutdownFile(instance AppInstance) error {
+	path := shutdownFilePath(instance)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create shutdown file directory: %w", err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return fmt.Errorf("failed to write shutdown file: %w", err)
+	}
+	return nil
+}
+
+// removeShutdownFile removes a shutdown file left behind by a previous
+// process of the instance, which would stop the next one as soon as it
+// starts.
+func removeShutdownFile(instance AppInstance) error {
+	if err := os.Remove(shutdownFilePath(instance)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove shutdown file: %w", err)
+	}
+	return nil
+}
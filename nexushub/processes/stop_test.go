@@ -0,0 +1,53 @@
+package processes
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShutdownFileStopsProcess(t *testing.T) {
+	instance := testAppInstance(t)
+	if err := writeShutdownFile(instance); err != nil {
+		t.Fatal(err)
+	}
+	// A file left behind by the previous process doesn't stop the next one
+	if err := removeShutdownFile(instance); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeShutdownFile(instance); err != nil {
+		t.Errorf("Expected removing a missing shutdown file to succeed, got %v", err)
+	}
+
+	cmd := exec.Command(instance.BinaryPath, instance.PkgPath)
+	cmd.Env = append(os.Environ(), "SHUTDOWN_FILE="+shutdownFilePath(instance))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	waitForFile(t, filepath.Join(instance.PkgPath, "ready"))
+	select {
+	case err := <-exited:
+		t.Fatalf("Expected the process to wait for its shutdown file, exited with %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := writeShutdownFile(instance); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("Expected the process to exit cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("Expected the shutdown file to stop the process")
+	}
+	if drained, _ := os.ReadFile(filepath.Join(instance.PkgPath, "drained")); string(drained) != "drained\n" {
+		t.Errorf("Expected the process to drain, got %q", drained)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package processes
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// defaultBinaryName is the executable in a package's bin directory that runs
+// it, see AppInstance.Command.
+const defaultBinaryName = "krunclient"
+
+// useShutdownFile is whether processes are asked to stop with a shutdown
+// file. They are interrupted instead.
+const useShutdownFile = false
+
+// configureCommand prepares a process's command for requestStop.
+func configureCommand(cmd *exec.Cmd) {}
+
+// requestStop asks a process to shut down gracefully by sending it SIGINT,
+// which applib handles.
+func requestStop(process *ManagedProcess, handle processHandle) error {
+	return handle.Signal(os.Interrupt)
+}
+
+// processPath returns the path a process sees a file of its instance at,
+// given its guest path. Packages run in a VM rooted at their package path.
+func processPath(instance AppInstance, guestPath string) string {
+	return guestPath
+}
+
+// isExecutable reports whether a file can be run, by its execute permission
+// bits.
+func isExecutable(info os.FileInfo) bool {
+	return info.Mode().Perm()&0111 != 0
+}
+
+// portProbeAddresses are the addresses AllocatePort listens on to check that
+// a port is free. The wildcard address conflicts with any other listener on
+// the port.
+func portProbeAddresses(port int) []string {
+	return []string{fmt.Sprintf(":%d", port)}
+}
@@ -0,0 +1,73 @@
+//go:build windows
+
+package processes
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// defaultBinaryName is the executable in a package's bin directory that runs
+// it, see AppInstance.Command.
+const defaultBinaryName = "krunclient.exe"
+
+// useShutdownFile is whether processes are asked to stop with a shutdown
+// file. Windows has no signal the hub can reliably send a child: console
+// control events only reach processes attached to the hub's console, which a
+// hub running as a service doesn't have.
+const useShutdownFile = true
+
+// configureCommand starts the process in its own process group, so that
+// requestStop can send it CTRL_BREAK_EVENT without also reaching the hub,
+// and a Ctrl+C meant for the hub doesn't skip the graceful shutdown.
+func configureCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// requestStop asks a process to shut down gracefully by writing its shutdown
+// file, which applib watches, and sending its process group
+// CTRL_BREAK_EVENT, which Go programs receive as os.Interrupt. Either is
+// enough, so it only fails if both do. Embedded applications are signalled
+// directly.
+func requestStop(process *ManagedProcess, handle processHandle) error {
+	if _, ok := handle.(*os.Process); !ok {
+		return handle.Signal(os.Interrupt)
+	}
+	fileErr := writeShutdownFile(process.Instance)
+	ctrlErr := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(process.PID))
+	if fileErr != nil && ctrlErr != nil {
+		return errors.Join(fileErr, fmt.Errorf("failed to send CTRL_BREAK_EVENT: %w", ctrlErr))
+	}
+	return nil
+}
+
+// processPath returns the path a process sees a file of its instance at,
+// given its guest path. Processes run directly on Windows hosts, so this is
+// the host path under the package path.
+func processPath(instance AppInstance, guestPath string) string {
+	return filepath.Join(instance.PkgPath, filepath.FromSlash(guestPath))
+}
+
+// isExecutable reports whether a file can be run, by its extension, since
+// Windows has no execute permission bits.
+func isExecutable(info os.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(info.Name())) {
+	case ".exe", ".com", ".bat", ".cmd":
+		return true
+	}
+	return false
+}
+
+// portProbeAddresses are the addresses AllocatePort listens on to check that
+// a port is free: the loopback address applib listens on. Listening on the
+// wildcard address would trigger a firewall prompt for the hub.
+func portProbeAddresses(port int) []string {
+	return []string{fmt.Sprintf("127.0.0.1:%d", port)}
+}
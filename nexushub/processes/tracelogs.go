@@ -0,0 +1,157 @@
+package processes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/applib/httputils"
+)
+
+// maxTraceLogLine bounds the lines read back from log files, so that one
+// runaway line does not stop the search.
+const maxTraceLogLine = 1 << 20
+
+// parseTraceID returns the trace ID an applib application tags the lines it
+// logs while serving a request with. The tag is the last attribute of the
+// line ("... msg=... trace_id=<id>"), so it is never inside the message; in
+// a logger with groups it is qualified by them ("group.trace_id=<id>").
+func parseTraceID(line string) string {
+	key := httputils.TraceIDLogKey + "="
+	i := strings.LastIndex(line, key)
+	if i < 1 || (line[i-1] != ' ' && line[i-1] != '.') {
+		return ""
+	}
+	value := line[i+len(key):]
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return ""
+		}
+		return unquoted
+	}
+	if strings.ContainsAny(value, " \t\"") {
+		return ""
+	}
+	return value
+}
+
+// LogPosition is where an instance's output stood at some point: the
+// process writing it and the ID of its last entry in the log buffer.
+type LogPosition struct {
+	PID int   `json:"pid"`
+	ID  int64 `json:"id"`
+}
+
+// GetLogPosition returns the current position of the instance's output, or
+// false if it has no process.
+func (pm *ProcessManager) GetLogPosition(instanceID string) (LogPosition, bool) {
+	pm.mu.RLock()
+	process, exists := pm.actualState[instanceID]
+	pm.mu.RUnlock()
+	if !exists {
+		return LogPosition{}, false
+	}
+	position := LogPosition{PID: process.PID}
+	if process.LogBuffer != nil {
+		position.ID = process.LogBuffer.GetLatestID()
+	}
+	return position, true
+}
+
+// GetTraceLogs returns the instance's log entries tagged with the trace ID
+// that were written after from, oldest first. With log files enabled they
+// are read from the files written to since the given time, which outlast
+// the buffer; otherwise they come from the buffer of the instance's current
+// process, and are lost once it restarts or the buffer wraps.
+func (pm *ProcessManager) GetTraceLogs(instanceID, traceID string, from LogPosition, since time.Time) ([]ProcessLogEntry, error) {
+	if pm.logFileConfig != nil {
+		return traceLogsFromFiles(filepath.Join(pm.logFileConfig.Dir, instanceID+".log"), traceID, since)
+	}
+
+	entries := []ProcessLogEntry{}
+	pm.mu.RLock()
+	process, exists := pm.actualState[instanceID]
+	pm.mu.RUnlock()
+	if !exists || process.LogBuffer == nil {
+		return entries, nil
+	}
+	// Positions in the buffer of an earlier process mean nothing to this one
+	fromID := int64(0)
+	if process.PID == from.PID {
+		fromID = from.ID
+	}
+	for _, entry := range process.LogBuffer.GetEntriesFromID(fromID) {
+		if entry.TraceID == traceID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// traceLogsFromFiles reads the entries tagged with the trace ID from the log
+// file at path and its rotated files last written to at or after since.
+func traceLogsFromFiles(path, traceID string, since time.Time) ([]ProcessLogEntry, error) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	entries := []ProcessLogEntry{}
+	for _, name := range append(rotated, path) {
+		info, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			continue // Rotated away since the glob
+		} else if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Before(since) {
+			continue
+		}
+		found, err := readTraceLogs(name, traceID)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	return entries, nil
+}
+
+func readTraceLogs(path, traceID string) ([]ProcessLogEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []ProcessLogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxTraceLogLine)
+	quoted, err := json.Marshal(traceID)
+	if err != nil {
+		return nil, err
+	}
+	needle := append([]byte(`"traceId":`), quoted...)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.Contains(line, needle) {
+			continue
+		}
+		var entry ProcessLogEntry
+		if err := json.Unmarshal(line, &entry); err != nil || entry.TraceID != traceID {
+			continue // A line being written, or the ID quoted in a message
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
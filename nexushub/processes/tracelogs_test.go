@@ -0,0 +1,103 @@
+package processes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+)
+
+func TestParseTraceID(t *testing.T) {
+	for line, expected := range map[string]string{
+		`time=2026-10-17T02:00:00Z level=INFO msg=serving module=http trace_id=abc-123`:   "abc-123",
+		`time=2026-10-17T02:00:00Z level=INFO msg=serving trace_id="with space"`:          "with space",
+		`time=2026-10-17T02:00:00Z level=INFO msg="see trace_id=abc-123 later" module=db`: "",
+		`time=2026-10-17T02:00:00Z level=INFO msg="quoted trace_id=x"`:                    "",
+		`time=2026-10-17T02:00:00Z level=INFO msg=serving db.query=x db.trace_id=abc-123`: "abc-123",
+		`time=2026-10-17T02:00:00Z level=INFO msg=serving parent_trace_id=abc-123`:        "",
+		`plain output`: "",
+	} {
+		if traceID := parseTraceID(line); traceID != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, line, traceID)
+		}
+	}
+}
+
+func TestGetTraceLogsFromBuffer(t *testing.T) {
+	pm := newReadyTestManager(t, nil)
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app"}, PID: 100, LogBuffer: NewLogBuffer(10)}
+	pm.mu.Lock()
+	pm.actualState["app"] = process
+	pm.mu.Unlock()
+
+	process.LogBuffer.AddEntry("info", "stderr", "level=INFO msg=before trace_id=t1", 100)
+	from, ok := pm.GetLogPosition("app")
+	if !ok || from != (LogPosition{PID: 100, ID: 1}) {
+		t.Fatalf("Unexpected position %+v", from)
+	}
+	process.LogBuffer.AddEntry("info", "stderr", "level=INFO msg=during trace_id=t1", 100)
+	process.LogBuffer.AddEntry("info", "stderr", "level=INFO msg=other trace_id=t2", 100)
+	process.LogBuffer.AddEntry("error", "stderr", "level=ERROR msg=failed trace_id=t1", 100)
+
+	entries, err := pm.GetTraceLogs("app", "t1", from, time.Time{})
+	if err != nil || len(entries) != 2 || entries[0].Message != "level=INFO msg=during trace_id=t1" || entries[1].Level != "error" {
+		t.Errorf("Expected the two entries after the position, got %+v: %v", entries, err)
+	}
+
+	// After a restart the whole buffer of the new process is searched
+	entries, _ = pm.GetTraceLogs("app", "t1", LogPosition{PID: 99, ID: 3}, time.Time{})
+	if len(entries) != 3 {
+		t.Errorf("Expected every entry of the new process, got %+v", entries)
+	}
+}
+
+func TestGetTraceLogsFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	clk := clock.NewFake(time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC))
+	config := LogFileConfig{Dir: dir, MaxSize: 300}.withDefaults()
+	file := newLogFile(config, "app", clk)
+	for i, message := range []string{"one", "two", "three", "four"} {
+		traceID := "t1"
+		if i == 1 {
+			traceID = "t2"
+		}
+		entry := ProcessLogEntry{ID: int64(i + 1), Timestamp: clk.Now(), Level: "info", Message: message, TraceID: traceID}
+		if err := file.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Millisecond)
+	}
+	file.Close()
+	rotated, _ := filepath.Glob(filepath.Join(dir, "app.log.*"))
+	if len(rotated) == 0 {
+		t.Fatal("Expected the file to be rotated")
+	}
+
+	pm := newReadyTestManager(t, nil)
+	pm.logFileConfig = &config
+	entries, err := pm.GetTraceLogs("app", "t1", LogPosition{}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	if len(messages) != 3 || messages[0] != "one" || messages[1] != "three" || messages[2] != "four" {
+		t.Errorf("Expected the entries across rotated files in order, got %v", messages)
+	}
+
+	// Files last written before the request are skipped
+	old := time.Now().Add(-time.Hour)
+	for _, name := range rotated {
+		if err := os.Chtimes(name, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ = pm.GetTraceLogs("app", "t1", LogPosition{}, time.Now().Add(-time.Minute))
+	if len(entries) != 2 || entries[0].Message != "three" {
+		t.Errorf("Expected only the current file to be read, got %+v", entries)
+	}
+}
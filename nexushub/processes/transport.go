@@ -0,0 +1,200 @@
+package processes
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Transport selects how the hub talks to an instance's process.
+type Transport string
+
+const (
+	// TransportTCP gives the process a port from the PortManager, mapped to
+	// port 80 in the guest. It is the default.
+	TransportTCP Transport = "tcp"
+	// TransportUnix has the process listen on a unix domain socket in its
+	// instance directory and uses no port at all.
+	TransportUnix Transport = "unix"
+	// TransportEmbedded runs the application in the hub's own process, see
+	// applib.RegisterEmbedded, reached through an in-memory listener. There
+	// is no process isolation.
+	TransportEmbedded Transport = "embedded"
+)
+
+// guestSocketFile is where a socket-mode app listens, relative to the guest
+// root. The guest root is the instance directory, so the host sees the
+// socket at filepath.Join(instance.PkgPath, guestSocketFile).
+const guestSocketFile = "/run/app.sock"
+
+// socketHostSuffix marks the placeholder host names used in URLs for
+// socket-mode instances, see BackendHost.
+const socketHostSuffix = ".sock"
+
+// ParseTransport validates a transport name from a package manifest. An empty
+// name means TransportTCP.
+func ParseTransport(name string) (Transport, error) {
+	switch Transport(name) {
+	case "", TransportTCP:
+		return TransportTCP, nil
+	case TransportUnix:
+		return TransportUnix, nil
+	case TransportEmbedded:
+		return TransportEmbedded, nil
+	}
+	return "", fmt.Errorf("unknown transport %q", name)
+}
+
+// UsesSocket reports whether the instance's process listens on a unix socket
+// instead of a port.
+func (instance *AppInstance) UsesSocket() bool {
+	return instance.Transport == TransportUnix
+}
+
+// Embedded reports whether the instance runs in the hub's own process.
+func (instance *AppInstance) Embedded() bool {
+	return instance.Transport == TransportEmbedded
+}
+
+// SocketPath returns the host path of the socket a socket-mode instance
+// listens on.
+func (instance *AppInstance) SocketPath() string {
+	return filepath.Join(instance.PkgPath, guestSocketFile)
+}
+
+// BackendHost returns the host to put in URLs for requests to the instance's
+// process listening on port. Socket-mode and embedded instances get a
+// placeholder host that DialBackend resolves to the instance's socket or
+// in-memory listener, so such requests must be sent with BackendClient or a
+// transport using DialBackend.
+func (instance *AppInstance) BackendHost(port int) string {
+	if instance.UsesSocket() {
+		return instance.InstanceID + socketHostSuffix
+	}
+	if instance.Embedded() {
+		return instance.InstanceID + embeddedHostSuffix
+	}
+	return net.JoinHostPort("localhost", strconv.Itoa(port))
+}
+
+// BackendURL returns the base URL for requests to the instance's process
+// listening on port, see BackendHost.
+func (instance *AppInstance) BackendURL(port int) string {
+	return "http://" + instance.BackendHost(port)
+}
+
+// backendSockets maps the instance IDs of socket-mode processes to the host
+// paths of their sockets.
+var backendSockets sync.Map
+
+// RegisterBackendSocket makes DialBackend route requests for the instance's
+// placeholder host to its socket. The process manager registers every
+// socket-mode instance it starts.
+func RegisterBackendSocket(instance AppInstance) {
+	backendSockets.Store(instance.InstanceID, instance.SocketPath())
+}
+
+// UnregisterBackendSocket forgets the socket of an instance that is no longer
+// running.
+func UnregisterBackendSocket(instanceID string) {
+	backendSockets.Delete(instanceID)
+}
+
+// prepareSocket creates the directory for the instance's socket and removes
+// any socket left behind by a previous run, which would stop the app from
+// listening.
+func prepareSocket(instance AppInstance) error {
+	path := instance.SocketPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// IsSocketHost reports whether host (with or without a port) is the
+// placeholder host of a socket-mode instance.
+func IsSocketHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.HasSuffix(host, socketHostSuffix)
+}
+
+// isEmbeddedHost reports whether host (with or without a port) is the
+// placeholder host of an embedded instance.
+func isEmbeddedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.HasSuffix(host, embeddedHostSuffix)
+}
+
+// DialBackend wraps dial so that addresses with a socket-mode placeholder
+// host connect to the instance's unix socket, addresses with an embedded
+// placeholder host to the instance's in-memory listener, and addresses
+// registered with RegisterBackendTLS are connected to over TLS with the hub's
+// client certificate. Other addresses are passed to dial unchanged.
+//
+// The TLS handshake happens here rather than in the HTTP transport, so that
+// callers keep using the http:// URLs from BackendURL.
+func DialBackend(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil && strings.HasSuffix(host, embeddedHostSuffix) {
+			return dialEmbedded(ctx, strings.TrimSuffix(host, embeddedHostSuffix))
+		}
+		if err != nil || !strings.HasSuffix(host, socketHostSuffix) {
+			config, ok := backendTLS.Load(strings.ToLower(addr))
+			conn, err := dial(ctx, network, addr)
+			if err != nil || !ok {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, config.(*tls.Config))
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+			}
+			return tlsConn, nil
+		}
+		instanceID := strings.TrimSuffix(host, socketHostSuffix)
+		path, ok := backendSockets.Load(instanceID)
+		if !ok {
+			return nil, fmt.Errorf("no socket registered for instance %s", instanceID)
+		}
+		return dial(ctx, "unix", path.(string))
+	}
+}
+
+// backendProxy is http.ProxyFromEnvironment, except that requests to socket
+// and embedded hosts never go through a proxy.
+func backendProxy(req *http.Request) (*url.URL, error) {
+	if IsSocketHost(req.URL.Host) || isEmbeddedHost(req.URL.Host) {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// NewBackendTransport returns a transport for requests to instance processes
+// that dials with dialer, reaching socket-mode and embedded instances through
+// their sockets and in-memory listeners.
+func NewBackendTransport(dialer *net.Dialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = backendProxy
+	transport.DialContext = DialBackend(dialer.DialContext)
+	return transport
+}
+
+// BackendClient is an HTTP client for requests to instance processes using
+// URLs from BackendURL.
+var BackendClient = &http.Client{Transport: NewBackendTransport(&net.Dialer{})}
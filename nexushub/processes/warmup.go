@@ -0,0 +1,128 @@
+package processes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WarmupHeader is set on the warm-up requests sent to a newly started
+// instance, so that it can tell them apart from real traffic.
+const WarmupHeader = "X-Warmup"
+
+// Warmer sends the warm-up requests of a process whose instance lists
+// Warmup paths.
+type Warmer interface {
+	WarmUp(ctx context.Context, process *ManagedProcess) error
+}
+
+// HTTPWarmer implements Warmer by requesting each of the instance's Warmup
+// paths in turn, without a user.
+type HTTPWarmer struct {
+	client *http.Client
+}
+
+// NewHTTPWarmer creates a new HTTPWarmer. requestTimeout specifies the
+// timeout for each request.
+func NewHTTPWarmer(requestTimeout time.Duration) *HTTPWarmer {
+	return &HTTPWarmer{
+		client: &http.Client{
+			Transport: BackendClient.Transport,
+			Timeout:   requestTimeout,
+		},
+	}
+}
+
+// WarmUp requests every Warmup path of the process's instance, even if some
+// fail, and returns the first failure. Any response counts as success
+// unless its status is 500 or more, since the requests are anonymous.
+func (w *HTTPWarmer) WarmUp(ctx context.Context, process *ManagedProcess) error {
+	var firstErr error
+	for _, path := range process.Instance.Warmup {
+		err := w.request(ctx, process, path)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return firstErr
+}
+
+func (w *HTTPWarmer) request(ctx context.Context, process *ManagedProcess, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, process.Instance.BackendURL(process.Port)+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(WarmupHeader, "true")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("warm-up request %s for %s failed: %w", path, process.Instance.InstanceID, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("warm-up request %s for %s returned status %s", path, process.Instance.InstanceID, resp.Status)
+	}
+	return nil
+}
+
+// EnableMetrics records how long instances take to start in registry.
+func (pm *ProcessManager) EnableMetrics(registry *prometheus.Registry) {
+	pm.startDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nexushub_instance_start_seconds",
+		Help:    "Time from starting an instance's process to its first passing health check, by instance.",
+		Buckets: []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"instance"})
+	pm.warmupDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nexushub_instance_warmup_seconds",
+		Help:    "Time taken by the warm-up requests sent to a started instance, by instance and outcome (ok or error).",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"instance", "outcome"})
+	registry.MustRegister(pm.startDurations, pm.warmupDurations)
+}
+
+// processStarted is called on a process's first passing health check since
+// it was started. It records how long the start took and sends the
+// instance's warm-up requests in the background.
+func (pm *ProcessManager) processStarted(ctx context.Context, process *ManagedProcess) {
+	process.mu.Lock()
+	if process.healthySinceStart {
+		process.mu.Unlock()
+		return
+	}
+	process.healthySinceStart = true
+	startTime := process.startTime
+	process.mu.Unlock()
+
+	instanceID := process.Instance.InstanceID
+	if !startTime.IsZero() {
+		startDuration := pm.clock.Since(startTime)
+		pm.logger.Info("Process started", "instanceID", instanceID, "duration", startDuration)
+		if pm.startDurations != nil {
+			pm.startDurations.WithLabelValues(instanceID).Observe(startDuration.Seconds())
+		}
+	}
+	if len(process.Instance.Warmup) == 0 {
+		return
+	}
+	go func() {
+		start := time.Now()
+		err := pm.warmer.WarmUp(ctx, process)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+			pm.logger.Warn("Warm-up failed", "instanceID", instanceID, "error", err)
+		} else {
+			pm.logger.Info("Process warmed up", "instanceID", instanceID, "requests", len(process.Instance.Warmup), "duration", time.Since(start))
+		}
+		if pm.warmupDurations != nil {
+			pm.warmupDurations.WithLabelValues(instanceID, outcome).Observe(time.Since(start).Seconds())
+		}
+	}()
+}
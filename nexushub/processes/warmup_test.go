@@ -0,0 +1,150 @@
+package processes
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+	"github.com/tomyedwab/yesterday/nexushub/secrets"
+)
+
+// countingWarmer records the processes it warmed up
+type countingWarmer struct {
+	mu     sync.Mutex
+	warmed []*ManagedProcess
+	done   chan struct{}
+}
+
+func (w *countingWarmer) WarmUp(ctx context.Context, process *ManagedProcess) error {
+	w.mu.Lock()
+	w.warmed = append(w.warmed, process)
+	w.mu.Unlock()
+	w.done <- struct{}{}
+	return nil
+}
+
+func (w *countingWarmer) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.warmed)
+}
+
+func TestWarmupOncePerStart(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	warmer := &countingWarmer{done: make(chan struct{}, 10)}
+	portManager, err := NewPortManager(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewProcessManager(Config{
+		InstanceProvider: staticInstances{},
+		PortManager:      portManager,
+		HealthChecker:    healthyChecker{},
+		Warmer:           warmer,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:            fake,
+	}, secrets.NewStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	pm.EnableMetrics(registry)
+
+	instance := AppInstance{InstanceID: "app", Warmup: []string{"/api/items"}}
+	start := func() *ManagedProcess {
+		process := newProcessEntry(instance, nil, 20001, fake)
+		pm.addProcess(process, nil)
+		return process
+	}
+	waitForWarmup := func() {
+		t.Helper()
+		select {
+		case <-warmer.done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the process to be warmed up")
+		}
+	}
+
+	// Assumed running when started, but only warmed up once healthy
+	process := start()
+	if warmer.count() != 0 {
+		t.Errorf("Expected no warm-up before the first health check, got %d", warmer.count())
+	}
+	fake.Advance(3 * time.Second)
+	pm.checkAndUpdateHealth(context.Background(), process)
+	waitForWarmup()
+	for i := 0; i < 3; i++ {
+		pm.checkAndUpdateHealth(context.Background(), process)
+	}
+	// Recovering from being unhealthy is not a new start either
+	process.UpdateState(StateUnhealthy)
+	pm.checkAndUpdateHealth(context.Background(), process)
+	time.Sleep(20 * time.Millisecond)
+	if warmer.count() != 1 {
+		t.Errorf("Expected one warm-up for one start, got %d", warmer.count())
+	}
+
+	// A restarted process is warmed up again
+	restarted := start()
+	pm.checkAndUpdateHealth(context.Background(), restarted)
+	waitForWarmup()
+	if warmer.count() != 2 || warmer.warmed[1] != restarted {
+		t.Errorf("Expected the restarted process to be warmed up, got %d warm-ups", warmer.count())
+	}
+
+	if count := testutil.CollectAndCount(registry, "nexushub_instance_start_seconds"); count != 1 {
+		t.Errorf("Expected start durations for one instance, got %d", count)
+	}
+	if count := testutil.CollectAndCount(registry, "nexushub_instance_warmup_seconds"); count != 1 {
+		t.Errorf("Expected warm-up durations for one instance, got %d", count)
+	}
+}
+
+func TestHTTPWarmer(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get(WarmupHeader) != "true" {
+			t.Errorf("Expected the warm-up header on %s", r.URL.Path)
+		}
+		requested = append(requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/private":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/api/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+
+	warmer := NewHTTPWarmer(time.Second)
+	process := &ManagedProcess{Instance: AppInstance{InstanceID: "app", Warmup: []string{"/api/items", "/api/private"}}, Port: port}
+	if err := warmer.WarmUp(context.Background(), process); err != nil {
+		t.Errorf("Expected client errors to count as warmed up, got %v", err)
+	}
+
+	// Every path is requested even after a failure
+	process.Instance.Warmup = []string{"/api/broken", "/api/items"}
+	if err := warmer.WarmUp(context.Background(), process); err == nil {
+		t.Error("Expected an error for a failed warm-up request")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != 4 || requested[2] != "/api/broken" || requested[3] != "/api/items" {
+		t.Errorf("Unexpected requests %v", requested)
+	}
+}
@@ -0,0 +1,494 @@
+package quotas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+)
+
+// Instance is an installed instance whose usage is collected.
+type Instance struct {
+	InstanceID    string
+	Name          string
+	Subscriptions map[string]bool
+	// DatabaseDir holds the instance's database files
+	DatabaseDir string
+	// Limits are those of the instance's package, see ParsePackageQuota
+	Limits Limits
+}
+
+// Source lists the installed instances. packages.PackageManager is the
+// hub's.
+type Source interface {
+	QuotaInstances() ([]Instance, error)
+}
+
+// EventCounter counts the events in the event log by type.
+// events.EventManager is the hub's.
+type EventCounter interface {
+	Stats(days int) (*events.EventStats, error)
+}
+
+// Usage is an instance's usage as of the last measurement, with its limits.
+type Usage struct {
+	InstanceID    string `json:"instanceId"`
+	Name          string `json:"name"`
+	DatabaseBytes int64  `json:"databaseBytes"`
+	Events        int64  `json:"events"`
+	// Limits are the hub's defaults overridden by the package's and then
+	// by the admin's
+	Limits Limits `json:"limits"`
+	// Overridden is set if an admin overrode any of the limits
+	Overridden    bool      `json:"overridden,omitempty"`
+	DatabaseLevel Level     `json:"databaseLevel"`
+	EventsLevel   Level     `json:"eventsLevel"`
+	MeasuredAt    time.Time `json:"measuredAt"`
+	// Error says why the database could not be measured; its last size is
+	// kept
+	Error string `json:"error,omitempty"`
+
+	subscriptions map[string]bool
+	packageLimits Limits
+}
+
+// Level is the highest level of the instance's resources.
+func (u *Usage) Level() Level {
+	return max(u.DatabaseLevel, u.EventsLevel)
+}
+
+// ExceededError is the error of a write rejected because an instance is at
+// a hard limit.
+type ExceededError struct {
+	InstanceID string   `json:"instanceId"`
+	Resource   Resource `json:"resource"`
+	Usage      int64    `json:"usage"`
+	Limit      int64    `json:"limit"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("instance %s is at its %s quota (%d of %d)", e.InstanceID, e.Resource, e.Usage, e.Limit)
+}
+
+// Status is the status of a rejected publish: 507 Insufficient Storage for
+// a database at its limit and 429 Too Many Requests for an event count at
+// its limit.
+func (e *ExceededError) Status() int {
+	if e.Resource == ResourceEvents {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInsufficientStorage
+}
+
+// Collector periodically measures the usage of every installed instance and
+// checks it against the instance's limits. A nil Collector enforces no
+// limits, so callers need not check whether one is configured.
+type Collector struct {
+	config    Config
+	source    Source
+	counter   EventCounter
+	overrides *Store
+	logger    *slog.Logger
+	// measure returns the size of a database directory, DirSize unless
+	// replaced by tests
+	measure func(dir string) (int64, error)
+
+	usageGauge *prometheus.GaugeVec
+	limitGauge *prometheus.GaugeVec
+
+	mu    sync.RWMutex
+	usage map[string]*Usage
+}
+
+// NewCollector returns a collector of the source's instances. overrides may
+// be nil if admins cannot override limits. Usage is unknown, and no limit is
+// enforced, until the first Check.
+func NewCollector(config Config, source Source, counter EventCounter, overrides *Store, logger *slog.Logger) *Collector {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultCheckInterval
+	}
+	return &Collector{
+		config:    config,
+		source:    source,
+		counter:   counter,
+		overrides: overrides,
+		logger:    logger,
+		measure:   DirSize,
+		usage:     make(map[string]*Usage),
+	}
+}
+
+// EnableMetrics exports the usage and limits of every instance.
+func (c *Collector) EnableMetrics(registry *prometheus.Registry) {
+	c.usageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nexushub_quota_usage",
+		Help: "Usage of instances' quota resources as of the last measurement, by instance and resource.",
+	}, []string{"instance", "resource"})
+	c.limitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nexushub_quota_limit",
+		Help: "Limits on instances' quota resources, by instance, resource and kind (soft or hard). Unlimited resources are left out.",
+	}, []string{"instance", "resource", "kind"})
+	registry.MustRegister(c.usageGauge, c.limitGauge)
+}
+
+// DirSize returns the total size of the files below dir, or zero if it does
+// not exist.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since it was listed, e.g. a checkpointed WAL
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, statErr := os.Stat(dir); errors.Is(statErr, fs.ErrNotExist) {
+			return 0, nil
+		}
+	}
+	return size, err
+}
+
+// Check measures every instance and updates their levels, logging each
+// resource that crosses a limit in either direction.
+func (c *Collector) Check() error {
+	instances, err := c.source.QuotaInstances()
+	if err != nil {
+		c.logger.Error("Failed to list instances for quota usage", "error", err)
+		return err
+	}
+	stats, err := c.counter.Stats(0)
+	if err != nil {
+		c.logger.Error("Failed to count events for quota usage", "error", err)
+		return err
+	}
+	counts := make(map[string]int64, len(stats.Types))
+	for _, typeStats := range stats.Types {
+		counts[typeStats.Type] = typeStats.Count
+	}
+	overrides := map[string]Limits{}
+	if c.overrides != nil {
+		if overrides, err = c.overrides.All(); err != nil {
+			c.logger.Error("Failed to read quota overrides", "error", err)
+			return err
+		}
+	}
+
+	now := time.Now()
+	measured := make(map[string]*Usage, len(instances))
+	for _, instance := range instances {
+		usage := &Usage{
+			InstanceID:    instance.InstanceID,
+			Name:          instance.Name,
+			MeasuredAt:    now,
+			subscriptions: instance.Subscriptions,
+			packageLimits: instance.Limits,
+		}
+		for eventType := range instance.Subscriptions {
+			usage.Events += counts[eventType]
+		}
+		size, err := c.measure(instance.DatabaseDir)
+		if err != nil {
+			c.logger.Warn("Failed to measure instance database", "instance", instance.InstanceID, "error", err)
+			usage.Error = err.Error()
+			c.mu.RLock()
+			if previous, ok := c.usage[instance.InstanceID]; ok {
+				size = previous.DatabaseBytes
+			}
+			c.mu.RUnlock()
+		}
+		usage.DatabaseBytes = size
+		override, overridden := overrides[instance.InstanceID]
+		usage.Overridden = overridden
+		usage.Limits = c.config.Defaults.Merge(instance.Limits).Merge(override)
+		usage.classify()
+		measured[instance.InstanceID] = usage
+	}
+
+	c.mu.Lock()
+	previous := c.usage
+	c.usage = measured
+	for id, usage := range measured {
+		c.logTransitions(previous[id], usage)
+	}
+	c.mu.Unlock()
+	c.exportMetrics()
+	return nil
+}
+
+func (u *Usage) classify() {
+	u.DatabaseLevel = u.Limits.DatabaseBytes.Level(u.DatabaseBytes)
+	u.EventsLevel = u.Limits.Events.Level(u.Events)
+}
+
+// logTransitions logs the resources whose level differs from the previous
+// usage, which is nil for an instance not measured before. The caller must
+// hold c.mu.
+func (c *Collector) logTransitions(previous, usage *Usage) {
+	var databaseLevel, eventsLevel Level
+	if previous != nil {
+		databaseLevel, eventsLevel = previous.DatabaseLevel, previous.EventsLevel
+	}
+	c.logTransition(usage.InstanceID, ResourceDatabaseBytes, databaseLevel, usage.DatabaseLevel, usage.DatabaseBytes, usage.Limits.DatabaseBytes)
+	c.logTransition(usage.InstanceID, ResourceEvents, eventsLevel, usage.EventsLevel, usage.Events, usage.Limits.Events)
+}
+
+func (c *Collector) logTransition(instanceID string, resource Resource, previous, level Level, usage int64, limit Limit) {
+	if level == previous {
+		return
+	}
+	attrs := []any{"instance", instanceID, "resource", resource, "usage", usage, "previous", previous.String()}
+	switch level {
+	case LevelHard:
+		c.logger.Error("Instance reached its hard quota: rejecting publishes of its events", append(attrs, "limit", limit.Hard)...)
+	case LevelSoft:
+		c.logger.Warn("Instance reached its soft quota", append(attrs, "limit", limit.Soft)...)
+	default:
+		c.logger.Info("Instance is back under its quota", attrs...)
+	}
+}
+
+func (c *Collector) exportMetrics() {
+	if c.usageGauge == nil {
+		return
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.usageGauge.Reset()
+	c.limitGauge.Reset()
+	for id, usage := range c.usage {
+		c.usageGauge.WithLabelValues(id, string(ResourceDatabaseBytes)).Set(float64(usage.DatabaseBytes))
+		c.usageGauge.WithLabelValues(id, string(ResourceEvents)).Set(float64(usage.Events))
+		for resource, limit := range map[Resource]Limit{
+			ResourceDatabaseBytes: usage.Limits.DatabaseBytes,
+			ResourceEvents:        usage.Limits.Events,
+			ResourcePackageBytes:  {Hard: usage.Limits.PackageBytes},
+		} {
+			if limit.Soft > 0 {
+				c.limitGauge.WithLabelValues(id, string(resource), "soft").Set(float64(limit.Soft))
+			}
+			if limit.Hard > 0 {
+				c.limitGauge.WithLabelValues(id, string(resource), "hard").Set(float64(limit.Hard))
+			}
+		}
+	}
+}
+
+// Run measures usage right away and then every interval until the context
+// is cancelled. It blocks, so callers should run it in a goroutine.
+func (c *Collector) Run(ctx context.Context) {
+	c.Check()
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check()
+		}
+	}
+}
+
+// Usage returns the instance's usage as of the last measurement.
+func (c *Collector) Usage(instanceID string) (Usage, bool) {
+	if c == nil {
+		return Usage{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	usage, ok := c.usage[instanceID]
+	if !ok {
+		return Usage{}, false
+	}
+	return *usage, true
+}
+
+// AllUsage returns the usage of every instance as of the last measurement,
+// ordered by instance ID.
+func (c *Collector) AllUsage() []Usage {
+	all := []Usage{}
+	if c == nil {
+		return all
+	}
+	c.mu.RLock()
+	for _, usage := range c.usage {
+		all = append(all, *usage)
+	}
+	c.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].InstanceID < all[j].InstanceID })
+	return all
+}
+
+// ErrOverridesDisabled is returned when setting an override on a collector
+// without a Store.
+var ErrOverridesDisabled = errors.New("quota overrides are not enabled")
+
+// Override returns the admin's limits for the instance, or nil if it has
+// none.
+func (c *Collector) Override(instanceID string) (*Limits, error) {
+	if c.overrides == nil {
+		return nil, nil
+	}
+	return c.overrides.Get(instanceID)
+}
+
+// SetOverride stores an admin's limits for the instance and applies them
+// right away. Limits left at zero are inherited.
+func (c *Collector) SetOverride(instanceID string, limits Limits) error {
+	if c.overrides == nil {
+		return ErrOverridesDisabled
+	}
+	if err := c.overrides.Set(instanceID, limits); err != nil {
+		return err
+	}
+	c.applyOverride(instanceID, limits, true)
+	return nil
+}
+
+// ClearOverride removes an admin's limits for the instance, restoring the
+// package's and the hub's.
+func (c *Collector) ClearOverride(instanceID string) error {
+	if c.overrides == nil {
+		return ErrOverridesDisabled
+	}
+	if err := c.overrides.Delete(instanceID); err != nil {
+		return err
+	}
+	c.applyOverride(instanceID, Limits{}, false)
+	return nil
+}
+
+func (c *Collector) applyOverride(instanceID string, override Limits, overridden bool) {
+	c.mu.Lock()
+	if usage, ok := c.usage[instanceID]; ok {
+		previous := *usage
+		usage.Overridden = overridden
+		usage.Limits = c.config.Defaults.Merge(usage.packageLimits).Merge(override)
+		usage.classify()
+		c.logTransitions(&previous, usage)
+	}
+	c.mu.Unlock()
+	c.exportMetrics()
+}
+
+// PackageLimit returns the largest package that may be uploaded for the
+// instance, or zero if any size may be.
+func (c *Collector) PackageLimit(instanceID string) int64 {
+	if c == nil {
+		return 0
+	}
+	if usage, ok := c.Usage(instanceID); ok {
+		return usage.Limits.PackageBytes
+	}
+	// Debug applications are not measured until they are installed
+	limit := c.config.Defaults.PackageBytes
+	if c.overrides != nil {
+		if override, err := c.overrides.Get(instanceID); err != nil {
+			c.logger.Warn("Failed to read quota override", "instance", instanceID, "error", err)
+		} else if override != nil && override.PackageBytes != 0 {
+			limit = override.PackageBytes
+		}
+	}
+	return limit
+}
+
+// CheckPublish returns an *ExceededError if an instance subscribing to any
+// of the event types is at a hard limit, since the events would grow it
+// further. The publish that brings an instance to its limit is allowed.
+func (c *Collector) CheckPublish(eventTypes []string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids := make([]string, 0, len(c.usage))
+	for id := range c.usage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		usage := c.usage[id]
+		if usage.Level() != LevelHard || !usage.subscribes(eventTypes) {
+			continue
+		}
+		if usage.DatabaseLevel == LevelHard {
+			return &ExceededError{InstanceID: id, Resource: ResourceDatabaseBytes, Usage: usage.DatabaseBytes, Limit: usage.Limits.DatabaseBytes.Hard}
+		}
+		return &ExceededError{InstanceID: id, Resource: ResourceEvents, Usage: usage.Events, Limit: usage.Limits.Events.Hard}
+	}
+	return nil
+}
+
+func (u *Usage) subscribes(eventTypes []string) bool {
+	for _, eventType := range eventTypes {
+		if u.subscriptions[eventType] {
+			return true
+		}
+	}
+	return false
+}
+
+// Published counts published events towards the event counts of the
+// instances subscribing to them, so that limits are enforced between
+// measurements.
+func (c *Collector) Published(eventTypes []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, usage := range c.usage {
+		previous := usage.EventsLevel
+		for _, eventType := range eventTypes {
+			if usage.subscriptions[eventType] {
+				usage.Events++
+			}
+		}
+		usage.classify()
+		c.logTransition(usage.InstanceID, ResourceEvents, previous, usage.EventsLevel, usage.Events, usage.Limits.Events)
+	}
+}
+
+// RejectPublish responds with the status of the *ExceededError and returns
+// true if CheckPublish rejects the event types. The body is a JSON object
+// with the error and the quota that was reached.
+func (c *Collector) RejectPublish(w http.ResponseWriter, eventTypes []string) bool {
+	var exceeded *ExceededError
+	if !errors.As(c.CheckPublish(eventTypes), &exceeded) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(exceeded.Status())
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": exceeded.Error(),
+		"quota": exceeded,
+	})
+	return true
+}
@@ -0,0 +1,234 @@
+package quotas
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+)
+
+// fakeHub is an injectable Source and EventCounter with database sizes set
+// per directory
+type fakeHub struct {
+	instances []Instance
+	counts    map[string]int64
+	sizes     map[string]int64
+}
+
+func (h *fakeHub) QuotaInstances() ([]Instance, error) {
+	return h.instances, nil
+}
+
+func (h *fakeHub) Stats(days int) (*events.EventStats, error) {
+	stats := &events.EventStats{}
+	for eventType, count := range h.counts {
+		stats.Types = append(stats.Types, events.EventTypeStats{Type: eventType, Count: count})
+	}
+	return stats, nil
+}
+
+func (h *fakeHub) measure(dir string) (int64, error) {
+	size, ok := h.sizes[dir]
+	if !ok {
+		return 0, errors.New("no such directory")
+	}
+	return size, nil
+}
+
+func newTestCollector(t *testing.T, hub *fakeHub, defaults Limits) *Collector {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "quotas.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	c := NewCollector(Config{Defaults: defaults}, hub, hub, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c.measure = hub.measure
+	return c
+}
+
+func newTestHub() *fakeHub {
+	return &fakeHub{
+		instances: []Instance{
+			{InstanceID: "app-a", Name: "a", Subscriptions: map[string]bool{"Todo:Add": true}, DatabaseDir: "/a"},
+			{InstanceID: "app-b", Name: "b", Subscriptions: map[string]bool{"Note:Add": true, "Todo:Add": true}, DatabaseDir: "/b"},
+		},
+		counts: map[string]int64{"Todo:Add": 5, "Note:Add": 3},
+		sizes:  map[string]int64{"/a": 100, "/b": 100},
+	}
+}
+
+func TestCollectorLevels(t *testing.T) {
+	hub := newTestHub()
+	c := newTestCollector(t, hub, Limits{DatabaseBytes: Limit{Soft: 500, Hard: 1000}, Events: Limit{Soft: 10, Hard: 20}})
+
+	if err := c.Check(); err != nil {
+		t.Fatal(err)
+	}
+	usage, ok := c.Usage("app-b")
+	if !ok || usage.Events != 8 || usage.DatabaseBytes != 100 || usage.Level() != LevelOK {
+		t.Errorf("Unexpected usage of app-b: %+v", usage)
+	}
+
+	hub.sizes["/a"] = 500
+	hub.counts["Note:Add"] = 20
+	c.Check()
+	if usage, _ := c.Usage("app-a"); usage.DatabaseLevel != LevelSoft || usage.EventsLevel != LevelOK {
+		t.Errorf("Expected app-a at its soft database limit, got %+v", usage)
+	}
+	if usage, _ := c.Usage("app-b"); usage.EventsLevel != LevelHard {
+		t.Errorf("Expected app-b at its hard event limit, got %+v", usage)
+	}
+
+	// Usage falls back under the limits, e.g. after a vacuum
+	hub.sizes["/a"] = 10
+	hub.counts["Note:Add"] = 0
+	c.Check()
+	for _, usage := range c.AllUsage() {
+		if usage.Level() != LevelOK {
+			t.Errorf("Expected %s back under its limits, got %+v", usage.InstanceID, usage)
+		}
+	}
+
+	// A database that cannot be measured keeps its last size
+	delete(hub.sizes, "/a")
+	c.Check()
+	if usage, _ := c.Usage("app-a"); usage.DatabaseBytes != 10 || usage.Error == "" {
+		t.Errorf("Expected the last size and an error, got %+v", usage)
+	}
+}
+
+func TestCollectorLimitPrecedence(t *testing.T) {
+	hub := newTestHub()
+	hub.instances[0].Limits = Limits{DatabaseBytes: Limit{Hard: 2000}, Events: Limit{Hard: 50}}
+	c := newTestCollector(t, hub, Limits{DatabaseBytes: Limit{Soft: 500, Hard: 1000}, PackageBytes: 300})
+	c.Check()
+
+	usage, _ := c.Usage("app-a")
+	expected := Limits{DatabaseBytes: Limit{Soft: 500, Hard: 2000}, Events: Limit{Hard: 50}, PackageBytes: 300}
+	if usage.Limits != expected || usage.Overridden {
+		t.Errorf("Expected the package's limits over the defaults, got %+v", usage.Limits)
+	}
+
+	// Overrides apply right away and survive the next measurement
+	if err := c.SetOverride("app-a", Limits{Events: Limit{Hard: 4}, PackageBytes: 600}); err != nil {
+		t.Fatal(err)
+	}
+	usage, _ = c.Usage("app-a")
+	if usage.Limits.Events.Hard != 4 || usage.EventsLevel != LevelHard || !usage.Overridden {
+		t.Errorf("Expected the override to apply right away, got %+v", usage)
+	}
+	c.Check()
+	if usage, _ := c.Usage("app-a"); usage.Limits.Events.Hard != 4 || usage.Limits.DatabaseBytes.Hard != 2000 {
+		t.Errorf("Expected the override after a measurement, got %+v", usage.Limits)
+	}
+	if override, err := c.Override("app-a"); err != nil || override == nil || override.PackageBytes != 600 {
+		t.Errorf("Unexpected override %+v, %v", override, err)
+	}
+	if limit := c.PackageLimit("app-a"); limit != 600 {
+		t.Errorf("Expected the overridden package limit, got %d", limit)
+	}
+
+	if err := c.ClearOverride("app-a"); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := c.Usage("app-a"); usage.Limits != expected || usage.Overridden || usage.Level() != LevelOK {
+		t.Errorf("Expected the package's limits after clearing the override, got %+v", usage)
+	}
+
+	// Debug applications are not measured but their overrides apply
+	c.SetOverride("debug-app", Limits{PackageBytes: 50})
+	if limit := c.PackageLimit("debug-app"); limit != 50 {
+		t.Errorf("Expected the debug application's override, got %d", limit)
+	}
+	if limit := c.PackageLimit("other-debug-app"); limit != 300 {
+		t.Errorf("Expected the default package limit, got %d", limit)
+	}
+
+	if err := c.SetOverride("app-a", Limits{Events: Limit{Soft: 10, Hard: 5}}); err == nil {
+		t.Error("Expected an error for a hard limit below the soft limit")
+	}
+	withoutStore := NewCollector(Config{}, hub, hub, nil, nil)
+	if err := withoutStore.SetOverride("app-a", Limits{}); !errors.Is(err, ErrOverridesDisabled) {
+		t.Errorf("Expected ErrOverridesDisabled, got %v", err)
+	}
+}
+
+func TestCollectorCheckPublish(t *testing.T) {
+	hub := newTestHub()
+	c := newTestCollector(t, hub, Limits{Events: Limit{Hard: 9}})
+
+	var nilCollector *Collector
+	if err := nilCollector.CheckPublish([]string{"Todo:Add"}); err != nil {
+		t.Errorf("Expected a nil collector to allow publishes, got %v", err)
+	}
+	// Nothing is enforced until the first measurement
+	if err := c.CheckPublish([]string{"Todo:Add"}); err != nil {
+		t.Errorf("Expected publishes before the first measurement, got %v", err)
+	}
+	c.Check()
+
+	// app-b is at 8 events: the publish that reaches 9 is allowed
+	if err := c.CheckPublish([]string{"Note:Add"}); err != nil {
+		t.Errorf("Expected the publish under the limit to be allowed, got %v", err)
+	}
+	c.Published([]string{"Note:Add"})
+	var exceeded *ExceededError
+	if err := c.CheckPublish([]string{"Note:Add"}); !errors.As(err, &exceeded) ||
+		exceeded.InstanceID != "app-b" || exceeded.Resource != ResourceEvents || exceeded.Usage != 9 || exceeded.Limit != 9 {
+		t.Errorf("Expected app-b's event quota to be exceeded, got %v", err)
+	}
+	if exceeded.Status() != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for an event quota, got %d", exceeded.Status())
+	}
+	// Events nobody at a limit subscribes to are still accepted
+	if err := c.CheckPublish([]string{"Other:Add"}); err != nil {
+		t.Errorf("Expected unrelated events to be allowed, got %v", err)
+	}
+
+	// A full database takes precedence and is reported as 507
+	hub.sizes["/b"] = 100
+	c.SetOverride("app-b", Limits{DatabaseBytes: Limit{Hard: 100}})
+	recorder := httptest.NewRecorder()
+	if !c.RejectPublish(recorder, []string{"Todo:Add"}) {
+		t.Fatal("Expected the publish to be rejected")
+	}
+	if recorder.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507, got %d", recorder.Code)
+	}
+	var body struct {
+		Error string        `json:"error"`
+		Quota ExceededError `json:"quota"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Quota.InstanceID != "app-b" || body.Quota.Resource != ResourceDatabaseBytes || body.Error == "" {
+		t.Errorf("Unexpected body %+v", body)
+	}
+	if c.RejectPublish(httptest.NewRecorder(), []string{"Other:Add"}) {
+		t.Error("Expected unrelated events not to be rejected")
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.sqlite"), make([]byte, 4096), 0644)
+	os.WriteFile(filepath.Join(dir, "app.sqlite-wal"), make([]byte, 1000), 0644)
+	if size, err := DirSize(dir); err != nil || size != 5096 {
+		t.Errorf("Expected 5096 bytes, got %d, %v", size, err)
+	}
+	if size, err := DirSize(filepath.Join(dir, "missing")); err != nil || size != 0 {
+		t.Errorf("Expected a missing directory to be empty, got %d, %v", size, err)
+	}
+}
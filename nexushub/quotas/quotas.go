@@ -0,0 +1,246 @@
+// Package quotas limits the resources each application instance uses on a
+// shared hub: the size of its database, the number of events delivered to
+// it and the size of the packages uploaded for it. A Collector measures the
+// usage of every instance periodically. Reaching a soft limit only warns;
+// reaching a hard limit rejects the event publishes that would grow the
+// instance further.
+package quotas
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/diskspace"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// DefaultCheckInterval is how often usage is measured when
+// QUOTA_CHECK_INTERVAL is unset.
+const DefaultCheckInterval = time.Minute
+
+// Resource is a resource an instance's quota limits.
+type Resource string
+
+const (
+	// ResourceDatabaseBytes is the size of the files in the instance's
+	// database directory, including the write-ahead log.
+	ResourceDatabaseBytes Resource = "databaseBytes"
+	// ResourceEvents is the number of events of the types the instance
+	// subscribes to.
+	ResourceEvents Resource = "events"
+	// ResourcePackageBytes is the size of a debug package uploaded for the
+	// instance.
+	ResourcePackageBytes Resource = "packageBytes"
+)
+
+// Level is how close an instance is to its limits.
+type Level int
+
+const (
+	// LevelOK means the usage is below the soft limit.
+	LevelOK Level = iota
+	// LevelSoft means the usage has reached the soft limit. The hub warns
+	// but keeps accepting writes.
+	LevelSoft
+	// LevelHard means the usage has reached the hard limit. Publishes of
+	// events delivered to the instance are rejected.
+	LevelHard
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelOK:
+		return "ok"
+	case LevelSoft:
+		return "soft"
+	case LevelHard:
+		return "hard"
+	}
+	return "unknown"
+}
+
+// MarshalText reports the level by name in JSON.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText parses a level marshalled by MarshalText.
+func (l *Level) UnmarshalText(text []byte) error {
+	for _, level := range []Level{LevelOK, LevelSoft, LevelHard} {
+		if string(text) == level.String() {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown quota level %q", text)
+}
+
+// Limit is a soft and a hard limit on a resource. Zero means no limit.
+type Limit struct {
+	Soft int64 `json:"soft,omitempty"`
+	Hard int64 `json:"hard,omitempty"`
+}
+
+// Level classifies a usage against the limit. A usage at a limit has
+// reached it.
+func (l Limit) Level(usage int64) Level {
+	switch {
+	case l.Hard > 0 && usage >= l.Hard:
+		return LevelHard
+	case l.Soft > 0 && usage >= l.Soft:
+		return LevelSoft
+	}
+	return LevelOK
+}
+
+func (l Limit) validate(name string) error {
+	if l.Soft < 0 || l.Hard < 0 {
+		return fmt.Errorf("%s limits must not be negative", name)
+	}
+	if l.Soft > 0 && l.Hard > 0 && l.Hard < l.Soft {
+		return fmt.Errorf("the hard %s limit must not be smaller than the soft limit", name)
+	}
+	return nil
+}
+
+// Limits are the limits on an instance's resources. PackageBytes is a single
+// maximum, since an oversized upload is refused outright.
+type Limits struct {
+	DatabaseBytes Limit `json:"databaseBytes"`
+	Events        Limit `json:"events"`
+	PackageBytes  int64 `json:"packageBytes,omitempty"`
+}
+
+// Validate checks that no limit is negative and that hard limits are not
+// below soft ones.
+func (l Limits) Validate() error {
+	if err := l.DatabaseBytes.validate("database size"); err != nil {
+		return err
+	}
+	if err := l.Events.validate("event count"); err != nil {
+		return err
+	}
+	if l.PackageBytes < 0 {
+		return fmt.Errorf("the package size limit must not be negative")
+	}
+	return nil
+}
+
+// Merge returns the limits with every non-zero limit of other in place of
+// its own, so that other can override some limits and inherit the rest.
+func (l Limits) Merge(other Limits) Limits {
+	merge := func(limit *int64, value int64) {
+		if value != 0 {
+			*limit = value
+		}
+	}
+	merge(&l.DatabaseBytes.Soft, other.DatabaseBytes.Soft)
+	merge(&l.DatabaseBytes.Hard, other.DatabaseBytes.Hard)
+	merge(&l.Events.Soft, other.Events.Soft)
+	merge(&l.Events.Hard, other.Events.Hard)
+	merge(&l.PackageBytes, other.PackageBytes)
+	return l
+}
+
+// Config is the hub's default limits and how often usage is measured.
+type Config struct {
+	Defaults Limits
+	Interval time.Duration
+}
+
+// ConfigFromEnv reads the default limits from the environment:
+//
+//	QUOTA_DATABASE_SOFT_LIMIT  database size that warns, e.g. "1GiB"
+//	QUOTA_DATABASE_HARD_LIMIT  database size that rejects publishes, e.g. "4GiB"
+//	QUOTA_EVENTS_SOFT_LIMIT    event count that warns
+//	QUOTA_EVENTS_HARD_LIMIT    event count that rejects publishes
+//	QUOTA_PACKAGE_LIMIT        largest debug package accepted, e.g. "256MiB"
+//	QUOTA_CHECK_INTERVAL       how often usage is measured, e.g. "1m"
+//
+// Every limit is unset, and so unlimited, by default.
+func ConfigFromEnv() (Config, error) {
+	config := Config{Interval: DefaultCheckInterval}
+	sizes := []struct {
+		name  string
+		limit *int64
+	}{
+		{"QUOTA_DATABASE_SOFT_LIMIT", &config.Defaults.DatabaseBytes.Soft},
+		{"QUOTA_DATABASE_HARD_LIMIT", &config.Defaults.DatabaseBytes.Hard},
+		{"QUOTA_PACKAGE_LIMIT", &config.Defaults.PackageBytes},
+	}
+	for _, size := range sizes {
+		if value := os.Getenv(size.name); value != "" {
+			parsed, err := parseSize(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid %s: %w", size.name, err)
+			}
+			*size.limit = parsed
+		}
+	}
+	counts := []struct {
+		name  string
+		limit *int64
+	}{
+		{"QUOTA_EVENTS_SOFT_LIMIT", &config.Defaults.Events.Soft},
+		{"QUOTA_EVENTS_HARD_LIMIT", &config.Defaults.Events.Hard},
+	}
+	for _, count := range counts {
+		if value := os.Getenv(count.name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				return config, fmt.Errorf("invalid %s %q: expected a non-negative number", count.name, value)
+			}
+			*count.limit = parsed
+		}
+	}
+	if value := os.Getenv("QUOTA_CHECK_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return config, fmt.Errorf("invalid QUOTA_CHECK_INTERVAL %q: expected a positive duration", value)
+		}
+		config.Interval = interval
+	}
+	if err := config.Defaults.Validate(); err != nil {
+		return config, fmt.Errorf("invalid default quota: %w", err)
+	}
+	return config, nil
+}
+
+// ParsePackageQuota returns the limits a package's manifest sets. A nil
+// quota sets none.
+func ParsePackageQuota(quota *types.PackageQuota) (Limits, error) {
+	var limits Limits
+	if quota == nil {
+		return limits, nil
+	}
+	var err error
+	if quota.DatabaseSoftLimit != "" {
+		if limits.DatabaseBytes.Soft, err = parseSize(quota.DatabaseSoftLimit); err != nil {
+			return limits, fmt.Errorf("invalid quota databaseSoftLimit: %w", err)
+		}
+	}
+	if quota.DatabaseHardLimit != "" {
+		if limits.DatabaseBytes.Hard, err = parseSize(quota.DatabaseHardLimit); err != nil {
+			return limits, fmt.Errorf("invalid quota databaseHardLimit: %w", err)
+		}
+	}
+	limits.Events = Limit{Soft: quota.EventsSoftLimit, Hard: quota.EventsHardLimit}
+	if err := limits.Validate(); err != nil {
+		return limits, fmt.Errorf("invalid quota: %w", err)
+	}
+	return limits, nil
+}
+
+// parseSize parses a byte count such as "512MiB", see diskspace.ParseSize.
+func parseSize(value string) (int64, error) {
+	size, err := diskspace.ParseSize(value)
+	if err != nil {
+		return 0, err
+	}
+	if size > 1<<62 {
+		return 0, fmt.Errorf("size %q is too large", value)
+	}
+	return int64(size), nil
+}
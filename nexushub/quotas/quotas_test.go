@@ -0,0 +1,52 @@
+package quotas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	config, err := ConfigFromEnv()
+	if err != nil || config.Defaults != (Limits{}) || config.Interval != DefaultCheckInterval {
+		t.Errorf("Expected no limits by default, got %+v, %v", config, err)
+	}
+
+	t.Setenv("QUOTA_DATABASE_SOFT_LIMIT", "1KiB")
+	t.Setenv("QUOTA_DATABASE_HARD_LIMIT", "2KiB")
+	t.Setenv("QUOTA_EVENTS_HARD_LIMIT", "1000")
+	t.Setenv("QUOTA_PACKAGE_LIMIT", "1MiB")
+	t.Setenv("QUOTA_CHECK_INTERVAL", "30s")
+	config, err = ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Limits{DatabaseBytes: Limit{Soft: 1024, Hard: 2048}, Events: Limit{Hard: 1000}, PackageBytes: 1 << 20}
+	if config.Defaults != expected || config.Interval != 30*time.Second {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	t.Setenv("QUOTA_DATABASE_HARD_LIMIT", "512")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("Expected an error for a hard limit below the soft limit")
+	}
+	t.Setenv("QUOTA_DATABASE_HARD_LIMIT", "")
+	t.Setenv("QUOTA_EVENTS_SOFT_LIMIT", "-1")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("Expected an error for a negative event limit")
+	}
+}
+
+func TestParsePackageQuota(t *testing.T) {
+	limits, err := ParsePackageQuota(&types.PackageQuota{DatabaseHardLimit: "1MiB", EventsSoftLimit: 100})
+	if err != nil || limits != (Limits{DatabaseBytes: Limit{Hard: 1 << 20}, Events: Limit{Soft: 100}}) {
+		t.Errorf("Unexpected limits %+v, %v", limits, err)
+	}
+	if limits, err := ParsePackageQuota(nil); err != nil || limits != (Limits{}) {
+		t.Errorf("Expected no limits without a quota, got %+v, %v", limits, err)
+	}
+	if _, err := ParsePackageQuota(&types.PackageQuota{DatabaseSoftLimit: "lots"}); err == nil {
+		t.Error("Expected an error for an invalid size")
+	}
+}
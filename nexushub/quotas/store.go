@@ -0,0 +1,102 @@
+package quotas
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const overrideSchema = `
+CREATE TABLE IF NOT EXISTS quota_overrides_v1 (
+	instance_id TEXT PRIMARY KEY,
+	database_soft INTEGER NOT NULL,
+	database_hard INTEGER NOT NULL,
+	events_soft INTEGER NOT NULL,
+	events_hard INTEGER NOT NULL,
+	package_bytes INTEGER NOT NULL
+);
+`
+
+const setOverrideSql = `
+INSERT OR REPLACE INTO quota_overrides_v1 (instance_id, database_soft, database_hard, events_soft, events_hard, package_bytes)
+VALUES ($1, $2, $3, $4, $5, $6);
+`
+
+const getOverrideSql = `
+SELECT database_soft, database_hard, events_soft, events_hard, package_bytes
+FROM quota_overrides_v1 WHERE instance_id = $1;
+`
+
+const getOverridesSql = `
+SELECT instance_id, database_soft, database_hard, events_soft, events_hard, package_bytes
+FROM quota_overrides_v1;
+`
+
+const deleteOverrideSql = `
+DELETE FROM quota_overrides_v1 WHERE instance_id = $1;
+`
+
+// Store keeps the limits admins set for single instances, which take
+// precedence over the package's and the hub's.
+type Store struct {
+	db *sqlx.DB
+}
+
+// NewStore creates the overrides table if needed.
+func NewStore(db *sqlx.DB) (*Store, error) {
+	if _, err := db.Exec(overrideSchema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Set replaces the instance's override. Limits left at zero are inherited.
+func (s *Store) Set(instanceID string, limits Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(setOverrideSql, instanceID, limits.DatabaseBytes.Soft, limits.DatabaseBytes.Hard,
+		limits.Events.Soft, limits.Events.Hard, limits.PackageBytes)
+	return err
+}
+
+// Get returns the instance's override, or nil if it has none.
+func (s *Store) Get(instanceID string) (*Limits, error) {
+	var limits Limits
+	err := s.db.QueryRow(getOverrideSql, instanceID).Scan(&limits.DatabaseBytes.Soft, &limits.DatabaseBytes.Hard,
+		&limits.Events.Soft, &limits.Events.Hard, &limits.PackageBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+// All returns every override by instance ID.
+func (s *Store) All() (map[string]Limits, error) {
+	rows, err := s.db.Query(getOverridesSql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := make(map[string]Limits)
+	for rows.Next() {
+		var instanceID string
+		var limits Limits
+		if err := rows.Scan(&instanceID, &limits.DatabaseBytes.Soft, &limits.DatabaseBytes.Hard,
+			&limits.Events.Soft, &limits.Events.Hard, &limits.PackageBytes); err != nil {
+			return nil, err
+		}
+		overrides[instanceID] = limits
+	}
+	return overrides, rows.Err()
+}
+
+// Delete removes the instance's override, if any.
+func (s *Store) Delete(instanceID string) error {
+	_, err := s.db.Exec(deleteOverrideSql, instanceID)
+	return err
+}
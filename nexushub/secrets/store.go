@@ -0,0 +1,181 @@
+// Package secrets manages the internal secret used to authorize cross-service
+// requests between NexusHub and the applications it runs.
+//
+// The secret rotates periodically (or on demand). After a rotation the
+// previous secret remains valid for a grace window so that requests already
+// in flight, or issued by a child that has not yet re-read its secret file,
+// are not rejected.
+package secrets
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+)
+
+const (
+	// DefaultGracePeriod is how long the previous secret is still accepted
+	// after a rotation.
+	DefaultGracePeriod = 5 * time.Minute
+	// DefaultRotationInterval is how often the secret is rotated when no
+	// interval is configured.
+	DefaultRotationInterval = 24 * time.Hour
+)
+
+// Config controls how the internal secret is rotated.
+type Config struct {
+	// GracePeriod is how long the previous secret is still accepted after a
+	// rotation. It should outlast the longest request made with a secret
+	// and the time a child takes to pick up a new one.
+	GracePeriod time.Duration
+	// RotationInterval is how often the secret rotates on its own. Zero
+	// disables scheduled rotation; the secret can still be rotated on demand.
+	RotationInterval time.Duration
+}
+
+// ConfigFromEnv reads the rotation settings from SECRET_GRACE_PERIOD and
+// SECRET_ROTATION_INTERVAL, given as Go durations (e.g. "10m", "12h"). A
+// rotation interval of "0" or "off" disables scheduled rotation. Unset
+// variables take the defaults.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		GracePeriod:      DefaultGracePeriod,
+		RotationInterval: DefaultRotationInterval,
+	}
+	if value := os.Getenv("SECRET_GRACE_PERIOD"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace <= 0 {
+			return Config{}, fmt.Errorf("invalid SECRET_GRACE_PERIOD %q: expected a positive duration", value)
+		}
+		config.GracePeriod = grace
+	}
+	if value := os.Getenv("SECRET_ROTATION_INTERVAL"); value != "" {
+		if value == "off" {
+			value = "0"
+		}
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return Config{}, fmt.Errorf("invalid SECRET_ROTATION_INTERVAL %q: expected a duration or \"off\"", value)
+		}
+		if interval > 0 && interval <= config.GracePeriod {
+			return Config{}, fmt.Errorf("SECRET_ROTATION_INTERVAL (%v) must be longer than the grace period (%v)", interval, config.GracePeriod)
+		}
+		config.RotationInterval = interval
+	}
+	return config, nil
+}
+
+// RotateCallback is called with the new secret after every rotation.
+type RotateCallback func(current string)
+
+// Store holds the current and previous internal secrets.
+type Store struct {
+	mu          sync.RWMutex
+	current     string
+	previous    string
+	rotatedAt   time.Time
+	gracePeriod time.Duration
+	callbacks   []RotateCallback
+	auditLogger *audit.Logger
+	now         func() time.Time
+}
+
+// NewStore creates a store with a freshly generated secret.
+func NewStore(gracePeriod time.Duration) *Store {
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+	return &Store{
+		current:     uuid.New().String(),
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+	}
+}
+
+// SetAuditLogger configures the audit logger used to record rotations.
+func (s *Store) SetAuditLogger(logger *audit.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditLogger = logger
+}
+
+// OnRotate registers a callback that is invoked after every rotation.
+func (s *Store) OnRotate(callback RotateCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callbacks = append(s.callbacks, callback)
+}
+
+// Current returns the secret that should be used for new requests.
+func (s *Store) Current() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Validate reports whether token matches the current secret, or the previous
+// secret if the rotation happened within the grace period.
+func (s *Store) Validate(token string) bool {
+	if token == "" {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.current)) == 1 {
+		return true
+	}
+	if s.previous == "" || s.now().Sub(s.rotatedAt) > s.gracePeriod {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.previous)) == 1
+}
+
+// Rotate generates a new secret, demoting the current one to previous, and
+// notifies all registered callbacks. The reason is recorded in the audit log.
+func (s *Store) Rotate(reason string) string {
+	s.mu.Lock()
+	s.previous = s.current
+	s.current = uuid.New().String()
+	s.rotatedAt = s.now()
+	current := s.current
+	callbacks := append([]RotateCallback(nil), s.callbacks...)
+	auditLogger := s.auditLogger
+	s.mu.Unlock()
+
+	slog.Info("Rotated internal secret", "reason", reason)
+	if auditLogger != nil {
+		if err := auditLogger.LogInternalSecretRotation(reason); err != nil {
+			slog.Error("Failed to audit internal secret rotation", "error", err)
+		}
+	}
+	for _, callback := range callbacks {
+		callback(current)
+	}
+	return current
+}
+
+// RunRotation rotates the secret every interval until the context is
+// cancelled. It blocks, so callers should run it in a goroutine. A zero
+// interval disables scheduled rotation and returns immediately.
+func (s *Store) RunRotation(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Rotate("scheduled")
+		}
+	}
+}
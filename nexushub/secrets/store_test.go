@@ -0,0 +1,137 @@
+package secrets
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+)
+
+func newTestStore(t *testing.T, grace time.Duration) (*Store, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewStore(grace)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestValidateCurrentSecret(t *testing.T) {
+	store, _ := newTestStore(t, time.Minute)
+
+	if !store.Validate(store.Current()) {
+		t.Error("Expected current secret to be valid")
+	}
+	if store.Validate("") {
+		t.Error("Expected empty token to be invalid")
+	}
+	if store.Validate("not-the-secret") {
+		t.Error("Expected unknown token to be invalid")
+	}
+}
+
+func TestRotationGraceWindow(t *testing.T) {
+	store, now := newTestStore(t, time.Minute)
+
+	old := store.Current()
+	current := store.Rotate("test")
+	if current == old {
+		t.Fatal("Expected rotation to produce a new secret")
+	}
+	if store.Current() != current {
+		t.Errorf("Expected Current() to return %q, got %q", current, store.Current())
+	}
+
+	// A request signed with the old secret just after rotation is accepted
+	*now = now.Add(30 * time.Second)
+	if !store.Validate(old) {
+		t.Error("Expected previous secret to be valid during grace window")
+	}
+	if !store.Validate(current) {
+		t.Error("Expected current secret to be valid")
+	}
+
+	// ...but rejected once the grace window has passed
+	*now = now.Add(31 * time.Second)
+	if store.Validate(old) {
+		t.Error("Expected previous secret to be rejected after grace window")
+	}
+	if !store.Validate(current) {
+		t.Error("Expected current secret to remain valid")
+	}
+}
+
+func TestRotationDropsOlderSecrets(t *testing.T) {
+	store, _ := newTestStore(t, time.Hour)
+
+	first := store.Current()
+	second := store.Rotate("test")
+	store.Rotate("test")
+
+	if store.Validate(first) {
+		t.Error("Expected secret from two rotations ago to be rejected")
+	}
+	if !store.Validate(second) {
+		t.Error("Expected previous secret to be valid during grace window")
+	}
+}
+
+func TestRotateCallbacksAndAudit(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "audit.db"))
+	defer db.Close()
+	auditLogger, err := audit.NewLogger(db)
+	if err != nil {
+		t.Fatalf("NewLogger returned error: %v", err)
+	}
+
+	store, _ := newTestStore(t, time.Minute)
+	store.SetAuditLogger(auditLogger)
+
+	var received []string
+	store.OnRotate(func(current string) {
+		received = append(received, current)
+	})
+
+	current := store.Rotate("manual")
+	if len(received) != 1 || received[0] != current {
+		t.Errorf("Expected callback with %q, got %v", current, received)
+	}
+
+	events, err := auditLogger.GetEventsByType(audit.EventInternalSecretRotate, 10)
+	if err != nil {
+		t.Fatalf("GetEventsByType returned error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 rotation audit event, got %d", len(events))
+	}
+	if events[0].AccessTokenFingerprint != "manual" {
+		t.Errorf("Expected rotation reason 'manual', got %q", events[0].AccessTokenFingerprint)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	config, err := ConfigFromEnv()
+	if err != nil || config.GracePeriod != DefaultGracePeriod || config.RotationInterval != DefaultRotationInterval {
+		t.Errorf("Expected defaults, got %+v (%v)", config, err)
+	}
+
+	t.Setenv("SECRET_GRACE_PERIOD", "10m")
+	t.Setenv("SECRET_ROTATION_INTERVAL", "6h")
+	config, err = ConfigFromEnv()
+	if err != nil || config.GracePeriod != 10*time.Minute || config.RotationInterval != 6*time.Hour {
+		t.Errorf("Expected configured values, got %+v (%v)", config, err)
+	}
+
+	t.Setenv("SECRET_ROTATION_INTERVAL", "off")
+	if config, err = ConfigFromEnv(); err != nil || config.RotationInterval != 0 {
+		t.Errorf("Expected rotation to be disabled, got %+v (%v)", config, err)
+	}
+
+	for _, interval := range []string{"daily", "-1h", "5m"} {
+		t.Setenv("SECRET_ROTATION_INTERVAL", interval)
+		if _, err := ConfigFromEnv(); err == nil {
+			t.Errorf("Expected an error for rotation interval %q", interval)
+		}
+	}
+}
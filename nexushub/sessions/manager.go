@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
 	"github.com/tomyedwab/yesterday/nexushub/types"
 )
 
@@ -28,6 +29,8 @@ type SessionManager struct {
 	accessExpiry       time.Duration // How long access tokens are valid
 	sessionExpiry      time.Duration // How long sessions are valid
 	sessionReuseExpiry time.Duration // How long a session is valid after an access token is issued
+	expiryLeeway       time.Duration // How long past its expiry a session is still accepted
+	clock              clock.Clock
 }
 
 // NewManager creates and initializes a new SessionManager.
@@ -45,6 +48,8 @@ func NewManager(db *sqlx.DB, accessTokenExpiry, sessionExpiry, sessionReuseExpir
 		accessExpiry:       accessTokenExpiry,
 		sessionExpiry:      sessionExpiry,
 		sessionReuseExpiry: sessionReuseExpiry,
+		expiryLeeway:       clock.DefaultSkewLeeway,
+		clock:              clock.Real,
 	}
 
 	log.Printf("SessionManager initialized")
@@ -52,8 +57,20 @@ func NewManager(db *sqlx.DB, accessTokenExpiry, sessionExpiry, sessionReuseExpir
 	return m, nil
 }
 
+// SetExpiryLeeway sets how long past its expiry a session is still accepted,
+// so that a small backwards step of the wall clock on another host or a
+// forwards step on this one doesn't log users out.
+func (m *SessionManager) SetExpiryLeeway(leeway time.Duration) {
+	m.expiryLeeway = leeway
+}
+
+// SetClock replaces the clock sessions are timed with, for tests.
+func (m *SessionManager) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
 func (m *SessionManager) CreateSession(userID int) (*Session, error) {
-	session, err := NewSession(userID, m.sessionExpiry)
+	session, err := newSessionAt(userID, m.sessionExpiry, m.clock.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -75,28 +92,40 @@ func (m *SessionManager) GetSessionByRefreshToken(refreshToken string) (*Session
 	return session, nil
 }
 
+// GetSessionsForUser returns a user's sessions, oldest first.
+func (m *SessionManager) GetSessionsForUser(userID int) ([]Session, error) {
+	return DBGetSessionsForUser(m.db, userID)
+}
+
+// DeleteSession ends a single session, e.g. when the user logs out on one
+// device.
+func (m *SessionManager) DeleteSession(session *Session) error {
+	return session.DBDelete(m.db)
+}
+
 func (m *SessionManager) DeleteSessionsForUser(userID int) error {
 	return DBDeleteSessionsForUser(m.db, userID)
 }
 
 // Delete sessions that have been inactive for a while
 func (m *SessionManager) DeleteExpiredSessions() error {
-	return DBDeleteExpiredSessions(m.db, m.sessionExpiry)
+	return DBDeleteExpiredSessions(m.db, m.clock.Now().Add(-m.expiryLeeway))
 }
 
 // GetAccessToken creates a new access token which is stored in-memory in
 // NexusHub, and rotates the refresh token in the database.
 func (m *SessionManager) CreateAccessToken(session *Session) (*types.AccessTokenResponse, error) {
-	if time.Since(time.Unix(int64(session.ExpiresAt), 0)) > 0 {
+	now := m.clock.Now()
+	if now.Sub(time.Unix(session.ExpiresAt.Int64(), 0)) > m.expiryLeeway {
 		session.DBDelete(m.db)
 		return nil, ErrSessionExpired
 	}
 
 	// Calculate expiry time
-	expiresAt := time.Now().UTC().Add(m.accessExpiry).Unix()
+	expiresAt := now.UTC().Add(m.accessExpiry).Unix()
 
 	// Update the session with the new refresh token
-	refreshToken, err := session.DBUpdateRefreshToken(m.db, m.sessionReuseExpiry, m.sessionExpiry)
+	refreshToken, err := session.DBUpdateRefreshToken(m.db, now, m.sessionReuseExpiry, m.sessionExpiry)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update session with new refresh token: %w", err)
 	}
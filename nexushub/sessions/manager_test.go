@@ -0,0 +1,67 @@
+package sessions
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/clock"
+)
+
+func newTestManager(t *testing.T, fake *clock.Fake) *SessionManager {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "sessions.db"))
+	t.Cleanup(func() { db.Close() })
+	m, err := NewManager(db, 15*time.Minute, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetClock(fake)
+	return m
+}
+
+func TestSessionExpiryToleratesClockStep(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	m := newTestManager(t, fake)
+	session, err := m.CreateSession(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wall clock stepping just past the expiry is within the leeway
+	fake.Step(time.Hour + clock.DefaultSkewLeeway/2)
+	if _, err := m.CreateAccessToken(session); err != nil {
+		t.Errorf("Expected a session within the expiry leeway to be accepted, got %v", err)
+	}
+	if err := m.DeleteExpiredSessions(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetSessionByRefreshToken(session.RefreshToken); err != nil {
+		t.Errorf("Expected a session within the expiry leeway to be kept, got %v", err)
+	}
+
+	// The old refresh token was valid for another minute
+	fake.Step(time.Minute + clock.DefaultSkewLeeway)
+	if _, err := m.CreateAccessToken(session); err != ErrSessionExpired {
+		t.Errorf("Expected a session past the expiry leeway to expire, got %v", err)
+	}
+}
+
+func TestDeleteExpiredSessionsUsesLeeway(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	m := newTestManager(t, fake)
+	m.SetExpiryLeeway(0)
+	session, err := m.CreateSession(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Step(time.Hour + time.Minute)
+	if err := m.DeleteExpiredSessions(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetSessionByRefreshToken(session.RefreshToken); err == nil {
+		t.Error("Expected the expired session to be deleted")
+	}
+}
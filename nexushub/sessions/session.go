@@ -74,11 +74,17 @@ func generateRandomID(length int) (string, error) {
 
 // NewSession creates a new session instance with a unique ID.
 func NewSession(userID int, sessionExpiry time.Duration) (*Session, error) {
+	return newSessionAt(userID, sessionExpiry, time.Now())
+}
+
+// newSessionAt creates a session as of now. Times are stored as UTC Unix
+// seconds.
+func newSessionAt(userID int, sessionExpiry time.Duration, now time.Time) (*Session, error) {
 	refreshToken, err := generateRandomID(32)
 	if err != nil {
 		return nil, err
 	}
-	now := time.Now().UTC()
+	now = now.UTC()
 	return &Session{
 		RefreshToken: refreshToken,
 		UserID:       userID,
@@ -116,9 +122,9 @@ func (s *Session) DBCreate(db *sqlx.DB) error {
 	return err
 }
 
-func (s *Session) DBUpdateRefreshToken(db *sqlx.DB, oldSessionExpiry, newSessionExpiry time.Duration) (string, error) {
+func (s *Session) DBUpdateRefreshToken(db *sqlx.DB, now time.Time, oldSessionExpiry, newSessionExpiry time.Duration) (string, error) {
 	fmt.Printf("Updating refresh token for user %d\n", s.UserID)
-	newSession, err := NewSession(s.UserID, newSessionExpiry)
+	newSession, err := newSessionAt(s.UserID, newSessionExpiry, now)
 	if err != nil {
 		return "", err
 	}
@@ -127,7 +133,7 @@ func (s *Session) DBUpdateRefreshToken(db *sqlx.DB, oldSessionExpiry, newSession
 		return "", err
 	}
 
-	s.ExpiresAt = FlexibleInt64(time.Now().UTC().Add(oldSessionExpiry).Unix())
+	s.ExpiresAt = FlexibleInt64(now.UTC().Add(oldSessionExpiry).Unix())
 	_, err = db.Exec("UPDATE sessions SET expires_at = $1 WHERE refresh_token = $2", s.ExpiresAt, s.RefreshToken)
 
 	return newSession.RefreshToken, err
@@ -138,9 +144,10 @@ func (s *Session) DBDelete(db *sqlx.DB) error {
 	return err
 }
 
-func DBDeleteExpiredSessions(db *sqlx.DB, sessionExpiry time.Duration) error {
-	// Delete sessions that have expired
-	_, err := db.Exec("DELETE FROM sessions WHERE expires_at < UNIXEPOCH()")
+// DBDeleteExpiredSessions deletes sessions that expired before the given
+// time.
+func DBDeleteExpiredSessions(db *sqlx.DB, before time.Time) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE expires_at < $1", before.UTC().Unix())
 	if err != nil {
 		return err
 	}
@@ -152,3 +159,10 @@ func DBDeleteSessionsForUser(db *sqlx.DB, userID int) error {
 	_, err := db.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
 	return err
 }
+
+// DBGetSessionsForUser returns a user's sessions, oldest first.
+func DBGetSessionsForUser(db *sqlx.DB, userID int) ([]Session, error) {
+	var sessions []Session
+	err := db.Select(&sessions, "SELECT * FROM sessions WHERE user_id = $1 ORDER BY created_at", userID)
+	return sessions, err
+}
@@ -0,0 +1,258 @@
+// Package traces keeps an index of requests served by the proxy by their
+// trace IDs: a sample of all requests, and every request that failed. Each
+// entry is the proxy's record of the request along with where the output of
+// the instance that served it stood, so its log lines can be found again.
+package traces
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+const (
+	// DefaultSampleRate is the fraction of successful requests indexed when
+	// TRACE_SAMPLE_RATE is unset.
+	DefaultSampleRate = 0.01
+	// DefaultErrorSampleRate is the fraction of failed requests indexed
+	// when TRACE_ERROR_SAMPLE_RATE is unset.
+	DefaultErrorSampleRate = 1.0
+	// DefaultRetention is how long entries are kept when TRACE_RETENTION is
+	// unset.
+	DefaultRetention = 7 * 24 * time.Hour
+)
+
+// MaxErrorLength is the longest error response body kept in an entry.
+const MaxErrorLength = 1024
+
+// ErrNotFound is returned by Get for a trace ID that is not in the index.
+var ErrNotFound = errors.New("trace not found")
+
+const traceSchema = `
+CREATE TABLE IF NOT EXISTS traces_v1 (
+	trace_id TEXT PRIMARY KEY,
+	instance_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	method TEXT NOT NULL,
+	host TEXT NOT NULL,
+	path TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	started_at INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL,
+	error TEXT NOT NULL,
+	log_pid INTEGER NOT NULL,
+	log_id INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_traces_v1_started_at ON traces_v1(started_at);
+`
+
+const recordTraceSql = `
+INSERT OR REPLACE INTO traces_v1 (trace_id, instance_id, user_id, method, host, path, status, started_at, finished_at, error, log_pid, log_id)
+VALUES (:trace_id, :instance_id, :user_id, :method, :host, :path, :status, :started_at, :finished_at, :error, :log_pid, :log_id);
+`
+
+const getTraceSql = `
+SELECT trace_id, instance_id, user_id, method, host, path, status, started_at, finished_at, error, log_pid, log_id
+FROM traces_v1 WHERE trace_id = $1;
+`
+
+const deleteExpiredTracesSql = `
+DELETE FROM traces_v1 WHERE started_at < $1;
+`
+
+// Record is the proxy's record of a request.
+type Record struct {
+	TraceID string `json:"traceId"`
+	// InstanceID is the application instance the request was for, if any
+	InstanceID string    `json:"instanceId,omitempty"`
+	UserID     int       `json:"userId,omitempty"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Error is the start of the response body of a failed request
+	Error string `json:"error,omitempty"`
+	// LogPosition is where the instance's output stood when the request
+	// was forwarded to it
+	LogPosition processes.LogPosition `json:"logPosition"`
+}
+
+type traceRow struct {
+	TraceID    string `db:"trace_id"`
+	InstanceID string `db:"instance_id"`
+	UserID     int    `db:"user_id"`
+	Method     string `db:"method"`
+	Host       string `db:"host"`
+	Path       string `db:"path"`
+	Status     int    `db:"status"`
+	StartedAt  int64  `db:"started_at"`
+	FinishedAt int64  `db:"finished_at"`
+	Error      string `db:"error"`
+	LogPID     int    `db:"log_pid"`
+	LogID      int64  `db:"log_id"`
+}
+
+// Config is which requests are indexed and how long they are kept.
+type Config struct {
+	// SampleRate and ErrorSampleRate are the fractions of successful and
+	// failed requests indexed, from 0 to 1. Requests fail with a status of
+	// 500 or more.
+	SampleRate      float64
+	ErrorSampleRate float64
+	Retention       time.Duration
+}
+
+// ConfigFromEnv reads the configuration from the environment:
+//
+//	TRACE_SAMPLE_RATE       fraction of successful requests indexed, e.g. "0.01"
+//	TRACE_ERROR_SAMPLE_RATE fraction of failed requests indexed, e.g. "1"
+//	TRACE_RETENTION         how long entries are kept, e.g. "168h"
+func ConfigFromEnv() (Config, error) {
+	config := Config{SampleRate: DefaultSampleRate, ErrorSampleRate: DefaultErrorSampleRate, Retention: DefaultRetention}
+	for _, setting := range []struct {
+		name  string
+		value *float64
+	}{
+		{"TRACE_SAMPLE_RATE", &config.SampleRate},
+		{"TRACE_ERROR_SAMPLE_RATE", &config.ErrorSampleRate},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return Config{}, fmt.Errorf("invalid %s %q: expected a number from 0 to 1", setting.name, value)
+		}
+		*setting.value = rate
+	}
+	if value := os.Getenv("TRACE_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention <= 0 {
+			return Config{}, fmt.Errorf("invalid TRACE_RETENTION %q: expected a positive duration", value)
+		}
+		config.Retention = retention
+	}
+	return config, nil
+}
+
+// Enabled reports whether any requests are indexed.
+func (c Config) Enabled() bool {
+	return c.SampleRate > 0 || c.ErrorSampleRate > 0
+}
+
+// Sample decides whether to index a request that was answered with the
+// given status.
+func (c Config) Sample(status int) bool {
+	return c.sample(status, rand.Float64())
+}
+
+// sample is Sample for a uniform random number from [0, 1).
+func (c Config) sample(status int, random float64) bool {
+	if status >= 500 {
+		return random < c.ErrorSampleRate
+	}
+	return random < c.SampleRate
+}
+
+// Store keeps the index in a table of the hub database.
+type Store struct {
+	db        *sqlx.DB
+	retention time.Duration
+}
+
+// NewStore creates the index table if needed. Entries are kept for the
+// retention period after their request started.
+func NewStore(db *sqlx.DB, retention time.Duration) (*Store, error) {
+	if _, err := db.Exec(traceSchema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db, retention: retention}, nil
+}
+
+// Record adds a request to the index, replacing any entry with its trace
+// ID. The error is cut to MaxErrorLength bytes.
+func (s *Store) Record(record Record) error {
+	if len(record.Error) > MaxErrorLength {
+		record.Error = record.Error[:MaxErrorLength]
+	}
+	_, err := s.db.NamedExec(recordTraceSql, traceRow{
+		TraceID:    record.TraceID,
+		InstanceID: record.InstanceID,
+		UserID:     record.UserID,
+		Method:     record.Method,
+		Host:       record.Host,
+		Path:       record.Path,
+		Status:     record.Status,
+		StartedAt:  record.StartedAt.UnixMilli(),
+		FinishedAt: record.FinishedAt.UnixMilli(),
+		Error:      record.Error,
+		LogPID:     record.LogPosition.PID,
+		LogID:      record.LogPosition.ID,
+	})
+	return err
+}
+
+// Get returns the entry of a trace ID, or ErrNotFound if the request was
+// not indexed or its entry has expired.
+func (s *Store) Get(traceID string) (*Record, error) {
+	var row traceRow
+	err := s.db.Get(&row, getTraceSql, traceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		TraceID:     row.TraceID,
+		InstanceID:  row.InstanceID,
+		UserID:      row.UserID,
+		Method:      row.Method,
+		Host:        row.Host,
+		Path:        row.Path,
+		Status:      row.Status,
+		StartedAt:   time.UnixMilli(row.StartedAt).UTC(),
+		FinishedAt:  time.UnixMilli(row.FinishedAt).UTC(),
+		Error:       row.Error,
+		LogPosition: processes.LogPosition{PID: row.LogPID, ID: row.LogID},
+	}, nil
+}
+
+// DeleteExpired removes the entries of requests that started before the
+// retention period, returning how many were removed.
+func (s *Store) DeleteExpired(now time.Time) (int64, error) {
+	result, err := s.db.Exec(deleteExpiredTracesSql, now.Add(-s.retention).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RunCleanup deletes expired entries every interval until the context is
+// cancelled. It blocks, so callers should run it in a goroutine.
+func (s *Store) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(time.Now()); err != nil {
+				slog.Error("Failed to delete expired traces", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package traces
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+)
+
+func setupTestStore(t *testing.T, retention time.Duration) *Store {
+	db := sqlx.MustConnect("sqlite3", path.Join(t.TempDir(), "traces.db"))
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(db, retention)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	return store
+}
+
+func TestRecordAndGet(t *testing.T) {
+	store := setupTestStore(t, DefaultRetention)
+	start := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	record := Record{
+		TraceID:     "trace-1",
+		InstanceID:  "app",
+		UserID:      7,
+		Method:      "POST",
+		Host:        "hub.example.com",
+		Path:        "/app/api/items",
+		Status:      502,
+		StartedAt:   start,
+		FinishedAt:  start.Add(120 * time.Millisecond),
+		Error:       "Bad Gateway",
+		LogPosition: processes.LogPosition{PID: 100, ID: 42},
+	}
+	if err := store.Record(record); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get("trace-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, record) {
+		t.Errorf("Expected %+v, got %+v", record, *got)
+	}
+	if _, err := store.Get("trace-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Long errors are cut
+	record.Error = strings.Repeat("x", MaxErrorLength+10)
+	if err := store.Record(record); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get("trace-1"); len(got.Error) != MaxErrorLength {
+		t.Errorf("Expected the error to be cut to %d bytes, got %d", MaxErrorLength, len(got.Error))
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	store := setupTestStore(t, time.Hour)
+	now := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	for id, startedAt := range map[string]time.Time{
+		"old": now.Add(-2 * time.Hour),
+		"new": now.Add(-time.Minute),
+	} {
+		if err := store.Record(Record{TraceID: id, StartedAt: startedAt, FinishedAt: startedAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted, err := store.DeleteExpired(now)
+	if err != nil || deleted != 1 {
+		t.Fatalf("Expected one entry deleted, got %d: %v", deleted, err)
+	}
+	if _, err := store.Get("old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the old entry to be deleted, got %v", err)
+	}
+	if _, err := store.Get("new"); err != nil {
+		t.Errorf("Expected the new entry to be kept, got %v", err)
+	}
+}
+
+func TestSample(t *testing.T) {
+	config := Config{SampleRate: 0.1, ErrorSampleRate: 1}
+	for _, test := range []struct {
+		status int
+		random float64
+		sample bool
+	}{
+		{200, 0.05, true},
+		{200, 0.5, false},
+		{404, 0.5, false},
+		{500, 0.99, true},
+		{504, 0.5, true},
+	} {
+		if sample := config.sample(test.status, test.random); sample != test.sample {
+			t.Errorf("Expected sample(%d, %v) to be %v", test.status, test.random, test.sample)
+		}
+	}
+	if (Config{}).Enabled() || (Config{}).Sample(500) {
+		t.Error("Expected rates of 0 to index nothing")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("TRACE_SAMPLE_RATE", "0.5")
+	t.Setenv("TRACE_ERROR_SAMPLE_RATE", "")
+	t.Setenv("TRACE_RETENTION", "24h")
+	config, err := ConfigFromEnv()
+	expected := Config{SampleRate: 0.5, ErrorSampleRate: DefaultErrorSampleRate, Retention: 24 * time.Hour}
+	if err != nil || config != expected {
+		t.Errorf("Expected %+v, got %+v: %v", expected, config, err)
+	}
+	for name, value := range map[string]string{
+		"TRACE_SAMPLE_RATE":       "2",
+		"TRACE_ERROR_SAMPLE_RATE": "all",
+		"TRACE_RETENTION":         "-1h",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Errorf("Expected %s=%s to be rejected", name, value)
+			}
+		})
+	}
+}
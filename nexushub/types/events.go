@@ -15,3 +15,39 @@ type EventPublishData struct {
 	// The event payload
 	Data json.RawMessage `json:"data"`
 }
+
+// DryRunStatus is the outcome of applying an event in a dry run.
+type DryRunStatus string
+
+const (
+	// DryRunAccepted means the handlers applied the event and changed state
+	DryRunAccepted DryRunStatus = "accepted"
+	// DryRunRejected means a handler failed, so the event would not apply
+	DryRunRejected DryRunStatus = "rejected"
+	// DryRunNoop means no handler changed state for the event
+	DryRunNoop DryRunStatus = "noop"
+)
+
+// DryRunOutcome is the result of applying one event in a dry run.
+type DryRunOutcome struct {
+	Status DryRunStatus `json:"status"`
+	// The handler error of a rejected event
+	Error string `json:"error,omitempty"`
+}
+
+// InstanceDryRunOutcome is the result of applying an event to one instance.
+type InstanceDryRunOutcome struct {
+	InstanceID string `json:"instanceId"`
+	DryRunOutcome
+}
+
+// EventDryRunResult is the result of publishing an event in a dry run: it is
+// rejected if any instance rejected it, accepted if any accepted it and
+// otherwise a no-op.
+type EventDryRunResult struct {
+	ClientID string `json:"clientId"`
+	Type     string `json:"type"`
+	DryRunOutcome
+	// The outcome for each running instance subscribed to the event type
+	Instances []InstanceDryRunOutcome `json:"instances"`
+}
@@ -0,0 +1,9 @@
+package types
+
+// LogLevel is the body of an application's /internal/loglevel endpoint and
+// of the hub's /apps/{id}/loglevel, in both directions.
+type LogLevel struct {
+	// Level is a log level spec: a default level optionally followed by
+	// per-module levels, e.g. "info,database=debug,http=warn".
+	Level string `json:"level"`
+}
@@ -5,4 +5,61 @@ type PackageManifest struct {
 	Version       string   `json:"version"`
 	Description   string   `json:"description"`
 	Subscriptions []string `json:"subscriptions"`
+	// Transport is how the hub talks to the app: "tcp" (the default), "unix"
+	// to listen on a unix domain socket instead of a port, or "embedded" to
+	// run the app in the hub's own process, if the hub was built with it.
+	Transport string `json:"transport,omitempty"`
+	// RunSelfTest makes the hub wait for the app's self-tests, reported at
+	// /internal/selftest, to pass before marking an instance running.
+	RunSelfTest bool `json:"runSelfTest,omitempty"`
+	// StaticPath is a directory in the package, e.g. "static", whose files the
+	// proxy serves directly instead of forwarding the request to the app.
+	StaticPath string `json:"staticPath,omitempty"`
+	// HealthCheck changes how the hub decides the app is healthy. Without it
+	// /api/status must answer 200 OK.
+	HealthCheck *PackageHealthCheck `json:"healthCheck,omitempty"`
+	// Warm starts the app's instances with the hub and keeps them running
+	// without traffic, so that no request waits for them to start.
+	Warm bool `json:"warm,omitempty"`
+	// Warmup lists paths, e.g. "/api/items", requested in turn without a
+	// user each time an instance has started and passed its health check,
+	// to fill caches before the first real request.
+	Warmup []string `json:"warmup,omitempty"`
+	// Publishers restricts who may publish the app's event types. It maps an
+	// event type, e.g. "User:Add", to the roles allowed to publish it, or to
+	// ["internal"] to allow only the hub and other apps. Event types that are
+	// not listed can be published by any signed-in user.
+	Publishers map[string][]string `json:"publishers,omitempty"`
+	// Quota replaces the hub's default limits on the resources the app's
+	// instances use. Admins can still override them per instance.
+	Quota *PackageQuota `json:"quota,omitempty"`
+}
+
+// PackageHealthCheck is a package's health check settings. Every field is
+// optional and every condition given must hold for the app to be healthy.
+type PackageHealthCheck struct {
+	// Path is requested instead of /api/status, e.g. "/api/healthz".
+	Path string `json:"path,omitempty"`
+	// ExpectedStatus lists the healthy status codes, by default just 200.
+	ExpectedStatus []int `json:"expectedStatus,omitempty"`
+	// BodyContains is a string the response body must contain.
+	BodyContains string `json:"bodyContains,omitempty"`
+	// JSONField is a dot-separated path, e.g. "db.ok", to a field the JSON
+	// response must have, with a value other than null or false.
+	JSONField string `json:"jsonField,omitempty"`
+	// Timeout is a duration such as "5s" to wait for the response instead of
+	// the hub's default.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// PackageQuota is a package's quota settings. Every field is optional, and
+// the hub's default applies to those left out. Sizes are byte counts such as
+// "2GiB". Reaching a soft limit only warns; reaching a hard limit rejects
+// publishes of the events the instance subscribes to. A package cannot set
+// its own size limit, since that has to be checked before it is read.
+type PackageQuota struct {
+	DatabaseSoftLimit string `json:"databaseSoftLimit,omitempty"`
+	DatabaseHardLimit string `json:"databaseHardLimit,omitempty"`
+	EventsSoftLimit   int64  `json:"eventsSoftLimit,omitempty"`
+	EventsHardLimit   int64  `json:"eventsHardLimit,omitempty"`
 }
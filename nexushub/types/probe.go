@@ -0,0 +1,23 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProbeResult is an application's answer to /internal/probes/{name}, and
+// the hub's answer to /apps/{id}/probe/{name}.
+type ProbeResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Critical probes that keep failing mark the instance degraded
+	Critical  bool   `json:"critical"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+	// Detail is whatever the probe chose to report, e.g. a connection count
+	Detail    json.RawMessage `json:"detail,omitempty"`
+	CheckedAt time.Time       `json:"checkedAt"`
+	// Cached is set by the hub when the result comes from its cache rather
+	// than a fresh run of the probe
+	Cached bool `json:"cached"`
+}
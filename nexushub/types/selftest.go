@@ -0,0 +1,35 @@
+package types
+
+// Self-test report statuses.
+const (
+	SelfTestRunning = "running"
+	SelfTestPassed  = "passed"
+	SelfTestFailed  = "failed"
+)
+
+// SelfTestReport is an application's answer to /internal/selftest.
+type SelfTestReport struct {
+	// Status is SelfTestRunning until every test has run, then
+	// SelfTestPassed or SelfTestFailed.
+	Status string           `json:"status"`
+	Tests  []SelfTestResult `json:"tests"`
+}
+
+// SelfTestResult is the outcome of a single self-test.
+type SelfTestResult struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// FailedTests returns the names of the tests that failed.
+func (r *SelfTestReport) FailedTests() []string {
+	var failed []string
+	for _, test := range r.Tests {
+		if !test.Passed {
+			failed = append(failed, test.Name)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,25 @@
+package types
+
+import "encoding/json"
+
+// UserDataExport is an application's response to the hub's request for a
+// user's records, for the archive assembled by a user data export.
+type UserDataExport struct {
+	UserID  int             `json:"userId"`
+	Records json.RawMessage `json:"records"`
+}
+
+// RedactedEvent is an event whose personal data was replaced with
+// tombstones when its user was forgotten.
+type RedactedEvent struct {
+	ID   int             `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// UserDataForget is an application's response to the hub's request to
+// forget a user. The hub redacts its copy of the events the same way.
+type UserDataForget struct {
+	UserID int             `json:"userId"`
+	Events []RedactedEvent `json:"events"`
+}
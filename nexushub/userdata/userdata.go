@@ -0,0 +1,282 @@
+// Package userdata exports and forgets everything the hub and its
+// applications hold about a user, for data subject access and erasure
+// requests.
+//
+// An export is a single JSON archive of the user's sessions and audit events
+// from the hub and the records of every application that takes part through
+// applib's HandleUserData, including the Admin app's profile and access
+// grants.
+//
+// Forgetting a user deletes their sessions and asks every taking-part
+// application to crypto-shred the user's personal data: the key sealing it
+// in the application's event log is destroyed and its projections rebuilt,
+// leaving tombstones in place of the data. The hub then replaces its own copy
+// of the affected events with the redacted versions.
+package userdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+	"github.com/tomyedwab/yesterday/nexushub/processes"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+// ArchiveFormat is the version of the archive written by Export.
+const ArchiveFormat = 1
+
+// The internal application endpoints, served by applib's HandleUserData.
+const (
+	exportPath = "/internal/user-data/export"
+	forgetPath = "/internal/user-data/forget"
+)
+
+// Instance is a running application instance the hub asks for a user's
+// data.
+type Instance struct {
+	InstanceID string
+	Name       string
+	// BaseURL is the instance's backend URL, see processes.AppInstance.
+	BaseURL string
+}
+
+// Archive is everything the hub and its applications hold about a user.
+type Archive struct {
+	Format       int                  `json:"format"`
+	UserID       int                  `json:"userId"`
+	ExportedAt   time.Time            `json:"exportedAt"`
+	Sessions     []Session            `json:"sessions"`
+	AuditEvents  []AuditEvent         `json:"auditEvents"`
+	Applications []ApplicationRecords `json:"applications"`
+}
+
+// Session is a user's session, without its refresh token.
+type Session struct {
+	RefreshTokenFingerprint string    `json:"refreshTokenFingerprint"`
+	CreatedAt               time.Time `json:"createdAt"`
+	ExpiresAt               time.Time `json:"expiresAt"`
+}
+
+// AuditEvent is an audit log entry about the user.
+type AuditEvent struct {
+	ID                         string    `json:"id"`
+	Type                       string    `json:"type"`
+	Time                       time.Time `json:"time"`
+	RefreshTokenFingerprint    string    `json:"refreshTokenFingerprint,omitempty"`
+	OldRefreshTokenFingerprint string    `json:"oldRefreshTokenFingerprint,omitempty"`
+	NewRefreshTokenFingerprint string    `json:"newRefreshTokenFingerprint,omitempty"`
+	AccessTokenFingerprint     string    `json:"accessTokenFingerprint,omitempty"`
+}
+
+// ApplicationRecords are an application's records about the user, as
+// returned by its extractor.
+type ApplicationRecords struct {
+	InstanceID string          `json:"instanceId"`
+	Name       string          `json:"name"`
+	Records    json.RawMessage `json:"records"`
+}
+
+// ForgetResult reports what was forgotten.
+type ForgetResult struct {
+	UserID int `json:"userId"`
+	// Applications are the instances that forgot the user.
+	Applications []string `json:"applications"`
+	// RedactedEvents is the number of events in the hub's log whose
+	// personal data was replaced with tombstones.
+	RedactedEvents int `json:"redactedEvents"`
+	// Failed maps the instances that could not forget the user to the
+	// error. Forgetting again retries them.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// ErrIncomplete is returned by Forget when some applications failed to
+// forget the user.
+var ErrIncomplete = errors.New("some applications failed to forget the user")
+
+// errNotSupported is returned for an application that does not handle user
+// data.
+var errNotSupported = errors.New("application does not handle user data")
+
+// Service exports and forgets users' data.
+type Service struct {
+	sessions  *sessions.SessionManager
+	audit     *audit.Logger
+	events    *events.EventManager
+	instances func() ([]Instance, error)
+	secret    func() string
+	client    *http.Client
+}
+
+// NewService returns a service gathering data from the given stores and from
+// the instances returned by instances, which are called with the internal
+// secret returned by secret.
+func NewService(
+	sessionManager *sessions.SessionManager,
+	auditLogger *audit.Logger,
+	eventManager *events.EventManager,
+	instances func() ([]Instance, error),
+	secret func() string,
+) *Service {
+	return &Service{
+		sessions:  sessionManager,
+		audit:     auditLogger,
+		events:    eventManager,
+		instances: instances,
+		secret:    secret,
+		client:    processes.BackendClient,
+	}
+}
+
+// Export assembles the archive of a user's data. It fails rather than
+// return an incomplete archive if any application fails to respond. actor
+// identifies who asked for the export in the audit log.
+func (s *Service) Export(ctx context.Context, userID int, actor string) (*Archive, error) {
+	archive := &Archive{
+		Format:       ArchiveFormat,
+		UserID:       userID,
+		ExportedAt:   time.Now().UTC(),
+		Sessions:     []Session{},
+		AuditEvents:  []AuditEvent{},
+		Applications: []ApplicationRecords{},
+	}
+
+	userSessions, err := s.sessions.GetSessionsForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+	for _, session := range userSessions {
+		archive.Sessions = append(archive.Sessions, Session{
+			RefreshTokenFingerprint: session.RefreshTokenFingerprint,
+			CreatedAt:               time.Unix(session.CreatedAt.Int64(), 0).UTC(),
+			ExpiresAt:               time.Unix(session.ExpiresAt.Int64(), 0).UTC(),
+		})
+	}
+
+	auditEvents, err := s.audit.GetEventsByUserID(userID, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit events: %w", err)
+	}
+	for _, event := range auditEvents {
+		archive.AuditEvents = append(archive.AuditEvents, AuditEvent{
+			ID:                         event.ID,
+			Type:                       event.EventType,
+			Time:                       time.Unix(event.Timestamp, 0).UTC(),
+			RefreshTokenFingerprint:    event.RefreshTokenFingerprint,
+			OldRefreshTokenFingerprint: event.OldRefreshTokenFingerprint,
+			NewRefreshTokenFingerprint: event.NewRefreshTokenFingerprint,
+			AccessTokenFingerprint:     event.AccessTokenFingerprint,
+		})
+	}
+
+	instances, err := s.instances()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applications: %w", err)
+	}
+	for _, instance := range instances {
+		var export types.UserDataExport
+		err := s.call(ctx, instance, http.MethodGet, exportPath, userID, &export)
+		if errors.Is(err, errNotSupported) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to export from %s: %w", instance.InstanceID, err)
+		}
+		archive.Applications = append(archive.Applications, ApplicationRecords{
+			InstanceID: instance.InstanceID,
+			Name:       instance.Name,
+			Records:    export.Records,
+		})
+	}
+
+	if err := s.audit.LogUserData(audit.EventUserDataExport, userID, actor); err != nil {
+		return nil, fmt.Errorf("failed to audit export: %w", err)
+	}
+	return archive, nil
+}
+
+// Forget deletes a user's sessions, has every application crypto-shred the
+// user's personal data and redacts the hub's copy of the affected events. If
+// some applications fail it carries on with the others and returns
+// ErrIncomplete with the result; forgetting again is safe. actor identifies
+// who asked for the deletion in the audit log.
+func (s *Service) Forget(ctx context.Context, userID int, actor string) (*ForgetResult, error) {
+	result := &ForgetResult{UserID: userID, Applications: []string{}}
+	if err := s.sessions.DeleteSessionsForUser(userID); err != nil {
+		return nil, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	instances, err := s.instances()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applications: %w", err)
+	}
+	// If applications redact the same event, the last one wins
+	redacted := map[int][]byte{}
+	for _, instance := range instances {
+		var forget types.UserDataForget
+		err := s.call(ctx, instance, http.MethodPost, forgetPath, userID, &forget)
+		if errors.Is(err, errNotSupported) {
+			continue
+		}
+		if err != nil {
+			if result.Failed == nil {
+				result.Failed = map[string]string{}
+			}
+			result.Failed[instance.InstanceID] = err.Error()
+			continue
+		}
+		result.Applications = append(result.Applications, instance.InstanceID)
+		for _, event := range forget.Events {
+			redacted[event.ID] = event.Data
+		}
+	}
+
+	if len(redacted) > 0 {
+		if result.RedactedEvents, err = s.events.RedactEvents(redacted); err != nil {
+			return nil, fmt.Errorf("failed to redact events: %w", err)
+		}
+	}
+	if err := s.audit.LogUserData(audit.EventUserDataForget, userID, actor); err != nil {
+		return nil, fmt.Errorf("failed to audit deletion: %w", err)
+	}
+	if len(result.Failed) > 0 {
+		return result, ErrIncomplete
+	}
+	return result, nil
+}
+
+// call makes a request to an instance's user data endpoint and decodes the
+// response. It returns errNotSupported if the application does not serve the
+// endpoint.
+func (s *Service) call(ctx context.Context, instance Instance, method, path string, userID int, response any) error {
+	target := instance.BaseURL + path + "?" + url.Values{"userId": {strconv.Itoa(userID)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.secret())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotSupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
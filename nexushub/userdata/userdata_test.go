@@ -0,0 +1,168 @@
+package userdata
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tomyedwab/yesterday/nexushub/audit"
+	"github.com/tomyedwab/yesterday/nexushub/events"
+	"github.com/tomyedwab/yesterday/nexushub/sessions"
+	"github.com/tomyedwab/yesterday/nexushub/types"
+)
+
+type testHub struct {
+	service  *Service
+	sessions *sessions.SessionManager
+	audit    *audit.Logger
+	events   *events.EventManager
+}
+
+func newTestHub(t *testing.T, instances ...Instance) *testHub {
+	dir := t.TempDir()
+	open := func(name string) *sqlx.DB {
+		db := sqlx.MustConnect("sqlite3", filepath.Join(dir, name))
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	sessionManager, err := sessions.NewManager(open("sessions.db"), time.Minute, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLogger, err := audit.NewLogger(open("audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventManager, err := events.CreateEventManager(open("events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listInstances := func() ([]Instance, error) { return instances, nil }
+	service := NewService(sessionManager, auditLogger, eventManager, listInstances, func() string { return "secret" })
+	return &testHub{service: service, sessions: sessionManager, audit: auditLogger, events: eventManager}
+}
+
+// fakeApp serves the user data endpoints like applib's HandleUserData, or
+// fails them with status if it is not 200.
+func fakeApp(t *testing.T, status int, records string, redacted []types.RedactedEvent) (Instance, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		calls = append(calls, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		if status != http.StatusOK {
+			http.Error(w, "broken", status)
+			return
+		}
+		switch r.URL.Path {
+		case exportPath:
+			json.NewEncoder(w).Encode(types.UserDataExport{UserID: 5, Records: json.RawMessage(records)})
+		case forgetPath:
+			json.NewEncoder(w).Encode(types.UserDataForget{UserID: 5, Events: redacted})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return Instance{InstanceID: strings.TrimPrefix(server.URL, "http://"), Name: "app", BaseURL: server.URL}, &calls
+}
+
+func TestExportArchiveIsComplete(t *testing.T) {
+	admin, _ := fakeApp(t, http.StatusOK, `{"profile":{"id":5,"username":"alice"},"accessRules":[]}`, nil)
+	notes, _ := fakeApp(t, http.StatusOK, `{"notes":["call mum"]}`, nil)
+	legacy, _ := fakeApp(t, http.StatusNotFound, "", nil)
+	hub := newTestHub(t, admin, legacy, notes)
+
+	session, err := hub.sessions.CreateSession(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.sessions.CreateSession(6)
+	hub.audit.LogLogin(5, session.RefreshToken)
+	hub.audit.LogLogin(6, "other")
+
+	archive, err := hub.service.Export(context.Background(), 5, "user:1")
+	if err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	if archive.Format != ArchiveFormat || archive.UserID != 5 {
+		t.Errorf("Unexpected archive header %+v", archive)
+	}
+	fingerprint := sha256.Sum256([]byte(session.RefreshToken))
+	if len(archive.Sessions) != 1 || archive.Sessions[0].RefreshTokenFingerprint != hex.EncodeToString(fingerprint[:]) {
+		t.Errorf("Expected the user's session, got %+v", archive.Sessions)
+	}
+	if len(archive.AuditEvents) != 1 || archive.AuditEvents[0].Type != string(audit.EventLogin) {
+		t.Errorf("Expected the user's login, got %+v", archive.AuditEvents)
+	}
+	if len(archive.Applications) != 2 || archive.Applications[0].InstanceID != admin.InstanceID ||
+		string(archive.Applications[1].Records) != `{"notes":["call mum"]}` {
+		t.Errorf("Expected the records of the applications handling user data, got %+v", archive.Applications)
+	}
+
+	encoded, _ := json.Marshal(archive)
+	if strings.Contains(string(encoded), session.RefreshToken) {
+		t.Error("Expected the archive not to contain refresh tokens")
+	}
+
+	// The export itself is audited
+	logged, _ := hub.audit.GetEventsByType(audit.EventUserDataExport, 10)
+	if len(logged) != 1 || *logged[0].UserID != 5 || logged[0].AccessTokenFingerprint != "user:1" {
+		t.Errorf("Expected the export to be audited, got %+v", logged)
+	}
+}
+
+func TestExportFailsIfAnApplicationFails(t *testing.T) {
+	broken, _ := fakeApp(t, http.StatusInternalServerError, "", nil)
+	hub := newTestHub(t, broken)
+	if _, err := hub.service.Export(context.Background(), 5, "internal"); err == nil {
+		t.Error("Expected an incomplete export to fail")
+	}
+}
+
+func TestForget(t *testing.T) {
+	hub := newTestHub(t)
+	eventID, err := hub.events.PublishEvent("client-1", "User:Add", []byte(`{"username":"alice","salt":"s"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, adminCalls := fakeApp(t, http.StatusOK, "", []types.RedactedEvent{
+		{ID: eventID, Type: "User:Add", Data: json.RawMessage(`{"salt":"redacted-5","username":"redacted-5"}`)},
+	})
+	broken, _ := fakeApp(t, http.StatusInternalServerError, "", nil)
+	hub.service.instances = func() ([]Instance, error) { return []Instance{admin, broken}, nil }
+	hub.sessions.CreateSession(5)
+
+	result, err := hub.service.Forget(context.Background(), 5, "internal")
+	if !errors.Is(err, ErrIncomplete) || result == nil {
+		t.Fatalf("Expected ErrIncomplete with a result, got %v", err)
+	}
+	if len(result.Applications) != 1 || result.RedactedEvents != 1 || result.Failed[broken.InstanceID] == "" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(*adminCalls) != 1 || (*adminCalls)[0] != "POST "+forgetPath+"?userId=5" {
+		t.Errorf("Unexpected calls to the application: %v", *adminCalls)
+	}
+
+	if remaining, _ := hub.sessions.GetSessionsForUser(5); len(remaining) != 0 {
+		t.Errorf("Expected the user's sessions to be deleted, got %d", len(remaining))
+	}
+	event, err := hub.events.GetEvent(eventID)
+	if err != nil || strings.Contains(string(event.Data), "alice") || !strings.Contains(string(event.Data), "redacted-5") {
+		t.Errorf("Expected the hub's copy of the event to be redacted, got %+v: %v", event, err)
+	}
+	logged, _ := hub.audit.GetEventsByType(audit.EventUserDataForget, 10)
+	if len(logged) != 1 || *logged[0].UserID != 5 {
+		t.Errorf("Expected the deletion to be audited, got %+v", logged)
+	}
+}